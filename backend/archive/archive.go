@@ -21,12 +21,14 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	// Import all the required archivers here
 	_ "github.com/rclone/rclone/backend/archive/squashfs"
+	_ "github.com/rclone/rclone/backend/archive/tar"
 	_ "github.com/rclone/rclone/backend/archive/zip"
 
 	"github.com/rclone/rclone/backend/archive/archiver"
@@ -128,7 +130,12 @@ func (f *Fs) findArchive(remote string) (archive *archive) {
 	archive = findArchive(remote)
 	if archive != nil {
 		f.mu.Lock()
-		f.archives[remote] = archive
+		// Keep the existing archive as it may have changes pending
+		if existing, ok := f.archives[remote]; ok {
+			archive = existing
+		} else {
+			f.archives[remote] = archive
+		}
 		f.mu.Unlock()
 	}
 	return archive
@@ -232,12 +239,30 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 		PartialUploads:          true,
 	}).Fill(ctx, f).Mask(ctx, wrappedFs).WrapsFs(f, wrappedFs)
 
+	// Enable Shutdown always so changed archives get written
+	f.features.Shutdown = f.Shutdown
+
 	if foundArchive != nil {
 		fs.Debugf(f, "Root is an archive")
 		if err != fs.ErrorIsFile {
-			return nil, fmt.Errorf("expecting to find a file at %q", remote)
+			// If the archive doesn't exist (or didn't when the
+			// wrapped remote was cached) then wrap its parent so it
+			// can be created when written to
+			_, listErr := wrappedFs.List(ctx, "")
+			if listErr == nil {
+				return nil, fmt.Errorf("expecting to find a file at %q", remote)
+			}
+			parent, _, splitErr := fspath.Split(remotePath)
+			if splitErr != nil {
+				return nil, splitErr
+			}
+			wrappedFs, err = cache.Get(ctx, parent)
+			if err != nil {
+				return nil, fmt.Errorf("failed to make remote %q to wrap: %w", parent, err)
+			}
+			fs.Debugf(f, "Wrapping parent of archive %q", foundArchive.remote)
 		}
-		return foundArchive.init(ctx, f.f)
+		return foundArchive.init(ctx, wrappedFs)
 	}
 	// Correct root if definitely pointing to a file
 	if err == fs.ErrorIsFile {
@@ -271,7 +296,11 @@ func (f *Fs) Features() *fs.Features {
 
 // Rmdir removes the root directory of the Fs object
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
-	return f.f.Rmdir(ctx, dir)
+	subFs, err := f.findFs(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return subFs.Rmdir(ctx, dir)
 }
 
 // Hashes returns hash.HashNone to indicate remote hashing is unavailable
@@ -281,7 +310,11 @@ func (f *Fs) Hashes() hash.Set {
 
 // Mkdir makes the root directory of the Fs object
 func (f *Fs) Mkdir(ctx context.Context, dir string) error {
-	return f.f.Mkdir(ctx, dir)
+	subFs, err := f.findArchiveFs(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return subFs.Mkdir(ctx, dir)
 }
 
 // Purge all files in the directory
@@ -330,7 +363,11 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 //
 // If it isn't possible then return fs.ErrorCantMove
 func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
-	do := f.f.Features().Move
+	subFs, err := f.findArchiveFs(ctx, parentDir(remote))
+	if err != nil {
+		return nil, err
+	}
+	do := subFs.Features().Move
 	if do == nil {
 		return nil, fs.ErrorCantMove
 	}
@@ -394,12 +431,19 @@ func (f *Fs) DirCacheFlush() {
 }
 
 func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, stream bool, options ...fs.OpenOption) (fs.Object, error) {
+	subFs, err := f.findArchiveFs(ctx, parentDir(src.Remote()))
+	if err != nil {
+		return nil, err
+	}
 	var o fs.Object
-	var err error
 	if stream {
-		o, err = f.f.Features().PutStream(ctx, in, src, options...)
+		do := subFs.Features().PutStream
+		if do == nil {
+			return nil, errors.New("can't PutStream")
+		}
+		o, err = do(ctx, in, src, options...)
 	} else {
-		o, err = f.f.Put(ctx, in, src, options...)
+		o, err = subFs.Put(ctx, in, src, options...)
 	}
 	if err != nil {
 		return nil, err
@@ -450,6 +494,36 @@ func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
 	return do(ctx)
 }
 
+// parentDir returns the parent directory of remote
+func parentDir(remote string) string {
+	dir := path.Dir(remote)
+	if dir == "/" || dir == "." {
+		dir = ""
+	}
+	return dir
+}
+
+// Find the Fs for the directory, looking for an archive in its path
+// even if it hasn't been listed yet.
+//
+// If the archive doesn't exist it will be created when written to.
+func (f *Fs) findArchiveFs(ctx context.Context, dir string) (subFs fs.Fs, err error) {
+	foundArchive := subArchive(dir)
+	if foundArchive == nil {
+		return f.findFs(ctx, dir)
+	}
+	_ = f.findArchive(foundArchive.remote)
+	subFs, err = f.findFs(ctx, dir)
+	if errors.Is(err, fs.ErrorIsDir) {
+		// This is a directory which looks like an archive
+		f.mu.Lock()
+		delete(f.archives, foundArchive.remote)
+		f.mu.Unlock()
+		return f.f, nil
+	}
+	return subFs, err
+}
+
 // Find the Fs for the directory
 func (f *Fs) findFs(ctx context.Context, dir string) (subFs fs.Fs, err error) {
 	f.mu.Lock()
@@ -517,7 +591,7 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 		dir = ""
 	}
 
-	subFs, err := f.findFs(ctx, dir)
+	subFs, err := f.findArchiveFs(ctx, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -536,11 +610,39 @@ func (f *Fs) Precision() time.Duration {
 
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
-func (f *Fs) Shutdown(ctx context.Context) error {
+//
+// This writes any archives which have been changed since they were
+// last written, in order of their remote.
+func (f *Fs) Shutdown(ctx context.Context) (err error) {
+	f.mu.Lock()
+	archives := make([]*archive, 0, len(f.archives))
+	for _, archive := range f.archives {
+		archives = append(archives, archive)
+	}
+	f.mu.Unlock()
+	slices.SortFunc(archives, func(a, b *archive) int {
+		return strings.Compare(a.remote, b.remote)
+	})
+	for _, archive := range archives {
+		archive.mu.Lock()
+		subFs := archive.f
+		archive.mu.Unlock()
+		if subFs == nil {
+			continue
+		}
+		if do := subFs.Features().Shutdown; do != nil {
+			if shutdownErr := do(ctx); shutdownErr != nil {
+				fs.Errorf(subFs, "Failed to shutdown archive: %v", shutdownErr)
+				err = shutdownErr
+			}
+		}
+	}
 	if do := f.f.Features().Shutdown; do != nil {
-		return do(ctx)
+		if shutdownErr := do(ctx); shutdownErr != nil {
+			err = shutdownErr
+		}
 	}
-	return nil
+	return err
 }
 
 // PublicLink generates a public link to the remote path (usually readable by anyone)
@@ -3,9 +3,12 @@
 package archive
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
@@ -13,10 +16,14 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/mholt/archives"
+	"github.com/rclone/rclone/backend/archive/base"
 	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
@@ -219,3 +226,317 @@ func TestArchiveSquashfs(t *testing.T) {
 		run(t, "mksquashfs", input, output)
 	})
 }
+
+// Test creating and reading back some archives
+//
+// Note that this uses rclone and tar as external binaries.
+func TestArchiveTar(t *testing.T) {
+	fstest.Initialise()
+	skipIfNoExe(t, "tar")
+	skipIfNoExe(t, "rclone")
+	for _, test := range []struct {
+		name  string
+		flags string
+		exe   string
+	}{
+		{"test.tar", "-cf", ""},
+		{"test.tar.gz", "-czf", "gzip"},
+		{"test.tar.zst", "--zstd -cf", "zstd"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.exe != "" {
+				skipIfNoExe(t, test.exe)
+			}
+			testArchive(t, test.name, func(t *testing.T, output, input string) {
+				args := append([]string{"tar"}, strings.Fields(test.flags)...)
+				run(t, append(args, output, "-C", input, ".")...)
+			})
+		})
+	}
+}
+
+// read the names and contents of the files in the zip file
+func readZipFile(t *testing.T, zipPath string) map[string]string {
+	zr, err := zip.OpenReader(zipPath)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, zr.Close())
+	}()
+	files := map[string]string{}
+	for _, file := range zr.File {
+		if strings.HasSuffix(file.Name, "/") {
+			files[file.Name] = ""
+			continue
+		}
+		rc, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		files[file.Name] = string(data)
+	}
+	return files
+}
+
+// Test writing to zip archives
+func TestArchiveZipWrite(t *testing.T) {
+	ctx := context.Background()
+	setFlushDelay(t, time.Hour)
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "test.zip")
+	t1 := fstest.Time("2001-02-03T04:05:06Z")
+
+	shutdown := func(f fs.Fs) {
+		do := f.Features().Shutdown
+		require.NotNil(t, do)
+		require.NoError(t, do(ctx))
+	}
+
+	// Opening a missing archive doesn't create it
+	f, err := fs.NewFs(ctx, ":archive:"+zipPath)
+	require.NoError(t, err)
+	shutdown(f)
+	_, err = os.Stat(zipPath)
+	assert.True(t, os.IsNotExist(err), "archive shouldn't be created unless written to")
+
+	// But making its root does
+	emptyPath := filepath.Join(dir, "empty.zip")
+	f, err = fs.NewFs(ctx, ":archive:"+emptyPath)
+	require.NoError(t, err)
+	require.NoError(t, f.Mkdir(ctx, ""))
+	shutdown(f)
+	assert.Equal(t, map[string]string{}, readZipFile(t, emptyPath))
+
+	// Create a new archive
+	f, err = fs.NewFs(ctx, ":archive:"+zipPath)
+	require.NoError(t, err)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "potato.txt", ModTime: t1}, "potato", true)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "dir/sausage.txt", ModTime: t1}, "sausage", true)
+	require.NoError(t, f.Mkdir(ctx, "empty"))
+	_, err = os.Stat(zipPath)
+	assert.True(t, os.IsNotExist(err), "archive shouldn't be written until changes stop")
+	shutdown(f)
+	assert.Equal(t, map[string]string{
+		"potato.txt":      "potato",
+		"dir/":            "",
+		"dir/sausage.txt": "sausage",
+		"empty/":          "",
+	}, readZipFile(t, zipPath))
+
+	// Modify it
+	f, err = fs.NewFs(ctx, ":archive:"+zipPath)
+	require.NoError(t, err)
+	o, err := f.NewObject(ctx, "potato.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	o, err = f.NewObject(ctx, "dir/sausage.txt")
+	require.NoError(t, err)
+	o, err = f.Features().Move(ctx, o, "moved/sausage.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "sausage", fstests.ReadObject(ctx, t, o, -1))
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "beans.txt", ModTime: t1}, "beans", true)
+	require.NoError(t, f.Rmdir(ctx, "empty"))
+	shutdown(f)
+	assert.Equal(t, map[string]string{
+		"beans.txt":         "beans",
+		"dir/":              "",
+		"moved/":            "",
+		"moved/sausage.txt": "sausage",
+	}, readZipFile(t, zipPath))
+
+	// Write into it from the directory above
+	f, err = fs.NewFs(ctx, ":archive:"+dir)
+	require.NoError(t, err)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "test.zip/dir/eggs.txt", ModTime: t1}, "eggs", true)
+	o, err = f.NewObject(ctx, "test.zip/beans.txt")
+	require.NoError(t, err)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "test.zip/beans.txt", ModTime: t1}, "more beans", true)
+	shutdown(f)
+	assert.Equal(t, map[string]string{
+		"beans.txt":         "more beans",
+		"dir/":              "",
+		"dir/eggs.txt":      "eggs",
+		"moved/":            "",
+		"moved/sausage.txt": "sausage",
+	}, readZipFile(t, zipPath))
+}
+
+// read the names and contents of the files in the tar.zst file
+func readTarZstFile(t *testing.T, tarPath string) map[string]string {
+	in, err := os.Open(tarPath)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, in.Close())
+	}()
+	zr, err := archives.Zstd{}.OpenReader(in)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, zr.Close())
+	}()
+	tr := tar.NewReader(zr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+	return files
+}
+
+// set base.FlushDelay for the duration of the test
+func setFlushDelay(t *testing.T, delay time.Duration) {
+	oldFlushDelay := base.FlushDelay
+	base.FlushDelay = delay
+	t.Cleanup(func() {
+		base.FlushDelay = oldFlushDelay
+	})
+}
+
+// Test archives are written when changes stop
+func TestArchiveWriteFlushDelay(t *testing.T) {
+	ctx := context.Background()
+	setFlushDelay(t, 100*time.Millisecond)
+	zipPath := filepath.Join(t.TempDir(), "test.zip")
+	t1 := fstest.Time("2001-02-03T04:05:06Z")
+
+	// count the files in the archive returning -1 if it can't be
+	// read as it may be being written
+	countFiles := func() int {
+		zr, err := zip.OpenReader(zipPath)
+		if err != nil {
+			return -1
+		}
+		defer func() {
+			_ = zr.Close()
+		}()
+		return len(zr.File)
+	}
+
+	f, err := fs.NewFs(ctx, ":archive:"+zipPath)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Features().Shutdown(ctx))
+	}()
+	o := fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "potato.txt", ModTime: t1}, "potato", true)
+	require.Eventually(t, func() bool {
+		return countFiles() == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{
+		"potato.txt": "potato",
+	}, readZipFile(t, zipPath))
+
+	// Objects from before the archive was written can still be read
+	assert.Equal(t, "potato", fstests.ReadObject(ctx, t, o, -1))
+
+	// The archive isn't written while it is being read
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "sausage.txt", ModTime: t1}, "sausage", true)
+	time.Sleep(5 * base.FlushDelay)
+	assert.Equal(t, 1, countFiles())
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "potato", string(data))
+	require.NoError(t, in.Close())
+	require.Eventually(t, func() bool {
+		return countFiles() == 2
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+// Test writing to tar archives
+func TestArchiveTarWrite(t *testing.T) {
+	ctx := context.Background()
+	setFlushDelay(t, time.Hour)
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "test.tar.zst")
+	t1 := fstest.Time("2001-02-03T04:05:06Z")
+
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		require.NoError(t, config.SetCacheDir(oldCacheDir))
+	}()
+	indexFiles := func() []string {
+		matches, err := filepath.Glob(filepath.Join(config.GetCacheDir(), "archive", "tar", "*.json"))
+		require.NoError(t, err)
+		return matches
+	}
+
+	shutdown := func(f fs.Fs) {
+		do := f.Features().Shutdown
+		require.NotNil(t, do)
+		require.NoError(t, do(ctx))
+	}
+
+	// Create a new archive
+	f, err := fs.NewFs(ctx, ":archive:"+tarPath)
+	require.NoError(t, err)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "potato.txt", ModTime: t1}, "potato", true)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "dir/sausage.txt", ModTime: t1}, "sausage", true)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "dir/bacon.txt", ModTime: t1}, "bacon", true)
+	require.NoError(t, f.Mkdir(ctx, "empty"))
+	_, err = os.Stat(tarPath)
+	assert.True(t, os.IsNotExist(err), "archive shouldn't be written until changes stop")
+	shutdown(f)
+	assert.Equal(t, map[string]string{
+		"potato.txt":      "potato",
+		"dir/":            "",
+		"dir/bacon.txt":   "bacon",
+		"dir/sausage.txt": "sausage",
+		"empty/":          "",
+	}, readTarZstFile(t, tarPath))
+
+	// The index of the new archive should be cached
+	assert.Len(t, indexFiles(), 1)
+
+	// Modify it
+	f, err = fs.NewFs(ctx, ":archive:"+tarPath)
+	require.NoError(t, err)
+	o, err := f.NewObject(ctx, "dir/bacon.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "bacon", fstests.ReadObject(ctx, t, o, -1))
+	o, err = f.NewObject(ctx, "potato.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	o, err = f.NewObject(ctx, "dir/sausage.txt")
+	require.NoError(t, err)
+	o, err = f.Features().Move(ctx, o, "moved/sausage.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "sausage", fstests.ReadObject(ctx, t, o, -1))
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "beans.txt", ModTime: t1}, "beans", true)
+	require.NoError(t, f.Rmdir(ctx, "empty"))
+	shutdown(f)
+	assert.Equal(t, map[string]string{
+		"beans.txt":         "beans",
+		"dir/":              "",
+		"dir/bacon.txt":     "bacon",
+		"moved/":            "",
+		"moved/sausage.txt": "sausage",
+	}, readTarZstFile(t, tarPath))
+
+	// The index of the old archive should be replaced
+	assert.Len(t, indexFiles(), 1)
+
+	// Write into it from the directory above
+	f, err = fs.NewFs(ctx, ":archive:"+dir)
+	require.NoError(t, err)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "test.tar.zst/dir/eggs.txt", ModTime: t1}, "eggs", true)
+	fstests.PutTestContents(ctx, t, f, &fstest.Item{Path: "test.tar.zst/beans.txt", ModTime: t1}, "more beans", true)
+	o, err = f.NewObject(ctx, "test.tar.zst/moved/sausage.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "sausage", fstests.ReadObject(ctx, t, o, -1))
+	shutdown(f)
+	assert.Equal(t, map[string]string{
+		"beans.txt":         "more beans",
+		"dir/":              "",
+		"dir/bacon.txt":     "bacon",
+		"dir/eggs.txt":      "eggs",
+		"moved/":            "",
+		"moved/sausage.txt": "sausage",
+	}, readTarZstFile(t, tarPath))
+}
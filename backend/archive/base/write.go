@@ -0,0 +1,649 @@
+package base
+
+// Writing to archives
+//
+// Changes are kept in the directory tree with the contents of new
+// files spooled to local temporary files. When the archive is flushed
+// the archiver writes the whole archive to a local temporary file
+// which is then uploaded to the remote.
+//
+// The archive is flushed when no changes have been made to it for
+// FlushDelay and when the Fs is shut down. The new archive is built
+// with Mu held but uploaded without it. While it is uploading changes
+// and reads of the data in the archive wait for it to finish as the
+// positions of the data in the archive are only known after it has
+// been read back.
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/dirtree"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/readers"
+	"github.com/rclone/rclone/vfs"
+)
+
+// FlushDelay is how long to wait after the last change to an archive
+// before writing it to the remote.
+var FlushDelay = 5 * time.Second
+
+// Archive is implemented by archivers which use Writer to write to
+// their archives
+type Archive interface {
+	fs.Fs
+
+	// NewWriteObject returns a new object at remote for writing
+	// to.
+	//
+	// Call with Mu held
+	NewWriteObject(remote string) WriteObject
+
+	// WriteArchive writes the complete archive to out.
+	//
+	// Call with Mu held
+	WriteArchive(out io.Writer) error
+
+	// ReadArchive reads the archive again after it has been
+	// uploaded.
+	//
+	// Call with Mu held
+	ReadArchive() error
+}
+
+// WriteObject is implemented by the objects of archivers which use
+// Writer
+type WriteObject interface {
+	fs.Object
+
+	// Spool returns the spool file with the data of the object or
+	// nil if the data is in the archive.
+	//
+	// Call with Mu held
+	Spool() *Spool
+
+	// SetSpool replaces the data of the object with spool with
+	// the modification time given.
+	//
+	// Call with Mu held
+	SetSpool(spool *Spool, modTime time.Time)
+
+	// MoveTo returns a new object at remote which takes over the
+	// data of the object.
+	//
+	// Call with Mu held
+	MoveTo(remote string) WriteObject
+
+	// Reset replaces the data of the object with that of newObj
+	// which is the object at the same remote read from the
+	// archive after it has been written.
+	//
+	// Call with Mu held
+	Reset(newObj WriteObject)
+}
+
+// Spool is a local temporary file holding the data of a file until
+// the archive is written
+type Spool struct {
+	name  string
+	Size  int64  // size of the data
+	CRC32 uint32 // CRC32 of the data
+}
+
+// NewSpool copies in to a new spool file
+func NewSpool(in io.Reader) (s *Spool, err error) {
+	out, err := os.CreateTemp("", "rclone-archive-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to make spool file: %w", err)
+	}
+	s = &Spool{name: out.Name()}
+	defer func() {
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			s.Remove()
+			s = nil
+		}
+	}()
+	hasher := crc32.NewIEEE()
+	s.Size, err = io.Copy(io.MultiWriter(out, hasher), in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write spool file: %w", err)
+	}
+	s.CRC32 = hasher.Sum32()
+	return s, nil
+}
+
+// Open the spool file for reading from offset, returning at most
+// limit bytes if limit >= 0
+func (s *Spool) Open(offset, limit int64) (io.ReadCloser, error) {
+	in, err := os.Open(s.name)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		_, err = in.Seek(offset, io.SeekStart)
+		if err != nil {
+			_ = in.Close()
+			return nil, err
+		}
+	}
+	if limit >= 0 {
+		return readers.NewLimitedReadCloser(in, limit), nil
+	}
+	return in, nil
+}
+
+// Remove the spool file
+func (s *Spool) Remove() {
+	err := os.Remove(s.name)
+	if err != nil && !os.IsNotExist(err) {
+		fs.Debugf(nil, "Failed to remove spool file: %v", err)
+	}
+}
+
+// Writer does the parts of writing to an archive which are the same
+// for all archivers.
+//
+// It should be embedded in the archiver's Fs and set up with Init.
+type Writer struct {
+	ctx         context.Context // for flushing in the background
+	archive     Archive
+	wrapped     fs.Fs  // Fs the archive is stored on
+	remote      string // remote of the archive on wrapped
+	prefix      string // position for objects
+	prefixSlash string // position for objects with a slash on
+	root        string // position to read from within the archive
+
+	Mu           sync.Mutex      // protects the below
+	DT           dirtree.DirTree // contents of the archive
+	SingleObject bool            // set if root points to a file
+	Missing      bool            // set if the archive doesn't exist yet
+	dirty        bool            // set if the archive needs rewriting
+	busy         int             // number of files being spooled or read
+	flushTimer   *time.Timer     // writes the archive once changes stop
+	flushing     bool            // set while the archive is being uploaded
+	flushDone    *sync.Cond      // signalled when flushing is cleared
+	oldSpools    []*Spool        // replaced spools to remove when not busy
+}
+
+// Init sets up the Writer for archive which is stored at remote on
+// wrapped with the objects prefixed with prefix and rooted at root.
+func (w *Writer) Init(ctx context.Context, archive Archive, wrapped fs.Fs, remote, prefix, root string) {
+	w.ctx = ctx
+	w.archive = archive
+	w.wrapped = wrapped
+	w.remote = remote
+	w.prefix = prefix
+	w.prefixSlash = prefix + "/"
+	w.root = root
+	w.flushDone = sync.NewCond(&w.Mu)
+}
+
+// ToNative converts a remote into a path within the archive
+func (w *Writer) ToNative(remote string) string {
+	if w.prefix != "" {
+		if remote == w.prefix {
+			remote = ""
+		} else {
+			remote = strings.TrimPrefix(remote, w.prefixSlash)
+		}
+	}
+	return path.Join(w.root, remote)
+}
+
+// InArchive returns true if remote is a file or directory inside the
+// archive rather than one of the directories leading to it
+func (w *Writer) InArchive(remote string) bool {
+	if w.prefix == "" {
+		return remote != ""
+	}
+	return strings.HasPrefix(remote, w.prefixSlash)
+}
+
+// CheckWritable returns an error if the archive can't be written to
+func (w *Writer) CheckWritable() error {
+	if w.SingleObject {
+		return vfs.EROFS
+	}
+	return nil
+}
+
+// WaitFlush waits for any upload of the archive to finish. This must
+// be called before changing the archive or reading the data in it.
+//
+// Call with Mu held
+func (w *Writer) WaitFlush() {
+	for w.flushing {
+		w.flushDone.Wait()
+	}
+}
+
+// Changed marks the archive as needing to be written. It will be
+// written once no changes have been made for FlushDelay.
+//
+// Call with Mu held
+func (w *Writer) Changed() {
+	w.dirty = true
+	if w.flushTimer == nil {
+		w.flushTimer = time.AfterFunc(FlushDelay, w.delayedFlush)
+	} else {
+		w.flushTimer.Reset(FlushDelay)
+	}
+}
+
+// Busy stops the archive being flushed in the background until done
+// is called. This should be used while the archive is being read. It
+// waits for any upload of the archive to finish first.
+//
+// Call with Mu held
+func (w *Writer) Busy() (done func()) {
+	w.WaitFlush()
+	return w.markBusy()
+}
+
+// markBusy stops the archive being flushed in the background until
+// done is called, without waiting for an upload in progress.
+//
+// Call with Mu held
+func (w *Writer) markBusy() (done func()) {
+	w.busy++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.Mu.Lock()
+			defer w.Mu.Unlock()
+			w.busy--
+			if w.busy > 0 {
+				return
+			}
+			for _, spool := range w.oldSpools {
+				spool.Remove()
+			}
+			w.oldSpools = nil
+			if w.dirty && w.flushTimer != nil {
+				w.flushTimer.Reset(FlushDelay)
+			}
+		})
+	}
+}
+
+// removeSpool removes spool once it can't be open for reading
+//
+// Call with Mu held
+func (w *Writer) removeSpool(spool *Spool) {
+	if w.busy > 0 {
+		w.oldSpools = append(w.oldSpools, spool)
+		return
+	}
+	spool.Remove()
+}
+
+// doneReadCloser calls done when it is closed
+type doneReadCloser struct {
+	io.ReadCloser
+	done func()
+}
+
+// Close the reader and call done
+func (rc doneReadCloser) Close() error {
+	defer rc.done()
+	return rc.ReadCloser.Close()
+}
+
+// ReadCloserWithDone returns rc wrapped so done is called when it is
+// closed. Use this with the done function from Busy.
+func ReadCloserWithDone(rc io.ReadCloser, done func()) io.ReadCloser {
+	return doneReadCloser{ReadCloser: rc, done: done}
+}
+
+// delayedFlush is called by the flush timer to write the archive
+func (w *Writer) delayedFlush() {
+	w.Mu.Lock()
+	busy := w.busy > 0
+	w.Mu.Unlock()
+	if busy {
+		// The timer is started again when the archive isn't busy
+		return
+	}
+	err := w.flush(w.ctx)
+	if err != nil {
+		fs.Errorf(w.archive, "Failed to write archive: %v", err)
+	}
+}
+
+// addEntry adds entry to the directory tree. The tree is sorted when
+// the archive is written.
+//
+// Call with Mu held
+func (w *Writer) addEntry(entry fs.DirEntry) {
+	w.DT.AddEntry(entry)
+	w.Changed()
+}
+
+// newSpool spools in checking it is the size of src
+//
+// The archive isn't flushed while this is running.
+func (w *Writer) newSpool(in io.Reader, src fs.ObjectInfo) (*Spool, error) {
+	w.Mu.Lock()
+	done := w.markBusy()
+	w.Mu.Unlock()
+	defer done()
+	spool, err := NewSpool(in)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize := src.Size(); srcSize >= 0 && srcSize != spool.Size {
+		spool.Remove()
+		return nil, fmt.Errorf("wrote %d bytes but expected %d", spool.Size, srcSize)
+	}
+	return spool, nil
+}
+
+// setSpool replaces the data of o with spool
+//
+// Call with Mu held
+func (w *Writer) setSpool(o WriteObject, spool *Spool, modTime time.Time) {
+	if old := o.Spool(); old != nil {
+		w.removeSpool(old)
+	}
+	o.SetSpool(spool, modTime)
+	w.Changed()
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (w *Writer) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if err := w.CheckWritable(); err != nil {
+		return nil, err
+	}
+	remote := src.Remote()
+	if !w.InArchive(remote) {
+		return nil, vfs.EROFS
+	}
+	spool, err := w.newSpool(in, src)
+	if err != nil {
+		return nil, err
+	}
+	modTime := src.ModTime(ctx)
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	_, entry := w.DT.Find(remote)
+	if entry != nil {
+		o, ok := entry.(WriteObject)
+		if !ok {
+			spool.Remove()
+			return nil, fs.ErrorNotAFile
+		}
+		w.setSpool(o, spool, modTime)
+		return o, nil
+	}
+	o := w.archive.NewWriteObject(remote)
+	w.setSpool(o, spool, modTime)
+	w.addEntry(o)
+	return o, nil
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (w *Writer) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return w.Put(ctx, in, src, options...)
+}
+
+// UpdateObject replaces the data of o with in
+func (w *Writer) UpdateObject(ctx context.Context, o WriteObject, in io.Reader, src fs.ObjectInfo) error {
+	if err := w.CheckWritable(); err != nil {
+		return err
+	}
+	spool, err := w.newSpool(in, src)
+	if err != nil {
+		return err
+	}
+	modTime := src.ModTime(ctx)
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	w.setSpool(o, spool, modTime)
+	return nil
+}
+
+// RemoveObject removes o from the archive
+func (w *Writer) RemoveObject(o WriteObject) error {
+	if err := w.CheckWritable(); err != nil {
+		return err
+	}
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	if w.DT.Remove(o.Remote()) == nil {
+		return fs.ErrorObjectNotFound
+	}
+	if spool := o.Spool(); spool != nil {
+		w.removeSpool(spool)
+	}
+	w.Changed()
+	return nil
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (w *Writer) Mkdir(ctx context.Context, dir string) error {
+	if err := w.CheckWritable(); err != nil {
+		return err
+	}
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	if _, ok := w.DT[dir]; ok {
+		if w.Missing && dir == w.prefix {
+			// Create the empty archive
+			w.Changed()
+		}
+		return nil
+	}
+	if !w.InArchive(dir) {
+		return vfs.EROFS
+	}
+	_, entry := w.DT.Find(dir)
+	if entry != nil {
+		return fs.ErrorIsFile
+	}
+	w.addEntry(fs.NewDir(dir, time.Now()))
+	return nil
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (w *Writer) Rmdir(ctx context.Context, dir string) error {
+	if err := w.CheckWritable(); err != nil {
+		return err
+	}
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	entries, ok := w.DT[dir]
+	if !ok {
+		return fs.ErrorDirNotFound
+	}
+	if len(entries) != 0 {
+		return fs.ErrorDirectoryNotEmpty
+	}
+	if !w.InArchive(dir) {
+		// Leave the (empty) archive in place
+		return nil
+	}
+	delete(w.DT, dir)
+	w.DT.Remove(dir)
+	w.Changed()
+	return nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (w *Writer) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(WriteObject)
+	if !ok || srcObj.Fs() != w.archive || !w.InArchive(remote) {
+		fs.Debugf(src, "Can't move - not same archive")
+		return nil, fs.ErrorCantMove
+	}
+	if err := w.CheckWritable(); err != nil {
+		return nil, err
+	}
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	if _, entry := w.DT.Find(srcObj.Remote()); entry != srcObj {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if _, ok := w.DT[remote]; ok {
+		return nil, fs.ErrorIsDir
+	}
+	if _, entry := w.DT.Find(remote); entry != nil {
+		if dstObj, ok := entry.(WriteObject); ok && dstObj.Spool() != nil {
+			w.removeSpool(dstObj.Spool())
+		}
+		w.DT.Remove(remote)
+	}
+	w.DT.Remove(srcObj.Remote())
+	dstObj := srcObj.MoveTo(remote)
+	w.addEntry(dstObj)
+	return dstObj, nil
+}
+
+// Shutdown the backend, writing the archive to the remote if it has
+// been changed.
+func (w *Writer) Shutdown(ctx context.Context) error {
+	w.Mu.Lock()
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+	}
+	w.Mu.Unlock()
+	return w.flush(ctx)
+}
+
+// flush rewrites the archive to the remote if it has changed
+func (w *Writer) flush(ctx context.Context) (err error) {
+	w.Mu.Lock()
+	defer w.Mu.Unlock()
+	w.WaitFlush()
+	if !w.dirty {
+		return nil
+	}
+	fs.Debugf(w.archive, "Writing changed archive")
+
+	// Note the objects so they can be kept up to date and the
+	// spool files which can be removed once the archive has been
+	// uploaded
+	var (
+		objects = make(map[string]WriteObject)
+		spools  []*Spool
+	)
+	for _, entries := range w.DT {
+		for _, entry := range entries {
+			if o, ok := entry.(WriteObject); ok {
+				objects[o.Remote()] = o
+				if o.Spool() != nil {
+					spools = append(spools, o.Spool())
+				}
+			}
+		}
+	}
+
+	// Build the new archive in a temporary file so it doesn't
+	// overwrite the old one until it is complete
+	out, err := os.CreateTemp("", "rclone-archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to make temporary file: %w", err)
+	}
+	defer func() {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+	}()
+	w.DT.Sort()
+	err = w.archive.WriteArchive(out)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	size, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = out.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// Upload it without holding the lock so the archive can
+	// still be listed
+	w.dirty = false
+	w.flushing = true
+	w.Mu.Unlock()
+	err = w.upload(ctx, out, size)
+	w.Mu.Lock()
+	w.flushing = false
+	w.flushDone.Broadcast()
+	if err != nil {
+		w.dirty = true
+		return fmt.Errorf("failed to upload archive: %w", err)
+	}
+
+	// Read the new archive back
+	err = w.archive.ReadArchive()
+	if err != nil {
+		// Keep the spools and write the archive again next time
+		w.dirty = true
+		return err
+	}
+
+	// Point the objects which may be in use at the new archive
+	for _, entries := range w.DT {
+		for i, entry := range entries {
+			newObj, ok := entry.(WriteObject)
+			if !ok {
+				continue
+			}
+			if o, ok := objects[newObj.Remote()]; ok {
+				o.Reset(newObj)
+				entries[i] = o
+			}
+		}
+	}
+	for _, spool := range spools {
+		w.removeSpool(spool)
+	}
+	return nil
+}
+
+// upload size bytes from in to the archive on the wrapped remote
+func (w *Writer) upload(ctx context.Context, in io.Reader, size int64) error {
+	info := object.NewStaticObjectInfo(w.remote, time.Now(), size, true, nil, w.wrapped)
+	dst, err := w.wrapped.NewObject(ctx, w.remote)
+	if err == nil {
+		return dst.Update(ctx, in, info)
+	} else if err == fs.ErrorObjectNotFound {
+		_, err = w.wrapped.Put(ctx, in, info)
+	}
+	return err
+}
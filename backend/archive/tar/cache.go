@@ -0,0 +1,137 @@
+package tar
+
+// Compressed tar files can only be read from the start, so keep the
+// decompressing streams open once they have been used. When a file
+// is read its stream is returned to the cache positioned just after
+// it, so reading files in the order they are in the archive (as
+// rclone copy and sync mostly do) doesn't need to decompress the
+// archive again from the start for each file.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mholt/archives"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// maxStreams is the maximum number of idle streams to keep open
+const maxStreams = 4
+
+// A decompressing stream on the archive
+type stream struct {
+	fh  vfs.Handle    // handle on the compressed file
+	in  io.ReadCloser // decompressor reading from fh
+	pos int64         // position in the uncompressed stream
+}
+
+// Read from the uncompressed stream
+func (s *stream) Read(p []byte) (n int, err error) {
+	n, err = s.in.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+// Close the stream
+func (s *stream) Close() error {
+	err := s.in.Close()
+	closeErr := s.fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Cache of decompressing streams for accessing the file
+type cache struct {
+	node       vfs.Node
+	decompress archives.Decompressor
+	mu         sync.Mutex
+	streams    []*stream
+}
+
+// Make a new cache
+func newCache(node vfs.Node, decompress archives.Decompressor) *cache {
+	return &cache{
+		node:       node,
+		decompress: decompress,
+	}
+}
+
+// Get a stream positioned at off in the uncompressed data
+//
+// This uses the open stream closest before off if there is one,
+// otherwise it starts decompressing from the start of the archive.
+func (c *cache) open(off int64) (s *stream, err error) {
+	c.mu.Lock()
+	best := -1
+	for i, cs := range c.streams {
+		if cs.pos <= off && (best < 0 || cs.pos > c.streams[best].pos) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		s = c.streams[best]
+		c.streams = append(c.streams[:best], c.streams[best+1:]...)
+	}
+	c.mu.Unlock()
+
+	if s == nil {
+		fh, err := c.node.Open(os.O_RDONLY)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tar archive: %w", err)
+		}
+		in, err := c.decompress.OpenReader(fh)
+		if err != nil {
+			_ = fh.Close()
+			return nil, fmt.Errorf("failed to decompress tar archive: %w", err)
+		}
+		s = &stream{fh: fh, in: in}
+	}
+
+	// Skip forward to the offset
+	if skip := off - s.pos; skip > 0 {
+		_, err = io.CopyN(io.Discard, s, skip)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("failed to seek in tar archive: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Close a stream or return it to the cache
+func (c *cache) close(s *stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.streams = append(c.streams, s)
+	if len(c.streams) > maxStreams {
+		// Close the stream nearest the start of the file as it
+		// is the cheapest to make again
+		worst := 0
+		for i, cs := range c.streams {
+			if cs.pos < c.streams[worst].pos {
+				worst = i
+			}
+		}
+		if err := c.streams[worst].Close(); err != nil {
+			fs.Debugf(nil, "Failed to close tar stream: %v", err)
+		}
+		c.streams = append(c.streams[:worst], c.streams[worst+1:]...)
+	}
+}
+
+// Close all the streams in the cache
+func (c *cache) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.streams {
+		if err := s.Close(); err != nil {
+			fs.Debugf(nil, "Failed to close tar stream: %v", err)
+		}
+	}
+	c.streams = nil
+}
@@ -0,0 +1,219 @@
+package tar
+
+// The index of a tar file is the list of headers with the offset of
+// the data for each file in the uncompressed tar stream.
+//
+// Tar files have no index of their own, so making one means reading
+// (and decompressing) the whole archive. The index is saved in the
+// cache directory so this only needs to be done once for each
+// version of each archive.
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/file"
+)
+
+// indexVersion should be incremented if the index format changes
+const indexVersion = 1
+
+// indexMaxAge is how long an index is kept without being used
+const indexMaxAge = 30 * 24 * time.Hour
+
+// An entry in the tar file
+type indexEntry struct {
+	Name     string    `json:"name"`
+	Typeflag byte      `json:"type"`
+	Linkname string    `json:"link,omitempty"`
+	Mode     int64     `json:"mode"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Offset   int64     `json:"offset"` // of the data in the uncompressed stream
+}
+
+// newIndexEntry makes an index entry from a tar header
+func newIndexEntry(hdr *tar.Header, offset int64) *indexEntry {
+	return &indexEntry{
+		Name:     hdr.Name,
+		Typeflag: hdr.Typeflag,
+		Linkname: hdr.Linkname,
+		Mode:     hdr.Mode,
+		Size:     hdr.Size,
+		ModTime:  hdr.ModTime,
+		Offset:   offset,
+	}
+}
+
+// header makes a tar header from the index entry
+func (e *indexEntry) header() *tar.Header {
+	return &tar.Header{
+		Name:     e.Name,
+		Typeflag: e.Typeflag,
+		Linkname: e.Linkname,
+		Mode:     e.Mode,
+		Size:     e.Size,
+		ModTime:  e.ModTime,
+	}
+}
+
+// The index of a tar file
+type index struct {
+	Version int           `json:"version"`
+	Size    int64         `json:"size"`  // size of the archive
+	ModTime time.Time     `json:"mtime"` // modification time of the archive
+	Entries []*indexEntry `json:"entries"`
+}
+
+// indexDir returns the directory the indexes are stored in
+func indexDir() string {
+	return filepath.Join(config.GetCacheDir(), "archive", "tar")
+}
+
+// indexPath returns the path of the index for the archive with the
+// name, size and modTime given
+func indexPath(name string, size int64, modTime time.Time) string {
+	fingerprint := fmt.Sprintf("%s,%d,%d", name, size, modTime.UnixNano())
+	sum := md5.Sum([]byte(fingerprint))
+	return filepath.Join(indexDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+// errIndexStale is returned if the index doesn't match the archive
+var errIndexStale = errors.New("index is out of date")
+
+// loadIndex loads the index from path checking it matches size and
+// modTime
+func loadIndex(path string, size int64, modTime time.Time) (idx *index, err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	idx = new(index)
+	err = json.NewDecoder(in).Decode(idx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode index: %w", err)
+	}
+	if idx.Version != indexVersion || idx.Size != size || !idx.ModTime.Equal(modTime) {
+		return nil, errIndexStale
+	}
+	// Mark the index as used so it isn't expired
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		fs.Debugf(nil, "Failed to update index modification time: %v", err)
+	}
+	return idx, nil
+}
+
+// save the index to path
+func (idx *index) save(path string) (err error) {
+	err = file.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return fmt.Errorf("failed to make index directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(out).Encode(idx)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	expireIndexes(time.Now().Add(-indexMaxAge))
+	return nil
+}
+
+// expireIndexes removes the indexes which haven't been used since
+// cutoff.
+//
+// This is called whenever an index is saved so the index directory
+// can't grow without limit.
+func expireIndexes(cutoff time.Time) {
+	entries, err := os.ReadDir(indexDir())
+	if err != nil {
+		fs.Debugf(nil, "Failed to read index directory: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(indexDir(), entry.Name())
+		fs.Debugf(nil, "Removing expired tar index %q", path)
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			fs.Debugf(nil, "Failed to remove expired index: %v", err)
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	in  io.Reader
+	pos int64
+}
+
+// Read bytes counting them
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.in.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+// scanIndex reads the tar stream from in returning the index entries
+//
+// If in is an io.Seeker then it is used to skip the file data.
+func scanIndex(in io.Reader) (entries []*indexEntry, err error) {
+	var pos func() (int64, error)
+	if seeker, ok := in.(io.ReadSeeker); ok {
+		pos = func() (int64, error) {
+			return seeker.Seek(0, io.SeekCurrent)
+		}
+	} else {
+		cr := &countingReader{in: in}
+		in = cr
+		pos = func() (int64, error) {
+			return cr.pos, nil
+		}
+	}
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar header: %w", err)
+		}
+		offset, err := pos()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, newIndexEntry(hdr, offset))
+	}
+	return entries, nil
+}
@@ -0,0 +1,50 @@
+package tar
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexExpire(t *testing.T) {
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		require.NoError(t, config.SetCacheDir(oldCacheDir))
+	}()
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// Make an index which is used and one which isn't
+	idx := &index{Version: indexVersion, Size: 1, ModTime: t1}
+	used := indexPath("used", idx.Size, idx.ModTime)
+	unused := indexPath("unused", idx.Size, idx.ModTime)
+	require.NoError(t, idx.save(used))
+	require.NoError(t, idx.save(unused))
+	leftover := filepath.Join(indexDir(), "leftover.json.tmp")
+	require.NoError(t, os.WriteFile(leftover, nil, 0666))
+	old := time.Now().Add(-2 * indexMaxAge)
+	for _, path := range []string{used, unused, leftover} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+
+	// Loading an index marks it as used
+	_, err := loadIndex(used, idx.Size, idx.ModTime)
+	require.NoError(t, err)
+
+	// Saving a new index expires the old ones
+	current := indexPath("current", idx.Size, idx.ModTime)
+	require.NoError(t, idx.save(current))
+	assert.True(t, exists(current))
+	assert.True(t, exists(used))
+	assert.False(t, exists(unused))
+	assert.False(t, exists(leftover))
+}
@@ -0,0 +1,548 @@
+// Package tar implements a tar archiver for the archive backend
+package tar
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+	"github.com/rclone/rclone/backend/archive/archiver"
+	"github.com/rclone/rclone/backend/archive/base"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/dirtree"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// compression is used to compress and decompress tar files
+type compression interface {
+	archives.Compressor
+	archives.Decompressor
+}
+
+// The tar formats supported and their extensions
+var formats = []struct {
+	extensions []string
+	compress   compression // nil for an uncompressed tar file
+}{
+	{[]string{".tar"}, nil},
+	{[]string{".tar.gz", ".tgz"}, archives.Gz{}},
+	{[]string{".tar.bz2", ".tbz2", ".tbz"}, archives.Bz2{}},
+	{[]string{".tar.xz", ".txz"}, archives.Xz{}},
+	{[]string{".tar.zst", ".tzst"}, archives.Zstd{}},
+	{[]string{".tar.lz4"}, archives.Lz4{}},
+}
+
+func init() {
+	for _, format := range formats {
+		compress := format.compress
+		newFs := func(ctx context.Context, wrappedFs fs.Fs, remote, prefix, root string) (fs.Fs, error) {
+			return New(ctx, wrappedFs, remote, prefix, root, compress)
+		}
+		for _, extension := range format.extensions {
+			archiver.Register(archiver.Archiver{
+				New:       newFs,
+				Extension: extension,
+			})
+		}
+	}
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	base.Writer // writes changes to the archive
+	f           fs.Fs
+	wrapper     fs.Fs
+	name        string
+	features    *fs.Features // optional features
+	vfs         *vfs.VFS
+	node        vfs.Node    // tar file object - nil if the archive doesn't exist yet
+	compress    compression // compression used for the tar file - nil if none
+	remote      string      // remote of the tar file object
+	prefix      string      // position for objects
+	root        string      // position to read from within the archive
+
+	// These are protected by Mu
+	in      vfs.Handle    // open handle on an uncompressed tar file
+	c       *cache        // streams on a compressed tar file
+	entries []*indexEntry // all the entries in the tar file
+	written []*indexEntry // index of the archive being written
+}
+
+// New constructs an Fs from the (wrappedFs, remote) with the objects
+// prefix with prefix and rooted at root
+func New(ctx context.Context, wrappedFs fs.Fs, remote, prefix, root string, compress compression) (fs.Fs, error) {
+	fs.Debugf(nil, "Tar: New: remote=%q, prefix=%q, root=%q", remote, prefix, root)
+	vfsOpt := vfscommon.Opt
+	vfsOpt.ReadWait = 0
+	VFS := vfs.New(ctx, wrappedFs, &vfsOpt)
+	node, err := VFS.Stat(remote)
+	if errors.Is(err, vfs.ENOENT) {
+		// The archive will be created when it is written to
+		node, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %q archive: %w", remote, err)
+	}
+	if node != nil && node.IsDir() {
+		return nil, fmt.Errorf("failed to open %q archive: %w", remote, fs.ErrorIsDir)
+	}
+
+	f := &Fs{
+		f:        wrappedFs,
+		name:     path.Join(fs.ConfigString(wrappedFs), remote),
+		vfs:      VFS,
+		node:     node,
+		compress: compress,
+		remote:   remote,
+		root:     strings.Trim(root, "/"),
+		prefix:   prefix,
+	}
+	f.Init(ctx, f, wrappedFs, remote, prefix, f.root)
+
+	// Read the contents of the tar file
+	err = f.readTar()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tar file: %w", err)
+	}
+
+	// the features here are ones we could support, and they are
+	// ANDed with the ones from wrappedFs
+	f.features = (&fs.Features{
+		CaseInsensitive:         false,
+		DuplicateFiles:          false,
+		ReadMimeType:            false,
+		WriteMimeType:           false,
+		BucketBased:             false,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f).Mask(ctx, wrappedFs).WrapsFs(f, wrappedFs)
+
+	// These are done within the archive so enable them always
+	f.features.PutStream = f.PutStream
+	f.features.Move = f.Move
+	f.features.Shutdown = f.Shutdown
+
+	if f.SingleObject {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("Tar %q", f.name)
+}
+
+// closeReaders closes any open handles on the archive
+//
+// Call with f.Mu held
+func (f *Fs) closeReaders() {
+	if f.in != nil {
+		_ = f.in.Close()
+		f.in = nil
+	}
+	if f.c != nil {
+		f.c.closeAll()
+		f.c = nil
+	}
+}
+
+// openReaders opens the handles needed to read the archive
+//
+// Call with f.Mu held
+func (f *Fs) openReaders() (err error) {
+	if f.compress != nil {
+		f.c = newCache(f.node, f.compress)
+		return nil
+	}
+	f.in, err = f.node.Open(os.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file: %w", err)
+	}
+	return nil
+}
+
+// readIndex reads the index of the archive, either from the cache or
+// by reading the whole archive
+//
+// Call with f.Mu held
+func (f *Fs) readIndex() (entries []*indexEntry, err error) {
+	size, modTime := f.node.Size(), f.node.ModTime()
+	indexFile := indexPath(f.name, size, modTime)
+	idx, err := loadIndex(indexFile, size, modTime)
+	if err == nil {
+		fs.Debugf(f, "Loaded index from cache")
+		return idx.Entries, nil
+	}
+	if !os.IsNotExist(err) {
+		fs.Debugf(f, "Ignoring cached index: %v", err)
+	}
+
+	fs.Infof(f, "Reading tar file to make index")
+	if f.compress == nil {
+		entries, err = scanIndex(io.NewSectionReader(f.in, 0, size))
+	} else {
+		var s *stream
+		s, err = f.c.open(0)
+		if err != nil {
+			return nil, err
+		}
+		entries, err = scanIndex(s)
+		_ = s.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idx = &index{
+		Version: indexVersion,
+		Size:    size,
+		ModTime: modTime,
+		Entries: entries,
+	}
+	err = idx.save(indexFile)
+	if err != nil {
+		fs.Logf(f, "Failed to save index to cache: %v", err)
+	}
+	return entries, nil
+}
+
+// readTar reads the index of the tar file into f
+//
+// This sets f.SingleObject if f.root points to a file.
+//
+// Call with f.Mu held or before f is in use
+func (f *Fs) readTar() (err error) {
+	f.closeReaders()
+	if f.node == nil {
+		// Archive doesn't exist yet so start with an empty one
+		f.entries = nil
+		f.DT = dirtree.New()
+		f.DT.AddDir(fs.NewDir(f.prefix, time.Now()))
+		f.DT[f.prefix] = nil
+		f.Missing = true
+		return nil
+	}
+	f.Missing = false
+	if f.node.Size() < 0 {
+		return errors.New("can't read from tar file with unknown size")
+	}
+	err = f.openReaders()
+	if err != nil {
+		return err
+	}
+	entries, err := f.readIndex()
+	if err != nil {
+		f.closeReaders()
+		return err
+	}
+	f.entries = entries
+	f.buildTree()
+	return nil
+}
+
+// buildTree makes the directory tree from f.entries
+//
+// Call with f.Mu held
+func (f *Fs) buildTree() {
+	singleObject := false
+	seen := make(map[string]struct{})
+	dt := dirtree.New()
+	if f.root == "" {
+		// The root of the archive always exists
+		dt.AddDir(fs.NewDir(f.prefix, f.node.ModTime()))
+		dt[f.prefix] = nil
+	}
+	for _, entry := range f.entries {
+		var isDir bool
+		switch entry.Typeflag {
+		case tar.TypeDir:
+			isDir = true
+		case tar.TypeReg:
+		default:
+			// Ignore links, devices and other special files
+			continue
+		}
+		remote := cleanName(entry.Name)
+		if f.root != "" {
+			// Ignore all files outside the root
+			if !f.inRoot(remote) {
+				continue
+			}
+			if remote == f.root {
+				remote = ""
+			} else {
+				remote = strings.TrimPrefix(remote, f.root+"/")
+			}
+		}
+		remote = path.Join(f.prefix, remote)
+		if isDir {
+			dt.AddDir(fs.NewDir(remote, entry.ModTime))
+			continue
+		}
+		if remote == "" {
+			remote = path.Base(f.root)
+			singleObject = true
+			dt = dirtree.New()
+		}
+		o := &Object{
+			f:      f,
+			remote: remote,
+			entry:  entry,
+		}
+		// Later entries replace earlier ones with the same name
+		if _, found := seen[remote]; found {
+			dt.Remove(remote)
+		}
+		seen[remote] = struct{}{}
+		dt.Add(o)
+		if singleObject {
+			break
+		}
+	}
+	dt.CheckParents("")
+	dt.Sort()
+	f.DT = dt
+	f.SingleObject = singleObject
+}
+
+// cleanName returns the name in the tar file in a standard form
+func cleanName(name string) string {
+	name = strings.Trim(path.Clean(name), "/")
+	if name == "." {
+		name = ""
+	}
+	return name
+}
+
+// inRoot returns true if the archive path name is f.root or inside it
+func (f *Fs) inRoot(name string) bool {
+	return f.root == "" || name == f.root || strings.HasPrefix(name, f.root+"/")
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	defer log.Trace(f, "dir=%q", dir)("entries=%v, err=%v", &entries, &err)
+	f.Mu.Lock()
+	defer f.Mu.Unlock()
+	dirEntries, ok := f.DT[dir]
+	if !ok {
+		return nil, fs.ErrorDirNotFound
+	}
+	entries = append(fs.DirEntries(nil), dirEntries...)
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (o fs.Object, err error) {
+	defer log.Trace(f, "remote=%q", remote)("obj=%v, err=%v", &o, &err)
+	f.Mu.Lock()
+	defer f.Mu.Unlock()
+	if f.DT == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	_, entry := f.DT.Find(remote)
+	if entry == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	o, ok := entry.(*Object)
+	if !ok {
+		return nil, fs.ErrorNotAFile
+	}
+	return o, nil
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.f
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// Object describes an object to be read from the tar file
+type Object struct {
+	f      *Fs
+	remote string
+	entry  *indexEntry // header and position of the data in the tar file
+	spool  *base.Spool // set if the data is in a local file waiting to be written
+	orig   *indexEntry // entry in the old archive if entry has been changed
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Remote()
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	o.f.Mu.Lock()
+	defer o.f.Mu.Unlock()
+	return o.entry.Size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	o.f.Mu.Lock()
+	defer o.f.Mu.Unlock()
+	return o.entry.ModTime
+}
+
+// Storable raturns a boolean indicating if this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// streamReader reads from a stream returning it to the cache on Close
+type streamReader struct {
+	io.Reader
+	c *cache
+	s *stream
+}
+
+// Close the streamReader returning the stream to the cache
+func (sr *streamReader) Close() error {
+	sr.c.close(sr.s)
+	return nil
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	size := o.Size()
+	if offset > size {
+		offset = size
+	}
+	if limit < 0 || offset+limit > size {
+		limit = size - offset
+	}
+
+	// Don't write the archive while it is being read
+	o.f.Mu.Lock()
+	done := o.f.Busy()
+	spool, entry, in, c := o.spool, o.entry, o.f.in, o.f.c
+	o.f.Mu.Unlock()
+	defer func() {
+		if err != nil {
+			done()
+		}
+	}()
+
+	if spool != nil {
+		rc, err = spool.Open(offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		return base.ReadCloserWithDone(rc, done), nil
+	}
+
+	if c == nil {
+		if in == nil {
+			return nil, errors.New("tar file not open")
+		}
+		rc = io.NopCloser(io.NewSectionReader(in, entry.Offset+offset, limit))
+		return base.ReadCloserWithDone(rc, done), nil
+	}
+
+	s, err := c.open(entry.Offset + offset)
+	if err != nil {
+		return nil, err
+	}
+	rc = &streamReader{
+		Reader: io.LimitReader(s, limit),
+		c:      c,
+		s:      s,
+	}
+	return base.ReadCloserWithDone(rc, done), nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.UnWrapper   = (*Fs)(nil)
+	_ fs.Wrapper     = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.Mover       = (*Fs)(nil)
+	_ fs.Shutdowner  = (*Fs)(nil)
+	_ fs.Object      = (*Object)(nil)
+)
@@ -0,0 +1,338 @@
+package tar
+
+// Writing to tar archives
+//
+// The changes are kept by base.Writer which calls WriteArchive to
+// write the new archive. Unchanged files are copied across in the
+// order they are in the old archive so a compressed archive only
+// needs to be decompressed once.
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/archive/base"
+	"github.com/rclone/rclone/fs"
+)
+
+// NewWriteObject returns a new object at remote for writing to
+//
+// Call with f.Mu held
+func (f *Fs) NewWriteObject(remote string) base.WriteObject {
+	return &Object{
+		f:      f,
+		remote: remote,
+	}
+}
+
+// Spool returns the spool file with the data of the object or nil if
+// the data is in the archive.
+//
+// Call with f.Mu held
+func (o *Object) Spool() *base.Spool {
+	return o.spool
+}
+
+// SetSpool replaces the data of the object with spool with the
+// modification time given
+//
+// Call with f.Mu held
+func (o *Object) SetSpool(spool *base.Spool, modTime time.Time) {
+	o.spool = spool
+	o.orig = nil
+	o.entry = &indexEntry{
+		Name:     o.f.ToNative(o.remote),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     spool.Size,
+		ModTime:  modTime,
+		Offset:   -1,
+	}
+}
+
+// MoveTo returns a new object at remote which takes over the data of
+// the object
+//
+// Call with f.Mu held
+func (o *Object) MoveTo(remote string) base.WriteObject {
+	entry := *o.entry
+	entry.Name = o.f.ToNative(remote)
+	dstObj := &Object{
+		f:      o.f,
+		remote: remote,
+		entry:  &entry,
+		spool:  o.spool,
+	}
+	if dstObj.spool == nil {
+		dstObj.orig = o.origEntry()
+	}
+	o.spool = nil
+	return dstObj
+}
+
+// Reset replaces the data of the object with that of newObj read
+// from the archive after it has been written
+//
+// Call with f.Mu held
+func (o *Object) Reset(newObj base.WriteObject) {
+	o.entry = newObj.(*Object).entry
+	o.orig = nil
+	o.spool = nil
+}
+
+// ReadArchive reads the archive again after it has been uploaded,
+// saving the index made while writing it so it doesn't need reading
+//
+// Call with f.Mu held
+func (f *Fs) ReadArchive() (err error) {
+	oldIndex := ""
+	if f.node != nil {
+		oldIndex = indexPath(f.name, f.node.Size(), f.node.ModTime())
+	}
+	f.closeReaders()
+	f.vfs.FlushDirCache()
+	if oldIndex != "" {
+		_ = os.Remove(oldIndex)
+	}
+	f.node, err = f.vfs.Stat(f.remote)
+	if err != nil {
+		return fmt.Errorf("tar: failed to find archive after upload: %w", err)
+	}
+	idx := &index{
+		Version: indexVersion,
+		Size:    f.node.Size(),
+		ModTime: f.node.ModTime(),
+		Entries: f.written,
+	}
+	f.written = nil
+	err = idx.save(indexPath(f.name, idx.Size, idx.ModTime))
+	if err != nil {
+		fs.Logf(f, "Failed to save index to cache: %v", err)
+	}
+	return f.readTar()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	out io.Writer
+	pos int64
+}
+
+// Write bytes counting them
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.out.Write(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+// tarWriter writes a tar file recording the index as it goes
+type tarWriter struct {
+	tw      *tar.Writer
+	cw      *countingWriter
+	entries []*indexEntry
+}
+
+// write an entry to the tar file with the data from in
+func (w *tarWriter) write(entry *indexEntry, in io.Reader) error {
+	hdr := entry.header()
+	if hdr.Typeflag == tar.TypeDir && !strings.HasSuffix(hdr.Name, "/") {
+		hdr.Name += "/"
+	}
+	err := w.tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	w.entries = append(w.entries, newIndexEntry(hdr, w.cw.pos))
+	if in == nil || hdr.Size == 0 {
+		return nil
+	}
+	_, err = io.CopyN(w.tw, in, hdr.Size)
+	return err
+}
+
+// WriteArchive writes the complete archive to out keeping the index of
+// the new archive in f.written
+//
+// Call with f.Mu held
+func (f *Fs) WriteArchive(out io.Writer) (err error) {
+	var compressor io.WriteCloser
+	if f.compress != nil {
+		compressor, err = f.compress.OpenWriter(out)
+		if err != nil {
+			return err
+		}
+		out = compressor
+	}
+	w := &tarWriter{cw: &countingWriter{out: out}}
+	w.tw = tar.NewWriter(w.cw)
+
+	// Find the objects to write from the old archive and those
+	// which are new
+	var (
+		dirs    []*indexEntry
+		changed = make(map[*indexEntry]*indexEntry) // old entry to new entry
+		newObjs []*Object
+	)
+	if f.root != "" {
+		dirs = append(dirs, &indexEntry{Name: f.root, Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()})
+	}
+	for _, dir := range f.DT.Dirs() {
+		for _, entry := range f.DT[dir] {
+			if !f.InArchive(entry.Remote()) {
+				continue
+			}
+			switch x := entry.(type) {
+			case fs.Directory:
+				dirs = append(dirs, &indexEntry{
+					Name:     f.ToNative(x.Remote()),
+					Typeflag: tar.TypeDir,
+					Mode:     0755,
+					ModTime:  x.ModTime(context.Background()),
+				})
+			case *Object:
+				if x.spool != nil {
+					newObjs = append(newObjs, x)
+				} else {
+					changed[x.origEntry()] = x.entry
+				}
+			default:
+				return fmt.Errorf("unknown entry type %T", entry)
+			}
+		}
+	}
+
+	// Write the directories first
+	for _, entry := range dirs {
+		err = w.write(entry, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Then the files from the old archive in order
+	var s *stream
+	defer func() {
+		if s != nil {
+			_ = s.Close()
+		}
+	}()
+	for _, entry := range f.entries {
+		if entry.Typeflag == tar.TypeDir && f.inRoot(cleanName(entry.Name)) {
+			continue
+		}
+		newEntry := entry
+		if entry.Typeflag == tar.TypeReg && f.inRoot(cleanName(entry.Name)) {
+			newEntry = changed[entry]
+			if newEntry == nil {
+				// Removed or replaced
+				continue
+			}
+		}
+		var in io.Reader
+		if entry.Size > 0 {
+			if f.c != nil {
+				if s == nil || s.pos > entry.Offset {
+					if s != nil {
+						_ = s.Close()
+					}
+					s, err = f.c.open(entry.Offset)
+				} else {
+					_, err = io.CopyN(io.Discard, s, entry.Offset-s.pos)
+				}
+				if err != nil {
+					return err
+				}
+				in = s
+			} else {
+				in = io.NewSectionReader(f.in, entry.Offset, entry.Size)
+			}
+		}
+		err = w.write(newEntry, in)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Then the new files
+	sort.Slice(newObjs, func(i, j int) bool {
+		return newObjs[i].entry.Name < newObjs[j].entry.Name
+	})
+	for _, o := range newObjs {
+		err = o.writeSpool(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.tw.Close()
+	if err != nil {
+		return err
+	}
+	if compressor != nil {
+		err = compressor.Close()
+		if err != nil {
+			return err
+		}
+	}
+	f.written = w.entries
+	return nil
+}
+
+// origEntry returns the entry in the old archive the object's data
+// comes from
+func (o *Object) origEntry() *indexEntry {
+	if o.orig != nil {
+		return o.orig
+	}
+	return o.entry
+}
+
+// writeSpool writes the object from its spool file
+func (o *Object) writeSpool(w *tarWriter) (err error) {
+	in, err := o.spool.Open(0, -1)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	return w.write(o.entry, in)
+}
+
+// SetModTime sets the modification time of the local fs object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if err := o.f.CheckWritable(); err != nil {
+		return err
+	}
+	o.f.Mu.Lock()
+	defer o.f.Mu.Unlock()
+	o.f.WaitFlush()
+	if o.spool == nil {
+		o.orig = o.origEntry()
+	}
+	entry := *o.entry
+	entry.ModTime = modTime
+	o.entry = &entry
+	o.f.Changed()
+	return nil
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.f.UpdateObject(ctx, o, in, src)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return o.f.RemoveObject(o)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ base.Archive     = (*Fs)(nil)
+	_ base.WriteObject = (*Object)(nil)
+)
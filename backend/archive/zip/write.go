@@ -0,0 +1,206 @@
+package zip
+
+// Writing to zip archives
+//
+// The changes are kept by base.Writer which calls WriteArchive to
+// write the new archive. This copies the compressed data of unchanged
+// files across without recompressing it.
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/archive/base"
+	"github.com/rclone/rclone/fs"
+)
+
+// NewWriteObject returns a new object at remote for writing to
+//
+// Call with f.Mu held
+func (f *Fs) NewWriteObject(remote string) base.WriteObject {
+	return &Object{
+		f:      f,
+		remote: remote,
+	}
+}
+
+// Spool returns the spool file with the data of the object or nil if
+// the data is in the archive.
+//
+// Call with f.Mu held
+func (o *Object) Spool() *base.Spool {
+	return o.spool
+}
+
+// SetSpool replaces the data of the object with spool with the
+// modification time given
+//
+// Call with f.Mu held
+func (o *Object) SetSpool(spool *base.Spool, modTime time.Time) {
+	o.spool = spool
+	o.file = nil
+	o.fh = &zip.FileHeader{
+		Name:               o.f.ToNative(o.remote),
+		Method:             zip.Deflate,
+		Modified:           modTime,
+		CRC32:              spool.CRC32,
+		UncompressedSize64: uint64(spool.Size),
+	}
+}
+
+// MoveTo returns a new object at remote which takes over the data of
+// the object
+//
+// Call with f.Mu held
+func (o *Object) MoveTo(remote string) base.WriteObject {
+	fh := *o.fh
+	fh.Name = o.f.ToNative(remote)
+	dstObj := &Object{
+		f:      o.f,
+		remote: remote,
+		fh:     &fh,
+		file:   o.file,
+		spool:  o.spool,
+	}
+	o.spool = nil
+	return dstObj
+}
+
+// Reset replaces the data of the object with that of newObj read
+// from the archive after it has been written
+//
+// Call with f.Mu held
+func (o *Object) Reset(newObj base.WriteObject) {
+	x := newObj.(*Object)
+	o.fh = x.fh
+	o.file = x.file
+	o.spool = nil
+}
+
+// ReadArchive reads the archive again after it has been uploaded
+//
+// Call with f.Mu held
+func (f *Fs) ReadArchive() (err error) {
+	f.vfs.FlushDirCache()
+	f.node, err = f.vfs.Stat(f.remote)
+	if err != nil {
+		return fmt.Errorf("zip: failed to find archive after upload: %w", err)
+	}
+	return f.readZip()
+}
+
+// WriteArchive writes the complete archive to out
+//
+// Call with f.Mu held
+func (f *Fs) WriteArchive(out io.Writer) (err error) {
+	zw := zip.NewWriter(out)
+
+	// Copy the files outside the root unchanged
+	if f.zr != nil && f.root != "" {
+		for _, file := range f.zr.File {
+			if f.inRoot(strings.Trim(path.Clean(file.Name), "/")) {
+				continue
+			}
+			err = zw.Copy(file)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = zw.CreateHeader(&zip.FileHeader{
+			Name:     f.root + "/",
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Then write everything in the directory tree
+	for _, dir := range f.DT.Dirs() {
+		for _, entry := range f.DT[dir] {
+			if !f.InArchive(entry.Remote()) {
+				continue
+			}
+			switch x := entry.(type) {
+			case fs.Directory:
+				_, err = zw.CreateHeader(&zip.FileHeader{
+					Name:     f.ToNative(x.Remote()) + "/",
+					Modified: x.ModTime(context.Background()),
+				})
+			case *Object:
+				err = x.write(zw)
+			default:
+				err = fmt.Errorf("unknown entry type %T", entry)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// write the object into the zip writer
+func (o *Object) write(zw *zip.Writer) (err error) {
+	fh := *o.fh
+	if o.spool != nil {
+		in, err := o.spool.Open(0, -1)
+		if err != nil {
+			return err
+		}
+		defer fs.CheckClose(in, &err)
+		w, err := zw.CreateHeader(&fh)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, in)
+		return err
+	}
+	// Copy the compressed data without recompressing it
+	in, err := o.file.OpenRaw()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateRaw(&fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// SetModTime sets the modification time of the local fs object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if err := o.f.CheckWritable(); err != nil {
+		return err
+	}
+	o.f.Mu.Lock()
+	defer o.f.Mu.Unlock()
+	o.f.WaitFlush()
+	fh := *o.fh
+	fh.Modified = modTime
+	o.fh = &fh
+	o.f.Changed()
+	return nil
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.f.UpdateObject(ctx, o, in, src)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return o.f.RemoveObject(o)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ base.Archive     = (*Fs)(nil)
+	_ base.WriteObject = (*Object)(nil)
+)
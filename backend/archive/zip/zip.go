@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/rclone/rclone/backend/archive/archiver"
+	"github.com/rclone/rclone/backend/archive/base"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/dirtree"
 	"github.com/rclone/rclone/fs/hash"
@@ -31,17 +32,18 @@ func init() {
 
 // Fs represents a wrapped fs.Fs
 type Fs struct {
+	base.Writer // writes changes to the archive
 	f           fs.Fs
 	wrapper     fs.Fs
 	name        string
 	features    *fs.Features // optional features
 	vfs         *vfs.VFS
-	node        vfs.Node        // zip file object - set if reading
-	remote      string          // remote of the zip file object
-	prefix      string          // position for objects
-	prefixSlash string          // position for objects with a slash on
-	root        string          // position to read from within the archive
-	dt          dirtree.DirTree // read from zipfile
+	node        vfs.Node    // zip file object - nil if the archive doesn't exist yet
+	remote      string      // remote of the zip file object
+	prefix      string      // position for objects
+	root        string      // position to read from within the archive
+	in          vfs.Handle  // open handle on the zip file - set if reading - protected by Mu
+	zr          *zip.Reader // reader for the zip file - set if reading - protected by Mu
 }
 
 // New constructs an Fs from the (wrappedFs, remote) with the objects
@@ -54,23 +56,30 @@ func New(ctx context.Context, wrappedFs fs.Fs, remote, prefix, root string) (fs.
 	vfsOpt.ReadWait = 0
 	VFS := vfs.New(ctx, wrappedFs, &vfsOpt)
 	node, err := VFS.Stat(remote)
+	if errors.Is(err, vfs.ENOENT) {
+		// The archive will be created when it is written to
+		node, err = nil, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to find %q archive: %w", remote, err)
 	}
+	if node != nil && node.IsDir() {
+		return nil, fmt.Errorf("failed to open %q archive: %w", remote, fs.ErrorIsDir)
+	}
 
 	f := &Fs{
-		f:           wrappedFs,
-		name:        path.Join(fs.ConfigString(wrappedFs), remote),
-		vfs:         VFS,
-		node:        node,
-		remote:      remote,
-		root:        root,
-		prefix:      prefix,
-		prefixSlash: prefix + "/",
+		f:      wrappedFs,
+		name:   path.Join(fs.ConfigString(wrappedFs), remote),
+		vfs:    VFS,
+		node:   node,
+		remote: remote,
+		root:   root,
+		prefix: prefix,
 	}
+	f.Init(ctx, f, wrappedFs, remote, prefix, root)
 
 	// Read the contents of the zip file
-	singleObject, err := f.readZip()
+	err = f.readZip()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open zip file: %w", err)
 	}
@@ -89,7 +98,12 @@ func New(ctx context.Context, wrappedFs fs.Fs, remote, prefix, root string) (fs.
 		CanHaveEmptyDirectories: true,
 	}).Fill(ctx, f).Mask(ctx, wrappedFs).WrapsFs(f, wrappedFs)
 
-	if singleObject {
+	// These are done within the archive so enable them always
+	f.features.PutStream = f.PutStream
+	f.features.Move = f.Move
+	f.features.Shutdown = f.Shutdown
+
+	if f.SingleObject {
 		return f, fs.ErrorIsFile
 	}
 	return f, nil
@@ -117,24 +131,44 @@ func (f *Fs) String() string {
 
 // readZip the zip file into f
 //
-// Returns singleObject=true if f.root points to a file
-func (f *Fs) readZip() (singleObject bool, err error) {
+// This sets f.SingleObject if f.root points to a file.
+//
+// Call with f.Mu held or before f is in use
+func (f *Fs) readZip() (err error) {
+	if f.in != nil {
+		_ = f.in.Close()
+		f.in, f.zr = nil, nil
+	}
 	if f.node == nil {
-		return singleObject, fs.ErrorDirNotFound
+		// Archive doesn't exist yet so start with an empty one
+		f.DT = dirtree.New()
+		f.DT.AddDir(fs.NewDir(f.prefix, time.Now()))
+		f.DT[f.prefix] = nil
+		f.Missing = true
+		return nil
 	}
+	f.Missing = false
 	size := f.node.Size()
 	if size < 0 {
-		return singleObject, errors.New("can't read from zip file with unknown size")
+		return errors.New("can't read from zip file with unknown size")
 	}
 	r, err := f.node.Open(os.O_RDONLY)
 	if err != nil {
-		return singleObject, fmt.Errorf("failed to open zip file: %w", err)
+		return fmt.Errorf("failed to open zip file: %w", err)
 	}
 	zr, err := zip.NewReader(r, size)
 	if err != nil {
-		return singleObject, fmt.Errorf("failed to read zip file: %w", err)
+		_ = r.Close()
+		return fmt.Errorf("failed to read zip file: %w", err)
 	}
+	f.in, f.zr = r, zr
+	singleObject := false
 	dt := dirtree.New()
+	if f.root == "" {
+		// The root of the archive always exists
+		dt.AddDir(fs.NewDir(f.prefix, f.node.ModTime()))
+		dt[f.prefix] = nil
+	}
 	for _, file := range zr.File {
 		remote := strings.Trim(path.Clean(file.Name), "/")
 		if remote == "." {
@@ -143,7 +177,7 @@ func (f *Fs) readZip() (singleObject bool, err error) {
 		remote = path.Join(f.prefix, remote)
 		if f.root != "" {
 			// Ignore all files outside the root
-			if !strings.HasPrefix(remote, f.root) {
+			if !f.inRoot(remote) {
 				continue
 			}
 			if remote == f.root {
@@ -175,9 +209,15 @@ func (f *Fs) readZip() (singleObject bool, err error) {
 	}
 	dt.CheckParents("")
 	dt.Sort()
-	f.dt = dt
+	f.DT = dt
+	f.SingleObject = singleObject
 	//fs.Debugf(nil, "dt = %v", dt)
-	return singleObject, nil
+	return nil
+}
+
+// inRoot returns true if the archive path name is f.root or inside it
+func (f *Fs) inRoot(name string) bool {
+	return f.root == "" || name == f.root || strings.HasPrefix(name, f.root+"/")
 }
 
 // List the objects and directories in dir into entries.  The
@@ -195,10 +235,13 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	// if err != nil {
 	// 	return nil, err
 	// }
-	entries, ok := f.dt[dir]
+	f.Mu.Lock()
+	defer f.Mu.Unlock()
+	dirEntries, ok := f.DT[dir]
 	if !ok {
 		return nil, fs.ErrorDirNotFound
 	}
+	entries = append(fs.DirEntries(nil), dirEntries...)
 	fs.Debugf(f, "dir=%q, entries=%v", dir, entries)
 	return entries, nil
 }
@@ -206,10 +249,12 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 // NewObject finds the Object at remote.
 func (f *Fs) NewObject(ctx context.Context, remote string) (o fs.Object, err error) {
 	defer log.Trace(f, "remote=%q", remote)("obj=%v, err=%v", &o, &err)
-	if f.dt == nil {
+	f.Mu.Lock()
+	defer f.Mu.Unlock()
+	if f.DT == nil {
 		return nil, fs.ErrorObjectNotFound
 	}
-	_, entry := f.dt.Find(remote)
+	_, entry := f.DT.Find(remote)
 	if entry == nil {
 		return nil, fs.ErrorObjectNotFound
 	}
@@ -225,29 +270,6 @@ func (f *Fs) Precision() time.Duration {
 	return time.Second
 }
 
-// Mkdir makes the directory (container, bucket)
-//
-// Shouldn't return an error if it already exists
-func (f *Fs) Mkdir(ctx context.Context, dir string) error {
-	return vfs.EROFS
-}
-
-// Rmdir removes the directory (container, bucket) if empty
-//
-// Return an error if it doesn't exist or isn't empty
-func (f *Fs) Rmdir(ctx context.Context, dir string) error {
-	return vfs.EROFS
-}
-
-// Put in to the remote path with the modTime given of the given size
-//
-// May create the object even if it returns an error - if so
-// will return the object and the error, otherwise will return
-// nil and the error
-func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (o fs.Object, err error) {
-	return nil, vfs.EROFS
-}
-
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
 	return hash.Set(hash.CRC32)
@@ -273,7 +295,8 @@ type Object struct {
 	f      *Fs
 	remote string
 	fh     *zip.FileHeader
-	file   *zip.File
+	file   *zip.File   // set if the data is in the zip file on the remote
+	spool  *base.Spool // set if the data is in a local file waiting to be written
 }
 
 // Fs returns read only access to the Fs that this object is part of
@@ -296,6 +319,8 @@ func (o *Object) Remote() string {
 
 // Size returns the size of the file
 func (o *Object) Size() int64 {
+	o.f.Mu.Lock()
+	defer o.f.Mu.Unlock()
 	return int64(o.fh.UncompressedSize64)
 }
 
@@ -304,14 +329,11 @@ func (o *Object) Size() int64 {
 // It attempts to read the objects mtime and if that isn't present the
 // LastModified returned in the http headers
 func (o *Object) ModTime(ctx context.Context) time.Time {
+	o.f.Mu.Lock()
+	defer o.f.Mu.Unlock()
 	return o.fh.Modified
 }
 
-// SetModTime sets the modification time of the local fs object
-func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
-	return vfs.EROFS
-}
-
 // Storable raturns a boolean indicating if this object is storable
 func (o *Object) Storable() bool {
 	return true
@@ -321,8 +343,10 @@ func (o *Object) Storable() bool {
 // If no checksum is available it returns ""
 func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
 	if ht == hash.CRC32 {
+		o.f.Mu.Lock()
+		defer o.f.Mu.Unlock()
 		// FIXME return empty CRC if writing
-		if o.f.dt == nil {
+		if o.f.DT == nil {
 			return "", nil
 		}
 		return fmt.Sprintf("%08x", o.fh.CRC32), nil
@@ -346,7 +370,26 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		}
 	}
 
-	rc, err = o.file.Open()
+	// Don't write the archive while it is being read
+	o.f.Mu.Lock()
+	done := o.f.Busy()
+	spool, file := o.spool, o.file
+	o.f.Mu.Unlock()
+	defer func() {
+		if err != nil {
+			done()
+		}
+	}()
+
+	if spool != nil {
+		rc, err = spool.Open(offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		return base.ReadCloserWithDone(rc, done), nil
+	}
+
+	rc, err = file.Open()
 	if err != nil {
 		return nil, err
 	}
@@ -355,31 +398,25 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	if offset > 0 {
 		_, err = io.CopyN(io.Discard, rc, offset)
 		if err != nil {
+			_ = rc.Close()
 			return nil, err
 		}
 	}
 	// If limited then don't return everything
 	if limit >= 0 {
-		return readers.NewLimitedReadCloser(rc, limit), nil
+		rc = readers.NewLimitedReadCloser(rc, limit)
 	}
 
-	return rc, nil
-}
-
-// Update in to the object with the modTime given of the given size
-func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
-	return vfs.EROFS
-}
-
-// Remove an object
-func (o *Object) Remove(ctx context.Context) error {
-	return vfs.EROFS
+	return base.ReadCloserWithDone(rc, done), nil
 }
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs        = (*Fs)(nil)
-	_ fs.UnWrapper = (*Fs)(nil)
-	_ fs.Wrapper   = (*Fs)(nil)
-	_ fs.Object    = (*Object)(nil)
+	_ fs.Fs          = (*Fs)(nil)
+	_ fs.UnWrapper   = (*Fs)(nil)
+	_ fs.Wrapper     = (*Fs)(nil)
+	_ fs.PutStreamer = (*Fs)(nil)
+	_ fs.Mover       = (*Fs)(nil)
+	_ fs.Shutdowner  = (*Fs)(nil)
+	_ fs.Object      = (*Object)(nil)
 )
@@ -8,6 +8,7 @@ import (
 	"io"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/fs"
@@ -172,6 +173,34 @@ Setting suffix to "none" will result in an empty suffix. This may be useful
 when the path length is critical.`,
 			Default:  ".bin",
 			Advanced: true,
+		}, {
+			Name: "plaintext_hashes",
+			Help: `Store the hashes of the unencrypted data.
+
+If this is set then the MD5 and SHA-1 hashes of the unencrypted data
+are calculated as each file is uploaded and stored, encrypted, with
+the file. The remote can then report them so that rclone check,
+rclone sync --checksum and rclone cryptcheck can compare files without
+reading their data.
+
+Files uploaded before this is set, or by a crypt remote without it
+set, don't have hashes.`,
+			Default: plaintextHashesOff,
+			Examples: []fs.OptionExample{
+				{
+					Value: plaintextHashesOff,
+					Help:  "Don't store the hashes.",
+				},
+				{
+					Value: plaintextHashesMetadata,
+					Help:  "Store the hashes in the metadata of each file.\nThe remote must support setting metadata on existing files.",
+				},
+				{
+					Value: plaintextHashesSidecar,
+					Help:  "Store the hashes in a small file next to each file.\nThis works with any remote but not with filename_encryption obfuscate.",
+				},
+			},
+			Advanced: true,
 		}},
 	})
 }
@@ -206,6 +235,10 @@ func newCipherForConfig(opt *Options) (*Cipher, error) {
 	}
 	cipher.setEncryptedSuffix(opt.Suffix)
 	cipher.setPassBadBlocks(opt.PassBadBlocks)
+	err = checkPlaintextHashes(opt, cipher)
+	if err != nil {
+		return nil, err
+	}
 	return cipher, nil
 }
 
@@ -314,6 +347,7 @@ type Options struct {
 	FilenameEncoding        string `config:"filename_encoding"`
 	Suffix                  string `config:"suffix"`
 	StrictNames             bool   `config:"strict_names"`
+	PlaintextHashes         string `config:"plaintext_hashes"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -325,6 +359,8 @@ type Fs struct {
 	opt      Options
 	features *fs.Features // optional features
 	cipher   *Cipher
+
+	noSetMetadata sync.Once // to log once if the hashes can't be stored in metadata
 }
 
 // Name of the remote (as passed into NewFs)
@@ -350,6 +386,9 @@ func (f *Fs) String() string {
 // Encrypt an object file name to entries.
 func (f *Fs) add(entries *fs.DirEntries, obj fs.Object) error {
 	remote := obj.Remote()
+	if f.isSidecar(remote) {
+		return nil
+	}
 	decryptedRemote, err := f.cipher.DecryptFileName(remote)
 	if err != nil {
 		if f.opt.StrictNames {
@@ -505,11 +544,26 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 		return o, err
 	}
 
+	// Hash the unencrypted data if storing its hashes
+	var plainHasher *hash.MultiHasher
+	if f.opt.PlaintextHashes != plaintextHashesOff {
+		var err error
+		plainHasher, err = hash.NewMultiHasherTypes(plaintextHashTypes)
+		if err != nil {
+			return nil, err
+		}
+		var wrap accounting.WrapFn
+		in, wrap = accounting.UnWrap(in)
+		in = wrap(io.TeeReader(in, plainHasher))
+	}
+
 	// Encrypt the data into wrappedIn
 	wrappedIn, encrypter, err := f.cipher.encryptData(in)
 	if err != nil {
 		return nil, err
 	}
+	// Save the nonce as the encrypter increments it
+	initialNonce := encrypter.nonce
 
 	// Find a hash the destination supports to compute a hash of
 	// the encrypted data
@@ -533,14 +587,15 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Transfer the data
-	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce), options...)
+	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, initialNonce), options...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check the hashes of the encrypted data if we were comparing them
+	var srcHash string
 	if ht != hash.None && hasher != nil {
-		srcHash := hasher.Sums()[ht]
+		srcHash = hasher.Sums()[ht]
 		var dstHash string
 		dstHash, err = o.Hash(ctx, ht)
 		if err != nil {
@@ -559,7 +614,17 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 		}
 	}
 
-	return f.newObject(o), nil
+	newO := f.newObject(o)
+	if plainHasher != nil {
+		r := f.newHashRecord(ctx, o, plainHasher, ht, srcHash)
+		err = f.storeHashes(ctx, o, r)
+		if err != nil {
+			fs.Errorf(newO, "Failed to store plaintext hashes: %v", err)
+		} else {
+			newO.setHashes(r)
+		}
+	}
+	return newO, nil
 }
 
 // Put in to the remote path with the modTime given of the given size
@@ -578,7 +643,13 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
-	return hash.Set(hash.None)
+	switch {
+	case f.opt.PlaintextHashes == plaintextHashesOff:
+		return hash.Set(hash.None)
+	case f.opt.NoDataEncryption:
+		return f.Fs.Hashes()
+	}
+	return plaintextHashTypes
 }
 
 // Mkdir makes the directory (container, bucket)
@@ -623,7 +694,14 @@ func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) e
 //
 // Return an error if it doesn't exist or isn't empty
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
-	return f.Fs.Rmdir(ctx, f.cipher.EncryptDirName(dir))
+	encryptedDir := f.cipher.EncryptDirName(dir)
+	if f.opt.PlaintextHashes == plaintextHashesSidecar {
+		err := f.removeOrphanSidecars(ctx, encryptedDir)
+		if err != nil {
+			return err
+		}
+	}
+	return f.Fs.Rmdir(ctx, encryptedDir)
 }
 
 // Purge all files in the directory specified
@@ -658,10 +736,12 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if !ok {
 		return nil, fs.ErrorCantCopy
 	}
+	srcRemote := o.Object.Remote()
 	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
 	}
+	f.transferHashes(ctx, o.f, srcRemote, oResult.Remote(), do)
 	return f.newObject(oResult), nil
 }
 
@@ -683,10 +763,12 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if !ok {
 		return nil, fs.ErrorCantMove
 	}
+	srcRemote := o.Object.Remote()
 	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
 	}
+	f.transferHashes(ctx, o.f, srcRemote, oResult.Remote(), do)
 	return f.newObject(oResult), nil
 }
 
@@ -817,24 +899,33 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		return src.Hash(ctx, hashType)
 	}
 
+	nonce, err := o.readNonce(ctx)
+	if err != nil {
+		return "", err
+	}
+	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+}
+
+// readNonce reads the nonce from the header of the encrypted data
+func (o *Object) readNonce(ctx context.Context) (n nonce, err error) {
 	// Read the nonce - opening the file is sufficient to read the nonce in
 	// use a limited read so we only read the header
 	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
 	if err != nil {
-		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
+		return n, fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	d, err := f.cipher.newDecrypter(in)
+	d, err := o.f.cipher.newDecrypter(in)
 	if err != nil {
 		_ = in.Close()
-		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
+		return n, fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	nonce := d.nonce
-	// fs.Debugf(o, "Read nonce % 2x", nonce)
+	n = d.nonce
+	// fs.Debugf(o, "Read nonce % 2x", n)
 
 	// Check nonce isn't all zeros
 	isZero := true
-	for i := range nonce {
-		if nonce[i] != 0 {
+	for i := range n {
+		if n[i] != 0 {
 			isZero = false
 		}
 	}
@@ -845,10 +936,10 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 	// Close d (and hence in) once we have read the nonce
 	err = d.Close()
 	if err != nil {
-		return "", fmt.Errorf("failed to close nonce read: %w", err)
+		return n, fmt.Errorf("failed to close nonce read: %w", err)
 	}
 
-	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+	return n, nil
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -906,6 +997,9 @@ func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryT
 		case fs.EntryDirectory:
 			decrypted, err = f.cipher.DecryptDirName(path)
 		case fs.EntryObject:
+			if f.isSidecar(path) {
+				return
+			}
 			decrypted, err = f.cipher.DecryptFileName(path)
 		default:
 			fs.Errorf(path, "crypt ChangeNotify: ignoring unknown EntryType %d", entryType)
@@ -989,6 +1083,10 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 type Object struct {
 	fs.Object
 	f *Fs
+
+	mu         sync.Mutex
+	hashes     *hashRecord // plaintext hashes if read and found
+	hashesRead bool        // set if the plaintext hashes have been read
 }
 
 func (f *Fs) newObject(o fs.Object) *Object {
@@ -1038,7 +1136,31 @@ func (o *Object) Size() int64 {
 // Hash returns the selected checksum of the file
 // If no checksum is available it returns ""
 func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
-	return "", hash.ErrUnsupported
+	if !o.f.Hashes().Contains(ht) {
+		return "", hash.ErrUnsupported
+	}
+	if o.f.opt.NoDataEncryption {
+		return o.Object.Hash(ctx, ht)
+	}
+	r, err := o.readHashes(ctx)
+	if err != nil || r == nil {
+		return "", err
+	}
+	return r.hash(ht), nil
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	remote := o.Object.Remote()
+	err := o.Object.Remove(ctx)
+	if err != nil {
+		return err
+	}
+	err = o.f.removeHashes(ctx, remote)
+	if err != nil {
+		fs.Errorf(o, "Failed to remove plaintext hashes: %v", err)
+	}
+	return nil
 }
 
 // UnWrap returns the wrapped Object
@@ -1092,7 +1214,10 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	update := func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 		return o.Object, o.Object.Update(ctx, in, src, options...)
 	}
-	_, err := o.f.put(ctx, in, src, options, update)
+	newO, err := o.f.put(ctx, in, src, options, update)
+	if newO, ok := newO.(*Object); ok {
+		o.copyHashes(newO)
+	}
 	return err
 }
 
@@ -1280,7 +1405,13 @@ func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
 	if !ok {
 		return nil, nil
 	}
-	return do.Metadata(ctx)
+	metadata, err := do.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Don't leak the stored hashes to other remotes
+	delete(metadata, hashesMetadataKey)
+	return metadata, nil
 }
 
 // SetMetadata sets metadata for an Object
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha1"
 	"fmt"
 	"io"
 	"testing"
@@ -119,9 +120,84 @@ func testComputeHash(t *testing.T, f *Fs) {
 	assert.Equal(t, remoteObjHash, computedHash)
 }
 
+func testPlaintextHashes(t *testing.T, f *Fs) {
+	var (
+		contents = random.String(100)
+		path     = "plaintext_hashes_test"
+		ctx      = context.Background()
+	)
+	if f.opt.PlaintextHashes == plaintextHashesOff || f.opt.NoDataEncryption {
+		t.Skip("plaintext hashes not stored")
+	}
+
+	obj := uploadFile(t, f, path, contents)
+	md5sum := fmt.Sprintf("%x", md5.Sum([]byte(contents)))
+	sha1sum := fmt.Sprintf("%x", sha1.Sum([]byte(contents)))
+
+	// Read the hashes back from a fresh object
+	obj, err := f.NewObject(ctx, path)
+	require.NoError(t, err)
+	gotMD5, err := obj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, md5sum, gotMD5)
+	gotSHA1, err := obj.Hash(ctx, hash.SHA1)
+	require.NoError(t, err)
+	assert.Equal(t, sha1sum, gotSHA1)
+
+	// The stored hashes don't show in the metadata
+	metadata, err := fs.GetMetadata(ctx, obj)
+	require.NoError(t, err)
+	assert.NotContains(t, metadata, hashesMetadataKey)
+
+	// Overwrite the data without updating the hashes
+	underlying := obj.(*Object).Object
+	newContents := random.String(100)
+	in, err := f.cipher.EncryptData(bytes.NewBufferString(newContents))
+	require.NoError(t, err)
+	src := object.NewStaticObjectInfo(underlying.Remote(), time.Now(), f.cipher.EncryptedSize(int64(len(newContents))), true, nil, nil)
+	require.NoError(t, underlying.Update(ctx, in, src))
+
+	// The stale hashes are ignored
+	obj, err = f.NewObject(ctx, path)
+	require.NoError(t, err)
+	gotMD5, err = obj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, "", gotMD5)
+}
+
+func TestCheckPlaintextHashes(t *testing.T) {
+	for _, test := range []struct {
+		mode    NameEncryptionMode
+		suffix  string
+		hashes  string
+		wantErr string
+	}{
+		{NameEncryptionStandard, ".bin", plaintextHashesSidecar, ""},
+		{NameEncryptionStandard, ".bin", plaintextHashesMetadata, ""},
+		{NameEncryptionOff, ".bin", plaintextHashesSidecar, ""},
+		{NameEncryptionOff, "none", plaintextHashesSidecar, "suffix none"},
+		{NameEncryptionOff, ".hash", plaintextHashesSidecar, "suffix .hash"},
+		{NameEncryptionObfuscated, ".bin", plaintextHashesSidecar, "obfuscate"},
+		{NameEncryptionObfuscated, ".bin", plaintextHashesMetadata, ""},
+		{NameEncryptionStandard, ".bin", "potato", "unknown"},
+	} {
+		what := fmt.Sprintf("mode=%v suffix=%q hashes=%q", test.mode, test.suffix, test.hashes)
+		c, err := newCipher(test.mode, "", "", true, nil)
+		require.NoError(t, err)
+		c.setEncryptedSuffix(test.suffix)
+		err = checkPlaintextHashes(&Options{PlaintextHashes: test.hashes}, c)
+		if test.wantErr == "" {
+			assert.NoError(t, err, what)
+		} else {
+			assert.ErrorContains(t, err, test.wantErr, what)
+		}
+	}
+}
+
 // InternalTest is called by fstests.Run to extra tests
 func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("ObjectInfo", func(t *testing.T) { testObjectInfo(t, f, false) })
 	t.Run("ObjectInfoWrap", func(t *testing.T) { testObjectInfo(t, f, true) })
 	t.Run("ComputeHash", func(t *testing.T) { testComputeHash(t, f) })
+	t.Run("PlaintextHashes", func(t *testing.T) { testPlaintextHashes(t, f) })
 }
@@ -0,0 +1,348 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// Values for the plaintext_hashes option
+const (
+	plaintextHashesOff      = "off"
+	plaintextHashesMetadata = "metadata"
+	plaintextHashesSidecar  = "sidecar"
+)
+
+const (
+	hashesMetadataKey   = "rclone-crypt-hashes" // metadata key the hashes are stored in
+	hashesSidecarSuffix = ".hash"               // suffix added to the encrypted file name for sidecars
+	maxHashesSize       = 4096                  // the largest hash record we will read
+)
+
+// plaintextHashTypes are the hashes of the unencrypted data which are stored
+var plaintextHashTypes = hash.NewHashSet(hash.MD5, hash.SHA1)
+
+// hashRecord is the hashes of the unencrypted data of an object
+//
+// This is stored encrypted in the metadata of the object or in a
+// sidecar file. The size, modification time and hash of the encrypted
+// object tie the record to the encrypted data so it is ignored if the
+// object is overwritten without updating it. These can be checked
+// without reading the object.
+type hashRecord struct {
+	Size            int64     `json:"size"`
+	MD5             string    `json:"md5"`
+	SHA1            string    `json:"sha1"`
+	WrappedModTime  time.Time `json:"wrapped_mtime"`
+	WrappedHashType string    `json:"wrapped_hash_type,omitempty"`
+	WrappedHash     string    `json:"wrapped_hash,omitempty"`
+}
+
+// checkPlaintextHashes checks the plaintext_hashes option is valid
+func checkPlaintextHashes(opt *Options, cipher *Cipher) error {
+	switch opt.PlaintextHashes {
+	case plaintextHashesOff, plaintextHashesMetadata:
+	case plaintextHashesSidecar:
+		// Sidecars must not be confused with real files
+		switch cipher.NameEncryptionMode() {
+		case NameEncryptionObfuscated:
+			// Obfuscated names can end in the sidecar suffix
+			return errors.New("plaintext_hashes sidecar can't be used with filename_encryption obfuscate")
+		case NameEncryptionOff:
+			if cipher.encryptedSuffix == "" {
+				return errors.New("plaintext_hashes sidecar can't be used with filename_encryption off and suffix none")
+			}
+			if strings.EqualFold(cipher.encryptedSuffix, hashesSidecarSuffix) {
+				return fmt.Errorf("plaintext_hashes sidecar can't be used with suffix %s", hashesSidecarSuffix)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown plaintext_hashes %q - use off, metadata or sidecar", opt.PlaintextHashes)
+	}
+	return nil
+}
+
+// newHashRecord makes a hash record for the data hashed with hasher
+// which was uploaded as the wrapped object o.
+//
+// wrappedHash is the hash of type ht of the encrypted data, or "" if
+// not known.
+func (f *Fs) newHashRecord(ctx context.Context, o fs.Object, hasher *hash.MultiHasher, ht hash.Type, wrappedHash string) *hashRecord {
+	sums := hasher.Sums()
+	r := &hashRecord{
+		Size:           hasher.Size(),
+		MD5:            sums[hash.MD5],
+		SHA1:           sums[hash.SHA1],
+		WrappedModTime: o.ModTime(ctx),
+	}
+	// Slow hashes are expensive to check so only use the
+	// modification time for those
+	if wrappedHash != "" && !f.Fs.Features().SlowHash {
+		r.WrappedHashType = ht.String()
+		r.WrappedHash = wrappedHash
+	}
+	return r
+}
+
+// matches returns true if r was made for the wrapped object o
+func (f *Fs) matches(ctx context.Context, r *hashRecord, o fs.Object) bool {
+	if r.WrappedHash != "" {
+		var ht hash.Type
+		if err := ht.Set(r.WrappedHashType); err == nil {
+			wrappedHash, err := o.Hash(ctx, ht)
+			if err == nil && wrappedHash != "" {
+				return wrappedHash == r.WrappedHash
+			}
+		}
+	}
+	dt := o.ModTime(ctx).Sub(r.WrappedModTime)
+	if dt < 0 {
+		dt = -dt
+	}
+	return dt <= f.Fs.Precision()
+}
+
+// hash returns the hash of type ht or "" if not known
+func (r *hashRecord) hash(ht hash.Type) string {
+	switch ht {
+	case hash.MD5:
+		return r.MD5
+	case hash.SHA1:
+		return r.SHA1
+	}
+	return ""
+}
+
+// encryptHashRecord encrypts r into a blob
+func (f *Fs) encryptHashRecord(r *hashRecord) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	in, err := f.cipher.EncryptData(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(in)
+}
+
+// decryptHashRecord decrypts a blob made by encryptHashRecord
+func (f *Fs) decryptHashRecord(data []byte) (*hashRecord, error) {
+	in, err := f.cipher.DecryptData(io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	data, err = io.ReadAll(in)
+	_ = in.Close()
+	if err != nil {
+		return nil, err
+	}
+	r := new(hashRecord)
+	err = json.Unmarshal(data, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// sidecarRemote returns the remote of the sidecar of the wrapped
+// object at remote
+func sidecarRemote(remote string) string {
+	return remote + hashesSidecarSuffix
+}
+
+// isSidecar returns true if the wrapped object at remote is a sidecar
+func (f *Fs) isSidecar(remote string) bool {
+	return f.opt.PlaintextHashes == plaintextHashesSidecar && strings.HasSuffix(remote, hashesSidecarSuffix)
+}
+
+// storeHashes stores r for the wrapped object o
+func (f *Fs) storeHashes(ctx context.Context, o fs.Object, r *hashRecord) error {
+	data, err := f.encryptHashRecord(r)
+	if err != nil {
+		return err
+	}
+	switch f.opt.PlaintextHashes {
+	case plaintextHashesMetadata:
+		do, ok := o.(fs.SetMetadataer)
+		if ok {
+			err = do.SetMetadata(ctx, fs.Metadata{
+				hashesMetadataKey: base64.StdEncoding.EncodeToString(data),
+			})
+		}
+		if !ok || errors.Is(err, fs.ErrorNotImplemented) {
+			f.noSetMetadata.Do(func() {
+				fs.Logf(f, "Can't store plaintext hashes as %v can't set metadata - try plaintext_hashes sidecar", f.Fs)
+			})
+			return nil
+		}
+		return err
+	case plaintextHashesSidecar:
+		remote := sidecarRemote(o.Remote())
+		info := object.NewStaticObjectInfo(remote, o.ModTime(ctx), int64(len(data)), true, nil, f.Fs)
+		sidecar, err := f.Fs.NewObject(ctx, remote)
+		if err == nil {
+			return sidecar.Update(ctx, bytes.NewReader(data), info)
+		}
+		if !errors.Is(err, fs.ErrorObjectNotFound) {
+			return err
+		}
+		_, err = f.Fs.Put(ctx, bytes.NewReader(data), info)
+		return err
+	}
+	return nil
+}
+
+// loadHashes loads the hash record for the wrapped object o
+//
+// It returns nil if there isn't one.
+func (f *Fs) loadHashes(ctx context.Context, o fs.Object) (r *hashRecord, err error) {
+	var data []byte
+	switch f.opt.PlaintextHashes {
+	case plaintextHashesMetadata:
+		metadata, err := fs.GetMetadata(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := metadata[hashesMetadataKey]
+		if !ok {
+			return nil, nil
+		}
+		data, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			fs.Debugf(o, "Ignoring bad plaintext hashes: %v", err)
+			return nil, nil
+		}
+	case plaintextHashesSidecar:
+		var sidecar fs.Object
+		sidecar, err = f.Fs.NewObject(ctx, sidecarRemote(o.Remote()))
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var in io.ReadCloser
+		in, err = sidecar.Open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer fs.CheckClose(in, &err)
+		data, err = io.ReadAll(io.LimitReader(in, maxHashesSize))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	r, err = f.decryptHashRecord(data)
+	if err != nil {
+		fs.Debugf(o, "Ignoring bad plaintext hashes: %v", err)
+		return nil, nil
+	}
+	return r, nil
+}
+
+// removeHashes removes the sidecar of the wrapped object at remote if
+// there is one
+func (f *Fs) removeHashes(ctx context.Context, remote string) error {
+	if f.opt.PlaintextHashes != plaintextHashesSidecar {
+		return nil
+	}
+	sidecar, err := f.Fs.NewObject(ctx, sidecarRemote(remote))
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return sidecar.Remove(ctx)
+}
+
+// transferHashes copies or moves the sidecar of the wrapped object
+// at srcRemote on srcFs to the wrapped object at remote using do
+func (f *Fs) transferHashes(ctx context.Context, srcFs *Fs, srcRemote, remote string, do func(context.Context, fs.Object, string) (fs.Object, error)) {
+	if f.opt.PlaintextHashes != plaintextHashesSidecar || srcFs.opt.PlaintextHashes != plaintextHashesSidecar {
+		return
+	}
+	sidecar, err := srcFs.Fs.NewObject(ctx, sidecarRemote(srcRemote))
+	if err == nil {
+		_, err = do(ctx, sidecar, sidecarRemote(remote))
+	}
+	if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		fs.Errorf(srcRemote, "Failed to transfer plaintext hashes: %v", err)
+	}
+}
+
+// removeOrphanSidecars removes the sidecars in the wrapped directory
+// dir if there is nothing else in it so it can be removed
+func (f *Fs) removeOrphanSidecars(ctx context.Context, dir string) error {
+	entries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		// let Rmdir return the error
+		return nil
+	}
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Object); !ok || !f.isSidecar(entry.Remote()) {
+			return nil
+		}
+	}
+	for _, entry := range entries {
+		fs.Debugf(entry, "Removing orphaned plaintext hashes")
+		err = entry.(fs.Object).Remove(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHashes reads the plaintext hashes of the object
+//
+// It returns nil if they aren't stored or don't match the data.
+func (o *Object) readHashes(ctx context.Context) (*hashRecord, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.hashesRead {
+		return o.hashes, nil
+	}
+	r, err := o.f.loadHashes(ctx, o.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plaintext hashes: %w", err)
+	}
+	if r != nil && r.Size != o.Size() {
+		fs.Debugf(o, "Ignoring plaintext hashes for a different size %d", r.Size)
+		r = nil
+	}
+	if r != nil && !o.f.matches(ctx, r, o.Object) {
+		fs.Debugf(o, "Ignoring plaintext hashes as the data has been overwritten")
+		r = nil
+	}
+	o.hashes, o.hashesRead = r, true
+	return r, nil
+}
+
+// setHashes sets the plaintext hashes of the object
+func (o *Object) setHashes(r *hashRecord) {
+	o.mu.Lock()
+	o.hashes, o.hashesRead = r, true
+	o.mu.Unlock()
+}
+
+// copyHashes copies the plaintext hashes read by src to o
+func (o *Object) copyHashes(src *Object) {
+	src.mu.Lock()
+	r, read := src.hashes, src.hashesRead
+	src.mu.Unlock()
+	o.mu.Lock()
+	o.hashes, o.hashesRead = r, read
+	o.mu.Unlock()
+}
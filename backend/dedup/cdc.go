@@ -0,0 +1,147 @@
+package dedup
+
+// Content defined chunking
+//
+// This uses the FastCDC algorithm to split a stream into chunks whose
+// boundaries depend on the content, so inserting or removing data
+// only changes the chunks near the edit and the rest of the stream
+// still deduplicates against what is already stored.
+//
+// See "FastCDC: a Fast and Efficient Content-Defined Chunking
+// Approach for Data Deduplication" by Wen Xia et al.
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// gear is the table of random values used by the rolling hash
+//
+// This must never change as it determines where the chunk boundaries
+// are - changing it would stop new uploads deduplicating against
+// existing chunks.
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed
+	x := uint64(0x7263_6c6f_6e65_6364) // "rclonecd"
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// chunker splits a stream into content defined chunks
+type chunker struct {
+	in    io.Reader
+	min   int    // minimum chunk size
+	avg   int    // target average chunk size
+	max   int    // maximum chunk size
+	maskS uint64 // harder to match mask used before avg
+	maskL uint64 // easier to match mask used after avg
+	buf   []byte // buffered data
+	start int    // start of unread data in buf
+	end   int    // end of data in buf
+	eof   bool   // set if in has returned EOF
+}
+
+// errBadChunkSize is returned if the average chunk size isn't valid
+var errBadChunkSize = errors.New("chunk size must be a power of 2 and at least 256")
+
+// newChunker makes a chunker reading from in with an average chunk
+// size of avg which must be a power of two.
+func newChunker(in io.Reader, avg int) (*chunker, error) {
+	if avg < 256 || avg&(avg-1) != 0 {
+		return nil, errBadChunkSize
+	}
+	n := bits.TrailingZeros(uint(avg))
+	c := &chunker{
+		in:    in,
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 8,
+		maskS: mask(n + 1),
+		maskL: mask(n - 1),
+	}
+	c.buf = make([]byte, c.max)
+	return c, nil
+}
+
+// mask returns a mask with the top n bits set
+//
+// The top bits are used as they depend on the most input bytes.
+func mask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// fill reads into the buffer until it holds at least c.max bytes or
+// the input is exhausted
+func (c *chunker) fill() error {
+	if c.start > 0 {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+	}
+	for !c.eof && c.end < len(c.buf) {
+		n, err := c.in.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the first chunk in data
+func (c *chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
+
+// next returns the next chunk or io.EOF if there are no more
+//
+// The data returned is only valid until the next call.
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < c.max && !c.eof {
+		err := c.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := c.cut(data)
+	c.start += n
+	return data[:n], nil
+}
@@ -0,0 +1,55 @@
+package dedup
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/fs"
+)
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "gc",
+	Short: "Delete chunks which are no longer referenced.",
+	Long: `Delete the chunks which aren't used by any file.
+
+Chunks aren't deleted when files are deleted or overwritten as they
+may be shared with other files. Instead rclone keeps a count of the
+references to each chunk and this command deletes those with none.
+
+If rclone didn't shut down cleanly while writing to the remote then
+the reference counts may be wrong so they will be counted again from
+all the files in the remote first. Use ` + "`-o full`" + ` to force this.
+
+This should not be run while anything else is writing to the remote.
+It obeys ` + "`--dry-run`" + `.
+
+Usage examples:
+
+` + "```console" + `
+rclone backend gc dedup:
+rclone backend gc -o full dedup:
+` + "```" + `
+
+It returns a summary of the chunks found and deleted.`,
+	Opts: map[string]string{
+		"full": "Count the references to each chunk from all the files first.",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out any, err error) {
+	switch name {
+	case "gc":
+		_, full := opt["full"]
+		return f.repo.gc(ctx, full)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
@@ -0,0 +1,486 @@
+// Package dedup implements a deduplicating overlay backend
+//
+// Files are split into chunks with content defined chunking. Each
+// chunk is stored once, named by its hash, and each file is stored as
+// a manifest listing its chunks.
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "dedup",
+		Description: "Deduplicate files stored on other remotes",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name:     "remote",
+			Required: true,
+			Help: `Remote to store the deduplicated files in (e.g. myRemote:path).
+
+Normally should contain a ':' and a path, e.g. "myremote:path/to/dir",
+"myremote:bucket" or maybe "myremote:" (not recommended).`,
+		}, {
+			Name: "chunk_size",
+			Help: `Average size of the chunks files are split into.
+
+Files are split at points depending on their contents so that
+identical data is stored once even if it is at different offsets in
+different files. Chunks will be between a quarter of this and 8 times
+this in size.
+
+Smaller chunks find more duplicate data but mean more objects to
+store on the remote. This must be a power of 2.
+
+Changing this on an existing remote works, but new files will share
+fewer chunks with files uploaded before the change.`,
+			Default:  fs.SizeSuffix(1024 * 1024),
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote    string        `config:"remote"`
+	ChunkSize fs.SizeSuffix `config:"chunk_size"`
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features // optional features
+	files    fs.Fs        // where the manifests for this root are stored
+	repo     *repo        // shared state for the repository
+	wrapper  fs.Fs
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs, error) {
+	opt := Options{}
+	err := configstruct.Set(m, &opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point dedup remote at itself - check the value of the remote setting")
+	}
+	if opt.ChunkSize < 256 || opt.ChunkSize&(opt.ChunkSize-1) != 0 {
+		return nil, errBadChunkSize
+	}
+	r, err := getRepo(ctx, opt.Remote)
+	if err != nil {
+		return nil, err
+	}
+	filesRoot := fspath.JoinRootPath(opt.Remote, filesDir)
+	files, err := cache.Get(ctx, fspath.JoinRootPath(filesRoot, rpath))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", filesRoot, err)
+	}
+	f := &Fs{
+		name:  name,
+		root:  rpath,
+		opt:   opt,
+		files: files,
+		repo:  r,
+	}
+	// Correct root if definitely pointing to a file
+	if err == fs.ErrorIsFile {
+		f.root = path.Dir(f.root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+	}
+	cache.PinUntilFinalized(f.files, f)
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		ReadMimeType:            false,
+		WriteMimeType:           false,
+		BucketBased:             true,
+		CanHaveEmptyDirectories: true,
+		SetTier:                 false,
+		GetTier:                 false,
+	}).Fill(ctx, f).Mask(ctx, files).WrapsFs(f, files)
+	// Data is written to the chunks not the manifests so enable these always
+	f.features.PutStream = f.PutStream
+	f.features.Shutdown = f.Shutdown
+	return f, err
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("Dedup '%s:%s'", f.name, f.root)
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return f.files.Precision()
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.NewHashSet(hash.MD5, hash.SHA1)
+}
+
+// wrapEntries wraps the manifests into Objects
+func (f *Fs) wrapEntries(entries fs.DirEntries) fs.DirEntries {
+	for i, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			entries[i] = f.newObject(o, nil)
+		}
+	}
+	return entries
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = f.files.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries), nil
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+//
+// Don't implement this unless you have a more efficient way
+// of listing recursively that doing a directory traversal.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	return f.files.Features().ListR(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.files.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, nil), nil
+}
+
+// upload splits in into chunks, uploads any which aren't stored and
+// returns the manifest for the data.
+//
+// It takes a reference on each chunk which the caller should release
+// if the manifest isn't written.
+//
+// Call with f.repo.gcMu held for reading.
+func (f *Fs) upload(ctx context.Context, in io.Reader, src fs.ObjectInfo) (m *manifest, err error) {
+	hasher, err := hash.NewMultiHasherTypes(f.Hashes())
+	if err != nil {
+		return nil, err
+	}
+	c, err := newChunker(io.TeeReader(in, hasher), int(f.opt.ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	m = &manifest{Version: manifestVersion}
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		h := hex.EncodeToString(sum[:])
+		err = f.repo.putChunk(ctx, h, data)
+		if err != nil {
+			return nil, err
+		}
+		m.Chunks = append(m.Chunks, chunkRef{Hash: h, Size: int64(len(data))})
+		m.Size += int64(len(data))
+	}
+	if size := src.Size(); size >= 0 && size != m.Size {
+		return nil, fmt.Errorf("dedup: upload size mismatch: read %d bytes but expected %d", m.Size, size)
+	}
+	sums := hasher.Sums()
+	m.MD5 = sums[hash.MD5]
+	m.SHA1 = sums[hash.SHA1]
+	err = f.repo.addRefs(ctx, m.hashes(), 1)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeManifest writes m as the manifest for src to o or a new object if o is nil
+func (f *Fs) writeManifest(ctx context.Context, o fs.Object, m *manifest, src fs.ObjectInfo) (fs.Object, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	info := object.NewStaticObjectInfo(src.Remote(), src.ModTime(ctx), int64(len(data)), true, nil, f.files)
+	if o != nil {
+		err = o.Update(ctx, bytes.NewReader(data), info)
+	} else {
+		o, err = f.files.Put(ctx, bytes.NewReader(data), info)
+	}
+	if err != nil {
+		// Release the references to the chunks
+		if refErr := f.repo.addRefs(ctx, m.hashes(), -1); refErr != nil {
+			fs.Errorf(f, "Failed to release chunk references: %v", refErr)
+		}
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return o, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	existing, err := f.NewObject(ctx, src.Remote())
+	switch err {
+	case nil:
+		return existing, existing.Update(ctx, in, src, options...)
+	case fs.ErrorObjectNotFound:
+		// Not found so create it
+	default:
+		return nil, err
+	}
+	f.repo.gcMu.RLock()
+	defer f.repo.gcMu.RUnlock()
+	m, err := f.upload(ctx, in, src)
+	if err != nil {
+		return nil, err
+	}
+	o, err := f.writeManifest(ctx, nil, m, src)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, m), nil
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.files.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return f.files.Rmdir(ctx, dir)
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.files.Features().Copy
+	srcObj, ok := src.(*Object)
+	if do == nil || !ok || srcObj.f.repo != f.repo {
+		return nil, fs.ErrorCantCopy
+	}
+	m, err := srcObj.readManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := f.NewObject(ctx, remote)
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return nil, err
+	}
+	f.repo.gcMu.RLock()
+	defer f.repo.gcMu.RUnlock()
+	err = f.repo.addRefs(ctx, m.hashes(), 1)
+	if err != nil {
+		return nil, err
+	}
+	o, err := do(ctx, srcObj.o, remote)
+	if err != nil {
+		if refErr := f.repo.addRefs(ctx, m.hashes(), -1); refErr != nil {
+			fs.Errorf(f, "Failed to release chunk references: %v", refErr)
+		}
+		return nil, err
+	}
+	f.release(ctx, existing)
+	return f.newObject(o, m), nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.files.Features().Move
+	srcObj, ok := src.(*Object)
+	if do == nil || !ok || srcObj.f.repo != f.repo {
+		return nil, fs.ErrorCantMove
+	}
+	existing, err := f.NewObject(ctx, remote)
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return nil, err
+	}
+	o, err := do(ctx, srcObj.o, remote)
+	if err != nil {
+		return nil, err
+	}
+	f.release(ctx, existing)
+	return f.newObject(o, srcObj.m), nil
+}
+
+// release drops the references to the chunks of an object which has
+// been overwritten
+func (f *Fs) release(ctx context.Context, existing fs.Object) {
+	o, ok := existing.(*Object)
+	if !ok || o == nil {
+		return
+	}
+	m, err := o.readManifest(ctx)
+	if err == nil {
+		err = f.repo.addRefs(ctx, m.hashes(), -1)
+	}
+	if err != nil {
+		fs.Errorf(o, "Failed to release chunk references of overwritten file: %v", err)
+	}
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.files.Features().DirMove
+	srcFs, ok := src.(*Fs)
+	if do == nil || !ok || srcFs.repo != f.repo {
+		return fs.ErrorCantDirMove
+	}
+	return do(ctx, srcFs.files, srcRemote, dstRemote)
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	if do := f.files.Features().DirCacheFlush; do != nil {
+		do()
+	}
+}
+
+// Shutdown the backend, saving the chunk reference counts.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	err := f.repo.save(ctx)
+	if do := f.files.Features().Shutdown; do != nil {
+		if err2 := do(ctx); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.files
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.Wrapper         = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+)
@@ -0,0 +1,204 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunk data returning the sizes of the chunks
+func chunkSizes(t *testing.T, data []byte, avg int) (sizes []int) {
+	c, err := newChunker(bytes.NewReader(data), avg)
+	require.NoError(t, err)
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		sizes = append(sizes, len(chunk))
+	}
+	return sizes
+}
+
+func TestChunker(t *testing.T) {
+	_, err := newChunker(nil, 1000)
+	assert.Equal(t, errBadChunkSize, err)
+
+	assert.Nil(t, chunkSizes(t, nil, 256))
+	assert.Equal(t, []int{10}, chunkSizes(t, make([]byte, 10), 256))
+
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	sizes := chunkSizes(t, data, 4096)
+	total := 0
+	for _, size := range sizes {
+		assert.GreaterOrEqual(t, size, 1024)
+		assert.LessOrEqual(t, size, 8*4096)
+		total += size
+	}
+	assert.Equal(t, len(data), total)
+	assert.Greater(t, len(sizes), 16)
+
+	// The chunk boundaries must never change or data written
+	// with older versions won't deduplicate
+	assert.Equal(t, []int{6717, 7833, 1179, 6059, 4834}, sizes[:5])
+
+	// Inserting data at the start should only change the first chunk
+	shifted := append([]byte("hello"), data...)
+	shiftedSizes := chunkSizes(t, shifted, 4096)
+	assert.Equal(t, sizes[0]+5, shiftedSizes[0])
+	assert.Equal(t, sizes[1:], shiftedSizes[1:])
+}
+
+// count the chunks stored in the repository
+func countChunks(t *testing.T, dir string) (n int) {
+	err := filepath.Walk(filepath.Join(dir, chunksDir), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func TestDedupGC(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := NewFs(ctx, "TestDedupGC", "", configmap.Simple{
+		"remote":     dir,
+		"chunk_size": "1k",
+	})
+	require.NoError(t, err)
+	d := f.(*Fs)
+	t1 := fstest.Time("2001-02-03T04:05:06Z")
+
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	put := func(remote string, data []byte) fs.Object {
+		src := object.NewStaticObjectInfo(remote, t1, int64(len(data)), true, nil, nil)
+		o, err := f.Put(ctx, bytes.NewReader(data), src)
+		require.NoError(t, err)
+		return o
+	}
+
+	// Store two nearly identical files
+	o1 := put("one", data)
+	n1 := countChunks(t, dir)
+	o2 := put("two", append([]byte("header"), data...))
+	n2 := countChunks(t, dir)
+	assert.Less(t, n2-n1, n1/2, "second file should share most chunks")
+	got, err := readObject(ctx, o2)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("header"), data...), got)
+
+	// Removing one file shouldn't free the shared chunks
+	m1, err := o1.(*Object).readManifest(ctx)
+	require.NoError(t, err)
+	m2, err := o2.(*Object).readManifest(ctx)
+	require.NoError(t, err)
+	unique := map[string]struct{}{}
+	for _, h := range m1.hashes() {
+		unique[h] = struct{}{}
+	}
+	for _, h := range m2.hashes() {
+		delete(unique, h)
+	}
+	require.NoError(t, o1.Remove(ctx))
+	stats, err := d.repo.gc(ctx, false)
+	require.NoError(t, err)
+	assert.False(t, stats.Recounted)
+	assert.Equal(t, int64(n2), stats.Chunks)
+	assert.Equal(t, int64(len(unique)), stats.Deleted)
+	got, err = readObject(ctx, o2)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("header"), data...), got)
+
+	// A full gc should agree with the reference counts
+	stats, err = d.repo.gc(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, stats.Recounted)
+	assert.Equal(t, int64(1), stats.Files)
+	assert.Equal(t, int64(0), stats.Deleted)
+
+	// Removing the other should free everything
+	require.NoError(t, o2.Remove(ctx))
+	stats, err = d.repo.gc(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, stats.Chunks, stats.Deleted)
+	assert.Equal(t, 0, countChunks(t, dir))
+
+	// Simulate rclone not shutting down after a write
+	put("three", data)
+	d.repo.mu.Lock()
+	d.repo.loaded = false
+	d.repo.mu.Unlock()
+	stats, err = d.repo.gc(ctx, false)
+	require.NoError(t, err)
+	assert.True(t, stats.Recounted)
+	assert.Equal(t, int64(0), stats.Deleted)
+
+	require.NoError(t, d.Shutdown(ctx))
+}
+
+// Check that chunks deleted by gc from another rclone are uploaded again
+func TestDedupGCOtherRepo(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := NewFs(ctx, "TestDedupGCOtherRepo", "", configmap.Simple{
+		"remote":     dir,
+		"chunk_size": "1k",
+	})
+	require.NoError(t, err)
+	d := f.(*Fs)
+	t1 := fstest.Time("2001-02-03T04:05:06Z")
+
+	data := make([]byte, 16*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	put := func(remote string) fs.Object {
+		src := object.NewStaticObjectInfo(remote, t1, int64(len(data)), true, nil, nil)
+		o, err := f.Put(ctx, bytes.NewReader(data), src)
+		require.NoError(t, err)
+		return o
+	}
+
+	// Write a file then delete it leaving its chunks unreferenced
+	o := put("one")
+	n := countChunks(t, dir)
+	assert.Len(t, d.repo.known, n)
+	require.NoError(t, o.Remove(ctx))
+	require.NoError(t, d.repo.save(ctx))
+	assert.Empty(t, d.repo.known, "unreferenced chunks should be forgotten")
+
+	// Run gc from a second repo as if from another rclone
+	other := &repo{
+		base:   d.repo.base,
+		chunks: d.repo.chunks,
+	}
+	stats, err := other.gc(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(n), stats.Deleted)
+	assert.Equal(t, 0, countChunks(t, dir))
+
+	// Writing the same data again must upload the chunks again
+	o = put("two")
+	assert.Equal(t, n, countChunks(t, dir))
+	got, err := readObject(ctx, o)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	require.NoError(t, d.Shutdown(ctx))
+}
@@ -0,0 +1,57 @@
+// Test Dedup filesystem interface
+package dedup_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/backend/dedup"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	opt := fstests.Opt{
+		RemoteName: *fstest.RemoteName,
+		NilObject:  (*dedup.Object)(nil),
+		UnimplementableFsMethods: []string{
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"MergeDirs",
+			"PutUnchecked",
+			"Purge",
+			"CleanUp",
+			"About",
+			"UserInfo",
+			"Disconnect",
+			"ChangeNotify",
+			"PublicLink",
+			"DirSetModTime",
+			"MkdirMetadata",
+			"ListP",
+		},
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+			"SetMetadata",
+			"ID",
+			"UnWrap",
+		},
+	}
+	if *fstest.RemoteName == "" {
+		tempDir := filepath.Join(os.TempDir(), "rclone-dedup-test")
+		opt.ExtraConfig = []fstests.ExtraConfigItem{
+			{Name: "TestDedup", Key: "type", Value: "dedup"},
+			{Name: "TestDedup", Key: "remote", Value: tempDir},
+			{Name: "TestDedup", Key: "chunk_size", Value: "1k"},
+		}
+		opt.RemoteName = "TestDedup:"
+		opt.QuickTestOK = true
+	}
+	fstests.Run(t, &opt)
+}
@@ -0,0 +1,338 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	fshash "github.com/rclone/rclone/fs/hash"
+)
+
+// manifestVersion should be incremented if the manifest format changes
+const manifestVersion = 1
+
+// A chunk of a file
+type chunkRef struct {
+	Hash string `json:"h"` // SHA-256 of the chunk in hex
+	Size int64  `json:"s"` // size of the chunk
+}
+
+// The manifest stored for each file
+type manifest struct {
+	Version int        `json:"ver"`
+	Size    int64      `json:"size"`
+	MD5     string     `json:"md5,omitempty"`
+	SHA1    string     `json:"sha1,omitempty"`
+	Chunks  []chunkRef `json:"chunks"`
+}
+
+// decodeManifest decodes a manifest checking its version
+func decodeManifest(data []byte) (*manifest, error) {
+	m := new(manifest)
+	err := json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// hashes returns the hash of each chunk in the manifest
+func (m *manifest) hashes() []string {
+	hashes := make([]string, len(m.Chunks))
+	for i, c := range m.Chunks {
+		hashes[i] = c.Hash
+	}
+	return hashes
+}
+
+// Object describes a file stored as chunks
+type Object struct {
+	f *Fs
+	o fs.Object // the manifest
+
+	mu sync.Mutex // protects m
+	m  *manifest  // read on demand
+}
+
+// newObject makes an Object from the manifest object o and m if known
+func (f *Fs) newObject(o fs.Object, m *manifest) *Object {
+	return &Object{
+		f: f,
+		o: o,
+		m: m,
+	}
+}
+
+// readManifest reads the manifest if it hasn't been read yet
+func (o *Object) readManifest(ctx context.Context) (*manifest, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.m != nil {
+		return o.m, nil
+	}
+	data, err := readObject(ctx, o.o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	o.m = m
+	return m, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Remote()
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.o.Remote()
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (o *Object) Hash(ctx context.Context, ht fshash.Type) (string, error) {
+	if ht != fshash.MD5 && ht != fshash.SHA1 {
+		return "", fshash.ErrUnsupported
+	}
+	m, err := o.readManifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ht == fshash.MD5 {
+		return m.MD5, nil
+	}
+	return m.SHA1, nil
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	m, err := o.readManifest(context.TODO())
+	if err != nil {
+		fs.Errorf(o, "Failed to read size: %v", err)
+		return -1
+	}
+	return m.Size
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.o.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return o.o.SetModTime(ctx, modTime)
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	m, err := o.readManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(m.Size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if offset > m.Size {
+		offset = m.Size
+	}
+	if limit < 0 || offset+limit > m.Size {
+		limit = m.Size - offset
+	}
+	return &chunkReader{
+		ctx:       ctx,
+		repo:      o.f.repo,
+		chunks:    m.Chunks,
+		offset:    offset,
+		remaining: limit,
+	}, nil
+}
+
+// chunkReader reads a range of a file from its chunks
+type chunkReader struct {
+	ctx       context.Context
+	repo      *repo
+	chunks    []chunkRef    // chunks still to read
+	offset    int64         // offset to read from in chunks[0]
+	remaining int64         // bytes still to read
+	in        io.ReadCloser // current chunk if open
+	left      int64         // bytes left to read in the current chunk
+	hasher    hash.Hash     // set if reading the whole of the current chunk
+	want      string        // expected hash of the current chunk
+}
+
+// openNext opens the next chunk which has data to read
+func (r *chunkReader) openNext() error {
+	for len(r.chunks) > 0 && r.offset >= r.chunks[0].Size {
+		r.offset -= r.chunks[0].Size
+		r.chunks = r.chunks[1:]
+	}
+	if len(r.chunks) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	c := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	var options []fs.OpenOption
+	n := c.Size - r.offset
+	if n > r.remaining {
+		n = r.remaining
+	}
+	whole := r.offset == 0 && n == c.Size
+	if !whole {
+		options = append(options, &fs.RangeOption{Start: r.offset, End: r.offset + n - 1})
+	}
+	in, err := r.repo.openChunk(r.ctx, c.Hash, options...)
+	if err != nil {
+		return err
+	}
+	r.in = in
+	r.left = n
+	r.hasher = nil
+	if whole {
+		r.hasher = sha256.New()
+		r.want = c.Hash
+	}
+	r.offset = 0
+	return nil
+}
+
+// closeCurrent closes the current chunk checking its hash if possible
+func (r *chunkReader) closeCurrent(finished bool) error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	if err == nil && finished && r.hasher != nil {
+		if got := hex.EncodeToString(r.hasher.Sum(nil)); got != r.want {
+			err = fmt.Errorf("corrupted chunk %s: hash is %s", r.want, got)
+		}
+	}
+	return err
+}
+
+// Read bytes from the file
+func (r *chunkReader) Read(p []byte) (n int, err error) {
+	for n == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if r.in == nil {
+			err = r.openNext()
+			if err != nil {
+				return 0, err
+			}
+		}
+		if int64(len(p)) > r.left {
+			p = p[:r.left]
+		}
+		n, err = r.in.Read(p)
+		if r.hasher != nil {
+			_, _ = r.hasher.Write(p[:n])
+		}
+		r.remaining -= int64(n)
+		r.left -= int64(n)
+		if r.left == 0 {
+			err = r.closeCurrent(true)
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close the reader
+func (r *chunkReader) Close() error {
+	return r.closeCurrent(false)
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	old, err := o.readManifest(ctx)
+	if err != nil {
+		fs.Errorf(o, "Failed to read old manifest - its chunks won't be freed until gc is run with -o full: %v", err)
+		old = nil
+	}
+	o.f.repo.gcMu.RLock()
+	defer o.f.repo.gcMu.RUnlock()
+	m, err := o.f.upload(ctx, in, src)
+	if err != nil {
+		return err
+	}
+	_, err = o.f.writeManifest(ctx, o.o, m, src)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.m = m
+	o.mu.Unlock()
+	if old != nil {
+		err = o.f.repo.addRefs(ctx, old.hashes(), -1)
+		if err != nil {
+			fs.Errorf(o, "Failed to release chunk references: %v", err)
+		}
+	}
+	return nil
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	o.f.repo.gcMu.RLock()
+	defer o.f.repo.gcMu.RUnlock()
+	m, err := o.readManifest(ctx)
+	if err != nil {
+		fs.Errorf(o, "Failed to read manifest - its chunks won't be freed until gc is run with -o full: %v", err)
+		m = nil
+	}
+	err = o.o.Remove(ctx)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		err = o.f.repo.addRefs(ctx, m.hashes(), -1)
+		if err != nil {
+			fs.Errorf(o, "Failed to release chunk references: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package dedup
+
+// The repository is the directory on the underlying remote holding
+//
+//	files/     - a manifest for each file with the same name as the file
+//	chunks/    - the chunks named by their SHA-256 hash
+//	refs.json  - the number of references to each chunk
+//
+// All the Fs using the same repository share a repo so the reference
+// counts are kept consistent.
+//
+// The reference counts are kept in memory and written back on
+// Shutdown. The first change in a session writes refs.json marked as
+// dirty so if rclone doesn't shut down cleanly gc knows the counts
+// can't be trusted and recounts them from the manifests.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+const (
+	filesDir  = "files"
+	chunksDir = "chunks"
+	refsName  = "refs.json"
+)
+
+// refsVersion should be incremented if the refs format changes
+const refsVersion = 1
+
+// The contents of refs.json
+type refsFile struct {
+	Version int              `json:"ver"`
+	Dirty   bool             `json:"dirty"` // set if the counts may be wrong
+	Refs    map[string]int64 `json:"refs"`  // chunk hash to number of references
+}
+
+// A repository of chunks and manifests
+type repo struct {
+	base   fs.Fs        // root of the repository
+	chunks fs.Fs        // where the chunks are stored
+	gcMu   sync.RWMutex // held for reading while writing, for writing while running gc
+
+	mu          sync.Mutex       // protects the below
+	loaded      bool             // set if refs has been read
+	refs        map[string]int64 // chunk hash to number of references
+	dirty       bool             // set if refs has changed since saved
+	markedDirty bool             // set if refs.json is marked as dirty
+	untrusted   bool             // set if refs.json was dirty when read
+	known       map[string]bool  // chunks known to be stored
+}
+
+var (
+	reposMu sync.Mutex
+	repos   = map[string]*repo{}
+)
+
+// getRepo returns the repo for the remote, making it if necessary
+func getRepo(ctx context.Context, remote string) (*repo, error) {
+	reposMu.Lock()
+	defer reposMu.Unlock()
+	if r, ok := repos[remote]; ok {
+		return r, nil
+	}
+	base, err := cache.Get(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make repository remote %q: %w", remote, err)
+	}
+	chunks, err := cache.Get(ctx, fspath.JoinRootPath(remote, chunksDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make chunks remote: %w", err)
+	}
+	r := &repo{
+		base:   base,
+		chunks: chunks,
+	}
+	repos[remote] = r
+	return r, nil
+}
+
+// readFile reads all of remote from f
+func readFile(ctx context.Context, f fs.Fs, remote string) ([]byte, error) {
+	o, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return readObject(ctx, o)
+}
+
+// readObject reads all of o
+func readObject(ctx context.Context, o fs.Object) (data []byte, err error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	return io.ReadAll(in)
+}
+
+// writeFile writes data to remote on f replacing any existing file
+func writeFile(ctx context.Context, f fs.Fs, remote string, data []byte, modTime time.Time) (fs.Object, error) {
+	info := object.NewStaticObjectInfo(remote, modTime, int64(len(data)), true, nil, f)
+	o, err := f.NewObject(ctx, remote)
+	if err == nil {
+		return o, o.Update(ctx, bytes.NewReader(data), info)
+	} else if err != fs.ErrorObjectNotFound {
+		return nil, err
+	}
+	return f.Put(ctx, bytes.NewReader(data), info)
+}
+
+// load reads the reference counts if they haven't been read yet
+//
+// Call with r.mu held
+func (r *repo) load(ctx context.Context) error {
+	if r.loaded {
+		return nil
+	}
+	data, err := readFile(ctx, r.base, refsName)
+	if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound) {
+		r.refs = map[string]int64{}
+		r.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", refsName, err)
+	}
+	var refs refsFile
+	err = json.Unmarshal(data, &refs)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", refsName, err)
+	}
+	if refs.Version != refsVersion {
+		return fmt.Errorf("unsupported %s version %d", refsName, refs.Version)
+	}
+	if refs.Refs == nil {
+		refs.Refs = map[string]int64{}
+	}
+	for h, n := range refs.Refs {
+		if n <= 0 {
+			delete(refs.Refs, h)
+		}
+	}
+	r.refs = refs.Refs
+	r.markedDirty = refs.Dirty
+	r.untrusted = refs.Dirty
+	r.loaded = true
+	return nil
+}
+
+// write refs.json marking it dirty or not
+//
+// Call with r.mu held
+func (r *repo) write(ctx context.Context, dirty bool) error {
+	data, err := json.Marshal(refsFile{
+		Version: refsVersion,
+		Dirty:   dirty,
+		Refs:    r.refs,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writeFile(ctx, r.base, refsName, data, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", refsName, err)
+	}
+	r.markedDirty = dirty
+	return nil
+}
+
+// addRefs adds delta to the reference count of each chunk in hashes
+func (r *repo) addRefs(ctx context.Context, hashes []string, delta int64) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.load(ctx)
+	if err != nil {
+		return err
+	}
+	if !r.markedDirty {
+		err = r.write(ctx, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range hashes {
+		n := r.refs[h] + delta
+		if n < 0 {
+			fs.Debugf(r.base, "Reference count for chunk %s went negative", h)
+			n = 0
+		}
+		if n == 0 {
+			// Don't keep unreferenced chunks as gc may delete them
+			delete(r.refs, h)
+			delete(r.known, h)
+		} else {
+			r.refs[h] = n
+		}
+	}
+	r.dirty = true
+	return nil
+}
+
+// save writes the reference counts back if they have changed
+func (r *repo) save(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.dirty {
+		return nil
+	}
+	err := r.write(ctx, false)
+	if err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// chunkPath returns the path of the chunk with hash h
+func chunkPath(h string) string {
+	return path.Join(h[:2], h)
+}
+
+// isKnown returns true if the chunk with hash h is known to be stored
+func (r *repo) isKnown(h string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.known[h]
+}
+
+// setKnown notes that the chunk with hash h is stored
+func (r *repo) setKnown(h string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known == nil {
+		r.known = make(map[string]bool)
+	}
+	r.known[h] = true
+}
+
+// putChunk uploads the chunk unless it is already stored
+//
+// Chunks which this rclone has seen are remembered so they are only
+// looked for on the remote once. They are forgotten when they stop
+// being referenced as gc, possibly run by another rclone, may then
+// delete them.
+func (r *repo) putChunk(ctx context.Context, h string, data []byte) error {
+	if r.isKnown(h) {
+		return nil
+	}
+	remote := chunkPath(h)
+	_, err := r.chunks.NewObject(ctx, remote)
+	if err == nil {
+		r.setKnown(h)
+		return nil
+	}
+	if !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorDirNotFound) {
+		return err
+	}
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, r.chunks)
+	_, err = r.chunks.Put(ctx, bytes.NewReader(data), info)
+	if err != nil {
+		return fmt.Errorf("failed to upload chunk %s: %w", h, err)
+	}
+	r.setKnown(h)
+	return nil
+}
+
+// openChunk opens the chunk with hash h
+func (r *repo) openChunk(ctx context.Context, h string, options ...fs.OpenOption) (io.ReadCloser, error) {
+	o, err := r.chunks.NewObject(ctx, chunkPath(h))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find chunk %s: %w", h, err)
+	}
+	return o.Open(ctx, options...)
+}
+
+// recount counts the references to each chunk from the manifests
+func (r *repo) recount(ctx context.Context) (refs map[string]int64, files int64, err error) {
+	refs = map[string]int64{}
+	err = walk.ListR(ctx, r.base, filesDir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			data, err := readObject(ctx, o)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %q: %w", o.Remote(), err)
+			}
+			m, err := decodeManifest(data)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %q: %w", o.Remote(), err)
+			}
+			for _, c := range m.Chunks {
+				refs[c.Hash]++
+			}
+			files++
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		err = nil
+	}
+	return refs, files, err
+}
+
+// gcStats is returned from the gc command
+type gcStats struct {
+	Recounted bool  `json:"recounted"` // set if the references were counted from the manifests
+	Files     int64 `json:"files"`     // number of files if recounted
+	Chunks    int64 `json:"chunks"`    // number of chunks found
+	Deleted   int64 `json:"deleted"`   // number of chunks deleted
+	Freed     int64 `json:"freed"`     // bytes freed by deleting chunks
+}
+
+// gc deletes the chunks which have no references
+//
+// If full is set or the reference counts may be wrong then they are
+// counted from the manifests first.
+func (r *repo) gc(ctx context.Context, full bool) (stats gcStats, err error) {
+	r.gcMu.Lock()
+	defer r.gcMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err = r.load(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	dryRun := fs.GetConfig(ctx).DryRun
+
+	// If refs.json was marked dirty when read then rclone didn't
+	// shut down cleanly and the counts may be wrong
+	refs := r.refs
+	if full || r.untrusted {
+		fs.Infof(r.base, "Counting chunk references from manifests")
+		refs, stats.Files, err = r.recount(ctx)
+		if err != nil {
+			return stats, err
+		}
+		stats.Recounted = true
+		if !dryRun {
+			r.refs = refs
+			r.untrusted = false
+			r.dirty = true
+		}
+	}
+
+	err = walk.ListR(ctx, r.chunks, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			stats.Chunks++
+			h := path.Base(o.Remote())
+			if refs[h] > 0 {
+				continue
+			}
+			size := o.Size()
+			err := operations.DeleteFile(ctx, o)
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				delete(r.refs, h)
+				r.dirty = true
+			}
+			stats.Deleted++
+			stats.Freed += size
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		err = nil
+	}
+	if err != nil {
+		return stats, fmt.Errorf("failed to delete unreferenced chunks: %w", err)
+	}
+
+	if dryRun {
+		return stats, nil
+	}
+
+	if r.dirty {
+		err = r.write(ctx, false)
+		if err != nil {
+			return stats, err
+		}
+		r.dirty = false
+	}
+	return stats, nil
+}
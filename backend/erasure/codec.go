@@ -0,0 +1,464 @@
+package erasure
+
+// Shard format
+//
+// The object is split into stripes of data_shards*block_size bytes.
+// Each stripe is split into data_shards equal pieces (the last stripe
+// is shorter and zero padded to a multiple of data_shards) and
+// parity_shards parity pieces are calculated from them. Shard i is
+// piece i of every stripe one after another followed by a fixed size
+// trailer describing the object.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// trailerSize is the size of the trailer on the end of each shard
+const trailerSize = 64
+
+// trailerVersion should be incremented if the trailer format changes
+const trailerVersion = 1
+
+// trailerMagic starts each trailer
+var trailerMagic = []byte("rcloneEC")
+
+// trailer is stored on the end of each shard
+//
+//	0:8   magic
+//	8     version
+//	9     data shards
+//	10    parity shards
+//	11    index of this shard
+//	12:16 block size
+//	16:24 size of the object
+//	24:40 MD5 of the object
+//	40:60 SHA-1 of the object
+//	60:64 CRC-32 of the shard data
+type trailer struct {
+	data      int
+	parity    int
+	index     int
+	blockSize int
+	size      int64
+	md5       [16]byte
+	sha1      [20]byte
+	crc       uint32
+}
+
+// errBadTrailer is returned if the trailer isn't valid
+var errBadTrailer = errors.New("shard trailer is corrupted")
+
+// marshal the trailer into bytes
+func (t *trailer) marshal() []byte {
+	buf := make([]byte, trailerSize)
+	copy(buf[0:8], trailerMagic)
+	buf[8] = trailerVersion
+	buf[9] = byte(t.data)
+	buf[10] = byte(t.parity)
+	buf[11] = byte(t.index)
+	binary.BigEndian.PutUint32(buf[12:16], uint32(t.blockSize))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(t.size))
+	copy(buf[24:40], t.md5[:])
+	copy(buf[40:60], t.sha1[:])
+	binary.BigEndian.PutUint32(buf[60:64], t.crc)
+	return buf
+}
+
+// unmarshal the trailer from buf
+func (t *trailer) unmarshal(buf []byte) error {
+	if len(buf) != trailerSize || !bytes.Equal(buf[0:8], trailerMagic) {
+		return errBadTrailer
+	}
+	if buf[8] != trailerVersion {
+		return fmt.Errorf("unsupported shard trailer version %d", buf[8])
+	}
+	t.data = int(buf[9])
+	t.parity = int(buf[10])
+	t.index = int(buf[11])
+	t.blockSize = int(binary.BigEndian.Uint32(buf[12:16]))
+	t.size = int64(binary.BigEndian.Uint64(buf[16:24]))
+	copy(t.md5[:], buf[24:40])
+	copy(t.sha1[:], buf[40:60])
+	t.crc = binary.BigEndian.Uint32(buf[60:64])
+	if t.data == 0 || t.blockSize == 0 || t.size < 0 || t.index >= t.data+t.parity {
+		return errBadTrailer
+	}
+	return nil
+}
+
+// sameObject returns true if the trailers describe the same object
+func (t *trailer) sameObject(other *trailer) bool {
+	return t.data == other.data && t.parity == other.parity && t.blockSize == other.blockSize &&
+		t.size == other.size && t.md5 == other.md5 && t.sha1 == other.sha1
+}
+
+// layout describes how an object is split into shards
+type layout struct {
+	data      int // number of data shards
+	parity    int // number of parity shards
+	blockSize int // size of each piece in a full stripe
+}
+
+// stripeSize is the number of bytes of the object in a full stripe
+func (l layout) stripeSize() int64 {
+	return int64(l.data) * int64(l.blockSize)
+}
+
+// pieceSize returns the size of each piece of a stripe with n bytes
+// of the object in
+func (l layout) pieceSize(n int64) int64 {
+	return (n + int64(l.data) - 1) / int64(l.data)
+}
+
+// shardSize returns the size of the data in each shard for an
+// object of size bytes
+func (l layout) shardSize(size int64) int64 {
+	full, rest := size/l.stripeSize(), size%l.stripeSize()
+	return full*int64(l.blockSize) + l.pieceSize(rest)
+}
+
+// stripe returns the offset in the object and in each shard of stripe
+// i and the number of bytes of the object in it
+func (l layout) stripe(i, size int64) (offset, shardOffset, n int64) {
+	offset = i * l.stripeSize()
+	shardOffset = i * int64(l.blockSize)
+	n = min(size-offset, l.stripeSize())
+	return offset, shardOffset, n
+}
+
+// encoder splits a stream into shards
+type encoder struct {
+	layout
+	enc    reedsolomon.Encoder
+	outs   []io.Writer // writers for each shard - set to nil if failed
+	errs   []error     // errors writing each shard
+	crcs   []uint32    // CRC-32 of the data written to each shard
+	buf    []byte      // buffer for a stripe
+	shards [][]byte    // the pieces of the stripe
+	size   int64       // bytes read so far
+	minOK  int         // minimum number of shards which must succeed
+}
+
+// newEncoder makes an encoder writing the shards to outs
+//
+// If fewer than minOK shards can be written an error is returned.
+func newEncoder(l layout, outs []io.Writer, minOK int) (*encoder, error) {
+	enc, err := reedsolomon.New(l.data, l.parity)
+	if err != nil {
+		return nil, err
+	}
+	e := &encoder{
+		layout: l,
+		enc:    enc,
+		outs:   outs,
+		errs:   make([]error, len(outs)),
+		crcs:   make([]uint32, len(outs)),
+		buf:    make([]byte, l.stripeSize()),
+		shards: make([][]byte, len(outs)),
+		minOK:  minOK,
+	}
+	return e, nil
+}
+
+// ok returns the number of shards which haven't failed
+func (e *encoder) ok() (n int) {
+	for _, out := range e.outs {
+		if out != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// err returns an error describing the failed shards
+func (e *encoder) err() error {
+	return errors.Join(e.errs...)
+}
+
+// write the pieces of the current stripe to the shards
+func (e *encoder) write() error {
+	for i, out := range e.outs {
+		if out == nil {
+			continue
+		}
+		_, err := out.Write(e.shards[i])
+		if err != nil {
+			e.errs[i] = err
+			e.outs[i] = nil
+			if e.ok() < e.minOK {
+				return e.err()
+			}
+			continue
+		}
+		e.crcs[i] = crc32.Update(e.crcs[i], crc32.IEEETable, e.shards[i])
+	}
+	return nil
+}
+
+// encode reads all of in writing the shards
+func (e *encoder) encode(in io.Reader) error {
+	for {
+		n, err := io.ReadFull(in, e.buf)
+		if err == io.EOF {
+			return nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		e.size += int64(n)
+		s := int(e.pieceSize(int64(n)))
+		clear(e.buf[n : s*e.data])
+		for i := range e.shards {
+			if i < e.data {
+				e.shards[i] = e.buf[i*s : (i+1)*s]
+			} else {
+				if cap(e.shards[i]) < e.blockSize {
+					e.shards[i] = make([]byte, e.blockSize)
+				}
+				e.shards[i] = e.shards[i][:s]
+			}
+		}
+		err = e.enc.Encode(e.shards)
+		if err != nil {
+			return err
+		}
+		err = e.write()
+		if err != nil {
+			return err
+		}
+		if n < len(e.buf) {
+			return nil
+		}
+	}
+}
+
+// writeTrailers writes t with the index and CRC filled in to each
+// shard which hasn't failed
+func (e *encoder) writeTrailers(t trailer) error {
+	for i, out := range e.outs {
+		if out == nil {
+			continue
+		}
+		t.index = i
+		t.crc = e.crcs[i]
+		_, err := out.Write(t.marshal())
+		if err != nil {
+			e.errs[i] = err
+			e.outs[i] = nil
+		}
+	}
+	if e.ok() < e.minOK {
+		return e.err()
+	}
+	return nil
+}
+
+// shardOpener opens shard i at offset in the shard data
+type shardOpener func(i int, offset int64) (io.ReadCloser, error)
+
+// decoder reads an object back from its shards
+type decoder struct {
+	layout
+	enc     reedsolomon.Encoder
+	open    shardOpener
+	size    int64           // size of the object
+	ins     []io.ReadCloser // open shards
+	bad     []bool          // set if the shard has failed
+	errs    []error         // why the shards failed
+	shards  [][]byte        // pieces of the current stripe
+	pos     int64           // the next stripe to read
+	all     bool            // set to read all the shards, not just enough to decode
+	crcs    []uint32        // CRC-32 of each shard read if all is set
+	missing []bool          // shards which were reconstructed in the current stripe
+}
+
+// newDecoder makes a decoder for an object of size bytes reading
+// shards with open. Shards in bad are never read.
+func newDecoder(l layout, size int64, open shardOpener, bad []bool) (*decoder, error) {
+	enc, err := reedsolomon.New(l.data, l.parity)
+	if err != nil {
+		return nil, err
+	}
+	n := l.data + l.parity
+	d := &decoder{
+		layout:  l,
+		enc:     enc,
+		open:    open,
+		size:    size,
+		ins:     make([]io.ReadCloser, n),
+		bad:     make([]bool, n),
+		errs:    make([]error, n),
+		shards:  make([][]byte, n),
+		crcs:    make([]uint32, n),
+		missing: make([]bool, n),
+	}
+	copy(d.bad, bad)
+	for i := range d.shards {
+		d.shards[i] = make([]byte, 0, l.blockSize)
+	}
+	return d, nil
+}
+
+// seek positions the decoder at the stripe containing offset in the
+// object returning the offset of the stripe.
+func (d *decoder) seek(offset int64) int64 {
+	d.closeAll()
+	d.pos = offset / d.stripeSize()
+	return d.pos * d.stripeSize()
+}
+
+// fail marks shard i as failed
+func (d *decoder) fail(i int, err error) {
+	if d.ins[i] != nil {
+		_ = d.ins[i].Close()
+		d.ins[i] = nil
+	}
+	d.bad[i] = true
+	d.errs[i] = err
+}
+
+// readPiece reads piece i of the current stripe into d.shards[i]
+func (d *decoder) readPiece(i int, shardOffset, s int64) bool {
+	if d.bad[i] {
+		return false
+	}
+	if d.ins[i] == nil {
+		in, err := d.open(i, shardOffset)
+		if err != nil {
+			d.fail(i, err)
+			return false
+		}
+		d.ins[i] = in
+	}
+	buf := d.shards[i][:s]
+	_, err := io.ReadFull(d.ins[i], buf)
+	if err != nil {
+		d.fail(i, err)
+		return false
+	}
+	d.shards[i] = buf
+	if d.all {
+		d.crcs[i] = crc32.Update(d.crcs[i], crc32.IEEETable, buf)
+	}
+	return true
+}
+
+// next reads the next stripe returning the bytes of the object in it
+//
+// The data shards of the stripe are in d.shards[:d.data] and, if
+// d.all is set, all the shards are filled in.
+func (d *decoder) next() (n int64, err error) {
+	_, shardOffset, n := d.stripe(d.pos, d.size)
+	if n <= 0 {
+		return 0, io.EOF
+	}
+	s := d.pieceSize(n)
+	got := 0
+	for i := range d.shards {
+		d.missing[i] = false
+		if !d.all && got >= d.data {
+			// Close any shards we don't need as they will be
+			// in the wrong place if they are needed later
+			if d.ins[i] != nil {
+				_ = d.ins[i].Close()
+				d.ins[i] = nil
+			}
+			d.shards[i] = d.shards[i][:0]
+			continue
+		}
+		if d.readPiece(i, shardOffset, s) {
+			got++
+		} else {
+			d.shards[i] = d.shards[i][:0]
+			d.missing[i] = true
+		}
+	}
+	if got < d.data {
+		return 0, fmt.Errorf("only %d of the %d shards needed are readable: %w", got, d.data, errors.Join(d.errs...))
+	}
+	if got < len(d.shards) {
+		if d.all {
+			err = d.enc.Reconstruct(d.shards)
+		} else {
+			err = d.enc.ReconstructData(d.shards)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	d.pos++
+	return n, nil
+}
+
+// closeAll closes all the open shards
+func (d *decoder) closeAll() {
+	for i, in := range d.ins {
+		if in != nil {
+			_ = in.Close()
+			d.ins[i] = nil
+		}
+	}
+}
+
+// reader reads a range of the object from a decoder
+type reader struct {
+	d         *decoder
+	skip      int64  // bytes to skip at the start of the next stripe
+	remaining int64  // bytes left to return
+	buf       []byte // unread data from the current stripe
+}
+
+// newReader makes a reader returning limit bytes from offset
+func newReader(d *decoder, offset, limit int64) *reader {
+	stripeOffset := d.seek(offset)
+	return &reader{
+		d:         d,
+		skip:      offset - stripeOffset,
+		remaining: limit,
+	}
+}
+
+// Read bytes from the object
+func (r *reader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		stripeLen, err := r.d.next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		// Join the data pieces
+		s := r.d.pieceSize(stripeLen)
+		buf := make([]byte, 0, stripeLen)
+		for i := 0; i < r.d.data && int64(len(buf)) < stripeLen; i++ {
+			piece := r.d.shards[i][:s]
+			buf = append(buf, piece[:min(s, stripeLen-int64(len(buf)))]...)
+		}
+		buf = buf[r.skip:]
+		r.skip = 0
+		if int64(len(buf)) > r.remaining {
+			buf = buf[:r.remaining]
+		}
+		r.buf = buf
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// Close the reader
+func (r *reader) Close() error {
+	r.d.closeAll()
+	return nil
+}
@@ -0,0 +1,254 @@
+package erasure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "repair",
+	Short: "Rebuild missing or damaged shards.",
+	Long: `Check the shards of every object and rebuild any which are missing or
+damaged from the others.
+
+Shards are missing if an upstream was unavailable or replaced, or if
+a write with ` + "`degraded_writes`" + ` set couldn't write every shard. Shards are
+damaged if their trailer doesn't match the other shards of the object.
+
+With ` + "`-o verify`" + ` every shard is read in full and checked against the
+checksum in its trailer, which finds corruption within the shard data
+but means reading all the data.
+
+Pass a path to only repair objects in that directory. It obeys
+` + "`--dry-run`" + `.
+
+Usage examples:
+
+` + "```console" + `
+rclone backend repair erasure:
+rclone backend repair -o verify erasure: path/to/dir
+` + "```" + `
+
+It returns a summary of the objects checked and repaired.`,
+	Opts: map[string]string{
+		"verify": "Read all the shards in full and check their checksums.",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out any, err error) {
+	switch name {
+	case "repair":
+		dir := ""
+		if len(arg) > 0 {
+			dir = arg[0]
+		}
+		_, verify := opt["verify"]
+		return f.repair(ctx, dir, verify)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// repairStats is returned from the repair command
+type repairStats struct {
+	Objects  int64 `json:"objects"`  // number of objects checked
+	Damaged  int64 `json:"damaged"`  // number of objects with missing or damaged shards
+	Shards   int64 `json:"shards"`   // number of shards rebuilt
+	Repaired int64 `json:"repaired"` // number of objects repaired
+	Failed   int64 `json:"failed"`   // number of objects which couldn't be repaired
+}
+
+// repair checks and rebuilds the shards of the objects in dir
+func (f *Fs) repair(ctx context.Context, dir string, verify bool) (stats repairStats, err error) {
+	var mu sync.Mutex
+	err = walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(*Object)
+			if !ok {
+				continue
+			}
+			rebuilt, err := o.repair(ctx, verify)
+			mu.Lock()
+			stats.Objects++
+			if rebuilt > 0 || err != nil {
+				stats.Damaged++
+			}
+			if err != nil {
+				fs.Errorf(o, "Failed to repair: %v", err)
+				stats.Failed++
+			} else if rebuilt > 0 {
+				stats.Shards += int64(rebuilt)
+				stats.Repaired++
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err == nil && stats.Failed > 0 {
+		err = fmt.Errorf("failed to repair %d objects", stats.Failed)
+	}
+	return stats, err
+}
+
+// verify reads all the shards of the object checking the CRCs,
+// returning the shards which are bad
+func (o *Object) verify(ctx context.Context) (bad []bool, err error) {
+	d, err := o.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.closeAll()
+	d.all = true
+	for {
+		_, err = d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	bad = d.bad
+	for i := range bad {
+		if !bad[i] && d.crcs[i] != o.trailers[i].crc {
+			fs.Debugf(o, "Shard %d has the wrong checksum", i)
+			bad[i] = true
+		}
+	}
+	return bad, nil
+}
+
+// repair rebuilds the missing or damaged shards of the object
+// returning the number rebuilt
+func (o *Object) repair(ctx context.Context, verify bool) (rebuilt int, err error) {
+	t, bad, err := o.readTrailers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if verify {
+		bad, err = o.verify(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var missing []int
+	for i := range bad {
+		if bad[i] {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	if operations.SkipDestructive(ctx, o, fmt.Sprintf("rebuild %d shards", len(missing))) {
+		return 0, nil
+	}
+
+	// Open the object before overwriting any shards
+	d, err := o.open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.closeAll()
+	copy(d.bad, bad)
+	d.all = true
+
+	// Upload the missing shards from pipes
+	l := d.layout
+	shardLen := l.shardSize(t.size) + trailerSize
+	modTime := o.ModTime(ctx)
+	var (
+		wg     sync.WaitGroup
+		pws    = make(map[int]*io.PipeWriter, len(missing))
+		shards = make([]fs.Object, len(o.shards))
+		errs   = make([]error, len(o.shards))
+		crcs   = make([]uint32, len(o.shards))
+	)
+	for _, i := range missing {
+		pr, pw := io.Pipe()
+		pws[i] = pw
+		u := o.f.upstreams[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info := object.NewStaticObjectInfo(o.remote, modTime, shardLen, true, nil, u)
+			var err error
+			if old := o.shards[i]; old != nil {
+				err = old.Update(ctx, pr, info)
+				shards[i] = old
+			} else {
+				shards[i], err = u.Put(ctx, pr, info)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to upload shard %d: %w", i, err)
+				_ = pr.CloseWithError(errs[i])
+			}
+		}()
+	}
+
+	// Rebuild the missing shards stripe by stripe
+	for err == nil {
+		var n int64
+		n, err = d.next()
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			break
+		}
+		s := l.pieceSize(n)
+		for _, i := range missing {
+			piece := d.shards[i][:s]
+			crcs[i] = crc32.Update(crcs[i], crc32.IEEETable, piece)
+			if _, err = pws[i].Write(piece); err != nil {
+				break
+			}
+		}
+	}
+	for _, i := range missing {
+		if err == nil {
+			shardTrailer := *t
+			shardTrailer.index = i
+			shardTrailer.crc = crcs[i]
+			_, err = pws[i].Write(shardTrailer.marshal())
+		}
+		if err != nil {
+			_ = pws[i].CloseWithError(err)
+		} else {
+			_ = pws[i].Close()
+		}
+	}
+	wg.Wait()
+	if err == nil {
+		err = errors.Join(errs...)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	for _, i := range missing {
+		o.shards[i] = shards[i]
+	}
+	o.t, o.trailers, o.bad = nil, nil, nil
+	o.mu.Unlock()
+	fs.Infof(o, "Rebuilt %d shards", len(missing))
+	return len(missing), nil
+}
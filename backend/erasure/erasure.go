@@ -0,0 +1,575 @@
+// Package erasure implements an erasure coded backend over several remotes
+//
+// Each object is split into data shards and parity shards with
+// Reed-Solomon coding and each shard is stored on a different
+// upstream so the object can be read back from any data_shards of
+// them.
+package erasure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "erasure",
+		Description: "Erasure code files across several remotes",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name:     "upstreams",
+			Required: true,
+			Help: `List of space separated upstreams.
+
+Each object is split into shards and each shard is stored on a
+different upstream, so there must be one upstream for each shard.
+
+Can be 'remotea:test/dir remoteb: remotec:', '"remotea:test/space dir" remoteb: remotec:', etc.
+
+The order of the upstreams must not be changed once data has been
+written.`,
+			Default: fs.SpaceSepList{},
+		}, {
+			Name: "parity_shards",
+			Help: `Number of parity shards.
+
+This is the number of upstreams which can be lost while still being
+able to read all the data. The remaining upstreams hold the data
+shards, so with 5 upstreams and 2 parity shards each object is split
+into 3 data shards and the data takes 5/3 of its size to store.`,
+			Default: 1,
+		}, {
+			Name: "block_size",
+			Help: `Size of the blocks each shard is written in.
+
+Objects are encoded a stripe of data_shards blocks at a time, so this
+sets the memory used for each transfer.`,
+			Default:  fs.SizeSuffix(1024 * 1024),
+			Advanced: true,
+		}, {
+			Name: "degraded_writes",
+			Help: `Allow writes to succeed if some of the upstreams fail.
+
+Normally writes fail unless every shard is written. If this is set
+then a write succeeds as long as enough shards to read the object back
+are written. The missing shards can be rebuilt later with the repair
+backend command.`,
+			Default:  false,
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams      fs.SpaceSepList `config:"upstreams"`
+	ParityShards   int             `config:"parity_shards"`
+	BlockSize      fs.SizeSuffix   `config:"block_size"`
+	DegradedWrites bool            `config:"degraded_writes"`
+}
+
+// Fs represents an erasure coded set of upstreams
+type Fs struct {
+	name      string
+	root      string
+	opt       Options
+	features  *fs.Features // optional features
+	upstreams []fs.Fs      // one for each shard
+	layout    layout       // how new objects are split up
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	n := len(opt.Upstreams)
+	if n < 2 {
+		return nil, errors.New("erasure needs at least 2 upstreams - check the value of the upstreams setting")
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point erasure remote at itself - check the value of the upstreams setting")
+		}
+	}
+	if opt.ParityShards < 1 || opt.ParityShards >= n {
+		return nil, fmt.Errorf("parity_shards must be between 1 and %d with %d upstreams", n-1, n)
+	}
+	if n > 256 {
+		return nil, errors.New("erasure can use at most 256 upstreams")
+	}
+	if opt.BlockSize <= 0 || opt.BlockSize > fs.SizeSuffix(1<<30) {
+		return nil, errors.New("block_size must be between 1 and 1Gi")
+	}
+	root = strings.Trim(root, "/")
+
+	f := &Fs{
+		name: name,
+		root: root,
+		opt:  *opt,
+		layout: layout{
+			data:      n - opt.ParityShards,
+			parity:    opt.ParityShards,
+			blockSize: int(opt.BlockSize),
+		},
+	}
+	isFile, err := f.makeUpstreams(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		// Point the upstreams at the parent directory
+		f.root = path.Dir(root)
+		if f.root == "." {
+			f.root = ""
+		}
+		_, err = f.makeUpstreams(ctx, f.root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the upstreams in the cache while f is in use
+	for _, u := range f.upstreams {
+		cache.Pin(u)
+	}
+	runtime.SetFinalizer(f, func(f *Fs) {
+		for _, u := range f.upstreams {
+			cache.Unpin(u)
+		}
+	})
+
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		ReadMimeType:            false,
+		WriteMimeType:           false,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+	}).Fill(ctx, f)
+	for _, u := range f.upstreams {
+		f.features = f.features.Mask(ctx, u)
+	}
+	// Shutdown is always passed on to the upstreams
+	f.features.Shutdown = f.Shutdown
+
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// makeUpstreams makes the upstreams pointing at root returning true
+// if root is a file in any of them
+func (f *Fs) makeUpstreams(ctx context.Context, root string) (isFile bool, err error) {
+	f.upstreams = make([]fs.Fs, len(f.opt.Upstreams))
+	isFiles := make([]bool, len(f.upstreams))
+	errs := f.forEach(func(i int, _ fs.Fs) error {
+		u, err := cache.Get(ctx, fspath.JoinRootPath(f.opt.Upstreams[i], root))
+		if err == fs.ErrorIsFile {
+			err = nil
+			isFiles[i] = true
+		}
+		if err != nil {
+			return fmt.Errorf("failed to make upstream %q: %w", f.opt.Upstreams[i], err)
+		}
+		f.upstreams[i] = u
+		return nil
+	})
+	return slices.Contains(isFiles, true), joinErrors(errs)
+}
+
+// forEach calls fn for each upstream concurrently returning the
+// errors for each one, or nil if there were none
+func (f *Fs) forEach(fn func(i int, u fs.Fs) error) []error {
+	var (
+		wg      sync.WaitGroup
+		errs    = make([]error, len(f.upstreams))
+		anyErrs bool
+		mu      sync.Mutex
+	)
+	for i, u := range f.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := fn(i, u)
+			if err != nil {
+				mu.Lock()
+				errs[i] = err
+				anyErrs = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if !anyErrs {
+		return nil
+	}
+	return errs
+}
+
+// countErrors returns the number of non nil errors in errs
+func countErrors(errs []error) (n int) {
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// joinErrors joins the errors in errs, returning the error itself if
+// they are all the same so sentinel errors can be checked for
+func joinErrors(errs []error) error {
+	var first error
+	same := true
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if first == nil {
+			first = err
+		} else if err != first {
+			same = false
+		}
+	}
+	if same {
+		return first
+	}
+	return errors.Join(errs...)
+}
+
+// minShards is the number of shards which must be written for a
+// write to succeed
+func (f *Fs) minShards() int {
+	if f.opt.DegradedWrites {
+		return f.layout.data
+	}
+	return len(f.upstreams)
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("erasure root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision is the greatest precision of all the upstreams
+func (f *Fs) Precision() time.Duration {
+	var greatestPrecision time.Duration
+	for _, u := range f.upstreams {
+		if u.Precision() > greatestPrecision {
+			greatestPrecision = u.Precision()
+		}
+	}
+	return greatestPrecision
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.NewHashSet(hash.MD5, hash.SHA1)
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	upstreamEntries := make([]fs.DirEntries, len(f.upstreams))
+	errs := f.forEach(func(i int, u fs.Fs) (err error) {
+		upstreamEntries[i], err = u.List(ctx, dir)
+		return err
+	})
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = nil
+			notFound++
+		}
+	}
+	if notFound == len(f.upstreams) {
+		return nil, fs.ErrorDirNotFound
+	}
+	if n := countErrors(errs); n > f.layout.parity {
+		return nil, joinErrors(errs)
+	} else if n > 0 {
+		fs.Errorf(f, "Listing %q on some upstreams failed: %v", dir, joinErrors(errs))
+	}
+
+	// Merge the entries from each upstream
+	dirs := map[string]struct{}{}
+	objects := map[string]*Object{}
+	for i, upstreamEntries := range upstreamEntries {
+		for _, entry := range upstreamEntries {
+			remote := entry.Remote()
+			switch x := entry.(type) {
+			case fs.Directory:
+				if _, found := dirs[remote]; !found {
+					dirs[remote] = struct{}{}
+					entries = append(entries, x)
+				}
+			case fs.Object:
+				o := objects[remote]
+				if o == nil {
+					o = f.newObject(remote)
+					objects[remote] = o
+					entries = append(entries, o)
+				}
+				o.shards[i] = x
+			}
+		}
+	}
+	return entries, nil
+}
+
+// NewObject creates a new remote Object for a given remote path
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o := f.newObject(remote)
+	errs := f.forEach(func(i int, u fs.Fs) (err error) {
+		o.shards[i], err = u.NewObject(ctx, remote)
+		return err
+	})
+	found := 0
+	for i, err := range errs {
+		if err == nil || errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = nil
+		}
+	}
+	for _, shard := range o.shards {
+		if shard != nil {
+			found++
+		}
+	}
+	if found == 0 {
+		if err := joinErrors(errs); err != nil {
+			return nil, err
+		}
+		return nil, fs.ErrorObjectNotFound
+	}
+	return o, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := f.newObject(src.Remote())
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+//
+// This is only enabled if all the upstreams support it.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return joinErrors(f.forEach(func(i int, u fs.Fs) error {
+		return u.Mkdir(ctx, dir)
+	}))
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		return u.Rmdir(ctx, dir)
+	})
+	return f.joinDirErrors(errs)
+}
+
+// joinDirErrors ignores fs.ErrorDirNotFound from upstreams unless
+// all of them returned it
+func (f *Fs) joinDirErrors(errs []error) error {
+	if errs == nil {
+		return nil
+	}
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = nil
+			notFound++
+		}
+	}
+	if notFound == len(errs) {
+		return fs.ErrorDirNotFound
+	}
+	return joinErrors(errs)
+}
+
+// Purge all files in the directory
+//
+// Implement this if you have a way of deleting all the files
+// quicker than just running Remove() on the result of List()
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		return u.Features().Purge(ctx, dir)
+	})
+	return f.joinDirErrors(errs)
+}
+
+// serverSide copies or moves each shard of src to remote using fn
+func (f *Fs) serverSide(ctx context.Context, src fs.Object, remote string, fn func(u fs.Fs) func(context.Context, fs.Object, string) (fs.Object, error), cantErr error) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || len(srcObj.shards) != len(f.upstreams) {
+		return nil, cantErr
+	}
+	for i, u := range f.upstreams {
+		if fn(u) == nil || !operations.SameConfig(srcObj.f.upstreams[i], u) {
+			return nil, cantErr
+		}
+	}
+	dst := f.newObject(remote)
+	errs := f.forEach(func(i int, u fs.Fs) (err error) {
+		if srcObj.shards[i] == nil {
+			return fmt.Errorf("shard %d is missing", i)
+		}
+		dst.shards[i], err = fn(u)(ctx, srcObj.shards[i], remote)
+		return err
+	})
+	if n := countErrors(errs); n > len(f.upstreams)-f.minShards() {
+		return nil, joinErrors(errs)
+	} else if n > 0 {
+		fs.Errorf(dst, "Some shards failed - run the repair command: %v", joinErrors(errs))
+	}
+	return dst, nil
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	return f.serverSide(ctx, src, remote, func(u fs.Fs) func(context.Context, fs.Object, string) (fs.Object, error) {
+		return u.Features().Copy
+	}, fs.ErrorCantCopy)
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	return f.serverSide(ctx, src, remote, func(u fs.Fs) func(context.Context, fs.Object, string) (fs.Object, error) {
+		return u.Features().Move
+	}, fs.ErrorCantMove)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || len(srcFs.upstreams) != len(f.upstreams) {
+		return fs.ErrorCantDirMove
+	}
+	for i, u := range f.upstreams {
+		if !operations.SameConfig(srcFs.upstreams[i], u) {
+			return fs.ErrorCantDirMove
+		}
+	}
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		return u.Features().DirMove(ctx, srcFs.upstreams[i], srcRemote, dstRemote)
+	})
+	return f.joinDirErrors(errs)
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	for _, u := range f.upstreams {
+		if do := u.Features().DirCacheFlush; do != nil {
+			do()
+		}
+	}
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	return joinErrors(f.forEach(func(i int, u fs.Fs) error {
+		if do := u.Features().Shutdown; do != nil {
+			return do(ctx)
+		}
+		return nil
+	}))
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+)
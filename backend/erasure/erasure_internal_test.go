@@ -0,0 +1,143 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Encode then decode objects of various sizes with shards missing
+func TestCodec(t *testing.T) {
+	l := layout{data: 3, parity: 2, blockSize: 100}
+	n := l.data + l.parity
+	for _, size := range []int64{0, 1, 2, 299, 300, 301, 1000, 3001} {
+		data := []byte(random.String(int(size)))
+		bufs := make([]*bytes.Buffer, n)
+		outs := make([]io.Writer, n)
+		for i := range bufs {
+			bufs[i] = new(bytes.Buffer)
+			outs[i] = bufs[i]
+		}
+		e, err := newEncoder(l, outs, n)
+		require.NoError(t, err)
+		require.NoError(t, e.encode(bytes.NewReader(data)))
+		assert.Equal(t, size, e.size)
+		require.NoError(t, e.writeTrailers(trailer{data: l.data, parity: l.parity, blockSize: l.blockSize, size: size}))
+		for i, buf := range bufs {
+			assert.Equal(t, l.shardSize(size)+trailerSize, int64(buf.Len()))
+			var tr trailer
+			require.NoError(t, tr.unmarshal(buf.Bytes()[buf.Len()-trailerSize:]))
+			assert.Equal(t, i, tr.index)
+			assert.Equal(t, size, tr.size)
+		}
+
+		open := func(i int, offset int64) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(bufs[i].Bytes()[offset : bufs[i].Len()-trailerSize])), nil
+		}
+		for _, bad := range [][]bool{
+			nil,
+			{true, false, false, false, false},
+			{false, true, false, true, false},
+			{true, true, false, false, false},
+		} {
+			for _, offset := range []int64{0, 1, 250, 301} {
+				if offset > size {
+					continue
+				}
+				d, err := newDecoder(l, size, open, bad)
+				require.NoError(t, err)
+				got, err := io.ReadAll(newReader(d, offset, size-offset))
+				require.NoError(t, err)
+				assert.Equal(t, data[offset:], got, "size=%d bad=%v offset=%d", size, bad, offset)
+			}
+		}
+
+		// Too many missing shards
+		if size > 0 {
+			d, err := newDecoder(l, size, open, []bool{true, true, true, false, false})
+			require.NoError(t, err)
+			_, err = io.ReadAll(newReader(d, 0, size))
+			assert.ErrorContains(t, err, "only 2 of the 3 shards")
+		}
+	}
+}
+
+// Check objects can be read with a shard missing and repaired
+func TestErasureRepair(t *testing.T) {
+	ctx := context.Background()
+	dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	f, err := NewFs(ctx, "TestErasureRepair", "", configmap.Simple{
+		"type":          "erasure",
+		"upstreams":     strings.Join(dirs, " "),
+		"parity_shards": "1",
+		"block_size":    "1k",
+	})
+	require.NoError(t, err)
+
+	const remote = "dir/file.bin"
+	data := []byte(random.String(10000))
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+
+	shardPath := func(i int) string {
+		return filepath.Join(dirs[i], "dir", "file.bin")
+	}
+	shard0, err := os.ReadFile(shardPath(0))
+	require.NoError(t, err)
+
+	read := func() []byte {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), o.Size())
+		in, err := o.Open(ctx)
+		require.NoError(t, err)
+		got, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		return got
+	}
+	repair := func(opt map[string]string) repairStats {
+		out, err := f.(fs.Commander).Command(ctx, "repair", nil, opt)
+		require.NoError(t, err)
+		return out.(repairStats)
+	}
+
+	// Lose a shard
+	require.NoError(t, os.Remove(shardPath(1)))
+	assert.Equal(t, data, read())
+	stats := repair(nil)
+	assert.Equal(t, repairStats{Objects: 1, Damaged: 1, Shards: 1, Repaired: 1}, stats)
+	_, err = os.Stat(shardPath(1))
+	require.NoError(t, err)
+	assert.Equal(t, repairStats{Objects: 1}, repair(nil))
+
+	// Lose another shard and read from the repaired one
+	require.NoError(t, os.Remove(shardPath(2)))
+	assert.Equal(t, data, read())
+	assert.Equal(t, int64(1), repair(nil).Shards)
+
+	// Corrupt the data of a shard which only verify can find
+	corrupt := bytes.Clone(shard0)
+	corrupt[100] ^= 0xFF
+	require.NoError(t, os.WriteFile(shardPath(0), corrupt, 0666))
+	assert.Equal(t, repairStats{Objects: 1}, repair(nil))
+	assert.Equal(t, repairStats{Objects: 1, Damaged: 1, Shards: 1, Repaired: 1}, repair(map[string]string{"verify": ""}))
+	got, err := os.ReadFile(shardPath(0))
+	require.NoError(t, err)
+	assert.Equal(t, shard0, got)
+	assert.Equal(t, data, read())
+}
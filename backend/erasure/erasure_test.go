@@ -0,0 +1,60 @@
+// Test Erasure filesystem interface
+package erasure_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/backend/erasure"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	opt := fstests.Opt{
+		RemoteName: *fstest.RemoteName,
+		NilObject:  (*erasure.Object)(nil),
+		UnimplementableFsMethods: []string{
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"MergeDirs",
+			"PutUnchecked",
+			"CleanUp",
+			"About",
+			"UserInfo",
+			"Disconnect",
+			"ChangeNotify",
+			"PublicLink",
+			"DirSetModTime",
+			"MkdirMetadata",
+			"ListP",
+			"ListR",
+			"UnWrap",
+			"WrapFs",
+			"SetWrapper",
+		},
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+			"SetMetadata",
+			"ID",
+			"UnWrap",
+		},
+	}
+	if *fstest.RemoteName == "" {
+		dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+		opt.ExtraConfig = []fstests.ExtraConfigItem{
+			{Name: "TestErasure", Key: "type", Value: "erasure"},
+			{Name: "TestErasure", Key: "upstreams", Value: strings.Join(dirs, " ")},
+			{Name: "TestErasure", Key: "parity_shards", Value: "1"},
+			{Name: "TestErasure", Key: "block_size", Value: "1k"},
+		}
+		opt.RemoteName = "TestErasure:"
+		opt.QuickTestOK = true
+	}
+	fstests.Run(t, &opt)
+}
@@ -0,0 +1,390 @@
+package erasure
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// Object describes a file stored as shards on the upstreams
+type Object struct {
+	f      *Fs
+	remote string
+	shards []fs.Object // shard i on upstream i or nil if not found
+
+	mu       sync.Mutex
+	t        *trailer   // trailer agreed by the shards - read on demand
+	trailers []*trailer // trailer read from each shard or nil
+	bad      []bool     // set if shard i is missing or doesn't match t
+}
+
+// newObject makes an Object with no shards
+func (f *Fs) newObject(remote string) *Object {
+	return &Object{
+		f:      f,
+		remote: remote,
+		shards: make([]fs.Object, len(f.upstreams)),
+	}
+}
+
+// readShardTrailer reads the trailer from the end of shard i
+func (o *Object) readShardTrailer(ctx context.Context, i int) (*trailer, error) {
+	shard := o.shards[i]
+	size := shard.Size()
+	if size < trailerSize {
+		return nil, errBadTrailer
+	}
+	in, err := shard.Open(ctx, &fs.RangeOption{Start: size - trailerSize, End: size - 1})
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, trailerSize)
+	_, err = io.ReadFull(in, buf)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := new(trailer)
+	err = t.unmarshal(buf)
+	if err != nil {
+		return nil, err
+	}
+	if t.index != i {
+		return nil, fmt.Errorf("shard %d has index %d - have the upstreams been reordered?", i, t.index)
+	}
+	if t.data+t.parity != len(o.shards) {
+		return nil, fmt.Errorf("shard %d is for %d upstreams, not %d", i, t.data+t.parity, len(o.shards))
+	}
+	l := layout{data: t.data, parity: t.parity, blockSize: t.blockSize}
+	if want := l.shardSize(t.size) + trailerSize; size != want {
+		return nil, fmt.Errorf("shard %d is the wrong size: expecting %d got %d", i, want, size)
+	}
+	return t, nil
+}
+
+// readTrailers reads the trailers of all the shards if not already
+// read.
+//
+// It returns the trailer which most of the shards agree on and which
+// shards are bad.
+func (o *Object) readTrailers(ctx context.Context) (t *trailer, bad []bool, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.t != nil {
+		return o.t, o.bad, nil
+	}
+	n := len(o.shards)
+	trailers := make([]*trailer, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, shard := range o.shards {
+		if shard == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trailers[i], errs[i] = o.readShardTrailer(ctx, i)
+			if errs[i] != nil {
+				fs.Debugf(o, "Ignoring shard %d: %v", i, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Choose the trailer with the most shards agreeing
+	votes := 0
+	for _, ti := range trailers {
+		if ti == nil {
+			continue
+		}
+		count := 0
+		for _, tj := range trailers {
+			if tj != nil && ti.sameObject(tj) {
+				count++
+			}
+		}
+		if count > votes {
+			t, votes = ti, count
+		}
+	}
+	if t == nil {
+		if err = errors.Join(errs...); err == nil {
+			err = fs.ErrorObjectNotFound
+		}
+		return nil, nil, fmt.Errorf("no readable shards: %w", err)
+	}
+	bad = make([]bool, n)
+	for i, ti := range trailers {
+		bad[i] = ti == nil || !t.sameObject(ti)
+	}
+	o.t, o.trailers, o.bad = t, trailers, bad
+	return t, bad, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.MD5 && ht != hash.SHA1 {
+		return "", hash.ErrUnsupported
+	}
+	t, _, err := o.readTrailers(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ht == hash.MD5 {
+		return hex.EncodeToString(t.md5[:]), nil
+	}
+	return hex.EncodeToString(t.sha1[:]), nil
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	t, _, err := o.readTrailers(context.TODO())
+	if err != nil {
+		fs.Errorf(o, "Failed to read size: %v", err)
+		return -1
+	}
+	return t.size
+}
+
+// firstShard returns the first shard found
+func (o *Object) firstShard() fs.Object {
+	for _, shard := range o.shards {
+		if shard != nil {
+			return shard
+		}
+	}
+	return nil
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	shard := o.firstShard()
+	if shard == nil {
+		return time.Now()
+	}
+	return shard.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	errs := o.f.forEach(func(i int, _ fs.Fs) error {
+		if o.shards[i] == nil {
+			return nil
+		}
+		return o.shards[i].SetModTime(ctx, modTime)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// open returns a decoder for the object
+func (o *Object) open(ctx context.Context, options ...fs.OpenOption) (*decoder, error) {
+	t, bad, err := o.readTrailers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l := layout{data: t.data, parity: t.parity, blockSize: t.blockSize}
+	end := l.shardSize(t.size) - 1
+	open := func(i int, offset int64) (io.ReadCloser, error) {
+		return o.shards[i].Open(ctx, append([]fs.OpenOption{&fs.RangeOption{Start: offset, End: end}}, options...)...)
+	}
+	return newDecoder(l, t.size, open, bad)
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	var shardOptions []fs.OpenOption
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		case *fs.HTTPOption:
+			shardOptions = append(shardOptions, option)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	d, err := o.open(ctx, shardOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if offset > d.size {
+		offset = d.size
+	}
+	if limit < 0 || offset+limit > d.size {
+		limit = d.size - offset
+	}
+	return newReader(d, offset, limit), nil
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	var (
+		f        = o.f
+		n        = len(f.upstreams)
+		size     = src.Size()
+		shardLen = int64(-1)
+		modTime  = src.ModTime(ctx)
+		outs     = make([]io.Writer, n)
+		shards   = make([]fs.Object, n)
+		errs     = make([]error, n)
+		wg       sync.WaitGroup
+	)
+	if size >= 0 {
+		shardLen = f.layout.shardSize(size) + trailerSize
+	}
+	hasher, err := hash.NewMultiHasherTypes(f.Hashes())
+	if err != nil {
+		return err
+	}
+	in = io.TeeReader(in, hasher)
+
+	// Upload each shard from a pipe
+	for i, u := range f.upstreams {
+		pr, pw := io.Pipe()
+		outs[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info := object.NewStaticObjectInfo(o.remote, modTime, shardLen, true, nil, u)
+			var err error
+			if old := o.shards[i]; old != nil {
+				err = old.Update(ctx, pr, info, options...)
+				shards[i] = old
+			} else if shardLen < 0 {
+				shards[i], err = u.Features().PutStream(ctx, pr, info, options...)
+			} else {
+				shards[i], err = u.Put(ctx, pr, info, options...)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to upload shard %d: %w", i, err)
+				_ = pr.CloseWithError(errs[i])
+			}
+		}()
+	}
+
+	e, err := newEncoder(f.layout, outs, f.minShards())
+	var t trailer
+	if err == nil {
+		err = e.encode(in)
+	}
+	if err == nil && size >= 0 && e.size != size {
+		err = fmt.Errorf("source changed size: expecting %d got %d", size, e.size)
+	}
+	if err == nil {
+		t = trailer{
+			data:      f.layout.data,
+			parity:    f.layout.parity,
+			blockSize: f.layout.blockSize,
+			size:      e.size,
+		}
+		md5sum, _ := hasher.Sum(hash.MD5)
+		sha1sum, _ := hasher.Sum(hash.SHA1)
+		copy(t.md5[:], md5sum)
+		copy(t.sha1[:], sha1sum)
+		err = e.writeTrailers(t)
+	}
+	for i := range outs {
+		pw := outs[i].(*io.PipeWriter)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+		} else {
+			_ = pw.Close()
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	bad := make([]bool, n)
+	trailers := make([]*trailer, n)
+	failed := 0
+	for i := range errs {
+		if errs[i] != nil {
+			bad[i] = true
+			failed++
+			continue
+		}
+		shardTrailer := t
+		shardTrailer.index, shardTrailer.crc = i, e.crcs[i]
+		trailers[i] = &shardTrailer
+	}
+	if n-failed < f.minShards() {
+		return errors.Join(errs...)
+	}
+	for i := range errs {
+		if !bad[i] {
+			continue
+		}
+		fs.Errorf(o, "Failed to write shard %d - run the repair backend command: %v", i, errs[i])
+		// Remove what is left of the old shard as it no longer matches
+		if old := o.shards[i]; old != nil {
+			if err := old.Remove(ctx); err != nil {
+				fs.Debugf(o, "Failed to remove shard %d: %v", i, err)
+			}
+		}
+		shards[i] = nil
+	}
+
+	o.mu.Lock()
+	o.shards = shards
+	o.t, o.trailers, o.bad = &t, trailers, bad
+	o.mu.Unlock()
+	return nil
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return joinErrors(o.f.forEach(func(i int, _ fs.Fs) error {
+		if o.shards[i] == nil {
+			return nil
+		}
+		return o.shards[i].Remove(ctx)
+	}))
+}
@@ -0,0 +1,193 @@
+//go:build linux
+
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/random"
+	"golang.org/x/sys/unix"
+)
+
+// errCloneUnsupported is returned by Clone when FICLONE can't be used
+// between src and dst.
+var errCloneUnsupported = errors.New("reflink not supported")
+
+// Copy src to this remote using server-side copy operations.
+//
+// # This is stored with the remote path given
+//
+// # It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	if f.opt.NoClone {
+		return nil, fs.ErrorCantCopy
+	}
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't clone - not same remote type")
+		return nil, fs.ErrorCantCopy
+	}
+	if f.opt.TranslateSymlinks && srcObj.translatedLink { // in --links mode, use cloning only for regular files
+		return nil, fs.ErrorCantCopy
+	}
+
+	// Fetch metadata if --metadata is in use
+	meta, err := fs.GetMetadataOptions(ctx, f, src, fs.MetadataAsOpenOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("copy: failed to read metadata: %w", err)
+	}
+
+	// Create destination
+	dstObj := f.newObject(remote)
+	err = dstObj.mkdirAll()
+	if err != nil {
+		return nil, err
+	}
+
+	srcPath := srcObj.path
+	if f.opt.FollowSymlinks { // in --copy-links mode, find the real file being pointed to and pass that in instead
+		srcPath, err = filepath.EvalSymlinks(srcPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = Clone(srcPath, f.localPath(remote))
+	if errors.Is(err, errCloneUnsupported) {
+		fs.Debugf(src, "Can't clone - %v", err)
+		return nil, fs.ErrorCantCopy
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Preserve the modification time as Update would
+	err = dstObj.SetModTime(ctx, srcObj.ModTime(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("copy: failed to set modification time: %w", err)
+	}
+
+	// Set metadata if --metadata is in use
+	if meta != nil {
+		err = dstObj.writeMetadata(meta)
+		if err != nil {
+			return nil, fmt.Errorf("copy: failed to set metadata: %w", err)
+		}
+	}
+
+	return f.NewObject(ctx, remote)
+}
+
+// Clone makes dst a copy of src with a FICLONE reflink, which shares
+// the blocks of src on copy-on-write filesystems such as btrfs, XFS
+// and bcachefs.
+//
+// The copy is made into a temporary file next to dst which is renamed
+// over dst on success, so an existing dst is left untouched if the
+// clone fails.
+//
+// If the filesystem doesn't support reflinks then it returns an error
+// wrapping errCloneUnsupported so the caller can do a normal copy.
+func Clone(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// Don't base the name on dst as it may be at the length limit
+	tmp := filepath.Join(filepath.Dir(dst), ".rclone-clone-"+random.String(8))
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp, dst)
+		}
+		if err != nil {
+			if removeErr := os.Remove(tmp); removeErr != nil {
+				fs.Debugf(tmp, "Failed to remove failed clone: %v", removeErr)
+			}
+		}
+	}()
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if err != nil {
+		if isCloneUnsupported(err) {
+			return fmt.Errorf("%w: %v", errCloneUnsupported, err)
+		}
+		return fmt.Errorf("reflink failed: %w", err)
+	}
+	fs.Debugf(dst, "isCloned: true")
+	return nil
+}
+
+// Magic numbers from statfs(2) of filesystems which can do FICLONE
+const (
+	btrfsSuperMagic    = 0x9123683E
+	xfsSuperMagic      = 0x58465342
+	bcachefsSuperMagic = 0xca451a4e
+	ocfs2SuperMagic    = 0x7461636f
+)
+
+// canClone returns true if the filesystem holding root may support
+// reflinks.
+//
+// If root doesn't exist yet then its nearest existing parent is
+// checked.
+func canClone(root string) bool {
+	var st unix.Statfs_t
+	for {
+		err := unix.Statfs(root, &st)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(root)
+		if !errors.Is(err, unix.ENOENT) || parent == root {
+			return false
+		}
+		root = parent
+	}
+	switch uint32(st.Type) {
+	case btrfsSuperMagic, xfsSuperMagic, bcachefsSuperMagic, ocfs2SuperMagic:
+		return true
+	}
+	return false
+}
+
+// isCloneUnsupported returns true if err means that the filesystem
+// refused the operation rather than that it went wrong.
+func isCloneUnsupported(err error) bool {
+	switch {
+	case errors.Is(err, syscall.EOPNOTSUPP),
+		errors.Is(err, syscall.ENOTTY),
+		errors.Is(err, syscall.ENOSYS),
+		errors.Is(err, syscall.EXDEV),
+		errors.Is(err, syscall.EINVAL):
+		return true
+	}
+	return false
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Copier = &Fs{}
+)
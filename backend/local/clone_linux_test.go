@@ -0,0 +1,68 @@
+//go:build linux
+
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneLinux(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	require.NoError(t, os.WriteFile(src, []byte("hello world"), 0666))
+
+	// Write something longer first to check the destination is truncated
+	require.NoError(t, os.WriteFile(dst, []byte("a much longer piece of content"), 0666))
+
+	err := Clone(src, dst)
+	if err != nil {
+		require.ErrorIs(t, err, errCloneUnsupported)
+		got, readErr := os.ReadFile(dst)
+		require.NoError(t, readErr)
+		assert.Equal(t, "a much longer piece of content", string(got), "failed clone should leave destination alone")
+		t.Skipf("cloning not supported on %s: %v", dir, err)
+	}
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(got))
+
+	// Check no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestCopyLinux(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	f := r.Flocal.(*Fs)
+
+	modTime := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile("file.txt", "hello", modTime)
+	src, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+
+	dst, err := f.Copy(ctx, src, "sub/copy.txt")
+	if err == fs.ErrorCantCopy {
+		t.Skip("server-side copy not supported here")
+	}
+	require.NoError(t, err)
+	assert.Equal(t, "sub/copy.txt", dst.Remote())
+
+	file2 := fstest.NewItem("sub/copy.txt", "hello", modTime)
+	r.CheckLocalItems(t, file1, file2)
+
+	// Check --local-no-clone disables it
+	f.opt.NoClone = true
+	_, err = f.Copy(ctx, src, "copy2.txt")
+	assert.Equal(t, fs.ErrorCantCopy, err)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package local
+
+// canClone returns true if the filesystem holding root may support
+// cloning - this is only checked on Linux.
+func canClone(root string) bool {
+	return true
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/random"
 	"github.com/rclone/rclone/lib/readers"
 	"golang.org/x/text/unicode/norm"
 )
@@ -240,8 +241,10 @@ deduplicated by default (i.e. having two identical files does not consume more
 storage than having just one.)  However, for use cases where data redundancy is
 preferable, --local-no-clone can be used to disable cloning and force "deep" copies.
 
-Currently, cloning is only supported when using APFS on macOS (support for other
-platforms may be added in the future.)`,
+Currently, cloning is only supported when using APFS on macOS and on Linux.
+On Linux rclone uses a reflink (FICLONE), which works on copy-on-write
+filesystems such as btrfs, XFS and bcachefs, and does a normal copy if the
+filesystem doesn't support it.`,
 				Default:  false,
 				Advanced: true,
 			},
@@ -323,6 +326,50 @@ only useful for reading.
 				Default:  fs.CommaSepList{},
 				Advanced: true,
 			},
+			{
+				Name: "version_dir",
+				Help: `Directory to keep old versions of files in.
+
+If this is set then when a file is overwritten or deleted rclone moves
+the old version into this directory instead of discarding it. The old
+versions can be seen with --local-versions or --local-version-at.
+
+The directory mirrors the absolute paths of the files so the same
+directory can be used for any number of local remotes. It should be on
+the same filesystem as the files as keeping a version is a rename.
+
+Server-side copy and move and multi-thread downloads are disabled when
+this is set so that every change to a file keeps a version.`,
+				Default:  "",
+				Advanced: true,
+			},
+			{
+				Name: "versions",
+				Help: `Include old versions in directory listings.
+
+The old versions from --local-version-dir are shown with the time they
+were replaced or deleted in their name, like the versions shown with
+--s3-versions. They can be read and deleted but not modified.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "version_at",
+				Help: `Show file versions as they were at the specified time.
+
+This uses the old versions kept in --local-version-dir.
+
+The parameter should be a date, "2006-01-02", datetime "2006-01-02
+15:04:05" or a duration for that long ago, eg "100d" or "1h".
+
+Note that when using this no file write operations are permitted,
+so you can't upload files or delete them.
+
+See [the time option docs](/docs/#time-options) for valid formats.
+`,
+				Default:  fs.Time{},
+				Advanced: true,
+			},
 			{
 				Name:     config.ConfigEncoding,
 				Help:     config.ConfigEncodingHelp,
@@ -353,6 +400,9 @@ type Options struct {
 	Hashes            fs.CommaSepList      `config:"hashes"`
 	Enc               encoder.MultiEncoder `config:"encoding"`
 	NoClone           bool                 `config:"no_clone"`
+	VersionDir        string               `config:"version_dir"`
+	Versions          bool                 `config:"versions"`
+	VersionAt         fs.Time              `config:"version_at"`
 }
 
 // Fs represents a local filesystem rooted at root
@@ -367,6 +417,7 @@ type Fs struct {
 	warnedMu       sync.Mutex          // used for locking access to 'warned'.
 	warned         map[string]struct{} // whether we have warned about this string
 	xattrSupported atomic.Int32        // whether xattrs are supported
+	versionDir     string              // where old versions are kept if set (OS path)
 
 	// do os.Lstat or os.Stat
 	lstat        func(name string) (os.FileInfo, error)
@@ -385,6 +436,7 @@ type Object struct {
 	hashes  map[hash.Type]string // Hashes
 	// these are read only and don't need the mutex held
 	translatedLink bool // Is this object a translated link
+	isVersion      bool // Is this object an old version from the version directory
 }
 
 // Directory represents a local filesystem directory
@@ -415,6 +467,12 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if opt.TranslateSymlinks && opt.FollowSymlinks {
 		return nil, errLinksAndCopyLinks
 	}
+	if opt.Versions && opt.VersionAt.IsSet() {
+		return nil, errors.New("local: can't use --local-versions and --local-version-at at the same time")
+	}
+	if (opt.Versions || opt.VersionAt.IsSet()) && opt.VersionDir == "" {
+		return nil, errNeedVersionDir
+	}
 
 	f := &Fs{
 		name:   name,
@@ -427,6 +485,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		f.xattrSupported.Store(1)
 	}
 	f.root = cleanRootPath(root, f.opt.NoUNC, f.opt.Enc)
+	if opt.VersionDir != "" {
+		f.versionDir = cleanRootPath(opt.VersionDir, f.opt.NoUNC, f.opt.Enc)
+	}
 	f.features = (&fs.Features{
 		CaseInsensitive:          f.caseInsensitive(),
 		CanHaveEmptyDirectories:  true,
@@ -446,10 +507,18 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if opt.FollowSymlinks {
 		f.lstat = os.Stat
 	}
-	if opt.NoClone {
-		// Disable server-side copy when --local-no-clone is set
+	if opt.NoClone || !canClone(f.root) {
+		// Disable server-side copy when --local-no-clone is set or
+		// the filesystem can't do reflinks
 		f.features.Copy = nil
 	}
+	if f.versionDir != "" || opt.VersionAt.IsSet() {
+		// Every change must go through Update and Remove to keep versions
+		f.features.Copy = nil
+		f.features.Move = nil
+		f.features.DirMove = nil
+		f.features.OpenWriterAt = nil
+	}
 
 	// Check to see if this points to a file
 	fi, err := f.lstat(f.root)
@@ -586,6 +655,9 @@ func (f *Fs) newObjectWithInfo(remote string, info os.FileInfo) (fs.Object, erro
 // NewObject finds the Object at remote.  If it can't be found
 // it returns the error ErrorObjectNotFound.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	if f.showVersions() {
+		return f.newObjectVersion(remote)
+	}
 	return f.newObjectWithInfo(remote, nil)
 }
 
@@ -608,6 +680,14 @@ func (f *Fs) newDirectory(dir string, fi os.FileInfo) *Directory {
 // This should return ErrDirNotFound if the directory isn't
 // found.
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if f.showVersions() {
+		return f.listVersions(ctx, dir)
+	}
+	return f.list(ctx, dir)
+}
+
+// list the objects and directories in dir into entries
+func (f *Fs) list(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
 	filter, useFilter := filter.GetConfig(ctx), filter.GetUseFilter(ctx)
 
 	fsDirPath := f.localPath(dir)
@@ -709,6 +789,10 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 				mode = fi.Mode()
 			}
 			if fi.IsDir() {
+				// Don't show the version directory if it is in the root
+				if f.versionDir != "" && filepath.Join(fsDirPath, name) == f.versionDir {
+					continue
+				}
 				// Ignore directories which are symlinks.  These are junction points under windows which
 				// are kind of a souped up symlink. Unix doesn't have directories which are symlinks.
 				if (mode&symlinkFlag) == 0 && f.dev == readDevice(fi, f.opt.OneFileSystem) {
@@ -1227,6 +1311,12 @@ func (o *Object) setTimes(atime, mtime time.Time) (err error) {
 
 // SetModTime sets the modification time of the local fs object
 func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
+	if o.isVersion {
+		return errVersionReadOnly
+	}
 	if o.fs.opt.NoSetModTime {
 		return nil
 	}
@@ -1415,6 +1505,13 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	var out io.WriteCloser
 	var hasher *hash.MultiHasher
 
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
+	if o.isVersion {
+		return errVersionReadOnly
+	}
+
 	for _, option := range options {
 		switch x := option.(type) {
 		case *fs.HashesOption:
@@ -1432,6 +1529,13 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		return err
 	}
 
+	// When keeping versions write the new data to a temporary file
+	// so the old version stays in place until it is complete
+	outPath := o.path
+	if o.fs.versionDir != "" {
+		outPath = filepath.Join(filepath.Dir(o.path), ".rclone-update-"+random.String(8))
+	}
+
 	// Wipe hashes before update
 	o.clearHashCache()
 
@@ -1440,13 +1544,13 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	// If it is a translated link, just read in the contents, and
 	// then create a symlink
 	if !o.translatedLink {
-		f, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		f, err := file.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 		if err != nil {
 			if runtime.GOOS == "windows" && os.IsPermission(err) {
 				// If permission denied on Windows might be trying to update a
 				// hidden file, in which case try opening without CREATE
 				// See: https://stackoverflow.com/questions/13215716/ioerror-errno-13-permission-denied-when-trying-to-open-hidden-file-in-w-mod
-				f, err = file.OpenFile(o.path, os.O_WRONLY|os.O_TRUNC, 0666)
+				f, err = file.OpenFile(outPath, os.O_WRONLY|os.O_TRUNC, 0666)
 				if err != nil {
 					return err
 				}
@@ -1484,14 +1588,14 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	if o.translatedLink {
 		if err == nil {
 			// Remove any current symlink or file, if one exists
-			if _, err := os.Lstat(o.path); err == nil {
-				if removeErr := os.Remove(o.path); removeErr != nil {
+			if _, err := os.Lstat(outPath); err == nil {
+				if removeErr := os.Remove(outPath); removeErr != nil {
 					fs.Errorf(o, "Failed to remove previous file: %v", removeErr)
 					return removeErr
 				}
 			}
 			// Use the contents for the copied object to create a symlink
-			err = os.Symlink(symlinkData.String(), o.path)
+			err = os.Symlink(symlinkData.String(), outPath)
 		}
 
 		// only continue if symlink creation succeeded
@@ -1502,12 +1606,23 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 
 	if err != nil {
 		fs.Logf(o, "Removing partially written file on error: %v", err)
-		if removeErr := os.Remove(o.path); removeErr != nil {
+		if removeErr := os.Remove(outPath); removeErr != nil {
 			fs.Errorf(o, "Failed to remove partially written file: %v", removeErr)
 		}
 		return err
 	}
 
+	// Keep the old version then move the new data into place
+	if outPath != o.path {
+		err = o.fs.replaceVersion(outPath, o.path)
+		if err != nil {
+			if removeErr := os.Remove(outPath); removeErr != nil {
+				fs.Errorf(o, "Failed to remove partially written file: %v", removeErr)
+			}
+			return err
+		}
+	}
+
 	// All successful so update the hashes
 	if hasher != nil {
 		o.fs.objectMetaMu.Lock()
@@ -1625,7 +1740,19 @@ func (o *Object) lstat() error {
 
 // Remove an object
 func (o *Object) Remove(ctx context.Context) error {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
 	o.clearHashCache()
+	if o.fs.versionDir != "" && !o.isVersion {
+		versionPath, err := o.fs.archive(o.path)
+		if err != nil {
+			return fmt.Errorf("failed to keep old version: %w", err)
+		}
+		if versionPath != "" {
+			return nil
+		}
+	}
 	return remove(o.path)
 }
 
@@ -1661,6 +1788,12 @@ func (o *Object) writeMetadata(metadata fs.Metadata) (err error) {
 //
 // It should return fs.ErrorNotImplemented if it can't set metadata
 func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
+	if o.isVersion {
+		return errVersionReadOnly
+	}
 	err := o.writeMetadata(metadata)
 	if err != nil {
 		return fmt.Errorf("SetMetadata failed on Object: %w", err)
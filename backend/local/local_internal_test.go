@@ -21,6 +21,7 @@ import (
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
 	"github.com/rclone/rclone/lib/file"
 	"github.com/rclone/rclone/lib/readers"
 	"github.com/stretchr/testify/assert"
@@ -677,3 +678,105 @@ func TestCopySymlink(t *testing.T) {
 	want = fstest.NewItem("dst2/file.txt", "hello world", when)
 	fstest.CompareItems(t, []fs.DirEntry{dst}, []fstest.Item{want}, nil, f.precision, "")
 }
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	// Put the version directory in the root to check it is hidden
+	versionDir := filepath.Join(root, ".versions")
+	newFs := func(m configmap.Simple) *Fs {
+		m["version_dir"] = versionDir
+		f, err := NewFs(ctx, "local", root, m)
+		require.NoError(t, err)
+		return f.(*Fs)
+	}
+	when := time.Now()
+	put := func(f *Fs, remote, contents string) {
+		info := object.NewStaticObjectInfo(remote, when, int64(len(contents)), true, nil, nil)
+		_, err := f.Put(ctx, bytes.NewBufferString(contents), info)
+		require.NoError(t, err)
+	}
+
+	f := newFs(configmap.Simple{})
+	assert.Nil(t, f.Features().Move)
+	assert.Nil(t, f.Features().Copy)
+	beforeCreate := fstest.TimeMark()
+	put(f, "dir/file.txt", "one")
+	afterCreate := fstest.TimeMark()
+	put(f, "dir/file.txt", "two")
+	afterUpdate := fstest.TimeMark()
+	o, err := f.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	put(f, "dir/other.txt", "other")
+	require.NoError(t, os.Remove(filepath.Join(root, "dir", "other.txt")))
+	put(f, "dir/other.txt", "other")
+	assert.Equal(t, []string{"dir"}, fstest.ListNames(ctx, t, f, ""))
+	assert.Equal(t, []string{"dir/other.txt"}, fstest.ListNames(ctx, t, f, "dir"))
+
+	// Check the options are validated
+	_, err = NewFs(ctx, "local", root, configmap.Simple{"versions": "true"})
+	assert.Equal(t, errNeedVersionDir, err)
+	_, err = NewFs(ctx, "local", root, configmap.Simple{"version_dir": versionDir, "versions": "true", "version_at": afterCreate})
+	require.Error(t, err)
+
+	t.Run("Versions", func(t *testing.T) {
+		fv := newFs(configmap.Simple{"versions": "true"})
+		names := fstest.ListNames(ctx, t, fv, "dir")
+		require.Len(t, names, 3)
+		assert.Equal(t, "dir/other.txt", names[2])
+		assert.Equal(t, "one", fstests.ReadObject(ctx, t, fstest.NewObject(ctx, t, fv, names[0]), -1))
+		assert.Equal(t, "two", fstests.ReadObject(ctx, t, fstest.NewObject(ctx, t, fv, names[1]), -1))
+
+		// Old versions can't be modified
+		o, err := fv.NewObject(ctx, names[0])
+		require.NoError(t, err)
+		assert.Equal(t, errVersionReadOnly, o.SetModTime(ctx, when))
+		err = o.Update(ctx, bytes.NewBufferString("new"), object.NewStaticObjectInfo(names[0], when, 3, true, nil, nil))
+		assert.Equal(t, errVersionReadOnly, err)
+	})
+
+	t.Run("VersionAt", func(t *testing.T) {
+		for _, test := range []struct {
+			at    string
+			want  []string
+			wantF string
+		}{
+			{at: beforeCreate, want: nil},
+			{at: afterCreate, want: []string{"dir/file.txt"}, wantF: "one"},
+			{at: afterUpdate, want: []string{"dir/file.txt"}, wantF: "two"},
+			{at: fstest.TimeMark(), want: []string{"dir/other.txt"}},
+		} {
+			fat := newFs(configmap.Simple{"version_at": test.at})
+			assert.Equal(t, test.want, fstest.ListNames(ctx, t, fat, "dir"), test.at)
+			if test.wantF != "" {
+				assert.Equal(t, test.wantF, fstests.ReadObject(ctx, t, fstest.NewObject(ctx, t, fat, "dir/file.txt"), -1))
+				o, err := fat.NewObject(ctx, "dir/file.txt")
+				require.NoError(t, err)
+				assert.Equal(t, errNotWithVersionAt, o.Remove(ctx))
+			} else {
+				_, err := fat.NewObject(ctx, "dir/file.txt")
+				assert.Equal(t, fs.ErrorObjectNotFound, err)
+			}
+		}
+	})
+
+	t.Run("DeletedDirectory", func(t *testing.T) {
+		o, err := f.NewObject(ctx, "dir/other.txt")
+		require.NoError(t, err)
+		require.NoError(t, o.Remove(ctx))
+		require.NoError(t, f.Rmdir(ctx, "dir"))
+		_, err = f.List(ctx, "dir")
+		assert.Equal(t, fs.ErrorDirNotFound, err)
+
+		fv := newFs(configmap.Simple{"versions": "true"})
+		assert.Equal(t, []string{"dir"}, fstest.ListNames(ctx, t, fv, ""))
+		names := fstest.ListNames(ctx, t, fv, "dir")
+		require.Len(t, names, 3)
+		for _, name := range names {
+			o, err := fv.NewObject(ctx, name)
+			require.NoError(t, err)
+			require.NoError(t, o.Remove(ctx))
+		}
+	})
+}
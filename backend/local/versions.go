@@ -0,0 +1,397 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/version"
+)
+
+// The version directory has two trees which mirror the absolute paths
+// of the files.
+const (
+	versionsTree = "versions" // old versions named with the time they were replaced or deleted
+	createdTree  = "created"  // empty markers named with the time a file was created
+)
+
+var (
+	errNotWithVersionAt = errors.New("can't modify or delete files in --local-version-at mode")
+	errVersionReadOnly  = errors.New("can't modify an old version of a file - only delete it")
+	errNeedVersionDir   = errors.New("local: --local-versions and --local-version-at need --local-version-dir")
+)
+
+// storedVersion is an entry in one of the trees of the version directory
+type storedVersion struct {
+	t       time.Time   // time in the name
+	name    string      // leaf name in the tree
+	base    string      // leaf name of the file it is for
+	info    os.FileInfo // info about the entry
+	created bool        // set if this is a created marker
+}
+
+// showVersions returns true if the listings should come from the
+// version directory as well as the files
+func (f *Fs) showVersions() bool {
+	return f.opt.Versions || f.opt.VersionAt.IsSet()
+}
+
+// mirrorPath returns the path in tree of the version directory which
+// mirrors the absolute path p
+func (f *Fs) mirrorPath(tree, p string) string {
+	vol := filepath.VolumeName(p)
+	rest := p[len(vol):]
+	// Turn `C:` or `\\?\UNC\server\share` into a directory name
+	vol = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, vol)
+	return filepath.Join(f.versionDir, tree, vol, rest)
+}
+
+// newStorePath returns an unused path in tree of the version directory
+// for the file at p named with the current time, making its directory.
+func (f *Fs) newStorePath(tree, p string) (string, error) {
+	dir, leaf := filepath.Split(f.mirrorPath(tree, p))
+	err := file.MkdirAll(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+	t := time.Now().UTC()
+	for {
+		storePath := filepath.Join(dir, version.Add(leaf, t))
+		_, err := os.Lstat(storePath)
+		if os.IsNotExist(err) {
+			return storePath, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		// The version names only have millisecond precision
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// archive moves the file at p into the version directory returning
+// the path it was moved to, or "" if there isn't a file at p.
+func (f *Fs) archive(p string) (string, error) {
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	versionPath, err := f.newStorePath(versionsTree, p)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(p, versionPath)
+	if err != nil {
+		return "", err
+	}
+	return versionPath, nil
+}
+
+// replaceVersion moves the new data for the file at p from tmp into
+// place, keeping the existing file in the version directory or if
+// there isn't one recording that the file was created.
+//
+// The existing file is hard linked into the version directory so p
+// is never missing. If that isn't possible it is moved there just
+// before tmp replaces it.
+func (f *Fs) replaceVersion(tmp, p string) (err error) {
+	undo, err := f.keepVersion(p)
+	if err != nil {
+		return fmt.Errorf("failed to keep old version: %w", err)
+	}
+	err = os.Rename(tmp, p)
+	if err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// keepVersion puts the file at p into the version directory, or if
+// there isn't one records that the file is being created.
+//
+// It returns a function to undo this if replacing p fails.
+func (f *Fs) keepVersion(p string) (undo func(), err error) {
+	fi, err := os.Lstat(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && !fi.IsDir() {
+		versionPath, err := f.newStorePath(versionsTree, p)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Link(p, versionPath)
+		if err == nil {
+			return func() {
+				_ = os.Remove(versionPath)
+			}, nil
+		}
+		fs.Debugf(p, "Moving old version as hard linking failed: %v", err)
+		err = os.Rename(p, versionPath)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			if err := os.Rename(versionPath, p); err != nil {
+				fs.Errorf(p, "Failed to restore old version: %v", err)
+			}
+		}, nil
+	}
+	markerPath, err := f.newStorePath(createdTree, p)
+	if err != nil {
+		return nil, err
+	}
+	marker, err := file.OpenFile(markerPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = marker.Close()
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = os.Remove(markerPath)
+	}, nil
+}
+
+// readStore reads the entries in tree of the version directory for
+// the directory dirPath.
+//
+// It returns the versions of files and the subdirectories.
+func (f *Fs) readStore(tree, dirPath string) (versions []storedVersion, dirs []os.FileInfo, err error) {
+	storeDir := f.mirrorPath(tree, dirPath)
+	dirEntries, err := os.ReadDir(storeDir)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read version directory: %w", err)
+	}
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read version directory: %w", err)
+		}
+		if info.IsDir() {
+			dirs = append(dirs, info)
+			continue
+		}
+		name := dirEntry.Name()
+		t, base := version.Remove(name)
+		if t.IsZero() {
+			continue
+		}
+		versions = append(versions, storedVersion{
+			t:       t,
+			name:    name,
+			base:    base,
+			info:    info,
+			created: tree == createdTree,
+		})
+	}
+	return versions, dirs, nil
+}
+
+// versionsAt finds what the files in the directory dirPath were at
+// the --local-version-at time.
+//
+// It returns the first entry in the version directory after that time
+// for each file which has one. If it is a created marker then the
+// file didn't exist, otherwise the file was that version. Files
+// without an entry haven't changed since.
+func (f *Fs) versionsAt(dirPath string) (map[string]storedVersion, error) {
+	versions, _, err := f.readStore(versionsTree, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	created, _, err := f.readStore(createdTree, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	at := time.Time(f.opt.VersionAt)
+	next := make(map[string]storedVersion)
+	for _, v := range append(versions, created...) {
+		if !v.t.After(at) {
+			continue
+		}
+		cur, found := next[v.base]
+		// A version replaced at the same time as a file was
+		// created must have existed before it
+		if !found || v.t.Before(cur.t) || (v.t.Equal(cur.t) && !v.created) {
+			next[v.base] = v
+		}
+	}
+	return next, nil
+}
+
+// newVersionObject makes an Object for remote which is stored at
+// storePath in the version directory
+func (f *Fs) newVersionObject(remote, storePath string) *Object {
+	o := f.newObject(remote)
+	o.path = storePath
+	o.isVersion = true
+	return o
+}
+
+// storedRemote returns the remote for the entry v in the version
+// directory for dir called name, or "" if it shouldn't be listed.
+func (f *Fs) storedRemote(ctx context.Context, dir, name string, v storedVersion) string {
+	mode := v.info.Mode()
+	if mode&os.ModeSymlink != 0 {
+		if !f.opt.TranslateSymlinks {
+			return ""
+		}
+		name += fs.LinkSuffix
+	} else if !mode.IsRegular() {
+		return ""
+	}
+	remote := f.cleanRemote(dir, name)
+	if filter.GetUseFilter(ctx) && !filter.GetConfig(ctx).IncludeRemote(remote) {
+		return ""
+	}
+	return remote
+}
+
+// listVersions lists dir merging in the entries from the version
+// directory for --local-versions and --local-version-at
+func (f *Fs) listVersions(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = f.list(ctx, dir)
+	dirNotFound := errors.Is(err, fs.ErrorDirNotFound)
+	if err != nil && !dirNotFound {
+		return nil, err
+	}
+	dirPath := f.localPath(dir)
+	versions, storeDirs, err := f.readStore(versionsTree, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dirNotFound && len(versions) == 0 && len(storeDirs) == 0 {
+		return nil, fs.ErrorDirNotFound
+	}
+
+	// Add the directories which only exist in the version directory
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		seen[entry.Remote()] = struct{}{}
+	}
+	for _, fi := range storeDirs {
+		remote := f.cleanRemote(dir, fi.Name())
+		if _, found := seen[remote]; !found {
+			entries = append(entries, f.newDirectory(remote, fi))
+		}
+	}
+
+	if f.opt.Versions {
+		for _, v := range versions {
+			remote := f.storedRemote(ctx, dir, v.name, v)
+			if remote == "" {
+				continue
+			}
+			o := f.newVersionObject(remote, filepath.Join(f.mirrorPath(versionsTree, dirPath), v.name))
+			o.setMetadata(v.info)
+			entries = append(entries, o)
+		}
+		return entries, nil
+	}
+
+	// Show the files as they were at --local-version-at
+	next, err := f.versionsAt(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	versionDir := f.mirrorPath(versionsTree, dirPath)
+	atEntries := entries[:0]
+	for _, entry := range entries {
+		o, ok := entry.(*Object)
+		if !ok {
+			atEntries = append(atEntries, entry)
+			continue
+		}
+		base := filepath.Base(o.path)
+		v, found := next[base]
+		if !found {
+			atEntries = append(atEntries, o)
+			continue
+		}
+		delete(next, base)
+		if v.created {
+			continue
+		}
+		vo := f.newVersionObject(o.remote, filepath.Join(versionDir, v.name))
+		vo.setMetadata(v.info)
+		atEntries = append(atEntries, vo)
+	}
+	// Add the files which have been deleted since
+	for base, v := range next {
+		if v.created {
+			continue
+		}
+		remote := f.storedRemote(ctx, dir, base, v)
+		if remote == "" {
+			continue
+		}
+		vo := f.newVersionObject(remote, filepath.Join(versionDir, v.name))
+		vo.setMetadata(v.info)
+		atEntries = append(atEntries, vo)
+	}
+	return atEntries, nil
+}
+
+// newObjectVersion finds the Object at remote for --local-versions and
+// --local-version-at
+func (f *Fs) newObjectVersion(remote string) (fs.Object, error) {
+	var o *Object
+	if f.opt.VersionAt.IsSet() {
+		dirPath, base := filepath.Split(f.newObject(remote).path)
+		dirPath = filepath.Clean(dirPath)
+		next, err := f.versionsAt(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		v, found := next[base]
+		if !found {
+			return f.newObjectWithInfo(remote, nil)
+		}
+		if v.created {
+			return nil, fs.ErrorObjectNotFound
+		}
+		o = f.newVersionObject(remote, filepath.Join(f.mirrorPath(versionsTree, dirPath), v.name))
+	} else {
+		obj, err := f.newObjectWithInfo(remote, nil)
+		if !errors.Is(err, fs.ErrorObjectNotFound) || !version.Match(remote) {
+			return obj, err
+		}
+		o = f.newVersionObject(remote, f.mirrorPath(versionsTree, f.newObject(remote).path))
+	}
+	err := o.lstat()
+	if os.IsNotExist(err) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if o.mode.IsDir() || (o.mode&os.ModeSymlink != 0 && !o.translatedLink) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return o, nil
+}
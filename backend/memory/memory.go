@@ -52,6 +52,43 @@ Probably most easily used by using the connection string syntax:
 
     :memory,discard:bucket
 
+`,
+		}, {
+			Name:     "versioning",
+			Default:  false,
+			Advanced: true,
+			Help: `Keep old versions of objects.
+
+If set then when an object is overwritten or deleted the old version
+is kept. The old versions can be seen with --memory-versions or
+--memory-version-at.
+
+The versions are kept in the bucket, so a bucket with old versions
+can't be removed.
+`,
+		}, {
+			Name:     "versions",
+			Default:  false,
+			Advanced: true,
+			Help: `Include old versions in directory listings.
+
+The old versions are shown with the time they were replaced or deleted
+in their name, like the versions shown with --s3-versions. They can be
+read and deleted but not modified.
+`,
+		}, {
+			Name:     "version_at",
+			Default:  fs.Time{},
+			Advanced: true,
+			Help: `Show file versions as they were at the specified time.
+
+The parameter should be a date, "2006-01-02", datetime "2006-01-02
+15:04:05" or a duration for that long ago, eg "100d" or "1h".
+
+Note that when using this no file write operations are permitted,
+so you can't upload files or delete them.
+
+See [the time option docs](/docs/#time-options) for valid formats.
 `,
 		}},
 	})
@@ -59,7 +96,10 @@ Probably most easily used by using the connection string syntax:
 
 // Options defines the configuration for this backend
 type Options struct {
-	Discard bool `config:"discard"`
+	Discard    bool    `config:"discard"`
+	Versioning bool    `config:"versioning"`
+	Versions   bool    `config:"versions"`
+	VersionAt  fs.Time `config:"version_at"`
 }
 
 // Fs represents a remote memory server
@@ -130,20 +170,31 @@ func (bi *bucketsInfo) getObjectData(bucketName, bucketPath string) (od *objectD
 }
 
 // updateObjectData updates an object from (bucketName, bucketPath)
-func (bi *bucketsInfo) updateObjectData(bucketName, bucketPath string, od *objectData) {
+//
+// If keepVersion is set then the object being replaced is kept as an
+// old version.
+func (bi *bucketsInfo) updateObjectData(bucketName, bucketPath string, od *objectData, keepVersion bool) {
 	b := bi.makeBucket(bucketName)
 	b.mu.Lock()
+	if keepVersion {
+		b.keepVersion(bucketPath)
+	}
 	b.objects[bucketPath] = od
 	b.mu.Unlock()
 }
 
 // removeObjectData removes an object from (bucketName, bucketPath) returning true if removed
-func (bi *bucketsInfo) removeObjectData(bucketName, bucketPath string) (removed bool) {
+//
+// If keepVersion is set then the object is kept as an old version.
+func (bi *bucketsInfo) removeObjectData(bucketName, bucketPath string, keepVersion bool) (removed bool) {
 	b := bi.getBucket(bucketName)
 	if b != nil {
 		b.mu.Lock()
 		od := b.objects[bucketPath]
 		if od != nil {
+			if keepVersion {
+				b.keepVersion(bucketPath)
+			}
 			delete(b.objects, bucketPath)
 			removed = true
 		}
@@ -154,13 +205,15 @@ func (bi *bucketsInfo) removeObjectData(bucketName, bucketPath string) (removed
 
 // bucketInfo holds info about a single bucket
 type bucketInfo struct {
-	mu      sync.RWMutex
-	objects map[string]*objectData
+	mu       sync.RWMutex
+	objects  map[string]*objectData
+	versions map[string][]objectVersion // old versions of objects in time order
 }
 
 func newBucketInfo() *bucketInfo {
 	return &bucketInfo{
-		objects: make(map[string]*objectData, 16),
+		objects:  make(map[string]*objectData, 16),
+		versions: make(map[string][]objectVersion),
 	}
 }
 
@@ -175,7 +228,7 @@ func (bi *bucketInfo) getObjectData(name string) (od *objectData) {
 // getBucket gets a names bucket or nil
 func (bi *bucketInfo) isEmpty() (empty bool) {
 	bi.mu.RLock()
-	empty = len(bi.objects) == 0
+	empty = len(bi.objects) == 0 && !bi.hasVersions()
 	bi.mu.RUnlock()
 	return empty
 }
@@ -191,9 +244,10 @@ type objectData struct {
 
 // Object describes a memory object
 type Object struct {
-	fs     *Fs         // what this object is part of
-	remote string      // The remote path
-	od     *objectData // the object data
+	fs        *Fs         // what this object is part of
+	remote    string      // The remote path
+	od        *objectData // the object data
+	isVersion bool        // set if this is an old version
 }
 
 // ------------------------------------------------------------
@@ -255,6 +309,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		root: root,
 		opt:  *opt,
 	}
+	if opt.Versions && opt.VersionAt.IsSet() {
+		return nil, errors.New("memory: can't use --memory-versions and --memory-version-at at the same time")
+	}
 	f.setRoot(root)
 	f.features = (&fs.Features{
 		ReadMimeType:      true,
@@ -286,6 +343,9 @@ func (f *Fs) newObject(remote string, od *objectData) *Object {
 // it returns the error fs.ErrorObjectNotFound.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	bucket, bucketPath := f.split(remote)
+	if f.showVersions() {
+		return f.newObjectVersion(remote, bucket, bucketPath)
+	}
 	od := buckets.getObjectData(bucket, bucketPath)
 	if od == nil {
 		return nil, fs.ErrorObjectNotFound
@@ -311,7 +371,11 @@ func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBuck
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	dirs := make(map[string]struct{})
-	for absPath, od := range b.objects {
+	objects := b.objects
+	if f.showVersions() {
+		objects = f.versionObjects(b)
+	}
+	for absPath, od := range objects {
 		if strings.HasPrefix(absPath, directory) {
 			remote := absPath[len(prefix):]
 			if !recurse {
@@ -338,7 +402,9 @@ func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBuck
 			if addBucket {
 				remote = path.Join(bucket, remote)
 			}
-			err = fn(remote, f.newObject(remote, od), false)
+			o := f.newObject(remote, od)
+			o.isVersion = od != b.objects[absPath]
+			err = fn(remote, o, false)
 			if err != nil {
 				return err
 			}
@@ -534,6 +600,9 @@ func (f *Fs) Precision() time.Duration {
 //
 // If it isn't possible then return fs.ErrorCantCopy
 func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	if f.opt.VersionAt.IsSet() {
+		return nil, errNotWithVersionAt
+	}
 	dstBucket, dstPath := f.split(remote)
 	_ = buckets.makeBucket(dstBucket)
 	srcObj, ok := src.(*Object)
@@ -541,13 +610,16 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		fs.Debugf(src, "Can't copy - not same remote type")
 		return nil, fs.ErrorCantCopy
 	}
-	srcBucket, srcPath := srcObj.split()
-	od := buckets.getObjectData(srcBucket, srcPath)
-	if od == nil {
-		return nil, fs.ErrorObjectNotFound
+	od := srcObj.od
+	if !srcObj.isVersion {
+		srcBucket, srcPath := srcObj.split()
+		od = buckets.getObjectData(srcBucket, srcPath)
+		if od == nil {
+			return nil, fs.ErrorObjectNotFound
+		}
 	}
 	odCopy := *od
-	buckets.updateObjectData(dstBucket, dstPath, &odCopy)
+	buckets.updateObjectData(dstBucket, dstPath, &odCopy, f.opt.Versioning)
 	return f.NewObject(ctx, remote)
 }
 
@@ -605,6 +677,12 @@ func (o *Object) ModTime(ctx context.Context) (result time.Time) {
 
 // SetModTime sets the modification time of the local fs object
 func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
+	if o.isVersion {
+		return errVersionReadOnly
+	}
 	o.od.modTime = modTime
 	return nil
 }
@@ -649,6 +727,12 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.Read
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
+	if o.isVersion {
+		return errVersionReadOnly
+	}
 	bucket, bucketPath := o.split()
 	var data []byte
 	var size int64
@@ -671,14 +755,22 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		modTime:  src.ModTime(ctx),
 		mimeType: fs.MimeType(ctx, src),
 	}
-	buckets.updateObjectData(bucket, bucketPath, o.od)
+	buckets.updateObjectData(bucket, bucketPath, o.od, o.fs.opt.Versioning)
 	return nil
 }
 
 // Remove an object
 func (o *Object) Remove(ctx context.Context) error {
+	if o.fs.opt.VersionAt.IsSet() {
+		return errNotWithVersionAt
+	}
 	bucket, bucketPath := o.split()
-	removed := buckets.removeObjectData(bucket, bucketPath)
+	var removed bool
+	if o.isVersion {
+		removed = buckets.removeVersion(bucket, bucketPath)
+	} else {
+		removed = buckets.removeObjectData(bucket, bucketPath, o.fs.opt.Versioning)
+	}
 	if !removed {
 		return fs.ErrorObjectNotFound
 	}
@@ -1,14 +1,19 @@
 package memory
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"testing"
 
 	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,3 +43,89 @@ func testPurgeListDeadlock(t *testing.T) {
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	newFs := func(m configmap.Simple) *Fs {
+		f, err := NewFs(ctx, "memory", "versions-test", m)
+		require.NoError(t, err)
+		return f.(*Fs)
+	}
+	put := func(f *Fs, remote, contents string) {
+		info := object.NewStaticObjectInfo(remote, t1, int64(len(contents)), true, nil, nil)
+		_, err := f.Put(ctx, bytes.NewBufferString(contents), info)
+		require.NoError(t, err)
+	}
+
+	f := newFs(configmap.Simple{"versioning": "true"})
+	beforeCreate := fstest.TimeMark()
+	put(f, "file.txt", "one")
+	afterCreate := fstest.TimeMark()
+	put(f, "file.txt", "two")
+	afterUpdate := fstest.TimeMark()
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	put(f, "other.txt", "other")
+
+	// Can't use both
+	_, err = NewFs(ctx, "memory", "versions-test", configmap.Simple{"versions": "true", "version_at": afterCreate})
+	require.Error(t, err)
+
+	t.Run("Versions", func(t *testing.T) {
+		fv := newFs(configmap.Simple{"versions": "true"})
+		names := fstest.ListNames(ctx, t, fv, "")
+		require.Len(t, names, 3)
+		assert.Equal(t, "other.txt", names[2])
+		assert.Equal(t, "one", fstests.ReadObject(ctx, t, fstest.NewObject(ctx, t, fv, names[0]), -1))
+		assert.Equal(t, "two", fstests.ReadObject(ctx, t, fstest.NewObject(ctx, t, fv, names[1]), -1))
+
+		// Old versions can't be modified
+		o, err := fv.NewObject(ctx, names[0])
+		require.NoError(t, err)
+		assert.Equal(t, errVersionReadOnly, o.SetModTime(ctx, t1))
+		err = o.Update(ctx, bytes.NewBufferString("new"), object.NewStaticObjectInfo(names[0], t1, 3, true, nil, nil))
+		assert.Equal(t, errVersionReadOnly, err)
+	})
+
+	t.Run("VersionAt", func(t *testing.T) {
+		for _, test := range []struct {
+			at    string
+			want  []string
+			wantF string
+		}{
+			{at: beforeCreate, want: nil},
+			{at: afterCreate, want: []string{"file.txt"}, wantF: "one"},
+			{at: afterUpdate, want: []string{"file.txt"}, wantF: "two"},
+			{at: fstest.TimeMark(), want: []string{"other.txt"}},
+		} {
+			fat := newFs(configmap.Simple{"version_at": test.at})
+			assert.Equal(t, test.want, fstest.ListNames(ctx, t, fat, ""), test.at)
+			if test.wantF != "" {
+				assert.Equal(t, test.wantF, fstests.ReadObject(ctx, t, fstest.NewObject(ctx, t, fat, "file.txt"), -1))
+				o, err := fat.NewObject(ctx, "file.txt")
+				require.NoError(t, err)
+				assert.Equal(t, errNotWithVersionAt, o.Remove(ctx))
+			} else {
+				_, err := fat.NewObject(ctx, "file.txt")
+				assert.Equal(t, fs.ErrorObjectNotFound, err)
+			}
+		}
+	})
+
+	t.Run("RemoveVersions", func(t *testing.T) {
+		fv := newFs(configmap.Simple{"versions": "true"})
+		// The bucket can't be removed while it has old versions
+		fr := newFs(configmap.Simple{})
+		require.NoError(t, operations.Delete(ctx, fr))
+		names := fstest.ListNames(ctx, t, fv, "")
+		require.Len(t, names, 2)
+		assert.Equal(t, fs.ErrorDirectoryNotEmpty, fr.Rmdir(ctx, ""))
+		for _, name := range names {
+			o, err := fv.NewObject(ctx, name)
+			require.NoError(t, err)
+			require.NoError(t, o.Remove(ctx))
+		}
+		require.NoError(t, fr.Rmdir(ctx, ""))
+	})
+}
@@ -0,0 +1,184 @@
+package memory
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/version"
+)
+
+var (
+	errNotWithVersionAt = errors.New("can't modify or delete files in --memory-version-at mode")
+	errVersionReadOnly  = errors.New("can't modify an old version of a file - only delete it")
+)
+
+// objectVersion is an old version of an object
+type objectVersion struct {
+	t  time.Time   // when this version was replaced or deleted
+	od *objectData // the old version or nil if the object didn't exist
+}
+
+// keepVersion keeps the current version of the object name before it
+// is replaced or deleted.
+//
+// If there isn't a current version then this records that the object
+// didn't exist before now.
+//
+// Call with bi.mu held
+func (bi *bucketInfo) keepVersion(name string) {
+	versions := bi.versions[name]
+	// Versions are named with millisecond precision so make the
+	// time of each one unique
+	t := time.Now().UTC().Truncate(time.Millisecond)
+	if n := len(versions); n > 0 && !t.After(versions[n-1].t) {
+		t = versions[n-1].t.Add(time.Millisecond)
+	}
+	bi.versions[name] = append(versions, objectVersion{t: t, od: bi.objects[name]})
+}
+
+// hasVersions returns true if there are any old versions in the bucket
+//
+// Call with bi.mu held
+func (bi *bucketInfo) hasVersions() bool {
+	for _, versions := range bi.versions {
+		for _, v := range versions {
+			if v.od != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// objectDataAt returns the version of the object name at time at
+// or nil if it didn't exist.
+//
+// Call with bi.mu held
+func (bi *bucketInfo) objectDataAt(name string, at time.Time) *objectData {
+	for _, v := range bi.versions[name] {
+		if v.t.After(at) {
+			return v.od
+		}
+	}
+	return bi.objects[name]
+}
+
+// getVersion gets the old version called versionPath (a path with a
+// version string) from bucketName or nil
+func (bi *bucketsInfo) getVersion(bucketName, versionPath string) (od *objectData) {
+	t, bucketPath := version.Remove(versionPath)
+	if t.IsZero() {
+		return nil
+	}
+	b := bi.getBucket(bucketName)
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, v := range b.versions[bucketPath] {
+		if v.t.Equal(t) {
+			return v.od
+		}
+	}
+	return nil
+}
+
+// removeVersion removes the old version called versionPath from
+// bucketName returning true if removed
+func (bi *bucketsInfo) removeVersion(bucketName, versionPath string) (removed bool) {
+	t, bucketPath := version.Remove(versionPath)
+	b := bi.getBucket(bucketName)
+	if t.IsZero() || b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	versions := b.versions[bucketPath]
+	for i, v := range versions {
+		if v.t.Equal(t) && v.od != nil {
+			versions = append(versions[:i], versions[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	b.versions[bucketPath] = versions
+	// Forget the history of deleted objects with no old versions left
+	if b.objects[bucketPath] != nil {
+		return removed
+	}
+	for _, v := range versions {
+		if v.od != nil {
+			return removed
+		}
+	}
+	delete(b.versions, bucketPath)
+	return removed
+}
+
+// showVersions returns true if the listings should include old versions
+func (f *Fs) showVersions() bool {
+	return f.opt.Versions || f.opt.VersionAt.IsSet()
+}
+
+// versionObjects returns the objects in b to list for --memory-versions
+// or --memory-version-at
+//
+// Call with b.mu held
+func (f *Fs) versionObjects(b *bucketInfo) map[string]*objectData {
+	objects := make(map[string]*objectData, len(b.objects))
+	if f.opt.Versions {
+		for name, od := range b.objects {
+			objects[name] = od
+		}
+		for name, versions := range b.versions {
+			for _, v := range versions {
+				if v.od != nil {
+					objects[version.Add(name, v.t)] = v.od
+				}
+			}
+		}
+		return objects
+	}
+	at := time.Time(f.opt.VersionAt)
+	for name := range b.objects {
+		if od := b.objectDataAt(name, at); od != nil {
+			objects[name] = od
+		}
+	}
+	for name := range b.versions {
+		if od := b.objectDataAt(name, at); od != nil {
+			objects[name] = od
+		}
+	}
+	return objects
+}
+
+// newObjectVersion finds the Object at remote for --memory-versions or
+// --memory-version-at
+func (f *Fs) newObjectVersion(remote, bucket, bucketPath string) (fs.Object, error) {
+	var od *objectData
+	isVersion := true
+	if f.opt.VersionAt.IsSet() {
+		if b := buckets.getBucket(bucket); b != nil {
+			b.mu.RLock()
+			od = b.objectDataAt(bucketPath, time.Time(f.opt.VersionAt))
+			isVersion = od != b.objects[bucketPath]
+			b.mu.RUnlock()
+		}
+	} else {
+		od = buckets.getObjectData(bucket, bucketPath)
+		if od != nil {
+			isVersion = false
+		} else {
+			od = buckets.getVersion(bucket, bucketPath)
+		}
+	}
+	if od == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	o := f.newObject(remote, od)
+	o.isVersion = isVersion
+	return o, nil
+}
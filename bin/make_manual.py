@@ -43,9 +43,11 @@ docs = [
     "crypt.md",
     "compress.md",
     "combine.md",
+    "dedup.md",
     "doi.md",
     "drime.md",
     "dropbox.md",
+    "erasure.md",
     "filefabric.md",
     "filelu.md",
     "filen.md",
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -158,7 +159,7 @@ func init() {
 
 // bisync command definition
 var commandDefinition = &cobra.Command{
-	Use:   "bisync remote1:path1 remote2:path2",
+	Use:   "bisync remote1:path1 remote2:path2 [remote3:path3 ...]",
 	Short: shortHelp,
 	Long:  longHelp,
 	Annotations: map[string]string{
@@ -168,11 +169,19 @@ var commandDefinition = &cobra.Command{
 	RunE: func(command *cobra.Command, args []string) error {
 		// NOTE: avoid putting too much handling here, as it won't apply to the rc.
 		// Generally it's best to put init-type stuff in Bisync() (operations.go)
-		cmd.CheckArgs(2, 2, command, args)
-		fs1, file1, fs2, file2 := cmd.NewFsSrcDstFiles(args)
+		cmd.CheckArgs(2, math.MaxInt, command, args)
+		fs1, file1, fs2, file2 := cmd.NewFsSrcDstFiles(args[:2])
 		if file1 != "" || file2 != "" {
 			return errors.New("paths must be existing directories")
 		}
+		fses := []fs.Fs{fs1, fs2}
+		for _, arg := range args[2:] {
+			f, file := cmd.NewFsFile(arg)
+			if file != "" {
+				return errors.New("paths must be existing directories")
+			}
+			fses = append(fses, f)
+		}
 
 		ctx := context.Background()
 		opt := Opt
@@ -181,10 +190,13 @@ var commandDefinition = &cobra.Command{
 			TZ = time.Local
 		}
 
-		commonHashes := fs1.Hashes().Overlap(fs2.Hashes())
-		isDropbox1 := strings.HasPrefix(fs1.String(), "Dropbox")
-		isDropbox2 := strings.HasPrefix(fs2.String(), "Dropbox")
-		if commonHashes == hash.Set(0) && (isDropbox1 || isDropbox2) {
+		commonHashes := fs1.Hashes()
+		isDropbox := false
+		for _, f := range fses {
+			commonHashes = commonHashes.Overlap(f.Hashes())
+			isDropbox = isDropbox || strings.HasPrefix(f.String(), "Dropbox")
+		}
+		if commonHashes == hash.Set(0) && isDropbox {
 			ci := fs.GetConfig(ctx)
 			if !ci.DryRun && !ci.RefreshTimes {
 				fs.Debugf(nil, "Using flag --refresh-times is recommended")
@@ -192,7 +204,7 @@ var commandDefinition = &cobra.Command{
 		}
 
 		cmd.Run(false, true, command, func() error {
-			err := Bisync(ctx, fs1, fs2, &opt)
+			err := BisyncPaths(ctx, fses, &opt)
 			if err == ErrBisyncAborted {
 				return fserrors.FatalError(err)
 			}
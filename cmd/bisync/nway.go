@@ -0,0 +1,1020 @@
+package bisync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/cmd/bisync/bilib"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/terminal"
+	"golang.org/x/sync/errgroup"
+)
+
+// nwayRun keeps the runtime state of a bisync between more than two paths
+//
+// Rather than a listing per path, all the paths share a single
+// baseline listing of how they were after the last successful run.
+type nwayRun struct {
+	*bisyncRun
+	fses     []fs.Fs
+	listing  string      // the shared baseline listing
+	hashType hash.Type   // hash used in the listings, if any
+	now      []*fileList // listings of the paths, updated as changes are made
+	applying bool        // set once we have started changing the paths
+	rmdirs   []rmdir     // directories to remove once the files are done
+	copies   []nwayCopy  // files to copy once the changes are found
+	mu       sync.Mutex  // protects now and critical while copying
+}
+
+// rmdir is a directory to remove from a path
+type rmdir struct {
+	pathNum int
+	dir     string
+}
+
+// nwayCopy is a file to copy from one path to another
+type nwayCopy struct {
+	src     int       // path to copy from
+	dst     int       // path to copy to
+	file    string    // name of the file on src
+	newName string    // name of the file on dst
+	info    *fileInfo // listing of the file on src
+}
+
+// BisyncPaths runs bisync between all of fses.
+//
+// With two paths this is the same as Bisync. With more, deltas are
+// found on each path relative to a single shared listing and are
+// propagated to all the other paths.
+func BisyncPaths(ctx context.Context, fses []fs.Fs, optArg *Options) (err error) {
+	if len(fses) < 2 {
+		return errors.New("bisync needs at least two paths")
+	}
+	if len(fses) == 2 {
+		return Bisync(ctx, fses[0], fses[1], optArg)
+	}
+	opt := *optArg // ensure that input is never changed
+	b := &bisyncRun{
+		fs1:       fses[0],
+		fs2:       fses[1],
+		opt:       &opt,
+		DebugName: opt.DebugName,
+	}
+	n := &nwayRun{
+		bisyncRun: b,
+		fses:      fses,
+	}
+
+	if opt.CheckFilename == "" {
+		opt.CheckFilename = DefaultCheckFilename
+	}
+	if opt.Workdir == "" {
+		opt.Workdir = DefaultWorkdir
+	}
+	ci := fs.GetConfig(ctx)
+	opt.OrigBackupDir = ci.BackupDir
+
+	if ci.TerminalColorMode == fs.TerminalColorModeAlways || (ci.TerminalColorMode == fs.TerminalColorModeAuto && !log.Redirected()) {
+		ColorsLock.Lock()
+		Colors = true
+		ColorsLock.Unlock()
+	}
+
+	err = b.setCompareDefaults(ctx)
+	if err != nil {
+		return err
+	}
+	b.setResyncDefaults()
+	err = b.setResolveDefaults()
+	if err != nil {
+		return err
+	}
+	err = n.checkOptions()
+	if err != nil {
+		return err
+	}
+
+	if b.workDir, err = filepath.Abs(opt.Workdir); err != nil {
+		return fmt.Errorf("failed to make workdir absolute: %w", err)
+	}
+	if err = os.MkdirAll(b.workDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create workdir: %w", err)
+	}
+
+	// Produce a unique name for the sync operation
+	b.basePath = NwayBasePath(b.workDir, fses)
+	n.listing = b.basePath + ".lst"
+	b.aliases = bilib.AliasMap{}
+
+	for _, f := range fses {
+		path := bilib.FsPath(f)
+		if strings.Count(path, `"`)%2 != 0 {
+			return fmt.Errorf(Color(terminal.RedFg, `detected an odd number of quotes in your path. This is usually a mistake indicating incorrect escaping: %v`), path)
+		}
+	}
+
+	// Handle lock file
+	err = b.setLockFile()
+	if err != nil {
+		return err
+	}
+
+	// Handle SIGINT
+	fnHandle := atexit.Register(func() {
+		if !atexit.Signalled() {
+			return
+		}
+		if n.applying && !b.opt.Resync {
+			fs.Log(nil, Color(terminal.RedFg, "Bisync interrupted. Must run --resync to recover."))
+			markFailed(n.listing)
+		}
+		_ = b.removeLockFile()
+	})
+	defer atexit.Unregister(fnHandle)
+
+	// run bisync
+	err = n.runLocked(ctx)
+
+	removeLockErr := b.removeLockFile()
+	if err == nil {
+		err = removeLockErr
+	}
+	b.CleanupCompleted = true
+
+	if b.critical {
+		if b.retryable && b.opt.Resilient && !b.opt.Resync {
+			fs.Errorf(nil, Color(terminal.RedFg, "Bisync critical error: %v"), err)
+			fs.Error(nil, Color(terminal.YellowFg, "Bisync aborted. Error is retryable without --resync due to --resilient mode."))
+		} else {
+			if bilib.FileExists(n.listing) {
+				_ = os.Rename(n.listing, n.listing+"-err")
+			}
+			fs.Errorf(nil, Color(terminal.RedFg, "Bisync critical error: %v"), err)
+			fs.Error(nil, Color(terminal.RedFg, "Bisync aborted. Must run --resync to recover."))
+		}
+		return ErrBisyncAborted
+	}
+	if b.abort {
+		fs.Log(nil, Color(terminal.RedFg, "Bisync aborted. Please try again."))
+	}
+	if err == nil {
+		fs.Infoc(nil, Color(terminal.GreenFg, "Bisync successful"))
+	}
+	return err
+}
+
+// NwaySessionName makes a unique base name for a sync between fses
+func NwaySessionName(fses []fs.Fs) string {
+	names := make([]string, len(fses))
+	for i, f := range fses {
+		names[i] = bilib.StripHexString(bilib.CanonicalPath(bilib.FsPath(f)))
+	}
+	return strings.Join(names, "..")
+}
+
+// NwayBasePath joins the workDir with the session name for fses
+func NwayBasePath(workDir string, fses []fs.Fs) string {
+	return filepath.Join(workDir, NwaySessionName(fses))
+}
+
+// pathName returns the name used in the logs for path number i
+// counting from 0
+func pathName(i int) string {
+	return "Path" + strconv.Itoa(i+1)
+}
+
+// checkOptions checks the options make sense with more than two paths
+func (n *nwayRun) checkOptions() error {
+	opt := n.opt
+	if opt.BackupDir1 != "" || opt.BackupDir2 != "" {
+		return errors.New("--backup-dir1 and --backup-dir2 can only be used with two paths - use --backup-dir instead")
+	}
+	if opt.ConflictSuffix1 != opt.ConflictSuffix2 {
+		return errors.New("--conflict-suffix can only have one value with more than two paths")
+	}
+	if opt.Compare.DownloadHash {
+		return errors.New("--download-hash can only be used with two paths")
+	}
+
+	// All the paths must share a hash to compare checksums
+	n.hashType = hash.None
+	if opt.Compare.Checksum && !opt.IgnoreListingChecksum {
+		common := n.fses[0].Hashes()
+		for _, f := range n.fses[1:] {
+			common = common.Overlap(f.Hashes())
+		}
+		n.hashType = common.GetOne()
+		if n.hashType == hash.None {
+			return errors.New("--compare checksum needs a hash type which all the paths support")
+		}
+		opt.Compare.HashType1 = n.hashType
+		opt.Compare.HashType2 = n.hashType
+	}
+
+	// setResolveDefaults and setResyncDefaults only check the first two paths
+	noModTime := false
+	for _, f := range n.fses {
+		if f.Precision() == fs.ModTimeNotSupported {
+			noModTime = true
+		}
+	}
+	if noModTime && (opt.ConflictResolve == PreferNewer || opt.ConflictResolve == PreferOlder) {
+		fs.Logf(nil, Color(terminal.YellowFg, "WARNING: ignoring --conflict-resolve %s as at least one remote does not support modtimes."), opt.ConflictResolve.String())
+		opt.ConflictResolve = PreferNone
+	}
+	if noModTime && (opt.ResyncMode == PreferNewer || opt.ResyncMode == PreferOlder) {
+		fs.Logf(nil, Color(terminal.YellowFg, "WARNING: ignoring --resync-mode %s as at least one remote does not support modtimes."), opt.ResyncMode.String())
+		opt.ResyncMode = PreferPath1
+	}
+	return nil
+}
+
+// runLocked performs a full bisync run between all the paths
+func (n *nwayRun) runLocked(octx context.Context) (err error) {
+	b := n.bisyncRun
+	opt := b.opt
+	paths := make([]string, len(n.fses))
+	for i, f := range n.fses {
+		paths[i] = quotePath(bilib.FsPath(f))
+	}
+
+	// Create second context with filters
+	var fctx context.Context
+	if fctx, err = opt.applyFilters(octx); err != nil {
+		b.critical = true
+		b.retryable = true
+		return err
+	}
+	b.octx = octx
+	b.fctx = fctx
+
+	if opt.CheckSync == CheckSyncOnly {
+		fs.Infof(nil, "Validating listing against paths %s", strings.Join(paths, ", "))
+		if err = n.checkSyncOnly(fctx); err != nil {
+			b.critical = true
+			b.retryable = true
+		}
+		return err
+	}
+
+	fs.Infof(nil, "Synching paths %s", strings.Join(paths, ", "))
+
+	if opt.DryRun {
+		// In --dry-run mode, preserve the original listing and save updates to the .lst-dry file
+		origListing := n.listing
+		n.listing += "-dry"
+		if err := bilib.CopyFileIfExists(origListing, n.listing); err != nil {
+			return err
+		}
+	}
+
+	// overlapping paths check
+	for i := range n.fses {
+		for j := i + 1; j < len(n.fses); j++ {
+			if operations.OverlappingFilterCheck(fctx, n.fses[j], n.fses[i]) {
+				b.critical = true
+				b.retryable = true
+				return errors.New(Color(terminal.RedFg, "Overlapping paths detected. Cannot bisync between paths that overlap, unless excluded by filters."))
+			}
+		}
+		if fs.GetConfig(fctx).BackupDir != "" {
+			if _, err = operations.BackupDir(fctx, n.fses[i], n.fses[i], ""); err != nil {
+				b.critical = true
+				b.retryable = true
+				return err
+			}
+		}
+	}
+
+	if opt.Resync {
+		return n.resync(fctx)
+	}
+
+	// Check for existence of the prior listing
+	if !bilib.FileExists(n.listing) {
+		if opt.Recover && bilib.FileExists(n.listing+"-old") {
+			fs.Log(nil, Color(terminal.YellowFg, "Listing not found. Reverting to prior backup as --recover is set: ")+Color(terminal.HiBlueFg, n.listing))
+			b.handleErr(n.listing, "error reverting to old listing", bilib.CopyFileIfExists(n.listing+"-old", n.listing), true, true)
+		} else {
+			b.critical = true
+			b.retryable = true
+			errTip := Color(terminal.MagentaFg, "Tip: here is the filename we were looking for. Does it exist? \n")
+			errTip += fmt.Sprintf(Color(terminal.CyanFg, "Listing: %s\n"), Color(terminal.HiBlueFg, n.listing))
+			errTip += Color(terminal.MagentaFg, "Try running this command to inspect the work dir: \n")
+			errTip += fmt.Sprintf(Color(terminal.HiCyanFg, "rclone lsl \"%s\""), b.workDir)
+			return errors.New("cannot find prior listing, likely due to critical error on prior run \n" + errTip)
+		}
+	}
+
+	fs.Infof(nil, "Building listings")
+	n.now, err = n.listAll(fctx)
+	if err != nil || accounting.Stats(fctx).Errored() {
+		fs.Error(nil, Color(terminal.RedFg, "There were errors while building listings. Aborting as it is too dangerous to continue."))
+		b.critical = true
+		b.retryable = true
+		return err
+	}
+
+	// Check for deltas on each path relative to the prior sync
+	dss := make([]*deltaSet, len(n.fses))
+	for i, f := range n.fses {
+		fs.Infof(nil, "%s checking for diffs", pathName(i))
+		dss[i], err = b.findDeltas(fctx, f, n.listing, n.now[i], pathName(i))
+		if err != nil {
+			return err
+		}
+		dss[i].printStats()
+	}
+
+	// Check access health on all the paths
+	if opt.CheckAccess {
+		fs.Infof(nil, "Checking access health")
+		if err = n.checkAccess(dss); err != nil {
+			b.critical = true
+			b.retryable = true
+			return err
+		}
+	}
+
+	// Check for too many deleted files or all files changed
+	if !opt.Force {
+		for _, ds := range dss {
+			if ds.excessDeletes() {
+				b.abort = true
+				return errors.New("too many deletes")
+			}
+		}
+		allChanged := false
+		for i, ds := range dss {
+			if !ds.foundSame {
+				fs.Errorf(nil, "Safety abort: all files were changed on %s %s. Run with --force if desired.", ds.msg, paths[i])
+				allChanged = true
+			}
+		}
+		if allChanged {
+			b.abort = true
+			return errors.New("all files were changed")
+		}
+	}
+
+	noChanges := true
+	for _, ds := range dss {
+		if !ds.empty() {
+			noChanges = false
+		}
+	}
+	if noChanges {
+		fs.Infof(nil, "No changes found")
+	} else {
+		fs.Infof(nil, "Applying changes")
+		n.applying = true
+		err = n.applyDeltas(octx, dss)
+		if err != nil {
+			b.critical = true
+			return err
+		}
+	}
+
+	if opt.CheckSync == CheckSyncTrue && !opt.DryRun {
+		fs.Infof(nil, "Validating listings")
+		names := make([]string, len(n.fses))
+		for i := range names {
+			names[i] = pathName(i)
+		}
+		if err = n.checkSync(n.now, n.fses, names); err != nil {
+			b.critical = true
+			return err
+		}
+	}
+
+	fs.Infof(nil, "Updating listing")
+	b.handleErr(n.listing, "error saving old listing", bilib.CopyFileIfExists(n.listing, n.listing+"-old"), true, true)
+	err = n.now[0].save(n.listing)
+	if err != nil {
+		b.critical = true
+		b.retryable = true
+		return err
+	}
+
+	// Optional rmdirs for empty directories
+	if opt.RemoveEmptyDirs {
+		fs.Infof(nil, "Removing empty directories")
+		for i, f := range n.fses {
+			if err = operations.Rmdirs(fctx, f, "", true); err != nil {
+				b.critical = true
+				b.retryable = true
+				return fmt.Errorf("%s: %w", pathName(i), err)
+			}
+		}
+	}
+	return nil
+}
+
+// listAll lists all the paths concurrently
+func (n *nwayRun) listAll(ctx context.Context) ([]*fileList, error) {
+	lists := make([]*fileList, len(n.fses))
+	g, gCtx := errgroup.WithContext(ctx)
+	for i := range n.fses {
+		g.Go(func() (err error) {
+			lists[i], err = n.listPath(gCtx, n.fses[i])
+			if err != nil {
+				return fmt.Errorf("failed to list %s: %w", pathName(i), err)
+			}
+			return nil
+		})
+	}
+	return lists, g.Wait()
+}
+
+// listPath makes a listing of f
+func (n *nwayRun) listPath(ctx context.Context, f fs.Fs) (*fileList, error) {
+	var mu sync.Mutex
+	ls := newFileList()
+	ls.hash = n.hashType
+	err := walk.ListR(ctx, f, "", false, -1, walk.ListAll, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			var modtime time.Time
+			if n.opt.Compare.Modtime {
+				modtime = entry.ModTime(ctx).In(TZ)
+			}
+			switch x := entry.(type) {
+			case fs.Object:
+				hashVal := ""
+				if n.hashType != hash.None {
+					var err error
+					hashVal, err = x.Hash(ctx, n.hashType)
+					if err != nil {
+						return err
+					}
+				}
+				mu.Lock()
+				ls.put(x.Remote(), x.Size(), modtime, hashVal, "", "-")
+				mu.Unlock()
+			case fs.Directory:
+				if n.opt.CreateEmptySrcDirs {
+					mu.Lock()
+					ls.put(x.Remote(), -1, modtime, "", "", "d")
+					mu.Unlock()
+				}
+			}
+		}
+		return nil
+	})
+	return ls, err
+}
+
+// sameFile returns true if file is the same in ls1 on f1 and ls2 on f2
+func (n *nwayRun) sameFile(file string, ls1 *fileList, f1 fs.Info, ls2 *fileList, f2 fs.Info) bool {
+	if ls1.isDir(file) || ls2.isDir(file) {
+		return ls1.isDir(file) && ls2.isDir(file)
+	}
+	if n.opt.Compare.Size && sizeDiffers(ls1.getSize(file), ls2.getSize(file)) {
+		return false
+	}
+	if n.opt.Compare.Modtime && timeDiffers(n.fctx, ls1.getTime(file), ls2.getTime(file), f1, f2) {
+		return false
+	}
+	if n.opt.Compare.Checksum && n.hashDiffers(ls1.getHash(file), ls2.getHash(file), ls1.hash, ls2.hash, ls1.getSize(file), ls2.getSize(file)) {
+		return false
+	}
+	return true
+}
+
+// groupVersions groups the paths pathNums by which version of file
+// they have
+//
+// The groups are in the order of their lowest path number.
+func (n *nwayRun) groupVersions(file string, pathNums []int) (groups [][]int) {
+outer:
+	for _, i := range pathNums {
+		for g, group := range groups {
+			j := group[0]
+			if n.sameFile(file, n.now[i], n.fses[i], n.now[j], n.fses[j]) {
+				groups[g] = append(group, i)
+				continue outer
+			}
+		}
+		groups = append(groups, []int{i})
+	}
+	return groups
+}
+
+// winner returns the index of the group with the version of file
+// preferred by prefer, or -1 if there isn't one.
+func (n *nwayRun) winner(file string, groups [][]int, prefer Prefer) int {
+	var value func(i int) (int64, bool)
+	var what string
+	switch prefer {
+	case PreferPath1, PreferPath2:
+		want := 0
+		if prefer == PreferPath2 {
+			want = 1
+		}
+		for g, group := range groups {
+			if slices.Contains(group, want) {
+				return g
+			}
+		}
+		fs.Infof(file, "Winner cannot be determined as %s has no new version", pathName(want))
+		return -1
+	case PreferNewer, PreferOlder:
+		what = "modtime"
+		value = func(i int) (int64, bool) {
+			t := n.now[i].getTime(file)
+			return t.UnixNano(), !t.IsZero()
+		}
+	case PreferLarger, PreferSmaller:
+		what = "size"
+		value = func(i int) (int64, bool) {
+			size := n.now[i].getSize(file)
+			return size, size >= 0
+		}
+	default:
+		return -1
+	}
+	best, tie := -1, false
+	var bestValue int64
+	for g, group := range groups {
+		v, ok := value(group[0])
+		if !ok {
+			fs.Infof(file, "Winner cannot be determined as the %s on %s is unknown", what, pathName(group[0]))
+			return -1
+		}
+		better := v > bestValue
+		if prefer == PreferOlder || prefer == PreferSmaller {
+			better = v < bestValue
+		}
+		switch {
+		case best < 0 || better:
+			best, bestValue, tie = g, v, false
+		case v == bestValue:
+			tie = true
+		}
+	}
+	if tie {
+		fs.Infof(file, "Winner cannot be determined as more than one path is %s", prefer)
+		return -1
+	}
+	fs.Infof(file, "%s is %s", pathName(groups[best][0]), prefer)
+	return best
+}
+
+// applyDeltas propagates the changes found on each path to the others
+func (n *nwayRun) applyDeltas(ctx context.Context, dss []*deltaSet) error {
+	ctxMove := n.opt.setDryRun(ctx)
+	changed := bilib.Names{}
+	for _, ds := range dss {
+		for file := range ds.deltas {
+			changed.Add(file)
+		}
+	}
+	n.rmdirs = nil
+	n.copies = nil
+	for _, file := range changed.ToList() {
+		if err := n.applyFile(ctxMove, dss, file); err != nil {
+			return err
+		}
+	}
+	if err := n.runCopies(ctxMove); err != nil {
+		return err
+	}
+	// Remove the deepest directories first
+	for i := len(n.rmdirs) - 1; i >= 0; i-- {
+		d := n.rmdirs[i]
+		if err := operations.TryRmdir(ctxMove, n.fses[d.pathNum], d.dir); err != nil {
+			fs.Debugf(d.dir, "Not removing directory from %s: %v", pathName(d.pathNum), err)
+		}
+	}
+	return nil
+}
+
+// applyFile propagates the changes to file found on the paths
+func (n *nwayRun) applyFile(ctx context.Context, dss []*deltaSet, file string) error {
+	var deleted, modified []int
+	for i, ds := range dss {
+		d, found := ds.deltas[file]
+		switch {
+		case !found:
+		case d.is(deltaDeleted):
+			deleted = append(deleted, i)
+		default:
+			modified = append(modified, i)
+		}
+	}
+
+	// Deleted on some paths and unchanged on the others
+	if len(modified) == 0 {
+		for i := range n.fses {
+			if slices.Contains(deleted, i) {
+				continue
+			}
+			if err := n.remove(ctx, i, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Created or modified on some paths. If they all did the same
+	// thing then copy it to the others - this wins over a delete.
+	groups := n.groupVersions(file, modified)
+	if len(groups) == 1 {
+		return n.copyToAll(ctx, groups[0], file, file)
+	}
+	return n.resolve(ctx, file, groups)
+}
+
+// resolve a conflict where the paths in groups changed file in
+// different ways
+func (n *nwayRun) resolve(ctx context.Context, file string, groups [][]int) error {
+	changed := []string{}
+	for _, group := range groups {
+		changed = append(changed, pathName(group[0]))
+	}
+	n.indent("!WARNING", file, "New or changed in more than one path: "+strings.Join(changed, ", "))
+
+	winner := -1
+	if n.opt.ConflictResolve != PreferNone {
+		winner = n.winner(file, groups, n.opt.ConflictResolve)
+		if winner >= 0 {
+			fs.Infof(file, Color(terminal.GreenFg, "The winner is: %s"), pathName(groups[winner][0]))
+		} else {
+			fs.Infoc(file, Color(terminal.RedFg, "A winner could not be determined."))
+		}
+	}
+
+	num := 0
+	for g, group := range groups {
+		if g == winner {
+			continue
+		}
+		// the winner overwrites the losers
+		if winner >= 0 && n.opt.ConflictLoser == ConflictLoserDelete {
+			continue
+		}
+		var newName string
+		if n.opt.ConflictLoser == ConflictLoserPathname {
+			newName = SuffixName(ctx, file, n.opt.ConflictSuffix1+strconv.Itoa(group[0]+1))
+		} else {
+			newName, num = n.numerate(ctx, file, num+1)
+		}
+		for _, i := range group {
+			if err := n.rename(ctx, i, file, newName); err != nil {
+				return err
+			}
+		}
+		if err := n.copyToAll(ctx, group, newName, newName); err != nil {
+			return err
+		}
+	}
+
+	if winner >= 0 {
+		return n.copyToAll(ctx, groups[winner], file, file)
+	}
+	// no winner so the original name is left with the old version on
+	// the paths which didn't change it
+	for i := range n.fses {
+		if err := n.remove(ctx, i, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// numerate returns the first name for file with the conflict suffix
+// and a number from num up which isn't on any path, and the number.
+func (n *nwayRun) numerate(ctx context.Context, file string, num int) (string, int) {
+	for ; ; num++ {
+		newName := SuffixName(ctx, file, n.opt.ConflictSuffix1+strconv.Itoa(num))
+		found := false
+		for _, ls := range n.now {
+			if ls.has(newName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return newName, num
+		}
+	}
+}
+
+// copyToAll copies file from the first path in have to newName on all
+// the paths not in have
+//
+// Directories are made straight away but files are queued to be
+// copied by runCopies.
+func (n *nwayRun) copyToAll(ctx context.Context, have []int, file, newName string) error {
+	src := have[0]
+	srcLs := n.now[src]
+	isDir := srcLs.isDir(file)
+	info := srcLs.get(file)
+	for i, f := range n.fses {
+		if slices.Contains(have, i) {
+			continue
+		}
+		n.indent(pathName(src), newName, "Queue copy to "+pathName(i))
+		n.applying = true
+		if isDir {
+			if err := operations.Mkdir(ctx, f, newName); err != nil {
+				n.critical = true
+				return fmt.Errorf("%s mkdir failed for %s: %w", pathName(i), newName, err)
+			}
+		} else {
+			n.copies = append(n.copies, nwayCopy{src: src, dst: i, file: file, newName: newName, info: info})
+		}
+		// runCopies updates this with what was actually written
+		n.now[i].put(newName, info.size, info.time, info.hash, info.id, info.flags)
+	}
+	return nil
+}
+
+// runCopies runs the queued copies, --transfers at once
+func (n *nwayRun) runCopies(ctx context.Context) error {
+	copies := n.copies
+	n.copies = nil
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Transfers)
+	for _, c := range copies {
+		g.Go(func() error {
+			err := n.copyFile(gCtx, c)
+			if err != nil {
+				n.mu.Lock()
+				n.critical = true
+				n.mu.Unlock()
+			}
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// copyFile does a copy queued by copyToAll
+func (n *nwayRun) copyFile(ctx context.Context, c nwayCopy) error {
+	f := n.fses[c.dst]
+	srcObj, err := n.fses[c.src].NewObject(ctx, c.file)
+	if errors.Is(err, fs.ErrorObjectNotFound) && n.opt.DryRun {
+		// renamed in this run, so only in the listing
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s can't find %s to copy: %w", pathName(c.src), c.file, err)
+	}
+	dst, err := f.NewObject(ctx, c.newName)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		dst = nil
+	} else if err != nil {
+		return fmt.Errorf("%s copy failed for %s: %w", pathName(c.dst), c.newName, err)
+	} else if fs.GetConfig(ctx).BackupDir != "" {
+		// keep the version being replaced
+		backupDir, err := operations.BackupDir(ctx, f, f, c.newName)
+		if err == nil {
+			err = operations.MoveBackupDir(ctx, backupDir, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("%s backup failed for %s: %w", pathName(c.dst), c.newName, err)
+		}
+		dst = nil
+	}
+	newDst, err := operations.Copy(ctx, f, dst, c.newName, srcObj)
+	if err != nil {
+		return fmt.Errorf("%s copy failed for %s: %w", pathName(c.dst), c.newName, err)
+	}
+	// use what was actually written, if known
+	if newDst != nil && !n.opt.DryRun {
+		info := c.info
+		size, modtime, hashVal := newDst.Size(), info.time, info.hash
+		if n.opt.Compare.Modtime {
+			modtime = newDst.ModTime(ctx).In(TZ)
+		}
+		if n.hashType != hash.None {
+			if h, err := newDst.Hash(ctx, n.hashType); err == nil && h != "" {
+				hashVal = h
+			}
+		}
+		n.mu.Lock()
+		n.now[c.dst].put(c.newName, size, modtime, hashVal, info.id, info.flags)
+		n.mu.Unlock()
+	}
+	return nil
+}
+
+// rename file to newName on path i
+func (n *nwayRun) rename(ctx context.Context, i int, file, newName string) error {
+	n.indentf(pathName(i), file, "Renaming to %s", newName)
+	n.applying = true
+	if err := operations.MoveFile(ctx, n.fses[i], n.fses[i], newName, file); err != nil {
+		n.critical = true
+		return fmt.Errorf("%s rename failed for %s: %w", pathName(i), file, err)
+	}
+	info := n.now[i].get(file)
+	n.now[i].put(newName, info.size, info.time, info.hash, info.id, info.flags)
+	n.now[i].remove(file)
+	return nil
+}
+
+// remove file from path i if it is there
+func (n *nwayRun) remove(ctx context.Context, i int, file string) error {
+	ls := n.now[i]
+	if !ls.has(file) {
+		return nil
+	}
+	n.indent(pathName(i), file, "Queue delete")
+	n.applying = true
+	if ls.isDir(file) {
+		n.rmdirs = append(n.rmdirs, rmdir{pathNum: i, dir: file})
+		ls.remove(file)
+		return nil
+	}
+	f := n.fses[i]
+	obj, err := f.NewObject(ctx, file)
+	if err == nil {
+		var backupDir fs.Fs
+		if fs.GetConfig(ctx).BackupDir != "" {
+			backupDir, err = operations.BackupDir(ctx, f, f, file)
+		}
+		if err == nil {
+			err = operations.DeleteFileWithBackupDir(ctx, obj, backupDir)
+		}
+	}
+	if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		n.critical = true
+		return fmt.Errorf("%s delete failed for %s: %w", pathName(i), file, err)
+	}
+	ls.remove(file)
+	return nil
+}
+
+// resync implements --resync for more than two paths.
+//
+// It copies each file to the paths which don't have it. Where the
+// paths have different versions of a file the one preferred by
+// --resync-mode is copied over the others. Nothing is deleted.
+func (n *nwayRun) resync(fctx context.Context) (err error) {
+	fs.Infof(nil, "Building listings")
+	n.now, err = n.listAll(fctx)
+	if err != nil || accounting.Stats(fctx).Errored() {
+		fs.Error(nil, Color(terminal.RedFg, "There were errors while building listings. Aborting as it is too dangerous to continue."))
+		n.critical = true
+		n.retryable = true
+		return err
+	}
+
+	// Check access health on all the paths
+	// enforce even though this is --resync
+	if n.opt.CheckAccess {
+		fs.Infof(nil, "Checking access health")
+		dss := make([]*deltaSet, len(n.now))
+		for i, ls := range n.now {
+			dss[i] = &deltaSet{checkFiles: bilib.Names{}}
+			for _, file := range ls.list {
+				if filepath.Base(file) == n.opt.CheckFilename {
+					dss[i].checkFiles.Add(file)
+				}
+			}
+		}
+		if err = n.checkAccess(dss); err != nil {
+			n.critical = true
+			n.retryable = true
+			return err
+		}
+	}
+
+	all := bilib.Names{}
+	for _, ls := range n.now {
+		for _, file := range ls.list {
+			all.Add(file)
+		}
+	}
+	fs.Infof(nil, "Resync is copying files between all the paths")
+	ctxRun := n.opt.setDryRun(n.octx)
+	for _, file := range all.ToList() {
+		var have []int
+		for i, ls := range n.now {
+			if ls.has(file) {
+				have = append(have, i)
+			}
+		}
+		groups := n.groupVersions(file, have)
+		best := 0
+		if len(groups) > 1 {
+			best = max(n.winner(file, groups, n.opt.ResyncMode), 0)
+		}
+		if len(groups[best]) == len(n.fses) {
+			continue
+		}
+		if err = n.copyToAll(ctxRun, groups[best], file, file); err != nil {
+			n.critical = true
+			return err
+		}
+	}
+	if err = n.runCopies(ctxRun); err != nil {
+		n.critical = true
+		return err
+	}
+
+	fs.Infof(nil, "Resync updating listing")
+	err = n.now[0].save(n.listing)
+	if err != nil {
+		n.critical = true
+		n.retryable = true
+		return err
+	}
+	if !n.opt.DryRun {
+		fs.Infof(nil, "Resync complete")
+	}
+	return nil
+}
+
+// checkAccess checks the access check files are the same on all the paths
+func (n *nwayRun) checkAccess(dss []*deltaSet) error {
+	ok := true
+	prefix := "Access test failed:"
+	checkFiles1 := dss[0].checkFiles
+	if len(checkFiles1) == 0 {
+		fs.Logf("--check-access", Color(terminal.RedFg, "Failed to find any files named %s\n More info: %s"), Color(terminal.CyanFg, n.opt.CheckFilename), Color(terminal.BlueFg, "https://rclone.org/bisync/#check-access"))
+		ok = false
+	}
+	for i, ds := range dss[1:] {
+		name := pathName(i + 1)
+		for file := range checkFiles1 {
+			if !ds.checkFiles.Has(file) {
+				n.indentf("ERROR", file, "%s Path1 file not found in %s", prefix, name)
+				ok = false
+			}
+		}
+		for file := range ds.checkFiles {
+			if !checkFiles1.Has(file) {
+				n.indentf("ERROR", file, "%s %s file not found in Path1", prefix, name)
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		return errors.New("check file check failed")
+	}
+	fs.Infof(nil, "Found %d matching %q files on all paths", len(checkFiles1), n.opt.CheckFilename)
+	return nil
+}
+
+// checkSync checks that the lists of fses called names are all the
+// same as the first
+func (n *nwayRun) checkSync(lists []*fileList, fses []fs.Fs, names []string) error {
+	ok := true
+	for i := 1; i < len(lists); i++ {
+		for _, file := range lists[0].list {
+			if !lists[i].has(file) {
+				n.indentf("ERROR", file, "%s file not found in %s", names[0], names[i])
+				ok = false
+			} else if !n.sameFile(file, lists[0], fses[0], lists[i], fses[i]) {
+				n.indentf("ERROR", file, "%s file differs from %s", names[0], names[i])
+				ok = false
+			}
+		}
+		for _, file := range lists[i].list {
+			if !lists[0].has(file) {
+				n.indentf("ERROR", file, "%s file not found in %s", names[i], names[0])
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		return errors.New("paths are out of sync, run --resync to recover")
+	}
+	return nil
+}
+
+// checkSyncOnly checks that all the paths match the prior listing
+func (n *nwayRun) checkSyncOnly(fctx context.Context) (err error) {
+	old, err := n.loadListing(n.listing)
+	if err != nil {
+		return fmt.Errorf("cannot read prior listing: %w", err)
+	}
+	n.now, err = n.listAll(fctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, ls := range n.now {
+		fs.Infof(nil, "Validating %s", pathName(i))
+		f := n.fses[i]
+		if err := n.checkSync([]*fileList{ls, old}, []fs.Fs{f, f}, []string{pathName(i), "Listing"}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pathName(i), err))
+		}
+	}
+	return errors.Join(errs...)
+}
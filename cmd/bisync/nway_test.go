@@ -0,0 +1,194 @@
+package bisync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nwayTest is a bisync between local directories
+type nwayTest struct {
+	t    *testing.T
+	ctx  context.Context
+	dirs []string
+	fses []fs.Fs
+	opt  Options
+	t0   time.Time
+}
+
+func newNwayTest(t *testing.T, paths int) *nwayTest {
+	ctx, _ := fs.AddConfig(context.Background())
+	nt := &nwayTest{
+		t:   t,
+		ctx: ctx,
+		opt: Options{
+			Workdir:   t.TempDir(),
+			MaxDelete: 50,
+		},
+		t0: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	for range paths {
+		dir := t.TempDir()
+		f, err := fs.NewFs(ctx, dir)
+		require.NoError(t, err)
+		nt.dirs = append(nt.dirs, dir)
+		nt.fses = append(nt.fses, f)
+	}
+	return nt
+}
+
+// write file on path i with contents modified minutes after t0
+func (nt *nwayTest) write(i int, file, contents string, minutes int) {
+	p := filepath.Join(nt.dirs[i], file)
+	require.NoError(nt.t, os.WriteFile(p, []byte(contents), 0666))
+	modTime := nt.t0.Add(time.Duration(minutes) * time.Minute)
+	require.NoError(nt.t, os.Chtimes(p, modTime, modTime))
+}
+
+func (nt *nwayTest) remove(i int, file string) {
+	require.NoError(nt.t, os.Remove(filepath.Join(nt.dirs[i], file)))
+}
+
+func (nt *nwayTest) run() {
+	require.NoError(nt.t, BisyncPaths(nt.ctx, nt.fses, &nt.opt))
+}
+
+// check all the paths contain exactly want
+func (nt *nwayTest) check(want map[string]string) {
+	for i, dir := range nt.dirs {
+		got := map[string]string{}
+		entries, err := os.ReadDir(dir)
+		require.NoError(nt.t, err)
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			require.NoError(nt.t, err)
+			got[entry.Name()] = string(data)
+		}
+		assert.Equal(nt.t, want, got, pathName(i))
+	}
+}
+
+func TestBisyncPaths(t *testing.T) {
+	nt := newNwayTest(t, 3)
+	nt.write(0, "same.txt", "same", 0)
+	nt.write(0, "one.txt", "one", 0)
+	nt.write(1, "two.txt", "two", 0)
+	nt.write(2, "same.txt", "same", 0)
+	nt.write(2, "three.txt", "three", 0)
+
+	nt.opt.Resync = true
+	nt.run()
+	nt.opt.Resync = false
+	assert.FileExists(t, NwayBasePath(nt.opt.Workdir, nt.fses)+".lst")
+	nt.check(map[string]string{
+		"same.txt":  "same",
+		"one.txt":   "one",
+		"two.txt":   "two",
+		"three.txt": "three",
+	})
+
+	t.Run("NoChanges", func(t *testing.T) {
+		nt.run()
+		nt.check(map[string]string{
+			"same.txt":  "same",
+			"one.txt":   "one",
+			"two.txt":   "two",
+			"three.txt": "three",
+		})
+	})
+
+	t.Run("Propagate", func(t *testing.T) {
+		nt.write(1, "one.txt", "one modified on 2", 1)
+		nt.remove(2, "two.txt")
+		nt.write(0, "new.txt", "new on 1", 1)
+		// the same change on two paths isn't a conflict
+		nt.write(0, "three.txt", "three modified", 1)
+		nt.write(2, "three.txt", "three modified", 1)
+		nt.run()
+		nt.check(map[string]string{
+			"same.txt":  "same",
+			"one.txt":   "one modified on 2",
+			"new.txt":   "new on 1",
+			"three.txt": "three modified",
+		})
+	})
+
+	t.Run("ConflictWinner", func(t *testing.T) {
+		nt.opt.ConflictResolve = PreferNewer
+		defer func() { nt.opt.ConflictResolve = PreferNone }()
+		nt.write(0, "new.txt", "new changed on 1", 2)
+		nt.write(2, "new.txt", "new changed on 3", 3)
+		nt.run()
+		nt.check(map[string]string{
+			"same.txt":          "same",
+			"one.txt":           "one modified on 2",
+			"new.txt":           "new changed on 3",
+			"new.txt.conflict1": "new changed on 1",
+			"three.txt":         "three modified",
+		})
+	})
+
+	t.Run("ConflictNoWinner", func(t *testing.T) {
+		nt.opt.ConflictLoser = ConflictLoserPathname
+		defer func() { nt.opt.ConflictLoser = ConflictLoserSkip }()
+		nt.write(0, "one.txt", "one changed on 1", 4)
+		nt.write(1, "one.txt", "one changed on path 2", 4)
+		nt.run()
+		nt.check(map[string]string{
+			"same.txt":          "same",
+			"one.txt.conflict1": "one changed on 1",
+			"one.txt.conflict2": "one changed on path 2",
+			"new.txt":           "new changed on 3",
+			"new.txt.conflict1": "new changed on 1",
+			"three.txt":         "three modified",
+		})
+	})
+
+	t.Run("ChangeWinsOverDelete", func(t *testing.T) {
+		nt.remove(0, "three.txt")
+		nt.write(1, "three.txt", "three changed on 2", 5)
+		nt.run()
+		nt.check(map[string]string{
+			"same.txt":          "same",
+			"one.txt.conflict1": "one changed on 1",
+			"one.txt.conflict2": "one changed on path 2",
+			"new.txt":           "new changed on 3",
+			"new.txt.conflict1": "new changed on 1",
+			"three.txt":         "three changed on 2",
+		})
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		nt.opt.DryRun = true
+		nt.write(0, "dry.txt", "dry", 0)
+		nt.run()
+		nt.opt.DryRun = false
+		_, err := os.Stat(filepath.Join(nt.dirs[1], "dry.txt"))
+		assert.True(t, os.IsNotExist(err))
+		nt.remove(0, "dry.txt")
+	})
+
+	t.Run("CheckSyncOnly", func(t *testing.T) {
+		nt.opt.CheckSync = CheckSyncOnly
+		defer func() { nt.opt.CheckSync = CheckSyncTrue }()
+		nt.run()
+		nt.write(2, "extra.txt", "extra", 0)
+		assert.Error(t, BisyncPaths(nt.ctx, nt.fses, &nt.opt))
+	})
+}
+
+func TestBisyncPathsOptions(t *testing.T) {
+	nt := newNwayTest(t, 3)
+	nt.opt.BackupDir1 = filepath.Join(t.TempDir(), "backup")
+	assert.ErrorContains(t, BisyncPaths(nt.ctx, nt.fses, &nt.opt), "--backup-dir1")
+	nt.opt.BackupDir1 = ""
+	nt.opt.ConflictSuffixFlag = "a,b"
+	assert.ErrorContains(t, BisyncPaths(nt.ctx, nt.fses, &nt.opt), "--conflict-suffix")
+}
@@ -41,6 +41,9 @@ On each successive run it will:
   Changes include ||New||, ||Newer||, ||Older||, and ||Deleted|| files.
 - Propagate changes on Path1 to Path2, and vice-versa.
 
+More than two paths can be given, in which case they share a single
+listing and changes on any path are propagated to all the others.
+
 Bisync is considered an **advanced command**, so use with care.
 Make sure you have read and understood the entire [manual](https://rclone.org/bisync)
 (especially the [Limitations](https://rclone.org/bisync/#limitations) section)
@@ -173,26 +176,48 @@ func rcBisync(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 		return nil, err
 	}
 
+	// path3, path4, ... for syncing more than two paths
+	fses := []fs.Fs{fs1, fs2}
+	for i := 3; ; i++ {
+		name := "path" + strconv.Itoa(i)
+		if _, found := in[name]; !found {
+			break
+		}
+		f, err := rc.GetFsNamed(octx, in, name)
+		if err != nil {
+			return nil, err
+		}
+		fses = append(fses, f)
+	}
+
 	output := bilib.CaptureOutput(func() {
-		err = Bisync(octx, fs1, fs2, opt)
+		err = BisyncPaths(octx, fses, opt)
 	})
 
 	workDir, _ := filepath.Abs(DefaultWorkdir)
 	if opt.Workdir != "" {
 		workDir, _ = filepath.Abs(opt.Workdir)
 	}
-	basePath := bilib.BasePath(ctx, workDir, fs1, fs2)
 
 	_, _ = log.Writer().Write(output)
-	return rc.Params{
-		"output":   string(output),
-		"session":  bilib.SessionName(fs1, fs2),
-		"workDir":  workDir,
-		"basePath": basePath,
-		"listing1": basePath + ".path1.lst",
-		"listing2": basePath + ".path2.lst",
-		"logFile":  fslog.Opt.File,
-	}, err
+	out = rc.Params{
+		"output":  string(output),
+		"workDir": workDir,
+		"logFile": fslog.Opt.File,
+	}
+	if len(fses) > 2 {
+		basePath := NwayBasePath(workDir, fses)
+		out["session"] = NwaySessionName(fses)
+		out["basePath"] = basePath
+		out["listing"] = basePath + ".lst"
+	} else {
+		basePath := bilib.BasePath(ctx, workDir, fs1, fs2)
+		out["session"] = bilib.SessionName(fs1, fs2)
+		out["basePath"] = basePath
+		out["listing1"] = basePath + ".path1.lst"
+		out["listing2"] = basePath + ".path2.lst"
+	}
+	return out, err
 }
 
 func setEnum(in rc.Params, name string, defaultVal string, set func(s string) error) error {
@@ -3,6 +3,7 @@
 package cmount
 
 import (
+	"errors"
 	"io"
 	"os"
 	"path"
@@ -17,6 +18,7 @@ import (
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscache"
 	"github.com/winfsp/cgofuse/fuse"
 )
 
@@ -537,9 +539,14 @@ func (fsys *FS) Fsyncdir(path string, datasync bool, fh uint64) (errc int) {
 }
 
 // Setxattr sets extended attributes.
+//
+// Only vfs.PinXattr is supported which pins the path.
 func (fsys *FS) Setxattr(path string, name string, value []byte, flags int) (errc int) {
 	defer log.Trace(path, "name=%q, value=%q, flags=%d", name, value, flags)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	if name != vfs.PinXattr {
+		return -fuse.ENOTSUP
+	}
+	return translateError(fsys.VFS.Pin(path))
 }
 
 // Getxattr gets extended attributes.
@@ -549,9 +556,18 @@ func (fsys *FS) Getxattr(path string, name string) (errc int, value []byte) {
 }
 
 // Removexattr removes extended attributes.
+//
+// Only vfs.PinXattr is supported which unpins the path.
 func (fsys *FS) Removexattr(path string, name string) (errc int) {
 	defer log.Trace(path, "name=%q", name)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	if name != vfs.PinXattr {
+		return -fuse.ENOTSUP
+	}
+	err := fsys.VFS.Unpin(path)
+	if errors.Is(err, vfscache.ErrNotPinned) {
+		return -fuse.ENOATTR
+	}
+	return translateError(err)
 }
 
 // Listxattr lists extended attributes.
@@ -48,6 +48,12 @@ the files in ` + "`remote:path`" + `.
 rclone cryptcheck remote:path encryptedremote:path
 ` + "```" + `
 
+If the cryptedremote has the ` + "`plaintext_hashes`" + ` option set then
+the hashes of the unencrypted data stored with each file are compared
+with the hashes of the files on the remote:, so no files need to be
+read if remote: supports the same hashes. Files without stored hashes
+are checked as above.
+
 After it has run it will log the status of the ` + "`encryptedremote:`" + `.
 ` + check.FlagsHelp,
 	Annotations: map[string]string{
@@ -73,10 +79,17 @@ func cryptCheck(ctx context.Context, fdst, fsrc fs.Fs) error {
 	// Find a hash to use
 	funderlying := fcrypt.UnWrap()
 	hashType := funderlying.Hashes().GetOne()
-	if hashType == hash.None {
+	// Use the stored plaintext hashes if possible
+	plainHashType := fcrypt.Hashes().Overlap(fsrc.Hashes()).GetOne()
+	if hashType == hash.None && plainHashType == hash.None {
 		return fmt.Errorf("%s:%s does not support any hashes", funderlying.Name(), funderlying.Root())
 	}
-	fs.Infof(nil, "Using %v for hash comparisons", hashType)
+	if plainHashType != hash.None {
+		fs.Infof(nil, "Using stored plaintext %v hashes for comparisons where available", plainHashType)
+	}
+	if hashType != hash.None {
+		fs.Infof(nil, "Using %v for hash comparisons", hashType)
+	}
 
 	opt, close, err := check.GetCheckOpt(fsrc, fcrypt)
 	if err != nil {
@@ -89,6 +102,12 @@ func cryptCheck(ctx context.Context, fdst, fsrc fs.Fs) error {
 	// it returns true if differences were found
 	// it also returns whether it couldn't be hashed
 	opt.Check = func(ctx context.Context, dst, src fs.Object) (differ bool, noHash bool, err error) {
+		if plainHashType != hash.None {
+			differ, noHash, err = checkPlaintextHashes(ctx, fdst, fsrc, dst, src, plainHashType)
+			if err != nil || !noHash || hashType == hash.None {
+				return differ, noHash, err
+			}
+		}
 		cryptDst := dst.(*crypt.Object)
 		underlyingDst := cryptDst.UnWrap()
 		underlyingHash, err := underlyingDst.Hash(ctx, hashType)
@@ -115,3 +134,30 @@ func cryptCheck(ctx context.Context, fdst, fsrc fs.Fs) error {
 
 	return operations.CheckFn(ctx, opt)
 }
+
+// checkPlaintextHashes compares the plaintext hash stored with dst
+// with the hash of src
+//
+// It returns noHash if either hash is missing.
+func checkPlaintextHashes(ctx context.Context, fdst, fsrc fs.Fs, dst, src fs.Object, hashType hash.Type) (differ bool, noHash bool, err error) {
+	dstHash, err := dst.Hash(ctx, hashType)
+	if err != nil {
+		return true, false, fmt.Errorf("error reading stored hash from %v: %w", dst, err)
+	}
+	if dstHash == "" {
+		return false, true, nil
+	}
+	srcHash, err := src.Hash(ctx, hashType)
+	if err != nil {
+		return true, false, fmt.Errorf("error reading hash from %v: %w", src, err)
+	}
+	if srcHash == "" {
+		return false, true, nil
+	}
+	if srcHash != dstHash {
+		err = fmt.Errorf("hashes differ (%s:%s) %q vs (%s:%s) %q", fdst.Name(), fdst.Root(), dstHash, fsrc.Name(), fsrc.Root(), srcHash)
+		fs.Errorf(src, "%s", err.Error())
+		return true, false, nil
+	}
+	return false, false, nil
+}
@@ -294,3 +294,26 @@ func (d *Dir) Mknod(ctx context.Context, req *fuse.MknodRequest) (node fusefs.No
 	}
 	return node, nil
 }
+
+// Check interface satisfied
+var _ fusefs.NodeSetxattrer = (*Dir)(nil)
+
+// Setxattr sets an extended attribute with the given name and
+// value for the node.
+//
+// Only vfs.PinXattr is supported which pins the directory.
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) (err error) {
+	defer log.Trace(d, "name=%q", req.Name)("err=%v", &err)
+	return setPin(d.Dir, req.Name, true)
+}
+
+// Check interface satisfied
+var _ fusefs.NodeRemovexattrer = (*Dir)(nil)
+
+// Removexattr removes an extended attribute for the name.
+//
+// Only vfs.PinXattr is supported which unpins the directory.
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) (err error) {
+	defer log.Trace(d, "name=%q", req.Name)("err=%v", &err)
+	return setPin(d.Dir, req.Name, false)
+}
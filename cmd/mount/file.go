@@ -115,8 +115,11 @@ var _ fusefs.NodeListxattrer = (*File)(nil)
 
 // Setxattr sets an extended attribute with the given name and
 // value for the node.
-func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
-	return syscall.ENOSYS // we never implement this
+//
+// Only vfs.PinXattr is supported which pins the file.
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) (err error) {
+	defer log.Trace(f, "name=%q", req.Name)("err=%v", &err)
+	return setPin(f.File, req.Name, true)
 }
 
 var _ fusefs.NodeSetxattrer = (*File)(nil)
@@ -124,8 +127,11 @@ var _ fusefs.NodeSetxattrer = (*File)(nil)
 // Removexattr removes an extended attribute for the name.
 //
 // If there is no xattr by that name, returns fuse.ErrNoXattr.
-func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
-	return syscall.ENOSYS // we never implement this
+//
+// Only vfs.PinXattr is supported which unpins the file.
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) (err error) {
+	defer log.Trace(f, "name=%q", req.Name)("err=%v", &err)
+	return setPin(f.File, req.Name, false)
 }
 
 var _ fusefs.NodeRemovexattrer = (*File)(nil)
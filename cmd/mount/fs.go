@@ -6,6 +6,7 @@ package mount
 
 import (
 	"context"
+	"errors"
 	"syscall"
 
 	"bazil.org/fuse"
@@ -15,6 +16,7 @@ import (
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscache"
 )
 
 // FS represents the top level filing system
@@ -106,3 +108,23 @@ func translateError(err error) error {
 	fs.Errorf(nil, "IO error: %v", err)
 	return err
 }
+
+// setPin pins node if pin is set or unpins it otherwise when the
+// extended attribute name is set or removed.
+//
+// Only vfs.PinXattr is supported.
+func setPin(node vfs.Node, name string, pin bool) error {
+	if name != vfs.PinXattr {
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+	var err error
+	if pin {
+		err = node.VFS().Pin(node.Path())
+	} else {
+		err = node.VFS().Unpin(node.Path())
+		if errors.Is(err, vfscache.ErrNotPinned) {
+			return fuse.ErrNoXattr
+		}
+	}
+	return translateError(err)
+}
@@ -5,6 +5,7 @@
 package mount2
 
 import (
+	"errors"
 	"os"
 	"syscall"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscache"
 )
 
 // FS represents the top level filing system
@@ -139,3 +141,23 @@ func translateError(err error) syscall.Errno {
 	fs.Errorf(nil, "IO error: %v", err)
 	return syscall.EIO
 }
+
+// setPin pins node if pin is set or unpins it otherwise when the
+// extended attribute name is set or removed.
+//
+// Only vfs.PinXattr is supported.
+func setPin(node vfs.Node, name string, pin bool) syscall.Errno {
+	if name != vfs.PinXattr {
+		return syscall.ENOTSUP
+	}
+	var err error
+	if pin {
+		err = node.VFS().Pin(node.Path())
+	} else {
+		err = node.VFS().Unpin(node.Path())
+		if errors.Is(err, vfscache.ErrNotPinned) {
+			return syscall.Errno(fuse.ENOATTR)
+		}
+	}
+	return translateError(err)
+}
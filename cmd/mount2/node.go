@@ -435,16 +435,22 @@ var _ fusefs.NodeGetxattrer = (*Node)(nil)
 // Setxattr should store data for the given attribute.  See
 // setxattr(2) for information about flags.
 // If not defined, Setxattr will return ENOATTR.
-func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+//
+// Only vfs.PinXattr is supported which pins the node.
+func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("errno=%v", &errno)
+	return setPin(n.node, attr, true)
 }
 
 var _ fusefs.NodeSetxattrer = (*Node)(nil)
 
 // Removexattr should delete the given attribute.
 // If not defined, Removexattr will return ENOATTR.
-func (n *Node) Removexattr(ctx context.Context, attr string) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+//
+// Only vfs.PinXattr is supported which unpins the node.
+func (n *Node) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("errno=%v", &errno)
+	return setPin(n.node, attr, false)
 }
 
 var _ fusefs.NodeRemovexattrer = (*Node)(nil)
@@ -35,7 +35,7 @@ func TestMount(t *testing.T) {
 		}
 		sudo = true
 	}
-	for _, cacheType := range []string{"memory", "disk", "symlink"} {
+	for _, cacheType := range []string{"memory", "disk", "symlink", "kv"} {
 		t.Run(cacheType, func(t *testing.T) {
 			nfs.Opt.HandleCacheDir = t.TempDir()
 			require.NoError(t, nfs.Opt.HandleCache.Set(cacheType))
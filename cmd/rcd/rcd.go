@@ -10,6 +10,7 @@ import (
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/rc/rcflags"
 	"github.com/rclone/rclone/fs/rc/rcserver"
+	"github.com/rclone/rclone/fs/rc/schedule"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/systemd"
 	"github.com/spf13/cobra"
@@ -57,6 +58,12 @@ See the [rc documentation](/rc/) for more info on the rc flags.
 			fs.Fatal(nil, "rc server not configured")
 		}
 
+		// Run the scheduled rc commands
+		err = schedule.Start(context.Background())
+		if err != nil {
+			fs.Fatalf(nil, "Failed to start rc scheduler: %v", err)
+		}
+
 		// Notify stopping on exit
 		defer systemd.Notify()()
 
@@ -19,6 +19,7 @@ const (
 	mountPath    = "/VolumeDriver.Mount"
 	unmountPath  = "/VolumeDriver.Unmount"
 	capsPath     = "/VolumeDriver.Capabilities"
+	updatePath   = "/VolumeDriver.Update" // rclone extension
 )
 
 // CreateRequest is the structure that docker's requests are deserialized to.
@@ -27,6 +28,12 @@ type CreateRequest struct {
 	Options map[string]string `json:"Opts,omitempty"`
 }
 
+// UpdateRequest structure for a volume update request
+type UpdateRequest struct {
+	Name    string
+	Options map[string]string `json:"Opts,omitempty"`
+}
+
 // RemoveRequest structure for a volume remove request
 type RemoveRequest struct {
 	Name string
@@ -139,6 +146,13 @@ func newRouter(drv *Driver) http.Handler {
 			encodeResponse(w, nil, err, unmountPath)
 		}
 	})
+	r.Post(updatePath, func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateRequest
+		if decodeRequest(w, r, &req) {
+			err := drv.Update(&req)
+			encodeResponse(w, nil, err, updatePath)
+		}
+	})
 	r.Post(listPath, func(w http.ResponseWriter, r *http.Request) {
 		res, err := drv.List()
 		encodeResponse(w, res, err, listPath)
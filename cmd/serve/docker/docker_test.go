@@ -21,6 +21,7 @@ import (
 	"github.com/rclone/rclone/cmd/serve/docker"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/testy"
 	"github.com/rclone/rclone/lib/file"
@@ -203,6 +204,114 @@ func TestDockerPluginLogic(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDockerPluginUpdate(t *testing.T) {
+	ctx := context.Background()
+	oldCacheDir := config.GetCacheDir()
+	testDir, testFs := initialise(ctx, t)
+	err := config.SetCacheDir(testDir)
+	require.NoError(t, err)
+	defer func() {
+		_ = config.SetCacheDir(oldCacheDir)
+		if !t.Failed() {
+			fstest.Purge(testFs)
+			_ = os.RemoveAll(testDir)
+		}
+	}()
+
+	// Create dummy volume driver
+	drv, err := docker.NewDriver(ctx, testDir, nil, nil, true, true)
+	require.NoError(t, err)
+	require.NotNil(t, drv)
+	defer drv.Exit()
+
+	// Read the options saved in the driver state
+	savedOptions := func() docker.VolOpts {
+		data, err := os.ReadFile(filepath.Join(testDir, "docker-plugin.state"))
+		require.NoError(t, err)
+		var state []*docker.Volume
+		require.NoError(t, json.Unmarshal(data, &state))
+		require.Len(t, state, 1)
+		return state[0].Options
+	}
+
+	volReq := &docker.CreateRequest{
+		Name: "vol1",
+		Options: docker.VolOpts{
+			"remote":             testDir,
+			"vfs-cache-max-size": "1G",
+		},
+	}
+	require.NoError(t, drv.Create(volReq))
+
+	// Update while not mounted
+	updateReq := &docker.UpdateRequest{
+		Name: "vol1",
+		Options: docker.VolOpts{
+			"vfs_cache_max_size": "2G",
+			"vfs-cache-mode":     "full",
+			"bwlimit":            "1M",
+		},
+	}
+	require.NoError(t, drv.Update(updateReq))
+	assert.Equal(t, docker.VolOpts{
+		"vfs_cache_max_size": "2G",
+		"vfs-cache-mode":     "full",
+		"bwlimit":            "1M",
+	}, savedOptions())
+
+	// Check errors
+	err = drv.Update(&docker.UpdateRequest{Name: "vol99"})
+	assert.ErrorIs(t, err, docker.ErrVolumeNotFound)
+	err = drv.Update(&docker.UpdateRequest{Name: "vol1", Options: docker.VolOpts{"remote": "/tmp"}})
+	assertErrorContains(t, err, "can't be changed")
+	err = drv.Update(&docker.UpdateRequest{Name: "vol1", Options: docker.VolOpts{"bwlimit": "POTATO"}})
+	assertErrorContains(t, err, "cannot parse option")
+	err = drv.Update(&docker.UpdateRequest{Name: "vol1", Options: docker.VolOpts{"vfs-cache-mode": "POTATO"}})
+	assertErrorContains(t, err, "cannot parse vfs options")
+	assert.Equal(t, "1M", savedOptions()["bwlimit"])
+
+	// Update while mounted
+	mountReq := &docker.MountRequest{Name: "vol1", ID: "id1"}
+	_, err = drv.Mount(mountReq)
+	require.NoError(t, err)
+
+	updateReq.Options = docker.VolOpts{"vfs-cache-max-size": "3G", "bwlimit": "2M"}
+	require.NoError(t, drv.Update(updateReq))
+	assert.Equal(t, docker.VolOpts{
+		"vfs-cache-max-size": "3G",
+		"vfs-cache-mode":     "full",
+		"bwlimit":            "2M",
+	}, savedOptions())
+
+	updateReq.Options = docker.VolOpts{"vfs-cache-mode": "off"}
+	err = drv.Update(updateReq)
+	assertErrorContains(t, err, "can't be changed while the volume is mounted")
+
+	// Update with the rc removing an option
+	call := rc.Calls.Get("docker/update")
+	require.NotNil(t, call)
+	_, err = call.Fn(ctx, rc.Params{
+		"name": "vol1",
+		"opt":  map[string]string{"bwlimit": ""},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, docker.VolOpts{
+		"vfs-cache-max-size": "3G",
+		"vfs-cache-mode":     "full",
+	}, savedOptions())
+
+	// Simulate plugin restart to check the options are restored
+	require.NoError(t, drv.Unmount(&docker.UnmountRequest{Name: "vol1", ID: "id1"}))
+	drv2, err := docker.NewDriver(ctx, testDir, nil, nil, true, false)
+	require.NoError(t, err)
+	defer drv2.Exit()
+	require.NoError(t, drv2.Update(&docker.UpdateRequest{Name: "vol1"}))
+	assert.Equal(t, docker.VolOpts{
+		"vfs-cache-max-size": "3G",
+		"vfs-cache-mode":     "full",
+	}, savedOptions())
+}
+
 const (
 	httpTimeout = 2 * time.Second
 	tempDelay   = 10 * time.Millisecond
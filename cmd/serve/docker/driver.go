@@ -78,6 +78,9 @@ func NewDriver(ctx context.Context, root string, mntOpt *mountlib.Options, vfsOp
 		drv.exitOnce.Do(drv.Exit)
 	})
 
+	// allow volumes to be updated with the rc
+	setRcDriver(drv)
+
 	// notify systemd
 	if _, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
 		return nil, fmt.Errorf("failed to notify systemd: %w", err)
@@ -89,6 +92,7 @@ func NewDriver(ctx context.Context, root string, mntOpt *mountlib.Options, vfsOp
 // Exit will unmount all currently mounted volumes
 func (drv *Driver) Exit() {
 	fs.Debugf(nil, "Unmount all volumes")
+	clearRcDriver(drv)
 	drv.mu.Lock()
 	defer drv.mu.Unlock()
 
@@ -253,6 +257,26 @@ func (drv *Driver) Path(req *PathRequest) (*PathResponse, error) {
 	return &PathResponse{Mountpoint: vol.MountPoint}, nil
 }
 
+// Update changes the options of a volume
+//
+// This isn't part of the docker volume plugin API. Options which
+// affect the mount itself can only be changed when the volume isn't
+// mounted.
+func (drv *Driver) Update(req *UpdateRequest) error {
+	ctx := context.Background()
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	fs.Debugf(nil, "Update volume %q", req.Name)
+	vol, err := drv.getVolume(req.Name)
+	if err == nil {
+		err = vol.update(ctx, req.Options)
+	}
+	if err == nil {
+		err = drv.saveState()
+	}
+	return err
+}
+
 // Mount volume
 func (drv *Driver) Mount(req *MountRequest) (*MountResponse, error) {
 	drv.mu.Lock()
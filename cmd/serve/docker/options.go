@@ -15,7 +15,7 @@ import (
 
 // applyOptions configures volume from request options.
 //
-// There are 5 special options:
+// There are 7 special options:
 //   - "remote" aka "fs" determines existing remote from config file
 //     with a path or on-the-fly remote using the ":backend:" syntax.
 //     It is usually named "remote" in documentation but can be aliased as
@@ -26,6 +26,10 @@ import (
 //     first found (optional).
 //   - "persist" is reserved for future to create remotes persisted
 //     in rclone.conf similar to rcd (optional).
+//   - "bwlimit" limits the total bandwidth used by the volume
+//     (optional).
+//   - "bwlimit-file" limits the bandwidth of each file transferred by
+//     the volume overriding the global --bwlimit-file (optional).
 //
 // Unlike rcd we use the flat naming scheme for mount, vfs and backend
 // options without substructures. Dashes, underscores and mixed case
@@ -46,6 +50,8 @@ func (vol *Volume) applyOptions(volOpt VolOpts) error {
 	// vol.Options has all options except "remote" and "type"
 	vol.Options = VolOpts{}
 	vol.fsString = ""
+	vol.bwLimit = -1
+	vol.bwLimitFile = nil
 
 	var fsName, fsPath, fsType string
 	var explicitPath string
@@ -125,6 +131,13 @@ func (vol *Volume) applyOptions(volOpt VolOpts) error {
 		case "mount-type":
 			vol.mountType, err = opt.GetString(key)
 			ok = true
+		case "bwlimit":
+			err = vol.bwLimit.Set(vol.Options[key])
+			ok = true
+		case "bwlimit-file":
+			vol.bwLimitFile = fs.BwTimetable{}
+			err = vol.bwLimitFile.Set(vol.Options[key])
+			ok = true
 		}
 		if err != nil {
 			return fmt.Errorf("cannot parse option %q: %w", key, err)
@@ -41,6 +41,9 @@ func TestApplyOptions(t *testing.T) {
 		"no-modtime":  "1",
 		"no_checksum": "true",
 		"--no-seek":   "true",
+		// bandwidth limits
+		"bwlimit":      "1M",
+		"bwlimit_file": "512k",
 	}
 	err := vol.applyOptions(volOpt)
 	require.NoError(t, err)
@@ -56,6 +59,16 @@ func TestApplyOptions(t *testing.T) {
 	assert.Equal(t, true, vol.mnt.VFSOpt.NoModTime)
 	assert.Equal(t, true, vol.mnt.VFSOpt.NoChecksum)
 	assert.Equal(t, true, vol.mnt.VFSOpt.NoSeek)
+	// bandwidth limits
+	assert.Equal(t, fs.SizeSuffix(1024*1024), vol.bwLimit)
+	require.Len(t, vol.bwLimitFile, 1)
+	assert.Equal(t, fs.SizeSuffix(512*1024), vol.bwLimitFile[0].Bandwidth.Tx)
+
+	// bandwidth limits are reset if not supplied
+	err = vol.applyOptions(VolOpts{"remote": "/tmp/docker"})
+	require.NoError(t, err)
+	assert.Equal(t, fs.SizeSuffix(-1), vol.bwLimit)
+	assert.Nil(t, vol.bwLimitFile)
 
 	// Check errors
 	err = vol.applyOptions(VolOpts{
@@ -71,5 +84,10 @@ func TestApplyOptions(t *testing.T) {
 		"local_not_found": "POTATO",
 	})
 	require.ErrorContains(t, err, "unsupported backend option")
+	err = vol.applyOptions(VolOpts{
+		"remote":  "/tmp/docker",
+		"bwlimit": "POTATO",
+	})
+	require.ErrorContains(t, err, "cannot parse option")
 
 }
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/rclone/rclone/fs/rc"
+)
+
+var (
+	rcDriverMu sync.Mutex
+	rcDriver   *Driver // the driver controlled by the rc
+)
+
+// setRcDriver sets the driver controlled by the rc
+func setRcDriver(drv *Driver) {
+	rcDriverMu.Lock()
+	rcDriver = drv
+	rcDriverMu.Unlock()
+}
+
+// clearRcDriver stops the rc controlling drv
+func clearRcDriver(drv *Driver) {
+	rcDriverMu.Lock()
+	if rcDriver == drv {
+		rcDriver = nil
+	}
+	rcDriverMu.Unlock()
+}
+
+// getRcDriver returns the driver controlled by the rc
+func getRcDriver() (*Driver, error) {
+	rcDriverMu.Lock()
+	defer rcDriverMu.Unlock()
+	if rcDriver == nil {
+		return nil, errors.New("docker plugin is not running")
+	}
+	return rcDriver, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "docker/update",
+		Fn:    rcUpdate,
+		Title: "Update the options of a docker volume.",
+		Help: `
+This changes the options of a volume created by "rclone serve docker"
+and saves them in the plugin state file. It takes the following
+parameters:
+
+- name - name of the volume
+- opt - a map of options to set, an empty value removes the option
+
+For example
+
+    rclone rc docker/update name=vol1 -o vfs-cache-max-size=10G -o bwlimit=5M
+
+If the volume is mounted then only vfs-cache-max-size,
+vfs-cache-min-free-space, vfs-cache-max-age and bwlimit can be
+changed and these take effect without remounting. Other options can
+be changed when the volume isn't in use.
+`,
+	})
+}
+
+// Update the options of a docker volume
+func rcUpdate(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	drv, err := getRcDriver()
+	if err != nil {
+		return nil, err
+	}
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	var opt = map[string]string{}
+	err = in.GetStructMissingOK("opt", &opt)
+	if err != nil {
+		return nil, err
+	}
+	return nil, drv.Update(&UpdateRequest{Name: name, Options: opt})
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/rclone/rclone/cmd/mountlib"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/lib/file"
@@ -27,20 +28,23 @@ var (
 // Volume keeps volume runtime state
 // Public members get persisted in saved state
 type Volume struct {
-	Name       string    `json:"name"`
-	MountPoint string    `json:"mountpoint"`
-	CreatedAt  time.Time `json:"created"`
-	Fs         string    `json:"fs"`             // remote[,connectString]:path
-	Type       string    `json:"type,omitempty"` // same as ":backend:"
-	Path       string    `json:"path,omitempty"` // for "remote:path" or ":backend:path"
-	Options    VolOpts   `json:"options"`        // all options together
-	Mounts     []string  `json:"mounts"`         // mountReqs as a string list
-	mountReqs  map[string]any
-	fsString   string // result of merging Fs, Type and Options
-	persist    bool
-	mountType  string
-	drv        *Driver
-	mnt        *mountlib.MountPoint
+	Name        string    `json:"name"`
+	MountPoint  string    `json:"mountpoint"`
+	CreatedAt   time.Time `json:"created"`
+	Fs          string    `json:"fs"`             // remote[,connectString]:path
+	Type        string    `json:"type,omitempty"` // same as ":backend:"
+	Path        string    `json:"path,omitempty"` // for "remote:path" or ":backend:path"
+	Options     VolOpts   `json:"options"`        // all options together
+	Mounts      []string  `json:"mounts"`         // mountReqs as a string list
+	mountReqs   map[string]any
+	fsString    string // result of merging Fs, Type and Options
+	persist     bool
+	mountType   string
+	bwLimit     fs.SizeSuffix         // total bandwidth limit for the volume
+	bwLimitFile fs.BwTimetable        // per file bandwidth limit, nil to use the global one
+	bwLimiter   *accounting.BwLimiter // limits the bandwidth of the mounted volume
+	drv         *Driver
+	mnt         *mountlib.MountPoint
 }
 
 // VolOpts keeps volume options
@@ -104,8 +108,12 @@ func (vol *Volume) restoreState(ctx context.Context, drv *Driver) error {
 		MountPoint: vol.MountPoint,
 	}
 	volOpt := vol.Options
+	if volOpt == nil {
+		volOpt = VolOpts{}
+	}
 	volOpt["fs"] = vol.Fs
 	volOpt["type"] = vol.Type
+	volOpt["path"] = vol.Path
 	if err := vol.applyOptions(volOpt); err != nil {
 		return err
 	}
@@ -263,6 +271,7 @@ func (vol *Volume) mount(id string) error {
 		vol.mountReqs[id] = nil
 		return nil
 	}
+	vol.bwLimiter = accounting.NewBwLimiter(vol.bwLimit)
 	if drv.dummy {
 		vol.mountReqs[id] = nil
 		return nil
@@ -271,7 +280,7 @@ func (vol *Volume) mount(id string) error {
 		return errors.New("volume filesystem is not ready")
 	}
 
-	if _, err := vol.mnt.Mount(); err != nil {
+	if _, err := vol.mnt.MountContext(vol.mountContext()); err != nil {
 		return err
 	}
 	vol.mountReqs[id] = nil
@@ -279,6 +288,87 @@ func (vol *Volume) mount(id string) error {
 	return nil
 }
 
+// mountContext returns the context used for the VFS of the volume
+// which carries its bandwidth limits
+func (vol *Volume) mountContext() context.Context {
+	ctx := context.Background()
+	if vol.bwLimitFile != nil {
+		var ci *fs.ConfigInfo
+		ctx, ci = fs.AddConfig(ctx)
+		ci.BwLimitFile = vol.bwLimitFile
+	}
+	return accounting.WithBwLimiter(ctx, vol.bwLimiter)
+}
+
+// liveOptions are the options which can be changed while the volume
+// is mounted
+var liveOptions = map[string]bool{
+	"vfs-cache-max-size":       true,
+	"vfs-cache-min-free-space": true,
+	"vfs-cache-max-age":        true,
+	"bwlimit":                  true,
+}
+
+// update changes the options of the volume.
+//
+// An option with an empty value is removed. If the volume is mounted
+// only the liveOptions can be changed and they take effect without
+// remounting.
+func (vol *Volume) update(ctx context.Context, volOpt VolOpts) error {
+	mounted := len(vol.mountReqs) > 0
+	newOpt := VolOpts{}
+	for key, val := range vol.Options {
+		newOpt[key] = val
+	}
+	for key, val := range volOpt {
+		normalKey := normalOptName(key)
+		switch normalKey {
+		case "remote", "fs", "type", "path":
+			return fmt.Errorf("option %q can't be changed", key)
+		}
+		if mounted && !liveOptions[normalKey] {
+			return fmt.Errorf("option %q can't be changed while the volume is mounted", key)
+		}
+		// remove the option however it was spelt
+		for oldKey := range newOpt {
+			if normalOptName(oldKey) == normalKey {
+				delete(newOpt, oldKey)
+			}
+		}
+		if val != "" {
+			newOpt[key] = val
+		}
+	}
+	newOpt["fs"] = vol.Fs
+	newOpt["type"] = vol.Type
+	newOpt["path"] = vol.Path
+
+	// apply the options restoring the old ones on error
+	oldVol, oldMnt := *vol, *vol.mnt
+	err := vol.applyOptions(newOpt)
+	if err == nil && !mounted {
+		err = vol.setup(ctx)
+	}
+	if err != nil {
+		*vol, *vol.mnt = oldVol, oldMnt
+		return err
+	}
+	fs.Debugf(nil, "Updated volume %q options to %v", vol.Name, vol.Options)
+	if !mounted {
+		return nil
+	}
+
+	// apply the options to the running mount
+	vfsOpt := &vol.mnt.VFSOpt
+	if VFS := vol.mnt.VFS; VFS != nil {
+		VFS.SetCacheQuotas(vfsOpt.CacheMaxSize, vfsOpt.CacheMinFreeSpace, vfsOpt.CacheMaxAge)
+	}
+	if vol.bwLimiter != nil {
+		vol.bwLimiter.SetBwLimit(vol.bwLimit)
+	}
+	return nil
+}
+
 // unmount volume
 func (vol *Volume) unmount(id string) error {
 	count := len(vol.mountReqs)
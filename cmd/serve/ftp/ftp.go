@@ -148,7 +148,7 @@ You can set a single username and password with the --user and --pass flags.
 	},
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxy.Opt.AuthProxy == "" {
+		if !proxy.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -199,7 +199,7 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		ctx: ctx,
 		opt: *opt,
 	}
-	if proxy.Opt.AuthProxy != "" {
+	if proxy.Opt.Enabled() {
 		d.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		d.userPass = make(map[string]string, 16)
 	} else {
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rclone/rclone/cmd"
 	cmdserve "github.com/rclone/rclone/cmd/serve"
@@ -46,6 +47,7 @@ type Options struct {
 	HTTP       libhttp.Config
 	Template   libhttp.TemplateConfig
 	DisableZip bool
+	AllowWrite bool
 }
 
 // DefaultOpt is the default values used for Options
@@ -75,6 +77,7 @@ func init() {
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
 	flagSet.BoolVar(&Opt.DisableZip, "disable-zip", false, "Disable zip download of directories")
+	flagSet.BoolVar(&Opt.AllowWrite, "allow-write", false, "Allow uploads, directory creation and deletes (needs authentication)")
 	cmdserve.Command.AddCommand(Command)
 	cmdserve.AddRc("http", func(ctx context.Context, f fs.Fs, in rc.Params) (cmdserve.Handle, error) {
 		// Read VFS Opts
@@ -116,6 +119,40 @@ The server will log errors.  Use ` + "`-v`" + ` to see access logs.
 ` + "`--bwlimit`" + ` will be respected for file transfers.  Use ` + "`--stats`" + ` to
 control the stats printing.
 
+### Write mode
+
+By default the server is read only. Use ` + "`--allow-write`" + ` to let
+users upload files, create directories and delete files and empty
+directories. The directory listing then shows controls to do this from
+a web browser. All changes are made through the VFS so the
+` + "`--vfs-*`" + ` flags apply to them.
+
+As this lets anyone who can reach the server change the remote,
+` + "`--allow-write`" + ` can only be used with authentication: one of
+` + "`--user`" + ` and ` + "`--pass`" + `, ` + "`--htpasswd`" + `, ` + "`--client-ca`" + `,
+` + "`--user-from-header`" + ` or ` + "`--auth-proxy`" + `.
+
+The following requests are accepted in write mode:
+
+- ` + "`PUT /path/to/file`" + ` uploads the request body to the file, returning
+  201 if it was created or 204 if it was replaced.
+- ` + "`PUT /path/to/dir/`" + ` creates the directory.
+- ` + "`DELETE /path/to/file`" + ` or ` + "`DELETE /path/to/dir/`" + ` deletes the file or
+  empty directory, returning 204.
+- ` + "`POST /path/to/dir/`" + ` with a ` + "`multipart/form-data`" + ` or
+  ` + "`application/x-www-form-urlencoded`" + ` body. Each ` + "`file`" + ` part is
+  uploaded into the directory, each ` + "`mkdir`" + ` field makes a
+  subdirectory and each ` + "`delete`" + ` field deletes a file or empty
+  subdirectory of that name. The response redirects back to the
+  directory listing.
+
+For example
+
+    curl -u user:pass -T file.txt http://localhost:8080/dir/file.txt
+    curl -u user:pass -F file=@file.txt http://localhost:8080/dir/
+
+Requests from web pages on other sites are refused.
+
 ` + strings.TrimSpace(libhttp.Help(flagPrefix)+libhttp.TemplateHelp(flagPrefix)+libhttp.AuthHelp(flagPrefix)+vfs.Help()+proxy.Help),
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
@@ -123,7 +160,7 @@ control the stats printing.
 	},
 	Run: func(command *cobra.Command, args []string) {
 		var f fs.Fs
-		if proxy.Opt.AuthProxy == "" {
+		if !proxy.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -183,7 +220,7 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		opt: *opt,
 	}
 
-	if proxyOpt.AuthProxy != "" {
+	if proxyOpt.Enabled() {
 		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// override auth
 		s.opt.Auth.CustomAuthFn = s.auth
@@ -199,6 +236,9 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
+	if s.opt.AllowWrite && !s.server.UsingAuth() {
+		return nil, errors.New("--allow-write needs authentication - use --user and --pass, --htpasswd, --client-ca, --user-from-header or --auth-proxy")
+	}
 
 	router := s.server.Router()
 	router.Use(
@@ -209,6 +249,14 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	router.Get("/favicon.ico", s.serveFavicon)
 	router.Get("/*", s.handler)
 	router.Head("/*", s.handler)
+	if s.opt.AllowWrite {
+		router.Group(func(r chi.Router) {
+			r.Use(checkOrigin)
+			r.Post("/*", s.postHandler)
+			r.Put("/*", s.putHandler)
+			r.Delete("/*", s.deleteHandler)
+		})
+	}
 
 	return s, nil
 }
@@ -328,6 +376,7 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 	w.Header().Set("Last-Modified", dir.ModTime().UTC().Format(http.TimeFormat))
 
 	directory.DisableZip = s.opt.DisableZip
+	directory.AllowWrite = s.opt.AllowWrite
 
 	directory.Serve(w, r)
 }
@@ -1,12 +1,15 @@
 package http
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"flag"
 	"io"
 	stdfs "io/fs"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -36,7 +39,7 @@ const (
 	testTemplate    = "testdata/golden/testindex.html"
 )
 
-func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string) {
+func start(ctx context.Context, t *testing.T, f fs.Fs, setOpts ...func(*Options)) (s *HTTP, testURL string) {
 	opts := Options{
 		HTTP: libhttp.DefaultCfg(),
 		Template: libhttp.TemplateConfig{
@@ -48,6 +51,9 @@ func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string)
 		opts.Auth.BasicUser = testUser
 		opts.Auth.BasicPass = testPass
 	}
+	for _, setOpt := range setOpts {
+		setOpt(&opts)
+	}
 
 	s, err := newServer(ctx, f, &opts, &vfscommon.Opt, &proxy.Opt)
 	require.NoError(t, err, "failed to start server")
@@ -414,3 +420,140 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("existing"), 0666))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "full", "sub"), 0777))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	s, testURL := start(ctx, t, f, func(opts *Options) {
+		opts.AllowWrite = true
+	})
+	defer func() { assert.NoError(t, s.server.Shutdown()) }()
+
+	// Don't follow the redirects after a POST
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, path, contentType string, body io.Reader, header ...string) *http.Response {
+		req, err := http.NewRequest(method, testURL+path, body)
+		require.NoError(t, err)
+		req.SetBasicAuth(testUser, testPass)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+	checkFile := func(path, want string) {
+		got, err := os.ReadFile(filepath.Join(dir, path))
+		require.NoError(t, err, path)
+		assert.Equal(t, want, string(got), path)
+	}
+
+	t.Run("Put", func(t *testing.T) {
+		resp := do("PUT", "new.txt", "", strings.NewReader("new"))
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		checkFile("new.txt", "new")
+
+		resp = do("PUT", "existing.txt", "", strings.NewReader("replaced"))
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		checkFile("existing.txt", "replaced")
+
+		resp = do("PUT", "newdir/", "", nil)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.DirExists(t, filepath.Join(dir, "newdir"))
+
+		resp = do("PUT", "full", "", strings.NewReader("not a dir"))
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("PostMultipart", func(t *testing.T) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, name := range []string{"one.txt", "two.txt"} {
+			w, err := mw.CreateFormFile("file", name)
+			require.NoError(t, err)
+			_, err = w.Write([]byte("contents of " + name))
+			require.NoError(t, err)
+		}
+		require.NoError(t, mw.WriteField("mkdir", "made"))
+		require.NoError(t, mw.Close())
+
+		resp := do("POST", "newdir/?sort=name", mw.FormDataContentType(), &body)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "/newdir/?sort=name", resp.Header.Get("Location"))
+		checkFile("newdir/one.txt", "contents of one.txt")
+		checkFile("newdir/two.txt", "contents of two.txt")
+		assert.DirExists(t, filepath.Join(dir, "newdir", "made"))
+	})
+
+	t.Run("PostForm", func(t *testing.T) {
+		form := url.Values{"delete": {"one.txt", "made/"}}
+		resp := do("POST", "newdir/", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.NoFileExists(t, filepath.Join(dir, "newdir", "one.txt"))
+		assert.NoDirExists(t, filepath.Join(dir, "newdir", "made"))
+		checkFile("newdir/two.txt", "contents of two.txt")
+
+		form = url.Values{"mkdir": {"../escape"}}
+		resp = do("POST", "newdir/", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.NoDirExists(t, filepath.Join(dir, "escape"))
+
+		resp = do("POST", "existing.txt", "application/x-www-form-urlencoded", strings.NewReader(""))
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp := do("DELETE", "new.txt", "", nil)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.NoFileExists(t, filepath.Join(dir, "new.txt"))
+
+		resp = do("DELETE", "new.txt", "", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = do("DELETE", "full/", "", nil)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.DirExists(t, filepath.Join(dir, "full", "sub"))
+
+		resp = do("DELETE", "full/sub/", "", nil)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.NoDirExists(t, filepath.Join(dir, "full", "sub"))
+	})
+
+	t.Run("CrossOrigin", func(t *testing.T) {
+		form := url.Values{"delete": {"existing.txt"}}
+		resp := do("POST", "", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), "Origin", "https://example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		checkFile("existing.txt", "replaced")
+
+		resp = do("POST", "", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), "Origin", strings.TrimSuffix(testURL, "/"))
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.NoFileExists(t, filepath.Join(dir, "existing.txt"))
+	})
+}
+
+func TestWriteNeedsAuth(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	opts := Options{
+		HTTP:       libhttp.DefaultCfg(),
+		AllowWrite: true,
+	}
+	opts.HTTP.ListenAddr = []string{testBindAddress}
+	_, err = newServer(ctx, f, &opts, &vfscommon.Opt, &proxy.Opt)
+	assert.ErrorContains(t, err, "--allow-write needs authentication")
+}
@@ -0,0 +1,276 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+)
+
+// maxFieldSize is the largest form field other than a file we will read
+const maxFieldSize = 4096
+
+// formActions maps the form fields posted to a directory to a
+// description of what they do
+var formActions = map[string]string{
+	"file":   "upload",
+	"mkdir":  "make directory",
+	"delete": "delete",
+}
+
+// checkOrigin refuses requests made by web pages from other sites so
+// they can't use the browser's credentials to change the remote
+func checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				fs.Infof(nil, "%s: Refusing %s from origin %q", r.RemoteAddr, r.Method, origin)
+				http.Error(w, "Cross origin request refused", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeError writes an HTTP error for err from a VFS write operation
+func writeError(w http.ResponseWriter, r *http.Request, remote, what string, err error) {
+	switch {
+	case errors.Is(err, vfs.ENOENT):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, vfs.EEXIST), errors.Is(err, vfs.ENOTEMPTY):
+		http.Error(w, fmt.Sprintf("%s: %v", what, err), http.StatusConflict)
+	case errors.Is(err, vfs.EPERM), errors.Is(err, vfs.EROFS):
+		http.Error(w, fmt.Sprintf("%s: %v", what, err), http.StatusForbidden)
+	case errors.Is(err, vfs.EINVAL):
+		http.Error(w, fmt.Sprintf("%s: %v", what, err), http.StatusBadRequest)
+	default:
+		serve.Error(r.Context(), remote, w, what, err)
+	}
+}
+
+// checkLeaf checks leaf is a valid name for an entry in a directory
+func checkLeaf(leaf string) error {
+	if leaf == "" || leaf == "." || leaf == ".." || strings.ContainsAny(leaf, "/\\") {
+		return fmt.Errorf("invalid name %q: %w", leaf, vfs.EINVAL)
+	}
+	return nil
+}
+
+// upload writes in to the file at remote, returning true if the file
+// was created
+func upload(VFS *vfs.VFS, remote string, in io.Reader) (created bool, err error) {
+	node, err := VFS.Stat(remote)
+	if err == nil && node.IsDir() {
+		return false, fmt.Errorf("%q is a directory: %w", remote, vfs.EEXIST)
+	}
+	created = errors.Is(err, vfs.ENOENT)
+	fh, err := VFS.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return false, err
+	}
+	n, err := io.Copy(fh, in)
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if created {
+			_ = VFS.Remove(remote)
+		}
+		return false, err
+	}
+	fs.Infof(remote, "Uploaded %d bytes", n)
+	return created, nil
+}
+
+// mkdir makes the directory at remote
+func mkdir(VFS *vfs.VFS, remote string) error {
+	err := VFS.Mkdir(remote, 0777)
+	if err != nil {
+		return err
+	}
+	fs.Infof(remote, "Created directory")
+	return nil
+}
+
+// remove deletes the file or empty directory at remote
+func remove(VFS *vfs.VFS, remote string) error {
+	if remote == "" {
+		return fmt.Errorf("can't remove the root: %w", vfs.EPERM)
+	}
+	err := VFS.Remove(remote)
+	if err != nil {
+		return err
+	}
+	fs.Infof(remote, "Deleted")
+	return nil
+}
+
+// putHandler uploads the body to a file or makes a directory if the
+// path ends in /
+func (s *HTTP) putHandler(w http.ResponseWriter, r *http.Request) {
+	isDir := strings.HasSuffix(r.URL.Path, "/")
+	remote := strings.Trim(r.URL.Path, "/")
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to write: %v", err)
+		return
+	}
+	if isDir {
+		err = mkdir(VFS, remote)
+		if err != nil {
+			writeError(w, r, remote, "Failed to make directory", err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if remote == "" {
+		http.Error(w, "Can't upload to the root", http.StatusMethodNotAllowed)
+		return
+	}
+	created, err := upload(VFS, remote, r.Body)
+	if err != nil {
+		writeError(w, r, remote, "Failed to upload file", err)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteHandler deletes a file or an empty directory
+func (s *HTTP) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	remote := strings.Trim(r.URL.Path, "/")
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to delete: %v", err)
+		return
+	}
+	err = remove(VFS, remote)
+	if err != nil {
+		writeError(w, r, remote, "Failed to delete", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postHandler processes a form posted to a directory listing, then
+// redirects back to it
+func (s *HTTP) postHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Can only POST to a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	dirRemote := strings.Trim(r.URL.Path, "/")
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to write: %v", err)
+		return
+	}
+	node, err := VFS.Stat(dirRemote)
+	if err == nil && !node.IsDir() {
+		err = vfs.ENOENT
+	}
+	if err != nil {
+		writeError(w, r, dirRemote, "Failed to find directory", err)
+		return
+	}
+
+	// do runs action on leaf in the directory
+	do := func(action, leaf string, in io.Reader) (err error) {
+		// Directories are listed with a trailing /
+		leaf = strings.TrimSuffix(leaf, "/")
+		remote := path.Join(dirRemote, leaf)
+		defer func() {
+			if err != nil {
+				writeError(w, r, remote, "Failed to "+formActions[action], err)
+			}
+		}()
+		err = checkLeaf(leaf)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case "file":
+			_, err = upload(VFS, remote, in)
+		case "mkdir":
+			err = mkdir(VFS, remote)
+		case "delete":
+			err = remove(VFS, remote)
+		}
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		// Read the parts as they arrive so uploads aren't buffered
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad form: %v", err), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("Bad form: %v", err), http.StatusBadRequest)
+				return
+			}
+			action, leaf := part.FormName(), part.FileName()
+			if _, ok := formActions[action]; !ok {
+				continue
+			}
+			if action != "file" {
+				var value []byte
+				value, err = io.ReadAll(io.LimitReader(part, maxFieldSize))
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Bad form: %v", err), http.StatusBadRequest)
+					return
+				}
+				leaf = string(value)
+			} else if leaf == "" {
+				// An empty file input
+				continue
+			}
+			err = do(action, leaf, part)
+			if err != nil {
+				return
+			}
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFieldSize)
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad form: %v", err), http.StatusBadRequest)
+			return
+		}
+		for _, action := range []string{"mkdir", "delete"} {
+			for _, leaf := range r.PostForm[action] {
+				err = do(action, leaf, nil)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
@@ -4,6 +4,7 @@ package nfs
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
@@ -15,12 +16,14 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	billy "github.com/go-git/go-billy/v5"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/kv"
 	"github.com/willscott/go-nfs"
 	nfshelper "github.com/willscott/go-nfs/helpers"
 )
@@ -75,6 +78,8 @@ func (h *Handler) getCache() (c Cache, err error) {
 			return nil, err
 		}
 		return dh, nil
+	case cacheKV:
+		return newKVHandler(context.Background(), h)
 	}
 	return nil, errors.New("unknown handle cache type")
 }
@@ -90,6 +95,11 @@ type diskHandler struct {
 	suffix     func(fh []byte) []byte // returns nil for no suffix or the suffix
 	handleType int32                  //nolint:unused // used by the symlink cache
 	metadata   string                 // extension for metadata
+	db         *kv.DB                 // database used by the kv cache
+	known      map[string]string      // handles known to be in the database
+	pending    map[string]string      // handles waiting to be written to the database
+	flushTimer *time.Timer            // set if a write of pending is scheduled
+	limit      int                    // max size of known
 }
 
 // Create a new disk handler
@@ -160,13 +170,16 @@ func (dh *diskHandler) ToHandle(f billy.Filesystem, splitPath []string) (fh []by
 	fullPath, isMetadataFile := dh.isMetadataFile(fullPath)
 	fh = hashPath(fullPath)
 	cachePath := dh.handleToPath(fh)
-	cacheDir := filepath.Dir(cachePath)
-	err := os.MkdirAll(cacheDir, 0700)
-	if err != nil {
-		fs.Errorf("nfs", "Couldn't create cache file handle directory: %v", err)
-		return fh
+	// the kv cache doesn't store anything in cacheDir
+	if dh.cacheDir != "" {
+		cacheDir := filepath.Dir(cachePath)
+		err := os.MkdirAll(cacheDir, 0700)
+		if err != nil {
+			fs.Errorf("nfs", "Couldn't create cache file handle directory: %v", err)
+			return fh
+		}
 	}
-	fh, err = dh.write(fh, cachePath, fullPath)
+	fh, err := dh.write(fh, cachePath, fullPath)
 	if err != nil {
 		fs.Errorf("nfs", "Couldn't create cache file handle: %v", err)
 		return fh
@@ -5,6 +5,7 @@ package nfs
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 	"testing"
@@ -119,7 +120,7 @@ func TestCache(t *testing.T) {
 		ci.LogLevel = oldLogLevel
 	}()
 	billyFS := &FS{nil} // place holder billyFS
-	for _, cacheType := range []handleCache{cacheMemory, cacheDisk, cacheSymlink} {
+	for _, cacheType := range []handleCache{cacheMemory, cacheDisk, cacheSymlink, cacheKV} {
 		t.Run(cacheType.String(), func(t *testing.T) {
 			h := &Handler{
 				vfs:     vfs.New(context.Background(), object.MemoryFs, nil),
@@ -167,6 +168,28 @@ func TestCache(t *testing.T) {
 					testCacheCRUD(t, h, c, "file.metadata")
 				})
 			}
+			// Handles should be readable by a new cache as if the server restarted
+			if cacheType == cacheDisk || cacheType == cacheKV {
+				t.Run("Restart", func(t *testing.T) {
+					splitPath := []string{"dir", "restart"}
+					fh := c.ToHandle(h.billyFS, splitPath)
+					assert.Equal(t, hashPath("dir/restart"), fh)
+					if dh, ok := c.(*diskHandler); ok && dh.db != nil {
+						dh.kvFlush()
+					}
+
+					newC, err := h.getCache()
+					require.NoError(t, err)
+					_, newSplitPath, err := newC.FromHandle(fh)
+					require.NoError(t, err)
+					assert.Equal(t, splitPath, newSplitPath)
+					assert.Equal(t, fh, newC.ToHandle(h.billyFS, splitPath))
+					require.NoError(t, newC.(io.Closer).Close())
+				})
+			}
+			if c, ok := c.(io.Closer); ok {
+				require.NoError(t, c.Close())
+			}
 		})
 	}
 }
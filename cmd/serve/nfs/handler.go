@@ -5,6 +5,7 @@ package nfs
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 
@@ -101,6 +102,14 @@ func (h *Handler) InvalidateHandle(f billy.Filesystem, b []byte) (err error) {
 	return h.Cache.InvalidateHandle(f, b)
 }
 
+// Close releases any resources held by the handle cache
+func (h *Handler) Close() error {
+	if c, ok := h.Cache.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // Limit overrides the --nfs-cache-handle-limit value if out-of-range
 func (o *Options) Limit() int {
 	if o.HandleLimit < 0 {
@@ -0,0 +1,210 @@
+//go:build unix
+
+/*
+This implements a persistent NFS file handle cache using the key
+value database in the rclone cache directory.
+
+1. The file handles are the MD5 hash of the path so they are
+deterministic. The same path will always be given the same handle,
+even if the cache is lost.
+
+2. The database maps the handles back to the paths. This is
+persistent so the handles remain valid when the server is restarted.
+
+3. The database is kept per remote so it can be shared by servers
+serving different parts of the same remote.
+
+4. New handles are written to the database in batches as each write
+is a transaction which syncs the database to disk. Until then they
+are kept in memory so they can still be read back.
+*/
+
+package nfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+const (
+	kvHandleFacility = "nfs-handles" // facility name for the handle database
+	kvFlushDelay     = time.Second   // how long new handles wait before being written
+	kvFlushSize      = 1000          // write the new handles when there are this many
+)
+
+// Create a new disk handler using the key value database
+func newKVHandler(ctx context.Context, h *Handler) (dh *diskHandler, err error) {
+	if !kv.Supported() {
+		return nil, errors.New("--nfs-cache-type kv is not supported on this OS")
+	}
+	db, err := kv.Start(ctx, kvHandleFacility, h.vfs.Fs())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open handle database: %w", err)
+	}
+	dh = &diskHandler{
+		db:       db,
+		known:    make(map[string]string),
+		pending:  make(map[string]string),
+		limit:    h.opt.Limit(),
+		billyFS:  h.billyFS,
+		metadata: h.vfs.Opt.MetadataExtension,
+	}
+	dh.write = dh.kvCacheWrite
+	dh.read = dh.kvCacheRead
+	dh.remove = dh.kvCacheRemove
+	dh.suffix = dh.diskCacheSuffix
+	fs.Infof("nfs", "Storing handle cache in %q", db.Path())
+	return dh, nil
+}
+
+// kvGet: read the path for a handle
+type kvGet struct {
+	fh       []byte
+	fullPath []byte
+}
+
+func (op *kvGet) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get(op.fh)
+	if data == nil {
+		return errors.New("no record")
+	}
+	op.fullPath = append([]byte{}, data...)
+	return nil
+}
+
+// kvPut: write the path for a handle
+type kvPut struct {
+	fh       []byte
+	fullPath string
+}
+
+func (op *kvPut) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Put(op.fh, []byte(op.fullPath))
+}
+
+// kvPutBatch: write the paths for several handles
+type kvPutBatch struct {
+	paths map[string]string // handle to path
+}
+
+func (op *kvPutBatch) Do(ctx context.Context, b kv.Bucket) error {
+	for fh, fullPath := range op.paths {
+		err := b.Put([]byte(fh), []byte(fullPath))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvDelete: remove a handle
+type kvDelete struct {
+	fh []byte
+}
+
+func (op *kvDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete(op.fh)
+}
+
+// Write the fullPath into the database returning the fh unchanged
+//
+// Writes are skipped if the handle is already known to be in the
+// database as ToHandle is called for every directory entry. New
+// handles are queued and written in a batch by kvFlush.
+//
+// Call with dh.mu held
+func (dh *diskHandler) kvCacheWrite(fh []byte, cachePath string, fullPath string) ([]byte, error) {
+	key := string(fh)
+	if oldPath, found := dh.known[key]; found && oldPath == fullPath {
+		return fh, nil
+	}
+	if oldPath, found := dh.pending[key]; found && oldPath == fullPath {
+		return fh, nil
+	}
+	dh.pending[key] = fullPath
+	if len(dh.pending) >= kvFlushSize {
+		return fh, dh.kvFlushLocked()
+	}
+	if dh.flushTimer == nil {
+		dh.flushTimer = time.AfterFunc(kvFlushDelay, dh.kvFlush)
+	}
+	return fh, nil
+}
+
+// kvFlush writes any queued handles to the database
+func (dh *diskHandler) kvFlush() {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	err := dh.kvFlushLocked()
+	if err != nil {
+		fs.Errorf("nfs", "Couldn't write file handles: %v", err)
+	}
+}
+
+// kvFlushLocked writes any queued handles to the database in one transaction
+//
+// Call with dh.mu held
+func (dh *diskHandler) kvFlushLocked() error {
+	if dh.flushTimer != nil {
+		dh.flushTimer.Stop()
+		dh.flushTimer = nil
+	}
+	if len(dh.pending) == 0 {
+		return nil
+	}
+	paths := dh.pending
+	dh.pending = make(map[string]string)
+	err := dh.db.Do(true, &kvPutBatch{paths: paths})
+	if err != nil {
+		return err
+	}
+	if len(dh.known)+len(paths) > dh.limit {
+		clear(dh.known)
+	}
+	for fh, fullPath := range paths {
+		dh.known[fh] = fullPath
+	}
+	return nil
+}
+
+// Read the path for fh from the database
+func (dh *diskHandler) kvCacheRead(fh []byte, cachePath string) ([]byte, error) {
+	if fullPath, found := dh.known[string(fh)]; found {
+		return []byte(fullPath), nil
+	}
+	if fullPath, found := dh.pending[string(fh)]; found {
+		return []byte(fullPath), nil
+	}
+	op := &kvGet{fh: fh}
+	err := dh.db.Do(false, op)
+	if err != nil {
+		return nil, err
+	}
+	return op.fullPath, nil
+}
+
+// Remove fh from the database
+func (dh *diskHandler) kvCacheRemove(fh []byte, cachePath string) error {
+	delete(dh.known, string(fh))
+	delete(dh.pending, string(fh))
+	return dh.db.Do(true, &kvDelete{fh: fh})
+}
+
+// Close the database if in use
+func (dh *diskHandler) Close() error {
+	if dh.db == nil {
+		return nil
+	}
+	dh.mu.Lock()
+	err := dh.kvFlushLocked()
+	dh.mu.Unlock()
+	if err != nil {
+		fs.Errorf("nfs", "Couldn't write file handles: %v", err)
+	}
+	return dh.db.Stop(false)
+}
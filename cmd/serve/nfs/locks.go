@@ -0,0 +1,154 @@
+//go:build unix
+
+package nfs
+
+import (
+	"math"
+	"sync"
+)
+
+// nlmLock is a byte range lock held by a client process
+type nlmLock struct {
+	caller    string // name of the client machine
+	owner     string // identifies the process holding the lock
+	svid      int32  // process ID on the client
+	oh        []byte // opaque owner handle
+	exclusive bool   // set for a write lock
+	start     uint64 // first byte locked
+	end       uint64 // first byte not locked or math.MaxUint64 for to EOF
+}
+
+// nlmRange converts an NLM offset and length into start and end
+//
+// A length of 0 means lock to the end of the file.
+func nlmRange(offset, length uint64) (start, end uint64) {
+	end = offset + length
+	if length == 0 || end < offset {
+		end = math.MaxUint64
+	}
+	return offset, end
+}
+
+// length returns the NLM length for the lock
+func (l *nlmLock) length() uint64 {
+	if l.end == math.MaxUint64 {
+		return 0
+	}
+	return l.end - l.start
+}
+
+// conflicts returns true if l and other can't both be held
+func (l *nlmLock) conflicts(other *nlmLock) bool {
+	if l.owner == other.owner {
+		return false
+	}
+	if !l.exclusive && !other.exclusive {
+		return false
+	}
+	return l.start < other.end && other.start < l.end
+}
+
+// lockManager keeps the byte range locks for each file handle
+//
+// The locks are only kept in memory so are lost when the server is
+// restarted.
+type lockManager struct {
+	mu    sync.Mutex
+	files map[string][]*nlmLock // locks for each file handle
+}
+
+// newLockManager makes a new empty lockManager
+func newLockManager() *lockManager {
+	return &lockManager{
+		files: make(map[string][]*nlmLock),
+	}
+}
+
+// test returns the first lock on fh which conflicts with l or nil if
+// l could be granted
+func (m *lockManager) test(fh string, l *nlmLock) *nlmLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m._test(fh, l)
+}
+
+// _test returns a conflicting lock - call with mu held
+func (m *lockManager) _test(fh string, l *nlmLock) *nlmLock {
+	for _, other := range m.files[fh] {
+		if l.conflicts(other) {
+			return other
+		}
+	}
+	return nil
+}
+
+// lock takes the lock l on fh returning nil if granted or the lock
+// which prevented it.
+//
+// As with POSIX locks, any locks the owner already holds in the range
+// are replaced.
+func (m *lockManager) lock(fh string, l *nlmLock) *nlmLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if conflict := m._test(fh, l); conflict != nil {
+		return conflict
+	}
+	m._unlock(fh, l)
+	m.files[fh] = append(m.files[fh], l)
+	return nil
+}
+
+// unlock removes the range in l from the locks held by its owner on fh
+func (m *lockManager) unlock(fh string, l *nlmLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m._unlock(fh, l)
+}
+
+// _unlock removes a range - call with mu held
+func (m *lockManager) _unlock(fh string, l *nlmLock) {
+	var locks []*nlmLock
+	for _, old := range m.files[fh] {
+		if old.owner != l.owner || old.end <= l.start || l.end <= old.start {
+			locks = append(locks, old)
+			continue
+		}
+		// Keep the parts of old outside the range being unlocked
+		if old.start < l.start {
+			before := *old
+			before.end = l.start
+			locks = append(locks, &before)
+		}
+		if l.end < old.end {
+			after := *old
+			after.start = l.end
+			locks = append(locks, &after)
+		}
+	}
+	if len(locks) == 0 {
+		delete(m.files, fh)
+	} else {
+		m.files[fh] = locks
+	}
+}
+
+// freeAll removes all the locks held by the client machine caller
+//
+// This is used when a client reboots.
+func (m *lockManager) freeAll(caller string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for fh, oldLocks := range m.files {
+		var locks []*nlmLock
+		for _, l := range oldLocks {
+			if l.caller != caller {
+				locks = append(locks, l)
+			}
+		}
+		if len(locks) == 0 {
+			delete(m.files, fh)
+		} else {
+			m.files[fh] = locks
+		}
+	}
+}
@@ -43,6 +43,10 @@ var OptionsInfo = fs.Options{{
 	Name:    "nfs_cache_dir",
 	Default: "",
 	Help:    "The directory the NFS handle cache will use if set",
+}, {
+	Name:    "nfs_locking",
+	Default: false,
+	Help:    "Serve NFS locking (NLM) and portmapper requests",
 }}
 
 func init() {
@@ -55,6 +59,7 @@ const (
 	cacheMemory handleCache = iota
 	cacheDisk
 	cacheSymlink
+	cacheKV
 )
 
 type handleCacheChoices struct{}
@@ -64,6 +69,7 @@ func (handleCacheChoices) Choices() []string {
 		cacheMemory:  "memory",
 		cacheDisk:    "disk",
 		cacheSymlink: "symlink",
+		cacheKV:      "kv",
 	}
 }
 
@@ -73,6 +79,7 @@ type Options struct {
 	HandleLimit    int         `config:"nfs_cache_handle_limit"` // max file handles cached by go-nfs CachingHandler
 	HandleCache    handleCache `config:"nfs_cache_type"`         // what kind of handle cache to use
 	HandleCacheDir string      `config:"nfs_cache_dir"`          // where the handle cache should be stored
+	Locking        bool        `config:"nfs_locking"`            // serve NLM and portmapper requests
 }
 
 // Opt is the default set of serve nfs options
@@ -170,6 +177,18 @@ only. It requires running rclone as root or with |CAP_DAC_READ_SEARCH|.
 You can run rclone with this extra permission by doing this to the
 rclone binary |sudo setcap cap_dac_read_search+ep /path/to/rclone|.
 
+|--nfs-cache-type kv| is like |--nfs-cache-type disk| in that the
+handles are the hash of the path, but the handles are stored in a
+database in the |kv| directory under |--cache-dir|. There is one
+database per remote so it doesn't change if the flags rclone is run
+with change. As the handles are derived from the paths the same path
+always gets the same handle, so the handles stay valid when the server
+is restarted. Even if the database is lost, a handle becomes valid
+again as soon as its path is looked up by any client. New handles are
+written to the database in batches, at most a second after they are
+made. This is the recommended cache type for long running client
+mounts.
+
 |--nfs-cache-handle-limit| controls the maximum number of cached NFS
 handles stored by the caching handler. This should not be set too low
 or you may experience errors when trying to access files. The default
@@ -194,12 +213,34 @@ Where |$PORT| is the same port number used in the |serve nfs| command
 and |$HOSTNAME| is the network address of the machine that |serve nfs|
 was run on.
 
-If |--vfs-metadata-extension| is in use then for the |--nfs-cache-type disk|
-and |--nfs-cache-type cache| the metadata files will have the file
+If |--vfs-metadata-extension| is in use then for the |--nfs-cache-type disk|,
+|--nfs-cache-type kv| and |--nfs-cache-type cache| the metadata files will have the file
 handle of their parent file suffixed with |0x00, 0x00, 0x00, 0x01|.
 This means they can be looked up directly from the parent file handle
 is desired.
 
+### Locking
+
+NFS clients take file locks with the separate NLM (Network Lock
+Manager) protocol. If |--nfs-locking| is set then rclone answers NLM
+requests, so tools using |flock| or |fcntl| locks work and the locks
+are seen by all the clients.
+
+The clients find the lock manager with the portmapper, which they
+always contact on port 111. If |--nfs-locking| is set rclone answers
+portmapper requests too, so to use locking serve on port 111 with
+|--addr :111| (this usually needs root) and mount with
+|port=111,mountport=111|. The client must be running |rpc.statd|.
+
+The locks are only kept in memory so they are lost if the server is
+restarted. Blocked lock requests are not queued, the clients will poll
+for the lock instead which may be up to 30 seconds after it is
+released.
+
+If you don't need the locks to be shared between clients then mount
+with |nolock| on Linux or |locallocks| on macOS instead, which makes
+the locks local to each client.
+
 This command is only available on Unix platforms.
 
 `, "|", "`") + strings.TrimSpace(vfs.Help()),
@@ -0,0 +1,543 @@
+//go:build unix
+
+/*
+This implements the NFS lock manager (NLM) protocol version 4 and a
+minimal portmapper so clients can find it.
+
+go-nfs only serves the NFS and MOUNT programs so the connections are
+wrapped and any RPC calls for the NLM or portmapper programs are
+answered here. Everything else is passed through to go-nfs
+unchanged.
+
+The replies are written to the connection in between the replies
+written by go-nfs so the record marking isn't corrupted.
+*/
+
+package nfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// RPC program numbers and versions
+const (
+	pmapProg    = 100000
+	pmapVersion = 2
+	nfsProg     = 100003
+	nfsVersion  = 3
+	mountProg   = 100005
+	nlmProg     = 100021
+	nlmVersion  = 4
+)
+
+// Portmapper procedures
+const (
+	pmapProcNull    = 0
+	pmapProcGetPort = 3
+	pmapProcDump    = 4
+)
+
+// NLM version 4 procedures
+const (
+	nlmProcNull    = 0
+	nlmProcTest    = 1
+	nlmProcLock    = 2
+	nlmProcCancel  = 3
+	nlmProcUnlock  = 4
+	nlmProcNMLock  = 22
+	nlmProcFreeAll = 23
+)
+
+// NLM version 4 status codes
+const (
+	nlm4Granted = 0
+	nlm4Denied  = 1
+	nlm4Blocked = 3
+	nlm4StaleFH = 7
+)
+
+// RPC accept status codes
+const (
+	rpcSuccess      = 0
+	rpcProgMismatch = 2
+	rpcProcUnavail  = 3
+	rpcGarbageArgs  = 4
+)
+
+// The largest RPC call for the NLM or the portmapper we will read
+const maxRPCCall = 64 * 1024
+
+// nlm4Lock is the nlm4_lock structure
+type nlm4Lock struct {
+	CallerName string
+	FH         []byte
+	OH         []byte
+	Svid       int32
+	Offset     uint64
+	Length     uint64
+}
+
+// nlm4TestArgs is the nlm4_testargs structure
+type nlm4TestArgs struct {
+	Cookie    []byte
+	Exclusive bool
+	Lock      nlm4Lock
+}
+
+// nlm4LockArgs is the nlm4_lockargs structure
+type nlm4LockArgs struct {
+	Cookie    []byte
+	Block     bool
+	Exclusive bool
+	Lock      nlm4Lock
+	Reclaim   bool
+	State     int32
+}
+
+// nlm4CancelArgs is the nlm4_cancargs structure
+type nlm4CancelArgs struct {
+	Cookie    []byte
+	Block     bool
+	Exclusive bool
+	Lock      nlm4Lock
+}
+
+// nlm4UnlockArgs is the nlm4_unlockargs structure
+type nlm4UnlockArgs struct {
+	Cookie []byte
+	Lock   nlm4Lock
+}
+
+// nlm4Notify is the nlm4_notify structure
+type nlm4Notify struct {
+	Name  string
+	State int32
+}
+
+// nlm4Res is the nlm4_res structure
+type nlm4Res struct {
+	Cookie []byte
+	Stat   uint32
+}
+
+// nlm4Holder is the nlm4_holder structure
+type nlm4Holder struct {
+	Exclusive bool
+	Svid      int32
+	OH        []byte
+	Offset    uint64
+	Length    uint64
+}
+
+// rpcServer answers the NLM and portmapper RPC calls
+type rpcServer struct {
+	handler nfs.Handler
+	locks   *lockManager
+	port    uint32
+}
+
+// rpcListener wraps the connections accepted so the rpcServer can
+// answer the calls it handles
+type rpcListener struct {
+	net.Listener
+	srv *rpcServer
+}
+
+// newRPCListener wraps l so NLM and portmapper calls are answered
+func newRPCListener(l net.Listener, handler nfs.Handler) (*rpcListener, error) {
+	_, portString, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return nil, fmt.Errorf("cannot find port number in %s: %w", l.Addr(), err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port number in %s: %w", l.Addr(), err)
+	}
+	return &rpcListener{
+		Listener: l,
+		srv: &rpcServer{
+			handler: handler,
+			locks:   newLockManager(),
+			port:    uint32(port),
+		},
+	}, nil
+}
+
+// Accept waits for and returns the next connection to the listener
+func (l *rpcListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &rpcConn{
+		Conn: c,
+		srv:  l.srv,
+		in:   bufio.NewReader(c),
+	}, nil
+}
+
+// rpcConn wraps a connection answering the calls the rpcServer
+// handles and passing the others through
+type rpcConn struct {
+	net.Conn
+	srv *rpcServer
+
+	// read side - only used by the go-nfs reading goroutine
+	in   *bufio.Reader
+	head []byte // record marker of the record being passed through
+	left int64  // bytes of the record being passed through left to read
+
+	// write side
+	wmu     sync.Mutex
+	markerN int     // bytes of the current record marker written
+	marker  [4]byte // the current record marker
+	outLeft uint32  // bytes of the current record left to write
+	queued  []byte  // replies waiting to be written
+}
+
+// Read reads the calls which should be passed to go-nfs
+func (c *rpcConn) Read(p []byte) (n int, err error) {
+	for len(c.head) == 0 && c.left == 0 {
+		err = c.readRecord()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(c.head) > 0 {
+		n = copy(p, c.head)
+		c.head = c.head[n:]
+		return n, nil
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err = c.in.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// readRecord reads the next record marker. If the record is a call
+// for the rpcServer then it is answered, otherwise it is set up to be
+// passed through.
+func (c *rpcConn) readRecord() error {
+	var marker [4]byte
+	_, err := io.ReadFull(c.in, marker[:])
+	if err != nil {
+		return err
+	}
+	fragment := binary.BigEndian.Uint32(marker[:])
+	size := fragment &^ (1 << 31)
+	last := fragment&(1<<31) != 0
+	if last && size >= 16 {
+		// xid, message type, rpc version, program
+		header, err := c.in.Peek(16)
+		if err != nil {
+			return err
+		}
+		msgType := binary.BigEndian.Uint32(header[4:])
+		prog := binary.BigEndian.Uint32(header[12:])
+		if msgType == 0 && (prog == nlmProg || prog == pmapProg) {
+			if size > maxRPCCall {
+				return fmt.Errorf("RPC call too large: %d bytes", size)
+			}
+			call := make([]byte, size)
+			_, err = io.ReadFull(c.in, call)
+			if err != nil {
+				return err
+			}
+			reply, err := c.srv.handleCall(call)
+			if err != nil {
+				// Close the connection as the client won't get a reply
+				_ = c.Conn.Close()
+				return err
+			}
+			return c.writeReply(reply)
+		}
+	}
+	c.head = marker[:]
+	c.left = int64(size)
+	return nil
+}
+
+// Write writes the replies from go-nfs
+//
+// It keeps track of the record marking so the replies from the
+// rpcServer can be written in between them.
+func (c *rpcConn) Write(p []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	n, err = c.Conn.Write(p)
+	for p = p[:n]; len(p) > 0; {
+		if c.outLeft == 0 {
+			i := copy(c.marker[c.markerN:], p)
+			c.markerN += i
+			p = p[i:]
+			if c.markerN == len(c.marker) {
+				c.outLeft = binary.BigEndian.Uint32(c.marker[:]) &^ (1 << 31)
+				c.markerN = 0
+			}
+			continue
+		}
+		i := min(int(c.outLeft), len(p))
+		c.outLeft -= uint32(i)
+		p = p[i:]
+	}
+	if err == nil && len(c.queued) > 0 && c.markerN == 0 && c.outLeft == 0 {
+		_, err = c.Conn.Write(c.queued)
+		c.queued = nil
+	}
+	return n, err
+}
+
+// writeReply writes the reply as a record if go-nfs isn't part way
+// through writing a record or queues it otherwise
+func (c *rpcConn) writeReply(reply []byte) error {
+	record := binary.BigEndian.AppendUint32(nil, uint32(len(reply))|(1<<31))
+	record = append(record, reply...)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.markerN != 0 || c.outLeft != 0 {
+		c.queued = append(c.queued, record...)
+		return nil
+	}
+	_, err := c.Conn.Write(record)
+	return err
+}
+
+// rpcReply builds RPC replies
+type rpcReply struct {
+	bytes.Buffer
+	err error // the first error writing the reply
+}
+
+// newRPCReply starts an accepted reply to xid with the status given
+func newRPCReply(xid uint32, status uint32) *rpcReply {
+	r := &rpcReply{}
+	r.write(xid, uint32(1), uint32(0), rpc.AuthNull, status) // REPLY, MSG_ACCEPTED
+	return r
+}
+
+// write the values as XDR
+//
+// Writing to a bytes.Buffer can only fail on bad types. The first
+// error is kept and returned by bytes.
+func (r *rpcReply) write(values ...any) {
+	for _, value := range values {
+		if r.err != nil {
+			return
+		}
+		r.err = xdr.Write(r, value)
+	}
+}
+
+// bytes returns the reply or the first error writing it
+func (r *rpcReply) bytes() ([]byte, error) {
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to write RPC reply: %w", r.err)
+	}
+	return r.Bytes(), nil
+}
+
+// handleCall answers an RPC call for the NLM or portmapper returning
+// the reply
+func (s *rpcServer) handleCall(call []byte) ([]byte, error) {
+	in := bytes.NewReader(call)
+	var xid, msgType uint32
+	var header rpc.Header
+	err := xdr.Read(in, &xid)
+	if err == nil {
+		err = xdr.Read(in, &msgType)
+	}
+	if err == nil {
+		err = xdr.Read(in, &header)
+	}
+	if err != nil {
+		fs.Errorf("nfs", "Failed to read RPC call header: %v", err)
+		return newRPCReply(xid, rpcGarbageArgs).bytes()
+	}
+	var reply *rpcReply
+	switch header.Prog {
+	case pmapProg:
+		if header.Vers != pmapVersion {
+			reply = newRPCReply(xid, rpcProgMismatch)
+			reply.write(uint32(pmapVersion), uint32(pmapVersion))
+			break
+		}
+		reply, err = s.handlePortmap(xid, header.Proc, in)
+	case nlmProg:
+		if header.Vers != nlmVersion {
+			reply = newRPCReply(xid, rpcProgMismatch)
+			reply.write(uint32(nlmVersion), uint32(nlmVersion))
+			break
+		}
+		reply, err = s.handleNLM(xid, header.Proc, in)
+	}
+	if err != nil {
+		fs.Errorf("nfs", "Failed to read RPC call %d.%d arguments: %v", header.Prog, header.Proc, err)
+		return newRPCReply(xid, rpcGarbageArgs).bytes()
+	}
+	return reply.bytes()
+}
+
+// The programs the portmapper reports
+var pmapMappings = []rpc.Mapping{
+	{Prog: nfsProg, Vers: nfsVersion, Prot: rpc.IPProtoTCP},
+	{Prog: mountProg, Vers: 1, Prot: rpc.IPProtoTCP},
+	{Prog: mountProg, Vers: 3, Prot: rpc.IPProtoTCP},
+	{Prog: nlmProg, Vers: nlmVersion, Prot: rpc.IPProtoTCP},
+	{Prog: pmapProg, Vers: pmapVersion, Prot: rpc.IPProtoTCP},
+}
+
+// handlePortmap answers portmapper calls
+func (s *rpcServer) handlePortmap(xid uint32, proc uint32, in io.Reader) (*rpcReply, error) {
+	switch proc {
+	case pmapProcNull:
+		return newRPCReply(xid, rpcSuccess), nil
+	case pmapProcGetPort:
+		var mapping rpc.Mapping
+		if err := xdr.Read(in, &mapping); err != nil {
+			return nil, err
+		}
+		port := uint32(0)
+		for _, m := range pmapMappings {
+			if m.Prog == mapping.Prog && m.Vers == mapping.Vers && m.Prot == mapping.Prot {
+				port = s.port
+			}
+		}
+		fs.Debugf("nfs", "portmap: GETPORT prog=%d vers=%d prot=%d: port=%d", mapping.Prog, mapping.Vers, mapping.Prot, port)
+		reply := newRPCReply(xid, rpcSuccess)
+		reply.write(port)
+		return reply, nil
+	case pmapProcDump:
+		reply := newRPCReply(xid, rpcSuccess)
+		for _, m := range pmapMappings {
+			m.Port = s.port
+			reply.write(true, m)
+		}
+		reply.write(false)
+		return reply, nil
+	}
+	return newRPCReply(xid, rpcProcUnavail), nil
+}
+
+// Convert an nlm4Lock into an nlmLock
+func newNLMLock(l *nlm4Lock, exclusive bool) *nlmLock {
+	start, end := nlmRange(l.Offset, l.Length)
+	return &nlmLock{
+		caller:    l.CallerName,
+		owner:     fmt.Sprintf("%s/%d/%x", l.CallerName, l.Svid, l.OH),
+		svid:      l.Svid,
+		oh:        l.OH,
+		exclusive: exclusive,
+		start:     start,
+		end:       end,
+	}
+}
+
+// checkHandle returns an NLM error status if fh isn't valid
+func (s *rpcServer) checkHandle(fh []byte) uint32 {
+	if _, _, err := s.handler.FromHandle(fh); err != nil {
+		return nlm4StaleFH
+	}
+	return nlm4Granted
+}
+
+// handleNLM answers NLM calls
+func (s *rpcServer) handleNLM(xid uint32, proc uint32, in io.Reader) (*rpcReply, error) {
+	switch proc {
+	case nlmProcNull:
+		return newRPCReply(xid, rpcSuccess), nil
+	case nlmProcTest:
+		var args nlm4TestArgs
+		if err := xdr.Read(in, &args); err != nil {
+			return nil, err
+		}
+		reply := newRPCReply(xid, rpcSuccess)
+		stat := s.checkHandle(args.Lock.FH)
+		var conflict *nlmLock
+		if stat == nlm4Granted {
+			conflict = s.locks.test(string(args.Lock.FH), newNLMLock(&args.Lock, args.Exclusive))
+		}
+		if conflict != nil {
+			reply.write(args.Cookie, uint32(nlm4Denied), nlm4Holder{
+				Exclusive: conflict.exclusive,
+				Svid:      conflict.svid,
+				OH:        conflict.oh,
+				Offset:    conflict.start,
+				Length:    conflict.length(),
+			})
+		} else {
+			reply.write(nlm4Res{Cookie: args.Cookie, Stat: stat})
+		}
+		fs.Debugf("nfs", "NLM: TEST %q offset=%d length=%d exclusive=%v: conflict=%v", args.Lock.CallerName, args.Lock.Offset, args.Lock.Length, args.Exclusive, conflict != nil)
+		return reply, nil
+	case nlmProcLock, nlmProcNMLock:
+		var args nlm4LockArgs
+		if err := xdr.Read(in, &args); err != nil {
+			return nil, err
+		}
+		stat := s.checkHandle(args.Lock.FH)
+		if stat == nlm4Granted {
+			if conflict := s.locks.lock(string(args.Lock.FH), newNLMLock(&args.Lock, args.Exclusive)); conflict != nil {
+				// We don't call the client back when the lock
+				// is released, but when told it is blocked the
+				// client will retry the lock after a while.
+				if args.Block {
+					stat = nlm4Blocked
+				} else {
+					stat = nlm4Denied
+				}
+			}
+		}
+		fs.Debugf("nfs", "NLM: LOCK %q offset=%d length=%d exclusive=%v block=%v: stat=%d", args.Lock.CallerName, args.Lock.Offset, args.Lock.Length, args.Exclusive, args.Block, stat)
+		reply := newRPCReply(xid, rpcSuccess)
+		reply.write(nlm4Res{Cookie: args.Cookie, Stat: stat})
+		return reply, nil
+	case nlmProcCancel:
+		var args nlm4CancelArgs
+		if err := xdr.Read(in, &args); err != nil {
+			return nil, err
+		}
+		// Blocked locks aren't queued so there is nothing to cancel
+		reply := newRPCReply(xid, rpcSuccess)
+		reply.write(nlm4Res{Cookie: args.Cookie, Stat: nlm4Granted})
+		return reply, nil
+	case nlmProcUnlock:
+		var args nlm4UnlockArgs
+		if err := xdr.Read(in, &args); err != nil {
+			return nil, err
+		}
+		s.locks.unlock(string(args.Lock.FH), newNLMLock(&args.Lock, false))
+		fs.Debugf("nfs", "NLM: UNLOCK %q offset=%d length=%d", args.Lock.CallerName, args.Lock.Offset, args.Lock.Length)
+		reply := newRPCReply(xid, rpcSuccess)
+		reply.write(nlm4Res{Cookie: args.Cookie, Stat: nlm4Granted})
+		return reply, nil
+	case nlmProcFreeAll:
+		var args nlm4Notify
+		if err := xdr.Read(in, &args); err != nil {
+			return nil, err
+		}
+		fs.Debugf("nfs", "NLM: FREE_ALL %q", args.Name)
+		s.locks.freeAll(args.Name)
+		return newRPCReply(xid, rpcSuccess), nil
+	}
+	return newRPCReply(xid, rpcProcUnavail), nil
+}
+
+// check interfaces
+var (
+	_ net.Listener = (*rpcListener)(nil)
+	_ net.Conn     = (*rpcConn)(nil)
+)
@@ -0,0 +1,250 @@
+//go:build unix
+
+package nfs
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestLockManager(t *testing.T) {
+	m := newLockManager()
+	newLock := func(caller string, svid int32, exclusive bool, offset, length uint64) *nlmLock {
+		return newNLMLock(&nlm4Lock{CallerName: caller, Svid: svid, Offset: offset, Length: length}, exclusive)
+	}
+	const fh = "file"
+
+	// Length 0 means to EOF
+	l := newLock("a", 1, true, 10, 0)
+	assert.Equal(t, uint64(math.MaxUint64), l.end)
+	assert.Equal(t, uint64(0), l.length())
+
+	// Exclusive locks conflict with other owners only
+	assert.Nil(t, m.lock(fh, newLock("a", 1, true, 0, 10)))
+	assert.Nil(t, m.lock(fh, newLock("a", 1, true, 5, 10)))
+	conflict := m.test(fh, newLock("a", 2, false, 9, 1))
+	require.NotNil(t, conflict)
+	assert.Equal(t, int32(1), conflict.svid)
+	assert.Nil(t, m.test(fh, newLock("b", 1, false, 15, 10)))
+
+	// Shared locks don't conflict with each other
+	assert.Nil(t, m.lock(fh, newLock("b", 1, false, 20, 10)))
+	assert.Nil(t, m.lock(fh, newLock("c", 1, false, 25, 10)))
+	assert.NotNil(t, m.lock(fh, newLock("c", 1, true, 25, 1)))
+
+	// Unlocking the middle of a lock leaves the ends locked
+	m.unlock(fh, newLock("a", 1, false, 3, 4))
+	assert.NotNil(t, m.test(fh, newLock("b", 1, true, 2, 1)))
+	assert.Nil(t, m.test(fh, newLock("b", 1, true, 3, 4)))
+	assert.NotNil(t, m.test(fh, newLock("b", 1, true, 7, 1)))
+
+	// Downgrading a lock lets others share it
+	assert.Nil(t, m.lock(fh, newLock("a", 1, false, 0, 15)))
+	assert.Nil(t, m.lock(fh, newLock("b", 1, false, 0, 15)))
+
+	// Freeing all a caller's locks
+	m.freeAll("b")
+	m.freeAll("c")
+	assert.Nil(t, m.lock(fh, newLock("a", 1, true, 15, 0)))
+	m.unlock(fh, newLock("a", 1, false, 0, 0))
+	assert.Empty(t, m.files)
+}
+
+func TestRPCReply(t *testing.T) {
+	reply := newRPCReply(1, rpcSuccess)
+	reply.write(uint32(2))
+	data, err := reply.bytes()
+	require.NoError(t, err)
+	assert.Len(t, data, 6*4+4)
+
+	// A value which can't be written is an error not a panic
+	reply.write(make(chan int), uint32(3))
+	_, err = reply.bytes()
+	assert.ErrorContains(t, err, "failed to write RPC reply")
+}
+
+// nlmCall is an RPC call with arguments
+type nlmCall[T any] struct {
+	rpc.Header
+	Args T
+}
+
+// Make an RPC call header
+func rpcHeader(prog, vers, proc uint32) rpc.Header {
+	return rpc.Header{
+		Rpcvers: 2,
+		Prog:    prog,
+		Vers:    vers,
+		Proc:    proc,
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	}
+}
+
+func TestNLM(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0600))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	vfsOpt := vfscommon.Opt
+	VFS := vfs.New(context.Background(), f, &vfsOpt)
+	opt := Opt
+	opt.ListenAddr = "127.0.0.1:0"
+	opt.Locking = true
+	s, err := NewServer(context.Background(), VFS, &opt)
+	require.NoError(t, err)
+	go func() {
+		_ = s.Serve()
+	}()
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	l, ok := s.listener.(*rpcListener)
+	require.True(t, ok)
+	port := l.srv.port
+
+	client, err := rpc.DialTCP("tcp", s.Addr().String(), false)
+	require.NoError(t, err)
+	defer client.Close()
+	target, err := (&nfsc.Mount{Client: client}).Mount("/", rpc.AuthNull)
+	require.NoError(t, err)
+	_, fh, err := target.Lookup("file")
+	require.NoError(t, err)
+
+	lockArgs := func(caller string, svid int32, block, exclusive bool, offset, length uint64) nlm4LockArgs {
+		return nlm4LockArgs{
+			Cookie:    []byte{1, 2, 3},
+			Block:     block,
+			Exclusive: exclusive,
+			Lock: nlm4Lock{
+				CallerName: caller,
+				FH:         fh,
+				OH:         []byte(caller),
+				Svid:       svid,
+				Offset:     offset,
+				Length:     length,
+			},
+		}
+	}
+	lock := func(proc uint32, args nlm4LockArgs) uint32 {
+		res, err := client.Call(&nlmCall[nlm4LockArgs]{rpcHeader(nlmProg, nlmVersion, proc), args})
+		require.NoError(t, err)
+		var reply nlm4Res
+		require.NoError(t, xdr.Read(res, &reply))
+		assert.Equal(t, args.Cookie, reply.Cookie)
+		return reply.Stat
+	}
+	freeAll := func(caller string) {
+		_, err := client.Call(&nlmCall[nlm4Notify]{
+			rpcHeader(nlmProg, nlmVersion, nlmProcFreeAll),
+			nlm4Notify{Name: caller},
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("Portmap", func(t *testing.T) {
+		for _, test := range []struct {
+			prog, vers uint32
+			want       uint32
+		}{
+			{nlmProg, nlmVersion, port},
+			{nfsProg, nfsVersion, port},
+			{mountProg, 3, port},
+			{nlmProg, 1, 0},
+		} {
+			res, err := client.Call(&nlmCall[rpc.Mapping]{
+				rpcHeader(pmapProg, pmapVersion, pmapProcGetPort),
+				rpc.Mapping{Prog: test.prog, Vers: test.vers, Prot: rpc.IPProtoTCP},
+			})
+			require.NoError(t, err)
+			got, err := xdr.ReadUint32(res)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got, "prog %d vers %d", test.prog, test.vers)
+		}
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		assert.Equal(t, uint32(nlm4Granted), lock(nlmProcLock, lockArgs("a", 1, false, true, 0, 10)))
+		assert.Equal(t, uint32(nlm4Denied), lock(nlmProcLock, lockArgs("b", 2, false, true, 5, 10)))
+		assert.Equal(t, uint32(nlm4Blocked), lock(nlmProcLock, lockArgs("b", 2, true, false, 5, 10)))
+		assert.Equal(t, uint32(nlm4Granted), lock(nlmProcNMLock, lockArgs("b", 2, false, false, 10, 10)))
+
+		// Test shows the holder
+		args := lockArgs("b", 2, false, true, 0, 0)
+		res, err := client.Call(&nlmCall[nlm4TestArgs]{
+			rpcHeader(nlmProg, nlmVersion, nlmProcTest),
+			nlm4TestArgs{Cookie: args.Cookie, Exclusive: true, Lock: args.Lock},
+		})
+		require.NoError(t, err)
+		var reply struct {
+			Cookie []byte
+			Stat   uint32
+			Holder nlm4Holder
+		}
+		require.NoError(t, xdr.Read(res, &reply))
+		assert.Equal(t, uint32(nlm4Denied), reply.Stat)
+		assert.Equal(t, nlm4Holder{Exclusive: true, Svid: 1, OH: []byte("a"), Offset: 0, Length: 10}, reply.Holder)
+
+		// Unlock and the lock can be taken
+		unlock := lockArgs("a", 1, false, false, 0, 0)
+		res, err = client.Call(&nlmCall[nlm4UnlockArgs]{
+			rpcHeader(nlmProg, nlmVersion, nlmProcUnlock),
+			nlm4UnlockArgs{Cookie: unlock.Cookie, Lock: unlock.Lock},
+		})
+		require.NoError(t, err)
+		var unlockReply nlm4Res
+		require.NoError(t, xdr.Read(res, &unlockReply))
+		assert.Equal(t, uint32(nlm4Granted), unlockReply.Stat)
+		assert.Equal(t, uint32(nlm4Granted), lock(nlmProcLock, lockArgs("b", 2, false, true, 0, 10)))
+
+		// Free all the locks of a client
+		freeAll("b")
+		assert.Equal(t, uint32(nlm4Granted), lock(nlmProcLock, lockArgs("a", 1, false, true, 0, 0)))
+		freeAll("a")
+	})
+
+	t.Run("StaleHandle", func(t *testing.T) {
+		args := lockArgs("a", 1, false, true, 0, 0)
+		args.Lock.FH = []byte{1, 2, 3, 4}
+		assert.Equal(t, uint32(nlm4StaleFH), lock(nlmProcLock, args))
+	})
+
+	t.Run("BadVersion", func(t *testing.T) {
+		_, err := client.Call(&nlmCall[nlm4LockArgs]{rpcHeader(nlmProg, 1, nlmProcLock), lockArgs("a", 1, false, true, 0, 0)})
+		assert.ErrorContains(t, err, "PROG_MISMATCH")
+	})
+
+	// Check NLM and NFS calls can be mixed on the same connection
+	t.Run("Mixed", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Go(func() {
+				for range 10 {
+					if i%2 == 0 {
+						_, _, err := target.Lookup("file")
+						assert.NoError(t, err)
+						_, err = target.ReadDirPlus("/")
+						assert.NoError(t, err)
+					} else {
+						assert.Equal(t, uint32(nlm4Granted), lock(nlmProcLock, lockArgs("c", int32(i), false, false, 100, 1)))
+					}
+				}
+			})
+		}
+		wg.Wait()
+	})
+}
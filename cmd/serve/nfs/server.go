@@ -5,6 +5,7 @@ package nfs
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 
 	nfs "github.com/willscott/go-nfs"
@@ -45,6 +46,14 @@ func NewServer(ctx context.Context, vfs *vfs.VFS, opt *Options) (s *Server, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to open listening socket: %w", err)
 	}
+	if s.opt.Locking {
+		l, err := newRPCListener(s.listener, s.handler)
+		if err != nil {
+			_ = s.listener.Close()
+			return nil, err
+		}
+		s.listener = l
+	}
 	return s, nil
 }
 
@@ -55,7 +64,13 @@ func (s *Server) Addr() net.Addr {
 
 // Shutdown stops the server
 func (s *Server) Shutdown() error {
-	return s.listener.Close()
+	err := s.listener.Close()
+	if c, ok := s.handler.(io.Closer); ok {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // Serve starts the server
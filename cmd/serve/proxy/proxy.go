@@ -9,14 +9,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 
+	goauth "github.com/abbot/go-http-auth"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fspath"
 	libcache "github.com/rclone/rclone/lib/cache"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
@@ -92,6 +96,13 @@ that since |_obscure| is set to |pass|, rclone will obscure the |pass|
 parameter before creating the backend (which is required for sftp
 backends).
 
+Some servers (eg |serve smb|) authenticate with a challenge so the
+client never sends its password. For these the input to the proxy
+process only contains the |user| and the output must contain the
+user's password in the |_password| parameter so rclone can check the
+client's response. The |_password| parameter is not passed to the
+backend.
+
 The program can manipulate the supplied |user| in any way, for example
 to make proxy to many different sftp backends, you could make the
 |user| be |user@example.com| and then set the |host| to |example.com|
@@ -106,6 +117,36 @@ before it takes effect.
 This can be used to build general purpose proxies to any kind of
 backend that rclone supports.
 
+#### Home directories
+
+If you just want to give each user their own directory on a single
+remote you don't need a program. Instead supply |--auth-home| with the
+remote to use, with |{user}| where the user name should go, and
+|--auth-home-htpasswd| with an htpasswd file of the users and their
+passwords, for example
+
+|||console
+rclone serve sftp --auth-home 'remote:home/{user}' --auth-home-htpasswd /path/to/htpasswd
+|||
+
+If there is no |{user}| in |--auth-home| then the user name is added to
+the end of it as a directory, so |remote:home| is the same as the
+example above. The directory is created when the user first logs in.
+User names containing |/| or |\| or which are |.| or |..| are refused,
+as are public keys. If |--auth-home| is set then |--auth-proxy| is
+ignored.
+
+Use |--auth-home-quota| to limit the total size of the files each user
+may store, for example |--auth-home-quota 10G|. Uploads which would go
+over the quota fail, and the quota and the space used are reported to
+clients which ask for disk usage. The space used is counted when the
+user first needs it and kept up to date as files are written and
+deleted through rclone, so changes made to the remote by other means
+won't be noticed until the user's entry expires from the cache. With
+|--vfs-cache-mode writes| or |full| the files are written to the cache
+first, so a client will only see the quota error when the file is
+uploaded to the remote.
+
 `, "|", "`")
 
 // OptionsInfo descripts the Options in use
@@ -113,11 +154,32 @@ var OptionsInfo = fs.Options{{
 	Name:    "auth_proxy",
 	Default: "",
 	Help:    "A program to use to create the backend from the auth",
+}, {
+	Name:    "auth_home",
+	Default: "",
+	Help:    "Serve each user from their own directory of this remote, eg remote:home/{user}",
+}, {
+	Name:    "auth_home_htpasswd",
+	Default: "",
+	Help:    "An htpasswd file of the users for --auth-home",
+}, {
+	Name:    "auth_home_quota",
+	Default: fs.SizeSuffix(-1),
+	Help:    "Limit the size of each --auth-home directory to this",
 }}
 
 // Options is options for creating the proxy
 type Options struct {
-	AuthProxy string `config:"auth_proxy"`
+	AuthProxy        string        `config:"auth_proxy"`
+	AuthHome         string        `config:"auth_home"`
+	AuthHomeHtpasswd string        `config:"auth_home_htpasswd"`
+	AuthHomeQuota    fs.SizeSuffix `config:"auth_home_quota"`
+}
+
+// Enabled returns true if users should be served by the proxy
+// rather than from a remote given on the command line
+func (opt *Options) Enabled() bool {
+	return opt.AuthProxy != "" || opt.AuthHome != ""
 }
 
 // Opt is the default options
@@ -134,6 +196,7 @@ type Proxy struct {
 	ctx      context.Context // for global config
 	Opt      Options
 	vfsOpt   vfscommon.Options
+	htpasswd *goauth.BasicAuth // checks passwords for --auth-home
 }
 
 // cacheEntry is what is stored in the vfsCache
@@ -146,13 +209,21 @@ type cacheEntry struct {
 //
 // Any VFS are created with the vfsOpt passed in.
 func New(ctx context.Context, opt *Options, vfsOpt *vfscommon.Options) *Proxy {
-	return &Proxy{
+	p := &Proxy{
 		ctx:      ctx,
 		Opt:      *opt,
 		cmdLine:  strings.Fields(opt.AuthProxy),
 		vfsCache: libcache.New(),
 		vfsOpt:   *vfsOpt,
 	}
+	if opt.AuthHome != "" {
+		if opt.AuthProxy != "" {
+			fs.Logf(nil, "proxy: --auth-proxy is ignored when --auth-home is set")
+		}
+		// This reads the file lazily, rereading it if it changes
+		p.htpasswd = goauth.NewBasicAuthenticator("", goauth.HtpasswdFileProvider(opt.AuthHomeHtpasswd))
+	}
+	return p
 }
 
 // run the proxy command returning a config map
@@ -196,8 +267,121 @@ func (p *Proxy) run(in map[string]string) (config configmap.Simple, err error) {
 	return config, nil
 }
 
+// checkUser returns an error if user can't be used as a directory name
+func checkUser(user string) error {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, "/\\") {
+		return fmt.Errorf("proxy: invalid user name %q", user)
+	}
+	return nil
+}
+
+// checkPassword checks pass is the password for user in the htpasswd file
+func (p *Proxy) checkPassword(user, pass string) error {
+	if p.Opt.AuthHomeHtpasswd == "" {
+		return errors.New("proxy: --auth-home-htpasswd must be set to log in to --auth-home")
+	}
+	// The provider panics if the file is missing so check first
+	if _, err := os.Stat(p.Opt.AuthHomeHtpasswd); err != nil {
+		return fmt.Errorf("proxy: failed to read htpasswd file: %w", err)
+	}
+	r := &http.Request{Header: http.Header{}}
+	r.SetBasicAuth(user, pass)
+	if p.htpasswd.CheckAuth(r) != user {
+		return errors.New("proxy: incorrect password")
+	}
+	return nil
+}
+
+// homeRoot returns the remote to use for the home directory of user
+func (p *Proxy) homeRoot(user string) string {
+	if strings.Contains(p.Opt.AuthHome, "{user}") {
+		return strings.ReplaceAll(p.Opt.AuthHome, "{user}", user)
+	}
+	return fspath.JoinRootPath(p.Opt.AuthHome, user)
+}
+
+// home returns a VFS for the home directory of user, creating it if
+// necessary
+func (p *Proxy) home(user string) (*vfs.VFS, error) {
+	if err := checkUser(user); err != nil {
+		return nil, err
+	}
+	root := p.homeRoot(user)
+	f, err := cache.Get(p.ctx, root)
+	if err == fs.ErrorIsFile {
+		return nil, fmt.Errorf("proxy: home directory %q is a file", root)
+	} else if err != nil {
+		return nil, fmt.Errorf("proxy: failed to create home directory backend: %w", err)
+	}
+	err = f.Mkdir(p.ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("proxy: failed to create home directory: %w", err)
+	}
+	if p.Opt.AuthHomeQuota >= 0 {
+		f = newQuotaFs(p.ctx, f, int64(p.Opt.AuthHomeQuota))
+	}
+	return vfs.New(p.ctx, f, &p.vfsOpt), nil
+}
+
+// homeEntry returns the cacheEntry for the home of user, making a
+// new one if there isn't one in the cache
+func (p *Proxy) homeEntry(user string) (entry cacheEntry, err error) {
+	value, err := p.vfsCache.Get(user, func(key string) (value any, ok bool, err error) {
+		VFS, err := p.home(user)
+		if err != nil {
+			return nil, false, err
+		}
+		return cacheEntry{vfs: VFS}, true, nil
+	})
+	if err != nil {
+		return entry, err
+	}
+	return value.(cacheEntry), nil
+}
+
+// callHome authenticates user against the htpasswd file and returns
+// a cacheEntry for their home directory
+func (p *Proxy) callHome(user, auth string, isPublicKey bool) (value any, err error) {
+	if isPublicKey {
+		return nil, errors.New("proxy: public keys can't be used with --auth-home")
+	}
+	if err := checkUser(user); err != nil {
+		return nil, err
+	}
+	if err := p.checkPassword(user, auth); err != nil {
+		return nil, err
+	}
+	entry, err := p.homeEntry(user)
+	if err != nil {
+		return nil, err
+	}
+	// Remember the password so the file isn't checked each time
+	entry.pwHash = sha256.Sum256([]byte(auth))
+	p.vfsCache.Put(user, entry)
+	return entry, nil
+}
+
+// Home returns a VFS for the home directory of user when using
+// --auth-home, creating it if necessary.
+//
+// This doesn't check any auth so should only be used by servers which
+// have authenticated the user already.
+func (p *Proxy) Home(user string) (*vfs.VFS, error) {
+	if p.Opt.AuthHome == "" {
+		return nil, errors.New("proxy: --auth-home not set")
+	}
+	entry, err := p.homeEntry(user)
+	if err != nil {
+		return nil, err
+	}
+	return entry.vfs, nil
+}
+
 // call runs the auth proxy and returns a cacheEntry and an error
 func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error) {
+	if p.Opt.AuthHome != "" {
+		return p.callHome(user, auth, isPublicKey)
+	}
 	var config configmap.Simple
 	// Contact the proxy
 	if isPublicKey {
@@ -215,7 +399,12 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error)
 	if err != nil {
 		return nil, err
 	}
+	return p.newEntry(user, auth, config)
+}
 
+// newEntry makes a cacheEntry for user from the config returned by
+// the proxy, reusing the one in the cache if possible
+func (p *Proxy) newEntry(user, auth string, config configmap.Simple) (value any, err error) {
 	// Look for required fields in the answer
 	fsName, ok := config.Get("type")
 	if !ok {
@@ -274,6 +463,14 @@ func (p *Proxy) Call(user, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey
 	// Look in the cache first
 	value, ok := p.vfsCache.GetMaybe(user)
 
+	// With --auth-home check the htpasswd file if the cached
+	// password doesn't match as it may have been changed
+	if ok && p.Opt.AuthHome != "" {
+		entry, isEntry := value.(cacheEntry)
+		authHash := sha256.Sum256([]byte(auth))
+		ok = isEntry && subtle.ConstantTimeCompare(authHash[:], entry.pwHash[:]) == 1
+	}
+
 	// If not found then call the proxy for a fresh answer
 	if !ok {
 		value, err = p.call(user, auth, isPublicKey)
@@ -304,6 +501,47 @@ func (p *Proxy) Call(user, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey
 	return entry.vfs, user, nil
 }
 
+// CallChallenge runs the auth proxy for servers whose clients prove
+// they know the password without sending it, returning a *vfs.VFS and
+// the key used in the VFS cache.
+//
+// The proxy is only passed the user and must return the password in
+// the _password field of its output. This is passed to check which
+// should return an error if the client didn't use it.
+func (p *Proxy) CallChallenge(user string, check func(pass string) error) (VFS *vfs.VFS, vfsKey string, err error) {
+	if p.Opt.AuthHome != "" {
+		return nil, "", errors.New("proxy: --auth-home can't be used with clients which don't send their password")
+	}
+	config, err := p.run(map[string]string{
+		"user": user,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	pass, ok := config.Get("_password")
+	if !ok {
+		return nil, "", errors.New("proxy: _password not set in result")
+	}
+	delete(config, "_password")
+	if err := check(pass); err != nil {
+		return nil, "", err
+	}
+	value, err := p.newEntry(user, pass, config)
+	if err != nil {
+		return nil, "", err
+	}
+	entry, ok := value.(cacheEntry)
+	if !ok {
+		return nil, "", fmt.Errorf("proxy: value is not cache entry: %#v", value)
+	}
+	// The cached entry may have been made with an old password
+	authHash := sha256.Sum256([]byte(pass))
+	if subtle.ConstantTimeCompare(authHash[:], entry.pwHash[:]) != 1 {
+		return nil, "", errors.New("proxy: incorrect password")
+	}
+	return entry.vfs, user, nil
+}
+
 // Get VFS from the cache using key - returns nil if not found
 func (p *Proxy) Get(key string) *vfs.VFS {
 	value, ok := p.vfsCache.GetMaybe(key)
@@ -28,6 +28,9 @@ func main() {
 		}
 		out[k] = v
 	}
+	if in["pass"] == "" && in["public_key"] == "" {
+		out["_password"] = "pass-" + in["user"]
+	}
 	if out["type"] == "" {
 		out["type"] = "local"
 	}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -233,4 +237,136 @@ func TestRun(t *testing.T) {
 		// check cache is at the same level
 		assert.Equal(t, 1, p.vfsCache.Entries())
 	})
+
+	t.Run("CallChallenge", func(t *testing.T) {
+		// check cache empty
+		assert.Equal(t, 0, p.vfsCache.Entries())
+		defer p.vfsCache.Clear()
+
+		var gotPass string
+		check := func(pass string) error {
+			gotPass = pass
+			return nil
+		}
+		vfs, vfsKey, err := p.CallChallenge(testUser, check)
+		require.NoError(t, err)
+		require.NotNil(t, vfs)
+		assert.Equal(t, "pass-"+testUser, gotPass)
+		assert.Equal(t, "proxy-"+testUser, vfs.Fs().Name())
+		assert.Equal(t, testUser, vfsKey)
+		assert.Equal(t, 1, p.vfsCache.Entries())
+
+		// now try again from the cache
+		vfs2, _, err := p.CallChallenge(testUser, check)
+		require.NoError(t, err)
+		assert.Equal(t, vfs, vfs2)
+
+		// a failed check doesn't return the VFS
+		vfs, vfsKey, err = p.CallChallenge(testUser, func(pass string) error {
+			return errors.New("bad response")
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "bad response")
+		require.Nil(t, vfs)
+		require.Equal(t, "", vfsKey)
+	})
+}
+
+// writeHtpasswd writes an htpasswd file with SHA passwords for users
+func writeHtpasswd(t *testing.T, users map[string]string) string {
+	var out strings.Builder
+	for user, pass := range users {
+		sum := sha1.Sum([]byte(pass))
+		out.WriteString(user + ":{SHA}" + base64.StdEncoding.EncodeToString(sum[:]) + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte(out.String()), 0600))
+	return path
+}
+
+func TestHome(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	opt := Opt
+	opt.AuthHome = base + "/home/{user}"
+	opt.AuthHomeHtpasswd = writeHtpasswd(t, map[string]string{
+		"alice": "alicePass",
+		"../x":  "dotsPass",
+	})
+	opt.AuthHomeQuota = 100
+	p := New(ctx, &opt, &vfscommon.Opt)
+
+	t.Run("Login", func(t *testing.T) {
+		defer p.vfsCache.Clear()
+		VFS, vfsKey, err := p.Call("alice", "alicePass", false)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", vfsKey)
+		_, err = os.Stat(filepath.Join(base, "home", "alice"))
+		require.NoError(t, err, "home directory not created")
+
+		// The quota is reported by About
+		usage, err := VFS.Fs().Features().About(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), *usage.Total)
+		assert.Equal(t, int64(0), *usage.Used)
+
+		// Again from the cache
+		VFS2, _, err := p.Call("alice", "alicePass", false)
+		require.NoError(t, err)
+		assert.Equal(t, VFS, VFS2)
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		defer p.vfsCache.Clear()
+		_, _, err := p.Call("alice", "potato", false)
+		assert.ErrorContains(t, err, "incorrect password")
+		_, _, err = p.Call("bob", "alicePass", false)
+		assert.ErrorContains(t, err, "incorrect password")
+
+		// A cached entry isn't used with the wrong password
+		_, _, err = p.Call("alice", "alicePass", false)
+		require.NoError(t, err)
+		_, _, err = p.Call("alice", "potato", false)
+		assert.ErrorContains(t, err, "incorrect password")
+	})
+
+	t.Run("BadUser", func(t *testing.T) {
+		defer p.vfsCache.Clear()
+		_, _, err := p.Call("../x", "dotsPass", false)
+		assert.ErrorContains(t, err, "invalid user name")
+		_, err = p.Home("..")
+		assert.ErrorContains(t, err, "invalid user name")
+	})
+
+	t.Run("PublicKey", func(t *testing.T) {
+		defer p.vfsCache.Clear()
+		_, _, err := p.Call("alice", "AAAAB3NzaC1yc2E", true)
+		assert.ErrorContains(t, err, "public keys")
+	})
+
+	t.Run("Home", func(t *testing.T) {
+		defer p.vfsCache.Clear()
+		VFS, err := p.Home("carol")
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(base, "home", "carol"))
+		require.NoError(t, err)
+
+		// An entry made by Home can't be logged in to
+		// without the password being checked
+		_, _, err = p.Call("carol", "", false)
+		assert.ErrorContains(t, err, "incorrect password")
+		VFS2, err := p.Home("carol")
+		require.NoError(t, err)
+		assert.Equal(t, VFS, VFS2)
+	})
+
+	t.Run("NoUserInRoot", func(t *testing.T) {
+		opt := opt
+		opt.AuthHome = base + "/users"
+		p := New(ctx, &opt, &vfscommon.Opt)
+		_, err := p.Home("dave")
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(base, "users", "dave"))
+		require.NoError(t, err)
+	})
 }
@@ -0,0 +1,367 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// ErrorQuotaExceeded is returned when a write would take a user over
+// their quota
+var ErrorQuotaExceeded = errors.New("quota exceeded")
+
+// quotaError returns ErrorQuotaExceeded marked so it isn't retried
+func quotaError() error {
+	return fserrors.NoRetryError(ErrorQuotaExceeded)
+}
+
+// quotaFs wraps an Fs limiting the total size of the objects in it
+// and reporting the limit with About
+type quotaFs struct {
+	fs.Fs
+	quota    int64
+	features *fs.Features
+
+	mu      sync.Mutex
+	used    int64 // size of the objects, including uploads in progress
+	counted bool  // set if used has been counted
+}
+
+// quotaObject is an object in a quotaFs
+type quotaObject struct {
+	fs.Object
+	f *quotaFs
+}
+
+// newQuotaFs wraps f so its objects can't be larger than quota in total
+func newQuotaFs(ctx context.Context, f fs.Fs, quota int64) *quotaFs {
+	q := &quotaFs{
+		Fs:    f,
+		quota: quota,
+	}
+	stubFeatures := &fs.Features{
+		CanHaveEmptyDirectories:  true,
+		IsLocal:                  true,
+		ReadMimeType:             true,
+		WriteMimeType:            true,
+		ReadMetadata:             true,
+		WriteMetadata:            true,
+		UserMetadata:             true,
+		ReadDirMetadata:          true,
+		WriteDirMetadata:         true,
+		WriteDirSetModTime:       true,
+		UserDirMetadata:          true,
+		DirModTimeUpdatesOnWrite: true,
+		PartialUploads:           true,
+	}
+	q.features = stubFeatures.Fill(ctx, q).Mask(ctx, f).WrapsFs(q, f)
+	// Always report the quota even if f can't
+	q.features.About = q.About
+	return q
+}
+
+// Features returns the optional features of this Fs
+func (q *quotaFs) Features() *fs.Features { return q.features }
+
+// UnWrap returns the wrapped Fs
+func (q *quotaFs) UnWrap() fs.Fs { return q.Fs }
+
+// usage returns the bytes used, counting them if necessary.
+//
+// Call with the mutex held.
+func (q *quotaFs) usage(ctx context.Context) (int64, error) {
+	if !q.counted {
+		_, size, _, err := operations.Count(ctx, q.Fs)
+		if err != nil {
+			return 0, err
+		}
+		q.used, q.counted = size, true
+	}
+	return q.used, nil
+}
+
+// reserve adds delta bytes to the usage returning the bytes free
+// afterwards or an error if it would go over quota.
+func (q *quotaFs) reserve(ctx context.Context, delta int64) (free int64, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	used, err := q.usage(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if delta > 0 && used+delta > q.quota {
+		return 0, quotaError()
+	}
+	q.used += delta
+	return q.quota - q.used, nil
+}
+
+// add adds delta bytes to the usage
+func (q *quotaFs) add(delta int64) {
+	q.mu.Lock()
+	q.used += delta
+	q.mu.Unlock()
+}
+
+// existingSize returns the size of the object at remote which a
+// write will replace or 0 if there isn't one
+func (q *quotaFs) existingSize(ctx context.Context, remote string) int64 {
+	o, err := q.Fs.NewObject(ctx, remote)
+	if err != nil || o.Size() < 0 {
+		return 0
+	}
+	return o.Size()
+}
+
+// write runs fn to write in of size (which may be -1) replacing old
+// bytes checking the quota isn't exceeded. fn should return the size
+// written.
+func (q *quotaFs) write(ctx context.Context, in io.Reader, size, old int64, fn func(in io.Reader) (int64, error)) error {
+	delta := max(size, 0) - old
+	free, err := q.reserve(ctx, delta)
+	if err != nil {
+		return err
+	}
+	if size < 0 && in != nil {
+		in = &quotaReader{in: in, free: free}
+	}
+	written, err := fn(in)
+	if err != nil {
+		q.add(-delta)
+		return err
+	}
+	q.add(written - old - delta)
+	return nil
+}
+
+// quotaReader returns an error if more than free bytes are read
+type quotaReader struct {
+	in   io.Reader
+	free int64
+}
+
+// Read bytes from the reader
+func (r *quotaReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	r.free -= int64(n)
+	if r.free < 0 {
+		return n, quotaError()
+	}
+	return n, err
+}
+
+// wrapObject wraps o in a quotaObject
+func (q *quotaFs) wrapObject(o fs.Object, err error) (fs.Object, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &quotaObject{Object: o, f: q}, nil
+}
+
+// List the objects and directories in dir into entries.
+func (q *quotaFs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entries, err = q.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			entries[i] = &quotaObject{Object: o, f: q}
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.
+func (q *quotaFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	return q.wrapObject(q.Fs.NewObject(ctx, remote))
+}
+
+// put uploads in as src with fn
+func (q *quotaFs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, fn func(in io.Reader) (fs.Object, error)) (o fs.Object, err error) {
+	old := q.existingSize(ctx, src.Remote())
+	err = q.write(ctx, in, src.Size(), old, func(in io.Reader) (int64, error) {
+		o, err = fn(in)
+		if err != nil {
+			return 0, err
+		}
+		return o.Size(), nil
+	})
+	return q.wrapObject(o, err)
+}
+
+// Put in to the remote path with the modTime given of the given size
+func (q *quotaFs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return q.put(ctx, in, src, func(in io.Reader) (fs.Object, error) {
+		return q.Fs.Put(ctx, in, src, options...)
+	})
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (q *quotaFs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	do := q.Fs.Features().PutStream
+	if do == nil {
+		return nil, errors.New("can't PutStream")
+	}
+	return q.put(ctx, in, src, func(in io.Reader) (fs.Object, error) {
+		return do(ctx, in, src, options...)
+	})
+}
+
+// Copy src to this remote using server-side copy operations.
+func (q *quotaFs) Copy(ctx context.Context, src fs.Object, remote string) (o fs.Object, err error) {
+	do := q.Fs.Features().Copy
+	srcObj, ok := src.(*quotaObject)
+	if do == nil || !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	old := q.existingSize(ctx, remote)
+	err = q.write(ctx, nil, src.Size(), old, func(io.Reader) (int64, error) {
+		o, err = do(ctx, srcObj.Object, remote)
+		if err != nil {
+			return 0, err
+		}
+		return o.Size(), nil
+	})
+	return q.wrapObject(o, err)
+}
+
+// Move src to this remote using server-side move operations.
+func (q *quotaFs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := q.Fs.Features().Move
+	srcObj, ok := src.(*quotaObject)
+	if do == nil || !ok || srcObj.f != q {
+		return nil, fs.ErrorCantMove
+	}
+	old := q.existingSize(ctx, remote)
+	o, err := do(ctx, srcObj.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	q.add(-old)
+	return q.wrapObject(o, nil)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote using server-side move operations.
+func (q *quotaFs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := q.Fs.Features().DirMove
+	srcFs, ok := src.(*quotaFs)
+	if do == nil || !ok || srcFs != q {
+		return fs.ErrorCantDirMove
+	}
+	return do(ctx, srcFs.Fs, srcRemote, dstRemote)
+}
+
+// Purge all files in the directory specified
+func (q *quotaFs) Purge(ctx context.Context, dir string) error {
+	do := q.Fs.Features().Purge
+	if do == nil {
+		return fs.ErrorCantPurge
+	}
+	err := do(ctx, dir)
+	// Count the usage again when next needed
+	q.mu.Lock()
+	q.counted = false
+	q.mu.Unlock()
+	return err
+}
+
+// About gets quota information from the Fs
+func (q *quotaFs) About(ctx context.Context) (*fs.Usage, error) {
+	q.mu.Lock()
+	used, err := q.usage(ctx)
+	q.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &fs.Usage{
+		Total: fs.NewUsageValue(q.quota),
+		Used:  fs.NewUsageValue(used),
+		Free:  fs.NewUsageValue(max(q.quota-used, 0)),
+	}, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *quotaObject) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *quotaObject) UnWrap() fs.Object { return o.Object }
+
+// Update in to the object with the modTime given of the given size
+func (o *quotaObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.f.write(ctx, in, src.Size(), max(o.Object.Size(), 0), func(in io.Reader) (int64, error) {
+		err := o.Object.Update(ctx, in, src, options...)
+		return o.Object.Size(), err
+	})
+}
+
+// Remove the object
+func (o *quotaObject) Remove(ctx context.Context) error {
+	size := max(o.Object.Size(), 0)
+	err := o.Object.Remove(ctx)
+	if err == nil {
+		o.f.add(-size)
+	}
+	return err
+}
+
+// ID returns the ID of the Object if known, or "" if not
+func (o *quotaObject) ID() string {
+	if do, ok := o.Object.(fs.IDer); ok {
+		return do.ID()
+	}
+	return ""
+}
+
+// MimeType returns the content type of the Object if known
+func (o *quotaObject) MimeType(ctx context.Context) string {
+	if do, ok := o.Object.(fs.MimeTyper); ok {
+		return do.MimeType(ctx)
+	}
+	return ""
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *quotaObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// SetMetadata sets metadata for an Object
+//
+// It should return fs.ErrorNotImplemented if it can't set metadata
+func (o *quotaObject) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	do, ok := o.Object.(fs.SetMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*quotaFs)(nil)
+	_ fs.Abouter         = (*quotaFs)(nil)
+	_ fs.Copier          = (*quotaFs)(nil)
+	_ fs.Mover           = (*quotaFs)(nil)
+	_ fs.DirMover        = (*quotaFs)(nil)
+	_ fs.Purger          = (*quotaFs)(nil)
+	_ fs.PutStreamer     = (*quotaFs)(nil)
+	_ fs.UnWrapper       = (*quotaFs)(nil)
+	_ fs.Object          = (*quotaObject)(nil)
+	_ fs.IDer            = (*quotaObject)(nil)
+	_ fs.MimeTyper       = (*quotaObject)(nil)
+	_ fs.Metadataer      = (*quotaObject)(nil)
+	_ fs.SetMetadataer   = (*quotaObject)(nil)
+	_ fs.ObjectUnWrapper = (*quotaObject)(nil)
+)
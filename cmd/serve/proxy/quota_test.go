@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putQuota uploads contents to remote in f, with unknown size if stream
+func putQuota(ctx context.Context, t *testing.T, f fs.Fs, remote, contents string, stream bool) (fs.Object, error) {
+	size := int64(len(contents))
+	if stream {
+		size = -1
+	}
+	src := object.NewStaticObjectInfo(remote, time.Now(), size, true, nil, nil)
+	if stream {
+		return f.Features().PutStream(ctx, strings.NewReader(contents), src)
+	}
+	return f.Put(ctx, strings.NewReader(contents), src)
+}
+
+// checkUsed checks the bytes used reported by About
+func checkUsed(ctx context.Context, t *testing.T, f fs.Fs, want int64) {
+	usage, err := f.Features().About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *usage.Total)
+	assert.Equal(t, want, *usage.Used)
+	assert.Equal(t, 10-want, *usage.Free)
+}
+
+func TestQuotaFs(t *testing.T) {
+	ctx := context.Background()
+	base, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	// Existing files are counted
+	_, err = putQuota(ctx, t, base, "existing", "123", false)
+	require.NoError(t, err)
+	f := newQuotaFs(ctx, base, 10)
+	checkUsed(ctx, t, f, 3)
+
+	// Uploads up to the quota work
+	o, err := putQuota(ctx, t, f, "a", "4567", false)
+	require.NoError(t, err)
+	checkUsed(ctx, t, f, 7)
+
+	// But not over it
+	_, err = putQuota(ctx, t, f, "b", "4567", false)
+	assert.True(t, errors.Is(err, ErrorQuotaExceeded))
+	checkUsed(ctx, t, f, 7)
+
+	// Streamed uploads are stopped when they go over
+	_, err = putQuota(ctx, t, f, "b", "4567", true)
+	assert.True(t, errors.Is(err, ErrorQuotaExceeded))
+	checkUsed(ctx, t, f, 7)
+	_, err = putQuota(ctx, t, f, "b", "45", true)
+	require.NoError(t, err)
+	checkUsed(ctx, t, f, 9)
+
+	// Replacing a file only counts the difference
+	src := object.NewStaticObjectInfo("a", time.Now(), 5, true, nil, nil)
+	require.NoError(t, o.Update(ctx, bytes.NewBufferString("45678"), src))
+	checkUsed(ctx, t, f, 10)
+	_, err = putQuota(ctx, t, f, "existing", "1", false)
+	require.NoError(t, err)
+	checkUsed(ctx, t, f, 8)
+
+	// Copies are counted
+	_, err = operations.Copy(ctx, f, nil, "c", o)
+	assert.True(t, errors.Is(err, ErrorQuotaExceeded))
+	checkUsed(ctx, t, f, 8)
+
+	// Moves over an existing file free its space
+	b, err := f.NewObject(ctx, "b")
+	require.NoError(t, err)
+	_, err = f.Features().Move(ctx, b, "existing")
+	require.NoError(t, err)
+	checkUsed(ctx, t, f, 7)
+
+	// Removing files frees space
+	require.NoError(t, o.Remove(ctx))
+	checkUsed(ctx, t, f, 2)
+
+	// Purging a directory frees its space
+	require.NoError(t, f.Mkdir(ctx, "dir"))
+	_, err = putQuota(ctx, t, f, "dir/file", "12345", false)
+	require.NoError(t, err)
+	checkUsed(ctx, t, f, 7)
+	require.NoError(t, operations.Purge(ctx, f, "dir"))
+	checkUsed(ctx, t, f, 2)
+}
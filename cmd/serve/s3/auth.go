@@ -0,0 +1,254 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/gofakes3/signature"
+	"github.com/rclone/rclone/fs"
+)
+
+// Query parameters used by presigned URLs
+const (
+	amzAlgorithm  = "X-Amz-Algorithm"
+	amzCredential = "X-Amz-Credential"
+	amzDate       = "X-Amz-Date"
+	amzExpires    = "X-Amz-Expires"
+	amzSignature  = "X-Amz-Signature"
+	amzSigned     = "X-Amz-SignedHeaders"
+	iso8601Format = "20060102T150405Z"
+)
+
+// maxPresignedExpiry is the longest a presigned URL can be valid for,
+// as in S3
+const maxPresignedExpiry = 7 * 24 * time.Hour
+
+// timeNow is the current time - replaced in tests
+var timeNow = time.Now
+
+// isPresigned returns true if r is authorized by a presigned URL
+func isPresigned(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" && r.URL.Query().Get(amzSignature) != ""
+}
+
+// isAnonymous returns true if r has no authorization
+func isAnonymous(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" && r.URL.Query().Get(amzSignature) == ""
+}
+
+// presignedAuth returns an Authorization header equivalent to the
+// query parameters of a presigned URL
+func presignedAuth(q url.Values) string {
+	return fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Signature=%s", q.Get(amzAlgorithm), q.Get(amzCredential), q.Get(amzSigned), q.Get(amzSignature))
+}
+
+// checkPresigned checks the validity period of the presigned URL in
+// q at time now, returning nil if it is valid.
+//
+// The signature itself is checked by gofakes3.
+func checkPresigned(q url.Values, now time.Time) *signature.APIError {
+	expiresStr := q.Get(amzExpires)
+	if expiresStr == "" {
+		return &signature.APIError{
+			Code:           "AuthorizationQueryParametersError",
+			Description:    "X-Amz-Expires must be set for a presigned URL",
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+	expiresSeconds, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || expiresSeconds <= 0 {
+		return &signature.APIError{
+			Code:           "AuthorizationQueryParametersError",
+			Description:    "X-Amz-Expires should be a positive number of seconds",
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+	expires := time.Duration(expiresSeconds) * time.Second
+	if expires > maxPresignedExpiry {
+		return &signature.APIError{
+			Code:           "AuthorizationQueryParametersError",
+			Description:    fmt.Sprintf("X-Amz-Expires must be less than a week (in seconds) that is %d", int64(maxPresignedExpiry/time.Second)),
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+	date, err := time.Parse(iso8601Format, q.Get(amzDate))
+	if err != nil {
+		return &signature.APIError{
+			Code:           "AuthorizationQueryParametersError",
+			Description:    "X-Amz-Date must be in the ISO8601 Long Format \"yyyyMMdd'T'HHmmss'Z'\"",
+			HTTPStatusCode: http.StatusBadRequest,
+		}
+	}
+	if date.After(now.Add(gofakes3.DefaultSkewLimit)) {
+		return &signature.APIError{
+			Code:           "AccessDenied",
+			Description:    "Request is not valid yet",
+			HTTPStatusCode: http.StatusForbidden,
+		}
+	}
+	if now.After(date.Add(expires)) {
+		return &signature.APIError{
+			Code:           "AccessDenied",
+			Description:    "Request has expired",
+			HTTPStatusCode: http.StatusForbidden,
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the signature of r as gofakes3 would,
+// checking the validity period first if r is presigned
+func verifySignature(r *http.Request) *signature.APIError {
+	if isPresigned(r) {
+		if err := checkPresigned(r.URL.Query(), timeNow()); err != nil {
+			return err
+		}
+	}
+	if code := signature.V4SignVerify(r); code != signature.ErrNone {
+		apiErr := signature.GetAPIError(code)
+		return &apiErr
+	}
+	return nil
+}
+
+// writeAPIError writes err as an S3 error response
+func writeAPIError(w http.ResponseWriter, err *signature.APIError) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(err.HTTPStatusCode)
+	_, _ = w.Write(signature.EncodeAPIErrorToResponse(*err))
+}
+
+// bucketPolicy says what anonymous requests may do in a bucket
+type bucketPolicy int
+
+// Bucket policies
+const (
+	policyPrivate         bucketPolicy = iota // authorized requests only
+	policyPublicRead                          // anonymous reads and listings
+	policyPublicReadWrite                     // anonymous reads, listings and object writes
+)
+
+var bucketPolicyNames = map[string]bucketPolicy{
+	"private":           policyPrivate,
+	"public-read":       policyPublicRead,
+	"public-read-write": policyPublicReadWrite,
+}
+
+// parseBucketPolicies parses the bucket=policy pairs in list
+func parseBucketPolicies(list []string) (map[string]bucketPolicy, error) {
+	policies := make(map[string]bucketPolicy, len(list))
+	for _, v := range list {
+		bucket, name, ok := strings.Cut(v, "=")
+		bucket = strings.TrimSpace(bucket)
+		if !ok || bucket == "" || strings.Contains(bucket, "/") {
+			return nil, fmt.Errorf("invalid bucket policy %q: expecting bucket=policy", v)
+		}
+		policy, ok := bucketPolicyNames[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid bucket policy %q: policy must be private, public-read or public-read-write", v)
+		}
+		policies[bucket] = policy
+	}
+	return policies, nil
+}
+
+// bucketAndKey returns the bucket and object key that r is for
+func (w *Server) bucketAndKey(r *http.Request) (bucket, key string) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if !w.opt.ForcePathStyle {
+		// gofakes3 takes the bucket from the host in this case
+		bucket, _, _ = strings.Cut(r.Host, ".")
+		return bucket, p
+	}
+	bucket, key, _ = strings.Cut(p, "/")
+	return bucket, key
+}
+
+// isCleanPath returns true if the unescaped path p has no ".", ".."
+// or empty segments, so it can only refer to the bucket and key it
+// appears to. A trailing "/" is allowed as keys may end in one.
+func isCleanPath(p string) bool {
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "/"), "/")
+	if p == "" {
+		return true
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return path.Clean("/"+p) == "/"+p
+}
+
+// anonymousAllowed returns true if the bucket policies allow r
+// without authorization
+func (w *Server) anonymousAllowed(r *http.Request) bool {
+	// Don't let paths like /public/../private escape the bucket
+	if !isCleanPath(r.URL.Path) {
+		return false
+	}
+	bucket, key := w.bucketAndKey(r)
+	if bucket == "" {
+		return false
+	}
+	policy := w.policies[bucket]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return policy >= policyPublicRead
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+		if policy < policyPublicReadWrite || key == "" {
+			return false
+		}
+		// The source of a copy must be readable too
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _, _ = strings.Cut(source, "?") // remove ?versionId=
+			source, err := url.PathUnescape(source)
+			if err != nil || !isCleanPath(source) {
+				return false
+			}
+			sourceBucket, _, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+			return w.policies[sourceBucket] >= policyPublicRead
+		}
+		return true
+	}
+	return false
+}
+
+// errAccessDenied is returned for anonymous requests when auth is in use
+var errAccessDenied = &signature.APIError{
+	Code:           "AccessDenied",
+	Description:    "Access Denied",
+	HTTPStatusCode: http.StatusForbidden,
+}
+
+// authMiddleware checks the validity period of presigned URLs and
+// sends anonymous requests allowed by the bucket policies to the
+// handler without authorization.
+func (w *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if len(w.opt.AuthKey) == 0 {
+			// Anything goes
+		} else if isPresigned(r) {
+			if err := checkPresigned(r.URL.Query(), timeNow()); err != nil {
+				fs.Infof(r.URL.Path, "%s: Presigned URL refused: %s", r.RemoteAddr, err.Description)
+				writeAPIError(rw, err)
+				return
+			}
+		} else if isAnonymous(r) && r.Method != http.MethodOptions {
+			if w.anonHandler == nil || !w.anonymousAllowed(r) {
+				fs.Infof(r.URL.Path, "%s: Anonymous %s refused", r.RemoteAddr, r.Method)
+				writeAPIError(rw, errAccessDenied)
+				return
+			}
+			w.anonHandler.ServeHTTP(rw, r)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
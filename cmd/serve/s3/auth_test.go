@@ -0,0 +1,337 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPresigned(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		date    string
+		expires string
+		want    string
+	}{
+		{date: "20240102T030000Z", expires: "3600"},
+		{date: "20240102T030000Z", expires: "60", want: "Request has expired"},
+		{date: "20240102T040000Z", expires: "60", want: "Request is not valid yet"},
+		{date: "20240102T030000Z", expires: "", want: "X-Amz-Expires must be set"},
+		{date: "20240102T030000Z", expires: "-1", want: "positive number"},
+		{date: "20240102T030000Z", expires: "604801", want: "less than a week"},
+		{date: "20240102T030000Z", expires: "604800"},
+		{date: "2024-01-02", expires: "60", want: "X-Amz-Date"},
+	} {
+		t.Run(fmt.Sprintf("%s,%s", test.date, test.expires), func(t *testing.T) {
+			q := url.Values{}
+			q.Set(amzDate, test.date)
+			if test.expires != "" {
+				q.Set(amzExpires, test.expires)
+			}
+			err := checkPresigned(q, now)
+			if test.want == "" {
+				assert.Nil(t, err)
+			} else {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Description, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBucketPolicies(t *testing.T) {
+	policies, err := parseBucketPolicies([]string{"a=public-read", " b = public-read-write ", "c=private"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bucketPolicy{
+		"a": policyPublicRead,
+		"b": policyPublicReadWrite,
+		"c": policyPrivate,
+	}, policies)
+	for _, bad := range []string{"a", "=public-read", "a/b=public-read", "a=public"} {
+		_, err := parseBucketPolicies([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+// serve f with auth and the bucket policies given returning the URL
+// and a client
+func serveS3Auth(t *testing.T, f fs.Fs, policies ...string) (testURL string, client *minio.Client) {
+	keyid := random.String(16)
+	keysec := random.String(16)
+	opt := Opt // copy default options
+	opt.AuthKey = []string{fmt.Sprintf("%s,%s", keyid, keysec)}
+	opt.BucketPolicy = policies
+	opt.HTTP.ListenAddr = []string{endpoint}
+	w, err := newServer(context.Background(), f, &opt, &vfscommon.Opt, &proxy.Opt)
+	require.NoError(t, err)
+	go func() {
+		require.NoError(t, w.Serve())
+	}()
+	t.Cleanup(func() { _ = w.Shutdown() })
+	testURL = strings.TrimSuffix(w.server.URLs()[0], "/")
+	u, err := url.Parse(testURL)
+	require.NoError(t, err)
+	client, err = minio.New(u.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(keyid, keysec, ""),
+		Secure: false,
+	})
+	require.NoError(t, err)
+	return testURL, client
+}
+
+// do makes an anonymous request returning the status and body
+func do(t *testing.T, method, u, body string) (int, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestPresigned(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, f.Mkdir(ctx, "bucket"))
+	testURL, client := serveS3Auth(t, f)
+
+	t.Run("Put", func(t *testing.T) {
+		u, err := client.PresignedPutObject(ctx, "bucket", "file.txt", time.Hour)
+		require.NoError(t, err)
+		status, _ := do(t, http.MethodPut, u.String(), "hello")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		u, err := client.PresignedGetObject(ctx, "bucket", "file.txt", time.Hour, nil)
+		require.NoError(t, err)
+		status, body := do(t, http.MethodGet, u.String(), "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "hello", body)
+
+		// The URL can't be used for another object
+		other := strings.Replace(u.String(), "file.txt", "other.txt", 1)
+		status, _ = do(t, http.MethodGet, other, "")
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		presign := func(method string, query url.Values) string {
+			u, err := client.Presign(ctx, method, "bucket", "multipart.txt", time.Hour, query)
+			require.NoError(t, err)
+			return u.String()
+		}
+		status, body := do(t, http.MethodPost, presign(http.MethodPost, url.Values{"uploads": {""}}), "")
+		require.Equal(t, http.StatusOK, status, body)
+		var initiate struct {
+			UploadID string `xml:"UploadId"`
+		}
+		require.NoError(t, xml.Unmarshal([]byte(body), &initiate))
+		uploadID := initiate.UploadID
+		require.NotEqual(t, "", uploadID)
+
+		status, body = do(t, http.MethodPut, presign(http.MethodPut, url.Values{"uploadId": {uploadID}, "partNumber": {"1"}}), "part one")
+		require.Equal(t, http.StatusOK, status, body)
+		status, body = do(t, http.MethodGet, presign(http.MethodGet, url.Values{"uploadId": {uploadID}}), "")
+		require.Equal(t, http.StatusOK, status, body)
+		assert.Contains(t, body, "<PartNumber>1</PartNumber>")
+
+		complete := fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"%x"</ETag></Part></CompleteMultipartUpload>`, md5.Sum([]byte("part one")))
+		status, body = do(t, http.MethodPost, presign(http.MethodPost, url.Values{"uploadId": {uploadID}}), complete)
+		require.Equal(t, http.StatusOK, status, body)
+		status, body = do(t, http.MethodGet, presign(http.MethodGet, nil), "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "part one", body)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		u, err := client.PresignedGetObject(ctx, "bucket", "file.txt", time.Minute, nil)
+		require.NoError(t, err)
+		oldTimeNow := timeNow
+		timeNow = func() time.Time { return time.Now().Add(2 * time.Minute) }
+		defer func() { timeNow = oldTimeNow }()
+		status, body := do(t, http.MethodGet, u.String(), "")
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, body, "Request has expired")
+	})
+
+	t.Run("NotValidYet", func(t *testing.T) {
+		u, err := client.PresignedGetObject(ctx, "bucket", "file.txt", time.Minute, nil)
+		require.NoError(t, err)
+		oldTimeNow := timeNow
+		timeNow = func() time.Time { return time.Now().Add(-time.Hour) }
+		defer func() { timeNow = oldTimeNow }()
+		status, body := do(t, http.MethodGet, u.String(), "")
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, body, "Request is not valid yet")
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		status, _ := do(t, http.MethodGet, testURL+"/bucket/file.txt", "")
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+}
+
+func TestBucketPolicy(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	testURL, client := serveS3Auth(t, f, "public=public-read", "open=public-read-write")
+	for _, bucket := range []string{"private", "public", "open"} {
+		require.NoError(t, client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}))
+		_, err := client.PutObject(ctx, bucket, "file.txt", bytes.NewBufferString(bucket), int64(len(bucket)), minio.PutObjectOptions{})
+		require.NoError(t, err)
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/", http.StatusForbidden},
+		{http.MethodGet, "/private/file.txt", http.StatusForbidden},
+		{http.MethodGet, "/private", http.StatusForbidden},
+		{http.MethodGet, "/public/file.txt", http.StatusOK},
+		{http.MethodHead, "/public/file.txt", http.StatusOK},
+		{http.MethodGet, "/public", http.StatusOK},
+		{http.MethodPut, "/public/new.txt", http.StatusForbidden},
+		{http.MethodDelete, "/public/file.txt", http.StatusForbidden},
+		{http.MethodGet, "/open/file.txt", http.StatusOK},
+		{http.MethodPut, "/open/new.txt", http.StatusOK},
+		{http.MethodDelete, "/open/new.txt", http.StatusNoContent},
+		{http.MethodDelete, "/open", http.StatusForbidden},
+		// Paths which try to escape a public bucket
+		{http.MethodGet, "/public/x/../../private/file.txt", http.StatusForbidden},
+		{http.MethodGet, "/public//../private/file.txt", http.StatusForbidden},
+		{http.MethodGet, "/public/%2E%2E/private/file.txt", http.StatusForbidden},
+		{http.MethodGet, "/public/%2e%2e%2fprivate/file.txt", http.StatusForbidden},
+		{http.MethodGet, "/public/./file.txt", http.StatusForbidden},
+		{http.MethodHead, "/public/../private/file.txt", http.StatusForbidden},
+		{http.MethodPut, "/open/../private/new.txt", http.StatusForbidden},
+		{http.MethodPut, "/open/%2E%2E/private/new.txt", http.StatusForbidden},
+		{http.MethodPost, "/open/../private/new.txt?uploads", http.StatusForbidden},
+		{http.MethodDelete, "/open/../private/file.txt", http.StatusForbidden},
+	} {
+		t.Run(test.method+test.path, func(t *testing.T) {
+			status, body := do(t, test.method, testURL+test.path, "new")
+			assert.Equal(t, test.status, status, body)
+		})
+	}
+
+	t.Run("CopyFromPrivate", func(t *testing.T) {
+		for _, source := range []string{
+			"/private/file.txt",
+			"/public/../private/file.txt",
+			"/public//../private/file.txt",
+			"/public/%2E%2E/private/file.txt",
+			"public/x/%2e%2e/../private/file.txt?versionId=null",
+		} {
+			req, err := http.NewRequest(http.MethodPut, testURL+"/open/copy.txt", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Amz-Copy-Source", source)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, source)
+		}
+	})
+
+	t.Run("CopyFromPublic", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, testURL+"/open/copy.txt", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Amz-Copy-Source", "/public/file.txt")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("AuthProxy", func(t *testing.T) {
+		opt := Opt
+		opt.BucketPolicy = []string{"public=public-read"}
+		proxyOpt := proxy.Opt
+		proxyOpt.AuthProxy = "auth-proxy"
+		_, err := newServer(ctx, f, &opt, &vfscommon.Opt, &proxyOpt)
+		assert.ErrorContains(t, err, "--auth-proxy")
+	})
+}
+
+func TestAuthHome(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	opt := Opt
+	opt.AuthKey = []string{"alice,alicesecret", "bob,bobsecret"}
+	opt.HTTP.ListenAddr = []string{endpoint}
+	proxyOpt := proxy.Opt
+	proxyOpt.AuthHome = base + "/{user}"
+	proxyOpt.AuthHomeQuota = 10
+	w, err := newServer(ctx, nil, &opt, &vfscommon.Opt, &proxyOpt)
+	require.NoError(t, err)
+	go func() {
+		require.NoError(t, w.Serve())
+	}()
+	t.Cleanup(func() { _ = w.Shutdown() })
+	u, err := url.Parse(w.server.URLs()[0])
+	require.NoError(t, err)
+	client := func(key, secret string) *minio.Client {
+		c, err := minio.New(u.Host, &minio.Options{
+			Creds: credentials.NewStaticV4(key, secret, ""),
+		})
+		require.NoError(t, err)
+		return c
+	}
+	alice := client("alice", "alicesecret")
+	bob := client("bob", "bobsecret")
+
+	// Each user has their own buckets
+	require.NoError(t, alice.MakeBucket(ctx, "bucket", minio.MakeBucketOptions{}))
+	_, err = alice.PutObject(ctx, "bucket", "file.txt", strings.NewReader("hello"), 5, minio.PutObjectOptions{})
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(base, "alice", "bucket", "file.txt"))
+	require.NoError(t, err)
+	buckets, err := bob.ListBuckets(ctx)
+	require.NoError(t, err)
+	assert.Len(t, buckets, 0)
+
+	// The quota is enforced
+	_, err = alice.PutObject(ctx, "bucket", "big.txt", strings.NewReader("hello world"), 11, minio.PutObjectOptions{})
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(base, "alice", "bucket", "big.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Unknown keys and bad secrets are refused
+	_, err = client("carol", "alicesecret").ListBuckets(ctx)
+	assert.Error(t, err)
+	_, err = client("alice", "bobsecret").ListBuckets(ctx)
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(base, "carol"))
+	assert.True(t, os.IsNotExist(err))
+
+	t.Run("NoAuthKey", func(t *testing.T) {
+		opt := Opt
+		_, err := newServer(ctx, nil, &opt, &vfscommon.Opt, &proxyOpt)
+		assert.ErrorContains(t, err, "--auth-key")
+	})
+}
@@ -27,6 +27,12 @@ var (
 type s3Backend struct {
 	s    *Server
 	meta *sync.Map
+
+	versionMu   sync.Mutex // held while changing the version store
+	lastVersion int64      // time of the last version ID made
+
+	keyMu    sync.Mutex          // protects keyLocks
+	keyLocks map[string]*keyLock // locks for objects in versioned buckets
 }
 
 // newBackend creates a new SimpleBucketBackend.
@@ -49,7 +55,7 @@ func (b *s3Backend) ListBuckets(ctx context.Context) ([]gofakes3.BucketInfo, err
 	}
 	var response []gofakes3.BucketInfo
 	for _, entry := range dirEntries {
-		if entry.IsDir() {
+		if entry.IsDir() && entry.Name() != versionsDir {
 			response = append(response, gofakes3.BucketInfo{
 				Name:         entry.Name(),
 				CreationDate: gofakes3.NewContentTime(entry.ModTime()),
@@ -112,6 +118,9 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 	if err != nil {
 		return nil, err
 	}
+	if versionID, ok := ctx.Value(ctxKeyVersionID).(gofakes3.VersionID); ok {
+		return b.headObjectVersion(_vfs, bucketName, objectName, versionID)
+	}
 	_, err = _vfs.Stat(bucketName)
 	if err != nil {
 		return nil, gofakes3.BucketNotFound(bucketName)
@@ -120,13 +129,32 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 	fp := path.Join(bucketName, objectName)
 	node, err := _vfs.Stat(fp)
 	if err != nil {
-		return nil, gofakes3.KeyNotFound(objectName)
+		return b.notFound(_vfs, bucketName, objectName)
 	}
 
 	if !node.IsFile() {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
+	obj, err := b.headNode(node, objectName, b.loadMeta(fp))
+	if err != nil {
+		return nil, err
+	}
+	obj.VersionID = b.currentVersionID(_vfs, bucketName, objectName)
+	return obj, nil
+}
+
+// loadMeta returns the metadata stored in memory for the object at fp
+func (b *s3Backend) loadMeta(fp string) map[string]string {
+	if val, ok := b.meta.Load(fp); ok {
+		return val.(map[string]string)
+	}
+	return nil
+}
+
+// headNode returns the info for the file node as objectName, adding
+// the metadata in meta.
+func (b *s3Backend) headNode(node vfs.Node, objectName string, meta map[string]string) (*gofakes3.Object, error) {
 	entry := node.DirEntry()
 	if entry == nil {
 		return nil, gofakes3.KeyNotFound(objectName)
@@ -136,20 +164,16 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 	size := node.Size()
 	hash := getFileHashByte(fobj, b.s.etagHashType)
 
-	meta := map[string]string{
+	objMeta := map[string]string{
 		"Last-Modified": formatHeaderTime(node.ModTime()),
 		"Content-Type":  fs.MimeType(context.Background(), fobj),
 	}
-
-	if val, ok := b.meta.Load(fp); ok {
-		metaMap := val.(map[string]string)
-		maps.Copy(meta, metaMap)
-	}
+	maps.Copy(objMeta, meta)
 
 	return &gofakes3.Object{
 		Name:     objectName,
 		Hash:     hash,
-		Metadata: meta,
+		Metadata: objMeta,
 		Size:     size,
 		Contents: noOpReadCloser{},
 	}, nil
@@ -169,24 +193,30 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 	fp := path.Join(bucketName, objectName)
 	node, err := _vfs.Stat(fp)
 	if err != nil {
-		return nil, gofakes3.KeyNotFound(objectName)
+		return b.notFound(_vfs, bucketName, objectName)
 	}
 
 	if !node.IsFile() {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
-	entry := node.DirEntry()
-	if entry == nil {
-		return nil, gofakes3.KeyNotFound(objectName)
+	obj, err = b.readNode(node, objectName, b.loadMeta(fp), rangeRequest)
+	if err != nil {
+		return nil, err
 	}
+	obj.VersionID = b.currentVersionID(_vfs, bucketName, objectName)
+	return obj, nil
+}
 
-	fobj := entry.(fs.Object)
+// readNode opens the file node as objectName, adding the metadata in
+// meta.
+func (b *s3Backend) readNode(node vfs.Node, objectName string, meta map[string]string, rangeRequest *gofakes3.ObjectRangeRequest) (obj *gofakes3.Object, err error) {
+	obj, err = b.headNode(node, objectName, meta)
+	if err != nil {
+		return nil, err
+	}
 	file := node.(*vfs.File)
 
-	size := node.Size()
-	hash := getFileHashByte(fobj, b.s.etagHashType)
-
 	in, err := file.Open(os.O_RDONLY)
 	if err != nil {
 		return nil, gofakes3.ErrInternal
@@ -199,7 +229,7 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 	}()
 
 	var rdr io.ReadCloser = in
-	rnge, err := rangeRequest.Range(size)
+	rnge, err := rangeRequest.Range(obj.Size)
 	if err != nil {
 		return nil, err
 	}
@@ -211,24 +241,9 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 		rdr = limitReadCloser(rdr, in.Close, rnge.Length)
 	}
 
-	meta := map[string]string{
-		"Last-Modified": formatHeaderTime(node.ModTime()),
-		"Content-Type":  fs.MimeType(context.Background(), fobj),
-	}
-
-	if val, ok := b.meta.Load(fp); ok {
-		metaMap := val.(map[string]string)
-		maps.Copy(meta, metaMap)
-	}
-
-	return &gofakes3.Object{
-		Name:     objectName,
-		Hash:     hash,
-		Metadata: meta,
-		Size:     size,
-		Range:    rnge,
-		Contents: rdr,
-	}, nil
+	obj.Range = rnge
+	obj.Contents = rdr
+	return obj, nil
 }
 
 // storeModtime sets both "mtime" and "X-Amz-Meta-Mtime" to val in b.meta.
@@ -286,6 +301,9 @@ func (b *s3Backend) TouchObject(ctx context.Context, fp string, meta map[string]
 }
 
 // PutObject creates or overwrites the object with the given name.
+//
+// If the bucket is versioned the object it replaces is kept as an
+// old version.
 func (b *s3Backend) PutObject(
 	ctx context.Context,
 	bucketName, objectName string,
@@ -301,6 +319,20 @@ func (b *s3Backend) PutObject(
 		return result, gofakes3.BucketNotFound(bucketName)
 	}
 
+	status := b.versioning(_vfs, bucketName)
+	if status == "" {
+		return b.putObject(_vfs, bucketName, objectName, meta, input)
+	}
+	return b.putObjectVersion(_vfs, bucketName, objectName, meta, input, status)
+}
+
+// putObject writes the object with the given name.
+func (b *s3Backend) putObject(
+	_vfs *vfs.VFS,
+	bucketName, objectName string,
+	meta map[string]string,
+	input io.Reader,
+) (result gofakes3.PutObjectResult, err error) {
 	fp := path.Join(bucketName, objectName)
 	objectDir := path.Dir(fp)
 	// _, err = db.fs.Stat(objectDir)
@@ -315,53 +347,65 @@ func (b *s3Backend) PutObject(
 		}
 	}
 
-	f, err := _vfs.Create(fp)
+	err = writeObjectData(_vfs, fp, input)
 	if err != nil {
 		return result, err
 	}
 
+	return result, b.setObjectMeta(_vfs, fp, meta)
+}
+
+// writeObjectData writes the data of an object from input to fp
+func writeObjectData(_vfs *vfs.VFS, fp string, input io.Reader) error {
+	f, err := _vfs.Create(fp)
+	if err != nil {
+		return err
+	}
+
 	if _, err := io.Copy(f, input); err != nil {
 		// remove file when i/o error occurred (FsPutErr)
 		_ = f.Close()
 		_ = _vfs.Remove(fp)
-		return result, err
+		return err
 	}
 
 	if err := f.Close(); err != nil {
 		// remove file when close error occurred (FsPutErr)
 		_ = _vfs.Remove(fp)
-		return result, err
+		return err
 	}
 
 	_, err = _vfs.Stat(fp)
-	if err != nil {
-		return result, err
-	}
+	return err
+}
 
+// setObjectMeta stores the metadata of the object just written to fp
+// and sets its modification time from it
+func (b *s3Backend) setObjectMeta(_vfs *vfs.VFS, fp string, meta map[string]string) error {
 	b.meta.Store(fp, meta)
 
 	if val, ok := meta["X-Amz-Meta-Mtime"]; ok {
 		ti, err := swift.FloatStringToTime(val)
 		if err == nil {
 			b.storeModtime(fp, meta, val)
-			return result, _vfs.Chtimes(fp, ti, ti)
+			return _vfs.Chtimes(fp, ti, ti)
 		}
 		// ignore error since the file is successfully created
 
 		if val, ok := meta["mtime"]; ok {
 			b.storeModtime(fp, meta, val)
-			return result, _vfs.Chtimes(fp, ti, ti)
+			return _vfs.Chtimes(fp, ti, ti)
 		}
 		// ignore error since the file is successfully created
 	}
 
-	return result, nil
+	return nil
 }
 
 // DeleteMulti deletes multiple objects in a single request.
 func (b *s3Backend) DeleteMulti(ctx context.Context, bucketName string, objects ...string) (result gofakes3.MultiDeleteResult, rerr error) {
 	for _, object := range objects {
-		if err := b.deleteObject(ctx, bucketName, object); err != nil {
+		if _, err := b.deleteObject(ctx, bucketName, object); err != nil {
 			fs.Errorf("serve s3", "delete object failed: %v", err)
 			result.Error = append(result.Error, gofakes3.ErrorResult{
 				Code:    gofakes3.ErrInternal,
@@ -380,30 +424,46 @@ func (b *s3Backend) DeleteMulti(ctx context.Context, bucketName string, objects
 
 // DeleteObject deletes the object with the given name.
 func (b *s3Backend) DeleteObject(ctx context.Context, bucketName, objectName string) (result gofakes3.ObjectDeleteResult, rerr error) {
-	return result, b.deleteObject(ctx, bucketName, objectName)
+	return b.deleteObject(ctx, bucketName, objectName)
 }
 
 // deleteObject deletes the object from the filesystem.
-func (b *s3Backend) deleteObject(ctx context.Context, bucketName, objectName string) error {
+//
+// If the bucket is versioned the object is kept as an old version and
+// a delete marker is added.
+func (b *s3Backend) deleteObject(ctx context.Context, bucketName, objectName string) (result gofakes3.ObjectDeleteResult, err error) {
 	_vfs, err := b.s.getVFS(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
 	_, err = _vfs.Stat(bucketName)
 	if err != nil {
-		return gofakes3.BucketNotFound(bucketName)
+		return result, gofakes3.BucketNotFound(bucketName)
+	}
+
+	if status := b.versioning(_vfs, bucketName); status != "" {
+		defer b.lockKey(bucketName, objectName)()
+		err = b.keepCurrentVersion(_vfs, bucketName, objectName, status)
+		if err != nil {
+			return result, err
+		}
+		result.VersionID, err = b.addDeleteMarker(_vfs, bucketName, objectName, status)
+		if err != nil {
+			return result, err
+		}
+		result.IsDeleteMarker = result.VersionID != ""
 	}
 
 	fp := path.Join(bucketName, objectName)
 	// S3 does not report an error when attempting to delete a key that does not exist, so
 	// we need to skip IsNotExist errors.
 	if err := _vfs.Remove(fp); err != nil && !os.IsNotExist(err) {
-		return err
+		return result, err
 	}
 
 	// FIXME: unsafe operation
 	rmdirRecursive(fp, _vfs)
-	return nil
+	return result, nil
 }
 
 // CreateBucket creates a new bucket.
@@ -438,10 +498,15 @@ func (b *s3Backend) DeleteBucket(ctx context.Context, name string) error {
 		return gofakes3.BucketNotFound(name)
 	}
 
+	if b.hasVersions(_vfs, name) {
+		return gofakes3.ErrBucketNotEmpty
+	}
+
 	if err := _vfs.Remove(name); err != nil {
 		return gofakes3.ErrBucketNotEmpty
 	}
 
+	b.removeVersionStore(_vfs, name)
 	return nil
 }
 
@@ -451,6 +516,9 @@ func (b *s3Backend) BucketExists(ctx context.Context, name string) (exists bool,
 	if err != nil {
 		return false, err
 	}
+	if name == versionsDir {
+		return false, nil
+	}
 	_, err = _vfs.Stat(name)
 	if err != nil {
 		return false, nil
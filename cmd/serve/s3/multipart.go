@@ -0,0 +1,1062 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	xml "github.com/minio/xxml"
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/sync/errgroup"
+)
+
+// gofakes3 assembles multipart uploads in memory, so serve s3 handles
+// the multipart API itself. The parts of each upload are kept in files
+// in the cache directory until the upload is completed when they are
+// streamed into the VFS one after another, or straight to the backend
+// if it can write in chunks.
+//
+// The spool has a directory for each VFS served containing a
+// directory for each upload. That has the upload state in uploadFile
+// and for each part its data in NNNNN.part and its details in
+// NNNNN.part.json.
+
+const (
+	uploadFile  = "upload.json"
+	partSuffix  = ".part"
+	jsonSuffix  = ".json"
+	uploadIDLen = 32
+)
+
+// Values of X-Amz-Content-Sha256
+const streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+
+// upload is the state of a multipart upload
+type upload struct {
+	Bucket    string
+	Key       string
+	Meta      map[string]string
+	Initiated time.Time
+}
+
+// uploadInfo is an upload with its ID
+type uploadInfo struct {
+	upload
+	ID string
+}
+
+// part describes an uploaded part
+type part struct {
+	ETag     string
+	Size     int64
+	Modified time.Time
+}
+
+// copyPartResult is the response to UploadPartCopy
+type copyPartResult struct {
+	XMLName      xml.Name             `xml:"CopyPartResult"`
+	ETag         string               `xml:"ETag"`
+	LastModified gofakes3.ContentTime `xml:"LastModified"`
+}
+
+// spool keeps the parts of multipart uploads on disk
+type spool struct {
+	root       string
+	mu         sync.Mutex
+	users      map[string]int      // number of requests using each upload
+	completing map[string]struct{} // uploads being completed
+}
+
+// newSpool makes a spool keeping uploads under root
+func newSpool(root string) *spool {
+	return &spool{
+		root:       root,
+		users:      make(map[string]int),
+		completing: make(map[string]struct{}),
+	}
+}
+
+// dir returns the directory the uploads to VFS are kept in
+func (s *spool) dir(VFS *vfs.VFS) string {
+	return filepath.Join(s.root, stringToMd5Hash(fs.ConfigString(VFS.Fs())))
+}
+
+// newUploadID makes an upload ID which sorts in order of creation
+func newUploadID() string {
+	return fmt.Sprintf("%016x", time.Now().UnixNano()) + random.String(uploadIDLen-16)
+}
+
+// isUploadID returns true if id could have been made by newUploadID
+func isUploadID(id string) bool {
+	if len(id) != uploadIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// partPath returns the path of the data of part n in dir
+func partPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d", n)+partSuffix)
+}
+
+// readJSON reads the JSON in the file at path into v
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON writes v as JSON to the file at path, replacing it
+// atomically
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "json-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// create starts a new upload to VFS returning its ID
+func (s *spool) create(VFS *vfs.VFS, u *upload) (id string, err error) {
+	id = newUploadID()
+	dir := filepath.Join(s.dir(VFS), id)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to make multipart upload directory: %w", err)
+	}
+	err = writeJSON(filepath.Join(dir, uploadFile), u)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to save multipart upload: %w", err)
+	}
+	return id, nil
+}
+
+// get finds the upload id of bucket/key returning its directory.
+//
+// The upload can't be expired until release is called.
+func (s *spool) get(VFS *vfs.VFS, bucket, key, id string) (dir string, u *upload, err error) {
+	if !isUploadID(id) {
+		return "", nil, gofakes3.ErrNoSuchUpload
+	}
+	s.mu.Lock()
+	_, busy := s.completing[id]
+	if !busy {
+		s.users[id]++
+	}
+	s.mu.Unlock()
+	if busy {
+		return "", nil, gofakes3.ErrNoSuchUpload
+	}
+	defer func() {
+		if err != nil {
+			s.release(id)
+		}
+	}()
+	dir = filepath.Join(s.dir(VFS), id)
+	u = new(upload)
+	err = readJSON(filepath.Join(dir, uploadFile), u)
+	if errors.Is(err, os.ErrNotExist) || err == nil && (u.Bucket != bucket || u.Key != key) {
+		return "", nil, gofakes3.ErrNoSuchUpload
+	} else if err != nil {
+		return "", nil, fmt.Errorf("failed to read multipart upload: %w", err)
+	}
+	return dir, u, nil
+}
+
+// release finishes with the upload id returned by get
+func (s *spool) release(id string) {
+	s.mu.Lock()
+	s.users[id]--
+	if s.users[id] <= 0 {
+		delete(s.users, id)
+	}
+	s.mu.Unlock()
+}
+
+// begin marks the upload id as being completed returning false if it
+// already is
+func (s *spool) begin(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, busy := s.completing[id]; busy {
+		return false
+	}
+	s.completing[id] = struct{}{}
+	return true
+}
+
+// beginExpire marks the upload id as being completed returning false
+// if it already is or if any requests are using it
+func (s *spool) beginExpire(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, busy := s.completing[id]; busy || s.users[id] > 0 {
+		return false
+	}
+	s.completing[id] = struct{}{}
+	return true
+}
+
+// end unmarks the upload id as being completed
+func (s *spool) end(id string) {
+	s.mu.Lock()
+	delete(s.completing, id)
+	s.mu.Unlock()
+}
+
+// list returns the uploads to VFS in bucket sorted by key and then
+// creation
+func (s *spool) list(VFS *vfs.VFS, bucket string) (uploads []uploadInfo, err error) {
+	dir := s.dir(VFS)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || !isUploadID(id) {
+			continue
+		}
+		info := uploadInfo{ID: id}
+		err := readJSON(filepath.Join(dir, id, uploadFile), &info.upload)
+		if err != nil {
+			fs.Debugf(nil, "serve s3: ignoring multipart upload %s: %v", id, err)
+			continue
+		}
+		if info.Bucket == bucket {
+			uploads = append(uploads, info)
+		}
+	}
+	slices.SortFunc(uploads, func(a, b uploadInfo) int {
+		if a.Key != b.Key {
+			return strings.Compare(a.Key, b.Key)
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return uploads, nil
+}
+
+// expire aborts the uploads to any VFS which were initiated before
+// cutoff returning the number aborted
+func (s *spool) expire(cutoff time.Time) (n int) {
+	vfsDirs, err := os.ReadDir(s.root)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fs.Errorf(nil, "serve s3: failed to list multipart uploads to expire: %v", err)
+		}
+		return 0
+	}
+	for _, vfsDir := range vfsDirs {
+		if !vfsDir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.root, vfsDir.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			fs.Errorf(nil, "serve s3: failed to list multipart uploads to expire: %v", err)
+			continue
+		}
+		for _, entry := range entries {
+			id := entry.Name()
+			if !entry.IsDir() || !isUploadID(id) {
+				continue
+			}
+			var u upload
+			err := readJSON(filepath.Join(dir, id, uploadFile), &u)
+			if err != nil {
+				// Use the time of the directory if the upload can't be read
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				u.Initiated = info.ModTime()
+			}
+			if !u.Initiated.Before(cutoff) || !s.beginExpire(id) {
+				continue
+			}
+			err = os.RemoveAll(filepath.Join(dir, id))
+			s.end(id)
+			if err != nil {
+				fs.Errorf(u.Key, "serve s3: failed to expire multipart upload %s: %v", id, err)
+				continue
+			}
+			fs.Infof(u.Key, "serve s3: expired multipart upload %s started at %v", id, u.Initiated)
+			n++
+		}
+	}
+	return n
+}
+
+// expireLoop aborts the uploads older than maxAge now and then
+// periodically until ctx is cancelled. It does nothing if maxAge is 0.
+func (s *spool) expireLoop(ctx context.Context, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	interval := min(maxAge, time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.expire(time.Now().Add(-maxAge))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parts returns the parts uploaded to dir by part number
+func (s *spool) parts(dir string) (map[int]part, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+	parts := make(map[int]part, len(entries)/2)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), partSuffix+jsonSuffix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		var p part
+		err = readJSON(filepath.Join(dir, entry.Name()), &p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part %d: %w", n, err)
+		}
+		parts[n] = p
+	}
+	return parts, nil
+}
+
+// writePart writes part n of the upload in dir from in. If size is
+// not -1 then in must be exactly that long and if md5sum is not nil
+// the MD5 of the data must match it.
+func (s *spool) writePart(dir string, n int, in io.Reader, size int64, md5sum []byte) (p part, err error) {
+	f, err := os.CreateTemp(dir, "part-*.tmp")
+	if errors.Is(err, os.ErrNotExist) {
+		// aborted while we were starting
+		return p, gofakes3.ErrNoSuchUpload
+	} else if err != nil {
+		return p, fmt.Errorf("failed to make part: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	hasher := md5.New()
+	if size >= 0 {
+		in = io.LimitReader(in, size)
+	}
+	written, err := io.Copy(io.MultiWriter(f, hasher), in)
+	if err != nil {
+		return p, err
+	}
+	if size >= 0 && written != size {
+		return p, gofakes3.ErrIncompleteBody
+	}
+	sum := hasher.Sum(nil)
+	if md5sum != nil && !bytes.Equal(sum, md5sum) {
+		return p, gofakes3.ErrBadDigest
+	}
+	err = f.Close()
+	if err != nil {
+		return p, err
+	}
+	path := partPath(dir, n)
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return p, err
+	}
+	p = part{
+		ETag:     `"` + hex.EncodeToString(sum) + `"`,
+		Size:     written,
+		Modified: time.Now(),
+	}
+	return p, writeJSON(path+jsonSuffix, p)
+}
+
+// chunkedReader decodes the aws-chunked encoding used when the
+// payload is signed chunk by chunk. Each chunk is
+//
+//	hex-size;chunk-signature=signature\r\n
+//	data\r\n
+//
+// ending with a chunk of size 0. The chunk signatures aren't checked.
+type chunkedReader struct {
+	in      *bufio.Reader
+	remain  int64 // bytes left in the current chunk
+	started bool  // set when the first chunk has been read
+	done    bool  // set when the last chunk has been read
+}
+
+// newChunkedReader makes a reader decoding the aws-chunked in
+func newChunkedReader(in io.Reader) *chunkedReader {
+	return &chunkedReader{in: bufio.NewReader(in)}
+}
+
+// errBadChunk is returned for a malformed aws-chunked payload
+var errBadChunk = gofakes3.ErrorMessage(gofakes3.ErrIncompleteBody, "Malformed aws-chunked payload")
+
+// next reads the header of the next chunk
+func (c *chunkedReader) next() error {
+	if c.started {
+		// skip the \r\n after the previous chunk
+		if _, err := c.in.Discard(2); err != nil {
+			return errBadChunk
+		}
+	}
+	c.started = true
+	line, err := c.in.ReadString('\n')
+	if err != nil {
+		return errBadChunk
+	}
+	sizeHex, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 {
+		return errBadChunk
+	}
+	c.remain = size
+	c.done = size == 0
+	return nil
+}
+
+// Read decoded data into p
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for c.remain == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		err = c.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err = c.in.Read(p)
+	c.remain -= int64(n)
+	if err == io.EOF {
+		err = errBadChunk
+	}
+	return n, err
+}
+
+// metadataHeaders returns the metadata to store for an object from
+// the request headers in the same way as gofakes3
+func metadataHeaders(header http.Header, at time.Time) (map[string]string, error) {
+	meta := make(map[string]string)
+	for k, v := range header {
+		if strings.HasPrefix(k, "X-Amz-") || strings.HasPrefix(k, "Content-") || k == "Cache-Control" {
+			meta[k] = v[0]
+		}
+	}
+	meta["Last-Modified"] = formatHeaderTime(at)
+	size := 0
+	for k, v := range meta {
+		size += len(k) + len(v)
+	}
+	if size > gofakes3.DefaultMetadataSizeLimit {
+		return nil, gofakes3.ErrMetadataTooLarge
+	}
+	return meta, nil
+}
+
+// parseLimit parses the max-uploads or max-parts query parameter
+func parseLimit(s string, limit int64) (int64, error) {
+	if s == "" {
+		return limit, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, gofakes3.ErrInvalidURI
+	}
+	if n == 0 || n > limit {
+		n = limit
+	}
+	return n, nil
+}
+
+// writeXML writes v as the XML response
+func writeXML(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(xml.Header))
+	xe := xml.NewEncoder(w)
+	xe.Indent("", "  ")
+	return xe.Encode(v)
+}
+
+// writeS3Error writes err as an S3 error response in the same way as
+// gofakes3
+func writeS3Error(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		resp gofakes3.Error
+		code gofakes3.ErrorCode
+	)
+	if errors.As(err, &code) {
+		resp = &gofakes3.ErrorResponse{Code: code, Message: code.Message()}
+	} else if !errors.As(err, &resp) {
+		fs.Errorf(r.URL.Path, "serve s3: %s multipart upload failed: %v", r.Method, err)
+		resp = &gofakes3.ErrorResponse{Code: gofakes3.ErrInternal, Message: "Internal Error"}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(resp.ErrorCode().Status())
+	_ = writeXML(w, resp)
+}
+
+// multipartMiddleware handles the multipart upload API, passing other
+// requests to next. If verify is set the request signatures are
+// checked as gofakes3 would.
+func (w *Server) multipartMiddleware(next http.Handler, verify bool) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		uploadID := q.Get("uploadId")
+		_, isUploads := q["uploads"]
+		if uploadID == "" && !isUploads {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if verify {
+			if apiErr := verifySignature(r); apiErr != nil {
+				fs.Infof(r.URL.Path, "%s: Access denied: %s", r.RemoteAddr, apiErr.Description)
+				writeAPIError(rw, apiErr)
+				return
+			}
+		}
+		err := w.serveMultipart(rw, r, uploadID, isUploads)
+		if err != nil {
+			writeS3Error(rw, r, err)
+		}
+	})
+}
+
+// serveMultipart routes a multipart upload request
+func (w *Server) serveMultipart(rw http.ResponseWriter, r *http.Request, uploadID string, isUploads bool) error {
+	bucket, key := w.bucketAndKey(r)
+	if bucket == "" {
+		return gofakes3.ErrInvalidURI
+	}
+	VFS, err := w.getVFS(r.Context())
+	if err != nil {
+		return err
+	}
+	exists, err := w.backend.BucketExists(r.Context(), bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return gofakes3.BucketNotFound(bucket)
+	}
+	switch {
+	case uploadID == "" && r.Method == http.MethodGet:
+		return w.listUploads(rw, r, VFS, bucket)
+	case key == "":
+		return gofakes3.ErrMethodNotAllowed
+	case uploadID == "" && r.Method == http.MethodPost:
+		return w.initiateUpload(rw, r, VFS, bucket, key)
+	case uploadID == "":
+		return gofakes3.ErrMethodNotAllowed
+	}
+	dir, u, err := w.spool.get(VFS, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	defer w.spool.release(uploadID)
+	switch r.Method {
+	case http.MethodGet:
+		return w.listParts(rw, r, dir, bucket, key, uploadID)
+	case http.MethodPut:
+		return w.uploadPart(rw, r, dir)
+	case http.MethodPost:
+		return w.completeUpload(rw, r, VFS, dir, u, uploadID)
+	case http.MethodDelete:
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return fmt.Errorf("failed to abort multipart upload: %w", err)
+		}
+		fs.Debugf(u.Key, "serve s3: aborted multipart upload %s", uploadID)
+		rw.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return gofakes3.ErrMethodNotAllowed
+}
+
+// initiateUpload starts a multipart upload to bucket/key
+func (w *Server) initiateUpload(rw http.ResponseWriter, r *http.Request, VFS *vfs.VFS, bucket, key string) error {
+	meta, err := metadataHeaders(r.Header, time.Now())
+	if err != nil {
+		return err
+	}
+	id, err := w.spool.create(VFS, &upload{
+		Bucket:    bucket,
+		Key:       key,
+		Meta:      meta,
+		Initiated: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	fs.Debugf(key, "serve s3: started multipart upload %s", id)
+	return writeXML(rw, gofakes3.InitiateMultipartUpload{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: gofakes3.UploadID(id),
+	})
+}
+
+// uploadPart writes a part of the upload in dir from the body or
+// copies it from another object
+func (w *Server) uploadPart(rw http.ResponseWriter, r *http.Request, dir string) error {
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n <= 0 || n > gofakes3.MaxUploadPartNumber {
+		return gofakes3.ErrInvalidPart
+	}
+	if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+		return w.uploadPartCopy(rw, r, dir, n, source)
+	}
+	size := r.ContentLength
+	if size < 0 {
+		return gofakes3.ErrMissingContentLength
+	}
+	var in io.Reader = r.Body
+	if r.Header.Get("X-Amz-Content-Sha256") == streamingPayload {
+		in = newChunkedReader(r.Body)
+		size, err = strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || size < 0 {
+			return gofakes3.ErrMissingContentLength
+		}
+	}
+	var md5sum []byte
+	if v := r.Header.Values("Content-MD5"); len(v) > 0 {
+		md5sum, err = base64.StdEncoding.DecodeString(v[0])
+		if err != nil || len(md5sum) != md5.Size {
+			return gofakes3.ErrInvalidDigest
+		}
+	}
+	p, err := w.spool.writePart(dir, n, in, size, md5sum)
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("ETag", p.ETag)
+	return nil
+}
+
+// parseCopySource parses the X-Amz-Copy-Source header
+func parseCopySource(source string) (bucket, key string, versionID gofakes3.VersionID, err error) {
+	source, version, _ := strings.Cut(source, "?versionId=")
+	source, err = url.PathUnescape(source)
+	if err != nil {
+		return "", "", "", gofakes3.ErrInvalidArgument
+	}
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if bucket == "" || key == "" {
+		return "", "", "", gofakes3.ErrInvalidArgument
+	}
+	if version == "null" {
+		version = ""
+	}
+	return bucket, key, gofakes3.VersionID(version), nil
+}
+
+// parseCopySourceRange parses the X-Amz-Copy-Source-Range header
+// which must be of the form bytes=first-last
+func parseCopySourceRange(s string) (*gofakes3.ObjectRangeRequest, error) {
+	if s == "" {
+		return nil, nil
+	}
+	s, ok := strings.CutPrefix(s, "bytes=")
+	first, last, found := strings.Cut(s, "-")
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if !ok || !found || err1 != nil || err2 != nil || start < 0 || end < start {
+		return nil, gofakes3.ErrInvalidRange
+	}
+	return &gofakes3.ObjectRangeRequest{Start: start, End: end}, nil
+}
+
+// uploadPartCopy makes part n of the upload in dir from all or some
+// of the object in source
+func (w *Server) uploadPartCopy(rw http.ResponseWriter, r *http.Request, dir string, n int, source string) (err error) {
+	bucket, key, versionID, err := parseCopySource(source)
+	if err != nil {
+		return err
+	}
+	rangeRequest, err := parseCopySourceRange(r.Header.Get("X-Amz-Copy-Source-Range"))
+	if err != nil {
+		return err
+	}
+	var obj *gofakes3.Object
+	if versionID != "" {
+		versioned, ok := w.backend.(gofakes3.VersionedBackend)
+		if !ok {
+			return gofakes3.ErrNotImplemented
+		}
+		obj, err = versioned.GetObjectVersion(bucket, key, versionID, rangeRequest)
+	} else {
+		obj, err = w.backend.GetObject(r.Context(), bucket, key, rangeRequest)
+	}
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(obj.Contents, &err)
+	if obj.IsDeleteMarker {
+		return gofakes3.KeyNotFound(key)
+	}
+	p, err := w.spool.writePart(dir, n, obj.Contents, -1, nil)
+	if err != nil {
+		return err
+	}
+	return writeXML(rw, copyPartResult{
+		ETag:         p.ETag,
+		LastModified: gofakes3.NewContentTime(p.Modified),
+	})
+}
+
+// completeUpload puts the parts of the upload in dir listed in the
+// request together into the object
+func (w *Server) completeUpload(rw http.ResponseWriter, r *http.Request, VFS *vfs.VFS, dir string, u *upload, uploadID string) (err error) {
+	var in gofakes3.CompleteMultipartUploadRequest
+	err = xml.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		return gofakes3.ErrMalformedXML
+	}
+	if len(in.Parts) == 0 {
+		return gofakes3.ErrorMessage(gofakes3.ErrMalformedXML, "You must specify at least one part")
+	}
+	if !w.spool.begin(uploadID) {
+		return gofakes3.ErrNoSuchUpload
+	}
+	defer w.spool.end(uploadID)
+	parts, err := w.spool.parts(dir)
+	if err != nil {
+		return err
+	}
+
+	// Open the parts in order
+	var (
+		files []*os.File
+		body  partsReader
+		last  int
+	)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for _, inPart := range in.Parts {
+		if inPart.PartNumber <= last {
+			return gofakes3.ErrInvalidPartOrder
+		}
+		last = inPart.PartNumber
+		p, ok := parts[inPart.PartNumber]
+		if !ok || strings.Trim(inPart.ETag, `"`) != strings.Trim(p.ETag, `"`) {
+			return gofakes3.ErrorMessagef(gofakes3.ErrInvalidPart, "unexpected part etag for number %d in complete request", inPart.PartNumber)
+		}
+		f, err := os.Open(partPath(dir, inPart.PartNumber))
+		if err != nil {
+			return fmt.Errorf("failed to open part %d: %w", inPart.PartNumber, err)
+		}
+		files = append(files, f)
+		body.add(f, p.Size)
+	}
+
+	hasher := md5.New()
+	_, err = io.Copy(hasher, io.NewSectionReader(&body, 0, body.size))
+	if err != nil {
+		return fmt.Errorf("failed to read parts: %w", err)
+	}
+	result, err := w.putParts(r.Context(), VFS, u, &body)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		_ = f.Close()
+	}
+	files = nil
+	err = os.RemoveAll(dir)
+	if err != nil {
+		fs.Errorf(u.Key, "serve s3: failed to remove completed multipart upload: %v", err)
+	}
+	fs.Debugf(u.Key, "serve s3: completed multipart upload %s of %d parts", uploadID, len(in.Parts))
+
+	if result.VersionID != "" {
+		rw.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+	return writeXML(rw, gofakes3.CompleteMultipartUploadResult{
+		Bucket: u.Bucket,
+		Key:    u.Key,
+		ETag:   `"` + hex.EncodeToString(hasher.Sum(nil)) + `"`,
+	})
+}
+
+// partsReader reads the parts of an upload one after another as if
+// they were a single file
+type partsReader struct {
+	parts  []*io.SectionReader
+	starts []int64 // offset of each part
+	size   int64
+}
+
+// add appends the part in f which is size bytes long
+func (p *partsReader) add(f *os.File, size int64) {
+	p.parts = append(p.parts, io.NewSectionReader(f, 0, size))
+	p.starts = append(p.starts, p.size)
+	p.size += size
+}
+
+// ReadAt reads len(b) bytes from offset off
+func (p *partsReader) ReadAt(b []byte, off int64) (n int, err error) {
+	// Find the last part starting at or before off
+	i := sort.Search(len(p.starts), func(i int) bool { return p.starts[i] > off }) - 1
+	if i < 0 {
+		return 0, io.EOF
+	}
+	for ; n < len(b) && i < len(p.parts); i++ {
+		var m int
+		m, err = p.parts[i].ReadAt(b[n:], off+int64(n)-p.starts[i])
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// putParts writes the parts in body to the object of the upload u.
+//
+// If the backend can write in chunks the parts are sent straight to it
+// so large uploads aren't written to disk again by the VFS cache or
+// held in memory by a streaming upload. Otherwise, or if the bucket is
+// versioned, the object is written with PutObject.
+func (w *Server) putParts(ctx context.Context, VFS *vfs.VFS, u *upload, body *partsReader) (result gofakes3.PutObjectResult, err error) {
+	openChunkWriter := VFS.Fs().Features().OpenChunkWriter
+	b, ok := w.backend.(*s3Backend)
+	if !ok || openChunkWriter == nil || body.size == 0 || b.versioning(VFS, u.Bucket) != "" {
+		return w.backend.PutObject(ctx, u.Bucket, u.Key, u.Meta, io.NewSectionReader(body, 0, body.size), body.size)
+	}
+	fp := path.Join(u.Bucket, u.Key)
+	if dir := path.Dir(fp); dir != "." {
+		if err := mkdirRecursive(dir, VFS); err != nil {
+			return result, err
+		}
+	}
+	src := object.NewStaticObjectInfo(fp, time.Now(), body.size, true, nil, VFS.Fs())
+	info, writer, err := openChunkWriter(ctx, fp, src)
+	if err != nil {
+		return result, fmt.Errorf("failed to open chunk writer: %w", err)
+	}
+	err = writeChunks(ctx, writer, info, body)
+	if err == nil {
+		err = writer.Close(ctx)
+	}
+	if err != nil {
+		if !info.LeavePartsOnError {
+			if abortErr := writer.Abort(ctx); abortErr != nil {
+				fs.Debugf(fp, "serve s3: failed to abort chunked upload: %v", abortErr)
+			}
+		}
+		return result, fmt.Errorf("chunked upload failed: %w", err)
+	}
+
+	// The object was written behind the back of the VFS
+	root, err := VFS.Root()
+	if err != nil {
+		return result, err
+	}
+	root.ForgetPath(fp, fs.EntryObject)
+	return result, b.setObjectMeta(VFS, fp, u.Meta)
+}
+
+// writeChunks writes body to writer in chunks as described by info
+func writeChunks(ctx context.Context, writer fs.ChunkWriter, info fs.ChunkWriterInfo, body *partsReader) error {
+	chunkSize := info.ChunkSize
+	if chunkSize <= 0 || chunkSize > body.size {
+		chunkSize = body.size
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(max(info.Concurrency, 1))
+	for n, start := 0, int64(0); start < body.size && gCtx.Err() == nil; n, start = n+1, start+chunkSize {
+		chunk := io.NewSectionReader(body, start, min(chunkSize, body.size-start))
+		g.Go(func() error {
+			_, err := writer.WriteChunk(gCtx, n, chunk)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// listParts lists the parts of the upload in dir
+func (w *Server) listParts(rw http.ResponseWriter, r *http.Request, dir, bucket, key, uploadID string) error {
+	q := r.URL.Query()
+	marker := 0
+	if s := q.Get("part-number-marker"); s != "" {
+		var err error
+		marker, err = strconv.Atoi(s)
+		if err != nil || marker < 0 {
+			return gofakes3.ErrInvalidURI
+		}
+	}
+	maxParts, err := parseLimit(q.Get("max-parts"), gofakes3.MaxUploadPartsLimit)
+	if err != nil {
+		return err
+	}
+	parts, err := w.spool.parts(dir)
+	if err != nil {
+		return err
+	}
+	numbers := make([]int, 0, len(parts))
+	for n := range parts {
+		if n > marker {
+			numbers = append(numbers, n)
+		}
+	}
+	slices.Sort(numbers)
+
+	result := gofakes3.ListMultipartUploadPartsResult{
+		Bucket:           bucket,
+		Key:              key,
+		UploadID:         gofakes3.UploadID(uploadID),
+		StorageClass:     "STANDARD",
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	if int64(len(numbers)) > maxParts {
+		numbers = numbers[:maxParts]
+		result.IsTruncated = true
+	}
+	for _, n := range numbers {
+		p := parts[n]
+		result.Parts = append(result.Parts, gofakes3.ListMultipartUploadPartItem{
+			PartNumber:   n,
+			LastModified: gofakes3.NewContentTime(p.Modified),
+			ETag:         p.ETag,
+			Size:         p.Size,
+		})
+		result.NextPartNumberMarker = n
+	}
+	return writeXML(rw, result)
+}
+
+// listUploads lists the uploads in progress in bucket
+func (w *Server) listUploads(rw http.ResponseWriter, r *http.Request, VFS *vfs.VFS, bucket string) error {
+	q := r.URL.Query()
+	maxUploads, err := parseLimit(q.Get("max-uploads"), gofakes3.MaxUploadsLimit)
+	if err != nil {
+		return err
+	}
+	uploads, err := w.spool.list(VFS, bucket)
+	if err != nil {
+		return err
+	}
+	keyMarker, idMarker := q.Get("key-marker"), q.Get("upload-id-marker")
+	prefix := gofakes3.Prefix{
+		HasPrefix:    q.Get("prefix") != "",
+		Prefix:       q.Get("prefix"),
+		HasDelimiter: q.Get("delimiter") != "",
+		Delimiter:    q.Get("delimiter"),
+	}
+	result := gofakes3.ListMultipartUploadsResult{
+		Bucket:         bucket,
+		KeyMarker:      keyMarker,
+		UploadIDMarker: gofakes3.UploadID(idMarker),
+		MaxUploads:     maxUploads,
+		Delimiter:      prefix.Delimiter,
+		Prefix:         prefix.Prefix,
+	}
+	var (
+		match gofakes3.PrefixMatch
+		seen  = map[string]bool{}
+		count int64
+		last  uploadInfo
+	)
+	for _, u := range uploads {
+		if keyMarker != "" && (u.Key < keyMarker || u.Key == keyMarker && (idMarker == "" || u.ID <= idMarker)) {
+			continue
+		}
+		if !prefix.Match(u.Key, &match) {
+			continue
+		}
+		if match.CommonPrefix && seen[match.MatchedPart] {
+			last = u
+			continue
+		}
+		if count >= maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		count++
+		if match.CommonPrefix {
+			seen[match.MatchedPart] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, match.AsCommonPrefix())
+		} else {
+			result.Uploads = append(result.Uploads, gofakes3.ListMultipartUploadItem{
+				Key:          u.Key,
+				UploadID:     gofakes3.UploadID(u.ID),
+				StorageClass: "STANDARD",
+				Initiated:    gofakes3.NewContentTime(u.Initiated),
+			})
+		}
+		last = u
+	}
+	if result.IsTruncated {
+		result.NextKeyMarker = last.Key
+		result.NextUploadIDMarker = gofakes3.UploadID(last.ID)
+	}
+	return writeXML(rw, result)
+}
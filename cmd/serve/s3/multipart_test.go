@@ -0,0 +1,325 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/vfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedReader(t *testing.T) {
+	const sig = ";chunk-signature=0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\r\n"
+	in := "6" + sig + "hello \r\n" + "5" + sig + "world\r\n" + "0" + sig + "\r\n"
+	data, err := io.ReadAll(newChunkedReader(strings.NewReader(in)))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	for _, bad := range []string{"x" + sig, "6" + sig + "hel", "6" + sig + "hello "} {
+		_, err := io.ReadAll(newChunkedReader(strings.NewReader(bad)))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseCopySourceRange(t *testing.T) {
+	r, err := parseCopySourceRange("bytes=2-5")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), r.Start)
+	assert.Equal(t, int64(5), r.End)
+	r, err = parseCopySourceRange("")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+	for _, bad := range []string{"2-5", "bytes=5-2", "bytes=2-", "bytes=-5"} {
+		_, err := parseCopySourceRange(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPartsReader(t *testing.T) {
+	dir := t.TempDir()
+	var body partsReader
+	for i, data := range []string{"hello", "", " ", "world"} {
+		name := filepath.Join(dir, fmt.Sprint(i))
+		require.NoError(t, os.WriteFile(name, []byte(data), 0600))
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		body.add(f, int64(len(data)))
+	}
+	assert.Equal(t, int64(11), body.size)
+	for _, test := range []struct {
+		off  int64
+		n    int
+		want string
+		err  error
+	}{
+		{0, 11, "hello world", nil},
+		{3, 4, "lo w", nil},
+		{5, 1, " ", nil},
+		{8, 10, "rld", io.EOF},
+		{11, 1, "", io.EOF},
+	} {
+		b := make([]byte, test.n)
+		n, err := body.ReadAt(b, test.off)
+		assert.Equal(t, test.err, err, test.off)
+		assert.Equal(t, test.want, string(b[:n]), test.off)
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	ctx := context.Background()
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() { _ = config.SetCacheDir(oldCacheDir) }()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, f.Mkdir(ctx, "bucket"))
+	_, client := serveS3Auth(t, f)
+	core := minio.Core{Client: client}
+	_, err = client.PutObject(ctx, "bucket", "source.txt", strings.NewReader("0123456789"), 10, minio.PutObjectOptions{})
+	require.NoError(t, err)
+
+	const key = "dir/file.txt"
+	uploadID, err := core.NewMultipartUpload(ctx, "bucket", key, minio.PutObjectOptions{
+		UserMetadata: map[string]string{"potato": "jersey"},
+	})
+	require.NoError(t, err)
+	var parts []minio.CompletePart
+	for i, data := range []string{"hello ", "world "} {
+		p, err := core.PutObjectPart(ctx, "bucket", key, uploadID, i+1, strings.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+		require.NoError(t, err)
+		parts = append(parts, minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+
+	t.Run("ListUploads", func(t *testing.T) {
+		result, err := core.ListMultipartUploads(ctx, "bucket", "", "", "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, result.Uploads, 1)
+		assert.Equal(t, key, result.Uploads[0].Key)
+		assert.Equal(t, uploadID, result.Uploads[0].UploadID)
+
+		result, err = core.ListMultipartUploads(ctx, "bucket", "", "", "", "/", 0)
+		require.NoError(t, err)
+		assert.Len(t, result.Uploads, 0)
+		require.Len(t, result.CommonPrefixes, 1)
+		assert.Equal(t, "dir/", result.CommonPrefixes[0].Prefix)
+
+		result, err = core.ListMultipartUploads(ctx, "bucket", "", key, "", "", 0)
+		require.NoError(t, err)
+		assert.Len(t, result.Uploads, 0)
+	})
+
+	t.Run("ListParts", func(t *testing.T) {
+		result, err := core.ListObjectParts(ctx, "bucket", key, uploadID, 0, 1)
+		require.NoError(t, err)
+		require.Len(t, result.ObjectParts, 1)
+		assert.True(t, result.IsTruncated)
+		assert.Equal(t, parts[0].ETag, strings.Trim(result.ObjectParts[0].ETag, `"`))
+		assert.Equal(t, int64(6), result.ObjectParts[0].Size)
+
+		result, err = core.ListObjectParts(ctx, "bucket", key, uploadID, result.NextPartNumberMarker, 0)
+		require.NoError(t, err)
+		require.Len(t, result.ObjectParts, 1)
+		assert.False(t, result.IsTruncated)
+		assert.Equal(t, 2, result.ObjectParts[0].PartNumber)
+	})
+
+	// A new server reads the uploads from disk
+	_, client = serveS3Auth(t, f)
+	core = minio.Core{Client: client}
+
+	t.Run("Restart", func(t *testing.T) {
+		result, err := core.ListObjectParts(ctx, "bucket", key, uploadID, 0, 0)
+		require.NoError(t, err)
+		assert.Len(t, result.ObjectParts, 2)
+	})
+
+	t.Run("CopyPart", func(t *testing.T) {
+		p, err := core.CopyObjectPart(ctx, "bucket", "source.txt", "bucket", key, uploadID, 3, 2, 4, nil)
+		require.NoError(t, err)
+		parts = append(parts, p)
+	})
+
+	t.Run("CompleteBadETag", func(t *testing.T) {
+		bad := []minio.CompletePart{{PartNumber: 1, ETag: parts[1].ETag}}
+		_, err := core.CompleteMultipartUpload(ctx, "bucket", key, uploadID, bad, minio.PutObjectOptions{})
+		assert.ErrorContains(t, err, "etag")
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		_, err := core.CompleteMultipartUpload(ctx, "bucket", key, uploadID, parts, minio.PutObjectOptions{})
+		require.NoError(t, err)
+		obj, err := client.GetObject(ctx, "bucket", key, minio.GetObjectOptions{})
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world 2345", string(data))
+		info, err := obj.Stat()
+		require.NoError(t, err)
+		assert.Equal(t, "jersey", info.UserMetadata["Potato"])
+
+		// The parts have gone
+		dirs, err := filepath.Glob(filepath.Join(config.GetCacheDir(), "serve-s3", "uploads", "*", "*"))
+		require.NoError(t, err)
+		assert.Len(t, dirs, 0)
+		_, err = core.ListObjectParts(ctx, "bucket", key, uploadID, 0, 0)
+		assert.Equal(t, "NoSuchUpload", minio.ToErrorResponse(err).Code)
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		uploadID, err := core.NewMultipartUpload(ctx, "bucket", "aborted.txt", minio.PutObjectOptions{})
+		require.NoError(t, err)
+		require.NoError(t, core.AbortMultipartUpload(ctx, "bucket", "aborted.txt", uploadID))
+		_, err = core.PutObjectPart(ctx, "bucket", "aborted.txt", uploadID, 1, bytes.NewBufferString("x"), 1, minio.PutObjectPartOptions{})
+		assert.Equal(t, "NoSuchUpload", minio.ToErrorResponse(err).Code)
+		_, err = os.Stat(filepath.Join(f.Root(), "aborted.txt"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("NoSuchBucket", func(t *testing.T) {
+		_, err := core.NewMultipartUpload(ctx, "missing", "file.txt", minio.PutObjectOptions{})
+		assert.Equal(t, "NoSuchBucket", minio.ToErrorResponse(err).Code)
+	})
+}
+
+func TestMultipartExpire(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	VFS := vfs.New(ctx, f, nil)
+	defer VFS.Shutdown()
+	s := newSpool(t.TempDir())
+	now := time.Now()
+
+	oldID, err := s.create(VFS, &upload{Bucket: "bucket", Key: "old", Initiated: now.Add(-2 * time.Hour)})
+	require.NoError(t, err)
+	newID, err := s.create(VFS, &upload{Bucket: "bucket", Key: "new", Initiated: now})
+	require.NoError(t, err)
+	busyID, err := s.create(VFS, &upload{Bucket: "bucket", Key: "busy", Initiated: now.Add(-2 * time.Hour)})
+	require.NoError(t, err)
+	require.True(t, s.begin(busyID))
+
+	assert.Equal(t, 1, s.expire(now.Add(-time.Hour)))
+	_, _, err = s.get(VFS, "bucket", "old", oldID)
+	assert.Equal(t, gofakes3.ErrNoSuchUpload, err)
+	_, _, err = s.get(VFS, "bucket", "new", newID)
+	require.NoError(t, err)
+	s.release(newID)
+
+	// Uploads being completed aren't expired
+	s.end(busyID)
+
+	// Nor are uploads in use by a request
+	_, _, err = s.get(VFS, "bucket", "busy", busyID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.expire(now.Add(-time.Hour)))
+	s.release(busyID)
+	assert.Equal(t, 1, s.expire(now.Add(-time.Hour)))
+	assert.Equal(t, 0, s.expire(now.Add(-time.Hour)))
+}
+
+// chunkedFs is an Fs which writes objects with OpenChunkWriter
+type chunkedFs struct {
+	fs.Fs
+	chunkSize int64
+	mu        sync.Mutex
+	chunks    int // number of chunks written
+}
+
+// Features returns the features of the wrapped Fs with OpenChunkWriter
+func (f *chunkedFs) Features() *fs.Features {
+	ft := *f.Fs.Features()
+	ft.OpenChunkWriter = f.OpenChunkWriter
+	return &ft
+}
+
+// OpenChunkWriter collects the chunks in memory and puts them on Close
+func (f *chunkedFs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (fs.ChunkWriterInfo, fs.ChunkWriter, error) {
+	info := fs.ChunkWriterInfo{ChunkSize: f.chunkSize, Concurrency: 2}
+	return info, &chunkWriter{f: f, src: src, chunks: map[int][]byte{}}, nil
+}
+
+type chunkWriter struct {
+	f      *chunkedFs
+	src    fs.ObjectInfo
+	mu     sync.Mutex
+	chunks map[int][]byte
+}
+
+func (w *chunkWriter) WriteChunk(ctx context.Context, n int, in io.ReadSeeker) (int64, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	w.chunks[n] = data
+	w.mu.Unlock()
+	w.f.mu.Lock()
+	w.f.chunks++
+	w.f.mu.Unlock()
+	return int64(len(data)), nil
+}
+
+func (w *chunkWriter) Close(ctx context.Context) error {
+	var buf bytes.Buffer
+	for n := range len(w.chunks) {
+		buf.Write(w.chunks[n])
+	}
+	_, err := w.f.Fs.Put(ctx, &buf, w.src)
+	return err
+}
+
+func (w *chunkWriter) Abort(ctx context.Context) error {
+	return nil
+}
+
+func TestMultipartChunkWriter(t *testing.T) {
+	ctx := context.Background()
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() { _ = config.SetCacheDir(oldCacheDir) }()
+	local, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, local.Mkdir(ctx, "bucket"))
+	f := &chunkedFs{Fs: local, chunkSize: 4}
+	_, client := serveS3Auth(t, f)
+	core := minio.Core{Client: client}
+
+	const key = "dir/file.txt"
+	uploadID, err := core.NewMultipartUpload(ctx, "bucket", key, minio.PutObjectOptions{
+		UserMetadata: map[string]string{"potato": "jersey"},
+	})
+	require.NoError(t, err)
+	var parts []minio.CompletePart
+	for i, data := range []string{"hello ", "world", "!"} {
+		p, err := core.PutObjectPart(ctx, "bucket", key, uploadID, i+1, strings.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+		require.NoError(t, err)
+		parts = append(parts, minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	_, err = core.CompleteMultipartUpload(ctx, "bucket", key, uploadID, parts, minio.PutObjectOptions{})
+	require.NoError(t, err)
+
+	// The chunks don't line up with the parts
+	assert.Equal(t, 3, f.chunks)
+	obj, err := client.GetObject(ctx, "bucket", key, minio.GetObjectOptions{})
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(data))
+	info, err := obj.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, "jersey", info.UserMetadata["Potato"])
+}
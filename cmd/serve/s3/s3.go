@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"strings"
+	"time"
 
 	"github.com/rclone/rclone/cmd"
 	"github.com/rclone/rclone/cmd/serve"
@@ -37,6 +38,14 @@ var OptionsInfo = fs.Options{{
 	Name:    "no_cleanup",
 	Default: false,
 	Help:    "Not to cleanup empty folder after object is deleted",
+}, {
+	Name:    "bucket_policy",
+	Default: []string{},
+	Help:    "Set anonymous access to a bucket: bucket=public-read or bucket=public-read-write",
+}, {
+	Name:    "multipart_expiry",
+	Default: fs.Duration(24 * time.Hour),
+	Help:    "Abort multipart uploads which were started longer ago than this (0 to keep them)",
 }}.
 	Add(httplib.ConfigInfo).
 	Add(httplib.AuthConfigInfo)
@@ -44,12 +53,14 @@ var OptionsInfo = fs.Options{{
 // Options contains options for the s3 Server
 type Options struct {
 	//TODO add more options
-	ForcePathStyle bool     `config:"force_path_style"`
-	EtagHash       string   `config:"etag_hash"`
-	AuthKey        []string `config:"auth_key"`
-	NoCleanup      bool     `config:"no_cleanup"`
-	Auth           httplib.AuthConfig
-	HTTP           httplib.Config
+	ForcePathStyle  bool        `config:"force_path_style"`
+	EtagHash        string      `config:"etag_hash"`
+	AuthKey         []string    `config:"auth_key"`
+	NoCleanup       bool        `config:"no_cleanup"`
+	BucketPolicy    []string    `config:"bucket_policy"`
+	MultipartExpiry fs.Duration `config:"multipart_expiry"`
+	Auth            httplib.AuthConfig
+	HTTP            httplib.Config
 }
 
 // Opt is options set by command line flags
@@ -108,7 +119,7 @@ var Command = &cobra.Command{
 	Long:  help() + strings.TrimSpace(httplib.AuthHelp(flagPrefix)+httplib.Help(flagPrefix)+vfs.Help()),
 	RunE: func(command *cobra.Command, args []string) error {
 		var f fs.Fs
-		if proxy.Opt.AuthProxy == "" {
+		if !proxy.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
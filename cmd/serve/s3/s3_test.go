@@ -6,12 +6,15 @@ package s3
 import (
 	"bytes"
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
 	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -297,3 +300,213 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+func TestVersioning(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	require.NoError(t, f.Mkdir(ctx, "bucket"))
+
+	endpoint, keyid, keysec, _ := serveS3(t, f)
+	testURL, _ := url.Parse(endpoint)
+	client, err := minio.New(testURL.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(keyid, keysec, ""),
+		Secure: false,
+	})
+	require.NoError(t, err)
+
+	put := func(key, contents string) string {
+		info, err := client.PutObject(ctx, "bucket", key, bytes.NewBufferString(contents), int64(len(contents)), minio.PutObjectOptions{})
+		require.NoError(t, err)
+		return info.VersionID
+	}
+	get := func(key, versionID string) (string, error) {
+		obj, err := client.GetObject(ctx, "bucket", key, minio.GetObjectOptions{VersionID: versionID})
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = obj.Close() }()
+		data, err := io.ReadAll(obj)
+		return string(data), err
+	}
+	list := func() (versions []minio.ObjectInfo) {
+		for info := range client.ListObjects(ctx, "bucket", minio.ListObjectsOptions{WithVersions: true, Recursive: true}) {
+			require.NoError(t, info.Err)
+			versions = append(versions, info)
+		}
+		return versions
+	}
+
+	// Objects written before versioning is enabled have the null version
+	put("dir/file.txt", "zero")
+	require.NoError(t, client.EnableVersioning(ctx, "bucket"))
+	config, err := client.GetBucketVersioning(ctx, "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, "Enabled", config.Status)
+
+	v1 := put("dir/file.txt", "one")
+	v2 := put("dir/file.txt", "two")
+	assert.NotEmpty(t, v1)
+	assert.NotEqual(t, v1, v2)
+
+	// The version store isn't visible as a bucket
+	buckets, err := client.ListBuckets(ctx)
+	require.NoError(t, err)
+	require.Len(t, buckets, 1)
+	assert.Equal(t, "bucket", buckets[0].Name)
+
+	got, err := get("dir/file.txt", "")
+	require.NoError(t, err)
+	assert.Equal(t, "two", got)
+	got, err = get("dir/file.txt", v1)
+	require.NoError(t, err)
+	assert.Equal(t, "one", got)
+	stat, err := client.StatObject(ctx, "bucket", "dir/file.txt", minio.StatObjectOptions{VersionID: v1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stat.Size)
+	assert.Equal(t, v1, stat.VersionID)
+
+	versions := list()
+	require.Len(t, versions, 3)
+	assert.Equal(t, v2, versions[0].VersionID)
+	assert.True(t, versions[0].IsLatest)
+	assert.Equal(t, v1, versions[1].VersionID)
+	assert.False(t, versions[1].IsLatest)
+	assert.Equal(t, int64(4), versions[2].Size)
+
+	t.Run("DeleteMarker", func(t *testing.T) {
+		require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/file.txt", minio.RemoveObjectOptions{}))
+		_, err := get("dir/file.txt", "")
+		assert.Error(t, err)
+		_, err = os.Stat(filepath.Join(dir, "bucket", "dir", "file.txt"))
+		assert.True(t, os.IsNotExist(err))
+
+		versions := list()
+		require.Len(t, versions, 4)
+		assert.True(t, versions[0].IsDeleteMarker)
+		assert.True(t, versions[0].IsLatest)
+
+		// Removing the delete marker restores the newest version
+		require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/file.txt", minio.RemoveObjectOptions{VersionID: versions[0].VersionID}))
+		got, err := get("dir/file.txt", "")
+		require.NoError(t, err)
+		assert.Equal(t, "two", got)
+	})
+
+	t.Run("DeleteVersion", func(t *testing.T) {
+		require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/file.txt", minio.RemoveObjectOptions{VersionID: v2}))
+		got, err := get("dir/file.txt", "")
+		require.NoError(t, err)
+		assert.Equal(t, "one", got)
+		_, err = get("dir/file.txt", v2)
+		assert.Error(t, err)
+		assert.Len(t, list(), 2)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		const n = 8
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				put("concurrent.txt", fmt.Sprintf("version %d", i))
+			}()
+		}
+		wg.Wait()
+		ids := map[string]struct{}{}
+		for _, v := range list() {
+			if v.Key == "concurrent.txt" {
+				ids[v.VersionID] = struct{}{}
+				got, err := get(v.Key, v.VersionID)
+				require.NoError(t, err)
+				assert.Contains(t, got, "version ")
+			}
+		}
+		assert.Len(t, ids, n)
+		for id := range ids {
+			require.NoError(t, client.RemoveObject(ctx, "bucket", "concurrent.txt", minio.RemoveObjectOptions{VersionID: id}))
+		}
+	})
+
+	t.Run("SpecialKeys", func(t *testing.T) {
+		const key = "dir/a & <b> +c%.txt"
+		v := put(key, "special")
+		found := false
+		for _, info := range list() {
+			if info.Key == key {
+				found = true
+				assert.Equal(t, v, info.VersionID)
+			}
+		}
+		assert.True(t, found, "key not found in listing")
+		require.NoError(t, client.RemoveObject(ctx, "bucket", key, minio.RemoveObjectOptions{VersionID: v}))
+	})
+
+	t.Run("CollidingKeys", func(t *testing.T) {
+		// Keys which look like the version store mustn't mix up
+		// their versions with those of other keys
+		keys := []string{"x", "x.versions/live", "x%versions/live", "x%25versions/y"}
+		for _, key := range keys {
+			put(key, key+" one")
+			put(key, key+" two")
+		}
+		count := map[string]int{}
+		for _, info := range list() {
+			if !slices.Contains(keys, info.Key) {
+				continue
+			}
+			count[info.Key]++
+			got, err := get(info.Key, info.VersionID)
+			require.NoError(t, err)
+			assert.Contains(t, got, info.Key+" ")
+			require.NoError(t, client.RemoveObject(ctx, "bucket", info.Key, minio.RemoveObjectOptions{VersionID: info.VersionID}))
+		}
+		for _, key := range keys {
+			assert.Equal(t, 2, count[key], key)
+		}
+	})
+
+	t.Run("BadVersionID", func(t *testing.T) {
+		_, err := get("dir/file.txt", "../../../etc/passwd")
+		assert.Error(t, err)
+	})
+
+	t.Run("Suspended", func(t *testing.T) {
+		require.NoError(t, client.SuspendVersioning(ctx, "bucket"))
+		assert.Equal(t, "null", put("new.txt", "a"))
+		put("new.txt", "b")
+		got, err := get("new.txt", "")
+		require.NoError(t, err)
+		assert.Equal(t, "b", got)
+		// The null version was replaced rather than kept
+		assert.Len(t, list(), 3)
+	})
+
+	t.Run("DeleteBucket", func(t *testing.T) {
+		assert.Error(t, client.RemoveBucket(ctx, "bucket"))
+		// Removing the null version of new.txt leaves a delete
+		// marker which is removed on the second pass
+		for range 2 {
+			for _, v := range list() {
+				require.NoError(t, client.RemoveObject(ctx, "bucket", v.Key, minio.RemoveObjectOptions{VersionID: v.VersionID}))
+			}
+		}
+		assert.Empty(t, list())
+		require.NoError(t, client.RemoveBucket(ctx, "bucket"))
+		_, err := os.Stat(filepath.Join(dir, versionsDir))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestURLEncodeVersionsList(t *testing.T) {
+	in := xml.Header + `<ListBucketVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><Prefix>a b</Prefix><KeyMarker>a&amp;b</KeyMarker><Version><Key>a &lt;b&gt;/c+d.txt</Key><VersionId>1</VersionId></Version><DeleteMarker><Key>e&amp;f</Key></DeleteMarker><CommonPrefixes><Prefix>g h/</Prefix></CommonPrefixes></ListBucketVersionsResult>`
+	want := xml.Header + `<ListBucketVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><Prefix>a+b</Prefix><KeyMarker>a%26b</KeyMarker><Version><Key>a+%3Cb%3E/c%2Bd.txt</Key><VersionId>1</VersionId></Version><DeleteMarker><Key>e%26f</Key></DeleteMarker><CommonPrefixes><Prefix>g+h/</Prefix></CommonPrefixes><EncodingType>url</EncodingType></ListBucketVersionsResult>`
+	got, err := urlEncodeVersionsList([]byte(in))
+	require.NoError(t, err)
+	assert.Equal(t, want, string(got))
+
+	_, err = urlEncodeVersionsList([]byte("<broken"))
+	assert.Error(t, err)
+}
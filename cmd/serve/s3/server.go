@@ -10,13 +10,16 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rclone/gofakes3"
 	"github.com/rclone/gofakes3/signature"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/hash"
 	httplib "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/vfs"
@@ -27,6 +30,7 @@ type ctxKey int
 
 const (
 	ctxKeyID ctxKey = iota
+	ctxKeyVersionID
 )
 
 // Server is a s3.FileSystem interface
@@ -36,10 +40,15 @@ type Server struct {
 	f            fs.Fs
 	_vfs         *vfs.VFS // don't use directly, use getVFS
 	faker        *gofakes3.GoFakeS3
+	backend      gofakes3.Backend
+	spool        *spool // parts of multipart uploads
 	handler      http.Handler
+	anonHandler  http.Handler // for anonymous requests allowed by policies
+	policies     map[string]bucketPolicy
 	proxy        *proxy.Proxy
 	ctx          context.Context // for global config
 	s3Secret     string
+	authList     map[string]string // users for --auth-home
 	etagHashType hash.Type
 }
 
@@ -75,20 +84,61 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		return nil, fmt.Errorf("parsing auth list failed: %q", err)
 	}
 
+	w.policies, err = parseBucketPolicies(opt.BucketPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if proxyOpt.AuthHome != "" {
+		if len(authList) == 0 {
+			return nil, errors.New("--auth-home needs --auth-key to set the users")
+		}
+		w.authList = authList
+	}
+	if len(w.policies) > 0 {
+		if proxyOpt.Enabled() {
+			return nil, errors.New("--bucket-policy can't be used with --auth-proxy or --auth-home")
+		}
+		if len(opt.AuthKey) == 0 {
+			fs.Logf("serve s3", "--bucket-policy has no effect without --auth-key")
+		}
+	}
+
 	var newLogger logger
-	w.faker = gofakes3.New(
-		newBackend(w),
+	options := []gofakes3.Option{
 		gofakes3.WithHostBucket(!opt.ForcePathStyle),
 		gofakes3.WithLogger(newLogger),
 		gofakes3.WithRequestID(rand.Uint64()),
-		gofakes3.WithoutVersioning(),
-		gofakes3.WithV4Auth(authList),
 		gofakes3.WithIntegrityCheck(true), // Check Content-MD5 if supplied
-	)
+	}
+	if proxyOpt.Enabled() {
+		// The versioning calls aren't passed a context so can't
+		// find the VFS for the user
+		options = append(options, gofakes3.WithoutVersioning())
+	}
+	w.backend = newBackend(w)
+	w.faker = gofakes3.New(w.backend, append(options, gofakes3.WithV4Auth(authList))...)
+	w.spool = newSpool(filepath.Join(config.GetCacheDir(), "serve-s3", "uploads"))
 
 	w.handler = w.faker.Server()
+	if !proxyOpt.Enabled() {
+		w.handler = versionsMiddleware(w.handler)
+	}
+	// gofakes3 checks the signatures if it has any keys which it
+	// always does with the auth proxy
+	w.handler = w.multipartMiddleware(w.handler, len(opt.AuthKey) > 0 || proxyOpt.Enabled())
+	if len(w.policies) > 0 && len(opt.AuthKey) > 0 {
+		// Serve anonymous requests allowed by the policies
+		// with a faker without auth sharing the backend
+		w.anonHandler = versionsMiddleware(gofakes3.New(w.backend, options...).Server())
+		w.anonHandler = w.multipartMiddleware(w.anonHandler, false)
+	}
 
-	if proxy.Opt.AuthProxy != "" {
+	if proxyOpt.AuthHome != "" {
+		w.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
+		// the access keys are the users
+		w.handler = proxyAuthMiddleware(w.handler, w)
+		w.faker.AddAuthKeys(authList)
+	} else if proxyOpt.AuthProxy != "" {
 		w.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// proxy auth middleware
 		w.handler = proxyAuthMiddleware(w.handler, w)
@@ -100,6 +150,7 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 			w.faker.AddAuthKeys(authList)
 		}
 	}
+	w.handler = w.authMiddleware(w.handler)
 
 	w.server, err = httplib.NewServer(ctx,
 		httplib.WithConfig(opt.HTTP),
@@ -134,6 +185,13 @@ func (w *Server) getVFS(ctx context.Context) (VFS *vfs.VFS, err error) {
 
 // auth does proxy authorization
 func (w *Server) auth(accessKeyID string) (value any, err error) {
+	if w.authList != nil {
+		// The signature is checked by gofakes3 afterwards
+		if _, ok := w.authList[accessKeyID]; !ok {
+			return nil, errors.New("unknown access key")
+		}
+		return w.proxy.Home(accessKeyID)
+	}
 	VFS, _, err := w.proxy.Call(stringToMd5Hash(accessKeyID), accessKeyID, false)
 	if err != nil {
 		return nil, err
@@ -148,6 +206,9 @@ func (w *Server) Bind(router chi.Router) {
 
 // Serve serves the s3 server until the server is shutdown
 func (w *Server) Serve() error {
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	go w.spool.expireLoop(ctx, time.Duration(w.opt.MultipartExpiry))
 	w.server.Serve()
 	fs.Logf(w.f, "Starting s3 server on %s", w.server.URLs())
 	w.server.Wait()
@@ -193,6 +254,9 @@ func proxyAuthMiddleware(next http.Handler, ws *Server) http.Handler {
 
 func parseAccessKeyID(r *http.Request) (accessKey string, error signature.ErrorCode) {
 	v4Auth := r.Header.Get("Authorization")
+	if isPresigned(r) {
+		v4Auth = presignedAuth(r.URL.Query())
+	}
 	req, err := signature.ParseSignV4(v4Auth)
 	if err != signature.ErrNone {
 		return "", err
@@ -0,0 +1,952 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs"
+)
+
+// The old versions of objects in versioned buckets are kept in a
+// hidden directory in the root. This can't be confused with a bucket
+// as bucket names can't start with a ".".
+//
+// For each bucket which has had versioning enabled it contains
+//
+//	versionsDir/bucket/versioningFile - the versioning status
+//	versionsDir/bucket/key%versions/liveFile - the version ID of the current object
+//	versionsDir/bucket/key%versions/ID - the data of an old version
+//	versionsDir/bucket/key%versions/ID.json - the versionRecord of an old version or delete marker
+//
+// The "%" in the key is escaped as "%25" so the directory for the
+// versions of a key can't be confused with a directory for the keys
+// under it.
+//
+// Objects being uploaded to versioned buckets are written to
+// versionsDir/uploadsDir first.
+const (
+	versionsDir    = ".rclone-s3-versions"
+	uploadsDir     = ".uploads"
+	versionsSuffix = "%versions"
+	versioningFile = "versioning"
+	liveFile       = "live"
+	recordSuffix   = ".json"
+	versionIDLen   = 16
+)
+
+// versionRecord describes an old version of an object or a delete marker
+type versionRecord struct {
+	DeleteMarker bool              `json:"delete_marker,omitempty"`
+	Time         time.Time         `json:"time,omitempty"`     // when the delete marker was made
+	Metadata     map[string]string `json:"metadata,omitempty"` // metadata of the old version
+}
+
+// storedVersion is an old version or delete marker in the version store
+type storedVersion struct {
+	id     gofakes3.VersionID
+	record versionRecord
+	node   vfs.Node // the data if not a delete marker
+}
+
+// keyLock serialises the changes to the versions of an object
+type keyLock struct {
+	mu    sync.Mutex
+	users int // number of users of the lock
+}
+
+// lockKey locks the versions of objectName in bucket returning a
+// function to unlock them
+func (b *s3Backend) lockKey(bucket, objectName string) (unlock func()) {
+	key := path.Join(bucket, objectName)
+	b.keyMu.Lock()
+	if b.keyLocks == nil {
+		b.keyLocks = map[string]*keyLock{}
+	}
+	l := b.keyLocks[key]
+	if l == nil {
+		l = &keyLock{}
+		b.keyLocks[key] = l
+	}
+	l.users++
+	b.keyMu.Unlock()
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		b.keyMu.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(b.keyLocks, key)
+		}
+		b.keyMu.Unlock()
+	}
+}
+
+// versionsPath returns the directory in the version store for the
+// versions of objectName in bucket
+func versionsPath(bucket, objectName string) string {
+	return path.Join(versionsDir, bucket, strings.ReplaceAll(objectName, "%", "%25")+versionsSuffix)
+}
+
+// isVersionID returns true if id looks like a version ID we made
+func isVersionID(id gofakes3.VersionID) bool {
+	if len(id) != versionIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newVersionID makes a new version ID
+//
+// These are made from the time so they sort in the order they were
+// made.
+//
+// Call with b.versionMu held
+func (b *s3Backend) newVersionID() gofakes3.VersionID {
+	t := time.Now().UnixNano()
+	if t <= b.lastVersion {
+		t = b.lastVersion + 1
+	}
+	b.lastVersion = t
+	return gofakes3.VersionID(fmt.Sprintf("%0*x", versionIDLen, t))
+}
+
+// versionedVFS returns the VFS for the gofakes3.VersionedBackend
+// methods.
+//
+// These aren't passed a context so versioning isn't available with
+// --auth-proxy.
+func (b *s3Backend) versionedVFS() (*vfs.VFS, error) {
+	return b.s.getVFS(b.s.ctx)
+}
+
+// versioning returns the versioning status of bucket or "" if it has
+// never been enabled
+func (b *s3Backend) versioning(_vfs *vfs.VFS, bucket string) gofakes3.VersioningStatus {
+	data, err := _vfs.ReadFile(path.Join(versionsDir, bucket, versioningFile))
+	if err != nil {
+		return ""
+	}
+	return gofakes3.VersioningStatus(strings.TrimSpace(string(data)))
+}
+
+// liveVersionID returns the version ID of the current object or "" if
+// it doesn't have one
+func (b *s3Backend) liveVersionID(_vfs *vfs.VFS, bucket, objectName string) gofakes3.VersionID {
+	data, err := _vfs.ReadFile(path.Join(versionsPath(bucket, objectName), liveFile))
+	if err != nil {
+		return ""
+	}
+	id := gofakes3.VersionID(strings.TrimSpace(string(data)))
+	if !isVersionID(id) {
+		return ""
+	}
+	return id
+}
+
+// currentVersionID returns the version ID to report for the current
+// object
+func (b *s3Backend) currentVersionID(_vfs *vfs.VFS, bucket, objectName string) gofakes3.VersionID {
+	if b.versioning(_vfs, bucket) == "" {
+		return ""
+	}
+	if id := b.liveVersionID(_vfs, bucket, objectName); id != "" {
+		return id
+	}
+	return "null"
+}
+
+// setLiveVersionID records the version ID of the current object,
+// removing the record if id is ""
+func (b *s3Backend) setLiveVersionID(_vfs *vfs.VFS, bucket, objectName string, id gofakes3.VersionID) error {
+	dir := versionsPath(bucket, objectName)
+	livePath := path.Join(dir, liveFile)
+	if id == "" {
+		err := _vfs.Remove(livePath)
+		if err == vfs.ENOENT {
+			err = nil
+		}
+		b.removeEmptyVersionsDir(_vfs, bucket, dir)
+		return err
+	}
+	err := mkdirRecursive(dir, _vfs)
+	if err != nil {
+		return err
+	}
+	return _vfs.WriteFile(livePath, []byte(id), 0666)
+}
+
+// readRecord reads the versionRecord for id in dir
+func readRecord(_vfs *vfs.VFS, dir string, id gofakes3.VersionID) (record versionRecord, err error) {
+	data, err := _vfs.ReadFile(path.Join(dir, string(id)+recordSuffix))
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// writeRecord writes the versionRecord for id in dir
+func writeRecord(_vfs *vfs.VFS, dir string, id gofakes3.VersionID, record versionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = mkdirRecursive(dir, _vfs)
+	if err != nil {
+		return err
+	}
+	return _vfs.WriteFile(path.Join(dir, string(id)+recordSuffix), data, 0666)
+}
+
+// readVersions reads the version store directory dir returning the
+// version ID of the current object and the old versions newest first.
+func readVersions(_vfs *vfs.VFS, dir string) (live gofakes3.VersionID, versions []storedVersion, err error) {
+	entries, err := _vfs.ReadDir(dir)
+	if err == vfs.ENOENT {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if name == liveFile {
+			data, err := _vfs.ReadFile(path.Join(dir, name))
+			if err == nil && isVersionID(gofakes3.VersionID(data)) {
+				live = gofakes3.VersionID(data)
+			}
+			continue
+		}
+		id := gofakes3.VersionID(strings.TrimSuffix(name, recordSuffix))
+		if string(id) == name || !isVersionID(id) {
+			continue
+		}
+		v := storedVersion{id: id}
+		v.record, err = readRecord(_vfs, dir, id)
+		if err != nil {
+			fs.Errorf(path.Join(dir, name), "serve s3: ignoring bad version record: %v", err)
+			continue
+		}
+		if !v.record.DeleteMarker {
+			v.node, err = _vfs.Stat(path.Join(dir, string(id)))
+			if err != nil {
+				fs.Errorf(path.Join(dir, name), "serve s3: ignoring version with missing data: %v", err)
+				continue
+			}
+		}
+		versions = append(versions, v)
+	}
+	slices.SortFunc(versions, func(a, b storedVersion) int {
+		return strings.Compare(string(b.id), string(a.id))
+	})
+	return live, versions, nil
+}
+
+// removeEmptyVersionsDir removes the version store directory dir for
+// an object in bucket and its parents if they are empty
+func (b *s3Backend) removeEmptyVersionsDir(_vfs *vfs.VFS, bucket, dir string) {
+	// Stop at versionsDir/bucket which holds the versioning file
+	root := path.Join(versionsDir, bucket)
+	for ; dir != root && strings.HasPrefix(dir, root+"/"); dir = path.Dir(dir) {
+		entries, err := _vfs.ReadDir(dir)
+		if err != nil || len(entries) != 0 || _vfs.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// metaLastModified returns the upload time recorded in the metadata
+// of an object if set.
+//
+// This is used in version listings rather than the modification time
+// as it is what HeadObject returns and what clients use to order the
+// versions.
+func metaLastModified(meta map[string]string) (t time.Time, ok bool) {
+	t, err := http.ParseTime(meta["Last-Modified"])
+	if err != nil {
+		return t, false
+	}
+	return t, true
+}
+
+// keepCurrentVersion moves the current object into the version store
+// before it is replaced or deleted.
+//
+// When versioning is suspended an object without a version ID is
+// replaced rather than kept, as S3 does.
+func (b *s3Backend) keepCurrentVersion(_vfs *vfs.VFS, bucket, objectName string, status gofakes3.VersioningStatus) error {
+	b.versionMu.Lock()
+	defer b.versionMu.Unlock()
+	fp := path.Join(bucket, objectName)
+	node, err := _vfs.Stat(fp)
+	if err == vfs.ENOENT || (err == nil && !node.IsFile()) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	id := b.liveVersionID(_vfs, bucket, objectName)
+	if id == "" {
+		if status != gofakes3.VersioningEnabled {
+			return nil
+		}
+		// Give the object a version ID of its own now it is kept
+		id = b.newVersionID()
+	}
+	dir := versionsPath(bucket, objectName)
+	err = writeRecord(_vfs, dir, id, versionRecord{Metadata: b.loadMeta(fp)})
+	if err != nil {
+		return fmt.Errorf("failed to keep old version: %w", err)
+	}
+	err = _vfs.Rename(fp, path.Join(dir, string(id)))
+	if err != nil {
+		_ = _vfs.Remove(path.Join(dir, string(id)+recordSuffix))
+		return fmt.Errorf("failed to keep old version: %w", err)
+	}
+	b.meta.Delete(fp)
+	fs.Debugf(fp, "serve s3: kept old version %s", id)
+	return b.setLiveVersionID(_vfs, bucket, objectName, "")
+}
+
+// putObjectVersion writes the object in a versioned bucket.
+//
+// The data is uploaded to a temporary name first so the current
+// object stays readable while it uploads. The current object is then
+// kept as an old version and the new one renamed into its place with
+// the object locked.
+func (b *s3Backend) putObjectVersion(
+	_vfs *vfs.VFS,
+	bucketName, objectName string,
+	meta map[string]string,
+	input io.Reader,
+	status gofakes3.VersioningStatus,
+) (result gofakes3.PutObjectResult, err error) {
+	fp := path.Join(bucketName, objectName)
+	uploads := path.Join(versionsDir, uploadsDir)
+	err = mkdirRecursive(uploads, _vfs)
+	if err != nil {
+		return result, err
+	}
+	tmp := path.Join(uploads, random.String(16))
+	err = writeObjectData(_vfs, tmp, input)
+	if err != nil {
+		return result, err
+	}
+
+	defer b.lockKey(bucketName, objectName)()
+	err = b.keepCurrentVersion(_vfs, bucketName, objectName, status)
+	if err == nil && path.Dir(fp) != "." {
+		err = mkdirRecursive(path.Dir(fp), _vfs)
+	}
+	if err == nil {
+		err = _vfs.Rename(tmp, fp)
+	}
+	if err != nil {
+		_ = _vfs.Remove(tmp)
+		b.restoreLatestVersion(_vfs, bucketName, objectName)
+		return result, err
+	}
+	err = b.setObjectMeta(_vfs, fp, meta)
+	if err != nil {
+		return result, err
+	}
+	result.VersionID, err = b.newCurrentVersion(_vfs, bucketName, objectName, status)
+	return result, err
+}
+
+// newCurrentVersion gives the object just written a new version ID
+// if versioning is enabled, returning the ID
+func (b *s3Backend) newCurrentVersion(_vfs *vfs.VFS, bucket, objectName string, status gofakes3.VersioningStatus) (id gofakes3.VersionID, err error) {
+	b.versionMu.Lock()
+	defer b.versionMu.Unlock()
+	if status != gofakes3.VersioningEnabled {
+		return "null", nil
+	}
+	id = b.newVersionID()
+	return id, b.setLiveVersionID(_vfs, bucket, objectName, id)
+}
+
+// addDeleteMarker adds a delete marker for the object returning its ID
+//
+// When versioning is suspended no delete marker is added if there are
+// no old versions for it to hide and "" is returned.
+func (b *s3Backend) addDeleteMarker(_vfs *vfs.VFS, bucket, objectName string, status gofakes3.VersioningStatus) (id gofakes3.VersionID, err error) {
+	b.versionMu.Lock()
+	defer b.versionMu.Unlock()
+	dir := versionsPath(bucket, objectName)
+	if status != gofakes3.VersioningEnabled {
+		_, versions, err := readVersions(_vfs, dir)
+		if err != nil {
+			return "", err
+		}
+		if len(versions) == 0 {
+			return "", nil
+		}
+	}
+	id = b.newVersionID()
+	err = writeRecord(_vfs, dir, id, versionRecord{
+		DeleteMarker: true,
+		Time:         time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to add delete marker: %w", err)
+	}
+	return id, nil
+}
+
+// restoreLatestVersion makes the newest old version of the object the
+// current object if there isn't a current object and the newest
+// version isn't a delete marker.
+func (b *s3Backend) restoreLatestVersion(_vfs *vfs.VFS, bucket, objectName string) {
+	b.versionMu.Lock()
+	defer b.versionMu.Unlock()
+	fp := path.Join(bucket, objectName)
+	if _, err := _vfs.Stat(fp); err == nil {
+		return
+	}
+	dir := versionsPath(bucket, objectName)
+	_, versions, err := readVersions(_vfs, dir)
+	if err != nil || len(versions) == 0 || versions[0].record.DeleteMarker {
+		b.removeEmptyVersionsDir(_vfs, bucket, dir)
+		return
+	}
+	latest := versions[0]
+	err = mkdirRecursive(path.Dir(fp), _vfs)
+	if err == nil {
+		err = _vfs.Rename(path.Join(dir, string(latest.id)), fp)
+	}
+	if err != nil {
+		fs.Errorf(fp, "serve s3: failed to restore version %s: %v", latest.id, err)
+		return
+	}
+	_ = _vfs.Remove(path.Join(dir, string(latest.id)+recordSuffix))
+	if latest.record.Metadata != nil {
+		b.meta.Store(fp, latest.record.Metadata)
+	}
+	err = b.setLiveVersionID(_vfs, bucket, objectName, latest.id)
+	if err != nil {
+		fs.Errorf(fp, "serve s3: failed to set version %s: %v", latest.id, err)
+	}
+	fs.Debugf(fp, "serve s3: restored version %s", latest.id)
+}
+
+// notFound returns the delete marker for the object if it is the
+// newest version or a not found error
+func (b *s3Backend) notFound(_vfs *vfs.VFS, bucket, objectName string) (*gofakes3.Object, error) {
+	if b.versioning(_vfs, bucket) != "" {
+		_, versions, err := readVersions(_vfs, versionsPath(bucket, objectName))
+		if err == nil && len(versions) > 0 && versions[0].record.DeleteMarker {
+			return &gofakes3.Object{
+				Name:           objectName,
+				VersionID:      versions[0].id,
+				IsDeleteMarker: true,
+				Contents:       noOpReadCloser{},
+			}, nil
+		}
+	}
+	return nil, gofakes3.KeyNotFound(objectName)
+}
+
+// hasVersions returns true if there are any old versions or delete
+// markers in bucket
+func (b *s3Backend) hasVersions(_vfs *vfs.VFS, bucket string) bool {
+	entries, err := _vfs.ReadDir(path.Join(versionsDir, bucket))
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// removeVersionStore removes the version store for bucket when it is
+// deleted
+func (b *s3Backend) removeVersionStore(_vfs *vfs.VFS, bucket string) {
+	dir := path.Join(versionsDir, bucket)
+	_ = _vfs.Remove(path.Join(dir, versioningFile))
+	_ = _vfs.Remove(dir)
+	_ = _vfs.Remove(path.Join(versionsDir, uploadsDir))
+	if entries, err := _vfs.ReadDir(versionsDir); err == nil && len(entries) == 0 {
+		_ = _vfs.Remove(versionsDir)
+	}
+}
+
+// VersioningConfiguration returns the versioning status of the bucket.
+func (b *s3Backend) VersioningConfiguration(bucket string) (config gofakes3.VersioningConfiguration, err error) {
+	_vfs, err := b.versionedVFS()
+	if err != nil {
+		return config, err
+	}
+	if _, err = _vfs.Stat(bucket); err != nil {
+		return config, gofakes3.BucketNotFound(bucket)
+	}
+	config.Status = b.versioning(_vfs, bucket)
+	return config, nil
+}
+
+// SetVersioningConfiguration enables or suspends versioning on the
+// bucket.
+func (b *s3Backend) SetVersioningConfiguration(bucket string, v gofakes3.VersioningConfiguration) error {
+	_vfs, err := b.versionedVFS()
+	if err != nil {
+		return err
+	}
+	if _, err = _vfs.Stat(bucket); err != nil {
+		return gofakes3.BucketNotFound(bucket)
+	}
+	if v.MFADelete.Enabled() {
+		return gofakes3.ErrNotImplemented
+	}
+	switch v.Status {
+	case gofakes3.VersioningEnabled, gofakes3.VersioningSuspended:
+	case "":
+		// Versioning can't be turned off once enabled
+		return nil
+	default:
+		return gofakes3.ErrorMessage(gofakes3.ErrIllegalVersioningConfiguration, "unknown versioning status")
+	}
+	dir := path.Join(versionsDir, bucket)
+	if err = mkdirRecursive(dir, _vfs); err != nil {
+		return err
+	}
+	fs.Infof(bucket, "serve s3: versioning %s", v.Status)
+	return _vfs.WriteFile(path.Join(dir, versioningFile), []byte(v.Status), 0666)
+}
+
+// objectVersion finds the version id of the object returning the node
+// with its data or the record of a delete marker
+func (b *s3Backend) objectVersion(_vfs *vfs.VFS, bucket, objectName string, id gofakes3.VersionID) (node vfs.Node, meta map[string]string, isDeleteMarker bool, err error) {
+	if _, err = _vfs.Stat(bucket); err != nil {
+		return nil, nil, false, gofakes3.BucketNotFound(bucket)
+	}
+	if !isVersionID(id) {
+		return nil, nil, false, gofakes3.ErrNoSuchVersion
+	}
+	fp := path.Join(bucket, objectName)
+	if b.liveVersionID(_vfs, bucket, objectName) == id {
+		node, err = _vfs.Stat(fp)
+		if err != nil || !node.IsFile() {
+			return nil, nil, false, gofakes3.ErrNoSuchVersion
+		}
+		return node, b.loadMeta(fp), false, nil
+	}
+	dir := versionsPath(bucket, objectName)
+	record, err := readRecord(_vfs, dir, id)
+	if err != nil {
+		return nil, nil, false, gofakes3.ErrNoSuchVersion
+	}
+	if record.DeleteMarker {
+		return nil, nil, true, nil
+	}
+	node, err = _vfs.Stat(path.Join(dir, string(id)))
+	if err != nil {
+		return nil, nil, false, gofakes3.ErrNoSuchVersion
+	}
+	return node, record.Metadata, false, nil
+}
+
+// GetObjectVersion fetches a version of the object.
+func (b *s3Backend) GetObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	_vfs, err := b.versionedVFS()
+	if err != nil {
+		return nil, err
+	}
+	node, meta, isDeleteMarker, err := b.objectVersion(_vfs, bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if isDeleteMarker {
+		return &gofakes3.Object{Name: objectName, VersionID: versionID, IsDeleteMarker: true, Contents: noOpReadCloser{}}, nil
+	}
+	obj, err := b.readNode(node, objectName, meta, rangeRequest)
+	if err != nil {
+		return nil, err
+	}
+	obj.VersionID = versionID
+	return obj, nil
+}
+
+// HeadObjectVersion fetches the info for a version of the object.
+func (b *s3Backend) HeadObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.Object, error) {
+	_vfs, err := b.versionedVFS()
+	if err != nil {
+		return nil, err
+	}
+	return b.headObjectVersion(_vfs, bucketName, objectName, versionID)
+}
+
+// headObjectVersion fetches the info for a version of the object from _vfs.
+func (b *s3Backend) headObjectVersion(_vfs *vfs.VFS, bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.Object, error) {
+	node, meta, isDeleteMarker, err := b.objectVersion(_vfs, bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if isDeleteMarker {
+		return &gofakes3.Object{Name: objectName, VersionID: versionID, IsDeleteMarker: true, Contents: noOpReadCloser{}}, nil
+	}
+	obj, err := b.headNode(node, objectName, meta)
+	if err != nil {
+		return nil, err
+	}
+	obj.VersionID = versionID
+	return obj, nil
+}
+
+// DeleteObjectVersion permanently deletes a version of the object.
+//
+// If this was the newest version then the next newest becomes the
+// current object unless it is a delete marker.
+func (b *s3Backend) DeleteObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID) (result gofakes3.ObjectDeleteResult, err error) {
+	_vfs, err := b.versionedVFS()
+	if err != nil {
+		return result, err
+	}
+	if _, err = _vfs.Stat(bucketName); err != nil {
+		return result, gofakes3.BucketNotFound(bucketName)
+	}
+	if !isVersionID(versionID) {
+		return result, nil
+	}
+	fp := path.Join(bucketName, objectName)
+	dir := versionsPath(bucketName, objectName)
+	defer b.lockKey(bucketName, objectName)()
+
+	b.versionMu.Lock()
+	if b.liveVersionID(_vfs, bucketName, objectName) == versionID {
+		err = _vfs.Remove(fp)
+		if err == nil {
+			b.meta.Delete(fp)
+			err = b.setLiveVersionID(_vfs, bucketName, objectName, "")
+		}
+	} else {
+		var record versionRecord
+		record, err = readRecord(_vfs, dir, versionID)
+		if err != nil {
+			// S3 doesn't report an error for a missing version
+			b.versionMu.Unlock()
+			return result, nil
+		}
+		result.IsDeleteMarker = record.DeleteMarker
+		if !record.DeleteMarker {
+			err = _vfs.Remove(path.Join(dir, string(versionID)))
+		}
+		if err == nil {
+			err = _vfs.Remove(path.Join(dir, string(versionID)+recordSuffix))
+		}
+	}
+	b.versionMu.Unlock()
+	if err != nil {
+		return result, err
+	}
+	result.VersionID = versionID
+
+	b.restoreLatestVersion(_vfs, bucketName, objectName)
+	if _, err := _vfs.Stat(fp); err != nil && !b.s.opt.NoCleanup {
+		rmdirRecursive(fp, _vfs)
+	}
+	return result, nil
+}
+
+// ListBucketVersions lists the current objects, old versions and
+// delete markers in the bucket.
+func (b *s3Backend) ListBucketVersions(bucketName string, prefix *gofakes3.Prefix, page *gofakes3.ListBucketVersionsPage) (*gofakes3.ListBucketVersionsResult, error) {
+	_vfs, err := b.versionedVFS()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = _vfs.Stat(bucketName); err != nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	var p gofakes3.Prefix
+	if prefix != nil {
+		p = *prefix
+	}
+	// workaround as in ListBucket
+	if strings.TrimSpace(p.Prefix) == "" {
+		p.HasPrefix = false
+	}
+	if strings.TrimSpace(p.Delimiter) == "" {
+		p.HasDelimiter = false
+	}
+	if page == nil {
+		page = &gofakes3.ListBucketVersionsPage{}
+	}
+	maxKeys := page.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+
+	// Find the versions of each key, newest first
+	versions := map[string][]gofakes3.VersionItem{}
+	current := gofakes3.NewObjectList()
+	err = b.entryListR(_vfs, bucketName, "", "", false, current)
+	if err != nil && err != gofakes3.ErrNoSuchKey {
+		return nil, err
+	}
+	for _, item := range current.Contents {
+		lastModified := item.LastModified
+		if t, ok := metaLastModified(b.loadMeta(path.Join(bucketName, item.Key))); ok {
+			lastModified = gofakes3.NewContentTime(t)
+		}
+		versions[item.Key] = []gofakes3.VersionItem{&gofakes3.Version{
+			Key:          item.Key,
+			LastModified: lastModified,
+			Size:         item.Size,
+			ETag:         item.ETag,
+			StorageClass: gofakes3.StorageStandard,
+		}}
+	}
+	err = b.walkVersions(_vfs, path.Join(versionsDir, bucketName), "", func(key string, live gofakes3.VersionID, stored []storedVersion) {
+		if items := versions[key]; len(items) > 0 {
+			items[0].(*gofakes3.Version).VersionID = live
+		}
+		for _, v := range stored {
+			if v.record.DeleteMarker {
+				versions[key] = append(versions[key], &gofakes3.DeleteMarker{
+					Key:          key,
+					VersionID:    v.id,
+					LastModified: gofakes3.NewContentTime(v.record.Time),
+				})
+			} else {
+				lastModified, ok := metaLastModified(v.record.Metadata)
+				if !ok {
+					lastModified = v.node.ModTime()
+				}
+				versions[key] = append(versions[key], &gofakes3.Version{
+					Key:          key,
+					VersionID:    v.id,
+					LastModified: gofakes3.NewContentTime(lastModified),
+					Size:         v.node.Size(),
+					ETag:         getFileHash(v.node, b.s.etagHashType),
+					StorageClass: gofakes3.StorageStandard,
+				})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	result := gofakes3.NewListBucketVersionsResult(bucketName, &p, page)
+	result.MaxKeys = maxKeys
+	var (
+		match        gofakes3.PrefixMatch
+		lastKey      string
+		lastID       gofakes3.VersionID
+		passedMarker = !page.HasVersionIDMarker
+	)
+	for _, key := range keys {
+		if !p.Match(key, &match) {
+			continue
+		}
+		if page.HasKeyMarker && key < page.KeyMarker {
+			continue
+		}
+		if match.CommonPrefix {
+			if !page.HasKeyMarker || match.MatchedPart > page.KeyMarker {
+				result.AddPrefix(match.MatchedPart)
+			}
+			continue
+		}
+		for i, item := range versions[key] {
+			id := item.GetVersionID()
+			if id == "" {
+				id = "null"
+			}
+			if page.HasKeyMarker && key == page.KeyMarker {
+				if !passedMarker {
+					passedMarker = id == page.VersionIDMarker
+					continue
+				}
+				if !page.HasVersionIDMarker {
+					continue
+				}
+			}
+			if int64(len(result.Versions)) >= maxKeys {
+				result.IsTruncated = true
+				result.NextKeyMarker = lastKey
+				result.NextVersionIDMarker = lastID
+				return result, nil
+			}
+			switch v := item.(type) {
+			case *gofakes3.Version:
+				v.IsLatest = i == 0
+			case *gofakes3.DeleteMarker:
+				v.IsLatest = i == 0
+			}
+			result.Versions = append(result.Versions, item)
+			lastKey, lastID = key, id
+		}
+	}
+	return result, nil
+}
+
+// walkVersions calls fn for each object with versions in the version
+// store directory root under dir
+func (b *s3Backend) walkVersions(_vfs *vfs.VFS, root, dir string, fn func(key string, live gofakes3.VersionID, versions []storedVersion)) error {
+	entries, err := _vfs.ReadDir(path.Join(root, dir))
+	if err == vfs.ENOENT {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+		if key, found := strings.CutSuffix(name, versionsSuffix); found {
+			live, versions, err := readVersions(_vfs, path.Join(root, name))
+			if err != nil {
+				return err
+			}
+			fn(strings.ReplaceAll(key, "%25", "%"), live, versions)
+			continue
+		}
+		err = b.walkVersions(_vfs, root, name, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionsListKeys are the elements in a ListObjectVersions response
+// which hold keys
+var versionsListKeys = map[string]bool{
+	"Key":           true,
+	"Prefix":        true,
+	"KeyMarker":     true,
+	"NextKeyMarker": true,
+}
+
+// urlEncodeVersionsList URL encodes the keys in a ListObjectVersions
+// response and adds the EncodingType so clients know to decode them
+func urlEncodeVersionsList(in []byte) ([]byte, error) {
+	var out bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(in))
+	enc := xml.NewEncoder(&out)
+	inKey := false
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			// The namespace is kept in the xmlns attribute
+			t.Name.Space = ""
+			inKey = versionsListKeys[t.Name.Local]
+			depth++
+			tok = t
+		case xml.EndElement:
+			t.Name.Space = ""
+			inKey = false
+			depth--
+			if depth == 0 {
+				encodingType := xml.StartElement{Name: xml.Name{Local: "EncodingType"}}
+				err = enc.EncodeElement("url", encodingType)
+				if err != nil {
+					return nil, err
+				}
+			}
+			tok = t
+		case xml.CharData:
+			if inKey {
+				tok = xml.CharData(gofakes3.URLEncode(string(t)))
+			}
+		}
+		err = enc.EncodeToken(tok)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// bufferedResponse holds a response so it can be rewritten
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header         { return r.header }
+func (r *bufferedResponse) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *bufferedResponse) WriteHeader(status int)      { r.status = status }
+
+// versionsMiddleware fills in parts of the versioning API which
+// gofakes3 doesn't pass on to the backend.
+//
+// HeadObject is passed the versionId in the context as gofakes3
+// ignores it.
+//
+// ListObjectVersions responses have their keys URL encoded when the
+// client asks for encoding-type=url which the rclone s3 backend always
+// does. gofakes3 only supports this for ListObjects.
+func versionsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Method == http.MethodHead {
+			if versionID := q.Get("versionId"); versionID != "" && versionID != "null" {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyVersionID, gofakes3.VersionID(versionID)))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := q["versions"]; !ok || r.Method != http.MethodGet || q.Get("encoding-type") != "url" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+		body := buf.body.Bytes()
+		if buf.status == http.StatusOK {
+			encoded, err := urlEncodeVersionsList(body)
+			if err != nil {
+				fs.Errorf("serve s3", "failed to encode keys in version listing: %v", err)
+				http.Error(w, "failed to encode keys", http.StatusInternalServerError)
+				return
+			}
+			body = encoded
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(buf.status)
+		_, _ = w.Write(body)
+	})
+}
@@ -52,7 +52,7 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		opt:     *opt,
 		stopped: make(chan struct{}),
 	}
-	if proxy.Opt.AuthProxy != "" {
+	if proxy.Opt.Enabled() {
 		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 	} else {
 		s.vfs = vfs.New(ctx, f, vfsOpt)
@@ -140,12 +140,12 @@ func (s *server) configure() (err error) {
 	var authorizedKeysMap map[string]struct{}
 
 	// ensure the user isn't trying to use conflicting flags
-	if proxy.Opt.AuthProxy != "" && s.opt.AuthorizedKeys != "" && s.opt.AuthorizedKeys != Opt.AuthorizedKeys {
-		return errors.New("--auth-proxy and --authorized-keys cannot be used at the same time")
+	if proxy.Opt.Enabled() && s.opt.AuthorizedKeys != "" && s.opt.AuthorizedKeys != Opt.AuthorizedKeys {
+		return errors.New("--authorized-keys cannot be used with --auth-proxy or --auth-home")
 	}
 
 	// Load the authorized keys
-	if s.opt.AuthorizedKeys != "" && proxy.Opt.AuthProxy == "" {
+	if s.opt.AuthorizedKeys != "" && !proxy.Opt.Enabled() {
 		authKeysFile := env.ShellExpand(s.opt.AuthorizedKeys)
 		authorizedKeysMap, err = loadAuthorizedKeys(authKeysFile)
 		// If user set the flag away from the default then report an error
@@ -0,0 +1,579 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/crypto/md4" //nolint:staticcheck // NTLM needs MD4
+)
+
+// DER encoded object identifiers
+var (
+	oidSPNEGO  = []byte{0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLMSSP = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+// SPNEGO negotiation states
+const (
+	negStateAcceptCompleted  = 0
+	negStateAcceptIncomplete = 1
+)
+
+// derTLV DER encodes the contents with the tag
+func derTLV(tag byte, contents ...[]byte) []byte {
+	n := 0
+	for _, c := range contents {
+		n += len(c)
+	}
+	out := []byte{tag}
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	case n < 0x10000:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range contents {
+		out = append(out, c...)
+	}
+	return out
+}
+
+var errBadDER = errors.New("invalid DER encoding")
+
+// derRead reads one DER TLV from the start of buf
+func derRead(buf []byte) (tag byte, content, rest []byte, err error) {
+	if len(buf) < 2 {
+		return 0, nil, nil, errBadDER
+	}
+	tag = buf[0]
+	n := int(buf[1])
+	buf = buf[2:]
+	if n >= 0x80 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(buf) < size {
+			return 0, nil, nil, errBadDER
+		}
+		n = 0
+		for _, b := range buf[:size] {
+			n = n<<8 | int(b)
+		}
+		buf = buf[size:]
+	}
+	if n > len(buf) {
+		return 0, nil, nil, errBadDER
+	}
+	return tag, buf[:n], buf[n:], nil
+}
+
+// spnegoHint returns the NegTokenInit the server sends in the
+// NEGOTIATE response advertising NTLMSSP
+func spnegoHint() []byte {
+	return derTLV(0x60,
+		derTLV(0x06, oidSPNEGO),
+		derTLV(0xa0,
+			derTLV(0x30,
+				derTLV(0xa0,
+					derTLV(0x30, derTLV(0x06, oidNTLMSSP))))))
+}
+
+// negToken is a decoded SPNEGO NegTokenInit or NegTokenResp
+type negToken struct {
+	mechTypes []byte   // DER encoded mechTypes as sent by the client
+	mechs     [][]byte // the mechanism OIDs in mechTypes
+	mechToken []byte
+	mechMIC   []byte
+}
+
+// parseNegToken decodes the NegTokenInit or NegTokenResp in buf
+func parseNegToken(buf []byte) (t *negToken, err error) {
+	tag, content, _, err := derRead(buf)
+	if err != nil {
+		return nil, err
+	}
+	if tag == 0x60 {
+		// InitialContextToken with the SPNEGO OID
+		var oid []byte
+		tag, oid, content, err = derRead(content)
+		if err != nil {
+			return nil, err
+		}
+		if tag != 0x06 || !bytes.Equal(oid, oidSPNEGO) {
+			return nil, errors.New("not an SPNEGO token")
+		}
+		tag, content, _, err = derRead(content)
+		if err != nil {
+			return nil, err
+		}
+		if tag != 0xa0 {
+			return nil, errors.New("expecting NegTokenInit")
+		}
+	} else if tag != 0xa1 {
+		return nil, errors.New("unknown SPNEGO token")
+	}
+	// The NegTokenInit or NegTokenResp sequence
+	tag, content, _, err = derRead(content)
+	if err != nil {
+		return nil, err
+	}
+	if tag != 0x30 {
+		return nil, errBadDER
+	}
+	t = &negToken{}
+	for len(content) > 0 {
+		var field, inner []byte
+		tag, field, content, err = derRead(content)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case 0xa0:
+			// mechTypes in NegTokenInit, negState in NegTokenResp
+			var list []byte
+			tag, list, _, err = derRead(field)
+			if err != nil {
+				return nil, err
+			}
+			if tag != 0x30 {
+				continue
+			}
+			t.mechTypes = field
+			for len(list) > 0 {
+				var oid []byte
+				tag, oid, list, err = derRead(list)
+				if err != nil {
+					return nil, err
+				}
+				if tag == 0x06 {
+					t.mechs = append(t.mechs, oid)
+				}
+			}
+		case 0xa2:
+			_, inner, _, err = derRead(field)
+			if err != nil {
+				return nil, err
+			}
+			t.mechToken = inner
+		case 0xa3:
+			// mechListMIC in NegTokenResp, or negHints in NegTokenInit2
+			tag, inner, _, err = derRead(field)
+			if err != nil {
+				return nil, err
+			}
+			if tag == 0x04 {
+				t.mechMIC = inner
+			}
+		}
+	}
+	return t, nil
+}
+
+// encodeNegTokenResp encodes a NegTokenResp. token and mic may be nil.
+func encodeNegTokenResp(state int, supportedMech bool, token, mic []byte) []byte {
+	var fields [][]byte
+	fields = append(fields, derTLV(0xa0, derTLV(0x0a, []byte{byte(state)})))
+	if supportedMech {
+		fields = append(fields, derTLV(0xa1, derTLV(0x06, oidNTLMSSP)))
+	}
+	if token != nil {
+		fields = append(fields, derTLV(0xa2, derTLV(0x04, token)))
+	}
+	if mic != nil {
+		fields = append(fields, derTLV(0xa3, derTLV(0x04, mic)))
+	}
+	return derTLV(0xa1, derTLV(0x30, fields...))
+}
+
+// NTLM message types and flags
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+
+	ntlmFlagUnicode          = 0x00000001
+	ntlmFlagRequestTarget    = 0x00000004
+	ntlmFlagSign             = 0x00000010
+	ntlmFlagNTLM             = 0x00000200
+	ntlmFlagAlwaysSign       = 0x00008000
+	ntlmFlagTargetTypeServer = 0x00020000
+	ntlmFlagExtendedSecurity = 0x00080000
+	ntlmFlagTargetInfo       = 0x00800000
+	ntlmFlagVersion          = 0x02000000
+	ntlmFlag128              = 0x20000000
+	ntlmFlagKeyExch          = 0x40000000
+	ntlmFlag56               = 0x80000000
+
+	// flags we agree to if the client asks for them
+	ntlmSupportedFlags = ntlmFlagUnicode | ntlmFlagRequestTarget | ntlmFlagSign |
+		ntlmFlagNTLM | ntlmFlagAlwaysSign | ntlmFlagExtendedSecurity |
+		ntlmFlagTargetInfo | ntlmFlagVersion | ntlmFlag128 | ntlmFlagKeyExch | ntlmFlag56
+)
+
+// AV pair IDs
+const (
+	avEOL             = 0
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDNSComputerName = 3
+	avDNSDomainName   = 4
+	avFlags           = 6
+	avTimestamp       = 7
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmVersion is the version structure we send - Windows 10 and NTLM revision 15
+var ntlmVersion = []byte{10, 0, 0, 0, 0, 0, 0, 15}
+
+// serverNames returns the NetBIOS and DNS names of this host
+func serverNames() (nbName, dnsName string) {
+	dnsName, err := os.Hostname()
+	if err != nil || dnsName == "" {
+		dnsName = "rclone"
+	}
+	nbName, _, _ = strings.Cut(dnsName, ".")
+	nbName = strings.ToUpper(nbName)
+	if len(nbName) > 15 {
+		nbName = nbName[:15]
+	}
+	return nbName, strings.ToLower(dnsName)
+}
+
+// ntlmServer is the server side of an NTLMv2 authentication
+type ntlmServer struct {
+	negotiate       []byte // the client's NEGOTIATE message
+	challenge       []byte // the server's CHALLENGE message
+	serverChallenge []byte
+}
+
+// challengeMessage returns the CHALLENGE message in reply to the
+// client's NEGOTIATE message
+func (n *ntlmServer) challengeMessage(negotiate []byte) ([]byte, error) {
+	if len(negotiate) < 16 {
+		return nil, errors.New("NTLM negotiate message too short")
+	}
+	n.negotiate = append([]byte(nil), negotiate...)
+	flags := le.Uint32(negotiate[12:])&ntlmSupportedFlags |
+		ntlmFlagUnicode | ntlmFlagRequestTarget | ntlmFlagTargetTypeServer | ntlmFlagTargetInfo
+	n.serverChallenge = make([]byte, 8)
+	if _, err := rand.Read(n.serverChallenge); err != nil {
+		return nil, err
+	}
+
+	nbName, dnsName := serverNames()
+	targetName := encodeUTF16(nbName)
+	var info []byte
+	addPair := func(id uint16, value []byte) {
+		info = le.AppendUint16(info, id)
+		info = le.AppendUint16(info, uint16(len(value)))
+		info = append(info, value...)
+	}
+	addPair(avNbDomainName, targetName)
+	addPair(avNbComputerName, targetName)
+	addPair(avDNSDomainName, encodeUTF16(dnsName))
+	addPair(avDNSComputerName, encodeUTF16(dnsName))
+	addPair(avTimestamp, le.AppendUint64(nil, fileTime(time.Now())))
+	addPair(avEOL, nil)
+
+	const payload = 56
+	msg := make([]byte, payload, payload+len(targetName)+len(info))
+	copy(msg, ntlmSignature)
+	le.PutUint32(msg[8:], ntlmChallenge)
+	le.PutUint16(msg[12:], uint16(len(targetName)))
+	le.PutUint16(msg[14:], uint16(len(targetName)))
+	le.PutUint32(msg[16:], payload)
+	le.PutUint32(msg[20:], flags)
+	copy(msg[24:32], n.serverChallenge)
+	le.PutUint16(msg[40:], uint16(len(info)))
+	le.PutUint16(msg[42:], uint16(len(info)))
+	le.PutUint32(msg[44:], uint32(payload+len(targetName)))
+	copy(msg[48:56], ntlmVersion)
+	msg = append(msg, targetName...)
+	msg = append(msg, info...)
+	n.challenge = msg
+	return msg, nil
+}
+
+// ntlmAuth is a decoded AUTHENTICATE message
+type ntlmAuth struct {
+	msg          []byte
+	flags        uint32
+	user         string
+	domain       []byte // UTF-16 as sent
+	lmResponse   []byte
+	ntResponse   []byte
+	encryptedKey []byte
+}
+
+// anonymous returns true if this is an anonymous logon
+func (a *ntlmAuth) anonymous() bool {
+	return a.user == "" && len(a.ntResponse) == 0 && len(a.lmResponse) <= 1
+}
+
+// parseAuthenticate decodes the client's AUTHENTICATE message
+func parseAuthenticate(msg []byte) (*ntlmAuth, error) {
+	if len(msg) < 64 || !bytes.HasPrefix(msg, ntlmSignature) || le.Uint32(msg[8:]) != ntlmAuthenticate {
+		return nil, errors.New("invalid NTLM authenticate message")
+	}
+	field := func(off int) ([]byte, error) {
+		return slice(msg, int(le.Uint32(msg[off+4:])), int(le.Uint16(msg[off:])))
+	}
+	a := &ntlmAuth{
+		msg:   append([]byte(nil), msg...),
+		flags: le.Uint32(msg[60:]),
+	}
+	var err error
+	var user []byte
+	for _, f := range []struct {
+		off int
+		p   *[]byte
+	}{
+		{12, &a.lmResponse},
+		{20, &a.ntResponse},
+		{28, &a.domain},
+		{36, &user},
+		{52, &a.encryptedKey},
+	} {
+		*f.p, err = field(f.off)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NTLM authenticate message: %w", err)
+		}
+	}
+	if a.flags&ntlmFlagUnicode != 0 {
+		a.user = decodeUTF16(user)
+	} else {
+		a.user = string(user)
+		a.domain = encodeUTF16(string(a.domain))
+	}
+	return a, nil
+}
+
+// hmacMD5 returns the HMAC-MD5 of the data with key
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// errBadPassword is returned when the client's response doesn't match
+var errBadPassword = errors.New("NTLM response doesn't match password")
+
+// verify checks the client's NTLMv2 response was made with pass,
+// returning the exported session key.
+func (n *ntlmServer) verify(a *ntlmAuth, pass string) (sessionKey []byte, err error) {
+	if len(a.ntResponse) <= 24 {
+		return nil, errors.New("NTLMv1 is not supported")
+	}
+	h := md4.New()
+	h.Write(encodeUTF16(pass))
+	responseKey := hmacMD5(h.Sum(nil), encodeUTF16(strings.ToUpper(a.user)), a.domain)
+	proof, blob := a.ntResponse[:16], a.ntResponse[16:]
+	if !hmac.Equal(proof, hmacMD5(responseKey, n.serverChallenge, blob)) {
+		return nil, errBadPassword
+	}
+	sessionKey = hmacMD5(responseKey, proof)
+	if a.flags&ntlmFlagKeyExch != 0 && len(a.encryptedKey) == 16 {
+		cipher, err := rc4.NewCipher(sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		exported := make([]byte, 16)
+		cipher.XORKeyStream(exported, a.encryptedKey)
+		sessionKey = exported
+	}
+
+	// Check the MIC if the client says it sent one
+	if len(blob) >= 28 && len(a.msg) >= 88 && avFlagsHasMIC(blob[28:]) {
+		msg := append([]byte(nil), a.msg...)
+		clear(msg[72:88])
+		if !hmac.Equal(a.msg[72:88], hmacMD5(sessionKey, n.negotiate, n.challenge, msg)) {
+			return nil, errors.New("NTLM message integrity check failed")
+		}
+	}
+	return sessionKey, nil
+}
+
+// avFlagsHasMIC returns true if the AV pairs have MsvAvFlags with
+// the MIC present bit set
+func avFlagsHasMIC(pairs []byte) bool {
+	for len(pairs) >= 4 {
+		id, n := le.Uint16(pairs), int(le.Uint16(pairs[2:]))
+		if id == avEOL || len(pairs) < 4+n {
+			break
+		}
+		if id == avFlags && n == 4 {
+			return le.Uint32(pairs[4:])&0x2 != 0
+		}
+		pairs = pairs[4+n:]
+	}
+	return false
+}
+
+// ntlmMIC returns the NTLMSSP signature of msg with sequence number 0
+// as used for the SPNEGO mechListMIC.
+func ntlmMIC(flags uint32, sessionKey []byte, fromClient bool, msg []byte) []byte {
+	direction := "server-to-client"
+	if fromClient {
+		direction = "client-to-server"
+	}
+	signKey := md5.Sum(append(append([]byte(nil), sessionKey...), "session key to "+direction+" signing key magic constant\x00"...))
+	sealBase := sessionKey
+	switch {
+	case flags&ntlmFlag128 != 0:
+	case flags&ntlmFlag56 != 0:
+		sealBase = sessionKey[:7]
+	default:
+		sealBase = sessionKey[:5]
+	}
+	sealKey := md5.Sum(append(append([]byte(nil), sealBase...), "session key to "+direction+" sealing key magic constant\x00"...))
+
+	sig := make([]byte, 16)
+	le.PutUint32(sig, 1)
+	copy(sig[4:12], hmacMD5(signKey[:], sig[12:16], msg))
+	if flags&ntlmFlagKeyExch != 0 {
+		cipher, _ := rc4.NewCipher(sealKey[:])
+		cipher.XORKeyStream(sig[4:12], sig[4:12])
+	}
+	return sig
+}
+
+// authResult is the result of a successful authentication
+type authResult struct {
+	user       string
+	VFS        *vfs.VFS
+	flags      uint16 // SMB2 session flags
+	sessionKey []byte // nil for guest and anonymous sessions
+}
+
+// authState is the state of an authentication in progress
+type authState struct {
+	spnego    bool
+	mechTypes []byte
+	ntlm      ntlmServer
+}
+
+// step processes a security token from the client returning the
+// token to send back. If the authentication is complete a non nil
+// authResult is returned.
+func (a *authState) step(s *server, in []byte) (out []byte, result *authResult, err error) {
+	var token, mic []byte
+	switch {
+	case bytes.HasPrefix(in, ntlmSignature):
+		token = in
+	case len(in) > 0 && (in[0] == 0x60 || in[0] == 0xa1):
+		a.spnego = true
+		t, err := parseNegToken(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		if t.mechTypes != nil {
+			a.mechTypes = t.mechTypes
+			hasNTLM := false
+			for _, mech := range t.mechs {
+				hasNTLM = hasNTLM || bytes.Equal(mech, oidNTLMSSP)
+			}
+			if !hasNTLM {
+				return nil, nil, errors.New("client doesn't support NTLMSSP")
+			}
+			if !bytes.Equal(t.mechs[0], oidNTLMSSP) || len(t.mechToken) == 0 {
+				// Ask the client to start again with NTLMSSP
+				return encodeNegTokenResp(negStateAcceptIncomplete, true, nil, nil), nil, nil
+			}
+		}
+		token, mic = t.mechToken, t.mechMIC
+	default:
+		return nil, nil, errors.New("unknown security token")
+	}
+	if len(token) < 12 || !bytes.HasPrefix(token, ntlmSignature) {
+		return nil, nil, errors.New("invalid NTLM message")
+	}
+
+	switch le.Uint32(token[8:]) {
+	case ntlmNegotiate:
+		out, err = a.ntlm.challengeMessage(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		if a.spnego {
+			out = encodeNegTokenResp(negStateAcceptIncomplete, true, out, nil)
+		}
+		return out, nil, nil
+	case ntlmAuthenticate:
+		if a.ntlm.challenge == nil {
+			return nil, nil, errors.New("NTLM authenticate without challenge")
+		}
+		auth, err := parseAuthenticate(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		result, err = s.authenticate(&a.ntlm, auth)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !a.spnego {
+			return nil, result, nil
+		}
+		var serverMIC []byte
+		if result.sessionKey != nil && auth.flags&ntlmFlagExtendedSecurity != 0 && a.mechTypes != nil {
+			if mic != nil && !hmac.Equal(mic, ntlmMIC(auth.flags, result.sessionKey, true, a.mechTypes)) {
+				return nil, nil, errors.New("SPNEGO mechListMIC check failed")
+			}
+			serverMIC = ntlmMIC(auth.flags, result.sessionKey, false, a.mechTypes)
+		}
+		return encodeNegTokenResp(negStateAcceptCompleted, false, nil, serverMIC), result, nil
+	}
+	return nil, nil, errors.New("unexpected NTLM message")
+}
+
+// authenticate checks the user in the AUTHENTICATE message may log
+// in and finds their VFS
+func (s *server) authenticate(n *ntlmServer, auth *ntlmAuth) (*authResult, error) {
+	guest := s.proxy == nil && s.opt.User == ""
+	if auth.anonymous() {
+		if !guest {
+			return nil, errors.New("anonymous login not allowed")
+		}
+		return &authResult{VFS: s.globalVFS, flags: sessionFlagIsNull}, nil
+	}
+	result := &authResult{user: auth.user}
+	check := func(pass string) (err error) {
+		result.sessionKey, err = n.verify(auth, pass)
+		return err
+	}
+	switch {
+	case s.proxy != nil:
+		VFS, _, err := s.proxy.CallChallenge(auth.user, check)
+		if err != nil {
+			return nil, fmt.Errorf("proxy login failed: %w", err)
+		}
+		result.VFS = VFS
+	case guest:
+		fs.Debugf(nil, "Logging in %q as guest", auth.user)
+		result.VFS = s.globalVFS
+		result.flags = sessionFlagIsGuest
+	default:
+		if !strings.EqualFold(auth.user, s.opt.User) {
+			return nil, fmt.Errorf("unknown user %q", auth.user)
+		}
+		if err := check(s.opt.Pass); err != nil {
+			return nil, err
+		}
+		result.VFS = s.globalVFS
+	}
+	return result, nil
+}
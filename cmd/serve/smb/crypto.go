@@ -0,0 +1,120 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// cmac returns the AES-CMAC of msg as defined in RFC 4493
+func cmac(block cipher.Block, msg []byte) []byte {
+	const bs = aes.BlockSize
+
+	// Make the subkeys
+	k1 := make([]byte, bs)
+	block.Encrypt(k1, k1)
+	shiftSubkey(k1)
+	k2 := append([]byte(nil), k1...)
+	shiftSubkey(k2)
+
+	n := (len(msg) + bs - 1) / bs
+	last := make([]byte, bs)
+	if n > 0 && len(msg)%bs == 0 {
+		subtle.XORBytes(last, msg[(n-1)*bs:], k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := copy(last, msg[(n-1)*bs:])
+		last[rest] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, bs)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x, x, msg[i*bs:(i+1)*bs])
+		block.Encrypt(x, x)
+	}
+	subtle.XORBytes(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// shiftSubkey does the subkey generation step of RFC 4493 on k in place
+func shiftSubkey(k []byte) {
+	msb := k[0] >> 7
+	for i := 0; i < len(k)-1; i++ {
+		k[i] = k[i]<<1 | k[i+1]>>7
+	}
+	k[len(k)-1] <<= 1
+	if msb != 0 {
+		k[len(k)-1] ^= 0x87
+	}
+}
+
+// kdf is the SP800-108 counter mode key derivation function used
+// by SMB3 with HMAC-SHA256 and a 128 bit output
+func kdf(ki, label, context []byte) []byte {
+	h := hmac.New(sha256.New, ki)
+	h.Write([]byte{0x00, 0x00, 0x00, 0x01})
+	h.Write(label)
+	h.Write([]byte{0x00})
+	h.Write(context)
+	h.Write([]byte{0x00, 0x00, 0x00, 0x80})
+	return h.Sum(nil)[:16]
+}
+
+// signer signs and verifies SMB2 messages for a session
+type signer struct {
+	hmacKey []byte       // HMAC-SHA256 key for SMB 2.x
+	block   cipher.Block // AES-CMAC cipher for SMB 3.x
+}
+
+// newSigner makes a signer for the dialect from the session key.
+//
+// preauthHash is only used for SMB 3.1.1
+func newSigner(dialect uint16, sessionKey, preauthHash []byte) (*signer, error) {
+	var key []byte
+	switch dialect {
+	case dialect202, dialect210:
+		return &signer{hmacKey: sessionKey}, nil
+	case dialect300, dialect302:
+		key = kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	default:
+		key = kdf(sessionKey, []byte("SMBSigningKey\x00"), preauthHash)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &signer{block: block}, nil
+}
+
+// sum returns the signature of msg which must have a zero signature
+func (s *signer) sum(msg []byte) []byte {
+	if s.block != nil {
+		return cmac(s.block, msg)
+	}
+	h := hmac.New(sha256.New, s.hmacKey)
+	h.Write(msg)
+	return h.Sum(nil)[:signatureSize]
+}
+
+// sign sets the signed flag in msg and signs it
+func (s *signer) sign(msg []byte) {
+	le.PutUint32(msg[16:], le.Uint32(msg[16:])|flagSigned)
+	clear(msg[48:64])
+	copy(msg[48:64], s.sum(msg))
+}
+
+// verify returns true if msg has a valid signature
+func (s *signer) verify(msg []byte) bool {
+	var sig [signatureSize]byte
+	copy(sig[:], msg[48:64])
+	clear(msg[48:64])
+	ok := hmac.Equal(sig[:], s.sum(msg))
+	copy(msg[48:64], sig[:])
+	return ok
+}
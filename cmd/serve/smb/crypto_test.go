@@ -0,0 +1,88 @@
+package smb
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCMAC(t *testing.T) {
+	// Test vectors from RFC 4493
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172a" +
+		"ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" +
+		"f69f2445df4f9b17ad2b417be66c3710")
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	for _, test := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		assert.Equal(t, test.want, hex.EncodeToString(cmac(block, msg[:test.n])), test.n)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	for _, dialect := range []uint16{dialect202, dialect300, dialect311} {
+		s, err := newSigner(dialect, key, make([]byte, 64))
+		require.NoError(t, err)
+		msg := make([]byte, headerSize+10)
+		h := header{Command: cmdEcho, MessageID: 7}
+		h.encode(msg)
+		s.sign(msg)
+		assert.True(t, s.verify(msg))
+		got, err := parseHeader(msg)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(flagSigned), got.Flags)
+		msg[headerSize] ^= 1
+		assert.False(t, s.verify(msg))
+	}
+}
+
+func TestRequireSigning(t *testing.T) {
+	s, err := newSigner(dialect311, []byte("0123456789abcdef"), make([]byte, 64))
+	require.NoError(t, err)
+	c := &conn{
+		dialect: dialect311,
+		sessions: map[uint64]*session{
+			1: {id: 1, valid: true, user: "user", signer: s},
+			2: {id: 2, valid: true, flags: sessionFlagIsGuest},
+		},
+	}
+	request := func(sessionID uint64, sign bool) *request {
+		msg := make([]byte, headerSize+4)
+		h := header{Command: cmdEcho, SessionID: sessionID}
+		h.encode(msg)
+		if sign {
+			s.sign(msg)
+		}
+		h, err := parseHeader(msg)
+		require.NoError(t, err)
+		return &request{header: h, msg: msg, body: msg[headerSize:]}
+	}
+
+	_, err = c.process(request(1, false))
+	assert.Equal(t, statusAccessDenied, err)
+	_, err = c.process(request(1, true))
+	assert.NoError(t, err)
+
+	// A bad signature is rejected
+	r := request(1, true)
+	r.msg[headerSize] ^= 1
+	_, err = c.process(r)
+	assert.Equal(t, statusAccessDenied, err)
+
+	// Guests can't sign
+	_, err = c.process(request(2, false))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,1072 @@
+package smb
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// Access mask bits
+const (
+	accessReadData        = 0x00000001
+	accessWriteData       = 0x00000002
+	accessAppendData      = 0x00000004
+	accessDelete          = 0x00010000
+	accessMaximumAllowed  = 0x02000000
+	accessGenericAll      = 0x10000000
+	accessGenericExecute  = 0x20000000
+	accessGenericWrite    = 0x40000000
+	accessGenericRead     = 0x80000000
+	accessAll             = 0x001F01FF
+	accessReadOnly        = 0x001200A9
+	accessGenericReadMap  = 0x00120089
+	accessGenericWriteMap = 0x00120116
+	accessGenericExecMap  = 0x001200A0
+)
+
+// Create dispositions, options and actions
+const (
+	dispSupersede   = 0
+	dispOpen        = 1
+	dispCreate      = 2
+	dispOpenIf      = 3
+	dispOverwrite   = 4
+	dispOverwriteIf = 5
+
+	optDirectoryFile    = 0x00000001
+	optNonDirectoryFile = 0x00000040
+	optDeleteOnClose    = 0x00001000
+
+	actionSuperseded  = 0
+	actionOpened      = 1
+	actionCreated     = 2
+	actionOverwritten = 3
+)
+
+// IOCTL codes
+const (
+	fsctlDfsGetReferrals       = 0x00060194
+	fsctlDfsGetReferralsEx     = 0x000601B0
+	fsctlValidateNegotiateInfo = 0x00140204
+)
+
+// Info types and the file info classes which can be set
+const (
+	infoFile       = 1
+	infoFilesystem = 2
+	infoSecurity   = 3
+	infoQuota      = 4
+
+	fileBasicInformation         = 4
+	fileRenameInformation        = 10
+	fileDispositionInformation   = 13
+	filePositionInformation      = 14
+	fileModeInformation          = 16
+	fileAllocationInformation    = 19
+	fileEndOfFileInformation     = 20
+	fileDispositionInformationEx = 64
+	fileRenameInformationEx      = 65
+)
+
+// Query directory flags
+const (
+	queryRestartScans      = 0x01
+	queryReturnSingleEntry = 0x02
+	queryReopen            = 0x10
+)
+
+// openFile is a file or directory opened by the client
+type openFile struct {
+	id            uint64
+	tree          *tree
+	name          string // path in the VFS
+	isDir         bool
+	access        uint32 // granted access
+	deleteOnClose bool
+	handle        vfs.Handle // open handle or nil
+	writable      bool       // set if handle is open for writing
+
+	// directory listing state
+	listed  bool
+	pattern string
+	entries []dirEntry
+	pos     int
+	found   bool // set if any entries have been returned
+}
+
+// node returns the VFS node for the file
+func (f *openFile) node(VFS *vfs.VFS) (vfs.Node, error) {
+	if f.handle != nil {
+		return f.handle.Node(), nil
+	}
+	return VFS.Stat(f.name)
+}
+
+// openHandle makes sure f has an open handle, opening it for
+// writing if write is set
+func (f *openFile) openHandle(VFS *vfs.VFS, write bool) error {
+	if f.handle != nil {
+		if !write || f.writable {
+			return nil
+		}
+		// reopen read only handles for writing
+		err := f.handle.Close()
+		f.handle = nil
+		if err != nil {
+			return err
+		}
+	}
+	flags := os.O_RDONLY
+	if write {
+		flags = os.O_RDWR
+	}
+	return f.open(VFS, flags)
+}
+
+// open opens a handle on the file with flags
+func (f *openFile) open(VFS *vfs.VFS, flags int) error {
+	handle, err := VFS.OpenFile(f.name, flags, 0777)
+	if err != nil {
+		return err
+	}
+	f.handle = handle
+	f.writable = flags&(os.O_WRONLY|os.O_RDWR) != 0
+	return nil
+}
+
+// close the file, deleting it if required
+func (f *openFile) close(sess *session) (err error) {
+	if f.handle != nil {
+		err = f.handle.Close()
+		f.handle = nil
+	}
+	if f.deleteOnClose {
+		removeErr := sess.VFS.Remove(f.name)
+		if removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) && err == nil {
+			err = removeErr
+		}
+	}
+	delete(sess.files, f.id)
+	return err
+}
+
+// getFile returns the open file whose FileId is at offset off in
+// the request body
+func (c *conn) getFile(r *request, off int) (*openFile, error) {
+	if len(r.body) < off+16 {
+		return nil, statusInvalidParameter
+	}
+	id := le.Uint64(r.body[off+8:])
+	if id == 0xFFFFFFFFFFFFFFFF && r.Flags&flagRelated != 0 {
+		id = c.lastFileID
+	}
+	f := r.sess.files[id]
+	if f == nil || f.tree != r.tree {
+		return nil, statusFileClosed
+	}
+	c.lastFileID = id
+	return f, nil
+}
+
+// cleanName converts an SMB path into a VFS path
+func cleanName(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		// Only the default data stream is supported
+		if !strings.EqualFold(name[i:], "::$DATA") {
+			return "", statusObjectNameNotFound
+		}
+		name = name[:i]
+	}
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", statusObjectNameInvalid
+		}
+	}
+	return name, nil
+}
+
+// mapAccess converts generic access rights into specific ones
+func mapAccess(access uint32) uint32 {
+	if access&(accessMaximumAllowed|accessGenericAll) != 0 {
+		return accessAll
+	}
+	if access&accessGenericRead != 0 {
+		access |= accessGenericReadMap
+	}
+	if access&accessGenericWrite != 0 {
+		access |= accessGenericWriteMap
+	}
+	if access&accessGenericExecute != 0 {
+		access |= accessGenericExecMap
+	}
+	return access & accessAll
+}
+
+// capabilities returns the server capabilities for the dialect
+func capabilities(dialect uint16) uint32 {
+	if dialect >= dialect210 && dialect != dialectWild {
+		return capLargeMTU
+	}
+	return 0
+}
+
+// negotiateResponse makes the body of the NEGOTIATE response
+func (c *conn) negotiateResponse(dialect uint16) ([]byte, error) {
+	token := spnegoHint()
+	maxIO := uint32(maxSmallIO)
+	caps := capabilities(dialect)
+	if caps&capLargeMTU != 0 {
+		maxIO = maxIOSize
+	}
+	body := make([]byte, 64, 256)
+	le.PutUint16(body, 65)
+	le.PutUint16(body[2:], secModeSigningEnabled|secModeSigningRequired)
+	le.PutUint16(body[4:], dialect)
+	copy(body[8:24], c.s.guid[:])
+	le.PutUint32(body[24:], caps)
+	le.PutUint32(body[28:], maxIO)
+	le.PutUint32(body[32:], maxIO)
+	le.PutUint32(body[36:], maxIO)
+	le.PutUint64(body[40:], fileTime(time.Now()))
+	le.PutUint16(body[56:], headerSize+64)
+	le.PutUint16(body[58:], uint16(len(token)))
+	body = append(body, token...)
+	if dialect == dialect311 {
+		// Add the preauth integrity context with SHA-512
+		body = append(body, make([]byte, align8(headerSize+len(body))-headerSize-len(body))...)
+		le.PutUint16(body[6:], 1)
+		le.PutUint32(body[60:], uint32(headerSize+len(body)))
+		ctx := make([]byte, 8+6+32)
+		le.PutUint16(ctx, 1)
+		le.PutUint16(ctx[2:], 6+32)
+		le.PutUint16(ctx[8:], 1)
+		le.PutUint16(ctx[10:], 32)
+		le.PutUint16(ctx[12:], 1)
+		if _, err := rand.Read(ctx[14:]); err != nil {
+			return nil, err
+		}
+		body = append(body, ctx...)
+	}
+	return body, nil
+}
+
+// negotiate handles the SMB2 NEGOTIATE command
+func (c *conn) negotiate(r *request) ([]byte, error) {
+	b := r.body
+	if c.dialect != dialectNone || len(b) < 36 {
+		return nil, statusInvalidParameter
+	}
+	dialects, err := slice(b, 36, 2*int(le.Uint16(b[2:])))
+	if err != nil {
+		return nil, err
+	}
+	var best uint16
+	for i := 0; i < len(dialects); i += 2 {
+		switch d := le.Uint16(dialects[i:]); d {
+		case dialect202, dialect210, dialect300, dialect302, dialect311:
+			best = max(best, d)
+		}
+	}
+	if best == dialectNone {
+		return nil, statusNotSupported
+	}
+	c.dialect = best
+	c.clientCaps = le.Uint32(b[8:])
+	copy(c.clientGUID[:], b[12:28])
+	fs.Debugf(c.remote, "SMB negotiated dialect 0x%04x", best)
+	return c.negotiateResponse(best)
+}
+
+// negotiateSMB1 handles an SMB1 negotiate request by replying with
+// an SMB2 negotiate response if the client supports SMB2
+func (c *conn) negotiateSMB1(msg []byte) ([]byte, error) {
+	if c.dialect != dialectNone || len(msg) < 35 || msg[4] != 0x72 {
+		return nil, errors.New("unsupported SMB1 request")
+	}
+	dialect := uint16(dialectNone)
+	data := msg[35:]
+	for len(data) > 1 && data[0] == 0x02 {
+		end := strings.IndexByte(string(data[1:]), 0)
+		if end < 0 {
+			break
+		}
+		switch string(data[1 : 1+end]) {
+		case "SMB 2.???":
+			dialect = dialectWild
+		case "SMB 2.002":
+			if dialect == dialectNone {
+				dialect = dialect202
+			}
+		}
+		data = data[end+2:]
+	}
+	if dialect == dialectNone {
+		return nil, errors.New("client only supports SMB1")
+	}
+	if dialect == dialect202 {
+		c.dialect = dialect
+	}
+	body, err := c.negotiateResponse(dialect)
+	if err != nil {
+		return nil, err
+	}
+	h := header{Command: cmdNegotiate, Credits: 1, Flags: flagServerToRedir}
+	out := make([]byte, headerSize+len(body))
+	h.encode(out)
+	copy(out[headerSize:], body)
+	return out, nil
+}
+
+// sessionSetupResponse makes the body of a SESSION_SETUP response
+func sessionSetupResponse(flags uint16, token []byte) []byte {
+	body := make([]byte, 8, 9+len(token))
+	le.PutUint16(body, 9)
+	le.PutUint16(body[2:], flags)
+	le.PutUint16(body[4:], headerSize+8)
+	le.PutUint16(body[6:], uint16(len(token)))
+	if len(token) == 0 {
+		return append(body, 0)
+	}
+	return append(body, token...)
+}
+
+// sessionSetup handles the SESSION_SETUP command
+func (c *conn) sessionSetup(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 24 {
+		return nil, statusInvalidParameter
+	}
+	token, err := slice(r.msg, int(le.Uint16(b[12:])), int(le.Uint16(b[14:])))
+	if err != nil {
+		return nil, err
+	}
+	var sess *session
+	if r.SessionID == 0 {
+		sess = &session{
+			id:          c.s.newID(&c.s.nextID),
+			trees:       make(map[uint32]*tree),
+			files:       make(map[uint64]*openFile),
+			preauthHash: c.preauthHash,
+		}
+		c.sessions[sess.id] = sess
+		r.SessionID = sess.id
+	} else {
+		sess = c.sessions[r.SessionID]
+		if sess == nil {
+			return nil, statusUserSessionDeleted
+		}
+		if sess.valid {
+			// Re-authentication isn't supported
+			return nil, statusNotSupported
+		}
+	}
+	r.sess = sess
+	if c.dialect == dialect311 {
+		sess.preauthHash = preauthHash(sess.preauthHash, r.msg)
+	}
+	if sess.auth == nil {
+		sess.auth = &authState{}
+	}
+	out, result, err := sess.auth.step(c.s, token)
+	if err != nil {
+		fs.Infof(c.remote, "SMB login failed: %v", err)
+		delete(c.sessions, sess.id)
+		r.sess = nil
+		return nil, statusLogonFailure
+	}
+	if result == nil {
+		return sessionSetupResponse(0, out), statusMoreProcessingRequired
+	}
+	sess.auth = nil
+	sess.valid = true
+	sess.user = result.user
+	sess.VFS = result.VFS
+	sess.flags = result.flags
+	if result.sessionKey != nil {
+		sess.signer, err = newSigner(c.dialect, result.sessionKey, sess.preauthHash)
+		if err != nil {
+			return nil, err
+		}
+	} else if sess.flags&(sessionFlagIsGuest|sessionFlagIsNull) == 0 {
+		// Signing is required so users must have a session key
+		fs.Errorf(c.remote, "SMB login for %q has no session key to sign with", sess.user)
+		delete(c.sessions, sess.id)
+		r.sess = nil
+		return nil, statusLogonFailure
+	}
+	fs.Debugf(c.remote, "SMB user %q logged in", sess.user)
+	return sessionSetupResponse(sess.flags, out), nil
+}
+
+// logoff handles the LOGOFF command
+func (c *conn) logoff(r *request) ([]byte, error) {
+	c.closeSession(r.sess)
+	return encodeEmpty(4), nil
+}
+
+// treeConnect handles the TREE_CONNECT command
+func (c *conn) treeConnect(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 8 {
+		return nil, statusInvalidParameter
+	}
+	p, err := slice(r.msg, int(le.Uint16(b[4:])), int(le.Uint16(b[6:])))
+	if err != nil {
+		return nil, err
+	}
+	share := decodeUTF16(p)
+	share = share[strings.LastIndexByte(share, '\\')+1:]
+	t := &tree{}
+	switch {
+	case strings.EqualFold(share, c.s.opt.Share):
+	case strings.EqualFold(share, "IPC$"):
+		t.ipc = true
+	default:
+		return nil, statusBadNetworkName
+	}
+	sess := r.sess
+	sess.nextTreeID++
+	t.id = sess.nextTreeID
+	sess.trees[t.id] = t
+	r.TreeID = t.id
+
+	body := make([]byte, 16)
+	le.PutUint16(body, 16)
+	maximalAccess := uint32(accessAll)
+	if sess.VFS.Opt.ReadOnly {
+		maximalAccess = accessReadOnly
+	}
+	if t.ipc {
+		body[2] = 2 // pipe
+	} else {
+		body[2] = 1 // disk
+	}
+	le.PutUint32(body[12:], maximalAccess)
+	return body, nil
+}
+
+// treeDisconnect handles the TREE_DISCONNECT command
+func (c *conn) treeDisconnect(r *request) ([]byte, error) {
+	for _, f := range r.sess.files {
+		if f.tree == r.tree {
+			_ = f.close(r.sess)
+		}
+	}
+	delete(r.sess.trees, r.tree.id)
+	return encodeEmpty(4), nil
+}
+
+// create handles the CREATE command which opens or creates files
+// and directories
+func (c *conn) create(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 56 {
+		return nil, statusInvalidParameter
+	}
+	access := mapAccess(le.Uint32(b[24:]))
+	disposition := le.Uint32(b[36:])
+	options := le.Uint32(b[40:])
+	nameBuf, err := slice(r.msg, int(le.Uint16(b[44:])), int(le.Uint16(b[46:])))
+	if err != nil {
+		return nil, err
+	}
+	if r.tree.ipc {
+		return nil, statusObjectNameNotFound
+	}
+	name, err := cleanName(decodeUTF16(nameBuf))
+	if err != nil {
+		return nil, err
+	}
+	VFS := r.sess.VFS
+	node, err := VFS.Stat(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !exists {
+		parent, err := VFS.Stat(path.Dir("/" + name))
+		if err != nil || !parent.IsDir() {
+			return nil, statusObjectPathNotFound
+		}
+	}
+
+	f := &openFile{
+		tree:          r.tree,
+		name:          name,
+		access:        access,
+		deleteOnClose: options&optDeleteOnClose != 0,
+	}
+	wantDir := options&optDirectoryFile != 0
+	var action uint32
+	if exists {
+		f.isDir = node.IsDir()
+		if f.isDir && options&optNonDirectoryFile != 0 {
+			return nil, statusFileIsADirectory
+		}
+		if !f.isDir && wantDir {
+			return nil, statusNotADirectory
+		}
+		switch disposition {
+		case dispOpen, dispOpenIf:
+			action = actionOpened
+		case dispCreate:
+			return nil, statusObjectNameCollision
+		case dispOverwrite, dispOverwriteIf, dispSupersede:
+			if f.isDir {
+				return nil, statusInvalidParameter
+			}
+			action = actionOverwritten
+			if disposition == dispSupersede {
+				action = actionSuperseded
+			}
+			err = f.open(VFS, os.O_RDWR|os.O_TRUNC)
+		default:
+			return nil, statusInvalidParameter
+		}
+	} else {
+		switch disposition {
+		case dispOpen, dispOverwrite:
+			return nil, statusObjectNameNotFound
+		case dispCreate, dispOpenIf, dispOverwriteIf, dispSupersede:
+		default:
+			return nil, statusInvalidParameter
+		}
+		action = actionCreated
+		if wantDir {
+			f.isDir = true
+			err = VFS.Mkdir(name, 0777)
+		} else {
+			err = f.open(VFS, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	node, err = f.node(VFS)
+	if err != nil {
+		if f.handle != nil {
+			_ = f.handle.Close()
+		}
+		return nil, err
+	}
+	f.id = c.s.newID(&c.s.nextFileID)
+	r.sess.files[f.id] = f
+	c.lastFileID = f.id
+
+	body := make([]byte, 89)
+	le.PutUint16(body, 89)
+	le.PutUint32(body[4:], action)
+	putNetworkOpen(body[8:], node)
+	putFileID(body[64:], f.id)
+	return body, nil
+}
+
+// putFileID writes the 16 byte FileId for id into buf
+func putFileID(buf []byte, id uint64) {
+	le.PutUint64(buf, id)
+	le.PutUint64(buf[8:], id)
+}
+
+// closeFile handles the CLOSE command
+func (c *conn) closeFile(r *request) ([]byte, error) {
+	f, err := c.getFile(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	err = f.close(r.sess)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 60)
+	le.PutUint16(body, 60)
+	if le.Uint16(r.body[2:])&0x0001 != 0 {
+		// SMB2_CLOSE_FLAG_POSTQUERY_ATTRIB
+		if node, err := r.sess.VFS.Stat(f.name); err == nil {
+			le.PutUint16(body[2:], 0x0001)
+			putNetworkOpen(body[8:], node)
+		}
+	}
+	return body, nil
+}
+
+// flush handles the FLUSH command
+func (c *conn) flush(r *request) ([]byte, error) {
+	f, err := c.getFile(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	if f.handle != nil {
+		err = f.handle.Sync()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return encodeEmpty(4), nil
+}
+
+// read handles the READ command
+func (c *conn) read(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 48 {
+		return nil, statusInvalidParameter
+	}
+	length := le.Uint32(b[4:])
+	offset := le.Uint64(b[8:])
+	minCount := le.Uint32(b[32:])
+	f, err := c.getFile(r, 16)
+	if err != nil {
+		return nil, err
+	}
+	if f.isDir {
+		return nil, statusInvalidDeviceRequest
+	}
+	if length > maxIOSize || int64(offset) < 0 {
+		return nil, statusInvalidParameter
+	}
+	if f.access&accessReadData == 0 {
+		return nil, statusAccessDenied
+	}
+	err = f.openHandle(r.sess.VFS, false)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 16+length)
+	n, err := f.handle.ReadAt(body[16:], int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) && n == 0 {
+		return nil, err
+	}
+	if n == 0 || uint32(n) < minCount {
+		return nil, statusEndOfFile
+	}
+	le.PutUint16(body, 17)
+	body[2] = headerSize + 16
+	le.PutUint32(body[4:], uint32(n))
+	return body[:16+n], nil
+}
+
+// write handles the WRITE command
+func (c *conn) write(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 48 {
+		return nil, statusInvalidParameter
+	}
+	data, err := slice(r.msg, int(le.Uint16(b[2:])), int(le.Uint32(b[4:])))
+	if err != nil {
+		return nil, err
+	}
+	offset := le.Uint64(b[8:])
+	f, err := c.getFile(r, 16)
+	if err != nil {
+		return nil, err
+	}
+	if f.isDir {
+		return nil, statusInvalidDeviceRequest
+	}
+	if f.access&(accessWriteData|accessAppendData) == 0 {
+		return nil, statusAccessDenied
+	}
+	if int64(offset) < 0 {
+		return nil, statusInvalidParameter
+	}
+	err = f.openHandle(r.sess.VFS, true)
+	if err != nil {
+		return nil, err
+	}
+	n, err := f.handle.WriteAt(data, int64(offset))
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 17)
+	le.PutUint16(body, 17)
+	le.PutUint32(body[4:], uint32(n))
+	return body, nil
+}
+
+// lock handles the LOCK command
+//
+// Byte range locks aren't supported so this always succeeds
+func (c *conn) lock(r *request) ([]byte, error) {
+	if _, err := c.getFile(r, 8); err != nil {
+		return nil, err
+	}
+	return encodeEmpty(4), nil
+}
+
+// ioctl handles the IOCTL command
+func (c *conn) ioctl(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 56 {
+		return nil, statusInvalidParameter
+	}
+	ctlCode := le.Uint32(b[4:])
+	var out []byte
+	switch ctlCode {
+	case fsctlValidateNegotiateInfo:
+		out = make([]byte, 24)
+		le.PutUint32(out, capabilities(c.dialect))
+		copy(out[4:20], c.s.guid[:])
+		le.PutUint16(out[20:], secModeSigningEnabled|secModeSigningRequired)
+		le.PutUint16(out[22:], c.dialect)
+	case fsctlDfsGetReferrals, fsctlDfsGetReferralsEx:
+		return nil, statusNotFound
+	default:
+		return nil, statusInvalidDeviceRequest
+	}
+	body := make([]byte, 48, 48+len(out))
+	le.PutUint16(body, 49)
+	le.PutUint32(body[4:], ctlCode)
+	copy(body[8:24], b[8:24])
+	le.PutUint32(body[32:], headerSize+48)
+	le.PutUint32(body[36:], uint32(len(out)))
+	return append(body, out...), nil
+}
+
+// listDir reads the entries of the directory f including . and ..
+func listDir(VFS *vfs.VFS, f *openFile) ([]dirEntry, error) {
+	node, err := VFS.Stat(f.name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return nil, statusNotADirectory
+	}
+	parent, err := VFS.Stat(path.Dir("/" + f.name))
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dirEntry, 0, len(nodes)+2)
+	entries = append(entries, dirEntry{".", dir}, dirEntry{"..", parent})
+	for _, node := range nodes {
+		entries = append(entries, dirEntry{node.Name(), node})
+	}
+	return entries, nil
+}
+
+// matchPattern returns true if name matches the wildcard pattern
+// case insensitively
+func matchPattern(pattern, name string) bool {
+	if pattern == "*" || pattern == "" {
+		return true
+	}
+	return matchRunes([]rune(strings.ToLower(pattern)), []rune(strings.ToLower(name)))
+}
+
+// matchRunes matches name against pattern supporting the * and ?
+// wildcards and their DOS equivalents
+func matchRunes(pattern, name []rune) bool {
+	for len(pattern) > 0 {
+		switch p := pattern[0]; p {
+		case '*', '<':
+			for i := len(name); i >= 0; i-- {
+				if matchRunes(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?', '>':
+			if len(name) == 0 {
+				return p == '>' && matchRunes(pattern[1:], name)
+			}
+		case '"':
+			if len(name) == 0 {
+				return matchRunes(pattern[1:], name)
+			}
+			if name[0] != '.' {
+				return false
+			}
+		default:
+			if len(name) == 0 || unicode.ToLower(name[0]) != p {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// queryDirectory handles the QUERY_DIRECTORY command
+func (c *conn) queryDirectory(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 32 {
+		return nil, statusInvalidParameter
+	}
+	class, flags := b[2], b[3]
+	f, err := c.getFile(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	nameBuf, err := slice(r.msg, int(le.Uint16(b[24:])), int(le.Uint16(b[26:])))
+	if err != nil {
+		return nil, err
+	}
+	outLen := int(le.Uint32(b[28:]))
+	if !f.isDir {
+		return nil, statusInvalidParameter
+	}
+	if !dirInfoClasses[class] {
+		return nil, statusInvalidInfoClass
+	}
+	if !f.listed || flags&(queryRestartScans|queryReopen) != 0 {
+		f.entries, err = listDir(r.sess.VFS, f)
+		if err != nil {
+			return nil, err
+		}
+		f.listed = true
+		f.pattern = decodeUTF16(nameBuf)
+		f.pos = 0
+		f.found = false
+	}
+
+	out := make([]byte, 8, 8+outLen)
+	le.PutUint16(out, 9)
+	le.PutUint16(out[2:], headerSize+8)
+	last := -1
+	for ; f.pos < len(f.entries); f.pos++ {
+		e := f.entries[f.pos]
+		if !matchPattern(f.pattern, e.name) {
+			continue
+		}
+		entry := encodeDirEntry(class, e)
+		start := 8 + align8(len(out)-8)
+		if start+len(entry)-8 > outLen {
+			if last < 0 {
+				return nil, statusInfoLengthMismatch
+			}
+			break
+		}
+		out = append(out, make([]byte, start-len(out))...)
+		if last >= 0 {
+			le.PutUint32(out[last:], uint32(start-last))
+		}
+		out = append(out, entry...)
+		last = start
+		f.found = true
+		if flags&queryReturnSingleEntry != 0 {
+			f.pos++
+			break
+		}
+	}
+	if last < 0 {
+		if !f.found {
+			return nil, statusNoSuchFile
+		}
+		return nil, statusNoMoreFiles
+	}
+	le.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// queryInfo handles the QUERY_INFO command
+func (c *conn) queryInfo(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 40 {
+		return nil, statusInvalidParameter
+	}
+	infoType, class := b[2], b[3]
+	outLen := int(le.Uint32(b[4:]))
+	addlInfo := le.Uint32(b[16:])
+	f, err := c.getFile(r, 24)
+	if err != nil {
+		return nil, err
+	}
+	VFS := r.sess.VFS
+	var (
+		data     []byte
+		variable bool
+	)
+	switch infoType {
+	case infoFile:
+		node, err := f.node(VFS)
+		if err != nil {
+			return nil, err
+		}
+		data, variable, err = fileInfo(class, f, node)
+		if err != nil {
+			return nil, err
+		}
+	case infoFilesystem:
+		data, variable, err = fsInfo(class, VFS, c.s.opt.Share)
+		if err != nil {
+			return nil, err
+		}
+	case infoSecurity:
+		data = securityDescriptor(addlInfo, f.isDir)
+		if len(data) > outLen {
+			return encodeError(le.AppendUint32(nil, uint32(len(data)))), statusBufferTooSmall
+		}
+	case infoQuota:
+		return nil, statusNotSupported
+	default:
+		return nil, statusInvalidParameter
+	}
+	err = nil
+	if len(data) > outLen {
+		if !variable {
+			return nil, statusInfoLengthMismatch
+		}
+		data = data[:outLen]
+		err = statusBufferOverflow
+	}
+	body := make([]byte, 8, 9+len(data))
+	le.PutUint16(body, 9)
+	le.PutUint16(body[2:], headerSize+8)
+	le.PutUint32(body[4:], uint32(len(data)))
+	if len(data) == 0 {
+		return append(body, 0), err
+	}
+	return append(body, data...), err
+}
+
+// setInfo handles the SET_INFO command
+func (c *conn) setInfo(r *request) ([]byte, error) {
+	b := r.body
+	if len(b) < 32 {
+		return nil, statusInvalidParameter
+	}
+	infoType, class := b[2], b[3]
+	buf, err := slice(r.msg, int(le.Uint16(b[8:])), int(le.Uint32(b[4:])))
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.getFile(r, 16)
+	if err != nil {
+		return nil, err
+	}
+	switch infoType {
+	case infoFile:
+		err = c.setFileInfo(r, f, class, buf)
+		if err != nil {
+			return nil, err
+		}
+	case infoSecurity:
+		// Ignore attempts to set the security descriptor
+	case infoFilesystem, infoQuota:
+		return nil, statusNotSupported
+	default:
+		return nil, statusInvalidParameter
+	}
+	return encodeEmpty(2), nil
+}
+
+// setFileInfo sets the file information class on f from buf
+func (c *conn) setFileInfo(r *request, f *openFile, class uint8, buf []byte) error {
+	VFS := r.sess.VFS
+	switch class {
+	case fileBasicInformation:
+		if len(buf) < 36 {
+			return statusInfoLengthMismatch
+		}
+		writeTime := le.Uint64(buf[16:])
+		if writeTime == 0 || int64(writeTime) < 0 {
+			// 0 means don't change, -1 and -2 are for suspending updates
+			return nil
+		}
+		node, err := f.node(VFS)
+		if err != nil {
+			return err
+		}
+		err = node.SetModTime(timeFromFileTime(writeTime))
+		if err != nil && f.isDir {
+			fs.Debugf(f.name, "Ignoring failure to set directory modification time: %v", err)
+			err = nil
+		}
+		return err
+	case fileRenameInformation, fileRenameInformationEx:
+		if len(buf) < 20 {
+			return statusInfoLengthMismatch
+		}
+		replace := buf[0] != 0
+		if class == fileRenameInformationEx {
+			replace = le.Uint32(buf)&0x1 != 0
+		}
+		if le.Uint64(buf[8:]) != 0 {
+			// Renames relative to RootDirectory aren't supported
+			return statusNotSupported
+		}
+		nameBuf, err := slice(buf, 20, int(le.Uint32(buf[16:])))
+		if err != nil {
+			return err
+		}
+		newName, err := cleanName(decodeUTF16(nameBuf))
+		if err != nil {
+			return err
+		}
+		if f.name == "" || newName == "" {
+			return statusAccessDenied
+		}
+		if newName == f.name {
+			return nil
+		}
+		if node, err := VFS.Stat(newName); err == nil && !strings.EqualFold(newName, f.name) {
+			if !replace {
+				return statusObjectNameCollision
+			}
+			if node.IsDir() {
+				return statusAccessDenied
+			}
+		}
+		err = VFS.Rename(f.name, newName)
+		if err != nil {
+			return err
+		}
+		f.name = newName
+		return nil
+	case fileDispositionInformation, fileDispositionInformationEx:
+		if len(buf) < 1 {
+			return statusInfoLengthMismatch
+		}
+		del := buf[0]&0x1 != 0
+		if del {
+			if f.access&accessDelete == 0 {
+				return statusAccessDenied
+			}
+			if f.name == "" {
+				return statusAccessDenied
+			}
+			if f.isDir {
+				entries, err := VFS.ReadDir(f.name)
+				if err != nil {
+					return err
+				}
+				if len(entries) > 0 {
+					return statusDirectoryNotEmpty
+				}
+			}
+		}
+		f.deleteOnClose = del
+		return nil
+	case fileEndOfFileInformation:
+		if len(buf) < 8 {
+			return statusInfoLengthMismatch
+		}
+		if f.isDir {
+			return statusInvalidParameter
+		}
+		err := f.openHandle(VFS, true)
+		if err != nil {
+			return err
+		}
+		return f.handle.Truncate(int64(le.Uint64(buf)))
+	case fileAllocationInformation, filePositionInformation, fileModeInformation:
+		return nil
+	}
+	return statusInvalidInfoClass
+}
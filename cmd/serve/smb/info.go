@@ -0,0 +1,303 @@
+package smb
+
+import (
+	"strings"
+
+	"github.com/rclone/rclone/vfs"
+)
+
+// File attributes
+const (
+	attrReadOnly  = 0x00000001
+	attrDirectory = 0x00000010
+	attrArchive   = 0x00000020
+)
+
+// Sizes used to report the file system layout
+const (
+	bytesPerSector  = 512
+	sectorsPerUnit  = 8
+	allocationUnit  = bytesPerSector * sectorsPerUnit
+	unknownCapacity = 1 << 50
+)
+
+// dirEntry is an entry in a directory listing
+type dirEntry struct {
+	name string
+	node vfs.Node
+}
+
+// fileAttributes returns the attributes of node
+func fileAttributes(node vfs.Node) uint32 {
+	if node.IsDir() {
+		return attrDirectory
+	}
+	attrs := uint32(attrArchive)
+	if node.VFS().Opt.ReadOnly {
+		attrs |= attrReadOnly
+	}
+	return attrs
+}
+
+// fileSize returns the end of file of node
+func fileSize(node vfs.Node) uint64 {
+	if node.IsDir() || node.Size() < 0 {
+		return 0
+	}
+	return uint64(node.Size())
+}
+
+// allocationSize returns the allocation size for size
+func allocationSize(size uint64) uint64 {
+	return (size + allocationUnit - 1) &^ (allocationUnit - 1)
+}
+
+// putTimes writes the creation, last access, last write and change
+// times of node into buf
+func putTimes(buf []byte, node vfs.Node) {
+	t := fileTime(node.ModTime())
+	for i := range 4 {
+		le.PutUint64(buf[8*i:], t)
+	}
+}
+
+// putNetworkOpen writes the times, sizes and attributes of node
+// into the first 52 bytes of buf as used by FileNetworkOpenInformation,
+// CREATE and CLOSE
+func putNetworkOpen(buf []byte, node vfs.Node) {
+	putTimes(buf, node)
+	size := fileSize(node)
+	le.PutUint64(buf[32:], allocationSize(size))
+	le.PutUint64(buf[40:], size)
+	le.PutUint32(buf[48:], fileAttributes(node))
+}
+
+// smbPath returns the path of name as the client sees it
+func smbPath(name string) []byte {
+	return encodeUTF16(`\` + strings.ReplaceAll(name, "/", `\`))
+}
+
+// fileInfo returns the file information class for f
+//
+// variable is set if the class has a variable length so can be
+// returned truncated.
+func fileInfo(class uint8, f *openFile, node vfs.Node) (data []byte, variable bool, err error) {
+	size := fileSize(node)
+	basic := func() []byte {
+		buf := make([]byte, 40)
+		putTimes(buf, node)
+		le.PutUint32(buf[32:], fileAttributes(node))
+		return buf
+	}
+	standard := func() []byte {
+		buf := make([]byte, 24)
+		le.PutUint64(buf, allocationSize(size))
+		le.PutUint64(buf[8:], size)
+		le.PutUint32(buf[16:], 1)
+		if f.deleteOnClose {
+			buf[20] = 1
+		}
+		if f.isDir {
+			buf[21] = 1
+		}
+		return buf
+	}
+	name := func() []byte {
+		name := smbPath(f.name)
+		return append(le.AppendUint32(nil, uint32(len(name))), name...)
+	}
+	switch class {
+	case 4: // FileBasicInformation
+		return basic(), false, nil
+	case 5: // FileStandardInformation
+		return standard(), false, nil
+	case 6: // FileInternalInformation
+		return le.AppendUint64(nil, node.Inode()), false, nil
+	case 7: // FileEaInformation
+		return make([]byte, 4), false, nil
+	case 8: // FileAccessInformation
+		return le.AppendUint32(nil, f.access), false, nil
+	case 9, 48: // FileNameInformation, FileNormalizedNameInformation
+		return name(), true, nil
+	case 14: // FilePositionInformation
+		return make([]byte, 8), false, nil
+	case 16, 17: // FileModeInformation, FileAlignmentInformation
+		return make([]byte, 4), false, nil
+	case 18: // FileAllInformation
+		data = append(basic(), standard()...)
+		data = le.AppendUint64(data, node.Inode())
+		data = le.AppendUint32(data, 0)
+		data = le.AppendUint32(data, f.access)
+		data = append(data, make([]byte, 16)...)
+		return append(data, name()...), true, nil
+	case 22: // FileStreamInformation
+		if f.isDir {
+			return []byte{}, true, nil
+		}
+		stream := encodeUTF16("::$DATA")
+		data = make([]byte, 24, 24+len(stream))
+		le.PutUint32(data[4:], uint32(len(stream)))
+		le.PutUint64(data[8:], size)
+		le.PutUint64(data[16:], allocationSize(size))
+		return append(data, stream...), true, nil
+	case 28: // FileCompressionInformation
+		data = make([]byte, 16)
+		le.PutUint64(data, size)
+		return data, false, nil
+	case 34: // FileNetworkOpenInformation
+		data = make([]byte, 56)
+		putNetworkOpen(data, node)
+		return data, false, nil
+	case 35: // FileAttributeTagInformation
+		return le.AppendUint32(make([]byte, 0, 8), fileAttributes(node))[:8], false, nil
+	}
+	return nil, false, statusInvalidInfoClass
+}
+
+// fsInfo returns the file system information class
+func fsInfo(class uint8, VFS *vfs.VFS, share string) (data []byte, variable bool, err error) {
+	usage := func() (total, free uint64) {
+		t, used, f := VFS.Statfs()
+		if t < 0 {
+			t = unknownCapacity
+		}
+		if f < 0 {
+			f = t
+			if used >= 0 && used <= t {
+				f = t - used
+			}
+		}
+		return uint64(t) / allocationUnit, uint64(f) / allocationUnit
+	}
+	switch class {
+	case 1: // FileFsVolumeInformation
+		label := encodeUTF16(share)
+		data = make([]byte, 18, 18+len(label))
+		le.PutUint32(data[8:], 0x52434c4e)
+		le.PutUint32(data[12:], uint32(len(label)))
+		return append(data, label...), true, nil
+	case 3: // FileFsSizeInformation
+		total, free := usage()
+		data = make([]byte, 24)
+		le.PutUint64(data, total)
+		le.PutUint64(data[8:], free)
+		le.PutUint32(data[16:], sectorsPerUnit)
+		le.PutUint32(data[20:], bytesPerSector)
+		return data, false, nil
+	case 4: // FileFsDeviceInformation
+		return le.AppendUint32(make([]byte, 0, 8), 0x7)[:8], false, nil // FILE_DEVICE_DISK
+	case 5: // FileFsAttributeInformation
+		name := encodeUTF16("NTFS")
+		attrs := uint32(0x00000002 | 0x00000004) // FILE_CASE_PRESERVED_NAMES | FILE_UNICODE_ON_DISK
+		if VFS.Opt.ReadOnly {
+			attrs |= 0x00080000 // FILE_READ_ONLY_VOLUME
+		}
+		data = make([]byte, 12, 12+len(name))
+		le.PutUint32(data, attrs)
+		le.PutUint32(data[4:], 255)
+		le.PutUint32(data[8:], uint32(len(name)))
+		return append(data, name...), true, nil
+	case 7: // FileFsFullSizeInformation
+		total, free := usage()
+		data = make([]byte, 32)
+		le.PutUint64(data, total)
+		le.PutUint64(data[8:], free)
+		le.PutUint64(data[16:], free)
+		le.PutUint32(data[24:], sectorsPerUnit)
+		le.PutUint32(data[28:], bytesPerSector)
+		return data, false, nil
+	case 11: // FileFsSectorSizeInformation
+		data = make([]byte, 28)
+		for i := range 4 {
+			le.PutUint32(data[4*i:], bytesPerSector)
+		}
+		return data, false, nil
+	}
+	return nil, false, statusInvalidInfoClass
+}
+
+// securityDescriptor returns a self relative security descriptor
+// giving Everyone full access with the parts asked for in addlInfo
+func securityDescriptor(addlInfo uint32, isDir bool) []byte {
+	everyone := []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0} // S-1-1-0
+	sd := make([]byte, 20)
+	sd[0] = 1                 // Revision
+	control := uint16(0x8000) // SE_SELF_RELATIVE
+	if addlInfo&0x1 != 0 {    // OWNER_SECURITY_INFORMATION
+		le.PutUint32(sd[4:], uint32(len(sd)))
+		sd = append(sd, everyone...)
+	}
+	if addlInfo&0x2 != 0 { // GROUP_SECURITY_INFORMATION
+		le.PutUint32(sd[8:], uint32(len(sd)))
+		sd = append(sd, everyone...)
+	}
+	if addlInfo&0x4 != 0 { // DACL_SECURITY_INFORMATION
+		control |= 0x0004 // SE_DACL_PRESENT
+		le.PutUint32(sd[16:], uint32(len(sd)))
+		aceSize := 8 + len(everyone)
+		acl := make([]byte, 8+aceSize)
+		acl[0] = 2 // ACL_REVISION
+		le.PutUint16(acl[2:], uint16(len(acl)))
+		le.PutUint16(acl[4:], 1)
+		ace := acl[8:]
+		ace[0] = 0 // ACCESS_ALLOWED_ACE_TYPE
+		if isDir {
+			ace[1] = 0x3 // OBJECT_INHERIT_ACE | CONTAINER_INHERIT_ACE
+		}
+		le.PutUint16(ace[2:], uint16(aceSize))
+		le.PutUint32(ace[4:], accessAll)
+		copy(ace[8:], everyone)
+		sd = append(sd, acl...)
+	}
+	le.PutUint16(sd[2:], control)
+	return sd
+}
+
+// dirInfoClasses are the information classes supported by QUERY_DIRECTORY
+var dirInfoClasses = map[uint8]bool{
+	1:  true, // FileDirectoryInformation
+	2:  true, // FileFullDirectoryInformation
+	3:  true, // FileBothDirectoryInformation
+	12: true, // FileNamesInformation
+	37: true, // FileIdBothDirectoryInformation
+	38: true, // FileIdFullDirectoryInformation
+}
+
+// encodeDirEntry encodes e for the directory information class
+//
+// The NextEntryOffset is left as 0 for the caller to fill in.
+func encodeDirEntry(class uint8, e dirEntry) []byte {
+	name := encodeUTF16(e.name)
+	if class == 12 {
+		buf := make([]byte, 12, 12+len(name))
+		le.PutUint32(buf[8:], uint32(len(name)))
+		return append(buf, name...)
+	}
+	var fixed int
+	switch class {
+	case 1:
+		fixed = 64
+	case 2:
+		fixed = 68
+	case 3:
+		fixed = 94
+	case 37:
+		fixed = 104
+	case 38:
+		fixed = 80
+	}
+	buf := make([]byte, fixed, fixed+len(name))
+	putTimes(buf[8:], e.node)
+	size := fileSize(e.node)
+	le.PutUint64(buf[40:], size)
+	le.PutUint64(buf[48:], allocationSize(size))
+	le.PutUint32(buf[56:], fileAttributes(e.node))
+	le.PutUint32(buf[60:], uint32(len(name)))
+	switch class {
+	case 37:
+		le.PutUint64(buf[96:], e.node.Inode())
+	case 38:
+		le.PutUint64(buf[72:], e.node.Inode())
+	}
+	return append(buf, name...)
+}
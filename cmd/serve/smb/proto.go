@@ -0,0 +1,264 @@
+package smb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/vfs"
+)
+
+var le = binary.LittleEndian
+
+// SMB2 commands
+const (
+	cmdNegotiate      = 0x0000
+	cmdSessionSetup   = 0x0001
+	cmdLogoff         = 0x0002
+	cmdTreeConnect    = 0x0003
+	cmdTreeDisconnect = 0x0004
+	cmdCreate         = 0x0005
+	cmdClose          = 0x0006
+	cmdFlush          = 0x0007
+	cmdRead           = 0x0008
+	cmdWrite          = 0x0009
+	cmdLock           = 0x000A
+	cmdIoctl          = 0x000B
+	cmdCancel         = 0x000C
+	cmdEcho           = 0x000D
+	cmdQueryDirectory = 0x000E
+	cmdChangeNotify   = 0x000F
+	cmdQueryInfo      = 0x0010
+	cmdSetInfo        = 0x0011
+	cmdOplockBreak    = 0x0012
+)
+
+// SMB2 header flags
+const (
+	flagServerToRedir = 0x00000001
+	flagAsync         = 0x00000002
+	flagRelated       = 0x00000004
+	flagSigned        = 0x00000008
+)
+
+// Dialects
+const (
+	dialect202    = 0x0202
+	dialect210    = 0x0210
+	dialect300    = 0x0300
+	dialect302    = 0x0302
+	dialect311    = 0x0311
+	dialectWild   = 0x02FF
+	dialectNone   = 0x0000
+	headerSize    = 64
+	maxIOSize     = 1024 * 1024
+	maxSmallIO    = 64 * 1024
+	maxFrameSize  = maxIOSize + 64*1024
+	maxCredits    = 512
+	signatureSize = 16
+)
+
+// Capabilities and security modes
+const (
+	capLargeMTU = 0x00000004
+
+	secModeSigningEnabled  = 0x0001
+	secModeSigningRequired = 0x0002
+
+	sessionFlagIsGuest = 0x0001
+	sessionFlagIsNull  = 0x0002
+)
+
+// ntStatus is an NT status code which can be returned as an error
+type ntStatus uint32
+
+// NT status codes
+const (
+	statusSuccess                = ntStatus(0x00000000)
+	statusPending                = ntStatus(0x00000103)
+	statusBufferOverflow         = ntStatus(0x80000005)
+	statusNoMoreFiles            = ntStatus(0x80000006)
+	statusNotImplemented         = ntStatus(0xC0000002)
+	statusInvalidInfoClass       = ntStatus(0xC0000003)
+	statusInfoLengthMismatch     = ntStatus(0xC0000004)
+	statusInvalidHandle          = ntStatus(0xC0000008)
+	statusInvalidParameter       = ntStatus(0xC000000D)
+	statusNoSuchFile             = ntStatus(0xC000000F)
+	statusInvalidDeviceRequest   = ntStatus(0xC0000010)
+	statusEndOfFile              = ntStatus(0xC0000011)
+	statusMoreProcessingRequired = ntStatus(0xC0000016)
+	statusAccessDenied           = ntStatus(0xC0000022)
+	statusBufferTooSmall         = ntStatus(0xC0000023)
+	statusObjectNameInvalid      = ntStatus(0xC0000033)
+	statusObjectNameNotFound     = ntStatus(0xC0000034)
+	statusObjectNameCollision    = ntStatus(0xC0000035)
+	statusObjectPathNotFound     = ntStatus(0xC000003A)
+	statusDeletePending          = ntStatus(0xC0000056)
+	statusLogonFailure           = ntStatus(0xC000006D)
+	statusDiskFull               = ntStatus(0xC000007F)
+	statusFileIsADirectory       = ntStatus(0xC00000BA)
+	statusNotSupported           = ntStatus(0xC00000BB)
+	statusNetworkNameDeleted     = ntStatus(0xC00000C9)
+	statusBadNetworkName         = ntStatus(0xC00000CC)
+	statusUnexpectedIOError      = ntStatus(0xC00000E9)
+	statusDirectoryNotEmpty      = ntStatus(0xC0000101)
+	statusNotADirectory          = ntStatus(0xC0000103)
+	statusFileClosed             = ntStatus(0xC0000128)
+	statusUserSessionDeleted     = ntStatus(0xC0000203)
+	statusNotFound               = ntStatus(0xC0000225)
+)
+
+// Error makes ntStatus satisfy the error interface
+func (s ntStatus) Error() string {
+	return fmt.Sprintf("NT status 0x%08X", uint32(s))
+}
+
+// isError returns true if the status is an error rather than a
+// warning or success
+func (s ntStatus) isError() bool {
+	return s>>30 == 3
+}
+
+// statusFromError converts err into the NT status to return to the client
+func statusFromError(err error) ntStatus {
+	var status ntStatus
+	switch {
+	case err == nil:
+		return statusSuccess
+	case errors.As(err, &status):
+		return status
+	case errors.Is(err, os.ErrNotExist):
+		return statusObjectNameNotFound
+	case errors.Is(err, os.ErrExist):
+		return statusObjectNameCollision
+	case errors.Is(err, vfs.ENOTEMPTY):
+		return statusDirectoryNotEmpty
+	case errors.Is(err, os.ErrPermission), errors.Is(err, vfs.EROFS):
+		return statusAccessDenied
+	case errors.Is(err, vfs.ENOSYS):
+		return statusNotSupported
+	case errors.Is(err, os.ErrInvalid):
+		return statusInvalidParameter
+	case errors.Is(err, os.ErrClosed), errors.Is(err, vfs.EBADF):
+		return statusFileClosed
+	case errors.Is(err, proxy.ErrorQuotaExceeded):
+		return statusDiskFull
+	}
+	return statusUnexpectedIOError
+}
+
+// header is an SMB2 packet header
+type header struct {
+	CreditCharge uint16
+	Status       ntStatus
+	Command      uint16
+	Credits      uint16
+	Flags        uint32
+	NextCommand  uint32
+	MessageID    uint64
+	AsyncID      uint64
+	TreeID       uint32
+	SessionID    uint64
+	Signature    [signatureSize]byte
+}
+
+var (
+	smb1ProtocolID = []byte{0xFF, 'S', 'M', 'B'}
+	smb2ProtocolID = []byte{0xFE, 'S', 'M', 'B'}
+)
+
+// parseHeader reads the SMB2 header from the start of buf
+func parseHeader(buf []byte) (h header, err error) {
+	if len(buf) < headerSize || string(buf[:4]) != string(smb2ProtocolID) || le.Uint16(buf[4:]) != headerSize {
+		return h, errors.New("invalid SMB2 header")
+	}
+	h.CreditCharge = le.Uint16(buf[6:])
+	h.Status = ntStatus(le.Uint32(buf[8:]))
+	h.Command = le.Uint16(buf[12:])
+	h.Credits = le.Uint16(buf[14:])
+	h.Flags = le.Uint32(buf[16:])
+	h.NextCommand = le.Uint32(buf[20:])
+	h.MessageID = le.Uint64(buf[24:])
+	if h.Flags&flagAsync != 0 {
+		h.AsyncID = le.Uint64(buf[32:])
+	} else {
+		h.TreeID = le.Uint32(buf[36:])
+	}
+	h.SessionID = le.Uint64(buf[40:])
+	copy(h.Signature[:], buf[48:64])
+	return h, nil
+}
+
+// encode writes the header into the start of buf
+func (h *header) encode(buf []byte) {
+	copy(buf, smb2ProtocolID)
+	le.PutUint16(buf[4:], headerSize)
+	le.PutUint16(buf[6:], h.CreditCharge)
+	le.PutUint32(buf[8:], uint32(h.Status))
+	le.PutUint16(buf[12:], h.Command)
+	le.PutUint16(buf[14:], h.Credits)
+	le.PutUint32(buf[16:], h.Flags)
+	le.PutUint32(buf[20:], h.NextCommand)
+	le.PutUint64(buf[24:], h.MessageID)
+	if h.Flags&flagAsync != 0 {
+		le.PutUint64(buf[32:], h.AsyncID)
+	} else {
+		le.PutUint32(buf[32:], 0)
+		le.PutUint32(buf[36:], h.TreeID)
+	}
+	le.PutUint64(buf[40:], h.SessionID)
+	copy(buf[48:64], h.Signature[:])
+}
+
+// fileTimeOffset is the number of 100ns intervals between 1601 and 1970
+const fileTimeOffset = 116444736000000000
+
+// fileTime converts t into a Windows FILETIME
+func fileTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100 + fileTimeOffset)
+}
+
+// timeFromFileTime converts a Windows FILETIME into a time.Time
+func timeFromFileTime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-fileTimeOffset)*100)
+}
+
+// encodeUTF16 encodes s as UTF-16LE
+func encodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	buf := make([]byte, 2*len(u))
+	for i, c := range u {
+		le.PutUint16(buf[2*i:], c)
+	}
+	return buf
+}
+
+// decodeUTF16 decodes the UTF-16LE in buf
+func decodeUTF16(buf []byte) string {
+	u := make([]uint16, len(buf)/2)
+	for i := range u {
+		u[i] = le.Uint16(buf[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// slice returns the length bytes at offset in buf or an error if
+// they are out of range
+func slice(buf []byte, offset, length int) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > len(buf) {
+		return nil, statusInvalidParameter
+	}
+	return buf[offset : offset+length], nil
+}
+
+// align8 rounds n up to a multiple of 8
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
@@ -0,0 +1,473 @@
+package smb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// server is an SMB server serving a VFS
+type server struct {
+	f         fs.Fs
+	ctx       context.Context // for global config
+	opt       Options
+	listener  net.Listener
+	globalVFS *vfs.VFS     // the VFS if not using auth proxy
+	proxy     *proxy.Proxy // may be nil if not in use
+	guid      [16]byte     // server GUID
+
+	mu         sync.Mutex
+	conns      map[*conn]struct{}
+	closing    bool
+	wg         sync.WaitGroup
+	nextID     uint64 // for session IDs
+	nextFileID uint64
+}
+
+// Make a new SMB server to serve the remote
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (*server, error) {
+	if opt.Share == "" || strings.ContainsAny(opt.Share, `\/:`) || strings.EqualFold(opt.Share, "IPC$") {
+		return nil, fmt.Errorf("invalid share name %q", opt.Share)
+	}
+	s := &server{
+		f:     f,
+		ctx:   ctx,
+		opt:   *opt,
+		conns: make(map[*conn]struct{}),
+	}
+	if proxyOpt.Enabled() {
+		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
+	} else {
+		s.globalVFS = vfs.New(ctx, f, vfsOpt)
+	}
+	if _, err := rand.Read(s.guid[:]); err != nil {
+		return nil, err
+	}
+	var idBytes [4]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, err
+	}
+	s.nextID = uint64(le.Uint32(idBytes[:])) << 16
+	var err error
+	s.listener, err = net.Listen("tcp", opt.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %q: %w", opt.ListenAddr, err)
+	}
+	return s, nil
+}
+
+// Serve runs the SMB server until it is shutdown
+func (s *server) Serve() error {
+	fs.Logf(s.f, "SMB server listening on %v, share %q", s.listener.Addr(), s.opt.Share)
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		c := &conn{
+			s:        s,
+			nc:       nc,
+			remote:   nc.RemoteAddr().String(),
+			sessions: make(map[uint64]*session),
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			_ = nc.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server, closing all the connections
+func (s *server) Shutdown() error {
+	s.mu.Lock()
+	s.closing = true
+	err := s.listener.Close()
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
+// newID returns a new session or file ID
+func (s *server) newID(p *uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*p++
+	return *p
+}
+
+// conn is a connection from a client
+type conn struct {
+	s           *server
+	nc          net.Conn
+	remote      string
+	dialect     uint16
+	clientGUID  [16]byte
+	clientCaps  uint32
+	preauthHash []byte // SMB 3.1.1 preauth integrity hash of the connection
+	sessions    map[uint64]*session
+	lastFileID  uint64 // the file ID for related compound requests
+}
+
+// session is an authenticated user on a connection
+type session struct {
+	id          uint64
+	valid       bool       // set once authenticated
+	auth        *authState // authentication in progress
+	user        string
+	VFS         *vfs.VFS
+	flags       uint16
+	signer      *signer // nil if not signing
+	preauthHash []byte
+	trees       map[uint32]*tree
+	nextTreeID  uint32
+	files       map[uint64]*openFile
+}
+
+// tree is a connection to a share
+type tree struct {
+	id  uint32
+	ipc bool // the IPC$ share
+}
+
+// request is an SMB2 request being processed
+type request struct {
+	header
+	msg  []byte // the whole message including the header
+	body []byte // the message after the header
+	sess *session
+	tree *tree
+}
+
+// response is a response to a request
+type response struct {
+	msg    []byte
+	signer *signer
+}
+
+// serve reads requests from the connection and replies to them
+func (c *conn) serve() {
+	fs.Debugf(c.remote, "SMB connection opened")
+	defer c.close()
+	for {
+		msg, err := c.readFrame()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(c.remote, "SMB read failed: %v", err)
+			}
+			return
+		}
+		out, err := c.handleFrame(msg)
+		if err != nil {
+			fs.Errorf(c.remote, "SMB protocol error: %v", err)
+			return
+		}
+		if out == nil {
+			continue
+		}
+		err = c.writeFrame(out)
+		if err != nil {
+			fs.Debugf(c.remote, "SMB write failed: %v", err)
+			return
+		}
+	}
+}
+
+// close closes all the sessions and the connection
+func (c *conn) close() {
+	for _, sess := range c.sessions {
+		c.closeSession(sess)
+	}
+	_ = c.nc.Close()
+	fs.Debugf(c.remote, "SMB connection closed")
+}
+
+// closeSession closes all the files and trees in the session
+func (c *conn) closeSession(sess *session) {
+	for _, f := range sess.files {
+		_ = f.close(sess)
+	}
+	delete(c.sessions, sess.id)
+}
+
+// readFrame reads a message from the Direct TCP transport
+func (c *conn) readFrame() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.nc, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, fmt.Errorf("unexpected NetBIOS message type 0x%02x", hdr[0])
+	}
+	n := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("message too large (%d bytes)", n)
+	}
+	msg := make([]byte, n)
+	_, err := io.ReadFull(c.nc, msg)
+	return msg, err
+}
+
+// writeFrame writes a message to the Direct TCP transport
+func (c *conn) writeFrame(msg []byte) error {
+	buf := make([]byte, 4, 4+len(msg))
+	buf[1], buf[2], buf[3] = byte(len(msg)>>16), byte(len(msg)>>8), byte(len(msg))
+	_, err := c.nc.Write(append(buf, msg...))
+	return err
+}
+
+// handleFrame processes all the requests in msg returning the
+// responses to send or nil if there are none.
+func (c *conn) handleFrame(msg []byte) ([]byte, error) {
+	if len(msg) >= 4 && string(msg[:4]) == string(smb1ProtocolID) {
+		return c.negotiateSMB1(msg)
+	}
+	var (
+		responses []response
+		prev      *request
+		prevErr   error
+	)
+	for {
+		h, err := parseHeader(msg)
+		if err != nil {
+			return nil, err
+		}
+		n := len(msg)
+		if h.NextCommand != 0 {
+			if h.NextCommand < headerSize || int(h.NextCommand) > len(msg) {
+				return nil, errors.New("invalid NextCommand in compound request")
+			}
+			n = int(h.NextCommand)
+		}
+		r := &request{header: h, msg: msg[:n], body: msg[headerSize:n]}
+		var body []byte
+		if h.Flags&flagRelated != 0 && prev != nil {
+			r.SessionID, r.TreeID = prev.SessionID, prev.TreeID
+			err = prevErr
+		}
+		if err == nil {
+			body, err = c.process(r)
+		}
+		if h.Command != cmdCancel {
+			responses = append(responses, c.makeResponse(r, body, err))
+		}
+		prev, prevErr = r, err
+		if h.NextCommand == 0 {
+			break
+		}
+		msg = msg[n:]
+	}
+	if len(responses) == 0 {
+		return nil, nil
+	}
+
+	// Join the responses together, signing them if required
+	var out []byte
+	for i, resp := range responses {
+		msg := resp.msg
+		if i < len(responses)-1 {
+			msg = append(msg, make([]byte, align8(len(msg))-len(msg))...)
+			le.PutUint32(msg[20:], uint32(len(msg)))
+		}
+		if resp.signer != nil {
+			resp.signer.sign(msg)
+		}
+		out = append(out, msg...)
+	}
+	return out, nil
+}
+
+// process finds the session and tree for the request and runs it,
+// returning the response body or an error
+func (c *conn) process(r *request) ([]byte, error) {
+	switch r.Command {
+	case cmdNegotiate:
+		return c.negotiate(r)
+	case cmdCancel:
+		return nil, nil
+	}
+	if c.dialect == dialectNone {
+		return nil, statusInvalidParameter
+	}
+	if r.Command == cmdSessionSetup {
+		return c.sessionSetup(r)
+	}
+
+	// Find the session and check the signature
+	r.sess = c.sessions[r.SessionID]
+	if r.sess == nil || !r.sess.valid {
+		r.sess = nil
+		if r.Command == cmdEcho {
+			return encodeEmpty(4), nil
+		}
+		return nil, statusUserSessionDeleted
+	}
+	// Signing is required except for guest and anonymous sessions
+	// which don't have a key to sign with
+	if r.sess.signer != nil {
+		if r.Flags&flagSigned == 0 {
+			fs.Errorf(c.remote, "SMB request without signature from user %q", r.sess.user)
+			return nil, statusAccessDenied
+		}
+		if !r.sess.signer.verify(r.msg) {
+			fs.Errorf(c.remote, "SMB request with bad signature from user %q", r.sess.user)
+			return nil, statusAccessDenied
+		}
+	}
+	if r.Command == cmdEcho {
+		return encodeEmpty(4), nil
+	}
+	if r.Command == cmdLogoff {
+		return c.logoff(r)
+	}
+	if r.Command == cmdTreeConnect {
+		return c.treeConnect(r)
+	}
+
+	// The rest of the commands need a tree
+	r.tree = r.sess.trees[r.TreeID]
+	if r.tree == nil {
+		return nil, statusNetworkNameDeleted
+	}
+	switch r.Command {
+	case cmdTreeDisconnect:
+		return c.treeDisconnect(r)
+	case cmdCreate:
+		return c.create(r)
+	case cmdClose:
+		return c.closeFile(r)
+	case cmdFlush:
+		return c.flush(r)
+	case cmdRead:
+		return c.read(r)
+	case cmdWrite:
+		return c.write(r)
+	case cmdLock:
+		return c.lock(r)
+	case cmdIoctl:
+		return c.ioctl(r)
+	case cmdQueryDirectory:
+		return c.queryDirectory(r)
+	case cmdQueryInfo:
+		return c.queryInfo(r)
+	case cmdSetInfo:
+		return c.setInfo(r)
+	case cmdChangeNotify, cmdOplockBreak:
+		return nil, statusNotSupported
+	}
+	return nil, statusNotImplemented
+}
+
+// makeResponse makes the response to r from the body and error returned
+func (c *conn) makeResponse(r *request, body []byte, err error) response {
+	status := statusFromError(err)
+	if status == statusUnexpectedIOError {
+		fs.Errorf(c.remote, "SMB command 0x%02x failed: %v", r.Command, err)
+	}
+	if body == nil {
+		body = encodeError(nil)
+	}
+	credits := r.Credits
+	if credits == 0 {
+		credits = 1
+	} else if credits > maxCredits {
+		credits = maxCredits
+	}
+	h := header{
+		CreditCharge: r.CreditCharge,
+		Status:       status,
+		Command:      r.Command,
+		Credits:      credits,
+		Flags:        flagServerToRedir | r.Flags&flagRelated,
+		MessageID:    r.MessageID,
+		TreeID:       r.TreeID,
+		SessionID:    r.SessionID,
+	}
+	msg := make([]byte, headerSize+len(body))
+	h.encode(msg)
+	copy(msg[headerSize:], body)
+
+	resp := response{msg: msg}
+	sess := r.sess
+	if sess != nil && sess.signer != nil {
+		if r.Flags&flagSigned != 0 || (r.Command == cmdSessionSetup && status == statusSuccess) {
+			resp.signer = sess.signer
+		}
+	}
+
+	// Keep the preauth integrity hash up to date
+	if c.dialect == dialect311 {
+		switch {
+		case r.Command == cmdNegotiate && status == statusSuccess:
+			c.preauthHash = preauthHash(preauthHash(make([]byte, sha512.Size), r.msg), msg)
+		case r.Command == cmdSessionSetup && status == statusMoreProcessingRequired && sess != nil:
+			sess.preauthHash = preauthHash(sess.preauthHash, msg)
+		}
+	}
+	return resp
+}
+
+// preauthHash returns the new preauth integrity hash after msg
+func preauthHash(prev, msg []byte) []byte {
+	h := sha512.New()
+	h.Write(prev)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// encodeEmpty returns a response body with just a StructureSize
+func encodeEmpty(size uint16) []byte {
+	body := make([]byte, size)
+	le.PutUint16(body, size)
+	return body
+}
+
+// encodeError returns an error response body with the error data
+func encodeError(data []byte) []byte {
+	body := make([]byte, 8, 9+len(data))
+	le.PutUint16(body, 9)
+	le.PutUint32(body[4:], uint32(len(data)))
+	if len(data) == 0 {
+		return append(body, 0)
+	}
+	return append(body, data...)
+}
@@ -0,0 +1,175 @@
+// Package smb implements an SMB2/3 server to serve a VFS remote
+package smb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// OptionsInfo descripts the Options in use
+var OptionsInfo = fs.Options{{
+	Name:    "addr",
+	Default: "localhost:1445",
+	Help:    "IPaddress:Port or :Port to bind server to",
+}, {
+	Name:    "user",
+	Default: "",
+	Help:    "User name for authentication (empty value allows guest access)",
+}, {
+	Name:    "pass",
+	Default: "",
+	Help:    "Password for authentication",
+}, {
+	Name:    "share",
+	Default: "rclone",
+	Help:    "Name of the share to serve the remote as",
+}}
+
+// Options contains options for the SMB Server
+type Options struct {
+	ListenAddr string `config:"addr"`  // Port to listen on
+	User       string `config:"user"`  // single username
+	Pass       string `config:"pass"`  // password for User
+	Share      string `config:"share"` // name of the share
+}
+
+// Opt is options set by command line flags
+var Opt Options
+
+// AddFlags adds flags for serve smb
+func AddFlags(flagSet *pflag.FlagSet) {
+	flags.AddFlagsFromOptions(flagSet, "", OptionsInfo)
+}
+
+func init() {
+	fs.RegisterGlobalOptions(fs.OptionsInfo{Name: "smb", Opt: &Opt, Options: OptionsInfo})
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	proxyflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags())
+	serve.Command.AddCommand(Command)
+	serve.AddRc("smb", func(ctx context.Context, f fs.Fs, in rc.Params) (serve.Handle, error) {
+		// Read VFS Opts
+		var vfsOpt = vfscommon.Opt // set default opts
+		err := configstruct.SetAny(in, &vfsOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read Proxy Opts
+		var proxyOpt = proxy.Opt // set default opts
+		err = configstruct.SetAny(in, &proxyOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read opts
+		var opt = Opt // set default opts
+		err = configstruct.SetAny(in, &opt)
+		if err != nil {
+			return nil, err
+		}
+		// Create server
+		return newServer(ctx, f, &opt, &vfsOpt, &proxyOpt)
+	})
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "smb remote:path",
+	Short: `Serve the remote over SMB.`,
+	Long: strings.ReplaceAll(`Run an SMB server to serve a remote over the SMB2 and SMB3
+protocols. This allows Windows, macOS and Linux machines to map the
+remote as a network drive without installing any extra software.
+
+The remote is served as a single share named by |--share| (default
+|rclone|), so on Windows it can be mapped with
+
+|||
+net use Z: \\hostname\rclone
+|||
+
+Listing the shares on the server isn't supported so the share name
+needs to be given explicitly.
+
+### Server options
+
+Use |--addr| to specify which IP address and port the server should
+listen on, e.g. |--addr 1.2.3.4:1445| or |--addr :1445| to listen to
+all IPs. By default it only listens on localhost. You can use port
+|:0| to let the OS choose an available port.
+
+Windows can only connect to SMB servers on port 445, so to serve
+Windows clients use |--addr :445|. This normally needs root or
+administrator privileges and the port must not be in use by the
+operating system's own SMB server. Other clients such as |smbclient|,
+macOS and Linux |mount.cifs| can use a different port.
+
+SMB clients expect to be able to write to the middle of files and to
+read files they have open for writing so you will normally need to use
+|--vfs-cache-mode writes| or |--vfs-cache-mode full|.
+
+Windows clients expect file names to be case insensitive so you may
+want to use |--vfs-case-insensitive| too.
+
+#### Authentication
+
+Clients authenticate with NTLMv2. You can set a single username and
+password with the |--user| and |--pass| flags.
+
+If |--user| isn't set then any client can log in as a guest. Note that
+recent versions of Windows refuse to connect to servers with guest
+access unless this is enabled in the group policy.
+
+The |--auth-proxy| flag may be used as described below, however the
+client's password is never sent to the server so the proxy must return
+the user's password in |_password| as described below.
+|--auth-home| can't be used with this server.
+
+#### Limitations
+
+The server implements the parts of the SMB2 and SMB3 protocols needed
+to use the remote as a network drive. In particular
+
+- Signing is required, except for guests, but encryption isn't supported
+- Byte range locks are accepted but not enforced
+- Oplocks, leases and change notifications aren't supported
+- Alternate data streams, extended attributes and security
+  descriptors can't be stored
+
+`, "|", "`") + strings.TrimSpace(vfs.Help()+proxy.Help),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.74",
+		"groups":            "Filter",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		var f fs.Fs
+		if !proxy.Opt.Enabled() {
+			cmd.CheckArgs(1, 1, command, args)
+			f = cmd.NewFsSrc(args)
+		} else {
+			cmd.CheckArgs(0, 0, command, args)
+		}
+		cmd.Run(false, false, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxy.Opt)
+			if err != nil {
+				return err
+			}
+			return s.Serve()
+		})
+	},
+}
@@ -0,0 +1,254 @@
+// Serve smb tests set up a server and check it against the go-smb2
+// client used by the smb backend.
+
+package smb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudsoda/go-smb2"
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/smb"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/servetest"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUSER = "rclone"
+	testPASS = "password"
+)
+
+// start runs a server serving dir and returns it
+func start(t *testing.T, dir string, opt Options) *server {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	opt.ListenAddr = "127.0.0.1:0"
+	if opt.Share == "" {
+		opt.Share = Opt.Share
+	}
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = vfscommon.CacheModeWrites
+	vfsOpt.WriteBack = 0
+	s, err := newServer(ctx, f, &opt, &vfsOpt, &proxy.Opt)
+	require.NoError(t, err)
+
+	quit := make(chan struct{})
+	go func() {
+		assert.NoError(t, s.Serve())
+		close(quit)
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+		<-quit
+	})
+	return s
+}
+
+// dial connects to the server at addr with the dialect given
+func dial(t *testing.T, addr, user, pass string, dialect uint16) (*smb2.Session, error) {
+	d := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     user,
+			Password: pass,
+		},
+		Negotiator: smb2.Negotiator{
+			SpecifiedDialect: dialect,
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return d.Dial(ctx, addr)
+}
+
+// TestSMB checks the file operations work with each dialect
+func TestSMB(t *testing.T) {
+	for _, dialect := range []uint16{dialect202, dialect210, dialect300, dialect302, dialect311} {
+		t.Run(fmt.Sprintf("%04x", dialect), func(t *testing.T) {
+			dir := t.TempDir()
+			s := start(t, dir, Options{User: testUSER, Pass: testPASS})
+			addr := s.Addr().String()
+
+			sess, err := dial(t, addr, testUSER, testPASS, dialect)
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, sess.Logoff())
+			}()
+			require.NoError(t, sess.Echo())
+
+			_, err = sess.Mount("potato")
+			assert.Error(t, err)
+
+			share, err := sess.Mount(Opt.Share)
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, share.Umount())
+			}()
+
+			// Directories
+			require.NoError(t, share.Mkdir("dir", 0777))
+			assert.Error(t, share.Mkdir("dir", 0777))
+			fi, err := share.Stat("dir")
+			require.NoError(t, err)
+			assert.True(t, fi.IsDir())
+
+			// Write and read back a file
+			data := []byte("hello world")
+			require.NoError(t, share.WriteFile(`dir\file.txt`, data, 0666))
+			got, err := share.ReadFile(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			fi, err = share.Stat(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.False(t, fi.IsDir())
+			assert.Equal(t, int64(len(data)), fi.Size())
+
+			// Write to the middle of the file
+			fd, err := share.OpenFile(`dir\file.txt`, os.O_RDWR, 0666)
+			require.NoError(t, err)
+			_, err = fd.WriteAt([]byte("WORLD"), 6)
+			require.NoError(t, err)
+			buf := make([]byte, 32)
+			n, err := fd.ReadAt(buf, 0)
+			require.NoError(t, err)
+			assert.Equal(t, "hello WORLD", string(buf[:n]))
+			require.NoError(t, fd.Close())
+
+			// A larger file over several reads and writes
+			big := make([]byte, 3*maxSmallIO+17)
+			for i := range big {
+				big[i] = byte(i * 7)
+			}
+			require.NoError(t, share.WriteFile("big.bin", big, 0666))
+			got, err = share.ReadFile("big.bin")
+			require.NoError(t, err)
+			assert.Equal(t, big, got)
+
+			// Truncate
+			require.NoError(t, share.Truncate(`dir\file.txt`, 5))
+			got, err = share.ReadFile(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(got))
+
+			// Modification times
+			mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+			require.NoError(t, share.Chtimes(`dir\file.txt`, mtime, mtime))
+			fi, err = share.Stat(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.True(t, mtime.Equal(fi.ModTime()), "want %v got %v", mtime, fi.ModTime())
+
+			// Listing
+			fis, err := share.ReadDir("")
+			require.NoError(t, err)
+			var names []string
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			sort.Strings(names)
+			assert.Equal(t, []string{"big.bin", "dir"}, names)
+
+			// Rename
+			require.NoError(t, share.Rename(`dir\file.txt`, `dir\renamed.txt`))
+			_, err = share.Stat(`dir\file.txt`)
+			assert.True(t, os.IsNotExist(err), err)
+			_, err = share.Stat(`dir\renamed.txt`)
+			require.NoError(t, err)
+
+			// Remove
+			assert.Error(t, share.Remove("dir"))
+			require.NoError(t, share.Remove(`dir\renamed.txt`))
+			require.NoError(t, share.Remove("dir"))
+			_, err = share.Stat("dir")
+			assert.True(t, os.IsNotExist(err), err)
+
+			// File system info
+			_, err = share.Statfs("")
+			require.NoError(t, err)
+
+			// Check the files landed on disk
+			s.globalVFS.WaitForWriters(10 * time.Second)
+			got, err = os.ReadFile(filepath.Join(dir, "big.bin"))
+			require.NoError(t, err)
+			assert.Equal(t, big, got)
+		})
+	}
+}
+
+// TestAuth checks bad passwords and guest access
+func TestAuth(t *testing.T) {
+	dir := t.TempDir()
+	addr := start(t, dir, Options{User: testUSER, Pass: testPASS}).Addr().String()
+
+	_, err := dial(t, addr, testUSER, "wrong", 0)
+	assert.Error(t, err)
+	_, err = dial(t, addr, "potato", testPASS, 0)
+	assert.Error(t, err)
+	_, err = dial(t, addr, "", "", 0)
+	assert.Error(t, err)
+
+	// Guest access when no user is set
+	addr = start(t, dir, Options{}).Addr().String()
+	sess, err := dial(t, addr, "", "", 0)
+	require.NoError(t, err)
+	share, err := sess.Mount(Opt.Share)
+	require.NoError(t, err)
+	require.NoError(t, share.WriteFile("guest.txt", []byte("guest"), 0666))
+	require.NoError(t, share.Umount())
+	require.NoError(t, sess.Logoff())
+}
+
+// TestBackend checks the smb backend can use the server
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("existing"), 0666))
+	s := start(t, dir, Options{User: testUSER, Pass: testPASS, Share: "share"})
+	addr := s.Addr().String()
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	remote := fmt.Sprintf(":smb,host=%s,port=%s,user=%s,pass=%s:share", host, port, testUSER, obscure.MustObscure(testPASS))
+	f, err := fs.NewFs(ctx, remote)
+	require.NoError(t, err)
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "existing.txt", entries[0].Remote())
+
+	_, err = operations.Rcat(ctx, f, "sub/new.txt", io.NopCloser(strings.NewReader("new file")), time.Now(), nil)
+	require.NoError(t, err)
+	s.globalVFS.WaitForWriters(10 * time.Second)
+	got, err := os.ReadFile(filepath.Join(dir, "sub", "new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new file", string(got))
+
+	require.NoError(t, operations.Purge(ctx, f, "sub"))
+	_, err = os.Stat(filepath.Join(dir, "sub"))
+	assert.True(t, os.IsNotExist(err), err)
+}
+
+func TestRc(t *testing.T) {
+	servetest.TestRc(t, rc.Params{
+		"type":           "smb",
+		"vfs_cache_mode": "off",
+	})
+}
@@ -0,0 +1,523 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/kv"
+	"golang.org/x/net/webdav"
+)
+
+// lockFacility is the name of the key-value database for the locks
+const lockFacility = "webdav-locks"
+
+// holdTimeout is how long a lock stays held for a request if the
+// server holding it never releases it, for example if it is killed
+const holdTimeout = time.Hour
+
+// lockFileRetries is how many times an update of the lock file is
+// tried if other servers keep changing it at the same time
+const lockFileRetries = 10
+
+// lockRecord is a lock as saved in a lockStore
+type lockRecord struct {
+	Token     string        `json:"token"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"` // negative for infinite
+	OwnerXML  string        `json:"owner"`
+	ZeroDepth bool          `json:"zeroDepth"`
+	Expiry    time.Time     `json:"expiry"` // zero if infinite
+	HeldBy    string        `json:"heldBy"` // ID of the request holding the lock, if any
+	Held      time.Time     `json:"held"`   // when the hold lapses
+}
+
+// held returns true if a request on any server is using the lock at
+// now
+func (r *lockRecord) held(now time.Time) bool {
+	return r.HeldBy != "" && now.Before(r.Held)
+}
+
+// details returns the webdav.LockDetails of the lock
+func (r *lockRecord) details() webdav.LockDetails {
+	return webdav.LockDetails{
+		Root:      r.Root,
+		Duration:  r.Duration,
+		OwnerXML:  r.OwnerXML,
+		ZeroDepth: r.ZeroDepth,
+	}
+}
+
+// setDuration sets the duration and expiry of the lock from now
+func (r *lockRecord) setDuration(now time.Time, duration time.Duration) {
+	r.Duration = duration
+	r.Expiry = time.Time{}
+	if duration >= 0 {
+		r.Expiry = now.Add(duration)
+	}
+}
+
+// covers returns true if the lock applies to name
+func (r *lockRecord) covers(name string) bool {
+	if name == r.Root {
+		return true
+	}
+	return !r.ZeroDepth && isParent(r.Root, name)
+}
+
+// isParent returns true if dir is a parent directory of name
+func isParent(dir, name string) bool {
+	return dir == "/" || strings.HasPrefix(name, dir+"/")
+}
+
+// lockMap is the locks indexed by token
+type lockMap map[string]*lockRecord
+
+// lockStore is somewhere locks can be kept so they outlive the server
+// and can be shared between servers.
+type lockStore interface {
+	// update calls fn with all the locks which it may alter. If
+	// fn returns true the locks are saved. This should be atomic
+	// as far as the store allows. fn may be called more than once
+	// if the update has to be retried.
+	update(fn func(locks lockMap) (changed bool, err error)) error
+
+	// close the store
+	close() error
+}
+
+// persistLS is a webdav.LockSystem which keeps the locks in a lockStore
+//
+// Locks held by Confirm for the duration of a request are marked as
+// held in the store so the other servers sharing it see them too.
+type persistLS struct {
+	store lockStore
+	mu    sync.Mutex
+}
+
+// newPersistLS makes a LockSystem keeping its locks in store
+func newPersistLS(store lockStore) *persistLS {
+	return &persistLS{
+		store: store,
+	}
+}
+
+// update calls fn with the unexpired locks, saving them if they have
+// changed or any have expired.
+//
+// Call with the mutex held.
+func (p *persistLS) update(now time.Time, fn func(locks lockMap) (changed bool, err error)) error {
+	return p.store.update(func(locks lockMap) (changed bool, err error) {
+		for token, r := range locks {
+			if !r.held(now) && !r.Expiry.IsZero() && !now.Before(r.Expiry) {
+				delete(locks, token)
+				changed = true
+			}
+		}
+		fnChanged, err := fn(locks)
+		return changed || fnChanged, err
+	})
+}
+
+// lookup returns the token of the lock on name matching one of the
+// conditions which isn't held or "" if there isn't one.
+//
+// Call with the mutex held.
+func (p *persistLS) lookup(locks lockMap, now time.Time, name string, conditions ...webdav.Condition) string {
+	for _, c := range conditions {
+		r := locks[c.Token]
+		if r == nil || r.held(now) {
+			continue
+		}
+		if r.covers(name) {
+			return c.Token
+		}
+	}
+	return ""
+}
+
+// Confirm confirms that the caller can claim all of the locks
+// specified by the given conditions.
+func (p *persistLS) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (release func(), err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var tokens []string
+	holdID := uuid.New().String()
+	err = p.update(now, func(locks lockMap) (bool, error) {
+		tokens = tokens[:0]
+		for _, name := range []string{name0, name1} {
+			if name == "" {
+				continue
+			}
+			token := p.lookup(locks, now, slashClean(name), conditions...)
+			if token == "" {
+				return false, webdav.ErrConfirmationFailed
+			}
+			if len(tokens) == 0 || tokens[0] != token {
+				tokens = append(tokens, token)
+			}
+		}
+		for _, token := range tokens {
+			locks[token].HeldBy = holdID
+			locks[token].Held = now.Add(holdTimeout)
+		}
+		return len(tokens) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		err := p.store.update(func(locks lockMap) (changed bool, err error) {
+			for _, token := range tokens {
+				// The hold may have lapsed and been taken by another request
+				if r := locks[token]; r != nil && r.HeldBy == holdID {
+					r.HeldBy = ""
+					r.Held = time.Time{}
+					changed = true
+				}
+			}
+			return changed, nil
+		})
+		if err != nil {
+			fs.Errorf(nil, "webdav: failed to release locks: %v", err)
+		}
+	}, nil
+}
+
+// canCreate returns true if a lock can be created on name
+func canCreate(locks lockMap, name string, zeroDepth bool) bool {
+	for _, r := range locks {
+		switch {
+		case r.Root == name:
+			// The target is already locked
+			return false
+		case !zeroDepth && isParent(name, r.Root):
+			// A descendant of the target is locked
+			return false
+		case !r.ZeroDepth && isParent(r.Root, name):
+			// A parent of the target is locked with infinite depth
+			return false
+		}
+	}
+	return true
+}
+
+// Create creates a lock with the given details
+func (p *persistLS) Create(now time.Time, details webdav.LockDetails) (token string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	details.Root = slashClean(details.Root)
+	err = p.update(now, func(locks lockMap) (bool, error) {
+		if !canCreate(locks, details.Root, details.ZeroDepth) {
+			return false, webdav.ErrLocked
+		}
+		r := &lockRecord{
+			Token:     "urn:uuid:" + uuid.New().String(),
+			Root:      details.Root,
+			OwnerXML:  details.OwnerXML,
+			ZeroDepth: details.ZeroDepth,
+		}
+		r.setDuration(now, details.Duration)
+		locks[r.Token] = r
+		token = r.Token
+		return true, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Refresh refreshes the lock with the given token.
+func (p *persistLS) Refresh(now time.Time, token string, duration time.Duration) (details webdav.LockDetails, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err = p.update(now, func(locks lockMap) (bool, error) {
+		r := locks[token]
+		if r == nil {
+			return false, webdav.ErrNoSuchLock
+		}
+		if r.held(now) {
+			return false, webdav.ErrLocked
+		}
+		r.setDuration(now, duration)
+		details = r.details()
+		return true, nil
+	})
+	return details, err
+}
+
+// Unlock unlocks the lock with the given token.
+func (p *persistLS) Unlock(now time.Time, token string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.update(now, func(locks lockMap) (bool, error) {
+		r := locks[token]
+		if r == nil {
+			return false, webdav.ErrNoSuchLock
+		}
+		if r.held(now) {
+			return false, webdav.ErrLocked
+		}
+		delete(locks, token)
+		return true, nil
+	})
+}
+
+// slashClean is equivalent to but slightly more efficient than
+// path.Clean("/" + name), as used by webdav.NewMemLS.
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+// kvLockStore keeps the locks in a key-value database in the cache
+// directory. Servers sharing a cache directory share the locks.
+type kvLockStore struct {
+	db *kv.DB
+}
+
+// newKVLockStore opens the lock database for f which may be nil
+func newKVLockStore(ctx context.Context, f fs.Fs) (*kvLockStore, error) {
+	if !kv.Supported() {
+		return nil, errors.New("--lock-store kv is not supported on this OS")
+	}
+	db, err := kv.Start(ctx, lockFacility, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock database: %w", err)
+	}
+	return &kvLockStore{db: db}, nil
+}
+
+// opUpdate: read the locks, update them and write back any changes
+type opUpdate struct {
+	fn func(locks lockMap) (changed bool, err error)
+}
+
+func (op *opUpdate) Do(ctx context.Context, b kv.Bucket) error {
+	locks := lockMap{}
+	err := b.ForEach(func(key, value []byte) error {
+		var r lockRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return fmt.Errorf("corrupted lock %q: %w", key, err)
+		}
+		locks[string(key)] = &r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	old := make(map[string]struct{}, len(locks))
+	for token := range locks {
+		old[token] = struct{}{}
+	}
+	changed, err := op.fn(locks)
+	if err != nil || !changed {
+		return err
+	}
+	for token := range old {
+		if locks[token] == nil {
+			if err := b.Delete([]byte(token)); err != nil {
+				return err
+			}
+		}
+	}
+	for token, r := range locks {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(token), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *kvLockStore) update(fn func(locks lockMap) (changed bool, err error)) error {
+	// Each update is done in a single transaction so is atomic
+	// between processes too
+	return s.db.Do(true, &opUpdate{fn: fn})
+}
+
+func (s *kvLockStore) close() error {
+	return s.db.Stop(false)
+}
+
+// remoteLockStore keeps the locks in a JSON file on a remote so they
+// can be shared by servers on different machines.
+//
+// Most remotes can't replace a file only if it hasn't changed, so
+// update checks the file is unchanged just before writing it and reads
+// it back afterwards, starting again if another server got in first.
+// This makes lost updates unlikely but can't rule them out entirely.
+type remoteLockStore struct {
+	ctx    context.Context
+	f      fs.Fs
+	remote string
+}
+
+// errLockFileChanged is returned if another server changed the lock
+// file while it was being updated
+var errLockFileChanged = errors.New("lock file changed by another server")
+
+// newRemoteLockStore keeps the locks in the file at fsPath
+func newRemoteLockStore(ctx context.Context, fsPath string) (*remoteLockStore, error) {
+	parent, leaf, err := fspath.Split(fsPath)
+	if err != nil {
+		return nil, fmt.Errorf("bad --lock-store: %w", err)
+	}
+	if leaf == "" {
+		return nil, fmt.Errorf("--lock-store %q must be a file", fsPath)
+	}
+	f, err := cache.Get(ctx, parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make --lock-store remote: %w", err)
+	}
+	return &remoteLockStore{ctx: ctx, f: f, remote: leaf}, nil
+}
+
+// readData reads the lock file returning a nil object if it doesn't
+// exist
+func (s *remoteLockStore) readData() (o fs.Object, data []byte, err error) {
+	o, err = s.f.NewObject(s.ctx, s.remote)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	in, err := o.Open(s.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	data, err = io.ReadAll(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o, data, nil
+}
+
+// unchanged returns errLockFileChanged if the lock file doesn't
+// contain data or no longer exists as it did
+func (s *remoteLockStore) unchanged(exists bool, data []byte) error {
+	o, newData, err := s.readData()
+	if err != nil {
+		return err
+	}
+	if (o != nil) != exists || !bytes.Equal(newData, data) {
+		return errLockFileChanged
+	}
+	return nil
+}
+
+// write the locks to the file read as o and data, returning
+// errLockFileChanged if another server changed it
+func (s *remoteLockStore) write(o fs.Object, data []byte, locks lockMap) error {
+	newData, err := json.MarshalIndent(locks, "", "\t")
+	if err != nil {
+		return err
+	}
+	// Check nobody has changed the file since it was read
+	if err = s.unchanged(o != nil, data); err != nil {
+		return err
+	}
+	src := object.NewStaticObjectInfo(s.remote, time.Now(), int64(len(newData)), true, nil, s.f)
+	if o == nil {
+		_, err = s.f.Put(s.ctx, bytes.NewReader(newData), src)
+	} else {
+		// Update rather than Put so remotes allowing duplicate
+		// names don't make a second file
+		err = o.Update(s.ctx, bytes.NewReader(newData), src)
+	}
+	if err != nil {
+		return err
+	}
+	// Check nobody replaced it at the same time as us
+	return s.unchanged(true, newData)
+}
+
+// tryUpdate reads the locks, calls fn and writes them back if they
+// have changed, returning errLockFileChanged if another server got in
+// first
+func (s *remoteLockStore) tryUpdate(fn func(locks lockMap) (changed bool, err error)) error {
+	o, data, err := s.readData()
+	if err != nil {
+		return fmt.Errorf("failed to read locks: %w", err)
+	}
+	locks := lockMap{}
+	if o != nil {
+		if err := json.Unmarshal(data, &locks); err != nil {
+			// It may have been read while another server was writing it
+			if changedErr := s.unchanged(true, data); changedErr != nil {
+				return changedErr
+			}
+			return fmt.Errorf("corrupted lock file %q: %w", s.remote, err)
+		}
+	}
+	changed, err := fn(locks)
+	if err != nil || !changed {
+		return err
+	}
+	if err = s.write(o, data, locks); err != nil {
+		return fmt.Errorf("failed to write locks: %w", err)
+	}
+	return nil
+}
+
+func (s *remoteLockStore) update(fn func(locks lockMap) (changed bool, err error)) error {
+	for try := 1; ; try++ {
+		err := s.tryUpdate(fn)
+		if !errors.Is(err, errLockFileChanged) || try >= lockFileRetries {
+			return err
+		}
+		fs.Debugf(s.f, "webdav: retrying update of %q: %v", s.remote, err)
+		time.Sleep(time.Duration(rand.Int64N(int64(try) * int64(50*time.Millisecond))))
+	}
+}
+
+func (s *remoteLockStore) close() error {
+	return nil
+}
+
+// newLockSystem makes the LockSystem for the --lock-store given for
+// serving f, which may be nil
+func newLockSystem(ctx context.Context, where string, f fs.Fs) (ls webdav.LockSystem, closeFn func() error, err error) {
+	var store lockStore
+	switch where {
+	case "", "memory":
+		return webdav.NewMemLS(), func() error { return nil }, nil
+	case "kv":
+		store, err = newKVLockStore(ctx, f)
+	default:
+		store, err = newRemoteLockStore(ctx, where)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return newPersistLS(store), store.close, nil
+}
+
+// check interfaces
+var (
+	_ webdav.LockSystem = (*persistLS)(nil)
+	_ lockStore         = (*kvLockStore)(nil)
+	_ lockStore         = (*remoteLockStore)(nil)
+)
@@ -0,0 +1,188 @@
+package webdav
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// testLockSystem checks the semantics of ls
+func testLockSystem(t *testing.T, ls webdav.LockSystem) {
+	now := time.Now()
+
+	// Lock a directory with infinite depth
+	dirToken, err := ls.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	require.NoError(t, err)
+	assert.NotEqual(t, "", dirToken)
+
+	// Things inside it can't be locked
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/dir/file", Duration: -1, ZeroDepth: true})
+	assert.Equal(t, webdav.ErrLocked, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/", Duration: -1})
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	// But other things can
+	fileToken, err := ls.Create(now, webdav.LockDetails{Root: "file", Duration: -1, ZeroDepth: true, OwnerXML: "<owner/>"})
+	require.NoError(t, err)
+	assert.NotEqual(t, dirToken, fileToken)
+
+	// Confirm needs the right token
+	_, err = ls.Confirm(now, "/file", "", webdav.Condition{Token: dirToken})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	release, err := ls.Confirm(now, "/dir/a", "/dir/b", webdav.Condition{Token: dirToken})
+	require.NoError(t, err)
+
+	// A held lock can't be used again, refreshed or unlocked
+	_, err = ls.Confirm(now, "/dir/a", "", webdav.Condition{Token: dirToken})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	_, err = ls.Refresh(now, dirToken, time.Minute)
+	assert.Equal(t, webdav.ErrLocked, err)
+	assert.Equal(t, webdav.ErrLocked, ls.Unlock(now, dirToken))
+	release()
+
+	// Refresh extends the lock
+	details, err := ls.Refresh(now, fileToken, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, webdav.LockDetails{Root: "/file", Duration: time.Hour, ZeroDepth: true, OwnerXML: "<owner/>"}, details)
+	_, err = ls.Refresh(now, "potato", time.Hour)
+	assert.Equal(t, webdav.ErrNoSuchLock, err)
+
+	// Locks expire
+	later := now.Add(2 * time.Minute)
+	_, err = ls.Confirm(later, "/dir/a", "", webdav.Condition{Token: dirToken})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(later, dirToken))
+	release, err = ls.Confirm(later, "/file", "", webdav.Condition{Token: fileToken})
+	require.NoError(t, err)
+	release()
+
+	// Unlock removes the lock
+	require.NoError(t, ls.Unlock(later, fileToken))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(later, fileToken))
+	rootToken, err := ls.Create(later, webdav.LockDetails{Root: "/", Duration: -1})
+	require.NoError(t, err)
+	require.NoError(t, ls.Unlock(later, rootToken))
+}
+
+// testSharedLockSystem checks ls and ls2 sharing a store see each
+// other's locks
+func testSharedLockSystem(t *testing.T, ls, ls2 webdav.LockSystem) {
+	now := time.Now()
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/shared", Duration: -1})
+	require.NoError(t, err)
+	_, err = ls2.Create(now, webdav.LockDetails{Root: "/shared/file", Duration: -1})
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	// A lock held for a request on one server is held on the other
+	release, err := ls.Confirm(now, "/shared/file", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	_, err = ls2.Confirm(now, "/shared/file", "", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	_, err = ls2.Refresh(now, token, time.Hour)
+	assert.Equal(t, webdav.ErrLocked, err)
+	assert.Equal(t, webdav.ErrLocked, ls2.Unlock(now, token))
+	release()
+
+	// Unless the server holding it never lets go
+	release, err = ls.Confirm(now, "/shared/file", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	later := now.Add(holdTimeout + time.Minute)
+	release2, err := ls2.Confirm(later, "/shared/file", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	release() // doesn't release the other server's hold
+	assert.Equal(t, webdav.ErrLocked, ls.Unlock(later, token))
+	release2()
+
+	// The lock can be unlocked on the other server
+	require.NoError(t, ls2.Unlock(now, token))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, token))
+}
+
+func TestMemoryLockSystem(t *testing.T) {
+	ls, closeFn, err := newLockSystem(context.Background(), "memory", nil)
+	require.NoError(t, err)
+	testLockSystem(t, ls)
+	require.NoError(t, closeFn())
+}
+
+func TestRemoteLockSystem(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "locks.json")
+	ls, closeFn, err := newLockSystem(ctx, path, nil)
+	require.NoError(t, err)
+	testLockSystem(t, ls)
+	require.NoError(t, closeFn())
+
+	// The locks are still there after a restart
+	now := time.Now()
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/kept", Duration: time.Hour})
+	require.NoError(t, err)
+	ls2, _, err := newLockSystem(ctx, path, nil)
+	require.NoError(t, err)
+	_, err = ls2.Create(now, webdav.LockDetails{Root: "/kept", Duration: time.Hour})
+	assert.Equal(t, webdav.ErrLocked, err)
+	release, err := ls2.Confirm(now, "/kept", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	release()
+	require.NoError(t, ls2.Unlock(now, token))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, token))
+	testSharedLockSystem(t, ls, ls2)
+
+	// An update made by another server during an update isn't lost
+	calls := 0
+	var otherToken string
+	err = ls.(*persistLS).store.update(func(locks lockMap) (bool, error) {
+		calls++
+		if calls == 1 {
+			otherToken, err = ls2.Create(now, webdav.LockDetails{Root: "/other", Duration: time.Hour})
+			require.NoError(t, err)
+		}
+		locks["mine"] = &lockRecord{Token: "mine", Root: "/mine", Duration: -1}
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	require.NoError(t, ls.Unlock(now, otherToken))
+	require.NoError(t, ls2.Unlock(now, "mine"))
+
+	// A corrupted lock file is an error
+	require.NoError(t, os.WriteFile(path, []byte("{potato"), 0600))
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/file", Duration: time.Hour})
+	assert.ErrorContains(t, err, "corrupted lock file")
+
+	_, _, err = newLockSystem(ctx, t.TempDir()+"/", nil)
+	assert.Error(t, err)
+}
+
+func TestKVLockSystem(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported")
+	}
+	ctx := context.Background()
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() { _ = config.SetCacheDir(oldCacheDir) }()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	ls, closeFn, err := newLockSystem(ctx, "kv", f)
+	require.NoError(t, err)
+	testLockSystem(t, ls)
+
+	// A second server on the same remote sees the locks
+	ls2, closeFn2, err := newLockSystem(ctx, "kv", f)
+	require.NoError(t, err)
+	testSharedLockSystem(t, ls, ls2)
+
+	require.NoError(t, closeFn2())
+	require.NoError(t, closeFn())
+}
@@ -0,0 +1,220 @@
+package webdav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// propsMetadataKey is the metadata key the dead properties are stored in
+	propsMetadataKey = "webdav-props"
+
+	// sidecarPrefix starts the names of the files the dead
+	// properties are stored in if they can't be stored as metadata
+	sidecarPrefix = ".rclone-webdav-props"
+)
+
+// computedProps are the dead properties made by DeadProps which
+// shouldn't be stored
+var computedProps = map[xml.Name]struct{}{
+	{Space: "http://owncloud.org/ns", Local: "checksums"}: {},
+	{Space: "DAV:", Local: "lastmodified"}:                {},
+}
+
+// deadProp is a dead property as stored
+type deadProp struct {
+	Space string `json:"space"`
+	Local string `json:"local"`
+	Lang  string `json:"lang,omitempty"`
+	XML   string `json:"xml"`
+}
+
+// encodeProps encodes props for storage
+func encodeProps(props map[xml.Name]webdav.Property) (string, error) {
+	if len(props) == 0 {
+		return "", nil
+	}
+	list := make([]deadProp, 0, len(props))
+	for name, prop := range props {
+		list = append(list, deadProp{
+			Space: name.Space,
+			Local: name.Local,
+			Lang:  prop.Lang,
+			XML:   string(prop.InnerXML),
+		})
+	}
+	data, err := json.Marshal(list)
+	return string(data), err
+}
+
+// decodeProps decodes props encoded with encodeProps into props
+func decodeProps(props map[xml.Name]webdav.Property, data string) error {
+	if data == "" {
+		return nil
+	}
+	var list []deadProp
+	if err := json.Unmarshal([]byte(data), &list); err != nil {
+		return err
+	}
+	for _, p := range list {
+		name := xml.Name{Space: p.Space, Local: p.Local}
+		props[name] = webdav.Property{
+			XMLName:  name,
+			Lang:     p.Lang,
+			InnerXML: []byte(p.XML),
+		}
+	}
+	return nil
+}
+
+// sidecarPath returns the path of the sidecar file for the node at name
+func sidecarPath(name string) string {
+	dir, leaf := path.Split(strings.Trim(name, "/"))
+	if leaf == "" {
+		return sidecarPrefix
+	}
+	return dir + sidecarPrefix + "." + leaf
+}
+
+// isSidecar returns true if name is the path of a sidecar file
+func isSidecar(name string) bool {
+	return strings.HasPrefix(path.Base(name), sidecarPrefix)
+}
+
+// metadataSetter returns the entry of node if its dead properties
+// can be stored in its metadata
+func metadataSetter(node vfs.Node) (fs.SetMetadataer, bool) {
+	features := node.VFS().Fs().Features()
+	entry := node.DirEntry()
+	switch entry.(type) {
+	case fs.Object:
+		if !features.UserMetadata {
+			return nil, false
+		}
+	case fs.Directory:
+		if !features.UserDirMetadata {
+			return nil, false
+		}
+	default:
+		// Not uploaded yet or the root
+		return nil, false
+	}
+	setter, ok := entry.(fs.SetMetadataer)
+	return setter, ok
+}
+
+// loadDeadProps reads the stored dead properties of the handle
+func (h Handle) loadDeadProps() (map[xml.Name]webdav.Property, error) {
+	node := h.Handle.Node()
+	props := make(map[xml.Name]webdav.Property)
+	if _, ok := metadataSetter(node); ok {
+		metadata, err := fs.GetMetadata(h.ctx, node.DirEntry())
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeProps(props, metadata[propsMetadataKey]); err != nil {
+			fs.Errorf(node.Path(), "Ignoring corrupted WebDAV properties in metadata: %v", err)
+		}
+	}
+	// Properties which couldn't be stored as metadata
+	data, err := node.VFS().ReadFile(sidecarPath(node.Path()))
+	if errors.Is(err, os.ErrNotExist) {
+		return props, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := decodeProps(props, string(data)); err != nil {
+		fs.Errorf(node.Path(), "Ignoring corrupted WebDAV properties file: %v", err)
+	}
+	return props, nil
+}
+
+// saveDeadProps stores the dead properties of the handle
+//
+// They are stored as metadata if possible otherwise in the sidecar
+func (h Handle) saveDeadProps(props map[xml.Name]webdav.Property) error {
+	node := h.Handle.Node()
+	VFS := node.VFS()
+	data, err := encodeProps(props)
+	if err != nil {
+		return err
+	}
+	sidecar := sidecarPath(node.Path())
+	if setter, ok := metadataSetter(node); ok {
+		err = setter.SetMetadata(h.ctx, fs.Metadata{propsMetadataKey: data})
+		if err != nil {
+			return err
+		}
+		// Remove any properties saved before it could take metadata
+		err = VFS.Remove(sidecar)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	if data == "" {
+		err = VFS.Remove(sidecar)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	return VFS.WriteFile(sidecar, []byte(data), 0666)
+}
+
+// patchDeadProps applies the patches to the stored dead properties
+func (h Handle) patchDeadProps(patches []webdav.Proppatch) error {
+	props, err := h.loadDeadProps()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			if _, computed := computedProps[prop.XMLName]; computed {
+				continue
+			}
+			if patch.Remove {
+				delete(props, prop.XMLName)
+			} else {
+				props[prop.XMLName] = prop
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return h.saveDeadProps(props)
+}
+
+// moveSidecar moves the sidecar of oldName to newName, removing any
+// sidecar newName had.
+func moveSidecar(VFS *vfs.VFS, oldName, newName string) error {
+	oldSidecar, newSidecar := sidecarPath(oldName), sidecarPath(newName)
+	err := VFS.Rename(oldSidecar, newSidecar)
+	if errors.Is(err, os.ErrNotExist) {
+		err = VFS.Remove(newSidecar)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return err
+}
+
+// removeSidecar removes the sidecar of name if it has one
+func removeSidecar(VFS *vfs.VFS, name string) error {
+	err := VFS.Remove(sidecarPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestSidecarPath(t *testing.T) {
+	assert.Equal(t, ".rclone-webdav-props", sidecarPath(""))
+	assert.Equal(t, ".rclone-webdav-props", sidecarPath("/"))
+	assert.Equal(t, ".rclone-webdav-props.file.txt", sidecarPath("/file.txt"))
+	assert.Equal(t, "dir/.rclone-webdav-props.sub", sidecarPath("dir/sub/"))
+	assert.True(t, isSidecar("dir/.rclone-webdav-props.sub"))
+	assert.False(t, isSidecar("dir/file.txt"))
+}
+
+func TestEncodeProps(t *testing.T) {
+	name := xml.Name{Space: "urn:test", Local: "colour"}
+	props := map[xml.Name]webdav.Property{
+		name: {XMLName: name, Lang: "en", InnerXML: []byte("<b>red</b>")},
+	}
+	data, err := encodeProps(props)
+	require.NoError(t, err)
+	got := map[xml.Name]webdav.Property{}
+	require.NoError(t, decodeProps(got, data))
+	assert.Equal(t, props, got)
+
+	data, err = encodeProps(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", data)
+}
+
+// davRequest makes a WebDAV request returning the status and body
+func davRequest(t *testing.T, method, u, body string, headers ...string) (int, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	require.NoError(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+const (
+	setColour = `<?xml version="1.0"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:x="urn:test">
+  <D:set><D:prop><x:colour>red</x:colour></D:prop></D:set>
+</D:propertyupdate>`
+	removeColour = `<?xml version="1.0"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:x="urn:test">
+  <D:remove><D:prop><x:colour/></D:prop></D:remove>
+</D:propertyupdate>`
+	findColour = `<?xml version="1.0"?>
+<D:propfind xmlns:D="DAV:" xmlns:x="urn:test">
+  <D:prop><x:colour/></D:prop>
+</D:propfind>`
+)
+
+// colour returns the colour property of u or "" if not set
+func colour(t *testing.T, u string) string {
+	status, body := davRequest(t, "PROPFIND", u, findColour, "Depth", "0")
+	require.Equal(t, http.StatusMultiStatus, status, body)
+	var ms struct {
+		Propstat []struct {
+			Status string `xml:"status"`
+			Colour string `xml:"prop>colour"`
+		} `xml:"response>propstat"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(body), &ms))
+	for _, ps := range ms.Propstat {
+		if strings.Contains(ps.Status, "200") {
+			return ps.Colour
+		}
+	}
+	return ""
+}
+
+func TestDeadProps(t *testing.T) {
+	for _, remote := range []string{"local", "memory"} {
+		t.Run(remote, func(t *testing.T) {
+			ctx := context.Background()
+			root := t.TempDir()
+			if remote == "memory" {
+				root = ":memory:" + random.String(8)
+			}
+			f, err := fs.NewFs(ctx, root)
+			require.NoError(t, err)
+
+			opt := Opt
+			opt.HTTP.ListenAddr = []string{testBindAddress}
+			opt.DeadProps = true
+			w, err := newWebDAV(ctx, f, &opt, &vfscommon.Opt, &proxy.Opt)
+			require.NoError(t, err)
+			go func() {
+				require.NoError(t, w.Serve())
+			}()
+			defer func() { assert.NoError(t, w.Shutdown()) }()
+			testURL := w.server.URLs()[0]
+
+			status, _ := davRequest(t, "PUT", testURL+"file.txt", "hello")
+			require.Equal(t, http.StatusCreated, status)
+			assert.Equal(t, "", colour(t, testURL+"file.txt"))
+
+			// Set the property on a file and the root
+			for _, u := range []string{testURL + "file.txt", testURL} {
+				status, body := davRequest(t, "PROPPATCH", u, setColour)
+				require.Equal(t, http.StatusMultiStatus, status, body)
+				assert.Equal(t, "red", colour(t, u))
+			}
+
+			// The sidecars can't be seen
+			status, body := davRequest(t, "PROPFIND", testURL, "", "Depth", "1")
+			require.Equal(t, http.StatusMultiStatus, status)
+			assert.NotContains(t, body, sidecarPrefix)
+			status, _ = davRequest(t, "GET", testURL+sidecarPrefix, "")
+			assert.Equal(t, http.StatusNotFound, status)
+
+			// Properties move and are copied with the file
+			status, _ = davRequest(t, "MOVE", testURL+"file.txt", "", "Destination", testURL+"moved.txt")
+			require.Equal(t, http.StatusCreated, status)
+			assert.Equal(t, "red", colour(t, testURL+"moved.txt"))
+			status, _ = davRequest(t, "COPY", testURL+"moved.txt", "", "Destination", testURL+"copied.txt")
+			require.Equal(t, http.StatusCreated, status)
+			assert.Equal(t, "red", colour(t, testURL+"copied.txt"))
+
+			// A new file with the same name doesn't get them
+			status, _ = davRequest(t, "PUT", testURL+"file.txt", "hello")
+			require.Equal(t, http.StatusCreated, status)
+			assert.Equal(t, "", colour(t, testURL+"file.txt"))
+
+			// Properties can be removed
+			status, body = davRequest(t, "PROPPATCH", testURL+"moved.txt", removeColour)
+			require.Equal(t, http.StatusMultiStatus, status, body)
+			assert.Equal(t, "", colour(t, testURL+"moved.txt"))
+
+			// Deleting removes them
+			status, _ = davRequest(t, "DELETE", testURL+"copied.txt", "")
+			require.Equal(t, http.StatusNoContent, status)
+			_, err = w._vfs.Stat(sidecarPath("copied.txt"))
+			assert.Error(t, err)
+
+			// Only the root properties are left in a sidecar
+			entries, err := w._vfs.ReadDir("")
+			require.NoError(t, err)
+			var sidecars []string
+			for _, entry := range entries {
+				if isSidecar(entry.Name()) {
+					sidecars = append(sidecars, entry.Name())
+				}
+			}
+			if remote == "local" && f.Features().UserMetadata {
+				assert.Equal(t, []string{sidecarPrefix}, sidecars)
+			}
+		})
+	}
+}
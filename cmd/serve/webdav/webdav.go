@@ -49,6 +49,14 @@ var OptionsInfo = fs.Options{{
 	Name:    "disable_zip",
 	Default: false,
 	Help:    "Disable zip download of directories",
+}, {
+	Name:    "lock_store",
+	Default: "memory",
+	Help:    "Where to keep locks: memory, kv or a file on a remote",
+}, {
+	Name:    "dead_props",
+	Default: false,
+	Help:    "Store properties set with PROPPATCH as metadata",
 }}.
 	Add(libhttp.ConfigInfo).
 	Add(libhttp.AuthConfigInfo).
@@ -62,6 +70,8 @@ type Options struct {
 	EtagHash       string `config:"etag_hash"`
 	DisableDirList bool   `config:"disable_dir_list"`
 	DisableZip     bool   `config:"disable_zip"`
+	LockStore      string `config:"lock_store"`
+	DeadProps      bool   `config:"dead_props"`
 }
 
 // Opt is options set by command line flags
@@ -122,6 +132,56 @@ supported hash on the backend or you can use a named hash such as
 "MD5" or "SHA-1". Use the [hashsum](/commands/rclone_hashsum/) command
 to see the full list.
 
+#### --lock-store
+
+This controls where the locks taken by clients with LOCK are kept.
+Office applications and LibreOffice lock files while they are being
+edited so they need these to work. It can be set to
+
+- ` + "`memory`" + ` - the default, the locks are lost when the server stops
+- ` + "`kv`" + ` - the locks are kept in a database in the cache directory
+- ` + "`remote:path/file`" + ` - the locks are kept in a JSON file on a remote
+
+With ` + "`kv`" + ` the locks survive a restart and are shared by all
+the servers using the same ` + "`--cache-dir`" + ` on one machine, as
+the database is locked while it is being updated.
+
+To share the locks between servers on different machines, for example
+replicas behind a load balancer, keep them in a file on a remote
+which all the servers can reach. A lock taken on one server can then
+be used, refreshed or unlocked on any of them. The file is read and
+written for each lock operation and each request using a lock, so this
+is slower. Before writing the file a server checks nobody else has
+changed it and afterwards reads it back, retrying if another server
+got in first. As most remotes can't replace a file only if it is
+unchanged, two servers writing it at exactly the same moment can still
+lose an update, so prefer a remote with fast, consistent reads. Don't
+keep the file inside the remote being served as it will be visible to
+clients.
+
+While a server is using a lock for a request it can't be refreshed or
+unlocked by the other servers. If a server stops in the middle of a
+request, the other servers can use its lock again after an hour.
+
+#### --dead-props
+
+By default properties set by clients with PROPPATCH are thrown away,
+other than the modification time. With this flag they are stored and
+returned in PROPFIND, which clients such as CalDAV and CardDAV clients
+use to store tags, colours and ETags of their own.
+
+The properties are stored in the ` + "`webdav-props`" + ` user metadata of
+the file or directory on backends which support it (see the
+[overview](/overview/#metadata)). Otherwise, or if the file hasn't
+been uploaded yet, they are stored in a hidden sidecar file named
+` + "`.rclone-webdav-props.NAME`" + ` next to it (` + "`.rclone-webdav-props`" + `
+for the root) which is moved and deleted with the file. The sidecar
+files can't be seen or accessed through the server.
+
+This makes PROPFIND slower as the metadata of each file listed has
+to be read, which may need an extra request per file on some
+backends.
+
 ### Gzip compression
 
 The server will compress certain response bodies (text and XML, including
@@ -202,7 +262,7 @@ the local backend only.
 	},
 	RunE: func(command *cobra.Command, args []string) error {
 		var f fs.Fs
-		if proxy.Opt.AuthProxy == "" {
+		if !proxy.Opt.Enabled() {
 			cmd.CheckArgs(1, 1, command, args)
 			f = cmd.NewFsSrc(args)
 		} else {
@@ -241,6 +301,7 @@ type WebDAV struct {
 	proxy         *proxy.Proxy
 	ctx           context.Context // for global config
 	etagHashType  hash.Type
+	closeLocks    func() error // close the lock store
 }
 
 func webDAVCompressMiddleware() func(http.Handler) http.Handler {
@@ -279,7 +340,7 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	if w.etagHashType != hash.None {
 		fs.Debugf(f, "Using hash %v for ETag", w.etagHashType)
 	}
-	if proxyOpt.AuthProxy != "" {
+	if proxyOpt.Enabled() {
 		w.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// override auth
 		w.opt.Auth.CustomAuthFn = w.auth
@@ -299,10 +360,16 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	// Make sure BaseURL starts with a / and doesn't end with one
 	w.opt.HTTP.BaseURL = "/" + strings.Trim(w.opt.HTTP.BaseURL, "/")
 
+	lockSystem, closeLocks, err := newLockSystem(ctx, w.opt.LockStore, f)
+	if err != nil {
+		return nil, err
+	}
+	w.closeLocks = closeLocks
+
 	webdavHandler := &webdav.Handler{
 		Prefix:     w.opt.HTTP.BaseURL,
 		FileSystem: w,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: lockSystem,
 		Logger:     w.logRequest, // FIXME
 	}
 	w.webdavhandler = webdavHandler
@@ -508,7 +575,11 @@ func (w *WebDAV) Addr() net.Addr {
 
 // Shutdown the server
 func (w *WebDAV) Shutdown() error {
-	return w.server.Shutdown()
+	err := w.server.Shutdown()
+	if closeErr := w.closeLocks(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // logRequest is called by the webdav module on every request
@@ -519,6 +590,9 @@ func (w *WebDAV) logRequest(r *http.Request, err error) {
 // Mkdir creates a directory
 func (w *WebDAV) Mkdir(ctx context.Context, name string, perm os.FileMode) (err error) {
 	// defer log.Trace(name, "perm=%v", perm)("err = %v", &err)
+	if w.hidden(name) {
+		return os.ErrPermission
+	}
 	VFS, err := w.getVFS(ctx)
 	if err != nil {
 		return err
@@ -534,10 +608,20 @@ func (w *WebDAV) Mkdir(ctx context.Context, name string, perm os.FileMode) (err
 // OpenFile opens a file or a directory
 func (w *WebDAV) OpenFile(ctx context.Context, name string, flags int, perm os.FileMode) (file webdav.File, err error) {
 	// defer log.Trace(name, "flags=%v, perm=%v", flags, perm)("err = %v", &err)
+	if w.hidden(name) {
+		return nil, os.ErrNotExist
+	}
 	VFS, err := w.getVFS(ctx)
 	if err != nil {
 		return nil, err
 	}
+	// PROPPATCH opens directories for writing which the VFS
+	// doesn't allow, but their properties can be set read only
+	if flags == os.O_RDWR {
+		if node, err := VFS.Stat(name); err == nil && node.IsDir() {
+			flags = os.O_RDONLY
+		}
+	}
 	f, err := VFS.OpenFile(name, flags, perm)
 	if err != nil {
 		return nil, err
@@ -548,6 +632,9 @@ func (w *WebDAV) OpenFile(ctx context.Context, name string, flags int, perm os.F
 // RemoveAll removes a file or a directory and its contents
 func (w *WebDAV) RemoveAll(ctx context.Context, name string) (err error) {
 	// defer log.Trace(name, "")("err = %v", &err)
+	if w.hidden(name) {
+		return os.ErrNotExist
+	}
 	VFS, err := w.getVFS(ctx)
 	if err != nil {
 		return err
@@ -560,22 +647,38 @@ func (w *WebDAV) RemoveAll(ctx context.Context, name string) (err error) {
 	if err != nil {
 		return err
 	}
+	if w.opt.DeadProps {
+		return removeSidecar(VFS, name)
+	}
 	return nil
 }
 
 // Rename a file or a directory
 func (w *WebDAV) Rename(ctx context.Context, oldName, newName string) (err error) {
 	// defer log.Trace(oldName, "newName=%q", newName)("err = %v", &err)
+	if w.hidden(oldName) || w.hidden(newName) {
+		return os.ErrNotExist
+	}
 	VFS, err := w.getVFS(ctx)
 	if err != nil {
 		return err
 	}
-	return VFS.Rename(oldName, newName)
+	err = VFS.Rename(oldName, newName)
+	if err != nil {
+		return err
+	}
+	if w.opt.DeadProps {
+		return moveSidecar(VFS, oldName, newName)
+	}
+	return nil
 }
 
 // Stat returns info about the file or directory
 func (w *WebDAV) Stat(ctx context.Context, name string) (fi os.FileInfo, err error) {
 	// defer log.Trace(name, "")("fi=%+v, err = %v", &fi, &err)
+	if w.hidden(name) {
+		return nil, os.ErrNotExist
+	}
 	VFS, err := w.getVFS(ctx)
 	if err != nil {
 		return nil, err
@@ -587,6 +690,12 @@ func (w *WebDAV) Stat(ctx context.Context, name string) (fi os.FileInfo, err err
 	return FileInfo{FileInfo: fi, w: w}, nil
 }
 
+// hidden returns true if name is used internally so can't be seen
+// by clients
+func (w *WebDAV) hidden(name string) bool {
+	return w.opt.DeadProps && isSidecar(name)
+}
+
 // Handle represents an open file
 type Handle struct {
 	vfs.Handle
@@ -601,10 +710,15 @@ func (h Handle) Readdir(count int) (fis []os.FileInfo, err error) {
 		return nil, err
 	}
 	// Wrap each FileInfo
-	for i := range fis {
-		fis[i] = FileInfo{FileInfo: fis[i], w: h.w}
+	j := 0
+	for _, fi := range fis {
+		if h.w.hidden(fi.Name()) {
+			continue
+		}
+		fis[j] = FileInfo{FileInfo: fi, w: h.w}
+		j++
 	}
-	return fis, nil
+	return fis[:j], nil
 }
 
 // Stat the handle
@@ -623,6 +737,13 @@ func (h Handle) DeadProps() (map[xml.Name]webdav.Property, error) {
 		property   webdav.Property
 		properties = make(map[xml.Name]webdav.Property)
 	)
+	if h.w.opt.DeadProps {
+		var err error
+		properties, err = h.loadDeadProps()
+		if err != nil {
+			return nil, err
+		}
+	}
 	if h.w.etagHashType != hash.None {
 		entry := h.Handle.Node().DirEntry()
 		if o, ok := entry.(fs.Object); ok {
@@ -652,7 +773,11 @@ func (h Handle) DeadProps() (map[xml.Name]webdav.Property, error) {
 	return properties, nil
 }
 
-// Patch changes modtime of the underlying resources, it returns ok for all properties, the error is from setModtime if any
+// Patch changes modtime of the underlying resources and stores any
+// other properties if --dead-props is set, it returns ok for all
+// properties, the error is from setModtime or storing the properties
+// if any
+//
 // FIXME does not check for invalid property and SetModTime error
 func (h Handle) Patch(proppatches []webdav.Proppatch) ([]webdav.Propstat, error) {
 	var (
@@ -672,6 +797,9 @@ func (h Handle) Patch(proppatches []webdav.Proppatch) ([]webdav.Propstat, error)
 			}
 		}
 	}
+	if h.w.opt.DeadProps && err == nil {
+		err = h.patchDeadProps(proppatches)
+	}
 	return []webdav.Propstat{stat}, err
 }
 
@@ -71,7 +71,8 @@ type Account struct {
 	withBuf  bool          // is using a buffered in
 	checking bool          // set if attached transfer is checking
 
-	tokenBucket buckets // per file bandwidth limiter (may be nil)
+	tokenBucket buckets    // per file bandwidth limiter (may be nil)
+	bwLimiter   *BwLimiter // bandwidth limiter from the context (may be nil)
 
 	values accountValues
 }
@@ -116,6 +117,7 @@ func newAccountSizeName(ctx context.Context, stats *StatsInfo, in io.ReadCloser,
 		fs.Debugf(acc.name, "Limiting file transfer to %v", currLimit.Bandwidth)
 		acc.tokenBucket = newTokenBucket(currLimit.Bandwidth)
 	}
+	acc.bwLimiter = GetBwLimiter(ctx)
 
 	go acc.averageLoop()
 	stats.inProgress.set(acc.name, acc)
@@ -370,6 +372,9 @@ func (acc *Account) accountRead(n int) {
 	acc.accountReadN(int64(n))
 
 	TokenBucket.LimitBandwidth(TokenBucketSlotAccounting, n)
+	if acc.bwLimiter != nil {
+		acc.bwLimiter.limitBandwidth(n)
+	}
 	acc.limitPerFileBandwidth(n)
 }
 
@@ -0,0 +1,98 @@
+package accounting
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/time/rate"
+)
+
+// BwLimiter limits the combined bandwidth of all the transfers made
+// with a context it has been attached to with WithBwLimiter.
+//
+// This applies in addition to the global --bwlimit and can be changed
+// while transfers are in progress.
+type BwLimiter struct {
+	mu        sync.RWMutex
+	bandwidth fs.SizeSuffix
+	tb        *rate.Limiter
+}
+
+// NewBwLimiter makes a new BwLimiter limiting to bandwidth bytes/s.
+//
+// If bandwidth is <= 0 then it doesn't limit until SetBwLimit is
+// called.
+func NewBwLimiter(bandwidth fs.SizeSuffix) *BwLimiter {
+	l := &BwLimiter{}
+	l.SetBwLimit(bandwidth)
+	return l
+}
+
+// SetBwLimit sets the bandwidth limit in bytes/s, disabling it if
+// bandwidth is <= 0
+func (l *BwLimiter) SetBwLimit(bandwidth fs.SizeSuffix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bandwidth > 0 {
+		l.bandwidth = bandwidth
+		l.tb = newEmptyTokenBucket(bandwidth)
+	} else {
+		l.bandwidth = -1
+		l.tb = nil
+	}
+}
+
+// BwLimit returns the current bandwidth limit in bytes/s or -1 if
+// there isn't one
+func (l *BwLimiter) BwLimit() fs.SizeSuffix {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.bandwidth
+}
+
+// limitBandwidth sleeps for the correct amount of time for the
+// passage of n bytes according to the current bandwidth limit
+func (l *BwLimiter) limitBandwidth(n int) {
+	l.mu.RLock()
+	tb := l.tb
+	l.mu.RUnlock()
+	if tb != nil {
+		err := tb.WaitN(context.Background(), n)
+		if err != nil {
+			fs.Errorf(nil, "Token bucket error: %v", err)
+		}
+	}
+}
+
+// Type of key used to store the BwLimiter in a context
+type bwLimiterContextKeyType struct{}
+
+// Key used to store the BwLimiter in a context
+var bwLimiterContextKey = bwLimiterContextKeyType{}
+
+// WithBwLimiter returns a copy of ctx whose transfers will be limited
+// by l
+func WithBwLimiter(ctx context.Context, l *BwLimiter) context.Context {
+	return context.WithValue(ctx, bwLimiterContextKey, l)
+}
+
+// GetBwLimiter returns the BwLimiter attached to ctx or nil if there
+// isn't one
+func GetBwLimiter(ctx context.Context) *BwLimiter {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(bwLimiterContextKey).(*BwLimiter)
+	return l
+}
+
+// CopyBwLimiter copies the BwLimiter (if any) from srcCtx into
+// dstCtx returning the new context.
+func CopyBwLimiter(dstCtx, srcCtx context.Context) context.Context {
+	l := GetBwLimiter(srcCtx)
+	if l == nil {
+		return dstCtx
+	}
+	return WithBwLimiter(dstCtx, l)
+}
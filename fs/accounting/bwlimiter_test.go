@@ -0,0 +1,72 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBwLimiterContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, GetBwLimiter(ctx))
+	assert.Nil(t, GetBwLimiter(nil)) //nolint:staticcheck // we want to test passing a nil Context
+
+	l := NewBwLimiter(1024)
+	ctx = WithBwLimiter(ctx, l)
+	assert.Equal(t, l, GetBwLimiter(ctx))
+
+	newCtx := CopyBwLimiter(context.Background(), ctx)
+	assert.Equal(t, l, GetBwLimiter(newCtx))
+	newCtx = CopyBwLimiter(context.Background(), context.Background())
+	assert.Nil(t, GetBwLimiter(newCtx))
+}
+
+func TestBwLimiterSetBwLimit(t *testing.T) {
+	l := NewBwLimiter(0)
+	assert.Equal(t, fs.SizeSuffix(-1), l.BwLimit())
+	assert.Nil(t, l.tb)
+
+	l.SetBwLimit(1024 * 1024)
+	assert.Equal(t, fs.SizeSuffix(1024*1024), l.BwLimit())
+	assert.NotNil(t, l.tb)
+
+	l.SetBwLimit(-1)
+	assert.Equal(t, fs.SizeSuffix(-1), l.BwLimit())
+	assert.Nil(t, l.tb)
+}
+
+func TestBwLimiterAccount(t *testing.T) {
+	timeRead := func(ctx context.Context, n int) time.Duration {
+		stats := NewStats(ctx)
+		in := io.NopCloser(bytes.NewBuffer(make([]byte, n)))
+		acc := newAccountSizeName(ctx, stats, in, int64(n), "test")
+		defer func() {
+			require.NoError(t, acc.Close())
+		}()
+		start := time.Now()
+		_, err := io.Copy(io.Discard, acc)
+		require.NoError(t, err)
+		return time.Since(start)
+	}
+
+	t.Run("Off", func(t *testing.T) {
+		ctx := WithBwLimiter(context.Background(), NewBwLimiter(-1))
+		dt := timeRead(ctx, 1024*1024)
+		assert.Less(t, dt, 500*time.Millisecond)
+	})
+
+	t.Run("On", func(t *testing.T) {
+		// The token bucket starts empty so reading 1 MiB at 2 MiB/s
+		// should take about half a second
+		ctx := WithBwLimiter(context.Background(), NewBwLimiter(2*1024*1024))
+		dt := timeRead(ctx, 1024*1024)
+		assert.Greater(t, dt, 400*time.Millisecond)
+		assert.Less(t, dt, 5*time.Second)
+	})
+}
@@ -79,6 +79,22 @@ func (dt DirTree) Find(filePath string) (parentPath string, entry fs.DirEntry) {
 	return parentPath, nil
 }
 
+// Remove removes the entry for filePath from its parent and returns
+// it, or nil if not found.
+//
+// If the entry is a directory its contents are left in the tree.
+func (dt DirTree) Remove(filePath string) fs.DirEntry {
+	parentPath := parentDir(filePath)
+	entries := dt[parentPath]
+	for i, entry := range entries {
+		if entry.Remote() == filePath {
+			dt[parentPath] = append(entries[:i:i], entries[i+1:]...)
+			return entry
+		}
+	}
+	return nil
+}
+
 // checkParent checks that dirPath has a *Dir in its parent
 //
 // If dirs is not nil it must contain entries for every *Dir found in
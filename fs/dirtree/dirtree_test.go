@@ -99,6 +99,24 @@ func TestDirTreeFind(t *testing.T) {
 	assert.Equal(t, o, foundObj)
 }
 
+func TestDirTreeRemove(t *testing.T) {
+	dt := New()
+	o1 := mockobject.New("dir/potato")
+	o2 := mockobject.New("dir/sausage")
+	dt.Add(o1)
+	dt.Add(o2)
+
+	assert.Nil(t, dt.Remove("dir/beans"))
+	assert.Equal(t, o1, dt.Remove("dir/potato"))
+	assert.Equal(t, `dir/
+  sausage
+`, dt.String())
+	assert.Nil(t, dt.Remove("dir/potato"))
+	assert.Equal(t, o2, dt.Remove("dir/sausage"))
+	assert.Equal(t, `dir/
+`, dt.String())
+}
+
 func TestDirTreeCheckParent(t *testing.T) {
 	dt := New()
 
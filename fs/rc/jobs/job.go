@@ -43,8 +43,13 @@ type Job struct {
 	Success   bool      `json:"success"`
 	Duration  float64   `json:"duration"`
 	Output    rc.Params `json:"output"`
+	Queued    bool      `json:"queued"`             // set if waiting for a slot to run in
+	Progress  rc.Params `json:"progress,omitempty"` // stats of a job being saved to the job store
 	Stop      func()    `json:"-"`
 	listeners []*func()
+	path      string    // rc path of the job if it can be saved
+	input     rc.Params // parameters the job was started with if it can be saved
+	start     func()    // starts the job if it is queued
 
 	// realErr is the Error before printing it as a string, it's used to return
 	// the real error to the upper application layers while still printing the
@@ -92,6 +97,14 @@ func (job *Job) removeListener(fn *func()) {
 	}
 }
 
+// Err returns the error the job finished with or nil if it succeeded
+// or hasn't finished
+func (job *Job) Err() error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.realErr
+}
+
 // OnFinish adds listener to job that will be triggered when job is finished.
 // It returns a function to cancel listening.
 func (job *Job) OnFinish(fn func()) func() {
@@ -115,12 +128,21 @@ func (job *Job) run(ctx context.Context, fn rc.Func, in rc.Params) {
 	job.finish(fn(ctx, in))
 }
 
+// To avoid circular dependencies this is filled in by fs/rc/schedule
+var (
+	// ScheduleList for internal use only
+	ScheduleList func() any
+)
+
 // Jobs describes a collection of running tasks
 type Jobs struct {
 	mu            sync.RWMutex
 	jobs          map[int64]*Job
 	opt           *rc.Options
 	expireRunning bool
+	active        int    // number of async jobs running
+	queue         []*Job // async jobs waiting to run in FIFO order
+	store         *store // saves async jobs if set
 }
 
 var (
@@ -169,6 +191,7 @@ func (jobs *Jobs) Expire() {
 		job.mu.Lock()
 		if job.Finished && now.Sub(job.EndTime) > time.Duration(jobs.opt.JobExpireDuration) {
 			delete(jobs.jobs, ID)
+			jobs.store.remove(job)
 		}
 		job.mu.Unlock()
 	}
@@ -192,6 +215,8 @@ func (jobs *Jobs) IDs() (IDs []int64) {
 }
 
 // Stats returns the IDs of the running and finished jobs
+//
+// Queued jobs are in neither.
 func (jobs *Jobs) Stats() (running []int64, finished []int64) {
 	jobs.mu.RLock()
 	defer jobs.mu.RUnlock()
@@ -200,13 +225,25 @@ func (jobs *Jobs) Stats() (running []int64, finished []int64) {
 	for jobID := range jobs.jobs {
 		if jobs.jobs[jobID].Finished {
 			finished = append(finished, jobID)
-		} else {
+		} else if !jobs.jobs[jobID].Queued {
 			running = append(running, jobID)
 		}
 	}
 	return running, finished
 }
 
+// Queued returns the IDs of the jobs waiting to run in the order
+// they will be run
+func (jobs *Jobs) Queued() (queued []int64) {
+	jobs.mu.RLock()
+	defer jobs.mu.RUnlock()
+	queued = []int64{}
+	for _, job := range jobs.queue {
+		queued = append(queued, job.ID)
+	}
+	return queued
+}
+
 // Get a job with a given ID or nil if it doesn't exist
 func (jobs *Jobs) Get(ID int64) *Job {
 	jobs.mu.RLock()
@@ -284,8 +321,20 @@ var jobKey = jobKeyType{}
 
 // NewJob creates a Job and executes it, possibly in the background if _async is set
 func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Job, out rc.Params, err error) {
-	id := jobID.Add(1)
-	in = in.Copy() // copy input so we can change it
+	return jobs.newJob(ctx, 0, "", fn, in)
+}
+
+// newJob creates a Job and executes it, possibly in the background if
+// _async is set.
+//
+// If id is 0 a new ID is allocated. If path is set then async jobs are
+// saved to the job store so they can be resumed.
+func (jobs *Jobs) newJob(ctx context.Context, id int64, path string, fn rc.Func, in rc.Params) (job *Job, out rc.Params, err error) {
+	if id == 0 {
+		id = jobID.Add(1)
+	}
+	input := in.Copy() // the parameters before the special ones are removed
+	in = in.Copy()     // copy input so we can change it
 
 	ctx, isAsync, err := getAsync(ctx, in)
 	if err != nil {
@@ -310,6 +359,8 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 	ctx, cancel := context.WithCancel(ctx)
 	stop := func() {
 		cancel()
+		// Take the job out of the queue if it hasn't started
+		go jobs.unqueue(job)
 		// Wait for cancel to propagate before returning.
 		<-ctx.Done()
 	}
@@ -322,6 +373,10 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 	}
 
 	jobs.mu.Lock()
+	if isAsync && path != "" && jobs.store != nil {
+		job.path = path
+		job.input = input
+	}
 	jobs.jobs[job.ID] = job
 	jobs.mu.Unlock()
 
@@ -329,7 +384,9 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 	ctx = context.WithValue(ctx, jobKey, job)
 
 	if isAsync {
-		go job.run(ctx, fn, in)
+		jobs.startAsync(ctx, job, func() {
+			job.run(ctx, fn, in)
+		})
 		out = make(rc.Params)
 		out["jobid"] = job.ID
 		out["executeId"] = job.ExecuteID
@@ -342,12 +399,105 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 	return job, out, err
 }
 
+// startAsync runs job in the background using run, or queues it if
+// the maximum number of async jobs are running already
+func (jobs *Jobs) startAsync(ctx context.Context, job *Job, run func()) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	s := jobs.store
+	job.mu.Lock()
+	job.start = func() {
+		s.save(job)
+		stopProgress := s.saveProgress(ctx, job)
+		run()
+		stopProgress()
+		s.save(job)
+		jobs.done()
+	}
+	queue := jobs.opt.MaxJobs > 0 && jobs.active >= jobs.opt.MaxJobs
+	if queue {
+		job.Queued = true
+		job.StartTime = time.Time{}
+	}
+	job.mu.Unlock()
+	if queue {
+		jobs.queue = append(jobs.queue, job)
+		s.save(job)
+		return
+	}
+	jobs.launch(job)
+}
+
+// launch starts an async job in the background
+//
+// Call with jobs.mu held
+func (jobs *Jobs) launch(job *Job) {
+	jobs.active++
+	job.mu.Lock()
+	if job.Queued {
+		job.Queued = false
+		job.StartTime = time.Now()
+	}
+	start := job.start
+	job.start = nil
+	job.mu.Unlock()
+	go start()
+}
+
+// done is called when an async job finishes to start the next
+// queued jobs
+func (jobs *Jobs) done() {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.active--
+	for len(jobs.queue) > 0 && (jobs.opt.MaxJobs <= 0 || jobs.active < jobs.opt.MaxJobs) {
+		job := jobs.queue[0]
+		jobs.queue = jobs.queue[1:]
+		jobs.launch(job)
+	}
+}
+
+// unqueue removes a stopped job from the queue if it is there,
+// marking it as finished
+func (jobs *Jobs) unqueue(job *Job) {
+	jobs.mu.Lock()
+	s := jobs.store
+	i := slices.Index(jobs.queue, job)
+	if i >= 0 {
+		jobs.queue = slices.Delete(jobs.queue, i, i+1)
+	}
+	jobs.mu.Unlock()
+	if i < 0 {
+		return
+	}
+	job.mu.Lock()
+	job.Queued = false
+	job.StartTime = time.Now()
+	job.start = nil
+	job.mu.Unlock()
+	job.finish(nil, context.Canceled)
+	s.save(job)
+}
+
 // NewJob creates a Job and executes it on the global job queue,
 // possibly in the background if _async is set
 func NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Job, out rc.Params, err error) {
 	return running.NewJob(ctx, fn, in)
 }
 
+// NewCallJob creates a Job running call and executes it on the global
+// job queue, possibly in the background if _async is set.
+//
+// Unlike NewJob, async jobs made with this are saved to the job store
+// if --rc-job-persist is set so they can be resumed after a restart.
+func NewCallJob(ctx context.Context, call *rc.Call, in rc.Params) (job *Job, out rc.Params, err error) {
+	path := call.Path
+	if call.NeedsRequest || call.NeedsResponse {
+		path = ""
+	}
+	return running.newJob(ctx, 0, path, call.Fn, in)
+}
+
 // OnFinish adds listener to jobid that will be triggered when job is finished.
 // It returns a function to cancel listening.
 func OnFinish(jobID int64, fn func()) (func(), error) {
@@ -395,6 +545,7 @@ Results:
 - startTime - time the job started (e.g. "2018-10-26T18:50:20.528336039+01:00")
 - success - boolean - true for success false otherwise
 - output - output of the job as would have been returned if called synchronously
+- queued - boolean - true if the job is waiting to run because of --rc-max-jobs
 - progress - output of the progress related to the underlying job
 `,
 	})
@@ -431,9 +582,11 @@ func init() {
 Results:
 
 - executeId - string id of rclone executing (change after restart)
-- jobids - array of integer job ids (starting at 1 on each restart)
+- jobids - array of integer job ids (starting at 1 on each restart unless --rc-job-persist is set)
 - runningIds - array of integer job ids that are running
+- queuedIds - array of integer job ids waiting to run in the order they will run
 - finishedIds - array of integer job ids that are finished
+- schedules - array of the scheduled commands with the status of their last run, without their params, if the scheduler is running - see schedule/list
 `,
 	})
 }
@@ -444,8 +597,12 @@ func rcJobList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	out["jobids"] = running.IDs()
 	runningIDs, finishedIDs := running.Stats()
 	out["runningIds"] = runningIDs
+	out["queuedIds"] = running.Queued()
 	out["finishedIds"] = finishedIDs
 	out["executeId"] = executeID
+	if ScheduleList != nil {
+		out["schedules"] = ScheduleList()
+	}
 	return out, nil
 }
 
@@ -550,7 +707,7 @@ func NewJobFromParams(ctx context.Context, in rc.Params) (out rc.Params) {
 	}
 
 	fs.Debugf(nil, "rc: %q: with parameters %+v", path, in)
-	_, out, err = NewJob(ctx, call.Fn, in)
+	_, out, err = NewCallJob(ctx, call, in)
 	if err != nil {
 		return rcError(err, http.StatusInternalServerError)
 	}
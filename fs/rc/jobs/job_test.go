@@ -911,3 +911,65 @@ func TestJobsBatchConcurrent(t *testing.T) {
 	}
 
 }
+
+func TestJobsMaxJobs(t *testing.T) {
+	ctx := context.Background()
+	jobs := newJobs()
+	jobs.opt = &rc.Options{
+		MaxJobs:           1,
+		JobExpireDuration: fs.Duration(time.Hour),
+		JobExpireInterval: fs.Duration(time.Hour),
+	}
+	started := make(chan int64, 3)
+	release := make(chan struct{})
+	fn := func(ctx context.Context, in rc.Params) (rc.Params, error) {
+		id, _ := GetJobID(ctx)
+		started <- id
+		select {
+		case <-release:
+			return rc.Params{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	job1, _, err := jobs.NewJob(ctx, fn, rc.Params{"_async": true})
+	require.NoError(t, err)
+	job2, _, err := jobs.NewJob(ctx, fn, rc.Params{"_async": true})
+	require.NoError(t, err)
+	job3, _, err := jobs.NewJob(ctx, fn, rc.Params{"_async": true})
+	require.NoError(t, err)
+
+	assert.Equal(t, job1.ID, <-started)
+	assert.Equal(t, []int64{job2.ID, job3.ID}, jobs.Queued())
+	running, _ := jobs.Stats()
+	assert.Equal(t, []int64{job1.ID}, running)
+	job2.mu.Lock()
+	assert.True(t, job2.Queued)
+	assert.True(t, job2.StartTime.IsZero())
+	job2.mu.Unlock()
+
+	// Stopping a queued job removes it from the queue
+	job3.Stop()
+	finished := make(chan struct{})
+	job3.OnFinish(func() { close(finished) })
+	<-finished
+	assert.Equal(t, []int64{job2.ID}, jobs.Queued())
+	job3.mu.Lock()
+	assert.Equal(t, "context canceled", job3.Error)
+	job3.mu.Unlock()
+
+	// Finishing a job starts the next one
+	release <- struct{}{}
+	assert.Equal(t, job2.ID, <-started)
+	assert.Equal(t, []int64{}, jobs.Queued())
+	job2.mu.Lock()
+	assert.False(t, job2.Queued)
+	assert.False(t, job2.StartTime.IsZero())
+	job2.mu.Unlock()
+	release <- struct{}{}
+	select {
+	case id := <-started:
+		t.Errorf("job %d started unexpectedly", id)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
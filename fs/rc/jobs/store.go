@@ -0,0 +1,270 @@
+package jobs
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// storeFacility is the name of the key-value database for the jobs
+const storeFacility = "rcjobs"
+
+// progressInterval is how often the progress of running jobs is saved
+var progressInterval = 10 * time.Second
+
+// record is the state of a job as saved in the store
+type record struct {
+	ID        int64     `json:"id"`
+	Path      string    `json:"path"`
+	Input     rc.Params `json:"input"`
+	Group     string    `json:"group"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Error     string    `json:"error"`
+	Finished  bool      `json:"finished"`
+	Success   bool      `json:"success"`
+	Duration  float64   `json:"duration"`
+	Output    rc.Params `json:"output"`
+	Queued    bool      `json:"queued"`
+	Progress  rc.Params `json:"progress,omitempty"`
+}
+
+// store saves async jobs to a key-value database so they survive a
+// restart
+type store struct {
+	db *kv.DB
+}
+
+// openStore opens the job store
+func openStore(ctx context.Context) (*store, error) {
+	if !kv.Supported() {
+		return nil, errors.New("saving rc jobs is not supported on this OS")
+	}
+	db, err := kv.Start(ctx, storeFacility, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rc job store: %w", err)
+	}
+	return &store{db: db}, nil
+}
+
+// storeKey returns the key for job id which sorts in ID order
+func storeKey(id int64) []byte {
+	return fmt.Appendf(nil, "%020d", id)
+}
+
+// opPut: save a record
+type opPut struct {
+	key   []byte
+	value []byte
+}
+
+func (op *opPut) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Put(op.key, op.value)
+}
+
+// opDelete: delete a record
+type opDelete struct {
+	key []byte
+}
+
+func (op *opDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete(op.key)
+}
+
+// opLoad: read all the records
+type opLoad struct {
+	records []record
+}
+
+func (op *opLoad) Do(ctx context.Context, b kv.Bucket) error {
+	return b.ForEach(func(key, value []byte) error {
+		var r record
+		if err := json.Unmarshal(value, &r); err != nil {
+			fs.Errorf(nil, "rc: ignoring corrupted job %q in job store: %v", key, err)
+			return nil
+		}
+		op.records = append(op.records, r)
+		return nil
+	})
+}
+
+// save the current state of job if it is being saved
+func (s *store) save(job *Job) {
+	if s == nil || job.path == "" {
+		return
+	}
+	job.mu.Lock()
+	r := record{
+		ID:        job.ID,
+		Path:      job.path,
+		Input:     job.input,
+		Group:     job.Group,
+		StartTime: job.StartTime,
+		EndTime:   job.EndTime,
+		Error:     job.Error,
+		Finished:  job.Finished,
+		Success:   job.Success,
+		Duration:  job.Duration,
+		Output:    job.Output,
+		Queued:    job.Queued,
+		Progress:  job.Progress,
+	}
+	value, err := json.Marshal(&r)
+	job.mu.Unlock()
+	if err == nil {
+		err = s.db.Do(true, &opPut{key: storeKey(job.ID), value: value})
+	}
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to save job %d: %v", job.ID, err)
+	}
+}
+
+// saveProgress saves the stats of job every progressInterval until
+// the returned function is called
+func (s *store) saveProgress(ctx context.Context, job *Job) (stop func()) {
+	if s == nil || job.path == "" {
+		return func() {}
+	}
+	ticker := time.NewTicker(progressInterval)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-ticker.C:
+				progress, err := accounting.StatsGroup(ctx, job.Group).RemoteStats(true)
+				if err != nil {
+					continue
+				}
+				job.mu.Lock()
+				job.Progress = progress
+				job.mu.Unlock()
+				s.save(job)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+	}
+}
+
+// remove job from the store
+//
+// This may be called with job.mu held
+func (s *store) remove(job *Job) {
+	if s == nil || job.path == "" {
+		return
+	}
+	err := s.db.Do(true, &opDelete{key: storeKey(job.ID)})
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to remove job %d from job store: %v", job.ID, err)
+	}
+}
+
+// load all the records from the store in ID order
+func (s *store) load() ([]record, error) {
+	op := &opLoad{}
+	err := s.db.Do(false, op)
+	if err != nil && err != kv.ErrEmpty {
+		return nil, err
+	}
+	slices.SortFunc(op.records, func(a, b record) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return op.records, nil
+}
+
+// Resume opens the job store if --rc-job-persist is set and loads
+// the jobs saved by a previous run of rclone.
+//
+// Finished jobs are loaded so their status can be read until they
+// expire. Unfinished jobs are run again with the same job ID in the
+// order they were submitted.
+func Resume(ctx context.Context) error {
+	return running.resume(ctx)
+}
+
+// resume opens the store and loads the jobs from it
+func (jobs *Jobs) resume(ctx context.Context) error {
+	if !jobs.opt.JobPersist || jobs.store != nil {
+		return nil
+	}
+	s, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	records, err := s.load()
+	if err != nil {
+		return fmt.Errorf("failed to read rc job store: %w", err)
+	}
+	jobs.mu.Lock()
+	jobs.store = s
+	jobs.mu.Unlock()
+
+	// Make sure new job IDs don't clash with the loaded ones
+	for _, r := range records {
+		for {
+			current := jobID.Load()
+			if current >= r.ID || jobID.CompareAndSwap(current, r.ID) {
+				break
+			}
+		}
+	}
+
+	for _, r := range records {
+		if r.Finished {
+			job := &Job{
+				ID:        r.ID,
+				ExecuteID: executeID,
+				Group:     r.Group,
+				StartTime: r.StartTime,
+				EndTime:   r.EndTime,
+				Error:     r.Error,
+				Finished:  true,
+				Success:   r.Success,
+				Duration:  r.Duration,
+				Output:    r.Output,
+				Progress:  r.Progress,
+				Stop:      func() {},
+				path:      r.Path,
+			}
+			if r.Error != "" {
+				job.realErr = errors.New(r.Error)
+			}
+			jobs.mu.Lock()
+			jobs.jobs[job.ID] = job
+			jobs.mu.Unlock()
+			jobs.kickExpire()
+			continue
+		}
+		call := rc.Calls.Get(r.Path)
+		if call == nil {
+			fs.Errorf(nil, "rc: can't resume job %d: couldn't find path %q", r.ID, r.Path)
+			_ = s.db.Do(true, &opDelete{key: storeKey(r.ID)})
+			continue
+		}
+		fs.Infof(nil, "rc: resuming job %d: %q", r.ID, r.Path)
+		in := r.Input.Copy()
+		in["_async"] = true
+		_, _, err = jobs.newJob(context.Background(), r.ID, r.Path, call.Fn, in)
+		if err != nil {
+			fs.Errorf(nil, "rc: failed to resume job %d: %v", r.ID, err)
+			_ = s.db.Do(true, &opDelete{key: storeKey(r.ID)})
+		}
+	}
+	return nil
+}
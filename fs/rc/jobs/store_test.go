@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Parameters passed to test/persist
+var persistCalls = make(chan rc.Params, 10)
+
+func init() {
+	rc.Add(rc.Call{
+		Path: "test/persist",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			persistCalls <- in
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+}
+
+func TestJobsPersist(t *testing.T) {
+	ctx := context.Background()
+	config.SetCacheDir(t.TempDir())
+	opt := &rc.Options{
+		JobPersist:        true,
+		JobExpireDuration: fs.Duration(time.Hour),
+		JobExpireInterval: fs.Duration(time.Hour),
+	}
+	jobs1 := newJobs()
+	jobs1.opt = opt
+	require.NoError(t, jobs1.resume(ctx))
+
+	// A job which finishes
+	noop := rc.Calls.Get("rc/noop")
+	require.NotNil(t, noop)
+	job1, _, err := jobs1.newJob(ctx, 0, noop.Path, noop.Fn, rc.Params{"_async": true, "a": "b"})
+	require.NoError(t, err)
+	finished := make(chan struct{})
+	job1.OnFinish(func() { close(finished) })
+	<-finished
+	// wait for the job to be saved after it finishes
+	require.Eventually(t, func() bool {
+		jobs1.mu.RLock()
+		defer jobs1.mu.RUnlock()
+		return jobs1.active == 0
+	}, 10*time.Second, time.Millisecond)
+
+	// A job which is still running
+	call := rc.Calls.Get("test/persist")
+	job2, _, err := jobs1.newJob(ctx, 0, call.Path, call.Fn, rc.Params{"_async": true, "param": "potato"})
+	require.NoError(t, err)
+	assert.Equal(t, "potato", (<-persistCalls)["param"])
+	defer job2.Stop()
+
+	// Jobs which aren't async or don't have a path aren't saved
+	_, _, err = jobs1.newJob(ctx, 0, noop.Path, noop.Fn, rc.Params{})
+	require.NoError(t, err)
+	_, _, err = jobs1.NewJob(ctx, noop.Fn, rc.Params{"_async": true})
+	require.NoError(t, err)
+
+	records, err := jobs1.store.load()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, job1.ID, records[0].ID)
+	assert.True(t, records[0].Finished)
+	assert.Equal(t, job2.ID, records[1].ID)
+	assert.False(t, records[1].Finished)
+
+	// Simulate a restart
+	jobs2 := newJobs()
+	jobs2.opt = opt
+	require.NoError(t, jobs2.resume(ctx))
+
+	got1 := jobs2.Get(job1.ID)
+	require.NotNil(t, got1)
+	assert.True(t, got1.Finished)
+	assert.True(t, got1.Success)
+	assert.Equal(t, rc.Params{"a": "b"}, got1.Output)
+
+	got2 := jobs2.Get(job2.ID)
+	require.NotNil(t, got2)
+	defer got2.Stop()
+	assert.Equal(t, "potato", (<-persistCalls)["param"])
+	assert.False(t, got2.Finished)
+	assert.GreaterOrEqual(t, jobID.Load(), job2.ID)
+
+	// Expiring a job removes it from the store
+	got1.mu.Lock()
+	got1.EndTime = time.Now().Add(-2 * time.Hour)
+	got1.mu.Unlock()
+	jobs2.Expire()
+	assert.Nil(t, jobs2.Get(job1.ID))
+	records, err = jobs2.store.load()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, job2.ID, records[0].ID)
+}
@@ -83,6 +83,16 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(10 * time.Second),
 	Help:    "Interval to check for expired async jobs",
 	Groups:  "RC",
+}, {
+	Name:    "rc_job_persist",
+	Default: false,
+	Help:    "Save async jobs so they are resumed if rclone is restarted",
+	Groups:  "RC",
+}, {
+	Name:    "rc_max_jobs",
+	Default: 0,
+	Help:    "Maximum number of async jobs to run at once, more are queued (0 for unlimited)",
+	Groups:  "RC",
 }, {
 	Name:    "metrics_addr",
 	Default: []string{},
@@ -122,6 +132,8 @@ type Options struct {
 	MetricsTemplate     libhttp.TemplateConfig `config:"metrics"`
 	JobExpireDuration   fs.Duration            `config:"rc_job_expire_duration"`
 	JobExpireInterval   fs.Duration            `config:"rc_job_expire_interval"`
+	JobPersist          bool                   `config:"rc_job_persist"`
+	MaxJobs             int                    `config:"rc_max_jobs"`
 }
 
 // Opt is the default values used for Options
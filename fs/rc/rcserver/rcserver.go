@@ -41,6 +41,10 @@ func Start(ctx context.Context, opt *rc.Options) (*Server, error) {
 		if err != nil {
 			return nil, err
 		}
+		err = jobs.Resume(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return s, s.Serve()
 	}
 	return nil, nil
@@ -282,7 +286,7 @@ func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, path string)
 	}
 
 	fs.Debugf(nil, "rc: %q: with parameters %+v", path, in)
-	job, out, err := jobs.NewJob(ctx, call.Fn, in)
+	job, out, err := jobs.NewCallJob(ctx, call, in)
 	if job != nil {
 		w.Header().Add("x-rclone-jobid", fmt.Sprintf("%d", job.ID))
 	}
@@ -0,0 +1,199 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed cron expression
+type cronSpec struct {
+	minute  uint64        // bit set of minutes to run in
+	hour    uint64        // bit set of hours to run in
+	dom     uint64        // bit set of days of the month to run in
+	month   uint64        // bit set of months to run in
+	dow     uint64        // bit set of days of the week to run in
+	domStar bool          // set if the day of the month was *
+	dowStar bool          // set if the day of the week was *
+	every   time.Duration // set for @every expressions
+}
+
+// cronField describes one of the fields of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// cronMacros are the @ expressions which are short for a cron expression
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a standard 5 field cron expression, one of the @
+// macros or "@every <duration>"
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("bad duration in cron expression %q: %w", spec, err)
+		}
+		if every < time.Second {
+			return nil, fmt.Errorf("duration in cron expression %q must be at least 1s", spec)
+		}
+		return &cronSpec{every: every}, nil
+	}
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("expecting %d fields in cron expression %q but got %d", len(cronFields), spec, len(parts))
+	}
+	var bits [5]uint64
+	for i, part := range parts {
+		var err error
+		bits[i], err = cronFields[i].parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("bad cron expression %q: %w", spec, err)
+		}
+	}
+	c := &cronSpec{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}
+	// Sunday can be 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseValue parses a number or a name in the field
+func (f *cronField) parseValue(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// parse a field of a cron expression into a bit set
+//
+// The field is a comma separated list of *, a value or a range
+// "a-b", each optionally followed by a step "/n".
+func (f *cronField) parse(s string) (bits uint64, err error) {
+	for part := range strings.SplitSeq(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q in %s", stepPart, f.name)
+			}
+		}
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			startPart, endPart, isRange := strings.Cut(rangePart, "-")
+			start, err = f.parseValue(startPart)
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				end, err = f.parseValue(endPart)
+				if err != nil {
+					return 0, err
+				}
+				if end < start {
+					return 0, fmt.Errorf("bad range %q in %s", rangePart, f.name)
+				}
+			case !hasStep:
+				end = start
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// dayMatches returns true if the day of t matches the expression
+//
+// As in cron, if both the day of the month and the day of the week
+// are restricted then a day matching either is run.
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	domOK := c.dom&(1<<uint(t.Day())) != 0
+	dowOK := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// next returns the first time after t that the expression matches or
+// the zero time if it doesn't match in the next 5 years
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		hour, minute := t.Hour(), t.Minute()
+		var next time.Time
+		switch {
+		case c.month&(1<<uint(month)) == 0:
+			next = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			next = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(hour)) == 0:
+			next = time.Date(year, month, day, hour+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(minute)) == 0:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// Daylight saving changes can make time.Date go backwards
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
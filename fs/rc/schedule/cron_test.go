@@ -0,0 +1,64 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * potato *",
+		"@every",
+		"@every 1ms",
+		"@every potato",
+	} {
+		_, err := parseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Thursday
+	start := time.Date(2026, 1, 1, 12, 30, 15, 0, time.UTC)
+	for _, test := range []struct {
+		spec string
+		want []string
+	}{
+		{"* * * * *", []string{"2026-01-01 12:31", "2026-01-01 12:32"}},
+		{"*/20 * * * *", []string{"2026-01-01 12:40", "2026-01-01 13:00", "2026-01-01 13:20"}},
+		{"0 3 * * *", []string{"2026-01-02 03:00", "2026-01-03 03:00"}},
+		{"15,45 9-10 * * *", []string{"2026-01-02 09:15", "2026-01-02 09:45", "2026-01-02 10:15", "2026-01-02 10:45", "2026-01-03 09:15"}},
+		{"0 0 * * mon", []string{"2026-01-05 00:00", "2026-01-12 00:00"}},
+		{"0 0 * * 7", []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		{"0 0 * * 1-5/2", []string{"2026-01-02 00:00", "2026-01-05 00:00", "2026-01-07 00:00"}},
+		{"0 0 31 * *", []string{"2026-01-31 00:00", "2026-03-31 00:00"}},
+		{"0 0 29 feb *", []string{"2028-02-29 00:00"}},
+		{"0 0 13 * fri", []string{"2026-01-02 00:00", "2026-01-09 00:00", "2026-01-13 00:00"}},
+		{"@monthly", []string{"2026-02-01 00:00", "2026-03-01 00:00"}},
+		{"@hourly", []string{"2026-01-01 13:00", "2026-01-01 14:00"}},
+		{"@every 90m", []string{"2026-01-01 14:00", "2026-01-01 15:30"}},
+		{"0 0 30 2 *", []string{"0001-01-01 00:00"}},
+	} {
+		c, err := parseCron(test.spec)
+		require.NoError(t, err, test.spec)
+		tm := start
+		for _, want := range test.want {
+			tm = c.next(tm)
+			assert.Equal(t, want, tm.Format("2006-01-02 15:04"), test.spec)
+		}
+	}
+}
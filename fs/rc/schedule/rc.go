@@ -0,0 +1,157 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/fs/rc"
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "schedule/add",
+		Fn:    rcAdd,
+		Title: "Run an rc command on a schedule",
+		Help: strings.ReplaceAll(`This adds an rc command to be run on a cron schedule by rclone
+rcd. The schedules are saved so they persist when rclone rcd is
+restarted.
+
+Parameters:
+
+- cron - the schedule as a cron expression (string)
+- command - the rc command to run, e.g. "sync/sync" (string)
+- params - the parameters to pass to the command (object, optional)
+- name - a name to describe the schedule (string, optional)
+
+The cron expression has the 5 standard fields "minute hour
+day-of-month month day-of-week", each of which can be a number, a name
+for months and days of the week, |*|, a range |a-b| or a comma
+separated list of these, optionally followed by a step |/n|. The
+macros |@yearly|, |@monthly|, |@weekly|, |@daily| and |@hourly| are
+supported along with |@every <duration>| e.g. |@every 90m|. Times are
+in the local time zone of the rc server.
+
+The command is run as an async job. If the previous run of the
+command is still running when it is due again then that run is
+skipped, so runs of the same schedule never overlap.
+
+The params may include |_config|, |_filter| and |_group| as normal.
+
+Returns:
+
+- id - the id of the new schedule (integer)
+- next - when the command will first run
+
+For example
+
+|||console
+rclone rc schedule/add cron="0 3 * * *" command=sync/sync params='{"srcFs": "/home/user/files", "dstFs": "remote:backup"}'
+|||
+`, "|", "`"),
+	})
+}
+
+// Add a schedule
+func rcAdd(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	s, err := getScheduler()
+	if err != nil {
+		return nil, err
+	}
+	cron, err := in.GetString("cron")
+	if err != nil {
+		return nil, err
+	}
+	command, err := in.GetString("command")
+	if err != nil {
+		return nil, err
+	}
+	name, err := in.GetString("name")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	var params rc.Params
+	err = in.GetStructMissingOK("params", &params)
+	if err != nil {
+		return nil, err
+	}
+	e, err := s.add(name, cron, command, params)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"id":   e.ID,
+		"next": e.Next,
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "schedule/list",
+		Fn:    rcList,
+		Title: "List the scheduled rc commands",
+		Help: `Parameters: None.
+
+Returns:
+
+- schedules - an array of the schedules, each with
+    - id - the id of the schedule
+    - name - the name of the schedule
+    - cron - the cron expression
+    - command - the rc command run
+    - params - the parameters passed to the command
+    - next - when the command will next run
+    - lastRun - when the command was last run
+    - lastJobId - the job id of the last run which can be used with job/status
+    - lastSuccess - true if the last run succeeded
+    - lastError - the error from the last run or empty
+    - running - true if the last run is still running
+    - skipped - the number of runs skipped as the previous run was still running
+
+The schedules are also shown in the output of job/list.
+`,
+	})
+}
+
+// List the schedules
+func rcList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	s, err := getScheduler()
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"schedules": s.list(),
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "schedule/remove",
+		Fn:    rcRemove,
+		Title: "Remove a scheduled rc command",
+		Help: `Parameters:
+
+- id - the id of the schedule to remove (integer)
+
+This stops the command being run in future. If it is running at the
+moment then it isn't stopped - use job/stop for that.
+`,
+	})
+}
+
+// Remove a schedule
+func rcRemove(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	s, err := getScheduler()
+	if err != nil {
+		return nil, err
+	}
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	err = s.remove(id)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %d: %w", id, err)
+	}
+	return rc.Params{}, nil
+}
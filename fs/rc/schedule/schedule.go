@@ -0,0 +1,366 @@
+// Package schedule runs rc commands on a cron schedule.
+package schedule
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// storeFacility is the name of the key-value database for the schedules
+const storeFacility = "rcschedule"
+
+// maxWait is the longest the scheduler sleeps for
+const maxWait = time.Hour
+
+// Entry is an rc command run on a schedule
+type Entry struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Cron        string    `json:"cron"`
+	Command     string    `json:"command"`
+	Params      rc.Params `json:"params"`
+	Next        time.Time `json:"next"`        // when the command will next run
+	LastRun     time.Time `json:"lastRun"`     // when the command was last run
+	LastJobID   int64     `json:"lastJobId"`   // job ID of the last run
+	LastSuccess bool      `json:"lastSuccess"` // whether the last run succeeded
+	LastError   string    `json:"lastError"`   // error from the last run
+	Running     bool      `json:"running"`     // set if the last run hasn't finished
+	Skipped     int64     `json:"skipped"`     // runs skipped because the last run hadn't finished
+	spec        *cronSpec
+}
+
+// Scheduler runs the scheduled entries
+type Scheduler struct {
+	mu      sync.Mutex
+	db      *kv.DB
+	entries map[int64]*Entry
+	lastID  int64
+	wake    chan struct{}
+	now     func() time.Time
+}
+
+var (
+	schedMu sync.Mutex
+	sched   *Scheduler // the running scheduler if started
+)
+
+// newScheduler makes a scheduler loading the entries from db
+func newScheduler(db *kv.DB) (*Scheduler, error) {
+	s := &Scheduler{
+		db:      db,
+		entries: map[int64]*Entry{},
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+	op := &opLoad{}
+	err := db.Do(false, op)
+	if err != nil && err != kv.ErrEmpty {
+		return nil, fmt.Errorf("failed to read rc schedules: %w", err)
+	}
+	now := s.now()
+	for _, e := range op.entries {
+		e.spec, err = parseCron(e.Cron)
+		if err != nil {
+			fs.Errorf(nil, "rc: ignoring schedule %d: %v", e.ID, err)
+			continue
+		}
+		// The job for the last run didn't survive a restart
+		if e.Running {
+			e.Running = false
+			e.LastError = "rclone was restarted while running"
+		}
+		e.Next = e.spec.next(now)
+		s.entries[e.ID] = e
+		s.lastID = max(s.lastID, e.ID)
+	}
+	return s, nil
+}
+
+// Start the scheduler running the saved schedules.
+//
+// This is only called by rclone rcd so commands with --rc don't run
+// the schedules. The scheduler runs until ctx is cancelled.
+func Start(ctx context.Context) error {
+	schedMu.Lock()
+	defer schedMu.Unlock()
+	if sched != nil {
+		return nil
+	}
+	if !kv.Supported() {
+		fs.Debugf(nil, "rc: schedules are not supported on this OS")
+		return nil
+	}
+	db, err := kv.Start(ctx, storeFacility, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open rc schedule store: %w", err)
+	}
+	s, err := newScheduler(db)
+	if err != nil {
+		return err
+	}
+	sched = s
+	jobs.ScheduleList = s.status
+	go s.loop(ctx)
+	return nil
+}
+
+// getScheduler returns the running scheduler or an error
+func getScheduler() (*Scheduler, error) {
+	schedMu.Lock()
+	defer schedMu.Unlock()
+	if sched == nil {
+		return nil, errors.New("the scheduler only runs in rclone rcd")
+	}
+	return sched, nil
+}
+
+// loop runs the entries when they are due until ctx is cancelled
+func (s *Scheduler) loop(ctx context.Context) {
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		now := s.now()
+		s.runDue(now)
+		wait := s.nextWait(now)
+		s.mu.Unlock()
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// kick wakes up the loop to recalculate when to run next
+func (s *Scheduler) kick() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextWait returns how long to wait until the next entry is due
+//
+// Call with s.mu held
+func (s *Scheduler) nextWait(now time.Time) time.Duration {
+	wait := maxWait
+	for _, e := range s.entries {
+		if !e.Next.IsZero() {
+			wait = min(wait, e.Next.Sub(now))
+		}
+	}
+	return max(wait, 0)
+}
+
+// runDue starts the entries which are due
+//
+// Call with s.mu held
+func (s *Scheduler) runDue(now time.Time) {
+	for _, e := range s.entries {
+		if e.Next.IsZero() || now.Before(e.Next) {
+			continue
+		}
+		e.Next = e.spec.next(now)
+		if e.Running {
+			e.Skipped++
+			fs.Logf(nil, "rc: schedule %d: skipping run of %q as job %d is still running", e.ID, e.Command, e.LastJobID)
+		} else {
+			s.start(e, now)
+		}
+		s.save(e)
+	}
+}
+
+// start runs the command of entry e
+//
+// Call with s.mu held
+func (s *Scheduler) start(e *Entry, now time.Time) {
+	e.LastRun = now
+	call := rc.Calls.Get(e.Command)
+	if call == nil {
+		e.LastSuccess = false
+		e.LastError = fmt.Sprintf("couldn't find path %q", e.Command)
+		fs.Errorf(nil, "rc: schedule %d: %s", e.ID, e.LastError)
+		return
+	}
+	in := e.Params.Copy()
+	in["_async"] = true
+	job, _, err := jobs.NewCallJob(context.Background(), call, in)
+	if err != nil {
+		e.LastSuccess = false
+		e.LastError = err.Error()
+		fs.Errorf(nil, "rc: schedule %d: failed to start %q: %v", e.ID, e.Command, err)
+		return
+	}
+	fs.Infof(nil, "rc: schedule %d: started %q as job %d", e.ID, e.Command, job.ID)
+	e.Running = true
+	e.LastJobID = job.ID
+	id := e.ID
+	job.OnFinish(func() {
+		s.finished(id, job)
+	})
+}
+
+// finished is called when the job for entry id finishes
+func (s *Scheduler) finished(id int64, job *jobs.Job) {
+	err := job.Err()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[id]
+	if e == nil || e.LastJobID != job.ID {
+		return
+	}
+	e.Running = false
+	e.LastSuccess = err == nil
+	e.LastError = ""
+	if err != nil {
+		e.LastError = err.Error()
+	}
+	s.save(e)
+}
+
+// add a new entry returning a copy of it
+func (s *Scheduler) add(name, cron, command string, params rc.Params) (Entry, error) {
+	spec, err := parseCron(cron)
+	if err != nil {
+		return Entry{}, rc.NewErrParamInvalid(err)
+	}
+	call := rc.Calls.Get(command)
+	if call == nil {
+		return Entry{}, rc.NewErrParamInvalid(fmt.Errorf("couldn't find path %q", command))
+	}
+	if call.NeedsRequest || call.NeedsResponse {
+		return Entry{}, rc.NewErrParamInvalid(fmt.Errorf("can't schedule path %q as it needs the request or response", command))
+	}
+	if params == nil {
+		params = rc.Params{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := spec.next(s.now())
+	if next.IsZero() {
+		return Entry{}, rc.NewErrParamInvalid(fmt.Errorf("cron expression %q never runs", cron))
+	}
+	s.lastID++
+	e := &Entry{
+		ID:      s.lastID,
+		Name:    name,
+		Cron:    cron,
+		Command: command,
+		Params:  params,
+		Next:    next,
+		spec:    spec,
+	}
+	s.entries[e.ID] = e
+	s.save(e)
+	s.kick()
+	return *e, nil
+}
+
+// remove the entry with id
+func (s *Scheduler) remove(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[id] == nil {
+		return errors.New("schedule not found")
+	}
+	delete(s.entries, id)
+	err := s.db.Do(true, &opDelete{key: storeKey(id)})
+	if err != nil {
+		return fmt.Errorf("failed to remove schedule: %w", err)
+	}
+	s.kick()
+	return nil
+}
+
+// list returns copies of the entries in ID order
+func (s *Scheduler) list() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return entries
+}
+
+// status returns the entries without their params for job/list
+// which doesn't need authentication
+func (s *Scheduler) status() any {
+	entries := s.list()
+	for i := range entries {
+		entries[i].Params = nil
+	}
+	return entries
+}
+
+// storeKey returns the key for entry id which sorts in ID order
+func storeKey(id int64) []byte {
+	return fmt.Appendf(nil, "%020d", id)
+}
+
+// save entry e to the store
+//
+// Call with s.mu held
+func (s *Scheduler) save(e *Entry) {
+	value, err := json.Marshal(e)
+	if err == nil {
+		err = s.db.Do(true, &opPut{key: storeKey(e.ID), value: value})
+	}
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to save schedule %d: %v", e.ID, err)
+	}
+}
+
+// opPut: save an entry
+type opPut struct {
+	key   []byte
+	value []byte
+}
+
+func (op *opPut) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Put(op.key, op.value)
+}
+
+// opDelete: delete an entry
+type opDelete struct {
+	key []byte
+}
+
+func (op *opDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete(op.key)
+}
+
+// opLoad: read all the entries
+type opLoad struct {
+	entries []*Entry
+}
+
+func (op *opLoad) Do(ctx context.Context, b kv.Bucket) error {
+	return b.ForEach(func(key, value []byte) error {
+		e := new(Entry)
+		if err := json.Unmarshal(value, e); err != nil {
+			fs.Errorf(nil, "rc: ignoring corrupted schedule %q: %v", key, err)
+			return nil
+		}
+		op.entries = append(op.entries, e)
+		return nil
+	})
+}
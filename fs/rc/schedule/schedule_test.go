@@ -0,0 +1,156 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Parameters passed to test/schedule
+var scheduleCalls = make(chan rc.Params, 10)
+
+// Closed to finish the running test/schedule calls
+var scheduleDone = make(chan struct{})
+
+func init() {
+	rc.Add(rc.Call{
+		Path: "test/schedule",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			scheduleCalls <- in
+			<-scheduleDone
+			return rc.Params{}, nil
+		},
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	config.SetCacheDir(t.TempDir())
+	db, err := kv.Start(ctx, storeFacility, nil)
+	require.NoError(t, err)
+	s, err := newScheduler(db)
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 30, 0, 0, time.Local)
+	s.now = func() time.Time { return now }
+	schedMu.Lock()
+	sched = s
+	schedMu.Unlock()
+	defer func() {
+		schedMu.Lock()
+		sched = nil
+		schedMu.Unlock()
+	}()
+
+	call := func(path string, in rc.Params) (rc.Params, error) {
+		c := rc.Calls.Get(path)
+		require.NotNil(t, c, path)
+		return c.Fn(ctx, in)
+	}
+
+	// Bad schedules
+	_, err = call("schedule/add", rc.Params{"cron": "potato", "command": "test/schedule"})
+	assert.ErrorContains(t, err, "cron")
+	_, err = call("schedule/add", rc.Params{"cron": "@hourly", "command": "test/potato"})
+	assert.ErrorContains(t, err, "couldn't find path")
+	_, err = call("schedule/add", rc.Params{"cron": "@hourly", "command": "core/command"})
+	assert.ErrorContains(t, err, "needs the request or response")
+
+	// Add a schedule
+	out, err := call("schedule/add", rc.Params{
+		"cron":    "0 * * * *",
+		"command": "test/schedule",
+		"name":    "hourly",
+		"params":  `{"param": "potato"}`,
+	})
+	require.NoError(t, err)
+	id := out["id"].(int64)
+	assert.Equal(t, time.Date(2026, 1, 1, 13, 0, 0, 0, time.Local), out["next"])
+
+	out, err = call("schedule/list", rc.Params{})
+	require.NoError(t, err)
+	entries := out["schedules"].([]Entry)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "hourly", entries[0].Name)
+	assert.Equal(t, rc.Params{"param": "potato"}, entries[0].Params)
+
+	// Not due yet
+	s.mu.Lock()
+	assert.Equal(t, 30*time.Minute, s.nextWait(now))
+	s.runDue(now)
+	s.mu.Unlock()
+	assert.Len(t, scheduleCalls, 0)
+
+	// Due so it runs
+	now = now.Add(30 * time.Minute)
+	s.mu.Lock()
+	s.runDue(now)
+	s.mu.Unlock()
+	in := <-scheduleCalls
+	assert.Equal(t, "potato", in["param"])
+	e := s.list()[0]
+	assert.True(t, e.Running)
+	assert.Equal(t, now, e.LastRun)
+	assert.Equal(t, now.Add(time.Hour), e.Next)
+	jobID := e.LastJobID
+	out, err = call("job/status", rc.Params{"jobid": jobID})
+	require.NoError(t, err)
+	assert.Equal(t, false, out["finished"])
+
+	// Still running when due again so it is skipped
+	now = now.Add(time.Hour)
+	s.mu.Lock()
+	s.runDue(now)
+	s.mu.Unlock()
+	e = s.list()[0]
+	assert.Equal(t, int64(1), e.Skipped)
+	assert.Equal(t, jobID, e.LastJobID)
+
+	// The schedules are shown in job/list
+	jobs.ScheduleList = s.status
+	defer func() { jobs.ScheduleList = nil }()
+	out, err = call("job/list", rc.Params{})
+	require.NoError(t, err)
+	entries = out["schedules"].([]Entry)
+	require.Len(t, entries, 1)
+	assert.Nil(t, entries[0].Params)
+
+	// Let the job finish
+	close(scheduleDone)
+	require.Eventually(t, func() bool {
+		return !s.list()[0].Running
+	}, 10*time.Second, time.Millisecond)
+	e = s.list()[0]
+	assert.True(t, e.LastSuccess)
+	assert.Equal(t, "", e.LastError)
+
+	// The schedules are loaded on restart
+	s2, err := newScheduler(db)
+	require.NoError(t, err)
+	s2.mu.Lock()
+	require.Len(t, s2.entries, 1)
+	assert.Equal(t, int64(1), s2.entries[id].Skipped)
+	assert.Equal(t, "0 * * * *", s2.entries[id].Cron)
+	s2.mu.Unlock()
+
+	// Remove the schedule
+	_, err = call("schedule/remove", rc.Params{"id": id})
+	require.NoError(t, err)
+	_, err = call("schedule/remove", rc.Params{"id": id})
+	assert.ErrorContains(t, err, "not found")
+	assert.Len(t, s.list(), 0)
+	s3, err := newScheduler(db)
+	require.NoError(t, err)
+	assert.Len(t, s3.list(), 0)
+}
+
+func TestSchedulerNotStarted(t *testing.T) {
+	_, err := rc.Calls.Get("schedule/list").Fn(context.Background(), rc.Params{})
+	assert.ErrorContains(t, err, "rclone rcd")
+}
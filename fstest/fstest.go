@@ -547,6 +547,28 @@ func NewObject(ctx context.Context, t *testing.T, f fs.Fs, remote string) fs.Obj
 	return obj
 }
 
+// ListNames returns the sorted remotes of the entries in dir of f
+func ListNames(ctx context.Context, t *testing.T, f fs.Fs, dir string) (names []string) {
+	entries, err := f.List(ctx, dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TimeMark returns the time now in RFC3339 format making sure it is
+// distinct from the times of changes made just before and after it.
+//
+// This is useful for testing options which show the remote at a given
+// time.
+func TimeMark() string {
+	time.Sleep(5 * time.Millisecond)
+	defer time.Sleep(5 * time.Millisecond)
+	return time.Now().Format(time.RFC3339Nano)
+}
+
 // NewDirectoryRetries finds the directory with remote in f
 //
 // If directory can't be found it returns an error wrapping fs.ErrorDirNotFound
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -168,3 +169,34 @@ func TestIsReserved(t *testing.T) {
 	require.Error(t, IsReserved("test."))
 	require.Error(t, IsReserved("test "))
 }
+
+// Test we can free part of a file
+func TestPunchHole(t *testing.T) {
+	dir := t.TempDir()
+
+	filepath := path.Join(dir, "file1")
+	f, err := OpenFile(filepath, os.O_RDWR|os.O_CREATE, 0600)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, f.Close()) }()
+	require.NoError(t, SetSparse(f))
+
+	const blockSize = 64 * 1024
+	data := bytes.Repeat([]byte{'A'}, 3*blockSize)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+
+	require.NoError(t, PunchHole(f, 0, 0))
+	err = PunchHole(f, blockSize, blockSize)
+	if err == ErrPunchHoleUnsupported {
+		t.Skip("punch hole not supported")
+	}
+	require.NoError(t, err)
+
+	// The size is unchanged and the hole reads as zeroes
+	checkListing(t, dir, []string{"file1,196608,false"})
+	got := make([]byte, len(data))
+	_, err = f.ReadAt(got, 0)
+	require.NoError(t, err)
+	copy(data[blockSize:], make([]byte, blockSize))
+	assert.Equal(t, data, got)
+}
@@ -4,3 +4,7 @@ import "errors"
 
 // ErrDiskFull is returned from PreAllocate when it detects disk full
 var ErrDiskFull = errors.New("preallocate: file too big for remaining disk space")
+
+// ErrPunchHoleUnsupported is returned from PunchHole when the OS or
+// the file system can't free parts of a file
+var ErrPunchHoleUnsupported = errors.New("punch hole: not supported")
@@ -21,3 +21,13 @@ const SetSparseImplemented = false
 func SetSparse(out *os.File) error {
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole actually does anything.
+const PunchHoleImplemented = false
+
+// PunchHole frees the disk space used by size bytes at offset in the
+// file. They read as zeroes afterwards and the file size is unchanged.
+func PunchHole(out *os.File, offset, size int64) error {
+	return ErrPunchHoleUnsupported
+}
@@ -103,3 +103,34 @@ func SetSparse(out *os.File) error {
 	}
 	return nil
 }
+
+// fileZeroDataInformation is the input to FSCTL_SET_ZERO_DATA
+type fileZeroDataInformation struct {
+	FileOffset      int64
+	BeyondFinalZero int64
+}
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole actually does anything.
+const PunchHoleImplemented = true
+
+// PunchHole frees the disk space used by size bytes at offset in the
+// file. They read as zeroes afterwards and the file size is unchanged.
+//
+// The space is only freed if the file has been made sparse with
+// SetSparse.
+func PunchHole(out *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	zeroInfo := fileZeroDataInformation{
+		FileOffset:      offset,
+		BeyondFinalZero: offset + size,
+	}
+	var bytesReturned uint32
+	err := syscall.DeviceIoControl(syscall.Handle(out.Fd()), windows.FSCTL_SET_ZERO_DATA, (*byte)(unsafe.Pointer(&zeroInfo)), uint32(unsafe.Sizeof(zeroInfo)), nil, 0, &bytesReturned, nil)
+	if err != nil {
+		return fmt.Errorf("DeviceIoControl FSCTL_SET_ZERO_DATA: %w", err)
+	}
+	return nil
+}
@@ -35,6 +35,7 @@ type Directory struct {
 	Name         string
 	ZipURL       string
 	DisableZip   bool
+	AllowWrite   bool
 	Entries      []DirEntry
 	Query        string
 	HTMLTemplate *template.Template
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -124,3 +125,22 @@ func TestServe(t *testing.T) {
 </html>
 `, string(body))
 }
+
+func TestServeAllowWrite(t *testing.T) {
+	htmlTemplate, err := libhttp.GetTemplate("")
+	require.NoError(t, err)
+	for _, allowWrite := range []bool{false, true} {
+		d := NewDirectory("aDirectory", htmlTemplate)
+		d.AddHTMLEntry("aDirectory/file", false, 1, time.Time{})
+		d.AllowWrite = allowWrite
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/aDirectory/", nil)
+		d.Serve(w, r)
+		resp := w.Result()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, allowWrite, strings.Contains(string(body), `enctype="multipart/form-data"`))
+		assert.Equal(t, allowWrite, strings.Contains(string(body), `<input type="hidden" name="delete" value="file">`))
+	}
+}
@@ -86,7 +86,8 @@ func (d *Dir) cacheCleanup() {
 	_, stale := d._age(when)
 	d.mu.Unlock()
 
-	if stale {
+	// Listings can't be read again while offline so keep them
+	if stale && !d.vfs.IsOffline() {
 		d.ForgetAll()
 	}
 }
@@ -222,6 +223,18 @@ func (d *Dir) addVirtual(n int32) {
 // entries so could not be forgotten. Children which didn't have
 // virtual entries will be forgotten even if true is returned.
 func (d *Dir) ForgetAll() (hasVirtual bool) {
+	if d.vfs.dirCache != nil {
+		d.mu.RLock()
+		dirPath := d.path
+		d.mu.RUnlock()
+		d.vfs.dirCache.remove(dirPath, true)
+	}
+	return d.forgetAll()
+}
+
+// forgetAll forgets the directory entries in memory for this
+// directory and any children - see ForgetAll.
+func (d *Dir) forgetAll() (hasVirtual bool) {
 	// We run this part with RLock only to avoid deadlocks in the recursion
 
 	d.mu.RLock()
@@ -229,7 +242,7 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 	fs.Debugf(d.path, "forgetting directory cache")
 	for _, node := range d.items {
 		if dir, ok := node.(*Dir); ok {
-			dir.ForgetAll()
+			dir.forgetAll()
 		}
 	}
 
@@ -265,6 +278,12 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 func (d *Dir) forgetDirPath(relativePath string) {
 	dir := d.cachedDir(relativePath)
 	if dir == nil {
+		if d.vfs.dirCache != nil {
+			d.mu.RLock()
+			absPath := path.Join(d.path, relativePath)
+			d.mu.RUnlock()
+			d.vfs.dirCache.remove(absPath, true)
+		}
 		return
 	}
 	dir.ForgetAll()
@@ -272,6 +291,9 @@ func (d *Dir) forgetDirPath(relativePath string) {
 
 // invalidateDir invalidates the directory cache for absPath relative to the root
 func (d *Dir) invalidateDir(absPath string) {
+	if d.vfs.dirCache != nil {
+		d.vfs.dirCache.remove(absPath, false)
+	}
 	node := d.vfs.root.cachedNode(absPath)
 	if dir, ok := node.(*Dir); ok {
 		dir.mu.Lock()
@@ -458,6 +480,7 @@ func (d *Dir) addObject(node Node) {
 	}
 	d.virtual[leaf] = vAdd
 	fs.Debugf(d.path, "Added virtual directory entry %v: %q", vAdd, leaf)
+	d._removeDirCache()
 	d.mu.Unlock()
 }
 
@@ -514,6 +537,7 @@ func (d *Dir) delObject(leaf string) {
 	}
 	d.virtual[leaf] = vDel
 	fs.Debugf(d.path, "Added virtual directory entry %v: %q", vDel, leaf)
+	d._removeDirCache()
 	d.mu.Unlock()
 }
 
@@ -528,6 +552,84 @@ func (d *Dir) DelVirtual(leaf string) {
 	d.delObject(leaf)
 }
 
+// remove the saved listing of this directory from the on disk
+// directory cache, if in use, as it has changed - must be called with
+// the lock held
+func (d *Dir) _removeDirCache() {
+	if d.vfs.dirCache != nil {
+		d.vfs.dirCache.remove(d.path, false)
+	}
+}
+
+// read the directory from the on disk directory cache if it is in
+// use and the saved listing is fresh enough. It returns true if it
+// was read - must be called with the lock held
+func (d *Dir) _readDirFromDirCache(when time.Time) bool {
+	if d.vfs.dirCache == nil || !d.read.IsZero() {
+		return false
+	}
+	entries, read, ok := d.vfs.dirCache.get(d.path)
+	if !ok {
+		return false
+	}
+	age := when.Sub(read)
+	if age > time.Duration(d.vfs.Opt.DirCacheTime) || age < 0 {
+		return false
+	}
+	if err := d._readDirFromEntries(entries, nil, time.Time{}); err != nil {
+		fs.Debugf(d.path, "Failed to use saved directory listing: %v", err)
+		return false
+	}
+	fs.Debugf(d.path, "Read directory from directory cache (%v old)", age)
+	d.read = read
+	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	return true
+}
+
+// read the directory while offline from the on disk directory cache,
+// whatever its age, or failing that from the files in the VFS cache.
+// Listings already in memory are kept - must be called with the lock
+// held
+func (d *Dir) _readDirOffline(when time.Time) error {
+	if !d.read.IsZero() {
+		return nil
+	}
+	var (
+		entries fs.DirEntries
+		ok      bool
+	)
+	if d.vfs.dirCache != nil {
+		entries, _, ok = d.vfs.dirCache.get(d.path)
+	}
+	if !ok {
+		cached, err := d.vfs.cache.DirEntries(d.vfs.ctx, d.path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range cached {
+			modTime := entry.ModTime(d.vfs.ctx)
+			switch entry.(type) {
+			case fs.Object:
+				entries = append(entries, &cachedObject{
+					f:       d.f,
+					remote:  entry.Remote(),
+					size:    entry.Size(),
+					modTime: modTime,
+				})
+			case fs.Directory:
+				entries = append(entries, fs.NewDir(entry.Remote(), modTime))
+			}
+		}
+	}
+	fs.Debugf(d.path, "Read directory while offline")
+	err := d._readDirFromEntries(entries, nil, time.Time{})
+	if err != nil {
+		return err
+	}
+	d.read = when
+	return nil
+}
+
 // read the directory and sets d.items - must be called with the lock held
 func (d *Dir) _readDir() error {
 	when := time.Now()
@@ -538,6 +640,12 @@ func (d *Dir) _readDir() error {
 	} else {
 		return nil
 	}
+	if d.vfs.IsOffline() {
+		return d._readDirOffline(when)
+	}
+	if d._readDirFromDirCache(when) {
+		return nil
+	}
 	entries, err := list.DirSorted(d.vfs.ctx, d.f, false, d.path)
 	if err == fs.ErrorDirNotFound {
 		// We treat directory not found as empty because we
@@ -583,6 +691,10 @@ func (d *Dir) _readDir() error {
 	d.read = time.Now()
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
 
+	if d.vfs.dirCache != nil {
+		d.vfs.dirCache.put(map[string]fs.DirEntries{d.path: entries}, d.read)
+	}
+
 	return nil
 }
 
@@ -807,6 +919,9 @@ func (d *Dir) readDirTree() error {
 	fs.Debugf(d.path, "Reading directory tree done in %s", time.Since(when))
 	d.read = when
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	if d.vfs.dirCache != nil {
+		d.vfs.dirCache.put(dt, when)
+	}
 	return nil
 }
 
@@ -815,6 +930,7 @@ func (d *Dir) readDir() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.read = time.Time{}
+	d._removeDirCache()
 	return d._readDir()
 }
 
@@ -1084,7 +1200,11 @@ func (d *Dir) Mkdir(name string) (*Dir, error) {
 		return nil, err
 	}
 	// fs.Debugf(path, "Dir.Mkdir")
-	err = d.f.Mkdir(d.vfs.ctx, path)
+	err = d.vfs.offlineDo(func() error {
+		return d.f.Mkdir(d.vfs.ctx, path)
+	}, func() offlineOp {
+		return offlineOp{Op: offlineMkdir, Path: path}
+	})
 	if err != nil {
 		fs.Errorf(d, "Dir.Mkdir failed to create directory: %v", err)
 		return nil, err
@@ -1116,7 +1236,11 @@ func (d *Dir) Remove() error {
 		return ENOTEMPTY
 	}
 	// remove directory
-	err = d.f.Rmdir(d.vfs.ctx, d.path)
+	err = d.vfs.offlineDo(func() error {
+		return d.f.Rmdir(d.vfs.ctx, d.path)
+	}, func() offlineOp {
+		return offlineOp{Op: offlineRmdir, Path: d.path}
+	})
 	if err != nil {
 		fs.Errorf(d, "Dir.Remove failed to remove directory: %v", err)
 		return err
@@ -1219,7 +1343,11 @@ func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 		}
 		srcRemote := x.Remote()
 		dstRemote := newPath
-		err = operations.DirMove(d.vfs.ctx, d.f, srcRemote, dstRemote)
+		err = d.vfs.offlineDo(func() error {
+			return operations.DirMove(d.vfs.ctx, d.f, srcRemote, dstRemote)
+		}, func() offlineOp {
+			return offlineOp{Op: offlineDirMove, Path: srcRemote, NewPath: dstRemote}
+		})
 		if err != nil {
 			fs.Errorf(oldPath, "Dir.Rename error: %v", err)
 			return err
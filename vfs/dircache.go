@@ -0,0 +1,365 @@
+package vfs
+
+// This implements the optional on disk directory cache enabled with
+// --vfs-dir-cache-persist.
+//
+// Each directory listing read from the remote is saved in the key
+// value database in the rclone cache directory along with the time it
+// was read. When a directory is first needed after a restart its
+// listing is read from the database instead of the remote if it is
+// younger than --dir-cache-time.
+//
+// Any change to a directory made through the VFS, or reported by
+// ChangeNotify, removes its saved listing so it is read again from
+// the remote the next time it is needed.
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// Facility name for the directory cache database
+const dirCacheFacility = "vfs-dircache"
+
+// dirCacheEntry is a single saved directory entry
+type dirCacheEntry struct {
+	Name    string    `json:"n"`
+	IsDir   bool      `json:"d,omitempty"`
+	Size    int64     `json:"s"`
+	ModTime time.Time `json:"t"`
+}
+
+// dirCacheListing is the saved listing of a directory
+type dirCacheListing struct {
+	Read    time.Time       `json:"read"`
+	Entries []dirCacheEntry `json:"entries"`
+}
+
+// dirCache saves directory listings on disk
+type dirCache struct {
+	*kvStore
+	prefix string // prefix for the keys of this VFS
+}
+
+// newDirCache opens the on disk directory cache for vfs
+func newDirCache(vfs *VFS) (*dirCache, error) {
+	// Listings depend on the filters in use so keep them separately
+	prefix := fs.ConfigString(vfs.f)
+	if fi := filter.GetConfig(vfs.ctx); !fi.InActive() {
+		opt, err := json.Marshal(fi.Opt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode filters: %w", err)
+		}
+		sum := md5.Sum(opt)
+		prefix += "{" + hex.EncodeToString(sum[:4]) + "}"
+	}
+	store, err := openKVStore(vfs.ctx, dirCacheFacility, vfs.f)
+	if err != nil {
+		return nil, err
+	}
+	return &dirCache{
+		kvStore: store,
+		prefix:  prefix + ":",
+	}, nil
+}
+
+// key returns the database key for dirPath
+func (dc *dirCache) key(dirPath string) []byte {
+	return []byte(dc.prefix + dirPath)
+}
+
+// dirCacheGet: read a listing
+type dirCacheGet struct {
+	key     []byte
+	listing *dirCacheListing
+}
+
+func (op *dirCacheGet) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get(op.key)
+	if data == nil {
+		return nil
+	}
+	op.listing = new(dirCacheListing)
+	return json.Unmarshal(data, op.listing)
+}
+
+// dirCachePut: write listings
+type dirCachePut struct {
+	listings map[string][]byte
+}
+
+func (op *dirCachePut) Do(ctx context.Context, b kv.Bucket) error {
+	for key, data := range op.listings {
+		if err := b.Put([]byte(key), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirCacheFind: find the keys of a listing and optionally
+// everything below it
+type dirCacheFind struct {
+	key       []byte
+	recursive bool
+	keys      [][]byte
+}
+
+func (op *dirCacheFind) Do(ctx context.Context, b kv.Bucket) error {
+	if b.Get(op.key) != nil {
+		op.keys = append(op.keys, op.key)
+	}
+	if !op.recursive {
+		return nil
+	}
+	// The root has the prefix as its key so has no trailing /
+	prefix := op.key
+	if prefix[len(prefix)-1] != ':' {
+		prefix = append(prefix, '/')
+	}
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+		op.keys = append(op.keys, append([]byte{}, k...))
+	}
+	return nil
+}
+
+// dirCacheDelete: remove listings
+type dirCacheDelete struct {
+	keys [][]byte
+}
+
+func (op *dirCacheDelete) Do(ctx context.Context, b kv.Bucket) error {
+	for _, k := range op.keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the saved entries for dirPath and the time they were
+// read or false if there isn't a listing.
+func (dc *dirCache) get(dirPath string) (entries fs.DirEntries, when time.Time, ok bool) {
+	op := &dirCacheGet{key: dc.key(dirPath)}
+	err := dc.do(false, op)
+	if err != nil && !ignoreKVError(err) {
+		fs.Errorf(dirPath, "Failed to read directory cache: %v", err)
+		return nil, when, false
+	}
+	if op.listing == nil {
+		return nil, when, false
+	}
+	entries = make(fs.DirEntries, 0, len(op.listing.Entries))
+	for _, entry := range op.listing.Entries {
+		remote := path.Join(dirPath, entry.Name)
+		if entry.IsDir {
+			entries = append(entries, fs.NewDir(remote, entry.ModTime).SetSize(entry.Size))
+		} else {
+			entries = append(entries, &cachedObject{
+				f:       dc.f,
+				remote:  remote,
+				size:    entry.Size,
+				modTime: entry.ModTime,
+			})
+		}
+	}
+	return entries, op.listing.Read, true
+}
+
+// put saves the listings in dirEntries which were read at when
+func (dc *dirCache) put(dirEntries map[string]fs.DirEntries, when time.Time) {
+	ctx := context.Background()
+	op := &dirCachePut{listings: make(map[string][]byte, len(dirEntries))}
+	for dirPath, entries := range dirEntries {
+		listing := dirCacheListing{
+			Read:    when,
+			Entries: make([]dirCacheEntry, 0, len(entries)),
+		}
+		for _, entry := range entries {
+			_, isDir := entry.(fs.Directory)
+			listing.Entries = append(listing.Entries, dirCacheEntry{
+				Name:    path.Base(entry.Remote()),
+				IsDir:   isDir,
+				Size:    entry.Size(),
+				ModTime: entry.ModTime(ctx),
+			})
+		}
+		data, err := json.Marshal(&listing)
+		if err != nil {
+			fs.Errorf(dirPath, "Failed to encode directory cache: %v", err)
+			continue
+		}
+		op.listings[string(dc.key(dirPath))] = data
+	}
+	err := dc.do(true, op)
+	if err != nil && !ignoreKVError(err) {
+		fs.Errorf(dc.f, "Failed to save directory cache: %v", err)
+	}
+}
+
+// remove deletes the listing for dirPath and if recursive all the
+// listings below it.
+//
+// This is called for every change to a directory so it looks for the
+// listings first to avoid writing to the database if there are none.
+func (dc *dirCache) remove(dirPath string, recursive bool) {
+	find := &dirCacheFind{key: dc.key(dirPath), recursive: recursive}
+	err := dc.do(false, find)
+	if err == nil && len(find.keys) > 0 {
+		err = dc.do(true, &dirCacheDelete{keys: find.keys})
+	}
+	if err != nil && !ignoreKVError(err) {
+		fs.Errorf(dirPath, "Failed to remove from directory cache: %v", err)
+	}
+}
+
+// cachedObject is an fs.Object made from a saved directory listing.
+//
+// It knows its size and modification time but anything else looks
+// up the real object on the remote the first time it is needed.
+type cachedObject struct {
+	f       fs.Fs
+	remote  string
+	size    int64
+	modTime time.Time
+
+	mu sync.Mutex
+	o  fs.Object // the real object once found
+}
+
+// resolve returns the real object, finding it if necessary
+func (o *cachedObject) resolve(ctx context.Context) (fs.Object, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o == nil {
+		obj, err := o.f.NewObject(ctx, o.remote)
+		if err != nil {
+			return nil, err
+		}
+		o.o = obj
+	}
+	return o.o, nil
+}
+
+// resolveObject returns the real object for o if it came from the
+// directory cache or o otherwise
+func resolveObject(ctx context.Context, o fs.Object) (fs.Object, error) {
+	if co, ok := o.(*cachedObject); ok {
+		return co.resolve(ctx)
+	}
+	return o, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *cachedObject) Fs() fs.Info {
+	return o.f
+}
+
+// String returns the remote path
+func (o *cachedObject) String() string {
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *cachedObject) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification date of the file
+func (o *cachedObject) ModTime(ctx context.Context) time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o != nil {
+		return o.o.ModTime(ctx)
+	}
+	return o.modTime
+}
+
+// Size returns the size of the file
+func (o *cachedObject) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o != nil {
+		return o.o.Size()
+	}
+	return o.size
+}
+
+// Storable says whether this object can be stored
+func (o *cachedObject) Storable() bool {
+	return true
+}
+
+// Hash returns the requested hash of the real object
+func (o *cachedObject) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return "", err
+	}
+	return obj.Hash(ctx, ht)
+}
+
+// SetModTime sets the modification time of the real object
+func (o *cachedObject) SetModTime(ctx context.Context, t time.Time) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.SetModTime(ctx, t)
+}
+
+// Open opens the real object for read
+func (o *cachedObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Open(ctx, options...)
+}
+
+// Update the real object with the contents of in
+func (o *cachedObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Update(ctx, in, src, options...)
+}
+
+// Remove the real object
+func (o *cachedObject) Remove(ctx context.Context) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Remove(ctx)
+}
+
+// Metadata returns the metadata of the real object
+func (o *cachedObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetMetadata(ctx, obj)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object     = (*cachedObject)(nil)
+	_ fs.Metadataer = (*cachedObject)(nil)
+)
@@ -0,0 +1,103 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirCachePersist(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv database not supported")
+	}
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+
+	// Hold a reference to the database so it isn't removed when
+	// the VFS is restarted in the test
+	db, err := kv.Start(ctx, dirCacheFacility, r.Fremote)
+	require.NoError(t, err)
+	defer func() { _ = db.Stop(false) }()
+
+	opt := vfscommon.Opt
+	opt.DirCachePersist = true
+	opt.DirCacheTime = fs.Duration(time.Hour)
+	opt.PollInterval = 0
+
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", t1)
+	file2 := r.WriteObject(ctx, "dir/file2", "file2- contents", t2)
+	r.CheckRemoteItems(t, file1, file2)
+
+	vfs := New(ctx, r.Fremote, &opt)
+	require.NotNil(t, vfs.dirCache)
+	node, err := vfs.Stat("dir")
+	require.NoError(t, err)
+	checkListing(t, node.(*Dir), []string{"file1,14,false", "file2,15,false"})
+	_, _, ok := vfs.dirCache.get("")
+	assert.True(t, ok)
+	entries, _, ok := vfs.dirCache.get("dir")
+	require.True(t, ok)
+	assert.Equal(t, 2, len(entries))
+	cleanupVFS(t, vfs)
+
+	// Change the remote behind the back of the VFS
+	require.NoError(t, r.Fremote.Mkdir(ctx, "dir2"))
+	file3 := r.WriteObject(ctx, "dir/file3", "file3-- contents", t3)
+
+	// A restarted VFS uses the saved listing
+	vfs = New(ctx, r.Fremote, &opt)
+	node, err = vfs.Stat("dir")
+	require.NoError(t, err)
+	dir := node.(*Dir)
+	checkListing(t, dir, []string{"file1,14,false", "file2,15,false"})
+	checkListing(t, vfs.root, []string{"dir,0,true"})
+
+	// The files can be read and have the right modtimes
+	node, err = vfs.Stat("dir/file2")
+	require.NoError(t, err)
+	file := node.(*File)
+	fstest.AssertTimeEqualWithPrecision(t, "file2", t2, file.ModTime(), r.Fremote.Precision())
+	fd, err := file.Open(0)
+	require.NoError(t, err)
+	buf := make([]byte, 32)
+	n, _ := fd.Read(buf)
+	assert.Equal(t, "file2- contents", string(buf[:n]))
+	require.NoError(t, fd.Close())
+
+	// Renaming a file from the saved listing updates the remote and
+	// drops the listing
+	require.NoError(t, vfs.Rename("dir/file1", "dir/file1-renamed"))
+	_, _, ok = vfs.dirCache.get("dir")
+	assert.False(t, ok)
+
+	// Invalidating the directory reads it again from the remote
+	vfs.dirCache.put(map[string]fs.DirEntries{"dir": nil}, time.Now())
+	dir.invalidateDir("dir")
+	_, _, ok = vfs.dirCache.get("dir")
+	assert.False(t, ok)
+	checkListing(t, dir, []string{"file1-renamed,14,false", "file2,15,false", "file3,16,false"})
+
+	// Forgetting everything drops all the listings
+	vfs.FlushDirCache()
+	_, _, ok = vfs.dirCache.get("")
+	assert.False(t, ok)
+	checkListing(t, vfs.root, []string{"dir,0,true", "dir2,0,true"})
+	cleanupVFS(t, vfs)
+
+	file1.Path = "dir/file1-renamed"
+	r.CheckRemoteItems(t, file1, file2, file3)
+
+	// Saved listings which are too old are not used
+	opt.DirCacheTime = fs.Duration(time.Nanosecond)
+	require.NoError(t, r.Fremote.Rmdir(ctx, "dir2"))
+	vfs = New(ctx, r.Fremote, &opt)
+	defer cleanupVFS(t, vfs)
+	checkListing(t, vfs.root, []string{"dir,0,true"})
+}
@@ -267,9 +267,42 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 				return nil // no need to rename
 			}
 
-			// do the move of the remote object
-			dstOverwritten, _ := d.Fs().NewObject(ctx, newPath)
-			newObject, err = operations.Move(ctx, d.Fs(), dstOverwritten, newPath, o)
+			err = d.vfs.offlineDo(func() error {
+				// backends need the real object to move it
+				o, err := resolveObject(ctx, o)
+				if err != nil {
+					return err
+				}
+
+				// do the move of the remote object
+				dstOverwritten, _ := d.Fs().NewObject(ctx, newPath)
+				newObject, err = operations.Move(ctx, d.Fs(), dstOverwritten, newPath, o)
+				return err
+			}, func() offlineOp {
+				op := offlineOp{
+					Op:      offlineMove,
+					Path:    o.Remote(),
+					NewPath: newPath,
+					Size:    o.Size(),
+					ModTime: o.ModTime(ctx),
+				}
+				// Note the file being overwritten so changes to
+				// it on the remote can be detected later
+				if node, err := destDir.stat(path.Base(newPath)); err == nil {
+					if dst, ok := node.DirEntry().(fs.Object); ok {
+						op.Replace = true
+						op.DstSize = dst.Size()
+						op.DstTime = dst.ModTime(ctx)
+					}
+				}
+				newObject = &cachedObject{
+					f:       d.Fs(),
+					remote:  newPath,
+					size:    op.Size,
+					modTime: op.ModTime,
+				}
+				return op
+			})
 			if err != nil {
 				fs.Errorf(f.Path(), "File.Rename error: %v", err)
 				return err
@@ -501,10 +534,18 @@ func (f *File) _applyPendingModTime() error {
 	}
 
 	// set the time of the object
-	err := f.o.SetModTime(f.ctx, f.pendingModTime)
+	o, modTime := f.o, f.pendingModTime
+	err := f.d.vfs.offlineDo(func() error {
+		return o.SetModTime(f.ctx, modTime)
+	}, func() offlineOp {
+		return offlineOp{Op: offlineModTime, Path: o.Remote(), ModTime: modTime}
+	})
 	switch err {
 	case nil:
 		fs.Debugf(f.o, "Applied pending mod time %v OK", f.pendingModTime)
+		if f.d.vfs.dirCache != nil {
+			f.d.vfs.dirCache.remove(f.dPath, false)
+		}
 	case fs.ErrorCantSetModTime, fs.ErrorCantSetModTimeWithoutDelete:
 		// do nothing, in order to not break "touch somefile" if it exists already
 	default:
@@ -683,8 +724,12 @@ func (f *File) Remove() (err error) {
 
 	f.muRW.Lock() // muRW must be locked before mu to avoid
 	f.mu.Lock()   // deadlock in RWFileHandle.openPending and .close
-	if f.o != nil {
-		err = f.o.Remove(f.ctx)
+	if o := f.o; o != nil {
+		err = d.vfs.offlineDo(func() error {
+			return o.Remove(f.ctx)
+		}, func() offlineOp {
+			return offlineOp{Op: offlineRemove, Path: o.Remote(), Size: o.Size(), ModTime: o.ModTime(f.ctx)}
+		})
 	}
 	f.mu.Unlock()
 	f.muRW.Unlock()
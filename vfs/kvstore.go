@@ -0,0 +1,70 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// kvStore is a key value database used by the VFS.
+//
+// Unlike kv.DB it can be closed while other go routines are still
+// using it, after which any operations return kv.ErrInactive.
+type kvStore struct {
+	f fs.Fs
+
+	mu     sync.RWMutex // held for reading while using db
+	db     *kv.DB
+	closed bool // set if db has been stopped
+}
+
+// openKVStore opens the database for facility on f
+func openKVStore(ctx context.Context, facility string, f fs.Fs) (*kvStore, error) {
+	if !kv.Supported() {
+		return nil, errors.New("key value database not supported on this OS")
+	}
+	db, err := kv.Start(ctx, facility, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", facility, err)
+	}
+	fs.Debugf(f, "Using %s database %q", facility, db.Path())
+	return &kvStore{
+		f:  f,
+		db: db,
+	}, nil
+}
+
+// do runs op on the database unless it has been closed
+func (s *kvStore) do(write bool, op kv.Op) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return kv.ErrInactive
+	}
+	return s.db.Do(write, op)
+}
+
+// close the database
+func (s *kvStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	err := s.db.Stop(false)
+	if err != nil {
+		fs.Errorf(s.f, "Failed to close database: %v", err)
+	}
+}
+
+// ignoreKVError returns true for errors which mean there is nothing
+// in the database or it has been stopped because the VFS has been
+// shut down.
+func ignoreKVError(err error) bool {
+	return errors.Is(err, kv.ErrEmpty) || errors.Is(err, kv.ErrInactive)
+}
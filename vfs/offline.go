@@ -0,0 +1,447 @@
+package vfs
+
+// This implements the offline mode of the VFS.
+//
+// While offline the VFS serves what it has in memory, in the on disk
+// directory cache and in the VFS cache without using the remote.
+// Changes to files are kept in the VFS cache and uploaded by the
+// writeback when back online. Other changes (mkdir, rmdir, remove,
+// rename and setting modification times) are applied to the VFS
+// immediately and saved in a log in the key value database to be
+// replayed, in order, when back online.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// Facility name for the offline log database
+const offlineFacility = "vfs-offline"
+
+// Operations which can be saved in the offline log
+const (
+	offlineMkdir   = "mkdir"
+	offlineRmdir   = "rmdir"
+	offlineRemove  = "remove"
+	offlineMove    = "move"
+	offlineDirMove = "dirmove"
+	offlineModTime = "modtime"
+)
+
+// offlineOp is an operation saved in the offline log
+type offlineOp struct {
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	NewPath string    `json:"newPath,omitempty"`
+	Size    int64     `json:"size,omitempty"`    // size of the object at Path
+	ModTime time.Time `json:"modTime"`           // modtime of the object at Path or modtime to set
+	DstSize int64     `json:"dstSize,omitempty"` // size of the object overwritten at NewPath
+	DstTime time.Time `json:"dstTime"`           // modtime of the object overwritten at NewPath
+	Replace bool      `json:"replace,omitempty"` // set if the move replaces an object at NewPath
+	Time    time.Time `json:"time"`              // when the operation was done
+}
+
+// String describes the operation for logging
+func (op *offlineOp) String() string {
+	if op.NewPath != "" {
+		return fmt.Sprintf("%s %q to %q", op.Op, op.Path, op.NewPath)
+	}
+	return fmt.Sprintf("%s %q", op.Op, op.Path)
+}
+
+// sameObject returns true if o still has size and modTime
+func sameObject(ctx context.Context, o fs.Object, size int64, modTime time.Time) bool {
+	if o.Size() != size {
+		return false
+	}
+	precision := o.Fs().Precision()
+	if precision == fs.ModTimeNotSupported {
+		return true
+	}
+	dt := o.ModTime(ctx).Sub(modTime)
+	return dt < precision && dt > -precision
+}
+
+// offlineAdd: save an operation
+type offlineAdd struct {
+	key  []byte
+	data []byte
+}
+
+func (op *offlineAdd) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Put(op.key, op.data)
+}
+
+// offlineList: read the saved operations in order
+type offlineList struct {
+	prefix []byte
+	keys   [][]byte
+	ops    []offlineOp
+}
+
+func (op *offlineList) Do(ctx context.Context, b kv.Bucket) error {
+	c := b.Cursor()
+	for k, v := c.Seek(op.prefix); k != nil && strings.HasPrefix(string(k), string(op.prefix)); k, v = c.Next() {
+		var entry offlineOp
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return fmt.Errorf("failed to decode offline log entry %q: %w", k, err)
+		}
+		op.keys = append(op.keys, append([]byte{}, k...))
+		op.ops = append(op.ops, entry)
+	}
+	return nil
+}
+
+// offlineDelete: remove a saved operation
+type offlineDelete struct {
+	key []byte
+}
+
+func (op *offlineDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete(op.key)
+}
+
+// offline manages the offline mode of the VFS
+type offline struct {
+	vfs    *VFS
+	log    *kvStore
+	prefix string // prefix for the keys of this VFS
+
+	// mu is held for reading while changing the VFS so it can't
+	// go online between checking for offline and logging the change
+	mu     sync.RWMutex
+	on     atomic.Bool  // set if offline
+	manual atomic.Bool  // set if the user asked to be offline so don't reconnect
+	seq    atomic.Int64 // sequence number of the last saved operation
+	queued atomic.Int64 // number of saved operations
+}
+
+// newOffline opens the offline log for vfs
+func newOffline(vfs *VFS) (*offline, error) {
+	store, err := openKVStore(vfs.ctx, offlineFacility, vfs.f)
+	if err != nil {
+		return nil, err
+	}
+	o := &offline{
+		vfs:    vfs,
+		log:    store,
+		prefix: fs.ConfigString(vfs.f) + ":",
+	}
+	keys, _, err := o.list()
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+	if len(keys) > 0 {
+		var seq int64
+		_, _ = fmt.Sscanf(strings.TrimPrefix(string(keys[len(keys)-1]), o.prefix), "%d", &seq)
+		o.seq.Store(seq)
+	}
+	o.queued.Store(int64(len(keys)))
+	return o, nil
+}
+
+// list returns the saved operations in order
+func (o *offline) list() (keys [][]byte, ops []offlineOp, err error) {
+	op := &offlineList{prefix: []byte(o.prefix)}
+	err = o.log.do(false, op)
+	if err != nil && !ignoreKVError(err) {
+		return nil, nil, fmt.Errorf("failed to read offline log: %w", err)
+	}
+	return op.keys, op.ops, nil
+}
+
+// add saves op to the log - call with mu held for reading
+func (o *offline) add(op offlineOp) error {
+	op.Time = time.Now()
+	data, err := json.Marshal(&op)
+	if err != nil {
+		return fmt.Errorf("failed to encode offline operation: %w", err)
+	}
+	key := fmt.Sprintf("%s%020d", o.prefix, o.seq.Add(1))
+	err = o.log.do(true, &offlineAdd{key: []byte(key), data: data})
+	if err != nil {
+		return fmt.Errorf("failed to save offline operation: %w", err)
+	}
+	o.queued.Add(1)
+	fs.Debugf(op.Path, "vfs offline: queued %v", &op)
+	return nil
+}
+
+// do runs online if the VFS is online, otherwise it saves the
+// operation returned by op in the log to be replayed later.
+func (o *offline) do(online func() error, op func() offlineOp) error {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if !o.on.Load() {
+		return online()
+	}
+	return o.add(op())
+}
+
+// setOffline puts the cache and the change polling into offline
+// mode or out of it.
+func (o *offline) setOffline(offline bool) {
+	o.on.Store(offline)
+	o.vfs.cache.SetOffline(offline)
+	if o.vfs.pollChan != nil {
+		interval := time.Duration(o.vfs.Opt.PollInterval)
+		if offline {
+			interval = 0
+		}
+		select {
+		case o.vfs.pollChan <- interval:
+		case <-o.vfs.ctx.Done():
+		}
+	}
+}
+
+// goOnline replays the saved operations then leaves offline mode.
+//
+// If the remote isn't available it stays offline and returns an
+// error.
+func (o *offline) goOnline(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.on.Load() {
+		return nil
+	}
+	if err := o.check(ctx); err != nil {
+		return err
+	}
+	keys, ops, err := o.list()
+	if err != nil {
+		return err
+	}
+	if len(ops) > 0 {
+		fs.Infof(o.vfs.f, "vfs offline: replaying %d operations", len(ops))
+	}
+	for i := range ops {
+		op := &ops[i]
+		err := o.replay(ctx, op)
+		if err != nil {
+			// Stop if the remote has gone away again,
+			// otherwise the operation can never succeed
+			if checkErr := o.check(ctx); checkErr != nil {
+				return checkErr
+			}
+			fs.Errorf(op.Path, "vfs offline: failed to replay %v - skipping: %v", op, err)
+		}
+		err = o.log.do(true, &offlineDelete{key: keys[i]})
+		if err != nil {
+			return fmt.Errorf("failed to remove operation from offline log: %w", err)
+		}
+		o.queued.Add(-1)
+	}
+	o.setOffline(false)
+	fs.Logf(o.vfs.f, "vfs offline: back online")
+	return nil
+}
+
+// check the remote can be reached
+func (o *offline) check(ctx context.Context) error {
+	_, err := o.vfs.f.List(ctx, "")
+	if err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+		return fmt.Errorf("remote not available: %w", err)
+	}
+	return nil
+}
+
+// replay a single operation on the remote
+//
+// Changes made on the remote while offline take precedence: files
+// which have changed aren't removed and renames which would
+// overwrite a changed file use a conflict name instead.
+func (o *offline) replay(ctx context.Context, op *offlineOp) (err error) {
+	f := o.vfs.f
+	fs.Debugf(op.Path, "vfs offline: replaying %v", op)
+	switch op.Op {
+	case offlineMkdir:
+		return f.Mkdir(ctx, op.Path)
+	case offlineRmdir:
+		return f.Rmdir(ctx, op.Path)
+	case offlineDirMove:
+		return operations.DirMove(ctx, f, op.Path, op.NewPath)
+	case offlineRemove:
+		obj, err := f.NewObject(ctx, op.Path)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !sameObject(ctx, obj, op.Size, op.ModTime) {
+			fs.Logf(op.Path, "vfs offline: not removing as changed on the remote while offline")
+			return nil
+		}
+		return obj.Remove(ctx)
+	case offlineMove:
+		src, err := f.NewObject(ctx, op.Path)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			fs.Logf(op.Path, "vfs offline: not renaming to %q as removed on the remote while offline", op.NewPath)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		newPath := op.NewPath
+		dst, err := f.NewObject(ctx, newPath)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			dst = nil
+		} else if err != nil {
+			return err
+		} else if !op.Replace || !sameObject(ctx, dst, op.DstSize, op.DstTime) {
+			newPath = vfscommon.ConflictName(newPath, time.Now())
+			fs.Logf(op.Path, "vfs offline: %q changed on the remote while offline - renaming to %q instead", op.NewPath, newPath)
+			dst = nil
+		}
+		_, err = operations.Move(ctx, f, dst, newPath, src)
+		return err
+	case offlineModTime:
+		obj, err := f.NewObject(ctx, op.Path)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = obj.SetModTime(ctx, op.ModTime)
+		if errors.Is(err, fs.ErrorCantSetModTime) || errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("unknown offline operation %q", op.Op)
+}
+
+// startOffline sets up offline mode for the VFS cache. The VFS
+// starts offline if --vfs-offline is set or there are operations left
+// in the log, which are replayed in the background if not.
+//
+// It starts the reconnect loop which runs until ctx is cancelled.
+func (vfs *VFS) startOffline(ctx context.Context) {
+	o, err := newOffline(vfs)
+	if err != nil {
+		fs.Errorf(vfs.f, "Offline mode not available: %v", err)
+		return
+	}
+	vfs.offline = o
+	go vfs.reconnect(ctx)
+	pending := o.queued.Load()
+	if !vfs.Opt.Offline && pending == 0 {
+		return
+	}
+	o.setOffline(true)
+	if vfs.Opt.Offline {
+		o.manual.Store(true)
+		fs.Logf(vfs.f, "vfs offline: starting offline with %d queued operations", pending)
+		return
+	}
+	go func() {
+		err := vfs.goOnline()
+		if err != nil {
+			fs.Errorf(vfs.f, "vfs offline: staying offline as failed to replay %d queued operations: %v", pending, err)
+		}
+	}()
+}
+
+// reconnect tries to go back online every --vfs-cache-poll-interval
+// while the VFS is offline, unless the user asked for it to be
+// offline.
+//
+// doesn't return until ctx is cancelled
+func (vfs *VFS) reconnect(ctx context.Context) {
+	interval := time.Duration(vfs.Opt.CachePollInterval)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if !vfs.offline.on.Load() || vfs.offline.manual.Load() {
+			continue
+		}
+		err := vfs.goOnline()
+		if err != nil {
+			fs.Debugf(vfs.f, "vfs offline: staying offline: %v", err)
+		}
+	}
+}
+
+// goOnline replays the saved operations and leaves offline mode
+// then makes sure the listings are read again.
+func (vfs *VFS) goOnline() error {
+	err := vfs.offline.goOnline(vfs.ctx)
+	if err != nil {
+		return err
+	}
+	// Listings may be out of date so read them again
+	if vfs.dirCache != nil {
+		vfs.dirCache.remove("", true)
+	}
+	vfs.root.walk(func(d *Dir) {
+		d.read = time.Time{}
+	})
+	return nil
+}
+
+// offlineDo runs online to change the remote unless the VFS is
+// offline in which case the operation returned by op is saved to be
+// replayed when the VFS is back online.
+func (vfs *VFS) offlineDo(online func() error, op func() offlineOp) error {
+	if vfs.offline == nil {
+		return online()
+	}
+	return vfs.offline.do(online, op)
+}
+
+// IsOffline returns true if the VFS is in offline mode
+func (vfs *VFS) IsOffline() bool {
+	return vfs.offline != nil && vfs.offline.on.Load()
+}
+
+// SetOffline puts the VFS into or out of offline mode.
+//
+// While offline the VFS serves what it has cached without using the
+// remote and changes are queued. When going back online the queued
+// changes are replayed and uploads restarted. If the remote can't be
+// reached then the VFS stays offline, trying again every
+// --vfs-cache-poll-interval, and an error is returned.
+func (vfs *VFS) SetOffline(offline bool) error {
+	if vfs.offline == nil {
+		return errors.New("offline mode needs --vfs-cache-mode full")
+	}
+	vfs.offline.manual.Store(offline)
+	if !offline {
+		return vfs.goOnline()
+	}
+	vfs.offline.mu.Lock()
+	defer vfs.offline.mu.Unlock()
+	if !vfs.offline.on.Load() {
+		vfs.offline.setOffline(true)
+		fs.Logf(vfs.f, "vfs offline: now offline")
+	}
+	return nil
+}
+
+// offlineStats returns the state of the offline mode
+func (vfs *VFS) offlineStats() rc.Params {
+	return rc.Params{
+		"offline": vfs.IsOffline(),
+		"queued":  vfs.offline.queued.Load(),
+	}
+}
@@ -0,0 +1,215 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineCacheModeOff(t *testing.T) {
+	_, vfs := newTestVFS(t)
+	assert.ErrorContains(t, vfs.SetOffline(true), "--vfs-cache-mode full")
+	assert.False(t, vfs.IsOffline())
+	_, err := rc.Calls.Get("vfs/offline").Fn(context.Background(), rc.Params{})
+	assert.ErrorContains(t, err, "--vfs-cache-mode full")
+}
+
+// readRemote returns the contents of remote or "" if not found
+func readRemote(t *testing.T, r *fstest.Run, remote string) string {
+	ctx := context.Background()
+	o, err := r.Fremote.NewObject(ctx, remote)
+	if err == fs.ErrorObjectNotFound {
+		return ""
+	}
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, in.Close()) }()
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestOffline(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv database not supported")
+	}
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.WriteBack = fs.Duration(100 * time.Millisecond)
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	t2 := time.Date(2011, 12, 25, 12, 59, 59, 0, time.UTC)
+	r.WriteObject(ctx, "dir/a", "file a", t1)
+	r.WriteObject(ctx, "dir/b", "file bb", t1)
+	r.WriteObject(ctx, "c", "file ccc", t1)
+
+	// Read the directories and c into the cache
+	checkListing(t, vfs.root, []string{"c,8,false", "dir,0,true"})
+	node, err := vfs.Stat("dir")
+	require.NoError(t, err)
+	dir := node.(*Dir)
+	checkListing(t, dir, []string{"a,6,false", "b,7,false"})
+	data, err := vfs.ReadFile("c")
+	require.NoError(t, err)
+	assert.Equal(t, "file ccc", string(data))
+
+	offline := rc.Calls.Get("vfs/offline")
+	out, err := offline.Fn(ctx, rc.Params{"offline": true})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"offline": true, "queued": int64(0)}, out)
+	assert.True(t, vfs.IsOffline())
+
+	// Make changes while offline
+	require.NoError(t, vfs.Mkdir("newdir", 0777))
+	require.NoError(t, vfs.Remove("dir/a"))
+	require.NoError(t, vfs.Rename("dir/b", "dir/b2"))
+	require.NoError(t, vfs.WriteFile("new", []byte("new file"), 0666))
+	require.NoError(t, vfs.WriteFile("c", []byte("local c"), 0666))
+
+	// Data which isn't cached can't be read
+	_, err = vfs.ReadFile("dir/b2")
+	assert.Error(t, err)
+
+	// The VFS shows the changes
+	checkListing(t, vfs.root, []string{"c,7,false", "dir,0,true", "new,8,false", "newdir,0,true"})
+	checkListing(t, dir, []string{"b2,7,false"})
+	out, err = offline.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"offline": true, "queued": int64(3)}, out)
+
+	// But the remote doesn't - give any uploads a chance to happen
+	time.Sleep(3 * time.Duration(opt.WriteBack))
+	assert.Equal(t, "file a", readRemote(t, r, "dir/a"))
+	assert.Equal(t, "file bb", readRemote(t, r, "dir/b"))
+	assert.Equal(t, "", readRemote(t, r, "dir/b2"))
+	assert.Equal(t, "file ccc", readRemote(t, r, "c"))
+	assert.Equal(t, "", readRemote(t, r, "new"))
+
+	// Change the remote behind the back of the VFS
+	r.WriteObject(ctx, "dir/a", "remote a", t2)
+	r.WriteObject(ctx, "c", "remote c", t2)
+
+	// Go back online
+	out, err = offline.Fn(ctx, rc.Params{"offline": false})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"offline": false, "queued": int64(0)}, out)
+	assert.False(t, vfs.IsOffline())
+	vfs.WaitForWriters(10 * time.Second)
+	require.Eventually(t, func() bool {
+		return vfs.cache.Stats()["uploadsQueued"] == 0 && vfs.cache.Stats()["uploadsInProgress"] == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The changes were made except where the remote changed
+	assert.Equal(t, "remote a", readRemote(t, r, "dir/a"))
+	assert.Equal(t, "", readRemote(t, r, "dir/b"))
+	assert.Equal(t, "file bb", readRemote(t, r, "dir/b2"))
+	assert.Equal(t, "new file", readRemote(t, r, "new"))
+	assert.Equal(t, "remote c", readRemote(t, r, "c"))
+	_, err = r.Fremote.List(ctx, "newdir")
+	assert.NoError(t, err)
+
+	// The local copy of c was saved as a conflict
+	entries, err := r.Fremote.List(ctx, "")
+	require.NoError(t, err)
+	var conflict string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Remote(), "c.conflict-") {
+			conflict = entry.Remote()
+		}
+	}
+	require.NotEqual(t, "", conflict)
+	assert.Equal(t, "local c", readRemote(t, r, conflict))
+
+	// The VFS sees the remote again
+	checkListing(t, dir, []string{"a,8,false", "b2,7,false"})
+	fd, err := vfs.OpenFile("c", os.O_RDONLY, 0)
+	require.NoError(t, err)
+	data, err = io.ReadAll(fd)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+	assert.Equal(t, "remote c", string(data))
+}
+
+func TestOfflineRestart(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv database not supported")
+	}
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+
+	// Hold a reference to the database so it isn't removed when
+	// the VFS is restarted in the test
+	db, err := kv.Start(ctx, offlineFacility, r.Fremote)
+	require.NoError(t, err)
+	defer func() { _ = db.Stop(false) }()
+
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.Offline = true
+
+	vfs := New(ctx, r.Fremote, &opt)
+	assert.True(t, vfs.IsOffline())
+	require.NoError(t, vfs.Mkdir("dir", 0777))
+	assert.Equal(t, int64(1), vfs.offline.queued.Load())
+	cleanupVFS(t, vfs)
+	_, err = r.Fremote.List(ctx, "dir")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	// Restarting offline keeps the queued operations
+	vfs = New(ctx, r.Fremote, &opt)
+	assert.True(t, vfs.IsOffline())
+	assert.Equal(t, int64(1), vfs.offline.queued.Load())
+	cleanupVFS(t, vfs)
+
+	// Restarting online replays them
+	opt.Offline = false
+	vfs = New(ctx, r.Fremote, &opt)
+	defer cleanupVFS(t, vfs)
+	require.Eventually(t, func() bool {
+		return !vfs.IsOffline()
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), vfs.offline.queued.Load())
+	_, err = r.Fremote.List(ctx, "dir")
+	assert.NoError(t, err)
+}
+
+func TestOfflineReconnect(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv database not supported")
+	}
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.CachePollInterval = fs.Duration(10 * time.Millisecond)
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	// Going offline by request stays offline
+	require.NoError(t, vfs.SetOffline(true))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, vfs.IsOffline())
+
+	// Going offline because the remote couldn't be reached
+	// reconnects and replays the queued operations
+	vfs.offline.manual.Store(false)
+	require.NoError(t, vfs.Mkdir("dir", 0777))
+	require.Eventually(t, func() bool {
+		return !vfs.IsOffline()
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), vfs.offline.queued.Load())
+	_, err := r.Fremote.List(ctx, "dir")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,142 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscache"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// PinXattr is the extended attribute which pins a file or directory
+// in a mount when it is set and unpins it when it is removed.
+const PinXattr = "user.rclone.pin"
+
+// Pin marks the file or directory name to be kept in the VFS cache.
+//
+// Pinned files are downloaded in full in the background, are never
+// removed by the cache cleaner and are downloaded again if they
+// change on the remote. Pinning a directory pins everything in it
+// recursively, including files created later.
+func (vfs *VFS) Pin(name string) error {
+	if vfs.cache == nil || vfs.Opt.CacheMode < vfscommon.CacheModeFull {
+		return errors.New("pinning needs --vfs-cache-mode full")
+	}
+	node, err := vfs.Stat(name)
+	if err != nil {
+		return err
+	}
+	err = vfs.cache.Pin(pinPath(node))
+	if err != nil {
+		return err
+	}
+	vfs.kickPinner()
+	return nil
+}
+
+// Unpin removes the pin from the file or directory name so it can be
+// removed from the VFS cache as normal.
+func (vfs *VFS) Unpin(name string) error {
+	if vfs.cache == nil {
+		return errors.New("pinning needs --vfs-cache-mode full")
+	}
+	if node, err := vfs.Stat(name); err == nil {
+		name = pinPath(node)
+	}
+	return vfs.cache.Unpin(strings.Trim(name, "/"))
+}
+
+// Pinned returns the sorted list of pinned files and directories
+func (vfs *VFS) Pinned() []string {
+	if vfs.cache == nil {
+		return []string{}
+	}
+	return vfs.cache.Pinned()
+}
+
+// pinPath returns the name of node in the VFS cache
+func pinPath(node Node) string {
+	if f, ok := node.(*File); ok {
+		return f.CachePath()
+	}
+	return node.Path()
+}
+
+// kickPinner starts a pass of the pinner if one isn't queued already
+func (vfs *VFS) kickPinner() {
+	select {
+	case vfs.pinKick <- struct{}{}:
+	default:
+	}
+}
+
+// pinner downloads the pinned files into cache at startup, when
+// kicked and every --vfs-cache-poll-interval
+//
+// doesn't return until context is cancelled
+func (vfs *VFS) pinner(ctx context.Context, cache *vfscache.Cache) {
+	var tick <-chan time.Time
+	if vfs.Opt.CachePollInterval > 0 {
+		ticker := time.NewTicker(time.Duration(vfs.Opt.CachePollInterval))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		vfs.fetchPinned(ctx, cache)
+		select {
+		case <-vfs.pinKick:
+		case <-tick:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fetchPinned makes sure all the pinned files are fully downloaded
+// and up to date in the cache
+func (vfs *VFS) fetchPinned(ctx context.Context, cache *vfscache.Cache) {
+	if vfs.IsOffline() {
+		return
+	}
+	for _, name := range cache.Pinned() {
+		node, err := vfs.Stat(name)
+		if err != nil {
+			fs.Errorf(name, "vfs cache: failed to find pinned item: %v", err)
+			continue
+		}
+		vfs.fetchNode(ctx, cache, node)
+	}
+}
+
+// fetchNode downloads node into the cache recursing into directories
+func (vfs *VFS) fetchNode(ctx context.Context, cache *vfscache.Cache, node Node) {
+	if ctx.Err() != nil {
+		return
+	}
+	switch x := node.(type) {
+	case *Dir:
+		nodes, err := x.ReadDirAll()
+		if err != nil {
+			fs.Errorf(x, "vfs cache: failed to list pinned directory: %v", err)
+			return
+		}
+		for _, node := range nodes {
+			vfs.fetchNode(ctx, cache, node)
+		}
+	case *File:
+		o := x.getObject()
+		if o == nil {
+			// Not uploaded yet so the data is in the cache already
+			return
+		}
+		fetched, err := cache.Fetch(ctx, x.CachePath(), o, nil)
+		if err != nil {
+			fs.Errorf(x, "vfs cache: failed to download pinned file: %v", err)
+		} else if fetched {
+			fs.Infof(x, "vfs cache: downloaded pinned file")
+		}
+	}
+}
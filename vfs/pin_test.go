@@ -0,0 +1,68 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinCacheModeOff(t *testing.T) {
+	_, vfs := newTestVFS(t)
+	assert.ErrorContains(t, vfs.Pin("dir"), "--vfs-cache-mode full")
+	assert.Equal(t, []string{}, vfs.Pinned())
+}
+
+func TestRcPin(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	r.WriteObject(ctx, "dir/a", "file a", t1)
+	r.WriteObject(ctx, "dir/sub/b", "file bb", t1)
+	r.WriteObject(ctx, "c", "file ccc", t1)
+
+	pin := rc.Calls.Get("vfs/pin")
+	unpin := rc.Calls.Get("vfs/unpin")
+	pinned := rc.Calls.Get("vfs/pinned")
+
+	_, err := pin.Fn(ctx, rc.Params{"path": "notfound"})
+	assert.Equal(t, ENOENT, err)
+
+	_, err = pin.Fn(ctx, rc.Params{"path": "dir"})
+	require.NoError(t, err)
+	out, err := pinned.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"pinned": []string{"dir"}}, out)
+
+	// Download the pinned files
+	vfs.fetchPinned(ctx, vfs.cache)
+	for _, test := range []struct {
+		name   string
+		size   int64
+		pinned bool
+	}{
+		{"dir/a", 6, true},
+		{"dir/sub/b", 7, true},
+		{"c", 8, false},
+	} {
+		item := vfs.cache.Item(test.name)
+		assert.Equal(t, test.pinned, item.HasRange(ranges.Range{Pos: 0, Size: test.size}), test.name)
+		assert.Equal(t, test.pinned, vfs.cache.IsPinned(test.name), test.name)
+	}
+
+	_, err = unpin.Fn(ctx, rc.Params{"path": "dir/a"})
+	assert.ErrorContains(t, err, "pinned by a parent")
+	_, err = unpin.Fn(ctx, rc.Params{"path": "/dir/"})
+	require.NoError(t, err)
+	out, err = pinned.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"pinned": []string{}}, out)
+}
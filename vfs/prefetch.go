@@ -0,0 +1,201 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscache"
+)
+
+// prefetcher downloads directory trees into the VFS cache in the
+// background
+type prefetcher struct {
+	vfs   *VFS
+	ctx   context.Context // carries the bandwidth limit if set
+	cache *vfscache.Cache
+	queue chan prefetchFile
+
+	mu          sync.Mutex // protects the stats below
+	walking     int        // number of directory walks in progress
+	queued      int        // number of files waiting to be downloaded
+	inProgress  int        // number of files being downloaded
+	files       int        // number of files downloaded
+	skipped     int        // number of files already in the cache
+	errors      int        // number of files which failed
+	bytes       int64      // number of bytes downloaded
+	bytesQueued int64      // size of the files waiting or being downloaded
+}
+
+// prefetchFile is a file queued for download
+type prefetchFile struct {
+	file *File
+	o    fs.Object
+}
+
+// newPrefetcher makes a prefetcher and starts its workers which
+// run until ctx is cancelled
+func newPrefetcher(ctx context.Context, vfs *VFS, cache *vfscache.Cache) *prefetcher {
+	p := &prefetcher{
+		vfs:   vfs,
+		ctx:   ctx,
+		cache: cache,
+		queue: make(chan prefetchFile, 1024),
+	}
+	if bw := vfs.Opt.PrefetchBwLimit; bw > 0 {
+		p.ctx = accounting.WithBwLimiter(ctx, accounting.NewBwLimiter(bw))
+	}
+	for range max(vfs.Opt.PrefetchTransfers, 1) {
+		go p.worker()
+	}
+	return p
+}
+
+// add starts downloading name, a file or a directory tree, in the
+// background. Only files which are included by fi are downloaded.
+func (p *prefetcher) add(name string, fi *filter.Filter) error {
+	node, err := p.vfs.Stat(name)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.walking++
+	p.mu.Unlock()
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.walking--
+			p.mu.Unlock()
+		}()
+		if file, ok := node.(*File); ok {
+			p.walk(file, file.Dir().Path(), fi)
+			return
+		}
+		fs.Infof(node, "vfs cache: prefetching directory")
+		p.walk(node, node.Path(), fi)
+	}()
+	return nil
+}
+
+// walk queues the files in node for download recursing into
+// directories
+//
+// The paths of the files relative to root are used for filtering.
+func (p *prefetcher) walk(node Node, root string, fi *filter.Filter) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	switch x := node.(type) {
+	case *Dir:
+		nodes, err := x.ReadDirAll()
+		if err != nil {
+			fs.Errorf(x, "vfs cache: failed to list directory to prefetch: %v", err)
+			return
+		}
+		for _, node := range nodes {
+			p.walk(node, root, fi)
+		}
+	case *File:
+		o := x.getObject()
+		if o == nil {
+			return
+		}
+		remote := strings.TrimPrefix(strings.TrimPrefix(x.Path(), root), "/")
+		if !fi.Include(remote, o.Size(), x.ModTime(), nil) {
+			return
+		}
+		p.mu.Lock()
+		p.queued++
+		p.bytesQueued += o.Size()
+		p.mu.Unlock()
+		select {
+		case p.queue <- prefetchFile{file: x, o: o}:
+		case <-p.ctx.Done():
+		}
+	}
+}
+
+// worker downloads files from the queue until the context is cancelled
+func (p *prefetcher) worker() {
+	for {
+		select {
+		case item := <-p.queue:
+			p.fetch(item)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// fetch downloads a single file into the cache
+func (p *prefetcher) fetch(item prefetchFile) {
+	p.mu.Lock()
+	p.queued--
+	p.inProgress++
+	p.mu.Unlock()
+	fetched, err := p.cache.Fetch(p.ctx, item.file.CachePath(), item.o, p.account)
+	p.mu.Lock()
+	p.inProgress--
+	p.bytesQueued -= item.o.Size()
+	switch {
+	case err != nil:
+		p.errors++
+	case fetched:
+		p.files++
+	default:
+		p.skipped++
+	}
+	p.mu.Unlock()
+	if err != nil {
+		fs.Errorf(item.file, "vfs cache: failed to prefetch: %v", err)
+	} else if fetched {
+		fs.Debugf(item.file, "vfs cache: prefetched")
+	}
+}
+
+// account records n bytes downloaded
+func (p *prefetcher) account(n int64) error {
+	p.mu.Lock()
+	p.bytes += n
+	p.mu.Unlock()
+	return nil
+}
+
+// stats returns the progress of the prefetcher
+func (p *prefetcher) stats() rc.Params {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return rc.Params{
+		"running":     p.walking > 0 || p.queued > 0 || p.inProgress > 0,
+		"walking":     p.walking,
+		"queued":      p.queued,
+		"inProgress":  p.inProgress,
+		"files":       p.files,
+		"skipped":     p.skipped,
+		"errors":      p.errors,
+		"bytes":       p.bytes,
+		"bytesQueued": p.bytesQueued,
+	}
+}
+
+// Prefetch downloads name, which may be a file or a directory, into
+// the VFS cache in the background. Directories are downloaded
+// recursively. Only files included by fi are downloaded.
+//
+// The download uses --vfs-prefetch-transfers parallel transfers and
+// is limited to --vfs-prefetch-bwlimit. Progress is reported in the
+// "prefetch" section of Stats.
+func (vfs *VFS) Prefetch(name string, fi *filter.Filter) error {
+	if vfs.prefetch == nil {
+		return errors.New("prefetching needs --vfs-cache-mode full")
+	}
+	if vfs.IsOffline() {
+		return errors.New("can't prefetch while offline")
+	}
+	return vfs.prefetch.add(name, fi)
+}
@@ -0,0 +1,77 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wait for the prefetcher to finish
+func waitPrefetch(t *testing.T, vfs *VFS) rc.Params {
+	var stats rc.Params
+	require.Eventually(t, func() bool {
+		stats = vfs.Stats()["prefetch"].(rc.Params)
+		return !stats["running"].(bool)
+	}, 10*time.Second, 10*time.Millisecond)
+	return stats
+}
+
+func TestPrefetchCacheModeOff(t *testing.T) {
+	_, vfs := newTestVFS(t)
+	assert.ErrorContains(t, vfs.Prefetch("", filter.GetConfig(context.Background())), "--vfs-cache-mode full")
+	assert.Nil(t, vfs.Stats()["prefetch"])
+}
+
+func TestRcPrefetch(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.PrefetchTransfers = 2
+	opt.PrefetchBwLimit = 1024 * 1024 * 1024
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	r.WriteObject(ctx, "dir/a.txt", "file a", t1)
+	r.WriteObject(ctx, "dir/sub/b.txt", "file bb", t1)
+	r.WriteObject(ctx, "dir/sub/c.jpg", "file ccc", t1)
+	r.WriteObject(ctx, "d.txt", "file dddd", t1)
+
+	present := func(name string, size int64) bool {
+		return vfs.cache.Item(name).HasRange(ranges.Range{Pos: 0, Size: size})
+	}
+
+	// Prefetch with a filter relative to the directory
+	fi, err := filter.NewFilter(nil)
+	require.NoError(t, err)
+	require.NoError(t, fi.AddRule("- sub/*.jpg"))
+	require.NoError(t, vfs.Prefetch("dir", fi))
+	stats := waitPrefetch(t, vfs)
+	assert.Equal(t, 2, stats["files"])
+	assert.Equal(t, int64(13), stats["bytes"])
+	assert.Equal(t, 0, stats["errors"])
+	assert.True(t, present("dir/a.txt", 6))
+	assert.True(t, present("dir/sub/b.txt", 7))
+	assert.False(t, present("dir/sub/c.jpg", 8))
+	assert.False(t, present("d.txt", 9))
+
+	// Prefetch everything with the rc skipping what is cached
+	call := rc.Calls.Get("vfs/prefetch")
+	_, err = call.Fn(ctx, rc.Params{"path": "notfound"})
+	assert.Equal(t, ENOENT, err)
+	_, err = call.Fn(ctx, rc.Params{"path": "/"})
+	require.NoError(t, err)
+	stats = waitPrefetch(t, vfs)
+	assert.Equal(t, 4, stats["files"])
+	assert.Equal(t, 2, stats["skipped"])
+	assert.Equal(t, int64(30), stats["bytes"])
+	assert.Equal(t, int64(0), stats["bytesQueued"])
+	assert.True(t, present("dir/sub/c.jpg", 8))
+	assert.True(t, present("d.txt", 9))
+}
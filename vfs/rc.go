@@ -10,6 +10,7 @@ import (
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 )
@@ -407,14 +408,33 @@ This returns stats for the selected VFS.
             "erroredFiles": 0,
             "files": 0,
             "hashType": 1,
+            "offline": false,
             "outOfSpace": false,
             "path": "/home/user/.cache/rclone/vfs/local/mnt/a",
             "pathMeta": "/home/user/.cache/rclone/vfsMeta/local/mnt/a",
+            "pinned": 0,
             "uploadsInProgress": 0,
             "uploadsQueued": 0
         },
+        // Progress of vfs/prefetch - only present if --vfs-cache-mode full
+        "prefetch": {
+            "bytes": 0,
+            "bytesQueued": 0,
+            "errors": 0,
+            "files": 0,
+            "inProgress": 0,
+            "queued": 0,
+            "running": false,
+            "skipped": 0,
+            "walking": 0
+        },
         "fs": "/mnt/a",
         "inUse": 1,
+        // Offline mode - only present if --vfs-cache-mode full
+        "offline": {
+            "offline": false,
+            "queued": 0
+        },
         // Status of the in memory metadata cache
         "metadataCache": {
             "dirs": 1,
@@ -557,3 +577,226 @@ func rcQueueSetExpiry(ctx context.Context, in rc.Params) (out rc.Params, err err
 	err = vfs.cache.QueueSetExpiry(writeback.Handle(id), refTime, time.Duration(float64(time.Second)*expiry))
 	return nil, err
 }
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pin",
+		Title: "Pin a file or directory in the VFS cache.",
+		Help: strings.ReplaceAll(`
+
+This marks the file or directory given by |path| to be kept in the
+VFS cache so it is available offline. Pinning a directory pins
+everything in it recursively, including files added later.
+
+Pinned files are downloaded in full in the background, are never
+removed by the cache cleaner even if the cache is over
+|--vfs-cache-max-age| or |--vfs-cache-max-size|, and are downloaded
+again if they change on the remote. The pins are saved in the cache
+directory so they persist over restarts.
+
+This needs |--vfs-cache-mode full|.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |path| - the file or directory to pin, relative to the root of the VFS
+
+This returns an empty result on success, or an error.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPin,
+	})
+}
+
+func rcPin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using the VFS cache"))
+	}
+	path, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	return nil, vfs.Pin(path)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/unpin",
+		Title: "Unpin a file or directory in the VFS cache.",
+		Help: strings.ReplaceAll(`
+
+This removes a pin made with |vfs/pin| from the file or directory
+given by |path|. Its data stays in the VFS cache but may be removed
+by the cache cleaner as normal.
+
+Only the exact paths returned by |vfs/pinned| can be unpinned - a
+file can't be unpinned if a directory it is in is pinned.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |path| - the file or directory to unpin, relative to the root of the VFS
+
+This returns an empty result on success, or an error.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcUnpin,
+	})
+}
+
+func rcUnpin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using the VFS cache"))
+	}
+	path, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	return nil, vfs.Unpin(path)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pinned",
+		Title: "List the pinned files and directories in the VFS cache.",
+		Help: strings.ReplaceAll(`
+
+This lists the files and directories pinned with |vfs/pin|.
+
+    {
+        "pinned": [
+            "dir",
+            "file.txt"
+        ]
+    }
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPinned,
+	})
+}
+
+func rcPinned(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"pinned": vfs.Pinned(),
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/prefetch",
+		Title: "Download a file or directory into the VFS cache.",
+		Help: strings.ReplaceAll(`
+
+This downloads the file or directory given by |path| into the VFS
+cache in the background so it can be read quickly later. Directories
+are downloaded recursively.
+
+Filters passed in with |_filter| select which files are downloaded.
+They are applied to the paths relative to |path|, eg
+
+    rclone rc vfs/prefetch path=project _filter='{"IncludeRule":["*.exr"]}'
+
+Files which are already in the cache and unchanged on the remote are
+skipped. The prefetched files are not pinned, so they may be removed
+by the cache cleaner as normal - use |vfs/pin| if they must be kept.
+
+The downloads use |--vfs-prefetch-transfers| parallel transfers and
+are limited to |--vfs-prefetch-bwlimit| bytes/s. Progress is shown in
+the |prefetch| section of |vfs/stats|.
+
+This needs |--vfs-cache-mode full|.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |path| - the file or directory to download, relative to the root of the VFS
+
+This returns an empty result once the download has been started, or
+an error.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPrefetch,
+	})
+}
+
+func rcPrefetch(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.prefetch == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using --vfs-cache-mode full"))
+	}
+	path, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	return nil, vfs.Prefetch(path, filter.GetConfig(ctx))
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/offline",
+		Title: "Show or change the offline mode of the VFS.",
+		Help: strings.ReplaceAll(`
+
+Without parameters this shows whether the VFS is offline and how many
+changes are queued to be made on the remote.
+
+While offline the VFS serves only what is cached and doesn't use the
+remote. Changes are kept in the VFS cache and queued, then replayed
+when the VFS goes back online. See the offline mode section of the
+VFS documentation for the details.
+
+Passing |offline=false| replays the queued changes and goes back
+online. If the remote can't be reached the VFS stays offline and an
+error is returned. Passing |offline=true| goes offline.
+
+This needs |--vfs-cache-mode full|.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |offline| - set to go offline or back online (optional)
+
+    {
+        "offline": true,
+        "queued": 3
+    }
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcOffline,
+	})
+}
+
+func rcOffline(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.offline == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using --vfs-cache-mode full"))
+	}
+	offline, err := in.GetBool("offline")
+	if err == nil {
+		err = vfs.SetOffline(offline)
+		if err != nil {
+			return nil, err
+		}
+	} else if !rc.IsErrParamNotFound(err) {
+		return nil, err
+	}
+	return vfs.offlineStats(), nil
+}
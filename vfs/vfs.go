@@ -37,6 +37,7 @@ import (
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/log"
@@ -187,7 +188,11 @@ type VFS struct {
 	usageTime   time.Time
 	usage       *fs.Usage
 	pollChan    chan time.Duration
-	inUse       atomic.Int32 // count of number of opens
+	inUse       atomic.Int32  // count of number of opens
+	pinKick     chan struct{} // kicks the pinner to fetch pinned files
+	prefetch    *prefetcher   // downloads files into the cache if set
+	dirCache    *dirCache     // saves directory listings to disk if set
+	offline     *offline      // manages offline mode if set
 }
 
 // Keep track of active VFS keyed on fs.ConfigString(f)
@@ -208,11 +213,13 @@ func New(ctx context.Context, f fs.Fs, opt *vfscommon.Options) *VFS {
 	newCtx := context.Background()
 	newCtx = fs.CopyConfig(newCtx, ctx)
 	newCtx = filter.CopyConfig(newCtx, ctx)
+	newCtx = accounting.CopyBwLimiter(newCtx, ctx)
 	ctx, cancel := context.WithCancel(newCtx)
 	vfs := &VFS{
-		f:      f,
-		ctx:    ctx,
-		cancel: cancel,
+		f:       f,
+		ctx:     ctx,
+		cancel:  cancel,
+		pinKick: make(chan struct{}, 1),
 	}
 	vfs.inUse.Store(1)
 
@@ -241,6 +248,16 @@ func New(ctx context.Context, f fs.Fs, opt *vfscommon.Options) *VFS {
 	// Put the VFS into the active cache
 	active[configName] = append(active[configName], vfs)
 
+	// Open the on disk directory cache if required
+	if vfs.Opt.DirCachePersist {
+		dc, err := newDirCache(vfs)
+		if err != nil {
+			fs.Errorf(f, "Not saving the directory cache: %v", err)
+		} else {
+			vfs.dirCache = dc
+		}
+	}
+
 	// Create root directory
 	vfs.root = newDir(vfs, f, nil, fsDir)
 
@@ -336,6 +353,12 @@ func (vfs *VFS) Stats() (out rc.Params) {
 	if vfs.cache != nil {
 		out["diskCache"] = vfs.cache.Stats()
 	}
+	if vfs.prefetch != nil {
+		out["prefetch"] = vfs.prefetch.stats()
+	}
+	if vfs.offline != nil {
+		out["offline"] = vfs.offlineStats()
+	}
 	return out
 }
 
@@ -362,6 +385,14 @@ func (vfs *VFS) Fs() fs.Fs {
 func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 	vfs.shutdownCache()
 	vfs.cache = nil
+	vfs.prefetch = nil
+	if vfs.offline != nil {
+		vfs.offline.log.close()
+		vfs.offline = nil
+	}
+	if vfs.Opt.Offline && cacheMode < vfscommon.CacheModeFull {
+		fs.Errorf(vfs.f, "Ignoring --vfs-offline as it needs --vfs-cache-mode full")
+	}
 	if cacheMode > vfscommon.CacheModeOff {
 		ctx, cancel := context.WithCancel(vfs.ctx)
 		cache, err := vfscache.New(ctx, vfs.f, &vfs.Opt, vfs.AddVirtual)
@@ -374,9 +405,35 @@ func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 		vfs.Opt.CacheMode = cacheMode
 		vfs.cancelCache = cancel
 		vfs.cache = cache
+		if cacheMode >= vfscommon.CacheModeFull {
+			vfs.startOffline(ctx)
+			go vfs.pinner(ctx, cache)
+			vfs.prefetch = newPrefetcher(ctx, vfs, cache)
+			if vfs.Opt.Prefetch != "" {
+				go func(p *prefetcher) {
+					err := p.add(vfs.Opt.Prefetch, filter.GetConfig(vfs.ctx))
+					if err != nil {
+						fs.Errorf(vfs.f, "Failed to start --vfs-prefetch: %v", err)
+					}
+				}(vfs.prefetch)
+			}
+		}
 	}
 }
 
+// SetCacheQuotas changes the maximum size, minimum free space and
+// maximum age of the cache while the VFS is running. The new limits
+// are applied the next time the cache is cleaned.
+func (vfs *VFS) SetCacheQuotas(maxSize, minFreeSpace fs.SizeSuffix, maxAge fs.Duration) {
+	if vfs.cache == nil {
+		vfs.Opt.CacheMaxSize = maxSize
+		vfs.Opt.CacheMinFreeSpace = minFreeSpace
+		vfs.Opt.CacheMaxAge = maxAge
+		return
+	}
+	vfs.cache.SetQuotas(maxSize, minFreeSpace, maxAge)
+}
+
 // shutdown the cache if it was running
 func (vfs *VFS) shutdownCache() {
 	if vfs.cancelCache != nil {
@@ -414,6 +471,13 @@ func (vfs *VFS) Shutdown() {
 
 	// Cancel any background go routines
 	vfs.cancel()
+
+	if vfs.dirCache != nil {
+		vfs.dirCache.close()
+	}
+	if vfs.offline != nil {
+		vfs.offline.log.close()
+	}
 }
 
 // CleanUp deletes the contents of the on disk cache
@@ -662,7 +726,7 @@ func (vfs *VFS) Statfs() (total, used, free int64) {
 	defer vfs.usageMu.Unlock()
 	total, used, free = -1, -1, -1
 	doAbout := vfs.f.Features().About
-	if (doAbout != nil || vfs.Opt.UsedIsSize) && (vfs.usageTime.IsZero() || time.Since(vfs.usageTime) >= time.Duration(vfs.Opt.DirCacheTime)) {
+	if (doAbout != nil || vfs.Opt.UsedIsSize) && !vfs.IsOffline() && (vfs.usageTime.IsZero() || time.Since(vfs.usageTime) >= time.Duration(vfs.Opt.DirCacheTime)) {
 		var err error
 		ctx := vfs.ctx
 		if doAbout == nil {
@@ -12,9 +12,11 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	fscache "github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/fserrors"
@@ -48,21 +50,24 @@ type Cache struct {
 	opt        *vfscommon.Options   // vfs Options
 	root       string               // root of the cache directory
 	metaRoot   string               // root of the cache metadata directory
+	pinsPath   string               // file the pins are stored in
 	hashType   hash.Type            // hash to use locally and remotely
 	hashOption *fs.HashesOption     // corresponding OpenOption
 	writeback  *writeback.WriteBack // holds Items for writeback
 	avFn       AddVirtualFn         // if set, can be called to add dir entries
-
-	mu            sync.Mutex       // protects the following variables
-	cond          sync.Cond        // cond lock for synchronous cache cleaning
-	item          map[string]*Item // files/directories in the cache
-	errItems      map[string]error // items in error state
-	used          int64            // total size of files in the cache
-	outOfSpace    bool             // out of space
-	cleanerKicked bool             // some thread kicked the cleaner upon out of space
-	kickerMu      sync.Mutex       // mutex for cleanerKicked
-	kick          chan struct{}    // channel for kicking clear to start
-
+	offline    atomic.Bool          // set if the remote mustn't be used
+
+	mu            sync.Mutex          // protects the following variables
+	cond          sync.Cond           // cond lock for synchronous cache cleaning
+	item          map[string]*Item    // files/directories in the cache
+	errItems      map[string]error    // items in error state
+	used          int64               // total size of files in the cache
+	outOfSpace    bool                // out of space
+	cleanerKicked bool                // some thread kicked the cleaner upon out of space
+	kickerMu      sync.Mutex          // mutex for cleanerKicked
+	kick          chan struct{}       // channel for kicking clear to start
+	pins          map[string]struct{} // files and directories pinned in the cache
+	evictRanges   bool                // evict parts of files rather than whole files
 }
 
 // AddVirtualFn if registered by the WithAddVirtual method, can be
@@ -123,6 +128,7 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		opt:        opt,
 		root:       dataOSPath,
 		metaRoot:   metaOSPath,
+		pinsPath:   file.UNCPath(filepath.Join(parentOSPath, "vfsPins", relativeDirOSPath) + ".json"),
 		item:       make(map[string]*Item),
 		errItems:   make(map[string]error),
 		hashType:   hashType,
@@ -131,7 +137,22 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		avFn:       avFn,
 	}
 
-	// load in the cache and metadata off disk
+	if opt.CacheEvictRanges {
+		if file.PunchHoleImplemented {
+			c.evictRanges = true
+		} else {
+			fs.Errorf(fremote, "vfs cache: --vfs-cache-evict-ranges isn't supported on this OS - evicting whole files")
+		}
+	}
+
+	// Don't use the remote while reloading if starting offline
+	c.SetOffline(opt.Offline)
+
+	// load in the pins, cache and metadata off disk
+	err = c.loadPins()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cache: %w", err)
+	}
 	err = c.reload(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load cache: %w", err)
@@ -149,6 +170,38 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	return c, nil
 }
 
+// DirEntries returns the files and directories in the cache in dir.
+//
+// The objects returned are the cache files not remote objects.
+func (c *Cache) DirEntries(ctx context.Context, dir string) (fs.DirEntries, error) {
+	entries, err := c.fcache.List(ctx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// ErrOffline is returned when data which isn't in the cache is needed
+// while the cache is offline
+var ErrOffline = errors.New("vfs cache: not available while offline")
+
+// SetOffline sets whether the cache is offline.
+//
+// While offline no uploads are started, data which isn't in the cache
+// isn't downloaded and cached files aren't checked against the
+// remote. Files modified while offline are checked for changes on the
+// remote before they are uploaded and if there are any the local
+// copy is uploaded under a conflict name instead.
+func (c *Cache) SetOffline(offline bool) {
+	c.offline.Store(offline)
+	c.writeback.SetPaused(offline)
+}
+
+// IsOffline returns true if the cache is offline
+func (c *Cache) IsOffline() bool {
+	return c.offline.Load()
+}
+
 // Stats returns info about the Cache
 func (c *Cache) Stats() (out rc.Params) {
 	out = make(rc.Params)
@@ -168,6 +221,8 @@ func (c *Cache) Stats() (out rc.Params) {
 	out["erroredFiles"] = len(c.errItems)
 	out["bytesUsed"] = c.used
 	out["outOfSpace"] = c.outOfSpace
+	out["pinned"] = len(c.pins)
+	out["offline"] = c.offline.Load()
 
 	return out
 }
@@ -430,6 +485,7 @@ func (c *Cache) Rename(name string, newName string, newObj fs.Object) (err error
 		c.item[newName] = item
 		delete(c.item, name)
 	}
+	c._renamePins(clean(name), clean(newName))
 	c.mu.Unlock()
 
 	fs.Infof(name, "vfs cache: renamed in cache to %q", newName)
@@ -472,6 +528,11 @@ func (c *Cache) DirRename(oldDirName string, newDirName string) (err error) {
 		}
 	}
 
+	// Move any pins on the directory
+	c.mu.Lock()
+	c._renamePins(clean(oldDirName), clean(newDirName))
+	c.mu.Unlock()
+
 	// Old path should be empty now so remove it
 	c.purgeEmptyDirs(oldDirName[:len(oldDirName)-1], false)
 
@@ -490,6 +551,7 @@ func (c *Cache) Remove(name string) (wasWriting bool) {
 	if item != nil {
 		delete(c.item, name)
 	}
+	c._removePin(name)
 	c.mu.Unlock()
 	if item == nil {
 		return false
@@ -507,10 +569,17 @@ func (c *Cache) SetModTime(name string, modTime time.Time) {
 func (c *Cache) CleanUp() error {
 	err1 := os.RemoveAll(c.root)
 	err2 := os.RemoveAll(c.metaRoot)
+	err3 := os.Remove(c.pinsPath)
 	if err1 != nil {
 		return err1
 	}
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	if err3 != nil && !os.IsNotExist(err3) {
+		return err3
+	}
+	return nil
 }
 
 // walk walks the cache calling the function
@@ -561,6 +630,85 @@ func (c *Cache) reload(ctx context.Context) error {
 	return nil
 }
 
+// FetchFn is called by Fetch with the number of bytes downloaded
+// after each chunk of the file. If it returns an error the fetch is
+// abandoned.
+type FetchFn func(n int64) error
+
+// fetchChunkSize is the size of the chunks Fetch downloads when it
+// has a FetchFn
+const fetchChunkSize = 1024 * 1024
+
+// Fetch makes sure the whole of the object o is downloaded into the
+// cache as name.
+//
+// If the cached copy is complete and matches the fingerprint of o
+// then nothing is done. If the remote object has changed then the
+// stale copy is discarded and o is downloaded again. Items with local
+// modifications are left alone.
+//
+// If ctx has a bandwidth limiter attached with
+// accounting.WithBwLimiter then the download is limited by it, unless
+// the item is open already and so is downloading anyway.
+//
+// If fn is not nil the file is downloaded in chunks calling fn after
+// each one.
+//
+// It returns true if the object needed downloading.
+func (c *Cache) Fetch(ctx context.Context, name string, o fs.Object, fn FetchFn) (fetched bool, err error) {
+	item, _ := c.get(name)
+	if item.upToDate(o) {
+		return false, nil
+	}
+	if accounting.GetBwLimiter(ctx) != nil {
+		item.mu.Lock()
+		if item.opens == 0 {
+			item.fetchCtx = accounting.CopyBwLimiter(c.ctx, ctx)
+		}
+		item.mu.Unlock()
+		defer func() {
+			item.mu.Lock()
+			item.fetchCtx = nil
+			item.mu.Unlock()
+		}()
+	}
+	err = item.Open(o)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		closeErr := item.Close(nil)
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	item.preAccess()
+	defer item.postAccess()
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	size := item.info.Size
+	chunkSize := size
+	if fn != nil {
+		chunkSize = fetchChunkSize
+	}
+	for offset := int64(0); offset < size; offset += chunkSize {
+		before := item.info.Rs.Size()
+		err = item._ensure(offset, chunkSize)
+		if err != nil {
+			return false, fmt.Errorf("vfs cache: failed to fetch: %w", err)
+		}
+		if n := item.info.Rs.Size() - before; fn != nil && n > 0 {
+			item.mu.Unlock()
+			err = fn(n)
+			item.mu.Lock()
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
 // KickCleaner kicks cache cleaner upon out of space situation
 func (c *Cache) KickCleaner() {
 	/* Use a separate kicker mutex for the kick to go through without waiting for the
@@ -639,9 +787,9 @@ func (c *Cache) purgeClean() {
 
 	var items Items
 
-	// Make a slice of clean cache files
+	// Make a slice of clean cache files which aren't pinned
 	for _, item := range c.item {
-		if !item.IsDirty() {
+		if !item.IsDirty() && !c._isPinned(item.name) {
 			items = append(items, item)
 		}
 	}
@@ -675,12 +823,61 @@ func (c *Cache) purgeClean() {
 	c.cond.Broadcast()
 }
 
+// Evict the least recently read ranges of clean cache files until
+// the quota is satisfied
+func (c *Cache) purgeRanges() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.quotasOK() {
+		return
+	}
+
+	var crs []cacheRange
+
+	// Make a slice of the ranges of clean cache files which aren't pinned
+	for _, item := range c.item {
+		if !c._isPinned(item.name) {
+			crs = append(crs, item.evictableRanges()...)
+		}
+	}
+
+	sort.Slice(crs, func(i, j int) bool {
+		return crs[i].atime.Before(crs[j].atime)
+	})
+
+	// Evict ranges until the quota is OK
+	for _, cr := range crs {
+		if c.quotasOK() {
+			break
+		}
+		spaceFreed, err := cr.item.evictRange(cr.r)
+		c.used -= spaceFreed
+		if errors.Is(err, file.ErrPunchHoleUnsupported) {
+			fs.Errorf(c.fremote, "vfs cache: the cache directory doesn't support punching holes so evicting whole files instead of ranges")
+			c.evictRanges = false
+			break
+		} else if err != nil {
+			fs.Errorf(cr.item.name, "vfs cache: failed to evict range %v: %v", cr.r, err)
+		} else if spaceFreed > 0 {
+			fs.Debugf(cr.item.name, "vfs cache: evicted range %v, freed %d bytes", cr.r, spaceFreed)
+		}
+	}
+	if c.quotasOK() {
+		c.outOfSpace = false
+		c.cond.Broadcast()
+	}
+}
+
 // purgeOld gets rid of any files that are over age
 func (c *Cache) purgeOld(maxAge time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	// cutoff := time.Now().Add(-maxAge)
 	for _, item := range c.item {
+		if c._isPinned(item.name) {
+			continue
+		}
 		c.removeNotInUse(item, maxAge, false)
 	}
 	if c.quotasOK() {
@@ -748,7 +945,21 @@ func (c *Cache) quotasOK() bool {
 	return c.maxSizeQuotaOK() && c.minFreeSpaceQuotaOK()
 }
 
+// SetQuotas changes the maximum size, minimum free space and maximum
+// age of the cache. They are applied the next time the cache is
+// cleaned.
+func (c *Cache) SetQuotas(maxSize, minFreeSpace fs.SizeSuffix, maxAge fs.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opt.CacheMaxSize = maxSize
+	c.opt.CacheMinFreeSpace = minFreeSpace
+	c.opt.CacheMaxAge = maxAge
+	fs.Debugf(c.fremote, "vfs cache: quotas set to max size %v, min free space %v, max age %v", maxSize, minFreeSpace, maxAge)
+}
+
 // Return true if any quotas set
+//
+// must be called with mu held.
 func (c *Cache) haveQuotas() bool {
 	return c.opt.CacheMaxSize > 0 || c.opt.CacheMinFreeSpace > 0
 }
@@ -767,9 +978,9 @@ func (c *Cache) purgeOverQuota() {
 
 	var items Items
 
-	// Make a slice of unused files
+	// Make a slice of unused files which aren't pinned
 	for _, item := range c.item {
-		if !item.inUse() {
+		if !item.inUse() && !c._isPinned(item.name) {
 			items = append(items, item)
 		}
 	}
@@ -796,13 +1007,20 @@ func (c *Cache) clean(kicked bool) {
 	c.updateUsed()
 	c.mu.Lock()
 	oldItems, oldUsed := len(c.item), fs.SizeSuffix(c.used)
+	maxAge, haveQuotas := time.Duration(c.opt.CacheMaxAge), c.haveQuotas()
+	evictRanges := c.evictRanges
 	c.mu.Unlock()
 
 	// Remove any files that are over age
-	c.purgeOld(time.Duration(c.opt.CacheMaxAge))
+	c.purgeOld(maxAge)
 
 	// If have a maximum cache size...
-	if c.haveQuotas() {
+	if haveQuotas {
+		// Evict the least recently read parts of files first if required
+		if evictRanges {
+			c.purgeRanges()
+		}
+
 		// Remove files not in use until cache size is below quota starting from the oldest first
 		c.purgeOverQuota()
 
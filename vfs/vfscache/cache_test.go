@@ -2,18 +2,23 @@ package vfscache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/rclone/rclone/backend/local" // import the local backend
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/diskusage"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
@@ -343,6 +348,35 @@ func TestCachePurgeOld(t *testing.T) {
 	assert.Equal(t, []string(nil), itemAsString(c))
 }
 
+func TestCacheSetQuotas(t *testing.T) {
+	_, c := newTestCache(t)
+
+	potato := c.Item("potato")
+	itemWrite(t, potato, "hello")
+	require.NoError(t, potato.Close(nil))
+
+	potato2 := c.Item("potato2")
+	itemWrite(t, potato2, "hello2")
+	require.NoError(t, potato2.Close(nil))
+	potato2.info.ATime = time.Now().Add(10 * time.Second)
+
+	// Nothing removed with no quotas
+	c.clean(false)
+	assert.Equal(t, []string{
+		`name="potato" opens=0 size=5`,
+		`name="potato2" opens=0 size=6`,
+	}, itemAsString(c))
+
+	// Setting the max size removes the oldest item
+	c.SetQuotas(10, 0, fs.Duration(time.Hour))
+	assert.Equal(t, fs.SizeSuffix(10), c.opt.CacheMaxSize)
+	assert.Equal(t, fs.Duration(time.Hour), c.opt.CacheMaxAge)
+	c.clean(false)
+	assert.Equal(t, []string{
+		`name="potato2" opens=0 size=6`,
+	}, itemAsString(c))
+}
+
 func TestCachePurgeOverQuota(t *testing.T) {
 	_, c := newTestCache(t)
 
@@ -517,6 +551,93 @@ func TestCachePurgeClean(t *testing.T) {
 	assert.Equal(t, []string(nil), itemAsString(c))
 }
 
+func TestCachePurgeRanges(t *testing.T) {
+	if !file.PunchHoleImplemented {
+		t.Skip("punch hole not supported on this OS")
+	}
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.HandleCaching = 0
+	opt.CacheEvictRanges = true
+	opt.ChunkSize = 10
+	r, c := newTestCacheOpt(t, opt)
+	contents, obj, potato := newFile(t, r, c, "existing")
+
+	// Put the whole file in the cache as one range as a
+	// sequential download would
+	require.NoError(t, potato.Open(obj))
+	_, _, err := potato.WriteAtNoOverwrite([]byte(contents), 0)
+	require.NoError(t, err)
+	assert.Equal(t, ranges.Ranges{{Pos: 0, Size: 100}}, potato.info.Rs)
+
+	// Read the blocks in a different order
+	buf := make([]byte, 10)
+	for _, off := range []int64{50, 0, 90, 10, 20, 30, 40, 60, 70, 80} {
+		_, err := potato.ReadAt(buf, off)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, potato.info.ReadTimes, 10)
+
+	// A read over a block boundary marks both blocks
+	potato.mu.Lock()
+	potato.info.ReadTimes = nil
+	potato._markRead(15, 10)
+	assert.Len(t, potato.info.ReadTimes, 2)
+	assert.Contains(t, potato.info.ReadTimes, int64(10))
+	assert.Contains(t, potato.info.ReadTimes, int64(20))
+	potato.mu.Unlock()
+	for _, off := range []int64{50, 0, 90, 10, 20, 30, 40, 60, 70, 80} {
+		_, err := potato.ReadAt(buf, off)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+
+	// Add a dirty file which mustn't be evicted
+	potato2 := c.Item("sub/dir/potato2")
+	itemWrite(t, potato2, "hello")
+
+	// The least recently read blocks go first
+	c.updateUsed()
+	c.opt.CacheMaxSize = 85
+	c.purgeRanges()
+	assert.Equal(t, []string{
+		`name="existing" opens=1 size=100 space=80`,
+		`name="sub/dir/potato2" opens=1 size=5 space=5`,
+	}, itemSpaceAsString(c))
+	assert.Equal(t, int64(85), c.used)
+	assert.Equal(t, ranges.Ranges{{Pos: 10, Size: 40}, {Pos: 60, Size: 40}}, potato.info.Rs)
+	assert.NotContains(t, potato.info.ReadTimes, int64(0))
+	assert.NotContains(t, potato.info.ReadTimes, int64(50))
+
+	// The cache file keeps its size with a hole in
+	data, err := os.ReadFile(c.toOSPath(potato.name))
+	require.NoError(t, err)
+	assert.Equal(t, 100, len(data))
+	assert.Equal(t, make([]byte, 10), data[0:10])
+	assert.Equal(t, make([]byte, 10), data[50:60])
+	assert.Equal(t, contents[60:], string(data[60:]))
+
+	// The evicted range is downloaded again when read
+	_, err = potato.ReadAt(buf, 50)
+	require.NoError(t, err)
+	assert.Equal(t, contents[50:60], string(buf))
+
+	// Everything clean is evicted if needed, even if not open
+	require.NoError(t, potato.Close(nil))
+	c.updateUsed()
+	c.opt.CacheMaxSize = 1
+	c.purgeRanges()
+	assert.Equal(t, []string{
+		`name="existing" opens=0 size=100 space=0`,
+		`name="sub/dir/potato2" opens=1 size=5 space=5`,
+	}, itemSpaceAsString(c))
+	assert.Equal(t, int64(5), c.used)
+
+	require.NoError(t, potato2.Close(nil))
+}
+
 func TestCacheInUse(t *testing.T) {
 	_, c := newTestCache(t)
 
@@ -630,6 +751,89 @@ func TestCacheRename(t *testing.T) {
 	assert.NoError(t, c.Rename("nonexist", "nonexist2", nil))
 }
 
+func TestCacheFetch(t *testing.T) {
+	r, c := newTestCache(t)
+	ctx := context.Background()
+
+	contents := "hello world, this is a pinned file"
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	r.WriteObject(ctx, "dir/file", contents, t1)
+	o, err := r.Fremote.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+
+	fetched, err := c.Fetch(ctx, "dir/file", o, nil)
+	require.NoError(t, err)
+	assert.True(t, fetched)
+
+	item := c.Item("dir/file")
+	assert.True(t, item.present())
+	data, err := os.ReadFile(c.toOSPath("dir/file"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(data))
+
+	// Nothing to do the second time
+	fetched, err = c.Fetch(ctx, "dir/file", o, nil)
+	require.NoError(t, err)
+	assert.False(t, fetched)
+
+	// Changed on the remote so fetched again
+	contents = "changed"
+	t2 := time.Date(2002, 2, 3, 4, 5, 6, 0, time.UTC)
+	r.WriteObject(ctx, "dir/file", contents, t2)
+	o, err = r.Fremote.NewObject(ctx, "dir/file")
+	require.NoError(t, err)
+
+	fetched, err = c.Fetch(ctx, "dir/file", o, nil)
+	require.NoError(t, err)
+	assert.True(t, fetched)
+	data, err = os.ReadFile(c.toOSPath("dir/file"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(data))
+
+	// Fetch in chunks reporting progress
+	contents = strings.Repeat("potato", 500000)
+	r.WriteObject(ctx, "dir/file2", contents, t2)
+	o, err = r.Fremote.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	var calls, total int64
+	fetched, err = c.Fetch(ctx, "dir/file2", o, func(n int64) error {
+		calls++
+		total += n
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, fetched)
+	assert.Equal(t, int64(len(contents)), total)
+	assert.GreaterOrEqual(t, calls, int64(1))
+	data, err = os.ReadFile(c.toOSPath("dir/file2"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(data))
+
+	// Errors from the callback abandon the fetch
+	r.WriteObject(ctx, "dir/file3", contents, t2)
+	o, err = r.Fremote.NewObject(ctx, "dir/file3")
+	require.NoError(t, err)
+	fetched, err = c.Fetch(ctx, "dir/file3", o, func(n int64) error {
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	assert.False(t, fetched)
+
+	// A bandwidth limiter in the context limits the download
+	r.WriteObject(ctx, "dir/file4", contents, t2)
+	o, err = r.Fremote.NewObject(ctx, "dir/file4")
+	require.NoError(t, err)
+	limitCtx := accounting.WithBwLimiter(ctx, accounting.NewBwLimiter(4*1024*1024))
+	start := time.Now()
+	fetched, err = c.Fetch(limitCtx, "dir/file4", o, nil)
+	require.NoError(t, err)
+	assert.True(t, fetched)
+	assert.Greater(t, time.Since(start), 500*time.Millisecond)
+	data, err = os.ReadFile(c.toOSPath("dir/file4"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(data))
+}
+
 func TestCacheCleaner(t *testing.T) {
 	opt := vfscommon.Opt
 	opt.CachePollInterval = fs.Duration(10 * time.Millisecond)
@@ -17,6 +17,7 @@ import (
 	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/vfs/vfscache/downloaders"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
+	"github.com/rclone/rclone/vfs/vfscommon"
 )
 
 // NB as Cache and Item are tightly linked it is necessary to have a
@@ -69,6 +70,7 @@ type Item struct {
 	modified        bool                     // set if the file has been modified since the last Open
 	beingReset      bool                     // cache cleaner is resetting the cache file, access not allowed
 	graceTimer      *time.Timer              // timer for delayed close after grace period
+	fetchCtx        context.Context          // if set, the context new downloaders use instead of c.ctx
 }
 
 // Info is persisted to backing store
@@ -79,8 +81,14 @@ type Info struct {
 	Rs          ranges.Ranges // which parts of the file are present
 	Fingerprint string        // fingerprint of remote object
 	Dirty       bool          // set if the backing file has been modified
+	Offline     bool          // set if modified while offline so the remote must be checked before upload
+	ReadTimes   ReadTimes     // when the parts of the file were last read
 }
 
+// ReadTimes records the last time each block of a file in the cache
+// was read, keyed by the offset of the start of the block.
+type ReadTimes map[int64]time.Time
+
 // Items are a slice of *Item ordered by ATime
 type Items []*Item
 
@@ -250,7 +258,8 @@ func (item *Item) _truncate(size int64) (err error) {
 			// If the metadata has info but the file doesn't
 			// not exist then it has been externally removed
 			fs.Errorf(item.name, "vfs cache: detected external removal of cache file")
-			item.info.Rs = nil      // show we have no blocks cached
+			item.info.Rs = nil // show we have no blocks cached
+			item.info.ReadTimes = nil
 			item.info.Dirty = false // file can't be dirty if it doesn't exist
 			item._removeMeta("cache file externally deleted")
 			fd, err = file.OpenFile(osPath, os.O_CREATE|os.O_WRONLY, 0600)
@@ -437,8 +446,12 @@ func (item *Item) _dirty() {
 		item.c.writeback.Remove(item.writeBackID)
 		item.mu.Lock()
 	}
-	if !item.info.Dirty {
+	offline := item.c.offline.Load()
+	if !item.info.Dirty || (offline && !item.info.Offline) {
 		item.info.Dirty = true
+		if offline {
+			item.info.Offline = true
+		}
 		err := item._save()
 		if err != nil {
 			fs.Errorf(item.name, "vfs cache: failed to save item info: %v", err)
@@ -595,7 +608,7 @@ func (item *Item) open(o fs.Object) (err error) {
 
 	// Create the downloaders
 	if item.o != nil {
-		item.downloaders = downloaders.New(item.c.ctx, item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item._downloadCtx(), item, item.c.opt, item.name, item.o)
 	}
 
 	return err
@@ -610,6 +623,56 @@ func unlockMutexForCall(mu *sync.Mutex, f func()) {
 	f()
 }
 
+// _checkRemote finds the remote object for an item modified while
+// offline. It returns the object, or nil if there isn't one, and
+// whether it has changed on the remote since it was cached.
+//
+// Call with lock held
+func (item *Item) _checkRemote(ctx context.Context) (o fs.Object, changed bool, err error) {
+	name, fingerprint := item.name, item.info.Fingerprint
+	unlockMutexForCall(&item.mu, func() {
+		o, err = item.c.fremote.NewObject(ctx, name)
+	})
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		// Not there so nothing to conflict with
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("vfs cache: failed to check remote for changes: %w", err)
+	}
+	// If there was no remote object when the file was cached then
+	// it has been created on the remote since
+	changed = fingerprint == "" || fs.Fingerprint(item.c.ctx, o, item.c.opt.FastFingerprint) != fingerprint
+	return o, changed, nil
+}
+
+// _storeConflict uploads the local cache file under a conflict name
+// as the remote object o was changed while the item was modified
+// offline.
+//
+// The item is left pointing at o but with the fingerprint of the
+// uploaded copy so the cached data is replaced with o when it is next
+// opened.
+//
+// Call with lock held
+func (item *Item) _storeConflict(ctx context.Context, o fs.Object, cacheObj fs.Object) (err error) {
+	conflictName := vfscommon.ConflictName(item.name, time.Now())
+	fs.Logf(item.name, "vfs cache: remote changed while offline - uploading local copy as %q", conflictName)
+	var conflictObj fs.Object
+	unlockMutexForCall(&item.mu, func() {
+		conflictObj, err = operations.Copy(ctx, item.c.fremote, nil, conflictName, cacheObj)
+		if err == nil && item.c.avFn != nil {
+			err = item.c.AddVirtual(conflictName, conflictObj.Size(), false)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("vfs cache: failed to transfer conflicting copy from cache to remote: %w", err)
+	}
+	item.o = o
+	item.info.Fingerprint = fs.Fingerprint(item.c.ctx, conflictObj, item.c.opt.FastFingerprint)
+	return nil
+}
+
 // Store stores the local cache file to the remote object, returning
 // the new remote object. objOld is the old object if known.
 //
@@ -626,18 +689,32 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 	// Object has disappeared if cacheObj == nil
 	if cacheObj != nil {
 		o, name := item.o, item.name
-		unlockMutexForCall(&item.mu, func() {
-			o, err = operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
-		})
-		if err != nil {
-			if errors.Is(err, fs.ErrorCantUploadEmptyFiles) {
-				fs.Errorf(name, "Writeback failed: %v", err)
-				return nil
+		changed := false
+		if item.info.Offline {
+			o, changed, err = item._checkRemote(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		if changed {
+			err = item._storeConflict(ctx, o, cacheObj)
+			if err != nil {
+				return err
 			}
-			return fmt.Errorf("vfs cache: failed to transfer file from cache to remote: %w", err)
+		} else {
+			unlockMutexForCall(&item.mu, func() {
+				o, err = operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
+			})
+			if err != nil {
+				if errors.Is(err, fs.ErrorCantUploadEmptyFiles) {
+					fs.Errorf(name, "Writeback failed: %v", err)
+					return nil
+				}
+				return fmt.Errorf("vfs cache: failed to transfer file from cache to remote: %w", err)
+			}
+			item.o = o
+			item._updateFingerprint()
 		}
-		item.o = o
-		item._updateFingerprint()
 	}
 
 	// Write the object back to the VFS layer before we mark it as
@@ -654,6 +731,7 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 
 	// Show item is clean and is eligible for cache removal
 	item.info.Dirty = false
+	item.info.Offline = false
 	err = item._save()
 	if err != nil {
 		fs.Errorf(item.name, "vfs cache: failed to write metadata file: %v", err)
@@ -792,7 +870,7 @@ func (item *Item) _actualClose(storeFn StoreFn, syncWriteBack bool) (err error)
 	// upload the file to backing store if changed
 	if item.info.Dirty {
 		fs.Infof(item.name, "vfs cache: queuing for upload in %v", item.c.opt.WriteBack)
-		if syncWriteBack {
+		if syncWriteBack && !item.c.offline.Load() {
 			// do synchronous writeback
 			checkErr(item._store(item.c.ctx, storeFn))
 		} else {
@@ -827,7 +905,10 @@ func (item *Item) reload(ctx context.Context) error {
 		return nil
 	}
 	// see if the object still exists
-	obj, _ := item.c.fremote.NewObject(ctx, item.name)
+	var obj fs.Object
+	if !item.c.offline.Load() {
+		obj, _ = item.c.fremote.NewObject(ctx, item.name)
+	}
 	// open the file with the object (or nil)
 	err := item.Open(obj)
 	if err != nil {
@@ -860,7 +941,13 @@ func (item *Item) reload(ctx context.Context) error {
 //
 // call with lock held
 func (item *Item) _checkObject(o fs.Object) error {
-	if o == nil {
+	if item.c.offline.Load() && item.info.Fingerprint != "" {
+		// the remote can't be checked while offline so trust the cache
+		fs.Debugf(item.name, "vfs cache: offline so not checking remote fingerprint")
+		if o == nil {
+			o = item.o
+		}
+	} else if o == nil {
 		if item.info.Fingerprint != "" {
 			// no remote object && local object
 			// remove local object unless dirty
@@ -1129,7 +1216,7 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 
 	// Create the downloaders
 	if item.o != nil {
-		item.downloaders = downloaders.New(item.c.ctx, item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item._downloadCtx(), item, item.c.opt, item.name, item.o)
 	}
 
 	/* The item will stay in the beingReset state if we get an error that prevents us from
@@ -1140,6 +1227,139 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 	return ResetComplete, spaceFreed, err
 }
 
+// cacheRange is a range of an item in the cache with the last time
+// it was read
+type cacheRange struct {
+	item  *Item
+	r     ranges.Range
+	atime time.Time
+}
+
+// defaultEvictBlockSize is the size of the blocks which are evicted
+// if --vfs-read-chunk-size is off
+const defaultEvictBlockSize = 16 * 1024 * 1024
+
+// evictBlockSize returns the size of the blocks that read times are
+// recorded for and which are evicted. This is the chunk size so a
+// file which was read sequentially isn't evicted in one go.
+func (item *Item) evictBlockSize() int64 {
+	if bs := int64(item.c.opt.ChunkSize); bs > 0 {
+		return bs
+	}
+	return defaultEvictBlockSize
+}
+
+// _markRead records that the blocks of the file containing
+// (off, size) have just been read.
+//
+// The time is stored against the start of each block.
+//
+// call with lock held
+func (item *Item) _markRead(off, size int64) {
+	size = min(size, item.info.Size-off)
+	if size <= 0 {
+		return
+	}
+	if item.info.ReadTimes == nil {
+		item.info.ReadTimes = make(ReadTimes)
+	}
+	bs := item.evictBlockSize()
+	for block := off / bs * bs; block < off+size; block += bs {
+		item.info.ReadTimes[block] = item.info.ATime
+	}
+}
+
+// evictableRanges returns the blocks of the item which could be
+// evicted to save space, because some of the block is cached, along
+// with the last time they were read.
+//
+// Blocks which haven't been read, for example if they were read
+// ahead, are given the access time of the item.
+func (item *Item) evictableRanges() (crs []cacheRange) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.info.Dirty || item.beingReset || item.pendingAccesses > 0 {
+		return nil
+	}
+	bs := item.evictBlockSize()
+	// Read times may have been stored with a different block size
+	atimes := make(map[int64]time.Time, len(item.info.ReadTimes))
+	for off, atime := range item.info.ReadTimes {
+		block := off / bs * bs
+		if atime.After(atimes[block]) {
+			atimes[block] = atime
+		}
+	}
+	next := int64(0) // start of the next block not yet returned
+	for _, r := range item.info.Rs {
+		for block := max(r.Pos/bs*bs, next); block < r.End(); block += bs {
+			atime, found := atimes[block]
+			if !found {
+				atime = item.info.ATime
+			}
+			crs = append(crs, cacheRange{item: item, r: ranges.Range{Pos: block, Size: bs}, atime: atime})
+			next = block + bs
+		}
+	}
+	return crs
+}
+
+// evictRange frees the space used by the cached parts of r in the
+// cache file by punching holes in it and returns the space freed.
+//
+// Nothing is done if the item has been modified or accessed since r
+// was returned by evictableRanges.
+func (item *Item) evictRange(r ranges.Range) (spaceFreed int64, err error) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.info.Dirty || item.beingReset || item.pendingAccesses > 0 {
+		return 0, nil
+	}
+	present := item.info.Rs.Intersection(r)
+	if len(present) == 0 {
+		return 0, nil
+	}
+
+	// Use open handle if available
+	fd := item.fd
+	if fd == nil {
+		osPath := item.c.toOSPath(item.name) // No locking in Cache
+		fd, err = file.OpenFile(osPath, os.O_WRONLY, 0600)
+		if err != nil {
+			return 0, fmt.Errorf("vfs cache: evict range: failed to open cache file: %w", err)
+		}
+		defer func() {
+			closeErr := fd.Close()
+			if closeErr != nil {
+				fs.Errorf(item.name, "vfs cache: evict range: close failed: %v", closeErr)
+			}
+		}()
+	}
+
+	for _, pr := range present {
+		err = file.PunchHole(fd, pr.Pos, pr.Size)
+		if err != nil {
+			break
+		}
+		item.info.Rs.Remove(pr)
+		spaceFreed += pr.Size
+	}
+	if spaceFreed == 0 {
+		return 0, err
+	}
+	for off := range item.info.ReadTimes {
+		if off >= r.Pos && off < r.End() {
+			delete(item.info.ReadTimes, off)
+		}
+	}
+	// The space has been freed whether or not the metadata is saved
+	saveErr := item._save()
+	if saveErr != nil {
+		fs.Errorf(item.name, "vfs cache: evict range: failed to save metadata: %v", saveErr)
+	}
+	return spaceFreed, err
+}
+
 // ProtectCache either waits for an ongoing cache reset to finish or increases pendingReads
 // to protect against cache reset on this item while the thread potentially uses the cache file
 // Cache cleaner waits until pendingReads is zero before resetting cache.
@@ -1167,6 +1387,22 @@ func (item *Item) postAccess() {
 	item.cond.Broadcast()
 }
 
+// upToDate returns true if the item doesn't need fetching for o
+//
+// This is true if the item is fully downloaded with the same
+// fingerprint as o or if the item has local modifications.
+func (item *Item) upToDate(o fs.Object) bool {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.info.Dirty {
+		return true
+	}
+	if item.info.Fingerprint == "" || !item._exists() || !item._present() {
+		return false
+	}
+	return item.info.Fingerprint == fs.Fingerprint(item.c.ctx, o, item.c.opt.FastFingerprint)
+}
+
 // _present returns true if the whole file has been downloaded
 //
 // call with the lock held
@@ -1216,6 +1452,9 @@ func (item *Item) _ensure(offset, size int64) (err error) {
 		return errors.New("no space left on device")
 	} */
 	fs.Debugf(nil, "vfs cache: looking for range=%+v in %+v - present %v", r, item.info.Rs, present)
+	if !present && item.c.offline.Load() {
+		return ErrOffline
+	}
 	item.mu.Unlock()
 	defer item.mu.Lock()
 	if present {
@@ -1241,11 +1480,24 @@ func (item *Item) _ensure(offset, size int64) (err error) {
 			}
 			item.o = o
 		}
-		item.downloaders = downloaders.New(item.c.ctx, item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item._downloadCtx(), item, item.c.opt, item.name, item.o)
 	}
 	return item.downloaders.Download(r)
 }
 
+// _downloadCtx returns the context for new downloaders
+//
+// This is the cache context unless Fetch has set one with a
+// bandwidth limit.
+//
+// call with mu held
+func (item *Item) _downloadCtx() context.Context {
+	if item.fetchCtx != nil {
+		return item.fetchCtx
+	}
+	return item.c.ctx
+}
+
 // _written marks the (offset, size) as present in the backing file
 //
 // This is called by the downloader downloading file segments and the
@@ -1369,6 +1621,9 @@ func (item *Item) readAt(b []byte, off int64) (n int, err error) {
 	}
 
 	item.info.ATime = time.Now()
+	if item.c.opt.CacheEvictRanges {
+		item._markRead(off, int64(len(b)))
+	}
 	// Do the reading with Item.mu unlocked and cache protected by preAccess
 	n, err = item.fd.ReadAt(b, off)
 	return n, err
@@ -8,6 +8,7 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -699,3 +700,85 @@ func TestItemHandleCachingReset(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, RemovedNotInUse, rr)
 }
+
+func TestItemOffline(t *testing.T) {
+	r, c := newItemTestCache(t)
+	ctx := context.Background()
+
+	// Read two files into the cache
+	contents, obj, item := newFile(t, r, c, "existing")
+	contents2, obj2, item2 := newFile(t, r, c, "changed")
+	buf := make([]byte, 100)
+	for _, x := range []struct {
+		item *Item
+		obj  fs.Object
+	}{{item, obj}, {item2, obj2}} {
+		require.NoError(t, x.item.Open(x.obj))
+		_, err := x.item.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.NoError(t, x.item.Close(nil))
+	}
+	_, obj3, item3 := newFile(t, r, c, "uncached")
+
+	c.SetOffline(true)
+	assert.True(t, c.IsOffline())
+
+	// Data which isn't cached can't be read
+	require.NoError(t, item3.Open(obj3))
+	_, err := item3.ReadAt(buf, 0)
+	assert.ErrorIs(t, err, ErrOffline)
+	require.NoError(t, item3.Close(nil))
+
+	// Modifications are queued but not uploaded
+	for _, x := range []struct {
+		item *Item
+		obj  fs.Object
+	}{{item, obj}, {item2, obj2}} {
+		require.NoError(t, x.item.Open(x.obj))
+		_, err = x.item.WriteAt([]byte("HELLO"), 0)
+		require.NoError(t, err)
+		require.NoError(t, x.item.Close(nil))
+		assert.True(t, x.item.info.Offline)
+	}
+	newItem, _ := c.get("new")
+	require.NoError(t, newItem.Open(nil))
+	_, err = newItem.WriteAt([]byte("new file"), 0)
+	require.NoError(t, err)
+	require.NoError(t, newItem.Close(nil))
+	checkObject(t, r, "existing", contents)
+	_, uploadsQueued := c.writeback.Stats()
+	assert.Equal(t, 3, uploadsQueued)
+
+	// Change a file on the remote while offline
+	r.WriteObject(ctx, "changed", "changed on the remote", time.Now().Add(time.Minute))
+
+	// Going online uploads everything, saving the conflict
+	c.SetOffline(false)
+	require.Eventually(t, func() bool {
+		uploadsInProgress, uploadsQueued := c.writeback.Stats()
+		return uploadsInProgress == 0 && uploadsQueued == 0
+	}, 10*time.Second, 10*time.Millisecond)
+	checkObject(t, r, "existing", "HELLO"+contents[5:])
+	checkObject(t, r, "new", "new file")
+	checkObject(t, r, "changed", "changed on the remote")
+	entries, err := r.Fremote.List(ctx, "")
+	require.NoError(t, err)
+	var conflicts []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Remote(), "changed.conflict-") {
+			conflicts = append(conflicts, entry.Remote())
+		}
+	}
+	require.Equal(t, 1, len(conflicts))
+	checkObject(t, r, conflicts[0], "HELLO"+contents2[5:])
+	assert.False(t, item.info.Offline)
+	assert.False(t, item2.info.Dirty)
+
+	// The cached copy of the changed file is replaced when opened
+	obj2, err = r.Fremote.NewObject(ctx, "changed")
+	require.NoError(t, err)
+	require.NoError(t, item2.Open(obj2))
+	n, _ := item2.ReadAt(buf, 0)
+	assert.Equal(t, "changed on the remote", string(buf[:n]))
+	require.NoError(t, item2.Close(nil))
+}
@@ -0,0 +1,196 @@
+package vfscache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Pinned files and directories are kept fully downloaded in the
+// cache and are never removed by the cache cleaner.
+//
+// The pins are stored as a JSON list of paths in a file outside the
+// data and metadata cache roots so they can't clash with cached
+// files.
+
+// ErrNotPinned is returned by Unpin if the item wasn't pinned
+var ErrNotPinned = errors.New("not pinned")
+
+// loadPins reads the pins from disk
+//
+// It is called before the cache has started so no locking is needed.
+func (c *Cache) loadPins() error {
+	c.pins = make(map[string]struct{})
+	data, err := os.ReadFile(c.pinsPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read pins: %w", err)
+	}
+	var pins []string
+	err = json.Unmarshal(data, &pins)
+	if err != nil {
+		return fmt.Errorf("failed to decode pins: %w", err)
+	}
+	for _, name := range pins {
+		c.pins[clean(name)] = struct{}{}
+	}
+	return nil
+}
+
+// _pinned returns the sorted list of pins
+//
+// call with mu held
+func (c *Cache) _pinned() []string {
+	pins := make([]string, 0, len(c.pins))
+	for name := range c.pins {
+		pins = append(pins, name)
+	}
+	slices.Sort(pins)
+	return pins
+}
+
+// _savePins writes the pins to disk
+//
+// call with mu held
+func (c *Cache) _savePins() error {
+	if len(c.pins) == 0 {
+		err := os.Remove(c.pinsPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove pins: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(c._pinned(), "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode pins: %w", err)
+	}
+	err = createDir(filepath.Dir(c.pinsPath))
+	if err != nil {
+		return fmt.Errorf("failed to create pins directory: %w", err)
+	}
+	err = os.WriteFile(c.pinsPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write pins: %w", err)
+	}
+	return nil
+}
+
+// _isPinned returns true if name or any of its parent directories is
+// pinned
+//
+// call with mu held
+func (c *Cache) _isPinned(name string) bool {
+	if len(c.pins) == 0 {
+		return false
+	}
+	for {
+		if _, found := c.pins[name]; found {
+			return true
+		}
+		if name == "" {
+			return false
+		}
+		i := strings.LastIndexByte(name, '/')
+		if i < 0 {
+			name = ""
+		} else {
+			name = name[:i]
+		}
+	}
+}
+
+// IsPinned returns true if name or any of its parent directories is
+// pinned
+func (c *Cache) IsPinned(name string) bool {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c._isPinned(name)
+}
+
+// Pin marks name, which may be a file or a directory, to be kept in
+// the cache.
+//
+// This doesn't download anything - call Fetch for each file to do that.
+func (c *Cache) Pin(name string) error {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.pins[name]; found {
+		return nil
+	}
+	c.pins[name] = struct{}{}
+	fs.Infof(name, "vfs cache: pinned")
+	return c._savePins()
+}
+
+// Unpin removes the pin on name so it can be removed from the cache
+// by the cache cleaner as normal.
+//
+// It returns an error if name wasn't pinned.
+func (c *Cache) Unpin(name string) error {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.pins[name]; !found {
+		if c._isPinned(name) {
+			return fmt.Errorf("%q is pinned by a parent directory", name)
+		}
+		return fmt.Errorf("%q is %w", name, ErrNotPinned)
+	}
+	delete(c.pins, name)
+	fs.Infof(name, "vfs cache: unpinned")
+	return c._savePins()
+}
+
+// Pinned returns the sorted list of pinned files and directories
+func (c *Cache) Pinned() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c._pinned()
+}
+
+// _renamePins moves any pins on oldName or inside it to newName
+//
+// call with mu held
+func (c *Cache) _renamePins(oldName, newName string) {
+	var renames []string
+	for name := range c.pins {
+		if name == oldName || strings.HasPrefix(name, oldName+"/") {
+			renames = append(renames, name)
+		}
+	}
+	for _, name := range renames {
+		delete(c.pins, name)
+	}
+	for _, name := range renames {
+		c.pins[newName+name[len(oldName):]] = struct{}{}
+	}
+	if len(renames) > 0 {
+		err := c._savePins()
+		if err != nil {
+			fs.Errorf(oldName, "vfs cache: failed to rename pins: %v", err)
+		}
+	}
+}
+
+// _removePin removes the pin on name if it has one
+//
+// call with mu held
+func (c *Cache) _removePin(name string) {
+	if _, found := c.pins[name]; !found {
+		return
+	}
+	delete(c.pins, name)
+	err := c._savePins()
+	if err != nil {
+		fs.Errorf(name, "vfs cache: failed to remove pin: %v", err)
+	}
+}
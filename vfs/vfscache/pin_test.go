@@ -0,0 +1,121 @@
+package vfscache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachePin(t *testing.T) {
+	r, c := newTestCache(t)
+
+	assert.Equal(t, []string{}, c.Pinned())
+	assert.False(t, c.IsPinned("dir/file"))
+
+	require.NoError(t, c.Pin("dir/"))
+	require.NoError(t, c.Pin("other/file"))
+	require.NoError(t, c.Pin("dir"))
+	assert.Equal(t, []string{"dir", "other/file"}, c.Pinned())
+	assertPathExist(t, c.pinsPath)
+
+	assert.True(t, c.IsPinned("dir"))
+	assert.True(t, c.IsPinned("dir/file"))
+	assert.True(t, c.IsPinned("dir/sub/file"))
+	assert.True(t, c.IsPinned("other/file"))
+	assert.False(t, c.IsPinned("directory"))
+	assert.False(t, c.IsPinned("other"))
+	assert.False(t, c.IsPinned("other/file2"))
+
+	// Can't unpin something pinned by its parent
+	assert.ErrorContains(t, c.Unpin("dir/file"), "pinned by a parent")
+	assert.ErrorIs(t, c.Unpin("potato"), ErrNotPinned)
+
+	// Pins persist when the cache is restarted
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c2, err := New(ctx, r.Fremote, &opt, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir", "other/file"}, c2.Pinned())
+
+	// Renames and removes update the pins
+	require.NoError(t, c.DirRename("dir", "newdir"))
+	require.NoError(t, c.Rename("other/file", "other/file2", nil))
+	assert.Equal(t, []string{"newdir", "other/file2"}, c.Pinned())
+	c.Remove("other/file2")
+	assert.Equal(t, []string{"newdir"}, c.Pinned())
+
+	require.NoError(t, c.Unpin("newdir"))
+	assert.Equal(t, []string{}, c.Pinned())
+	assertPathNotExist(t, c.pinsPath)
+}
+
+func TestCachePinRename(t *testing.T) {
+	_, c := newTestCache(t)
+
+	// Renaming into a subdirectory makes new names which match
+	// the old name so check they are only renamed once
+	c.mu.Lock()
+	for i := range 100 {
+		c.pins[fmt.Sprintf("dir/file%d", i)] = struct{}{}
+	}
+	c.pins["dir2/file"] = struct{}{}
+	c._renamePins("dir", "dir/sub")
+	c.mu.Unlock()
+
+	want := []string{"dir2/file"}
+	for i := range 100 {
+		want = append(want, fmt.Sprintf("dir/sub/file%d", i))
+	}
+	sort.Strings(want)
+	assert.Equal(t, want, c.Pinned())
+}
+
+func TestCachePinPurge(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.HandleCaching = 0
+	opt.CacheMaxSize = 1
+	_, c := newTestCacheOpt(t, opt)
+
+	for _, name := range []string{"pinned/potato", "potato"} {
+		item := c.Item(name)
+		itemWrite(t, item, "hello")
+		require.NoError(t, item.Close(nil))
+		// Mark clean so the item can be purged
+		item.mu.Lock()
+		item.info.Dirty = false
+		item.mu.Unlock()
+	}
+	require.NoError(t, c.Pin("pinned"))
+
+	c.purgeOld(-10 * time.Second)
+	assert.Equal(t, []string{
+		`name="pinned/potato" opens=0 size=5`,
+	}, itemAsString(c))
+
+	item := c.Item("potato")
+	itemWrite(t, item, "hello")
+	require.NoError(t, item.Close(nil))
+	item.mu.Lock()
+	item.info.Dirty = false
+	item.mu.Unlock()
+
+	c.purgeOverQuota()
+	c.purgeClean()
+	assert.Equal(t, []string{
+		`name="pinned/potato" opens=0 size=5`,
+	}, itemAsString(c))
+
+	require.NoError(t, c.Unpin("pinned"))
+	c.purgeOverQuota()
+	assert.Equal(t, []string(nil), itemAsString(c))
+}
@@ -38,6 +38,7 @@ type WriteBack struct {
 	timer   *time.Timer               // next scheduled time for the uploader
 	expiry  time.Time                 // time the next item expires or IsZero
 	uploads int                       // number of uploads in progress
+	paused  bool                      // if set don't start any uploads
 }
 
 // New make a new WriteBack
@@ -220,7 +221,7 @@ func (wb *WriteBack) _stopTimer() {
 // reset the timer which runs the expiries
 func (wb *WriteBack) _resetTimer() {
 	wbItem := wb._peekItem()
-	if wbItem == nil {
+	if wbItem == nil || wb.paused {
 		wb._stopTimer()
 	} else {
 		if wb.expiry.Equal(wbItem.expiry) {
@@ -428,7 +429,7 @@ func (wb *WriteBack) processItems(ctx context.Context) {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	if wb.ctx.Err() != nil {
+	if wb.ctx.Err() != nil || wb.paused {
 		return
 	}
 
@@ -458,6 +459,17 @@ func (wb *WriteBack) processItems(ctx context.Context) {
 	}
 }
 
+// SetPaused stops new uploads from starting if paused is set.
+//
+// Items can still be added while paused and are uploaded as normal
+// once unpaused. Uploads in progress are not affected.
+func (wb *WriteBack) SetPaused(paused bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.paused = paused
+	wb._resetTimer()
+}
+
 // Stats return the number of uploads in progress and queued
 func (wb *WriteBack) Stats() (uploadsInProgress, uploadsQueued int) {
 	wb.mu.Lock()
@@ -315,6 +315,35 @@ func TestWriteBackAddOK(t *testing.T) {
 	checkNotInLookup(t, wb, wbItem)
 }
 
+// Test pausing stops uploads starting until unpaused
+func TestWriteBackPaused(t *testing.T) {
+	wb, cancel := newTestWriteBack(t)
+	defer cancel()
+
+	wb.SetPaused(true)
+	pi := newPutItem(t)
+	var inID Handle
+	wb.SetID(&inID)
+	id := wb.Add(inID, "one", 10, true, pi.put)
+	wbItem := wb.lookup[id]
+	assertTimerRunning(t, wb, false)
+
+	// Check the upload doesn't start even if the item has expired
+	wb.processItems(wb.ctx)
+	select {
+	case <-pi.started:
+		t.Fatal("upload started while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	checkOnHeap(t, wb, wbItem)
+
+	wb.SetPaused(false)
+	<-pi.started
+	pi.finish(nil)
+	waitUntilNoTransfers(t, wb)
+	checkNotInLookup(t, wb, wbItem)
+}
+
 // Now test the upload failing and being retried
 func TestWriteBackAddFailRetry(t *testing.T) {
 	wb, cancel := newTestWriteBack(t)
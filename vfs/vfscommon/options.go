@@ -35,6 +35,11 @@ var OptionsInfo = fs.Options{{
 	Default: false,
 	Help:    "Refreshes the directory cache recursively in the background on start",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_dir_cache_persist",
+	Default: false,
+	Help:    "Save the directory cache to disk so it survives restarts",
+	Groups:  "VFS",
 }, {
 	Name:    "poll_interval",
 	Default: fs.Duration(time.Minute),
@@ -75,6 +80,11 @@ var OptionsInfo = fs.Options{{
 	Default: fs.SizeSuffix(-1),
 	Help:    "Target minimum free space on the disk containing the cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_cache_evict_ranges",
+	Default: false,
+	Help:    "Evict the least recently read parts of files from the cache instead of whole files",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_read_chunk_size",
 	Default: 128 * fs.Mebi,
@@ -175,6 +185,26 @@ var OptionsInfo = fs.Options{{
 	Default: "",
 	Help:    "Set the extension to read metadata from.",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_prefetch",
+	Default: "",
+	Help:    "Directory to download into the cache in the background on start (use / for everything)",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_prefetch_transfers",
+	Default: 4,
+	Help:    "Number of files to prefetch in parallel",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_prefetch_bwlimit",
+	Default: fs.SizeSuffix(0),
+	Help:    "Bandwidth limit in bytes/s for prefetching (0 for no limit)",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_offline",
+	Default: false,
+	Help:    "Start offline, serving only what is cached and queuing changes for later",
+	Groups:  "VFS",
 }}
 
 func init() {
@@ -183,13 +213,14 @@ func init() {
 
 // Options is options for creating the vfs
 type Options struct {
-	NoSeek             bool          `config:"no_seek"`        // don't allow seeking if set
-	NoChecksum         bool          `config:"no_checksum"`    // don't check checksums if set
-	ReadOnly           bool          `config:"read_only"`      // if set VFS is read only
-	Links              bool          `config:"vfs_links"`      // if set interpret link files
-	NoModTime          bool          `config:"no_modtime"`     // don't read mod times for files
-	DirCacheTime       fs.Duration   `config:"dir_cache_time"` // how long to consider directory listing cache valid
-	Refresh            bool          `config:"vfs_refresh"`    // refreshes the directory listing recursively on start
+	NoSeek             bool          `config:"no_seek"`               // don't allow seeking if set
+	NoChecksum         bool          `config:"no_checksum"`           // don't check checksums if set
+	ReadOnly           bool          `config:"read_only"`             // if set VFS is read only
+	Links              bool          `config:"vfs_links"`             // if set interpret link files
+	NoModTime          bool          `config:"no_modtime"`            // don't read mod times for files
+	DirCacheTime       fs.Duration   `config:"dir_cache_time"`        // how long to consider directory listing cache valid
+	Refresh            bool          `config:"vfs_refresh"`           // refreshes the directory listing recursively on start
+	DirCachePersist    bool          `config:"vfs_dir_cache_persist"` // save the directory cache to disk
 	PollInterval       fs.Duration   `config:"poll_interval"`
 	Umask              FileMode      `config:"umask"`
 	UID                uint32        `config:"uid"`
@@ -204,6 +235,7 @@ type Options struct {
 	CacheMaxAge        fs.Duration   `config:"vfs_cache_max_age"`
 	CacheMaxSize       fs.SizeSuffix `config:"vfs_cache_max_size"`
 	CacheMinFreeSpace  fs.SizeSuffix `config:"vfs_cache_min_free_space"`
+	CacheEvictRanges   bool          `config:"vfs_cache_evict_ranges"`
 	CachePollInterval  fs.Duration   `config:"vfs_cache_poll_interval"`
 	CaseInsensitive    bool          `config:"vfs_case_insensitive"`
 	BlockNormDupes     bool          `config:"vfs_block_norm_dupes"`
@@ -216,6 +248,10 @@ type Options struct {
 	DiskSpaceTotalSize fs.SizeSuffix `config:"vfs_disk_space_total_size"`
 	HandleCaching      fs.Duration   `config:"vfs_handle_caching"`     // time to keep handle alive after last close
 	MetadataExtension  string        `config:"vfs_metadata_extension"` // if set respond to files with this extension with metadata
+	Prefetch           string        `config:"vfs_prefetch"`           // directory to prefetch into the cache on start
+	PrefetchTransfers  int           `config:"vfs_prefetch_transfers"` // number of files to prefetch at once
+	PrefetchBwLimit    fs.SizeSuffix `config:"vfs_prefetch_bwlimit"`   // bandwidth limit for prefetching
+	Offline            bool          `config:"vfs_offline"`            // start in offline mode
 }
 
 // Opt is the default options modified by the environment variables and command line flags
@@ -3,6 +3,8 @@ package vfscommon
 import (
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // OSFindParent returns the parent directory of name, or "" for the
@@ -24,3 +26,16 @@ func FindParent(name string) string {
 	}
 	return parent
 }
+
+// ConflictName returns the name to save a conflicting copy of name
+// under. This is made by adding ".conflict-" and the time t before
+// the extension, eg "dir/file.conflict-2006-01-02-150405.txt".
+func ConflictName(name string, t time.Time) string {
+	ext := path.Ext(name)
+	base := name[:len(name)-len(ext)]
+	if base == "" || strings.HasSuffix(base, "/") {
+		// eg ".bashrc" has no extension
+		base, ext = name, ""
+	}
+	return base + ".conflict-" + t.Format("2006-01-02-150405") + ext
+}
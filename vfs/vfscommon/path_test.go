@@ -0,0 +1,24 @@
+package vfscommon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictName(t *testing.T) {
+	when := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"file.txt", "file.conflict-2001-02-03-040506.txt"},
+		{"dir/file.tar.gz", "dir/file.tar.conflict-2001-02-03-040506.gz"},
+		{"dir.d/file", "dir.d/file.conflict-2001-02-03-040506"},
+		{".bashrc", ".bashrc.conflict-2001-02-03-040506"},
+		{"dir/.bashrc", "dir/.bashrc.conflict-2001-02-03-040506"},
+	} {
+		assert.Equal(t, test.want, ConflictName(test.in, when), test.in)
+	}
+}